@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jrockway/nodedns/pkg/dns"
+	"github.com/jrockway/nodedns/pkg/handoff"
+	"github.com/jrockway/nodedns/pkg/k8s"
+	"github.com/jrockway/nodedns/pkg/nodedns"
+	"go.uber.org/zap"
+)
+
+// recordSet is every record that this instance publishes, for handing off, cleaning up at
+// shutdown (see --cleanup_on_shutdown), and collecting orphans (see --gc_orphans); and whether
+// it may delete them.
+type recordSet struct {
+	main       *nodedns.Records  // The records configured with flags, or nil if they aren't published.
+	zone       string            // --zone, which the records named after nodes and Services are in.
+	publishers []*storePublisher // The records of the stores and rules in the config file, and the agent.
+
+	// The agent's publisher publishes the records named by agentTemplates, with {node} replaced,
+	// in the same order; nodeNames lists the nodes that still exist, whose records are kept.
+	agent          *storePublisher
+	agentTemplates []string
+	nodeNames      func(ctx context.Context) ([]string, error)
+
+	// The sinks that publish records whose names depend on the nodes or Services, if running.
+	perNode   *k8s.PerNode
+	topology  *k8s.Topology
+	services  *k8s.ServiceRecords
+	nodePorts *k8s.NodePortSRV
+	crds      *crdRecords
+
+	paused                    func() bool
+	dryRun, audit, createOnly bool
+	gate                      *handoff.Gate
+}
+
+// export returns the records that this instance publishes, for handing off.
+func (s *recordSet) export(ctx context.Context) ([]*dns.Snapshot, error) {
+	var result []*dns.Snapshot
+	if s.main != nil {
+		// Records with providers of their own are exported separately.
+		providers, names := s.main.ByProvider()
+		for _, p := range providers {
+			snap, err := exportProvider(ctx, p, names[p])
+			if err != nil {
+				return nil, fmt.Errorf("export main records: %w", err)
+			}
+			result = append(result, snap)
+		}
+	}
+	for _, p := range s.publishers {
+		for _, r := range p.current() {
+			snap, err := exportProvider(ctx, r.client, []string{r.name})
+			if err != nil {
+				return nil, fmt.Errorf("export %s record %s: %w", p.name, r.name, err)
+			}
+			result = append(result, snap)
+		}
+	}
+	return result, nil
+}
+
+// configured returns true if a flag or config record publishes the fully-qualified name, so that
+// it isn't seeded into perNode or topology.
+func (s *recordSet) configured(name string) bool {
+	if s.main != nil && s.main.Publishes(name) {
+		return true
+	}
+	for _, p := range s.publishers {
+		for _, r := range p.current() {
+			if strings.EqualFold(r.client.FQDN(r.name), name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deletes returns true if records may be deleted now, or logs why not.  Deleting records is
+// deliberate, so the caller holds the gate until it returns, and the deletion safety thresholds
+// don't apply.
+func (s *recordSet) deletes(l *zap.Logger, what string) bool {
+	if s.paused() || s.dryRun || s.audit || s.createOnly {
+		l.Info("not deleting " + what + "; updates are paused, or this is a dry run, audit, or create-only")
+		return false
+	}
+	if !s.gate.Enter() {
+		l.Info("not deleting " + what + "; this instance isn't writing records")
+		return false
+	}
+	return true
+}
+
+// cleanup empties every record that this instance publishes, at shutdown.  With the ownership
+// registry, records that another owner marked are left alone.  A standby replica only empties
+// the agent's records; the leader maintains the aggregate records, and keeps them when a
+// follower, like another node's agent, shuts down.
+func (s *recordSet) cleanup(ctx context.Context, standby bool) {
+	l := zap.L().Named("cleanup")
+	if !s.deletes(l, "records at shutdown") {
+		return
+	}
+	defer s.gate.Exit()
+	empty := func(p dns.Provider, name string) {
+		if g, ok := p.(*dns.Guard); ok {
+			p = g.Provider
+		}
+		if err := p.UpdateDNS(ctx, name, nil); err != nil {
+			l.Error("problem deleting record at shutdown", zap.String("record", p.FQDN(name)), zap.Error(err))
+			return
+		}
+		l.Info("deleted record at shutdown", zap.String("record", p.FQDN(name)))
+	}
+	if standby {
+		if s.agent != nil {
+			for _, r := range s.agent.current() {
+				empty(r.client, r.name)
+			}
+		}
+		return
+	}
+	if s.main != nil {
+		providers, names := s.main.ByProvider()
+		for _, p := range providers {
+			for _, name := range names[p] {
+				empty(p, name)
+			}
+		}
+	}
+	for _, p := range s.publishers {
+		for _, r := range p.current() {
+			empty(r.client, r.name)
+		}
+	}
+	if s.services != nil {
+		for _, name := range s.services.Published() {
+			empty(s.main.Default, dns.RelativeName(s.zone, name))
+		}
+	}
+	if s.nodePorts != nil {
+		p := s.main.Default
+		if g, ok := p.(*dns.Guard); ok {
+			p = g.Provider
+		}
+		if srvClient, ok := p.(dns.SRVUpdater); ok {
+			for _, name := range s.nodePorts.Published() {
+				if err := srvClient.UpdateSRV(ctx, dns.RelativeName(s.zone, name), nil); err != nil {
+					l.Error("problem deleting srv record at shutdown", zap.String("record", name), zap.Error(err))
+					continue
+				}
+				l.Info("deleted srv record at shutdown", zap.String("record", name))
+			}
+		}
+	}
+}
+
+// orphansKnown returns true once the records that aren't published are known to be orphans:
+// every store has published the records of a full list of its nodes, and the Services and
+// NodeDNSRecords have been listed.  Until then, the records of nodes, Services, and NodeDNSRecords
+// that haven't been seen yet would be deleted.
+func (s *recordSet) orphansKnown(stores storeSet) bool {
+	if !stores.Synced() {
+		return false
+	}
+	if s.services != nil && !s.services.Synced() {
+		return false
+	}
+	if s.crds != nil {
+		if _, ok := s.crds.current(); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// collectOrphans deletes the records that this instance owns, but that no record, config rule,
+// node, or Service publishes any more.  Owned records are listed per zone, so every published
+// name is kept, whichever provider publishes it.
+func (s *recordSet) collectOrphans(ctx context.Context) {
+	l := zap.L().Named("orphans")
+	if !s.deletes(l, "orphaned records") {
+		return
+	}
+	defer s.gate.Exit()
+	keep := make(map[string]bool)
+	var collectors []dns.Provider
+	zones := make(map[string]bool)
+	collect := func(p dns.Provider) {
+		if g, ok := p.(*dns.Guard); ok {
+			p = g.Provider
+		}
+		if zone := p.FQDN("@"); !zones[zone] {
+			zones[zone] = true
+			collectors = append(collectors, p)
+		}
+	}
+	publish := func(p dns.Provider, name string) {
+		keep[strings.ToLower(p.FQDN(name))] = true
+		collect(p)
+	}
+	if s.main != nil {
+		collect(s.main.Default)
+		providers, names := s.main.ByProvider()
+		for _, p := range providers {
+			for _, name := range names[p] {
+				publish(p, name)
+			}
+		}
+		var dynamic []string
+		if s.perNode != nil {
+			dynamic = append(dynamic, s.perNode.Published()...)
+		}
+		if s.topology != nil {
+			dynamic = append(dynamic, s.topology.Published()...)
+		}
+		if s.services != nil {
+			dynamic = append(dynamic, s.services.Published()...)
+		}
+		for _, name := range dynamic {
+			publish(s.main.Default, dns.RelativeName(s.zone, name))
+		}
+	}
+	for _, p := range s.publishers {
+		for _, r := range p.current() {
+			publish(r.client, r.name)
+		}
+	}
+	if s.crds != nil {
+		records, _ := s.crds.current()
+		for _, r := range records {
+			publish(r.client, r.name)
+		}
+	}
+	if s.agent != nil {
+		// Each node's agent publishes its own records, so the records of every node that still
+		// exists are kept, and those of deleted nodes are collected.
+		nodes, err := s.nodeNames(ctx)
+		if err != nil {
+			l.Error("problem listing nodes; not deleting orphaned records", zap.Error(err))
+			return
+		}
+		for i, r := range s.agent.current() {
+			for _, node := range nodes {
+				publish(r.client, strings.ReplaceAll(s.agentTemplates[i], "{node}", node))
+			}
+		}
+	}
+	for _, p := range collectors {
+		c, ok := p.(dns.OrphanCollector)
+		if !ok {
+			continue
+		}
+		deleted, err := c.DeleteOrphans(ctx, func(name string) bool { return keep[name] })
+		if err != nil {
+			l.Error("problem deleting orphaned records", zap.String("zone", p.FQDN("@")), zap.Error(err))
+		}
+		if len(deleted) > 0 {
+			l.Info("deleted orphaned records", zap.String("zone", p.FQDN("@")), zap.Strings("records", deleted))
+		}
+	}
+}
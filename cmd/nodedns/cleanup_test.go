@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/dns"
+	"github.com/jrockway/nodedns/pkg/fakedo"
+	"github.com/jrockway/nodedns/pkg/handoff"
+	"github.com/jrockway/nodedns/pkg/k8s"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// testRecordSet publishes "nodes" and "internal" with flags, and "ingress" and the agent's
+// "host-1.agent" from their own stores, to DigitalOcean with an ownership registry.  "internal"
+// is already marked with another owner, and "by-hand" isn't marked.
+func testRecordSet(t *testing.T, s *fakedo.Server, nd *nodednsflags) *recordSet {
+	t.Helper()
+	ctx := context.Background()
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "internal", Data: "10.0.0.9"})
+	s.AddRecord("example.com", godo.DomainRecord{Type: "TXT", Name: "internal", Data: dns.OwnerMarker("other")})
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "by-hand", Data: "10.0.0.8"})
+
+	f := testFactory(t, nd)
+	f.godo = func(zone string) *godo.Client { return s.Client() }
+	// Publish with a writable configuration; the test's flags apply to the cleanup.
+	writer := *nd
+	writer.IsDryRun, writer.Audit, writer.CreateOnly = false, false, false
+	wf := *f
+	wf.nd = &writer
+	records, err := newMainRecords(ctx, &wf, &dns.Config{Zone: "example.com", TTL: time.Minute}, &writer)
+	if err != nil {
+		t.Fatalf("newMainRecords: %v", err)
+	}
+	if err := records.Update(ctx, k8s.External, []net.IP{net.ParseIP("1.2.3.4")}); err != nil {
+		t.Fatalf("update external: %v", err)
+	}
+	publisher := func(name, record string) *storePublisher {
+		client, err := wf.newProvider(ctx, "digitalocean", dns.ProviderOptions{Zone: "example.com", TTL: time.Minute})
+		if err != nil {
+			t.Fatalf("new provider: %v", err)
+		}
+		if err := client.UpdateDNS(ctx, record, []net.IP{net.ParseIP("10.0.0.1")}); err != nil {
+			t.Fatalf("update %s: %v", record, err)
+		}
+		p := &storePublisher{name: name}
+		p.setRecords([]publishedRecord{{kind: k8s.Internal, name: record, client: client}})
+		return p
+	}
+	ingress, agent := publisher("ingress", "ingress"), publisher("agent", "host-1.agent")
+	return &recordSet{
+		main:       records,
+		zone:       "example.com",
+		publishers: []*storePublisher{ingress, agent},
+		agent:      agent,
+		paused:     func() bool { return false },
+		dryRun:     nd.IsDryRun,
+		audit:      nd.Audit,
+		createOnly: nd.CreateOnly,
+		gate:       handoff.NewGate(true),
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	published := map[string][]string{
+		"nodes":        {"1.2.3.4"},
+		"internal":     {"10.0.0.9"},
+		"by-hand":      {"10.0.0.8"},
+		"ingress":      {"10.0.0.1"},
+		"host-1.agent": {"10.0.0.1"},
+	}
+	testData := []struct {
+		name    string
+		nd      nodednsflags
+		standby bool
+		closed  bool // Whether the gate is closed, like while another instance is writing.
+		want    map[string][]string
+	}{
+		{
+			name: "cleanup",
+			// Every owned record is emptied, though the guard refuses to empty records by default.
+			want: map[string][]string{
+				// Another owner's record, and records without an owner, are left alone.
+				"internal": {"10.0.0.9"},
+				"by-hand":  {"10.0.0.8"},
+			},
+		},
+		{
+			name:    "standby",
+			standby: true,
+			want: map[string][]string{
+				"nodes":    {"1.2.3.4"},
+				"internal": {"10.0.0.9"},
+				"by-hand":  {"10.0.0.8"},
+				"ingress":  {"10.0.0.1"},
+			},
+		},
+		{
+			name: "dry run",
+			nd:   nodednsflags{IsDryRun: true},
+			want: published,
+		},
+		{
+			name: "audit",
+			nd:   nodednsflags{Audit: true},
+			want: published,
+		},
+		{
+			name: "create only",
+			nd:   nodednsflags{CreateOnly: true},
+			want: published,
+		},
+		{
+			name:   "handed off",
+			closed: true,
+			want:   published,
+		},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			zap.ReplaceGlobals(zaptest.NewLogger(t))
+			s := fakedo.New("example.com")
+			defer s.Close()
+			nd := test.nd
+			nd.DNSProvider, nd.TXTOwnerID = "digitalocean", "main"
+			nd.Internal, nd.External = "internal", "nodes"
+			rs := testRecordSet(t, s, &nd)
+			if diff := cmp.Diff(s.Addresses("example.com"), published); diff != "" {
+				t.Fatalf("published addresses:\n%s", diff)
+			}
+			if test.closed {
+				rs.gate.Close()
+			}
+			rs.cleanup(context.Background(), test.standby)
+			if diff := cmp.Diff(s.Addresses("example.com"), test.want); diff != "" {
+				t.Errorf("addresses after cleanup:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCollectOrphans(t *testing.T) {
+	testData := []struct {
+		name string
+		nd   nodednsflags
+		want map[string][]string
+	}{
+		{
+			name: "collect",
+			want: map[string][]string{
+				"nodes":        {"1.2.3.4"},
+				"internal":     {"10.0.0.9"},
+				"by-hand":      {"10.0.0.8"},
+				"ingress":      {"10.0.0.1"},
+				"host-1.agent": {"10.0.0.1"},
+			},
+		},
+		{
+			name: "dry run",
+			nd:   nodednsflags{IsDryRun: true},
+			want: map[string][]string{
+				"nodes":        {"1.2.3.4"},
+				"internal":     {"10.0.0.9"},
+				"by-hand":      {"10.0.0.8"},
+				"ingress":      {"10.0.0.1"},
+				"host-1.agent": {"10.0.0.1"},
+				"old-nodes":    {"1.2.3.5"},
+				"host-2.agent": {"10.0.0.2"},
+			},
+		},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			zap.ReplaceGlobals(zaptest.NewLogger(t))
+			s := fakedo.New("example.com")
+			defer s.Close()
+			// A record that a renamed flag left behind, and the agent record of a deleted node.
+			for name, ip := range map[string]string{"old-nodes": "1.2.3.5", "host-2.agent": "10.0.0.2"} {
+				s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: name, Data: ip})
+				s.AddRecord("example.com", godo.DomainRecord{Type: "TXT", Name: name, Data: dns.OwnerMarker("main")})
+			}
+			nd := test.nd
+			nd.DNSProvider, nd.TXTOwnerID = "digitalocean", "main"
+			nd.Internal, nd.External = "internal", "nodes"
+			rs := testRecordSet(t, s, &nd)
+			rs.agentTemplates = []string{"{node}.agent"}
+			rs.nodeNames = func(ctx context.Context) ([]string, error) { return []string{"host-1"}, nil }
+
+			// Orphans are only known once every store has listed all of its nodes; a node
+			// event for one node isn't enough.
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "host-1"},
+				Status:     v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "1.2.3.4"}}},
+			}
+			st, agent := k8s.NewNodeStore("main"), k8s.NewNodeStore("agent")
+			stores := storeSet{st, agent}
+			west := st.Cluster("west")
+			st.Add(node) // nolint:errcheck
+			if rs.orphansKnown(stores) {
+				t.Error("orphans known after a node event")
+			}
+			st.Replace([]interface{}{node}, "")    // nolint:errcheck
+			agent.Replace([]interface{}{node}, "") // nolint:errcheck
+			if rs.orphansKnown(stores) {
+				t.Error("orphans known before every cluster was listed")
+			}
+			west.Replace(nil, "") // nolint:errcheck
+			if !rs.orphansKnown(stores) {
+				t.Fatal("orphans not known after every store was synced")
+			}
+
+			rs.collectOrphans(context.Background())
+			if diff := cmp.Diff(s.Addresses("example.com"), test.want); diff != "" {
+				t.Errorf("addresses after collecting orphans:\n%s", diff)
+			}
+		})
+	}
+}
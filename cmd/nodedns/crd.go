@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/config"
+	"github.com/jrockway/nodedns/pkg/handoff"
+	"github.com/jrockway/nodedns/pkg/k8s"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// crdRecords runs a store for each NodeDNSRecord, and writes the result of each update of its
+// record to its status.
+type crdRecords struct {
+	client     *k8s.NodeDNSRecordClient
+	newStore   func(name string, rules []config.Rule) (*k8s.NodeStore, *storePublisher, error)
+	startWatch func(watchedStore) context.CancelFunc
+	gate       *handoff.Gate // Status is only written while the gate is open, like dns.
+	dryRun     bool          // Status isn't written in a dry run.
+
+	mu      sync.Mutex
+	running map[string]context.CancelFunc // Stops the store of each NodeDNSRecord, by key.
+	records map[string]*storePublisher    // Publishes the records of each NodeDNSRecord, by key.
+	listed  bool                          // True once every NodeDNSRecord has been listed.
+}
+
+var (
+	_ k8s.NodeDNSRecordHandler = (*crdRecords)(nil)
+	_ k8s.NodeDNSRecordLister  = (*crdRecords)(nil)
+)
+
+// crdRule returns the rule that publishes the NodeDNSRecord.
+func crdRule(r *k8s.NodeDNSRecord) config.Rule {
+	return config.Rule{
+		Name:     "crd-" + r.Namespace + "-" + r.Name,
+		Selector: r.Spec.NodeSelector,
+		Class:    string(r.Kind()),
+		Record:   r.Spec.RecordName,
+		Zone:     r.Spec.Zone,
+		Provider: r.Spec.Provider,
+		TTL:      metav1.Duration{Duration: r.TTL()},
+	}
+}
+
+// SetRecord implements k8s.NodeDNSRecordHandler.  The record's store, if it has one, is replaced
+// with a new one for the new spec.
+func (c *crdRecords) SetRecord(r *k8s.NodeDNSRecord, err error) {
+	key := r.Key()
+	l := zap.L().With(zap.String("nodednsrecord", key))
+	c.stop(key)
+	rule := crdRule(r)
+	if err == nil {
+		err = rule.Validate()
+	}
+	var st *k8s.NodeStore
+	var p *storePublisher
+	if err == nil {
+		st, p, err = c.newStore(rule.Name, []config.Rule{rule})
+	}
+	if err != nil {
+		l.Error("problem with nodednsrecord; not publishing it", zap.Error(err))
+		c.writeStatus(key, k8s.NodeDNSRecordStatus{ObservedGeneration: r.Generation, Addresses: []string{}, Error: err.Error()})
+		return
+	}
+	kind, generation := r.Kind(), r.Generation
+	p.onUpdate = func(req k8s.UpdateRequest, err error) {
+		if req.Record.Kind != kind {
+			return
+		}
+		status := k8s.NodeDNSRecordStatus{
+			ObservedGeneration: generation,
+			FQDN:               strings.Join(st.PublishedIn(kind), ","),
+			Addresses:          make([]string, 0, len(req.Record.IPs)),
+		}
+		for _, ip := range req.Record.IPs {
+			status.Addresses = append(status.Addresses, ip.String())
+		}
+		if err != nil {
+			status.Error = err.Error()
+		} else {
+			now := metav1.NewTime(time.Now())
+			status.LastSyncTime = &now
+		}
+		c.writeStatus(key, status)
+	}
+	stop := c.startWatch(watchedStore{store: st, selector: rule.Selector})
+	c.mu.Lock()
+	c.running[key] = stop
+	c.records[key] = p
+	c.mu.Unlock()
+	l.Info("publishing nodednsrecord", zap.String("record", rule.Record), zap.String("selector", rule.Selector), zap.String("class", rule.Class))
+}
+
+// DeleteRecord implements k8s.NodeDNSRecordHandler.  The record itself is left as it is.
+func (c *crdRecords) DeleteRecord(key string) {
+	c.stop(key)
+	zap.L().Info("nodednsrecord deleted; no longer publishing it", zap.String("nodednsrecord", key))
+}
+
+// stop stops the store of the NodeDNSRecord with the key, if it's running.
+func (c *crdRecords) stop(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if stop, ok := c.running[key]; ok {
+		stop()
+		delete(c.running, key)
+		delete(c.records, key)
+	}
+}
+
+// Listed implements k8s.NodeDNSRecordLister.
+func (c *crdRecords) Listed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listed = true
+}
+
+// current returns the records that the NodeDNSRecords publish, and whether every NodeDNSRecord has
+// been listed yet.
+func (c *crdRecords) current() ([]publishedRecord, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var result []publishedRecord
+	for _, p := range c.records {
+		result = append(result, p.current()...)
+	}
+	return result, c.listed
+}
+
+// writeStatus writes the status of the NodeDNSRecord, logging any problem.
+func (c *crdRecords) writeStatus(key string, status k8s.NodeDNSRecordStatus) {
+	if c.dryRun || !c.gate.Enter() {
+		return
+	}
+	defer c.gate.Exit()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.client.WriteStatus(ctx, key, status); err != nil {
+		zap.L().Warn("problem writing nodednsrecord status", zap.String("nodednsrecord", key), zap.Error(err))
+	}
+}
@@ -0,0 +1,542 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jrockway/nodedns/pkg/budget"
+	"github.com/jrockway/nodedns/pkg/changes"
+	"github.com/jrockway/nodedns/pkg/chaos"
+	"github.com/jrockway/nodedns/pkg/config"
+	"github.com/jrockway/nodedns/pkg/digitalocean"
+	"github.com/jrockway/nodedns/pkg/dns"
+	"github.com/jrockway/nodedns/pkg/k8s"
+	"github.com/jrockway/nodedns/pkg/probe"
+	"github.com/jrockway/nodedns/pkg/sentry"
+	"github.com/jrockway/nodedns/pkg/tracing"
+	"go.uber.org/zap"
+)
+
+// allFlags is every flag group that diagnose checks.
+type allFlags struct {
+	dns     *dns.Config
+	k       *kflags
+	nd      *nodednsflags
+	probe   *probeflags
+	do      *doflags
+	admin   *adminflags
+	chaos   *chaos.Config
+	budget  *budget.Config
+	slo     *sloflags
+	agent   *agentflags
+	archive *changes.ArchiveConfig
+	tracing *tracing.Config
+	sentry  *sentry.Config
+	webhook *dns.WebhookConfig
+	fake    *dns.FakeConfig
+	file    *dns.FileConfig
+	guard   *dns.GuardConfig
+	handoff *handoffflags
+
+	// vars are the variables that record templates are expanded with; see recordVars.
+	vars map[string]interface{}
+
+	// configProviders are the dns providers that the config file and --internal_dns_provider and
+	// --external_dns_provider use, other than --dns_provider; diagnose fills it in before the
+	// providers diagnose their flags.
+	configProviders map[string]bool
+}
+
+// usesDNSProvider returns true if records are published with the named dns provider, because it's
+// --dns_provider, --internal_dns_provider, --external_dns_provider, or the config file uses it.
+func (f *allFlags) usesDNSProvider(name string) bool {
+	return f.nd.DNSProvider == name || f.configProviders[name]
+}
+
+// dnsProviders returns every dns provider that records are published with, in order of name.
+func (f *allFlags) dnsProviders() []string {
+	result := []string{f.nd.DNSProvider}
+	for p := range f.configProviders {
+		if p != f.nd.DNSProvider {
+			result = append(result, p)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// runMain returns true if the store configured with flags should be run; it always is, unless a
+// config file or --label_record is in use or we're running as an agent, and no records are
+// configured with flags.
+func (f *allFlags) runMain() bool {
+	return (f.nd.Config == "" && f.nd.AliasFile == "" && len(f.nd.LabelRecords) == 0 && f.agent.NodeName == "") || f.nd.Internal != "" || f.nd.External != "" || f.nd.Overlay != ""
+}
+
+// problem is something wrong with the configuration.
+type problem struct {
+	what string // What's wrong, like "parse --overlay_cidr".
+	err  error
+	fix  string // A suggestion for fixing it.
+}
+
+// diagnose checks the flags and config files for every problem that can be found without talking
+// to any API, so that they can be reported together instead of one per restart.  It returns the
+// loaded config file, which is only valid if there are no problems.
+func diagnose(f allFlags) (*config.File, []problem) {
+	var problems []problem
+	add := func(what string, err error, fix string) {
+		problems = append(problems, problem{what: what, err: err, fix: fix})
+	}
+
+	if err := f.tracing.Validate(); err != nil {
+		add("reconcile trace sampling", err, "set --trace_reconcile_sample_rate to a fraction between 0 and 1, or a negative number to use the tracer's sampler")
+	}
+	if err := f.chaos.Validate(); err != nil {
+		add("chaos mode", err, "set the --chaos_*_rate flags to fractions between 0 and 1")
+	}
+	if err := f.budget.Validate(); err != nil {
+		add("api budget coordination", err, "set --budget_requests_per_hour and --budget_heartbeat to positive values, --budget_zone, and --budget_instance_id to a name without dots or spaces")
+	}
+	if err := f.guard.Validate(); err != nil {
+		add("deletion safety", err, "set --max_delete_fraction to a fraction between 0 and 1, and --min_records to 0 or more")
+	}
+	if f.nd.Parallelism < 1 {
+		add("--dns_parallelism", fmt.Errorf("%v: must be positive", f.nd.Parallelism), "set --dns_parallelism to how many records to change at a time, like 4")
+	}
+	if f.nd.InternalTTL < 0 || f.nd.ExternalTTL < 0 {
+		add("record ttl", fmt.Errorf("--internal_ttl=%v, --external_ttl=%v: must not be negative", f.nd.InternalTTL, f.nd.ExternalTTL), "set --internal_ttl and --external_ttl to a duration, like 60s, or 0 to use --ttl")
+	}
+	if f.slo.Target < 0 || f.slo.Target > 1 {
+		add("slo target", fmt.Errorf("%v: must be between 0 and 1", f.slo.Target), "set --slo_target to a fraction, like 0.999")
+	}
+
+	// Config file problems are reported individually.
+	addConfig := func(what string, err error) {
+		var cp config.Problems
+		if !errors.As(err, &cp) {
+			add(what, err, "fix the file; see the example in the README")
+			return
+		}
+		for _, err := range cp {
+			add(what, err, "fix the file; see the example in the README")
+		}
+	}
+	cfg := new(config.File)
+	if f.nd.Config != "" {
+		c, err := config.Load(f.nd.Config)
+		if err != nil {
+			addConfig("load --config", err)
+		} else {
+			cfg = c
+		}
+	}
+	if f.nd.AliasFile != "" {
+		aliases, err := config.LoadAliases(f.nd.AliasFile)
+		if err != nil {
+			addConfig("load --alias_file", err)
+		} else {
+			cfg.Aliases = append(cfg.Aliases, aliases...)
+			// Aliases from both files must not collide.
+			if f.nd.Config != "" {
+				if err := cfg.Validate(); err != nil {
+					addConfig("merge --alias_file with --config", err)
+				}
+			}
+		}
+	}
+	if len(f.nd.LabelRecords) > 0 {
+		cfg.Aliases = append(cfg.Aliases, config.LabelAliases(f.nd.LabelRecords, f.nd.LabelClass)...)
+		// The records must not collide with each other, or with the config files.
+		var cp config.Problems
+		if err := cfg.Validate(); errors.As(err, &cp) {
+			for _, err := range cp {
+				add("--label_record", err, "pass a label selector and a record, like --label_record=pool=ingress:ingress.example.com, once per record")
+			}
+		}
+	}
+	if err := cfg.Expand(f.vars); err != nil {
+		addConfig("expand record templates", err)
+	}
+	for _, d := range []struct{ flag, name string }{
+		{"--internal_domain", f.nd.Internal}, {"--external_domain", f.nd.External}, {"--overlay_domain", f.nd.Overlay},
+		{"--agent_internal_domain", f.agent.Internal}, {"--agent_external_domain", f.agent.External}, {"--agent_overlay_domain", f.agent.Overlay},
+	} {
+		if _, err := config.ExpandRecord(d.name, f.vars); err != nil {
+			add(d.flag, err, "set the variables that the template uses with --record_var, like --record_var=ClusterName:prod, or --record_vars_node for .Labels, .Zone, and .Region")
+		}
+	}
+	var rules []config.Rule
+	for _, s := range cfg.Stores {
+		rules = append(rules, s.Rules()...)
+	}
+	rules = append(rules, cfg.Rules...)
+	for _, a := range cfg.Aliases {
+		rules = append(rules, a.Rule())
+	}
+
+	runMain := f.runMain()
+	configured := len(cfg.Stores) + len(cfg.Rules) + len(cfg.Aliases)
+	if configured > 0 && f.nd.Source != "kubernetes" {
+		add("config file", errors.New("stores, rules, and aliases select nodes by label or name, and require --source=kubernetes"), "remove --source=droplets, or publish the droplets with --*_domain flags")
+	}
+	if f.nd.ConfigReload != 0 && f.nd.Config == "" && f.nd.AliasFile == "" {
+		add("--config_reload_interval", errors.New("there's no config file to reload"), "set --config or --alias_file, or remove --config_reload_interval")
+	}
+	if f.nd.ConfigReload < 0 {
+		add("--config_reload_interval", fmt.Errorf("%v: must not be negative", f.nd.ConfigReload), "set --config_reload_interval to how often to check the config files, like 30s")
+	}
+	if f.agent.NodeName != "" {
+		if f.nd.Source != "kubernetes" {
+			add("agent mode", errors.New("requires --source=kubernetes"), "remove --source=droplets")
+		}
+		// Every node runs an agent, so only one of them may maintain the aggregate records.
+		if (runMain || configured > 0) && (f.k.Engine != "controller-runtime" || !f.k.LeaderElection) {
+			add("agent mode", errors.New("aggregate records require --engine=controller-runtime and --leader_elect"), "add those flags, or run the aggregate records in a separate deployment")
+		}
+		// Only the leader may delete other nodes' records.
+		if f.nd.GCOrphans && (f.k.Engine != "controller-runtime" || !f.k.LeaderElection) {
+			add("--gc_orphans", errors.New("in agent mode, requires --engine=controller-runtime and --leader_elect, so that only one agent deletes records"), "add those flags, or remove --gc_orphans")
+		}
+	}
+
+	for _, cidr := range f.nd.OverlayCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			add("parse --overlay_cidr", err, "use cidr notation, like 100.64.0.0/10")
+		}
+	}
+	for _, cidr := range f.nd.IncludeCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			add("parse --include_cidr", err, "use cidr notation, like 10.0.0.0/8")
+		}
+	}
+	for _, cidr := range f.nd.ExcludeCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			add("parse --exclude_cidr", err, "use cidr notation, like 10.244.0.0/16")
+		}
+	}
+	for _, cidr := range f.nd.AnnouncedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			add("parse --external_announced_cidr", err, "use cidr notation, like 203.0.113.0/24")
+		}
+	}
+	if f.nd.PinConfigMap != "" {
+		if namespace, name := splitConfigMap(f.nd.PinConfigMap); namespace == "" || name == "" {
+			add("parse --pin_configmap", fmt.Errorf("%q: must be namespace/name", f.nd.PinConfigMap), "set --pin_configmap to the namespace and name of the configmap, like kube-system/nodedns-pinned")
+		}
+	}
+	if f.nd.StatusConfigMap != "" {
+		if namespace, name := splitConfigMap(f.nd.StatusConfigMap); namespace == "" || name == "" {
+			add("parse --status_configmap", fmt.Errorf("%q: must be namespace/name", f.nd.StatusConfigMap), "set --status_configmap to the namespace and name of the configmap, like kube-system/nodedns-status")
+		}
+		if f.nd.StatusInterval <= 0 {
+			add("--status_configmap_interval", fmt.Errorf("%v: must be positive", f.nd.StatusInterval), "set --status_configmap_interval to how often to write the configmap, like 10s")
+		}
+	}
+	if f.nd.RecordsConfigMap != "" {
+		if namespace, name := splitConfigMap(f.nd.RecordsConfigMap); namespace == "" || name == "" {
+			add("parse --records_configmap", fmt.Errorf("%q: must be namespace/name", f.nd.RecordsConfigMap), "set --records_configmap to the namespace and name of the configmap, like kube-system/nodedns-records")
+		}
+	}
+	for _, pattern := range f.nd.ExcludeNodeNames {
+		if _, err := regexp.Compile(pattern); err != nil {
+			add("parse --exclude_node_name", err, "use go regular expression syntax, like ^gpu-burst-")
+		}
+	}
+	if len(f.k.NodePortSRV) > 0 {
+		for name, port := range f.k.NodePortSRV {
+			if _, err := k8s.ParseServicePort(port); err != nil {
+				add("parse --nodeport_srv "+name, err, "name the service port as namespace/service/port, like default/web/https")
+			}
+		}
+		if f.nd.DNSProvider != "digitalocean" {
+			add("--nodeport_srv", fmt.Errorf("the %s provider doesn't publish srv records", f.nd.DNSProvider), "remove --nodeport_srv, or use the digitalocean provider")
+		}
+		if f.k.NodePortSRVTarget == "" && f.nd.External == "" {
+			add("--nodeport_srv", errors.New("no record for the srv records to point at"), "set --nodeport_srv_target or --external_domain")
+		}
+	}
+	if f.nd.PerNodeTemplate != "" {
+		tmpl, err := k8s.ParsePerNodeTemplate(f.nd.PerNodeTemplate)
+		if err == nil {
+			_, err = (&k8s.PerNode{Template: tmpl}).RecordName(k8s.Node{Name: "node-1"})
+		}
+		switch {
+		case err != nil:
+			add("parse --per_node_domain_template", err, "use a go template that names a record for the node, like {{.Name}}.nodes.example.com")
+		case !f.runMain():
+			add("--per_node_domain_template", errors.New("per-node records are published from the nodes selected with flags"), "set --internal_domain, --external_domain, or --overlay_domain, or remove --per_node_domain_template")
+		}
+	}
+	if f.nd.RemovalDelay < 0 {
+		add("--removal_delay", fmt.Errorf("%v: must not be negative", f.nd.RemovalDelay), "set --removal_delay to how long to keep nodes that go away, like 2m, or to 0")
+	}
+	if f.nd.PublicIPSource != "" && f.nd.PublicIPInterval <= 0 {
+		add("--public_ip_interval", fmt.Errorf("%v: must be positive", f.nd.PublicIPInterval), "set --public_ip_interval to how often to rediscover the public address, like 5m")
+	}
+	if f.probe.Interval < 0 {
+		add("--probe_interval", fmt.Errorf("%v: must not be negative", f.probe.Interval), "set --probe_interval to how often to probe addresses between updates, like 10s, or to 0")
+	}
+	if f.nd.TopologyTemplate != "" {
+		switch _, err := k8s.NewTopology(f.nd.TopologyTemplate, k8s.Kind(f.nd.TopologyRecord)); {
+		case err != nil:
+			add("parse --topology_domain_template", err, "name a record per zone, like nodes.{{.Zone}}.example.com")
+		case !f.runMain():
+			add("--topology_domain_template", errors.New("per-zone records are published from the nodes selected with flags"), "set --internal_domain, --external_domain, or --overlay_domain, or remove --topology_domain_template")
+		}
+	}
+	for _, p := range []struct {
+		flag  string
+		specs []string
+	}{{"--internal_probe", f.probe.Internal}, {"--external_probe", f.probe.External}, {"--overlay_probe", f.probe.Overlay}} {
+		if _, err := probe.NewProber("doctor", append(append([]string{}, f.probe.Probes...), p.specs...), f.probe.ExpectedStatus, f.probe.Timeout); err != nil {
+			add("parse --probe or "+p.flag, err, "use tcp:<port>, http:<port><path>, https:<port><path>, or icmp")
+		}
+	}
+
+	// Records, and the zones and tokens that they need.
+	type record struct{ what, zone, name, provider string }
+	var records []record
+	f.configProviders = make(map[string]bool)
+	if runMain {
+		for _, r := range []record{
+			{what: "--internal_domain", name: f.nd.Internal, zone: f.nd.InternalZone, provider: f.nd.InternalDNS},
+			{what: "--external_domain", name: f.nd.External, zone: f.nd.ExternalZone, provider: f.nd.ExternalDNS},
+			{what: "--overlay_domain", name: f.nd.Overlay},
+		} {
+			if r.name == "" {
+				continue
+			}
+			if r.zone == "" {
+				r.zone = f.dns.Zone
+			}
+			if r.provider == "" {
+				r.provider = f.nd.DNSProvider
+			} else {
+				f.configProviders[r.provider] = true
+			}
+			records = append(records, r)
+		}
+	}
+	if f.agent.NodeName != "" {
+		for _, r := range []record{{what: "--agent_internal_domain", name: f.agent.Internal}, {what: "--agent_external_domain", name: f.agent.External}, {what: "--agent_overlay_domain", name: f.agent.Overlay}} {
+			if r.name != "" {
+				records = append(records, record{what: r.what, zone: f.dns.Zone, name: strings.ReplaceAll(r.name, "{node}", f.agent.NodeName), provider: f.nd.DNSProvider})
+			}
+		}
+	}
+	for _, r := range rules {
+		zone, provider := f.dns.Zone, f.nd.DNSProvider
+		if r.Zone != "" {
+			zone = r.Zone
+		}
+		if r.Provider != "" {
+			provider = r.Provider
+			f.configProviders[provider] = true
+		}
+		records = append(records, record{what: "config " + r.Name, zone: zone, name: r.Record, provider: provider})
+		if r.Family != "" && f.nd.AddressFamily != "" && f.nd.AddressFamily != "dual" && r.Family != f.nd.AddressFamily {
+			// The rule would empty its record.
+			add("config "+r.Name, fmt.Errorf("family %s conflicts with --address_family=%s", r.Family, f.nd.AddressFamily), "remove the rule, or set --address_family=dual")
+		}
+	}
+	// The store configured with flags always looks up its zone, even without any records.
+	needToken := f.nd.Source == "droplets" || f.do.Verify || f.do.FirewallID != "" || f.do.LoadBalancerID != ""
+	// Zones are only selected automatically from the zones in the DigitalOcean account.
+	digitalOceanDNS := f.nd.DNSProvider == "digitalocean"
+	for _, p := range f.dnsProviders() {
+		if p == "digitalocean" {
+			continue
+		}
+		if f.nd.TXTOwnerID != "" {
+			add("--txt_owner_id", fmt.Errorf("the %s provider doesn't keep an ownership registry", p), "remove --txt_owner_id, or only use the digitalocean provider")
+		}
+		if f.nd.SkipUnchanged {
+			add("--skip_unchanged", fmt.Errorf("the %s provider doesn't skip unchanged updates", p), "remove --skip_unchanged, or only use the digitalocean provider")
+		}
+		if f.nd.Cleanup {
+			add("--cleanup_on_shutdown", fmt.Errorf("the %s provider doesn't keep an ownership registry, so it can't tell which records it owns", p), "remove --cleanup_on_shutdown, or only use the digitalocean provider")
+		}
+		if f.nd.GCOrphans {
+			add("--gc_orphans", fmt.Errorf("the %s provider doesn't keep an ownership registry, so it can't find its orphaned records", p), "remove --gc_orphans, or only use the digitalocean provider")
+		}
+	}
+	if f.nd.Cleanup && f.nd.TXTOwnerID == "" {
+		add("--cleanup_on_shutdown", errors.New("requires --txt_owner_id, so that only records that nodedns created are deleted"), "set --txt_owner_id, or remove --cleanup_on_shutdown")
+	}
+	if f.nd.AdoptUnowned && f.nd.TXTOwnerID == "" {
+		add("--txt_owner_adopt", errors.New("requires --txt_owner_id, which names the owner that records are marked with"), "set --txt_owner_id, or remove --txt_owner_adopt")
+	}
+	if f.nd.GCOrphans && f.nd.TXTOwnerID == "" {
+		add("--gc_orphans", errors.New("requires --txt_owner_id, so that only records that nodedns created are deleted"), "set --txt_owner_id, or remove --gc_orphans")
+	}
+	if f.nd.GCInterval < 0 || (f.nd.GCInterval > 0 && !f.nd.GCOrphans) {
+		add("--gc_interval", fmt.Errorf("%v: must be positive, with --gc_orphans", f.nd.GCInterval), "set --gc_orphans, or remove --gc_interval")
+	}
+	if runMain {
+		if f.dns.Zone == "" && (len(records) == 0 || !digitalOceanDNS) {
+			add("--zone", errors.New("must be set"), "set --zone to the dns zone that your records are in")
+		}
+		if _, ok := f.dns.ZoneTokens[f.dns.Zone]; !ok && digitalOceanDNS {
+			needToken = true
+		}
+	}
+	for _, r := range records {
+		if _, ok := f.dns.ZoneTokens[r.zone]; !ok && r.provider == "digitalocean" {
+			needToken = true
+		}
+		if r.zone == "" && r.provider != "digitalocean" {
+			add(r.what, fmt.Errorf("record %q has no zone", r.name), "set --zone or the zone of the rule in the config file; only digitalocean zones are selected automatically")
+			continue
+		}
+		if r.zone == "" {
+			// The zone is selected when nodedns starts, but only fully-qualified names can
+			// be matched to one.
+			if !strings.Contains(strings.TrimSuffix(r.name, "."), ".") {
+				add(r.what, fmt.Errorf("record %q has no zone", r.name), "use a fully-qualified name, like nodes.example.com, or set --zone or the zone of the rule in the config file")
+			}
+			continue
+		}
+		// Absolute names are the only ones that can be outside the zone; dns.Client.FQDN would
+		// quietly append the zone to them.
+		if !strings.HasSuffix(r.name, ".") {
+			continue
+		}
+		name, zone := strings.TrimSuffix(r.name, "."), strings.TrimSuffix(r.zone, ".")
+		if name != zone && !strings.HasSuffix(name, "."+zone) {
+			add(r.what, fmt.Errorf("record %q is outside of zone %q", r.name, r.zone), "use a name relative to the zone, or set the zone that contains the record")
+		}
+	}
+	if needToken && f.dns.PAToken == "" && f.dns.TokenFile == "" && len(f.dns.ExtraTokens) == 0 {
+		add("digitalocean token", errors.New("no token is configured"), "set --token or $DIGITALOCEAN_TOKEN to a personal access token with read and write scope, or --token_file to a file containing one")
+	}
+	if f.dns.TokenFile != "" {
+		if f.dns.PAToken != "" {
+			add("--token_file", errors.New("replaces --token, and can't be used with it"), "remove --token or $DIGITALOCEAN_TOKEN")
+		}
+		if _, err := digitalocean.ReadTokenFile(f.dns.TokenFile); err != nil {
+			add("--token_file", err, "mount the secret containing the token at --token_file")
+		}
+	}
+
+	// Flags that conflict with, or are useless without, other flags.
+	if len(f.k.Clusters) > 0 && f.nd.Source != "kubernetes" {
+		add("--cluster", errors.New("requires --source=kubernetes"), "remove --cluster, or --source=droplets")
+	}
+	if len(f.k.Clusters) > 0 && f.k.Engine == "controller-runtime" {
+		add("--cluster", errors.New("only works with --engine=reflector"), "remove --engine=controller-runtime")
+	}
+	for name, value := range f.k.Clusters {
+		if kubeconfig, _ := splitCluster(value); name == "" || kubeconfig == "" {
+			add("--cluster", fmt.Errorf("%q: needs a name and a kubeconfig", name+":"+value), "pass name:kubeconfig, or name:kubeconfig@context")
+			continue
+		}
+		if _, err := k8s.ClusterConfig(splitCluster(value)); err != nil {
+			add("--cluster", fmt.Errorf("%s: %w", name, err), "check the cluster's kubeconfig and context")
+		}
+	}
+	if f.k.NodeDNSRecords && f.nd.Source != "kubernetes" {
+		add("--watch_nodednsrecords", errors.New("requires --source=kubernetes"), "remove --watch_nodednsrecords, or --source=droplets")
+	}
+	if f.k.NodeDNSRecords && f.k.Engine != "reflector" {
+		add("--watch_nodednsrecords", errors.New("only works with --engine=reflector"), "remove --engine=controller-runtime")
+	}
+	if f.k.LeaderElection && f.nd.Source != "kubernetes" {
+		add("--leader_elect", errors.New("requires --source=kubernetes"), "remove --leader_elect, or --source=droplets")
+	}
+	if f.k.LeaderElection && f.k.Engine == "reflector" && f.agent.NodeName != "" {
+		add("--leader_elect", errors.New("in agent mode, only works with --engine=controller-runtime"), "add --engine=controller-runtime")
+	}
+	if f.k.LeaderElection && f.k.Engine == "reflector" && f.handoff.TakeoverFrom != "" {
+		add("--leader_elect", errors.New("replicas take over from each other by leader election, and can't also use --takeover_from"), "remove --takeover_from")
+	}
+	if f.do.DropUnverified && !f.do.Verify {
+		add("--drop_unverified", errors.New("only works with --verify_droplets"), "add --verify_droplets")
+	}
+	if f.nd.VIPReplace && len(f.nd.VIPAnnotations) == 0 {
+		add("--vip_replace", errors.New("only works with --vip_annotation"), "add --vip_annotation, or remove --vip_replace")
+	}
+	if f.nd.Audit && f.nd.IsDryRun {
+		add("--audit", errors.New("conflicts with --dry_run"), "remove --dry_run; --audit already doesn't change anything")
+	}
+	if f.nd.Audit && f.budget.Enabled {
+		add("--audit", errors.New("conflicts with --budget_coordination, which writes marker records"), "remove --budget_coordination")
+	}
+	if f.admin.Issuer != "" && f.admin.InsecureNoAuth {
+		add("admin api", errors.New("--admin_oidc_issuer and --admin_insecure_no_auth conflict"), "remove --admin_insecure_no_auth")
+	}
+	if f.admin.Issuer != "" && f.admin.Kubernetes {
+		add("admin api", errors.New("--admin_oidc_issuer and --admin_kubernetes_auth conflict"), "pick one way to authenticate admin api callers")
+	}
+	if f.admin.Kubernetes && f.admin.InsecureNoAuth {
+		add("admin api", errors.New("--admin_kubernetes_auth and --admin_insecure_no_auth conflict"), "remove --admin_insecure_no_auth")
+	}
+	if len(f.admin.KubeAudiences) > 0 && !f.admin.Kubernetes {
+		add("--admin_kubernetes_audience", errors.New("only works with --admin_kubernetes_auth"), "add --admin_kubernetes_auth, or remove --admin_kubernetes_audience")
+	}
+	if f.sentry.DSN != "" {
+		if _, err := sentry.New(*f.sentry); err != nil {
+			add("--sentry_dsn", err, "copy the dsn from the error tracker's project settings, like https://<key>@o0.ingest.sentry.io/<project>")
+		}
+	}
+	if f.archive.Bucket != "" && newArchiveUploader == nil {
+		add("--archive_bucket", errors.New("this build doesn't include the aws provider, which uploads the archive"), "use a build without the no_aws tag, or remove --archive_bucket")
+	}
+	if f.usesDNSProvider("webhook") {
+		if f.webhook.URL == "" {
+			add("--dns_provider=webhook", errors.New("requires --webhook_url"), "set --webhook_url to the endpoint that publishes the records")
+		}
+		if f.nd.Audit {
+			add("--audit", errors.New("can't audit records published with a webhook"), "remove --audit, or use a provider that nodedns can read records from")
+		}
+	}
+	if f.usesDNSProvider("fake") {
+		if err := f.fake.Validate(); err != nil {
+			add("--dns_provider=fake", err, "set --fake_dns_latency to a duration, and --fake_dns_failure_rate to a fraction between 0 and 1")
+		}
+	}
+	if f.usesDNSProvider("file") {
+		if err := f.file.Validate(); err != nil {
+			add("--dns_provider=file", err, "set --file_path to the file to write, and --file_format to zone or hosts")
+		}
+	}
+	// The choices of --dns_provider depend on the build, so they're checked here rather than by the
+	// flag parser.
+	compiled := make(map[string]bool)
+	for _, name := range dnsProviderNames() {
+		compiled[name] = true
+	}
+	known := make(map[string]bool)
+	for _, name := range config.Providers {
+		known[name] = true
+	}
+	for _, name := range f.dnsProviders() {
+		switch {
+		case compiled[name]:
+		case known[name]:
+			add("dns provider "+name, fmt.Errorf("this build doesn't include the %s provider", name), "use a build without the no_"+name+" tag")
+		default:
+			add("dns provider "+name, fmt.Errorf("unknown provider %q", name), "use one of "+strings.Join(dnsProviderNames(), ", "))
+		}
+	}
+	for _, p := range compiledProviders() {
+		if p.diagnose != nil {
+			problems = append(problems, p.diagnose(f)...)
+		}
+	}
+	return cfg, problems
+}
+
+// report logs every problem, and exits if there are any.
+func report(problems []problem) {
+	if len(problems) == 0 {
+		return
+	}
+	for _, p := range problems {
+		zap.L().Error("configuration problem: "+p.what, zap.Error(p.err), zap.String("fix", p.fix))
+	}
+	zap.L().Fatal(fmt.Sprintf("found %d configuration problems; see above", len(problems)))
+}
@@ -5,27 +5,235 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/jrockway/nodedns/pkg/admin"
+	"github.com/jrockway/nodedns/pkg/audit"
+	"github.com/jrockway/nodedns/pkg/budget"
+	"github.com/jrockway/nodedns/pkg/changes"
+	"github.com/jrockway/nodedns/pkg/chaos"
+	"github.com/jrockway/nodedns/pkg/config"
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"github.com/jrockway/nodedns/pkg/digitalocean"
 	"github.com/jrockway/nodedns/pkg/dns"
+	"github.com/jrockway/nodedns/pkg/handoff"
 	"github.com/jrockway/nodedns/pkg/k8s"
+	"github.com/jrockway/nodedns/pkg/nodedns"
+	"github.com/jrockway/nodedns/pkg/probe"
+	"github.com/jrockway/nodedns/pkg/publicip"
+	"github.com/jrockway/nodedns/pkg/sentry"
+	"github.com/jrockway/nodedns/pkg/slo"
+	"github.com/jrockway/nodedns/pkg/tracing"
+	"github.com/jrockway/nodedns/pkg/watchdog"
 	"github.com/jrockway/opinionated-server/server"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
 type kflags struct {
 	Kubeconfig string `long:"kubeconfig" env:"KUBECONFIG" description:"kubeconfig to use to connect to the cluster, when running outside of the cluster"`
 	Master     string `long:"master" env:"KUBE_MASTER" description:"url of the kubernetes master, only necessary when running outside of the cluster and when it's not specified in the provided kubeconfig"`
+
+	Clusters map[string]string `long:"cluster" env:"CLUSTERS" env-delim:"," description:"A name:kubeconfig[@context] pair, like west:/etc/nodedns/west.yaml@admin; the nodes of that cluster are merged into the same records as the nodes of the main cluster.  May be repeated."`
+
+	NodeDNSRecords bool `long:"watch_nodednsrecords" env:"WATCH_NODEDNSRECORDS" description:"publish a record for each NodeDNSRecord object (see deploy/crd.yaml), and write the result of each update to its status"`
+
+	Services          bool   `long:"watch_services" env:"WATCH_SERVICES" description:"publish the load balancer addresses of each Service of type LoadBalancer to the records named by its --service_annotation"`
+	ServiceAnnotation string `long:"service_annotation" env:"SERVICE_ANNOTATION" description:"the Service annotation that names the records, comma-separated, of its load balancer addresses" default:"nodedns.jrockway.io/hostname"`
+
+	NodePortSRV       map[string]string `long:"nodeport_srv" env:"NODEPORT_SRV" env-delim:"," description:"A record:namespace/service/port pair, like _https._tcp.nodes.example.com:default/web/https; an SRV record pointing at --nodeport_srv_target with the port's NodePort is kept up to date.  May be repeated."`
+	NodePortSRVTarget string            `long:"nodeport_srv_target" env:"NODEPORT_SRV_TARGET" description:"the record that --nodeport_srv records point at; if empty, --external_domain"`
+
+	Engine                  string `long:"engine" env:"ENGINE" description:"how to watch nodes; a reflector per store, or a controller-runtime manager with one shared watch and optional leader election" choice:"reflector" choice:"controller-runtime" default:"reflector"`
+	LeaderElection          bool   `long:"leader_elect" env:"LEADER_ELECT" description:"only publish records while holding a leader election lease, so that several replicas can be run; with --engine=reflector, followers keep watching nodes so they can take over right away"`
+	LeaderElectionNamespace string `long:"leader_election_namespace" env:"LEADER_ELECTION_NAMESPACE" description:"the namespace of the leader election lease; required outside of the cluster"`
+	LeaderElectionID        string `long:"leader_election_id" env:"LEADER_ELECTION_ID" description:"the name of the leader election lease" default:"nodedns"`
 }
 
 type nodednsflags struct {
-	IsDryRun bool          `long:"dry_run" env:"DRY_RUN" description:"don't actually update any dns records"`
-	Resync   time.Duration `long:"resync" env:"RESYNC_INTERVAL" description:"resync the current state of nodes to DNS at this interval"`
-	Internal string        `long:"internal_domain" env:"INTERNAL_DOMAIN" description:"the dns record that will store the nodes' internal addresses"`
-	External string        `long:"external_domain" env:"EXTERNAL_DOMAIN" description:"the dns record that will store the nodes' external addresses"`
+	Config        string            `long:"config" env:"CONFIG_FILE" description:"a yaml configuration file describing additional sets of nodes to publish to their own records"`
+	AliasFile     string            `long:"alias_file" env:"ALIAS_FILE" description:"a yaml file of aliases, assigning handpicked nodes to their own records; merged with the aliases in --config"`
+	LabelRecords  map[string]string `long:"label_record" env:"LABEL_RECORDS" env-delim:"," description:"A selector:record pair, like pool=ingress:ingress.example.com; nodes matching the label selector are published to that record, in addition to any others.  May be repeated."`
+	ConfigReload  time.Duration     `long:"config_reload_interval" env:"CONFIG_RELOAD_INTERVAL" description:"check --config and --alias_file for changes at this interval, and reload them when they change; they're always reloaded on SIGHUP.  Stores, rules, and aliases that are added or removed only start or stop at the next restart"`
+	LabelClass    string            `long:"label_record_class" env:"LABEL_RECORD_CLASS" description:"the class of address that --label_record publishes" choice:"internal" choice:"external" choice:"overlay" default:"external"`
+	Source        string            `long:"source" env:"SOURCE" description:"where to find nodes; kubernetes nodes, or digitalocean droplets (see --droplet_tag)" choice:"kubernetes" choice:"droplets" default:"kubernetes"`
+	IsDryRun      bool              `long:"dry_run" env:"DRY_RUN" description:"don't actually update any dns records"`
+	DNSProvider   string            `long:"dns_provider" env:"DNS_PROVIDER" description:"where to publish dns records; digitalocean, webhook, fake, file, or one of the dns providers that this build includes (see nodedns providers)" default:"digitalocean"`
+	Audit         bool              `long:"audit" env:"AUDIT" description:"compare dns to the nodes and report drift in logs and the dns_record_drift metric, but never change dns records or integrations; for running in shadow alongside whatever maintains the records now"`
+	CreateOnly    bool              `long:"create_only" env:"CREATE_ONLY" description:"only ever add dns records, never delete them; the records that would have been deleted are logged instead"`
+	SkipUnchanged bool              `long:"skip_unchanged" env:"SKIP_UNCHANGED" description:"remember the addresses last applied to each record, and skip updates that wouldn't change them without listing the zone; digitalocean only"`
+	VerifyEvery   time.Duration     `long:"verify_interval" env:"VERIFY_INTERVAL" description:"with --skip_unchanged, list each record again if it hasn't been listed for this long, to undo changes made by something else; 0 never does" default:"1h"`
+	TXTOwnerID    string            `long:"txt_owner_id" env:"TXT_OWNER_ID" description:"keep an ownership registry: mark each record with a TXT record containing owner=nodedns/<id>, and never change A or AAAA records that aren't marked with it; digitalocean only"`
+	AdoptUnowned  bool              `long:"txt_owner_adopt" env:"TXT_OWNER_ADOPT" description:"with --txt_owner_id, mark existing A or AAAA records that have no owner as this instance's, rather than refusing to change them; records marked with another owner are still left alone"`
+	Resync        time.Duration     `long:"resync" env:"RESYNC_INTERVAL" description:"resync the current state of nodes to DNS at this interval"`
+	UpdateTimeout time.Duration     `long:"update_timeout" env:"UPDATE_TIMEOUT" description:"how long each dns update may take, separately from the 10s limit on handling each node event" default:"1m"`
+	RetryMin      time.Duration     `long:"update_retry_min" env:"UPDATE_RETRY_MIN" description:"how long to wait before retrying a record whose update failed; the wait doubles with each retry; 0 waits for the next resync instead" default:"5s"`
+	RetryMax      time.Duration     `long:"update_retry_max" env:"UPDATE_RETRY_MAX" description:"the longest to wait between retries of a record whose update failed" default:"5m"`
+	RetryLimit    int               `long:"update_retry_limit" env:"UPDATE_RETRY_LIMIT" description:"how many times in a row to retry a record whose update failed before leaving it for the next change or resync; 0 retries until it succeeds"`
+	Concurrent    bool              `long:"concurrent_updates" env:"CONCURRENT_UPDATES" description:"update the records that a node event changes, and dns and each integration, concurrently instead of one at a time"`
+	Async         bool              `long:"async_updates" env:"ASYNC_UPDATES" description:"update dns and each integration in the background, so that slow updates never hold up the node watch; implies --concurrent_updates"`
+	PendingDir    string            `long:"pending_dir" env:"PENDING_DIR" description:"a directory (on a persistent volume) to save records whose updates haven't succeeded yet in, so that they're retried after a restart"`
+	DrainTimeout  time.Duration     `long:"drain_timeout" env:"DRAIN_TIMEOUT" description:"at shutdown, how long to wait for updates in progress to finish and background integrations to stop; keep it shorter than --shutdown_grace_period" default:"20s"`
+	Cleanup       bool              `long:"cleanup_on_shutdown" env:"CLEANUP_ON_SHUTDOWN" description:"at shutdown, after the updates in progress finish, delete every record that this instance owns (see --txt_owner_id), so that ephemeral clusters don't leave their nodes in dns; requires --txt_owner_id; digitalocean only"`
+	GCOrphans     bool              `long:"gc_orphans" env:"GC_ORPHANS" description:"once every store has listed all of its nodes after startup, delete the records that this instance owns (see --txt_owner_id) but no longer publishes, like records left behind by renamed flags or removed config rules; requires --txt_owner_id; digitalocean only"`
+	GCInterval    time.Duration     `long:"gc_interval" env:"GC_INTERVAL" description:"with --gc_orphans, also delete orphaned records this often; 0 to only delete them at startup"`
+	Parallelism   int               `long:"dns_parallelism" env:"DNS_PARALLELISM" description:"how many records to create, and then delete, at a time in one dns record, for providers that make a request per record (digitalocean, cloudflare, and consul); 1 to make one change at a time" default:"4"`
+	AuditLog      string            `long:"audit_log" env:"AUDIT_LOG" description:"append a line of json for every dns record created or deleted, with the nodes and the event that caused it, to this file; or \"log\" to log them from the audit logger"`
+	ChangesStdout bool              `long:"changes_stdout" env:"CHANGES_STDOUT" description:"write every change to a record to stdout as a line of json, for shell pipelines and log-based automation; logs still go to stderr"`
+	MaxFailures   int               `long:"sink_unhealthy_after" env:"SINK_UNHEALTHY_AFTER" description:"report unhealthy at /healthz/sinks when dns or an integration has failed this many times in a row; 0 to never report unhealthy" default:"5"`
+	LiveResyncs   int               `long:"liveness_resyncs" env:"LIVENESS_RESYNCS" description:"report not live at /healthz/live when nothing has been reconciled, or no node events have arrived, for this many --resync intervals; 0 (or no --resync) to always report live" default:"3"`
+	Internal      string            `long:"internal_domain" env:"INTERNAL_DOMAIN" description:"the dns record that will store the nodes' internal addresses"`
+	External      string            `long:"external_domain" env:"EXTERNAL_DOMAIN" description:"the dns record that will store the nodes' external addresses"`
+	RecordVars    map[string]string `long:"record_var" env:"RECORD_VARS" env-delim:"," description:"a variable for record name templates, like ClusterName:prod for --external_domain=nodes.{{.ClusterName}}.example.com; the --*_domain flags and the records in --config and --alias_file can be go templates"`
+	VarsNode      string            `long:"record_vars_node" env:"RECORD_VARS_NODE" description:"the node whose labels, zone, and region record name templates can use as {{index .Labels \"name\"}}, {{.Zone}}, and {{.Region}}; usually this pod's node, from the downward api"`
+	InternalTTL   time.Duration     `long:"internal_ttl" env:"INTERNAL_TTL" description:"the ttl of the records in --internal_domain; if zero, --ttl"`
+	ExternalTTL   time.Duration     `long:"external_ttl" env:"EXTERNAL_TTL" description:"the ttl of the records in --external_domain; if zero, --ttl"`
+	InternalDNS   string            `long:"internal_dns_provider" env:"INTERNAL_DNS_PROVIDER" description:"where to publish --internal_domain, for split-horizon dns; if empty, --dns_provider"`
+	ExternalDNS   string            `long:"external_dns_provider" env:"EXTERNAL_DNS_PROVIDER" description:"where to publish --external_domain; if empty, --dns_provider"`
+	InternalZone  string            `long:"internal_zone" env:"INTERNAL_ZONE" description:"the dns zone that --internal_domain is in, for split-horizon dns; if empty, --zone"`
+	ExternalZone  string            `long:"external_zone" env:"EXTERNAL_ZONE" description:"the dns zone that --external_domain is in; if empty, --zone"`
+
+	Overlay           string   `long:"overlay_domain" env:"OVERLAY_DOMAIN" description:"the dns record that will store the nodes' overlay network (tailscale, wireguard) addresses; if empty, overlay addresses are not detected"`
+	OverlayCIDRs      []string `long:"overlay_cidr" env:"OVERLAY_CIDRS" env-delim:"," description:"node addresses in this network are considered overlay addresses" default:"100.64.0.0/10"`
+	OverlayAnnotation string   `long:"overlay_annotation" env:"OVERLAY_ANNOTATION" description:"a node annotation containing a comma-separated list of the node's overlay addresses"`
+
+	PerNodeTemplate string `long:"per_node_domain_template" env:"PER_NODE_DOMAIN_TEMPLATE" description:"a go template naming a record for each node that contains only that node's addresses, like {{.Name}}.nodes.example.com; {{short .Name}} is the first label of the node's name"`
+	PerNodeRecord   string `long:"per_node_record" env:"PER_NODE_RECORD" description:"which of a node's addresses are published in its own record" choice:"internal" choice:"external" choice:"overlay" default:"external"`
+
+	TopologyTemplate string `long:"topology_domain_template" env:"TOPOLOGY_DOMAIN_TEMPLATE" description:"a record per topology zone or region, containing the addresses of the nodes in it, like nodes.{{.Zone}}.example.com; a go template of the node's .Zone and .Region, from its topology.kubernetes.io labels"`
+	TopologyRecord   string `long:"topology_record" env:"TOPOLOGY_RECORD" description:"which of the nodes' addresses are published in the per-zone records" choice:"internal" choice:"external" choice:"overlay" default:"external"`
+
+	ExcludeNodeNames          []string      `long:"exclude_node_name" env:"EXCLUDE_NODE_NAMES" env-delim:"," description:"a regular expression; nodes whose names match are not published, like ^gpu-burst-; may be repeated"`
+	ExcludeAnnotation         string        `long:"exclude_annotation" env:"EXCLUDE_ANNOTATION" description:"a node annotation that, if \"true\", keeps the node out of dns without cordoning it; nodes labeled node.kubernetes.io/exclude-from-external-load-balancers are always left out" default:"nodedns.jrockway.io/exclude"`
+	IncludeNetworkUnavailable bool          `long:"include_network_unavailable" env:"INCLUDE_NETWORK_UNAVAILABLE" description:"publish nodes whose NetworkUnavailable condition is true; by default they are left out of dns"`
+	IncludeUnschedulable      bool          `long:"include_unschedulable" env:"INCLUDE_UNSCHEDULABLE" description:"publish cordoned nodes; by default they are left out of dns"`
+	ExcludeConditions         []string      `long:"exclude_condition" env:"EXCLUDE_CONDITIONS" env-delim:"," description:"a node condition, like MemoryPressure, that must be False (or absent) for the node to be published; may be repeated"`
+	NotReadyGrace             time.Duration `long:"not_ready_grace" env:"NOT_READY_GRACE" description:"keep a published node in dns until it has not been Ready for this long, so that brief kubelet flaps don't remove it"`
+	RemovalDelay              time.Duration `long:"removal_delay" env:"REMOVAL_DELAY" description:"keep a published node that's deleted or stops being Ready in dns for this long, to avoid churn during rolling upgrades; additions are still immediate"`
+	ExcludeSpotExternal       bool          `long:"exclude_spot_external" env:"EXCLUDE_SPOT_EXTERNAL" description:"don't publish the external addresses of spot and preemptible nodes"`
+	AllowPrivateExternal      bool          `long:"allow_private_external" env:"ALLOW_PRIVATE_EXTERNAL" description:"publish external addresses that nodes report in private networks (10.0.0.0/8, 172.16.0.0/12, 192.168.0.0/16, fc00::/7); by default they're left out, since they're usually a misconfiguration that leaks internal addresses to public dns"`
+	AddressFamily             string        `long:"address_family" env:"ADDRESS_FAMILY" description:"which addresses to publish: only ipv4 (A records), only ipv6 (AAAA records), or both" choice:"ipv4" choice:"ipv6" choice:"dual" default:"dual"`
+	RejectPublicInternal      bool          `long:"reject_public_internal" env:"REJECT_PUBLIC_INTERNAL" description:"don't publish internal addresses that nodes report but that can be reached from the internet; for split-horizon setups, where the internal record is in an internal zone"`
+	ValidateExternal          bool          `long:"validate_external" env:"VALIDATE_EXTERNAL" description:"don't publish external addresses that nodes report but that can't be reached from the internet, like private, loopback, link-local, and documentation addresses"`
+	IncludeCIDRs              []string      `long:"include_cidr" env:"INCLUDE_CIDRS" env-delim:"," description:"only publish the addresses (of every kind) that nodes report in this network; may be repeated"`
+	ExcludeCIDRs              []string      `long:"exclude_cidr" env:"EXCLUDE_CIDRS" env-delim:"," description:"don't publish the addresses (of every kind) that nodes report in this network; may be repeated"`
+	AnnouncedCIDRs            []string      `long:"external_announced_cidr" env:"EXTERNAL_ANNOUNCED_CIDRS" env-delim:"," description:"only publish external addresses that nodes report in this network, like the prefixes that your network announces; may be repeated"`
+
+	VIPAnnotations []string `long:"vip_annotation" env:"VIP_ANNOTATIONS" env-delim:"," description:"a node annotation containing a comma-separated list of virtual addresses (from kube-vip, keepalived, etc.) to publish for the node; may be repeated"`
+	VIPRecord      string   `long:"vip_record" env:"VIP_RECORD" description:"which record virtual addresses are published in" choice:"internal" choice:"external" choice:"overlay" default:"external"`
+	AddrAnnotation string   `long:"address_annotation" env:"ADDRESS_ANNOTATION" description:"the node annotations whose comma-separated addresses replace the ones the node reports, or, if the list starts with +, are added to them; {kind} is replaced with internal, external, or overlay" default:"nodedns.jrockway.io/{kind}-ip"`
+	PinAnnotation  string   `long:"pin_annotation" env:"PIN_ANNOTATION" description:"a node annotation containing a comma-separated list of addresses to publish for as long as the node exists, even if it isn't ready; prefix an address with internal: or overlay: to pin it in that record instead of the external record"`
+	PinConfigMap   string   `long:"pin_configmap" env:"PIN_CONFIGMAP" description:"a configmap (namespace/name) of addresses to always publish, regardless of the nodes; keys are <kind> for the records configured with flags, or <store>.<kind>, and values are comma-separated addresses"`
+	VIPReplace     bool     `long:"vip_replace" env:"VIP_REPLACE" description:"publish a node's virtual addresses instead of its own addresses in --vip_record, rather than in addition to them"`
+
+	StatusConfigMap  string        `long:"status_configmap" env:"STATUS_CONFIGMAP" description:"a configmap (namespace/name) to keep the status of every store in, for kubectl; events are also recorded on it when records are updated, or updates fail"`
+	StatusInterval   time.Duration `long:"status_configmap_interval" env:"STATUS_CONFIGMAP_INTERVAL" description:"how often to write --status_configmap, if any record was updated" default:"10s"`
+	RecordsConfigMap string        `long:"records_configmap" env:"RECORDS_CONFIGMAP" description:"a configmap (namespace/name) to keep the addresses in every record in, for workloads that can't use dns; keys are <kind> for the records configured with flags, or <store>.<kind>, and values are comma-separated addresses"`
+
+	PublicIPSource   string        `long:"public_ip_source" env:"PUBLIC_IP_SOURCE" description:"discover the cluster's public address with a STUN server (stun:<host>:<port>) or a \"what's my ip\" url, and publish it in the external record for nodes that only have internal addresses"`
+	PublicIPInterval time.Duration `long:"public_ip_interval" env:"PUBLIC_IP_INTERVAL" description:"how often to rediscover the public address" default:"5m"`
+}
+
+type agentflags struct {
+	NodeName string `long:"agent_node_name" env:"NODE_NAME" description:"run as a per-node agent on this node, maintaining the node's own records; usually set from the downward api"`
+	Internal string `long:"agent_internal_domain" env:"AGENT_INTERNAL_DOMAIN" description:"in agent mode, the dns record that will store this node's internal addresses; {node} is replaced with the node name" default:"{node}.internal"`
+	External string `long:"agent_external_domain" env:"AGENT_EXTERNAL_DOMAIN" description:"in agent mode, the dns record that will store this node's external addresses; {node} is replaced with the node name" default:"{node}.external"`
+	Overlay  string `long:"agent_overlay_domain" env:"AGENT_OVERLAY_DOMAIN" description:"in agent mode, the dns record that will store this node's overlay addresses; {node} is replaced with the node name"`
+}
+
+type probeflags struct {
+	Probes         []string      `long:"probe" env:"PROBES" env-delim:"," description:"only publish addresses that pass this probe; tcp:<port>, http:<port><path>, https:<port><path>, or icmp; may be repeated"`
+	Internal       []string      `long:"internal_probe" env:"INTERNAL_PROBES" env-delim:"," description:"like --probe, but only for the internal record"`
+	External       []string      `long:"external_probe" env:"EXTERNAL_PROBES" env-delim:"," description:"like --probe, but only for the external record"`
+	Overlay        []string      `long:"overlay_probe" env:"OVERLAY_PROBES" env-delim:"," description:"like --probe, but only for the overlay record"`
+	Timeout        time.Duration `long:"probe_timeout" env:"PROBE_TIMEOUT" description:"how long each probe may take" default:"2s"`
+	ExpectedStatus int           `long:"probe_expected_status" env:"PROBE_EXPECTED_STATUS" description:"the http status that http and https probes must return" default:"200"`
+	FailThreshold  int           `long:"probe_failure_threshold" env:"PROBE_FAILURE_THRESHOLD" description:"only stop publishing an address after it fails this many probes in a row" default:"1"`
+	PassThreshold  int           `long:"probe_success_threshold" env:"PROBE_SUCCESS_THRESHOLD" description:"only publish an address that's been failing again after it passes this many probes in a row" default:"1"`
+	Interval       time.Duration `long:"probe_interval" env:"PROBE_INTERVAL" description:"also probe the addresses of every record at this interval, between updates, and update the records right away when an address starts or stops passing; if zero, addresses are only probed at updates and resyncs"`
+}
+
+type doflags struct {
+	Verify         bool   `long:"verify_droplets" env:"VERIFY_DROPLETS" description:"check that external addresses belong to droplets in the DigitalOcean account before publishing them"`
+	Tag            string `long:"droplet_tag" env:"DROPLET_TAG" description:"only consider droplets with this tag"`
+	DropUnverified bool   `long:"drop_unverified" env:"DROP_UNVERIFIED" description:"with --verify_droplets, don't publish addresses that don't belong to a droplet; by default they are only logged"`
+
+	PollInterval time.Duration `long:"droplet_poll_interval" env:"DROPLET_POLL_INTERVAL" description:"with --source=droplets, how often to list droplets" default:"1m"`
+
+	FirewallID     string `long:"firewall_id" env:"FIREWALL_ID" description:"keep the source addresses of this cloud firewall's inbound rules in sync with the nodes' addresses"`
+	FirewallRecord string `long:"firewall_record" env:"FIREWALL_RECORD" description:"which of the nodes' addresses to allow through the firewall" choice:"internal" choice:"external" choice:"overlay" default:"external"`
+
+	LoadBalancerID string `long:"load_balancer_id" env:"LOAD_BALANCER_ID" description:"keep this load balancer's droplets in sync with the nodes that are published in the external record"`
+}
+
+type adminflags struct {
+	Issuer         string   `long:"admin_oidc_issuer" env:"ADMIN_OIDC_ISSUER" description:"serve the admin api, requiring an id token from this oidc issuer"`
+	Audience       string   `long:"admin_oidc_audience" env:"ADMIN_OIDC_AUDIENCE" description:"the audience (client id) that admin api tokens must be issued for"`
+	Allowed        []string `long:"admin_allowed_identity" env:"ADMIN_ALLOWED_IDENTITIES" env-delim:"," description:"only allow these emails (or subjects, for tokens without an email) to use the admin api; may be repeated"`
+	Kubernetes     bool     `long:"admin_kubernetes_auth" env:"ADMIN_KUBERNETES_AUTH" description:"serve the admin api, requiring a kubernetes token (like a service account token) whose user cluster rbac allows to use the action; see the readme for the rules"`
+	KubeAudiences  []string `long:"admin_kubernetes_audience" env:"ADMIN_KUBERNETES_AUDIENCES" env-delim:"," description:"with --admin_kubernetes_auth, only accept tokens issued for this audience; may be repeated"`
+	InsecureNoAuth bool     `long:"admin_insecure_no_auth" env:"ADMIN_INSECURE_NO_AUTH" description:"serve the admin api without any authentication"`
+}
+
+type handoffflags struct {
+	TakeoverFrom  string        `long:"takeover_from" env:"TAKEOVER_FROM" description:"during an upgrade, don't write anything until the nodedns whose admin api is at this url (like http://nodedns-old:8080) has handed off to this one"`
+	TokenFile     string        `long:"takeover_token_file" env:"TAKEOVER_TOKEN_FILE" description:"a file containing a bearer token for the admin api of the nodedns being taken over"`
+	Timeout       time.Duration `long:"takeover_timeout" env:"TAKEOVER_TIMEOUT" description:"how long to keep trying to take over before exiting" default:"5m"`
+	CommitTimeout time.Duration `long:"handoff_commit_timeout" env:"HANDOFF_COMMIT_TIMEOUT" description:"when handing off to another nodedns, how long to wait for it to commit before writing again" default:"30s"`
+}
+
+type watchdogflags struct {
+	Threshold  time.Duration `long:"watchdog_threshold" env:"WATCHDOG_THRESHOLD" description:"alert when a record's live dns answers differ from the desired addresses for longer than this; should be longer than the ttl; 0 disables the watchdog"`
+	Interval   time.Duration `long:"watchdog_interval" env:"WATCHDOG_INTERVAL" description:"how often the watchdog resolves records" default:"30s"`
+	Resolver   string        `long:"watchdog_resolver" env:"WATCHDOG_RESOLVER" description:"the dns server (host:port) that the watchdog queries; ideally an authoritative server for the zone; \"authoritative\" queries the nameservers of each record's zone; if empty, the system resolver is used"`
+	Webhook    string        `long:"watchdog_webhook" env:"WATCHDOG_WEBHOOK" description:"POST a json alert to this url when a record diverges, and when it recovers"`
+	LogAnswers time.Duration `long:"log_dns_answers" env:"LOG_DNS_ANSWERS" description:"log each record's live dns answers next to its desired addresses at this interval, resolving with the watchdog's resolver; 0 disables logging"`
+}
+
+type sloflags struct {
+	Threshold time.Duration `long:"slo_freshness_threshold" env:"SLO_FRESHNESS_THRESHOLD" description:"records that take longer than this to reflect a node change count against the freshness slo" default:"60s"`
+	Target    float64       `long:"slo_target" env:"SLO_TARGET" description:"the fraction of time that records must be fresh" default:"0.999"`
+	Window    time.Duration `long:"slo_window" env:"SLO_WINDOW" description:"the rolling window over which the sli and error budget are computed" default:"672h"`
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "simulate":
+			os.Exit(simulateMain(os.Args[2:]))
+		case "export":
+			os.Exit(exportMain(os.Args[2:]))
+		case "import":
+			os.Exit(importMain(os.Args[2:]))
+		case "watch":
+			os.Exit(watchMain(os.Args[2:]))
+		case "providers":
+			os.Exit(providersMain(os.Args[2:]))
+		case "rbac":
+			os.Exit(rbacMain(os.Args[2:]))
+		case "sync":
+			os.Exit(syncMain(os.Args[2:]))
+		}
+	}
+
 	server.AppName = "nodedns"
 
 	dnsCfg := new(dns.Config)
@@ -34,44 +242,1597 @@ func main() {
 	server.AddFlagGroup("Kubernetes", kf)
 	ndf := new(nodednsflags)
 	server.AddFlagGroup("NodeDNS", ndf)
+	pf := new(probeflags)
+	server.AddFlagGroup("Probes", pf)
+	df := new(doflags)
+	server.AddFlagGroup("DigitalOcean Integrations", df)
+	for _, p := range compiledProviders() {
+		server.AddFlagGroup(p.group, p.flags)
+	}
+	adf := new(adminflags)
+	server.AddFlagGroup("Admin API", adf)
+	chaosCfg := new(chaos.Config)
+	server.AddFlagGroup("Chaos", chaosCfg)
+	retryCfg := new(digitalocean.RetryConfig)
+	server.AddFlagGroup("DigitalOcean Retries", retryCfg)
+	throttleCfg := new(digitalocean.ThrottleConfig)
+	server.AddFlagGroup("DigitalOcean Throttling", throttleCfg)
+	sizeLimit := new(dns.SizeLimit)
+	server.AddFlagGroup("Record Size", sizeLimit)
+	guardCfg := new(dns.GuardConfig)
+	server.AddFlagGroup("Deletion Safety", guardCfg)
+	bf := new(budget.Config)
+	server.AddFlagGroup("API Budget", bf)
+	wf := new(watchdogflags)
+	server.AddFlagGroup("Watchdog", wf)
+	sf := new(sloflags)
+	server.AddFlagGroup("SLO", sf)
+	agf := new(agentflags)
+	server.AddFlagGroup("Agent", agf)
+	ceCfg := new(changes.CloudEventsConfig)
+	server.AddFlagGroup("CloudEvents", ceCfg)
+	arCfg := new(changes.ArchiveConfig)
+	server.AddFlagGroup("Change Archive", arCfg)
+	hf := new(handoffflags)
+	server.AddFlagGroup("Handoff", hf)
+	traceCfg := new(tracing.Config)
+	server.AddFlagGroup("Tracing", traceCfg)
+	sentryCfg := new(sentry.Config)
+	server.AddFlagGroup("Error Tracking", sentryCfg)
+	webhookCfg := new(dns.WebhookConfig)
+	server.AddFlagGroup("DNS Webhook", webhookCfg)
+	fakeCfg := new(dns.FakeConfig)
+	server.AddFlagGroup("Fake DNS", fakeCfg)
+	fileCfg := new(dns.FileConfig)
+	server.AddFlagGroup("DNS File", fileCfg)
+	// opinionated-server traces requests with its own Jaeger tracer, configured from the
+	// environment; disable it, so that everything is traced by OpenTelemetry instead.
+	os.Setenv("JAEGER_DISABLED", "true")
 	server.Setup()
 
-	tctx, c := context.WithTimeout(context.Background(), 10*time.Second)
-	dnsClient, err := dns.NewClient(tctx, dnsCfg)
-	c()
+	if bf.ID == "" {
+		bf.ID, _ = os.Hostname()
+	}
+	if bf.Zone == "" {
+		bf.Zone = dnsCfg.Zone
+	}
+	fl := allFlags{dns: dnsCfg, k: kf, nd: ndf, probe: pf, do: df, admin: adf, archive: arCfg, tracing: traceCfg, sentry: sentryCfg, webhook: webhookCfg, fake: fakeCfg, file: fileCfg, guard: guardCfg, chaos: chaosCfg, budget: bf, slo: sf, agent: agf, handoff: hf}
+	vars, err := recordVars(kf, ndf)
+	if err != nil {
+		zap.L().Fatal("problem reading record template variables", zap.Error(err))
+	}
+	fl.vars = vars
+	// The domains that don't expand are left alone, for diagnose to report.
+	for _, d := range []*string{&ndf.Internal, &ndf.External, &ndf.Overlay, &agf.Internal, &agf.External, &agf.Overlay} {
+		if expanded, err := config.ExpandRecord(*d, vars); err == nil {
+			*d = expanded
+		}
+	}
+	cfg, problems := diagnose(fl)
+	report(problems)
+
+	stopTracing, err := traceCfg.Setup(context.Background(), "nodedns")
+	if err != nil {
+		zap.L().Fatal("problem setting up tracing", zap.Error(err))
+	}
+	server.AddUnaryInterceptor(otelgrpc.UnaryServerInterceptor())
+	server.AddStreamInterceptor(otelgrpc.StreamServerInterceptor())
+
+	if chaosCfg.Enabled {
+		zap.L().Warn("chaos mode enabled; injecting faults into DigitalOcean api calls", zap.Any("config", chaosCfg))
+	}
+	transport := chaosCfg.Wrap(nil)
+	var coord *budget.Coordinator
+	if bf.Enabled {
+		coord = budget.New(*bf)
+		transport = coord.Wrap(transport)
+	}
+	// Each retry goes through the budget and chaos transports, and reports the rate limit.
+	throttle := digitalocean.NewThrottle(throttleCfg)
+	transport = throttle.Wrap(transport)
+	transport = retryCfg.Wrap(transport)
+	// doClient is used for everything that talks to DigitalOcean, except for updating records in
+	// zones that have their own tokens.
+	godoClients := newGodoClients(dnsCfg, transport)
+	doClient := godoClients.shared
+	// Without --zone, each record goes in the most specific zone that contains it, out of the
+	// zones in the account and the zones with their own tokens.
+	autoZone := dnsCfg.Zone == ""
+	var zones []string
+	selectZone := func(record string) string {
+		if zones == nil {
+			tctx, c := context.WithTimeout(context.Background(), 30*time.Second)
+			listed, err := dns.ListZones(tctx, doClient)
+			c()
+			if err != nil {
+				zap.L().Fatal("problem listing zones to select from; set --zone", zap.Error(err))
+			}
+			zones = listed
+			for zone := range dnsCfg.ZoneTokens {
+				zones = append(zones, zone)
+			}
+		}
+		zone, ok := dns.LongestZone(zones, record)
+		if !ok {
+			zap.L().Fatal("no zone contains the record; set --zone, or use a fully-qualified name", zap.String("record", record), zap.Strings("zones", zones))
+		}
+		zap.L().Info("selected zone", zap.String("record", record), zap.String("zone", zone))
+		return zone
+	}
+	if autoZone && fl.runMain() {
+		for _, d := range []struct {
+			domain  *string
+			ownZone string
+		}{{&ndf.Internal, ndf.InternalZone}, {&ndf.External, ndf.ExternalZone}, {&ndf.Overlay, ""}} {
+			domain := d.domain
+			if *domain == "" || d.ownZone != "" {
+				continue
+			}
+			zone := selectZone(*domain)
+			if dnsCfg.Zone != "" && zone != dnsCfg.Zone {
+				zap.L().Fatal("the records configured with flags are in different zones; set --zone, or publish them with rules in a config file", zap.Strings("zones", []string{dnsCfg.Zone, zone}))
+			}
+			dnsCfg.Zone = zone
+			*domain = dns.RelativeName(zone, *domain)
+		}
+	}
+	// With budget coordination, resyncs are staggered across instances instead of being left to
+	// the watchers.
+	watchResync := ndf.Resync
+	var runResyncs func(context.Context, time.Duration, func() error)
+
+	// At shutdown, watches (and resyncs) are stopped first, so that the stores stop changing; then
+	// the stores finish the updates in progress; then the background clients stop.  See drain.
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	clientCtx, stopClients := context.WithCancel(context.Background())
+	var clients sync.WaitGroup
+	background := func(f func(ctx context.Context)) {
+		clients.Add(1)
+		go func() {
+			defer clients.Done()
+			f(clientCtx)
+		}()
+	}
+	if coord != nil {
+		coord.Client = doClient
+		tctx, c := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := coord.Beat(tctx); err != nil {
+			zap.L().Warn("problem sending first api budget heartbeat", zap.Error(err))
+		}
+		c()
+		background(coord.Run)
+		watchResync = 0
+		if ndf.Resync > 0 {
+			runResyncs = coord.RunResyncs
+		}
+	}
+
+	var adminServer *admin.Server
+	paused := func() bool { return adminServer != nil && adminServer.Paused() }
+	// The gate is closed while this instance waits to take over from another, after it hands off
+	// to another, and, with --leader_elect and the reflector engine, while it isn't the leader.
+	electLeader := kf.LeaderElection && kf.Engine == "reflector" && ndf.Source == "kubernetes"
+	gate := handoff.NewGate(hf.TakeoverFrom == "" && !electLeader)
+	// With --dry_run, what each record's last update would have changed.
+	plans := new(dns.PlanLog)
+
+	runMain := fl.runMain()
+
+	changesServer := changes.NewServer()
+	server.AddService(func(s *grpc.Server) { changes.RegisterChangesServer(s, changesServer) })
+	if ceCfg.Sink != "" {
+		background(func(ctx context.Context) {
+			if err := changes.NewCloudEventsSender(*ceCfg).Run(ctx, changesServer); err != nil && ctx.Err() == nil {
+				zap.L().Error("sending cloudevents errored", zap.Error(err))
+			}
+		})
+	}
+	if ndf.ChangesStdout {
+		background(func(ctx context.Context) {
+			if err := changes.NewJSONLinesWriter(os.Stdout).Run(ctx, changesServer); err != nil && ctx.Err() == nil {
+				zap.L().Error("writing changes to stdout errored", zap.Error(err))
+			}
+		})
+	}
+	var reporter *sentry.Reporter
+	if sentryCfg.DSN != "" {
+		reporter, err = sentry.New(*sentryCfg)
+		if err != nil {
+			zap.L().Fatal("problem setting up error tracking", zap.Error(err))
+		}
+		background(reporter.Run)
+	}
+	if arCfg.Bucket != "" {
+		uploader, err := newArchiveUploader(*arCfg)
+		if err != nil {
+			zap.L().Fatal("problem setting up change archive", zap.Error(err))
+		}
+		background(func(ctx context.Context) {
+			if err := changes.NewArchiver(*arCfg, uploader).Run(ctx, changesServer); err != nil && ctx.Err() == nil {
+				zap.L().Error("archiving changes errored", zap.Error(err))
+			}
+		})
+	}
+
+	var auditLog *audit.Log
+	if ndf.AuditLog != "" {
+		auditLog, err = audit.Open(ndf.AuditLog)
+		if err != nil {
+			zap.L().Fatal("problem opening audit log", zap.Error(err))
+		}
+	}
+
+	factory := &providerFactory{nd: ndf, guard: guardCfg, webhook: webhookCfg, fake: fakeCfg, file: fileCfg, godo: godoClients.zone}
+	if reporter != nil {
+		factory.onDrift = reportDrift(reporter)
+	}
+	if auditLog != nil {
+		factory.onMutation = auditLog.Record
+	}
+	newProvider := factory.newProvider
+
+	// mainRecords are the records configured with flags; dnsClient publishes the records in --zone
+	// whose names depend on nodes and Services.
+	var mainRecords *nodedns.Records
+	var dnsClient dns.Provider
+	if runMain {
+		mainRecords, err = newMainRecords(context.Background(), factory, dnsCfg, ndf)
+		if err != nil {
+			zap.L().Fatal("problem initializing dns provider", zap.Error(err))
+		}
+		dnsClient = mainRecords.Default
+	}
+
+	var verifier *digitalocean.Verifier
+	if df.Verify {
+		verifier = digitalocean.NewVerifier(doClient, df.Tag)
+	}
+	var firewall *digitalocean.FirewallSync
+	if df.FirewallID != "" {
+		firewall = digitalocean.NewFirewallSync(doClient, df.FirewallID)
+	}
+	var loadBalancer *digitalocean.LoadBalancerSync
+	if df.LoadBalancerID != "" {
+		loadBalancer = digitalocean.NewLoadBalancerSync(doClient, df.LoadBalancerID)
+	}
+
+	for _, p := range compiledProviders() {
+		if p.setup == nil {
+			continue
+		}
+		if err := p.setup(); err != nil {
+			zap.L().Fatal("problem initializing provider", zap.String("provider", p.name), zap.Error(err))
+		}
+	}
+
+	freshness := slo.New(sf.Threshold, sf.Target, sf.Window)
+	prometheus.MustRegister(freshness)
+
+	var wd *watchdog.Watchdog
+	if wf.Threshold > 0 || wf.LogAnswers > 0 {
+		wd = watchdog.New(watchdog.NewResolver(wf.Resolver), wf.Threshold, wf.Webhook)
+	}
+	if wf.Threshold > 0 {
+		background(func(ctx context.Context) { wd.Run(ctx, wf.Interval) })
+	}
+	if wf.LogAnswers > 0 {
+		background(func(ctx context.Context) { wd.RunLogger(ctx, wf.LogAnswers) })
+	}
+
+	overlayNetworks, err := parseNetworks(ndf.OverlayCIDRs)
 	if err != nil {
-		zap.L().Fatal("problem initializing DigitalOcean client", zap.Error(err))
+		zap.L().Fatal("problem parsing overlay network", zap.Error(err))
 	}
+	policy, err := newStorePolicy(ndf)
+	if err != nil {
+		zap.L().Fatal("problem parsing node policy", zap.Error(err))
+	}
+	policy.SampleReconciles = traceCfg.Sampler()
 
+	probers := newProbers("", pf)
 	ns := k8s.NewNodeStore("main")
-	ns.OnChange = func(req k8s.UpdateRequest) {
-		var err error
-		ips := req.Record.IPs
-		if req.Record.IsInternal {
-			zap.L().Info("current internal addresses", zap.Any("addresses", ips))
-			if !ndf.IsDryRun {
-				err = dnsClient.UpdateDNS(req.Ctx, ndf.Internal, ips)
+	// The probers of each store, for --probe_interval.
+	probed := map[*k8s.NodeStore]map[k8s.Kind]*probe.Prober{ns: probers}
+	policy.Apply(ns)
+	if ndf.Overlay != "" {
+		ns.OverlayNetworks = overlayNetworks
+		ns.OverlayAnnotation = ndf.OverlayAnnotation
+	}
+	var watched []watchedStore
+	if runMain {
+		watched = append(watched, watchedStore{store: ns})
+	}
+	// Stores and rules from the config file, and the agent's records, each get their own
+	// NodeStore.
+	var publishers []*storePublisher
+	// storeRecords returns the records that a store publishes for its rules.
+	storeRecords := func(name string, probers map[k8s.Kind]*probe.Prober, rules []config.Rule) ([]publishedRecord, error) {
+		var result []publishedRecord
+		for _, r := range rules {
+			kind := k8s.Kind(r.Class)
+			zone, ttl, provider := dnsCfg.Zone, dnsCfg.TTL, ndf.DNSProvider
+			if r.Provider != "" {
+				provider = r.Provider
+			}
+			if r.Zone != "" {
+				zone = r.Zone
+			} else if autoZone && provider == "digitalocean" {
+				zone = selectZone(r.Record)
+				r.Record = dns.RelativeName(zone, r.Record)
 			}
-		} else {
-			zap.L().Info("current external addresses", zap.Any("addresses", ips))
-			if !ndf.IsDryRun {
-				err = dnsClient.UpdateDNS(req.Ctx, ndf.External, ips)
+			if r.TTL.Duration != 0 {
+				ttl = r.TTL.Duration
 			}
+			tctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			client, err := newProvider(tctx, provider, dns.ProviderOptions{Zone: zone, TTL: ttl, Family: r.Family})
+			cancel()
+			if err != nil {
+				return nil, fmt.Errorf("initialize dns provider %s for %s: %w", provider, r.Record, err)
+			}
+			result = append(result, publishedRecord{
+				kind:   kind,
+				family: r.Family,
+				name:   r.Record,
+				client: client,
+				prober: probers[kind],
+			})
+		}
+		return result, nil
+	}
+	// configureStore sets up a store to publish its records.  When the store is running, it must
+	// be called from k8s.NodeStore.Reconfigure.
+	configureStore := func(st *k8s.NodeStore, p *storePublisher, rules []config.Rule, records []publishedRecord) {
+		st.Names, st.OverlayNetworks, st.OverlayAnnotation = nil, nil, ""
+		for _, r := range rules {
+			st.Names = append(st.Names, r.Nodes...)
+			if k8s.Kind(r.Class) == k8s.Overlay {
+				st.OverlayNetworks = overlayNetworks
+				st.OverlayAnnotation = ndf.OverlayAnnotation
+			}
+		}
+		names := make(map[k8s.Kind][]string)
+		for _, r := range records {
+			names[r.kind] = append(names[r.kind], r.client.FQDN(r.name))
+		}
+		st.RecordNames = names
+		p.setRecords(records)
+	}
+	newStore := func(name string, rules []config.Rule) (*k8s.NodeStore, *storePublisher, error) {
+		st := k8s.NewNodeStore(name)
+		policy.Apply(st)
+		p := &storePublisher{
+			name:      name,
+			probers:   newProbers(name+".", pf),
+			watchdog:  wd,
+			freshness: freshness,
+			sizeLimit: sizeLimit,
+			dryRun:    ndf.IsDryRun,
+			plans:     plans,
+			audit:     ndf.Audit,
+			paused:    paused,
+			gate:      gate,
+			throttle:  throttle,
+		}
+		records, err := storeRecords(name, p.probers, rules)
+		if err != nil {
+			return nil, nil, err
+		}
+		configureStore(st, p, rules, records)
+		st.Subscribe(p, changesServer.DynamicSink(name, st.PublishedIn))
+		probed[st] = p.probers
+		return st, p, nil
+	}
+	// The stores from the config file are reconfigured when it's reloaded; see watchConfig.
+	reloadable := make(map[string]*reloadableStore)
+	for _, c := range configStores(cfg) {
+		st, p, err := newStore(c.name, c.rules)
+		if err != nil {
+			zap.L().Fatal("problem initializing dns provider", zap.String("store", c.name), zap.Error(err))
+		}
+		publishers = append(publishers, p)
+		reloadable[c.name] = &reloadableStore{configStore: c, store: st, publisher: p}
+		watched = append(watched, watchedStore{store: st, selector: c.selector})
+	}
+	var agent *k8s.NodeStore
+	// agentPublisher publishes the agent's records, whose names are agentTemplates with {node}
+	// replaced, in the same order.
+	var agentPublisher *storePublisher
+	var agentTemplates []string
+	if agf.NodeName != "" {
+		var rules []config.Rule
+		for _, r := range []struct{ class, record string }{
+			{config.ClassInternal, agf.Internal},
+			{config.ClassExternal, agf.External},
+			{config.ClassOverlay, agf.Overlay},
+		} {
+			if r.record != "" {
+				rules = append(rules, config.Rule{Name: "agent", Class: r.class, Record: strings.ReplaceAll(r.record, "{node}", agf.NodeName)})
+				agentTemplates = append(agentTemplates, r.record)
+			}
+		}
+		st, p, err := newStore("agent", rules)
+		if err != nil {
+			zap.L().Fatal("problem initializing dns provider", zap.String("store", "agent"), zap.Error(err))
+		}
+		agent, agentPublisher = st, p
+		publishers = append(publishers, p)
+	}
+	// rs is every record that this instance publishes; the sinks whose records depend on the
+	// nodes or Services are added as they start.
+	rs := &recordSet{
+		main:           mainRecords,
+		zone:           dnsCfg.Zone,
+		publishers:     publishers,
+		agent:          agentPublisher,
+		agentTemplates: agentTemplates,
+		nodeNames: func(ctx context.Context) ([]string, error) {
+			clientset, err := k8s.Clientset(kf.Master, kf.Kubeconfig)
+			if err != nil {
+				return nil, fmt.Errorf("connect to kubernetes: %w", err)
+			}
+			return k8s.NodeNames(ctx, clientset)
+		},
+		paused:     paused,
+		dryRun:     ndf.IsDryRun,
+		audit:      ndf.Audit,
+		createOnly: ndf.CreateOnly,
+		gate:       gate,
+	}
+	exportRecords := rs.export
+	var stores storeSet
+	for _, w := range watched {
+		stores = append(stores, w.store)
+	}
+	if agent != nil {
+		stores = append(stores, agent)
+	}
+	if ndf.PendingDir != "" {
+		for _, st := range stores {
+			st.PendingPath = filepath.Join(ndf.PendingDir, st.Name+".json")
+			if err := st.LoadPending(); err != nil {
+				zap.L().Warn("problem loading pending updates; they won't be retried until the next resync", zap.String("store", st.Name), zap.Error(err))
+			}
+		}
+	}
+	if reporter != nil {
+		for _, st := range stores {
+			st.OnFailure = reportFailures(reporter, st, ndf.MaxFailures)
+		}
+	}
+	if ndf.StatusConfigMap != "" {
+		clientset, err := k8s.Clientset(kf.Master, kf.Kubeconfig)
+		if err != nil {
+			zap.L().Fatal("problem creating status configmap client", zap.Error(err))
+		}
+		namespace, name := splitConfigMap(ndf.StatusConfigMap)
+		status := k8s.NewStatusConfigMap(clientset, namespace, name, stores)
+		status.Interval, status.Enabled = ndf.StatusInterval, gate.IsOpen
+		for _, st := range stores {
+			st.OnUpdate = status.OnUpdate(st)
+		}
+		go status.Run(watchCtx)
+	}
+	if ndf.RecordsConfigMap != "" {
+		clientset, err := k8s.Clientset(kf.Master, kf.Kubeconfig)
+		if err != nil {
+			zap.L().Fatal("problem creating records configmap client", zap.Error(err))
+		}
+		namespace, name := splitConfigMap(ndf.RecordsConfigMap)
+		records := k8s.NewRecordConfigMap(clientset, namespace, name)
+		// Like the integrations, the configmap isn't changed while updates are paused, in a dry
+		// run or audit, or while another instance is writing.
+		records.Enabled = func() bool { return !paused() && !ndf.IsDryRun && !ndf.Audit && gate.IsOpen() }
+		for _, st := range stores {
+			st.Subscribe(records.Sink(st.Name))
+		}
+	}
+	http.Handle("/healthz/sinks", k8s.HealthHandler(stores, ndf.MaxFailures))
+	// With controller-runtime's leader election, followers don't watch nodes, so they pass both
+	// probes until they're elected.
+	var elected int32
+	if !(ndf.Source == "kubernetes" && kf.Engine == "controller-runtime" && kf.LeaderElection) {
+		elected = 1
+	}
+	standby := func() bool { return atomic.LoadInt32(&elected) == 0 }
+	http.Handle("/healthz/ready", k8s.ProbeHandler(func() error {
+		if standby() {
+			return nil
+		}
+		return stores.Ready(ns)
+	}))
+	http.Handle("/healthz/live", k8s.ProbeHandler(func() error {
+		if standby() || ndf.LiveResyncs <= 0 || ndf.Resync <= 0 {
+			return nil
+		}
+		return stores.Live(time.Duration(ndf.LiveResyncs) * ndf.Resync)
+	}))
+	http.Handle("/debug/stores", k8s.StatusHandler(stores))
+	if ndf.IsDryRun {
+		http.Handle("/debug/plan", plans)
+	}
+	// /metrics doesn't speak OpenMetrics, which is the only format that carries exemplars.
+	http.Handle("/metrics/openmetrics", promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+	if adf.Issuer != "" || adf.Kubernetes || adf.InsecureNoAuth {
+		var auth admin.Authenticator
+		switch {
+		case adf.Issuer != "":
+			tctx, c := context.WithTimeout(context.Background(), 30*time.Second)
+			auth, err = admin.NewOIDC(tctx, adf.Issuer, adf.Audience, adf.Allowed)
+			c()
+			if err != nil {
+				zap.L().Fatal("problem configuring admin api authentication", zap.Error(err))
+			}
+		case adf.Kubernetes:
+			clientset, err := k8s.Clientset(kf.Master, kf.Kubeconfig)
+			if err != nil {
+				zap.L().Fatal("problem configuring admin api authentication", zap.Error(err))
+			}
+			auth = admin.NewKubernetes(clientset, adf.KubeAudiences)
+		}
+		adminServer = admin.NewServer(stores, auth)
+		adminServer.Handoff = handoff.NewSource(gate, exportRecords, hf.CommitTimeout)
+		adminServer.Status = stores.Status
+		server.SetHTTPHandler(otelhttp.NewHandler(adminServer.Handler(), "admin"))
+	}
+
+	ns.Subscribe(k8s.SinkFunc("dns", func(req k8s.UpdateRequest) error {
+		var err error
+		domain := mainRecords.Name(req.Record.Kind)
+		client := mainRecords.Provider(req.Record.Kind)
+		if !ndf.IsDryRun && !ndf.Audit && domain != "" {
+			freshness.Changed(client.FQDN(domain))
+		}
+		if paused() {
+			zap.L().Info("updates paused; not updating "+string(req.Record.Kind)+" record", zap.Any("addresses", req.Record.IPs), correlation.Field(req.Ctx))
+			return k8s.ErrDeferred
+		}
+		if !gate.Enter() {
+			zap.L().Info("not writing during handoff; not updating "+string(req.Record.Kind)+" record", zap.Any("addresses", req.Record.IPs), correlation.Field(req.Ctx))
+			return k8s.ErrDeferred
+		}
+		defer gate.Exit()
+		if err := deferUntilReset(throttle, req); err != nil {
+			return err
+		}
+		probers[req.Record.Kind].Prune(req.Record.IPs)
+		ips := probers[req.Record.Kind].Filter(req.Ctx, req.Record.IPs)
+		if verifier != nil && req.Record.Kind == k8s.External {
+			unknown, err := verifier.Verify(req.Ctx, ips)
+			if err != nil {
+				zap.L().Warn("problem verifying addresses against droplets", zap.Error(err))
+			} else if df.DropUnverified {
+				ips = without(ips, unknown)
+			}
+		}
+		if domain != "" {
+			ips = sizeLimit.Apply(client.FQDN(domain), ips)
 		}
+		zap.L().Info("current "+string(req.Record.Kind)+" addresses", zap.Any("addresses", ips), correlation.Field(req.Ctx))
 		if ndf.IsDryRun {
-			err = errors.New("dry_run enabled; not actually updating")
+			if domain != "" {
+				planUpdate(req.Ctx, zap.L().With(correlation.Field(req.Ctx)), plans, client, domain, ips)
+			}
+			return nil
+		}
+		err = client.UpdateDNS(req.Ctx, domain, ips)
+		if domain != "" {
+			wd.Desired(client.FQDN(domain), ips, err)
+			if err == nil {
+				freshness.Synced(client.FQDN(domain))
+			}
 		}
 		if err != nil {
-			zap.L().Error("problem updating dns", zap.Error(err))
+			zap.L().Error("problem updating dns", correlation.Field(req.Ctx), zap.Error(err))
 		}
+		return err
+	}))
+
+	// publishRecord makes a change to one of the records that the per-node, topology, service, and
+	// nodeport sinks publish by name, with update.  Like the main records, the change is deferred
+	// while updates are paused, in a dry run, or while this instance isn't writing because it's
+	// a standby or of a handoff.
+	publishRecord := func(ctx context.Context, kind, fqdn string, contents zap.Field, update func() error) error {
+		l := zap.L().With(zap.String("record", fqdn), contents, correlation.Field(ctx))
+		switch {
+		case paused():
+			l.Info("updates paused; not updating " + kind + " record")
+			return k8s.ErrDeferred
+		case ndf.IsDryRun:
+			l.Info("dry run; not updating " + kind + " record")
+			return k8s.ErrDeferred
+		case standby():
+			// Every replica watches Services, but only the leader writes their records.
+			return k8s.ErrDeferred
+		case !gate.Enter():
+			l.Info("not writing during handoff; not updating " + kind + " record")
+			return k8s.ErrDeferred
+		}
+		defer gate.Exit()
+		if err := update(); err != nil {
+			l.Error("problem updating "+kind+" record", zap.Error(err))
+			return err
+		}
+		return nil
 	}
 
-	go func() {
-		ctx := context.Background()
-		if err := k8s.WatchNodes(ctx, kf.Master, kf.Kubeconfig, ndf.Resync, ns); err != nil {
-			zap.L().Fatal("watch nodes errored", zap.Error(err))
+	// perNode and topology publish records whose names depend on the nodes; see collectOrphans.
+	var perNode *k8s.PerNode
+	var topology *k8s.Topology
+	configured := rs.configured
+	if ndf.PerNodeTemplate != "" && dnsClient != nil {
+		tmpl, err := k8s.ParsePerNodeTemplate(ndf.PerNodeTemplate)
+		if err != nil {
+			zap.L().Fatal("problem parsing --per_node_domain_template", zap.Error(err))
 		}
-	}()
+		// Each node's record is emptied when the node goes away, which the guard would refuse, so
+		// the guard only refuses emptying too many of the records at once.
+		dnsClient, guard := unguarded(dnsClient, "per-node records")
+		perNode = &k8s.PerNode{Template: tmpl, Kind: k8s.Kind(ndf.PerNodeRecord)}
+		perNode.Guard = guard
+		perNode.Publish = func(ctx context.Context, name string, ips []net.IP) error {
+			name = dns.RelativeName(dnsCfg.Zone, name)
+			return publishRecord(ctx, "per-node", dnsClient.FQDN(name), zap.Any("addresses", ips), func() error {
+				return dnsClient.UpdateDNS(ctx, name, ips)
+			})
+		}
+		seedPublished(dnsClient, "per-node records", ndf.UpdateTimeout, perNode.Seed, configured)
+		ns.Subscribe(perNode)
+	}
+
+	if ndf.TopologyTemplate != "" && dnsClient != nil {
+		var err error
+		topology, err = k8s.NewTopology(ndf.TopologyTemplate, k8s.Kind(ndf.TopologyRecord))
+		if err != nil {
+			zap.L().Fatal("problem parsing --topology_domain_template", zap.Error(err))
+		}
+		// A zone's record is emptied when its last node goes away, which the guard would refuse,
+		// so the guard only refuses emptying too many of the records at once.
+		dnsClient, guard := unguarded(dnsClient, "topology records")
+		topology.Guard = guard
+		topology.Publish = func(ctx context.Context, name string, ips []net.IP) error {
+			name = dns.RelativeName(dnsCfg.Zone, name)
+			return publishRecord(ctx, "topology", dnsClient.FQDN(name), zap.Any("addresses", ips), func() error {
+				return dnsClient.UpdateDNS(ctx, name, ips)
+			})
+		}
+		seedPublished(dnsClient, "topology records", ndf.UpdateTimeout, topology.Seed, func(name string) bool {
+			return configured(name) || (perNode != nil && perNode.Matches(name))
+		})
+		ns.Subscribe(topology)
+	}
 
+	// integration returns a sink that calls sync with changes to the record of the provided kind,
+	// unless this is a dry run or audit, or updates are paused or this instance isn't writing
+	// because of a handoff, which defer the change.
+	integration := func(name string, kind k8s.Kind, sync func(req k8s.UpdateRequest) error) k8s.Sink {
+		return k8s.SinkFunc(name, func(req k8s.UpdateRequest) error {
+			if req.Record.Kind != kind || ndf.IsDryRun || ndf.Audit {
+				return nil
+			}
+			if paused() || !gate.Enter() {
+				return k8s.ErrDeferred
+			}
+			defer gate.Exit()
+			if err := sync(req); err != nil {
+				zap.L().Error("problem updating "+name, correlation.Field(req.Ctx), zap.Error(err))
+				return err
+			}
+			return nil
+		})
+	}
+	if firewall != nil {
+		ns.Subscribe(integration("firewall", k8s.Kind(df.FirewallRecord), func(req k8s.UpdateRequest) error {
+			return firewall.Sync(req.Ctx, req.Record.IPs)
+		}))
+	}
+	if loadBalancer != nil {
+		ns.Subscribe(integration("load_balancer", k8s.External, func(req k8s.UpdateRequest) error {
+			var ids []int
+			for _, n := range req.Nodes {
+				if len(n.External) == 0 {
+					continue
+				}
+				id, err := digitalocean.DropletID(n.ProviderID)
+				if err != nil {
+					zap.L().Warn("not adding node to load balancer", zap.String("node", n.Name), zap.Error(err))
+					continue
+				}
+				ids = append(ids, id)
+			}
+			return loadBalancer.Sync(req.Ctx, ids)
+		}))
+	}
+	for _, p := range compiledProviders() {
+		if p.sinks == nil {
+			continue
+		}
+		for _, sink := range p.sinks(integration) {
+			ns.Subscribe(sink)
+		}
+	}
+	if dnsClient != nil {
+		records := mainRecords.FQDNs()
+		ns.RecordNames = records
+		ns.Subscribe(changesServer.Sink(ns.Name, records))
+	}
+
+	if hf.TakeoverFrom != "" {
+		go takeover(hf, exportRecords, gate, stores)
+	}
+	if electLeader {
+		// Every replica watches nodes and keeps its records up to date, but only the leader
+		// writes them; a new leader publishes everything it has.
+		go func() {
+			l := zap.L().Named("leader")
+			if err := k8s.RunLeaderElection(watchCtx, k8s.LeaderConfig{
+				Master:     kf.Master,
+				Kubeconfig: kf.Kubeconfig,
+				Namespace:  kf.LeaderElectionNamespace,
+				Name:       kf.LeaderElectionID,
+				Lead: func() {
+					gate.Open()
+					if err := stores.Resync(); err != nil {
+						l.Error("problem publishing records after becoming the leader; they'll be published at the next resync", zap.Error(err))
+					}
+				},
+				Follow: gate.Close,
+			}); err != nil {
+				l.Fatal("leader election errored", zap.Error(err))
+			}
+		}()
+	}
+
+	if ndf.PublicIPSource != "" {
+		d, err := publicip.New(ndf.PublicIPSource)
+		if err != nil {
+			zap.L().Fatal("problem configuring public ip discovery", zap.Error(err))
+		}
+		// Like the watches, discovery stops before the stores drain.
+		go discoverPublicIP(watchCtx, d, ndf.PublicIPInterval, stores)
+	}
+
+	if ndf.Source == "kubernetes" {
+		go func() {
+			if err := k8s.WatchNodeClaims(watchCtx, kf.Master, kf.Kubeconfig, ndf.Resync, stores); err != nil {
+				zap.L().Error("watch karpenter nodeclaims errored", zap.Error(err))
+			}
+		}()
+	}
+	if ndf.PinConfigMap != "" {
+		namespace, name := splitConfigMap(ndf.PinConfigMap)
+		go func() {
+			if err := k8s.WatchPinned(watchCtx, kf.Master, kf.Kubeconfig, namespace, name, ndf.Resync, stores); err != nil {
+				zap.L().Error("watch pinned addresses errored", zap.Error(err))
+			}
+		}()
+	}
+
+	var services *k8s.ServiceRecords
+	if kf.Services && dnsClient != nil {
+		// A record is emptied when its Services go away, which the guard would refuse, so the
+		// guard only refuses emptying too many of the records at once.
+		dnsClient, guard := unguarded(dnsClient, "service records")
+		services = &k8s.ServiceRecords{Annotation: kf.ServiceAnnotation}
+		services.Guard = guard
+		services.Publish = func(ctx context.Context, name string, ips []net.IP) error {
+			record := dns.RelativeName(dnsCfg.Zone, name)
+			if !strings.EqualFold(dnsClient.FQDN(record), name) {
+				zap.L().Warn("not publishing a service record outside of the zone", zap.String("record", name), zap.String("zone", dnsCfg.Zone), correlation.Field(ctx))
+				return nil
+			}
+			return publishRecord(ctx, "service", name, zap.Any("addresses", ips), func() error {
+				return dnsClient.UpdateDNS(ctx, record, ips)
+			})
+		}
+		go func() {
+			if err := k8s.WatchServices(watchCtx, kf.Master, kf.Kubeconfig, ndf.Resync, services); err != nil {
+				zap.L().Error("watch services errored", zap.Error(err))
+			}
+		}()
+	}
+
+	var nodePorts *k8s.NodePortSRV
+	if len(kf.NodePortSRV) > 0 && dnsClient != nil {
+		dnsClient, guard := unguarded(dnsClient, "nodeport srv records")
+		srvClient, ok := dnsClient.(dns.SRVUpdater)
+		if !ok {
+			zap.L().Fatal("--nodeport_srv: the dns provider doesn't publish srv records", zap.String("provider", ndf.DNSProvider))
+		}
+		target := kf.NodePortSRVTarget
+		if target == "" {
+			target = ndf.External
+		}
+		if target == "" {
+			zap.L().Fatal("--nodeport_srv requires --nodeport_srv_target or --external_domain")
+		}
+		target = dnsClient.FQDN(target)
+		ports := make(map[string]k8s.ServicePort)
+		for name, spec := range kf.NodePortSRV {
+			port, err := k8s.ParseServicePort(spec)
+			if err != nil {
+				zap.L().Fatal("parse --nodeport_srv", zap.String("record", name), zap.Error(err))
+			}
+			ports[name] = port
+		}
+		nodePorts = &k8s.NodePortSRV{Ports: ports, Guard: guard, Publish: func(ctx context.Context, name string, port int32) error {
+			var srvs []dns.SRV
+			if port != 0 {
+				srvs = []dns.SRV{{Port: int(port), Target: target}}
+			}
+			return publishRecord(ctx, "nodeport srv", name, zap.Any("srvs", srvs), func() error {
+				return srvClient.UpdateSRV(ctx, dns.RelativeName(dnsCfg.Zone, name), srvs)
+			})
+		}}
+		go func() {
+			if err := k8s.WatchServices(watchCtx, kf.Master, kf.Kubeconfig, ndf.Resync, nodePorts); err != nil {
+				zap.L().Error("watch services for nodeports errored", zap.Error(err))
+			}
+		}()
+	}
+
+	if pf.Interval > 0 {
+		for st, probers := range probed {
+			go reprobe(watchCtx, pf.Interval, st, probers)
+		}
+	}
+
+	if agent != nil {
+		// The agent watches its own node regardless of leader election.
+		if runResyncs != nil {
+			go runResyncs(watchCtx, ndf.Resync, agent.Resync)
+		}
+		go func() {
+			if err := k8s.WatchNode(watchCtx, kf.Master, kf.Kubeconfig, agf.NodeName, watchResync, agent); err != nil {
+				zap.L().Error("watch node errored", zap.String("node", agf.NodeName), zap.Error(err))
+			}
+		}()
+	}
+
+	if ndf.Source == "kubernetes" && kf.Engine == "controller-runtime" {
+		// controller-runtime keeps its metrics in its own registry.
+		http.Handle("/metrics/controller-runtime", promhttp.HandlerFor(ctrlmetrics.Registry, promhttp.HandlerOpts{}))
+		var selected []k8s.SelectedStore
+		for _, w := range watched {
+			selected = append(selected, k8s.SelectedStore{Store: w.store, Selector: w.selector})
+		}
+		go func() {
+			if err := k8s.RunController(watchCtx, k8s.ControllerConfig{
+				Master:                  kf.Master,
+				Kubeconfig:              kf.Kubeconfig,
+				Resync:                  ndf.Resync,
+				RunResyncs:              runResyncs,
+				LeaderElection:          kf.LeaderElection,
+				LeaderElectionNamespace: kf.LeaderElectionNamespace,
+				LeaderElectionID:        kf.LeaderElectionID,
+				OnElected:               func() { atomic.StoreInt32(&elected, 1) },
+			}, selected); err != nil {
+				zap.L().Fatal("controller errored", zap.Error(err))
+			}
+		}()
+		watched = nil
+	}
+	clusters, err := clusterConfigs(kf.Clusters)
+	if err != nil {
+		zap.L().Fatal("problem configuring clusters", zap.Error(err))
+	}
+	// startWatch watches the store's nodes until the returned function is called.
+	startWatch := func(w watchedStore) context.CancelFunc {
+		ctx, cancel := context.WithCancel(watchCtx)
+		// Each cluster is added to the store before its watch starts, so that the store isn't
+		// synced until every cluster's nodes have been listed.
+		views := make(map[string]cache.Store)
+		if ndf.Source == "kubernetes" {
+			for name := range clusters {
+				views[name] = w.store.Cluster(name)
+			}
+		}
+		go func() {
+			if runResyncs != nil {
+				go runResyncs(ctx, ndf.Resync, w.store.Resync)
+			}
+			switch ndf.Source {
+			case "kubernetes":
+				// Each cluster has its own reflector, so that one cluster's API server being
+				// unavailable doesn't hold up the others.
+				for name, config := range clusters {
+					go func(name string, config *rest.Config) {
+						if err := k8s.WatchNodesWithConfig(ctx, config, w.selector, watchResync, views[name]); err != nil {
+							zap.L().Error("watch cluster nodes errored", zap.String("store", w.store.Name), zap.String("cluster", name), zap.Error(err))
+						}
+					}(name, config)
+				}
+				if err := k8s.WatchNodes(ctx, kf.Master, kf.Kubeconfig, w.selector, watchResync, w.store); err != nil {
+					zap.L().Fatal("watch nodes errored", zap.String("store", w.store.Name), zap.Error(err))
+				}
+			case "droplets":
+				if err := digitalocean.WatchDroplets(ctx, doClient, df.Tag, df.PollInterval, watchResync, w.store); err != nil && ctx.Err() == nil {
+					zap.L().Fatal("watch droplets errored", zap.Error(err))
+				}
+			}
+		}()
+		return cancel
+	}
+	for _, w := range watched {
+		stop := startWatch(w)
+		for _, rs := range reloadable {
+			if rs.store == w.store {
+				rs.restart = restarter(stop, startWatch, rs)
+			}
+		}
+	}
+	// reload applies the reloaded config file to the stores that it configures, without dropping
+	// the nodes that they have.
+	reload := func() {
+		if ndf.VarsNode != "" {
+			vars, err := recordVars(kf, ndf)
+			if err != nil {
+				zap.L().Warn("problem refreshing record template variables; keeping the previous ones", zap.Error(err))
+			} else {
+				fl.vars = vars
+			}
+		}
+		next, problems := diagnose(fl)
+		if len(problems) > 0 {
+			for _, p := range problems {
+				zap.L().Error("configuration problem: "+p.what, zap.Error(p.err), zap.String("fix", p.fix))
+			}
+			zap.L().Error(fmt.Sprintf("found %d configuration problems; keeping the previous configuration", len(problems)))
+			return
+		}
+		running := make(map[string]configStore, len(reloadable))
+		for name, rs := range reloadable {
+			running[name] = rs.configStore
+		}
+		changed, added, removed := configChanges(running, configStores(next))
+		if len(added) > 0 || len(removed) > 0 {
+			// Stores are shared by the health checks, the admin api, the status sinks, and
+			// the garbage collector, which all assume that the set of stores doesn't change.
+			zap.L().Warn("stores can't be added or removed without a restart; added stores won't run, and removed stores keep publishing, until then", zap.Strings("added", added), zap.Strings("removed", removed))
+		}
+		for _, c := range changed {
+			rs := reloadable[c.name]
+			l := zap.L().With(zap.String("store", c.name))
+			records, err := storeRecords(c.name, rs.publisher.probers, c.rules)
+			if err != nil {
+				l.Error("problem reloading store; keeping its previous configuration", zap.Error(err))
+				continue
+			}
+			relist := rs.relist(c)
+			rs.configStore = c
+			if err := rs.store.Reconfigure(func() { configureStore(rs.store, rs.publisher, c.rules, records) }); err != nil {
+				l.Error("problem publishing records after reloading the configuration", zap.Error(err))
+			}
+			switch {
+			case relist && rs.restart != nil:
+				l.Info("reloaded configuration; listing nodes again")
+				rs.restart()
+			case relist:
+				l.Warn("reloaded configuration; with --engine=controller-runtime, changes to the selector or nodes take effect at the next restart")
+			default:
+				l.Info("reloaded configuration")
+			}
+		}
+	}
+	var crds *crdRecords
+	if kf.NodeDNSRecords {
+		client, err := k8s.NewNodeDNSRecordClient(kf.Master, kf.Kubeconfig)
+		if err != nil {
+			zap.L().Fatal("problem creating nodednsrecord client", zap.Error(err))
+		}
+		crds = &crdRecords{
+			client:     client,
+			newStore:   newStore,
+			startWatch: startWatch,
+			gate:       gate,
+			dryRun:     ndf.IsDryRun,
+			running:    make(map[string]context.CancelFunc),
+			records:    make(map[string]*storePublisher),
+		}
+		go client.Watch(watchCtx, ndf.Resync, crds)
+	}
+	if ndf.Config != "" || ndf.AliasFile != "" {
+		go watchConfig(watchCtx, []string{ndf.Config, ndf.AliasFile}, ndf.ConfigReload, reload)
+	}
+
+	rs.perNode, rs.topology, rs.services, rs.nodePorts, rs.crds = perNode, topology, services, nodePorts, crds
+	var cleanup func(context.Context)
+	if ndf.Cleanup {
+		cleanup = func(ctx context.Context) { rs.cleanup(ctx, standby()) }
+	}
+	if ndf.GCOrphans {
+		go func() {
+			for standby() || !rs.orphansKnown(stores) || !gate.IsOpen() {
+				select {
+				case <-watchCtx.Done():
+					return
+				case <-time.After(5 * time.Second):
+				}
+			}
+			for {
+				ctx, cancel := context.WithTimeout(watchCtx, ndf.UpdateTimeout)
+				rs.collectOrphans(ctx)
+				cancel()
+				if ndf.GCInterval <= 0 {
+					return
+				}
+				select {
+				case <-watchCtx.Done():
+					return
+				case <-time.After(ndf.GCInterval):
+				}
+			}
+		}()
+	}
+
+	server.AddDrainHandler(func() {
+		drain(ndf.DrainTimeout, stopWatching, stores, cleanup, stopClients, &clients)
+		if auditLog != nil {
+			if err := auditLog.Close(); err != nil {
+				zap.L().Warn("problem closing audit log", zap.Error(err))
+			}
+		}
+		ctx, c := context.WithTimeout(context.Background(), 5*time.Second)
+		defer c()
+		if err := stopTracing(ctx); err != nil {
+			zap.L().Warn("problem sending the last traces", zap.Error(err))
+		}
+	})
 	server.ListenAndServe()
 }
+
+// clusterConfigs builds a client configuration for each cluster passed to --cluster.
+func clusterConfigs(clusters map[string]string) (map[string]*rest.Config, error) {
+	result := make(map[string]*rest.Config)
+	for name, value := range clusters {
+		kubeconfig, context := splitCluster(value)
+		config, err := k8s.ClusterConfig(kubeconfig, context)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %q: %w", name, err)
+		}
+		result[name] = config
+	}
+	return result, nil
+}
+
+// splitCluster splits a --cluster value into the kubeconfig and the (optional) context.
+func splitCluster(value string) (kubeconfig, context string) {
+	if i := strings.LastIndex(value, "@"); i >= 0 {
+		return value[:i], value[i+1:]
+	}
+	return value, ""
+}
+
+// drain stops nodedns in order, within the timeout: first the watches, then the updates that the
+// stores have in progress, then, if cleanup is non-nil, the records are cleaned up, and finally
+// the background clients are stopped.  Updates that don't finish in time are abandoned; the next
+// instance publishes every record when it starts.
+func drain(timeout time.Duration, stopWatching func(), stores storeSet, cleanup func(context.Context), stopClients func(), clients *sync.WaitGroup) {
+	l := zap.L().Named("drain")
+	ctx, c := context.WithTimeout(context.Background(), timeout)
+	defer c()
+	stopWatching()
+	for _, st := range stores {
+		if err := st.Drain(ctx); err != nil {
+			l.Warn("updates still in progress at shutdown", zap.String("store", st.Name), zap.Error(err))
+		}
+	}
+	if cleanup != nil {
+		cleanup(ctx)
+	}
+	stopClients()
+	done := make(chan struct{})
+	go func() {
+		clients.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		l.Info("drained")
+	case <-ctx.Done():
+		l.Warn("background clients still running at shutdown", zap.Error(ctx.Err()))
+	}
+}
+
+// takeover takes over writing from the instance at --takeover_from, retrying until
+// --takeover_timeout, and then opens the gate and publishes the current state of every record.
+// Reading this instance's records proves that it can reach DigitalOcean before the other instance
+// is asked to stop writing.
+func takeover(hf *handoffflags, exportRecords func(context.Context) ([]*dns.Snapshot, error), gate *handoff.Gate, stores storeSet) {
+	l := zap.L().Named("handoff").With(zap.String("from", hf.TakeoverFrom))
+	t := &handoff.Target{URL: hf.TakeoverFrom}
+	if hf.TokenFile != "" {
+		token, err := ioutil.ReadFile(hf.TokenFile)
+		if err != nil {
+			l.Fatal("problem reading takeover token", zap.Error(err))
+		}
+		t.Token = strings.TrimSpace(string(token))
+	}
+	validate := func(ctx context.Context) error {
+		_, err := exportRecords(ctx)
+		return err
+	}
+	ctx, c := context.WithTimeout(context.Background(), hf.Timeout)
+	defer c()
+	for {
+		tctx, tc := context.WithTimeout(ctx, time.Minute)
+		state, err := t.Takeover(tctx, validate)
+		tc()
+		if err == nil {
+			var records int
+			for _, s := range state.Snapshots {
+				records += len(s.Records)
+			}
+			l.Info("took over writing", zap.Time("stopped", state.Stopped), zap.Int("zones", len(state.Snapshots)), zap.Int("records", records))
+			break
+		}
+		l.Warn("problem taking over; retrying", zap.Error(err))
+		select {
+		case <-ctx.Done():
+			l.Fatal("gave up taking over; the other instance is still writing", zap.Error(err))
+		case <-time.After(5 * time.Second):
+		}
+	}
+	gate.Open()
+	if err := stores.Resync(); err != nil {
+		l.Error("problem publishing records after takeover; they'll be published at the next resync", zap.Error(err))
+	}
+}
+
+// unguarded returns the provider that a dns.Guard wraps, for sinks that publish a record per node,
+// group, or Service, and empty each one as a whole; and a sink Guard that applies the guard's
+// thresholds to the set of records instead, or nil if p isn't guarded.
+func unguarded(p dns.Provider, set string) (dns.Provider, func(published, removed int) error) {
+	g, ok := p.(*dns.Guard)
+	if !ok {
+		return p, nil
+	}
+	return g.Provider, func(published, removed int) error {
+		return g.CheckRemovals(set, published, removed)
+	}
+}
+
+// seedPublished adds the records in the provider's ownership registry that a sink could have
+// published, like before a restart, to the records that the sink has published, so that those of
+// nodes or zones that went away while nodedns wasn't running are emptied at the first update.
+// Records that skip returns true for, given the fully-qualified name, are left alone.
+func seedPublished(p dns.Provider, set string, timeout time.Duration, seed func(names []string) []string, skip func(name string) bool) {
+	c, ok := p.(dns.OrphanCollector)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	owned, err := c.Owned(ctx)
+	if err != nil {
+		zap.L().Warn("problem listing owned records; records published before a restart won't be emptied until they're published again", zap.String("records", set), zap.Error(err))
+		return
+	}
+	var names []string
+	for _, name := range owned {
+		if !skip(name) {
+			names = append(names, name)
+		}
+	}
+	if seeded := seed(names); len(seeded) > 0 {
+		zap.L().Info("seeded published records from the ownership registry", zap.String("records", set), zap.Strings("names", seeded))
+	}
+}
+
+// watchedStore is a NodeStore and the label selector that chooses its nodes.
+type watchedStore struct {
+	store    *k8s.NodeStore
+	selector string
+}
+
+// storeSet is every NodeStore that is running.
+type storeSet []*k8s.NodeStore
+
+// Resync resyncs every store.
+func (s storeSet) Resync() error {
+	for _, st := range s {
+		if err := st.Resync(); err != nil {
+			return fmt.Errorf("resync store %s: %w", st.Name, err)
+		}
+	}
+	return nil
+}
+
+// Status returns the status of every store.
+func (s storeSet) Status() []k8s.StoreStatus {
+	result := make([]k8s.StoreStatus, 0, len(s))
+	for _, st := range s {
+		result = append(result, st.Status())
+	}
+	return result
+}
+
+// Ready returns an error unless every store has received its nodes, and main's dns sink has
+// updated a record successfully.
+func (s storeSet) Ready(main *k8s.NodeStore) error {
+	for _, st := range s {
+		sink := ""
+		if st == main {
+			sink = "dns"
+		}
+		if err := st.Ready(sink); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Synced returns true once every store has published the records of a full list of its nodes.
+func (s storeSet) Synced() bool {
+	for _, st := range s {
+		if !st.Synced() {
+			return false
+		}
+	}
+	return true
+}
+
+// Live returns an error if any store's watch or reconciles have stopped for longer than maxAge.
+func (s storeSet) Live(maxAge time.Duration) error {
+	for _, st := range s {
+		if err := st.Live(maxAge); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newProbers returns a prober for each kind of record, configured by the probe flags.  Prober names
+// are prefixed with prefix.
+func newProbers(prefix string, pf *probeflags) map[k8s.Kind]*probe.Prober {
+	probers := make(map[k8s.Kind]*probe.Prober)
+	for kind, specs := range map[k8s.Kind][]string{
+		k8s.Internal: pf.Internal,
+		k8s.External: pf.External,
+		k8s.Overlay:  pf.Overlay,
+	} {
+		p, err := probe.NewProber(prefix+string(kind), append(append([]string{}, pf.Probes...), specs...), pf.ExpectedStatus, pf.Timeout)
+		if err != nil {
+			zap.L().Fatal("problem parsing probes", zap.String("record", string(kind)), zap.Error(err))
+		}
+		p.FailureThreshold, p.SuccessThreshold = pf.FailThreshold, pf.PassThreshold
+		// reprobe runs for every store's probers.
+		p.Periodic = pf.Interval > 0
+		probers[kind] = p
+	}
+	return probers
+}
+
+// reprobe probes the addresses of the store's records every interval until ctx is done, and
+// resyncs the store, which filters them again, when any of them starts or stops passing; so a
+// failing address is removed within an interval (or --probe_failure_threshold intervals) instead
+// of at the next --resync.  Probers forget addresses that are no longer in any record.
+func reprobe(ctx context.Context, interval time.Duration, st *k8s.NodeStore, probers map[k8s.Kind]*probe.Prober) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		changed := false
+		current := make(map[k8s.Kind][]net.IP)
+		for _, r := range st.Status().Records {
+			var ips []net.IP
+			for _, addr := range r.Addresses {
+				if ip := net.ParseIP(addr); ip != nil {
+					ips = append(ips, ip)
+				}
+			}
+			current[r.Kind] = append(current[r.Kind], ips...)
+			if probers[r.Kind].Recheck(ctx, ips) {
+				changed = true
+			}
+		}
+		// Updates prune too, but they may be paused, or left to another instance.
+		for kind, p := range probers {
+			p.Prune(current[kind])
+		}
+		if !changed {
+			continue
+		}
+		if err := st.Resync(); err != nil {
+			zap.L().Error("problem updating records after a probe result changed; they'll be updated at the next resync", zap.String("store", st.Name), zap.Error(err))
+		}
+	}
+}
+
+// publishedRecord is a record that a storePublisher maintains.
+type publishedRecord struct {
+	kind   k8s.Kind
+	family string // If non-empty, only addresses of this family ("ipv4" or "ipv6") are published.
+	name   string
+	client dns.Provider
+	prober *probe.Prober
+}
+
+// matches returns true if ip belongs in the record.
+func (r *publishedRecord) matches(ip net.IP) bool {
+	switch r.family {
+	case config.FamilyIPv4:
+		return ip.To4() != nil
+	case config.FamilyIPv6:
+		return ip.To4() == nil
+	}
+	return true
+}
+
+// planUpdate logs what updating the record would change, and adds it to plans, for --dry_run.
+// Providers that can't plan updates just log the addresses.
+func planUpdate(ctx context.Context, l *zap.Logger, plans *dns.PlanLog, provider dns.Provider, record string, ips []net.IP) {
+	var plan *dns.Plan
+	err := dns.ErrCannotPlan
+	if planner, ok := provider.(dns.Planner); ok {
+		plan, err = planner.Plan(ctx, record, ips)
+	}
+	switch {
+	case errors.Is(err, dns.ErrCannotPlan):
+		l.Info("dry run; not updating dns", zap.String("record", provider.FQDN(record)), zap.Any("addresses", ips))
+	case err != nil:
+		l.Error("problem planning dns update", zap.String("record", provider.FQDN(record)), zap.Error(err))
+	default:
+		plans.Add(plan)
+		l.Info("dry run; not updating dns", zap.String("plan", plan.String()), zap.Any("changes", plan))
+	}
+}
+
+// storePublisher publishes the records of a store or rules from the config file.  It only
+// publishes to DNS; the other integrations are only driven by the store configured with flags.
+type storePublisher struct {
+	name      string
+	probers   map[k8s.Kind]*probe.Prober
+	watchdog  *watchdog.Watchdog
+	freshness *slo.Tracker
+	sizeLimit *dns.SizeLimit
+	dryRun    bool
+	plans     *dns.PlanLog
+	audit     bool // Audited records aren't changed, so they don't count against the slo.
+	paused    func() bool
+	gate      *handoff.Gate
+	throttle  *digitalocean.Throttle
+
+	// onUpdate, if non-nil, is called with the result of each update; for writing the status
+	// of NodeDNSRecords.
+	onUpdate func(req k8s.UpdateRequest, err error)
+
+	mu      sync.Mutex
+	records []publishedRecord // Replaced when the config file is reloaded.
+}
+
+// setRecords replaces the records that the publisher maintains.
+func (p *storePublisher) setRecords(records []publishedRecord) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.records = records
+}
+
+// current returns the records that the publisher maintains.
+func (p *storePublisher) current() []publishedRecord {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.records
+}
+
+// Name implements k8s.Sink.
+func (p *storePublisher) Name() string { return "dns" }
+
+// Update implements k8s.Sink.  It returns the first error encountered, after attempting to publish
+// every record.
+func (p *storePublisher) Update(req k8s.UpdateRequest) error {
+	var result error
+	records := p.current()
+	for i := range records {
+		if r := &records[i]; r.kind == req.Record.Kind {
+			if err := p.publish(req, r); err != nil && result == nil {
+				result = fmt.Errorf("publish %s: %w", r.name, err)
+			}
+		}
+	}
+	if p.onUpdate != nil && !errors.Is(result, k8s.ErrDeferred) {
+		p.onUpdate(req, result)
+	}
+	return result
+}
+
+func (p *storePublisher) publish(req k8s.UpdateRequest, r *publishedRecord) error {
+	l := zap.L().With(zap.String("store", p.name), zap.String("record", r.name))
+	fqdn := r.client.FQDN(r.name)
+	if !p.dryRun && !p.audit {
+		p.freshness.Changed(fqdn)
+	}
+	ips := make([]net.IP, 0, len(req.Record.IPs))
+	for _, ip := range req.Record.IPs {
+		if r.matches(ip) {
+			ips = append(ips, ip)
+		}
+	}
+	if p.paused() {
+		l.Info("updates paused; not updating "+string(r.kind)+" record", zap.Any("addresses", ips))
+		return k8s.ErrDeferred
+	}
+	if !p.gate.Enter() {
+		l.Info("not writing during handoff; not updating "+string(r.kind)+" record", zap.Any("addresses", ips))
+		return k8s.ErrDeferred
+	}
+	defer p.gate.Exit()
+	if err := deferUntilReset(p.throttle, req); err != nil {
+		return err
+	}
+	// The store's records of the kind share the prober, so it keeps every address of the kind,
+	// not just those of this record's family.
+	r.prober.Prune(req.Record.IPs)
+	ips = r.prober.Filter(req.Ctx, ips)
+	ips = p.sizeLimit.Apply(fqdn, ips)
+	l.Info("current "+string(r.kind)+" addresses", zap.Any("addresses", ips))
+	if p.dryRun {
+		planUpdate(req.Ctx, l, p.plans, r.client, r.name, ips)
+		return nil
+	}
+	err := r.client.UpdateDNS(req.Ctx, r.name, ips)
+	if r.family == "" {
+		// The watchdog resolves both A and AAAA records, so it can only check records that
+		// we maintain both of.
+		p.watchdog.Desired(fqdn, ips, err)
+	}
+	if err != nil {
+		l.Error("problem updating dns", zap.Error(err))
+		return err
+	}
+	p.freshness.Synced(fqdn)
+	return nil
+}
+
+// recordVars returns the variables that record name templates are expanded with: the labels,
+// zone, and region of --record_vars_node, and --record_var, which takes precedence.
+func recordVars(kf *kflags, ndf *nodednsflags) (map[string]interface{}, error) {
+	vars := make(map[string]interface{})
+	if ndf.VarsNode != "" {
+		clientset, err := k8s.Clientset(kf.Master, kf.Kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("create kubernetes client: %w", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		vars, err = nodeRecordVars(ctx, clientset, ndf.VarsNode)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for k, v := range ndf.RecordVars {
+		vars[k] = v
+	}
+	return vars, nil
+}
+
+// nodeRecordVars returns the variables that the named node provides to record templates: its
+// Labels, and its Zone and Region, which are empty if it isn't labeled.
+func nodeRecordVars(ctx context.Context, client kubernetes.Interface, name string) (map[string]interface{}, error) {
+	n, err := client.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get node %s: %w", name, err)
+	}
+	labels := n.GetLabels()
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	return map[string]interface{}{
+		"Labels": labels,
+		"Zone":   k8s.TopologyLabel(n, k8s.ZoneLabels),
+		"Region": k8s.TopologyLabel(n, k8s.RegionLabels),
+	}, nil
+}
+
+// splitConfigMap splits --pin_configmap, --status_configmap, or --records_configmap into a
+// namespace and name.  diagnose checks that it has both.
+func splitConfigMap(v string) (string, string) {
+	parts := strings.SplitN(v, "/", 2)
+	if len(parts) != 2 {
+		return "", v
+	}
+	return parts[0], parts[1]
+}
+
+// exportProvider returns a snapshot of the provider's records with the provided names, if the
+// provider can export them.
+func exportProvider(ctx context.Context, p dns.Provider, names []string) (*dns.Snapshot, error) {
+	e, ok := p.(dns.Exporter)
+	if !ok {
+		return nil, errors.New("the dns provider can't export records")
+	}
+	return e.Export(ctx, names)
+}
+
+// reportDrift returns a function that reports records that drifted, in audit mode, to the error
+// tracker.
+func reportDrift(r *sentry.Reporter) func(ctx context.Context, zone, record string, add, remove []string) {
+	return func(ctx context.Context, zone, record string, add, remove []string) {
+		r.Report(ctx, sentry.Event{
+			Message: "dns record drifted",
+			Err:     fmt.Errorf("would add %v and remove %v", add, remove),
+			Tags:    map[string]string{"zone": zone, "record": record},
+		})
+	}
+}
+
+// reportFailures returns a NodeStore.OnFailure function that reports failures that need attention
+// to the error tracker: authentication failures, which retrying won't fix, the first time that they
+// happen, and other failures once a sink has failed threshold times in a row.
+func reportFailures(r *sentry.Reporter, st *k8s.NodeStore, threshold int) func(ctx context.Context, sink string, kind k8s.Kind, failures int, err error) {
+	return func(ctx context.Context, sink string, kind k8s.Kind, failures int, err error) {
+		tags := map[string]string{
+			"store":  st.Name,
+			"sink":   sink,
+			"kind":   string(kind),
+			"record": strings.Join(st.RecordNames[kind], ","),
+		}
+		switch {
+		case dns.IsAuthError(err) && failures == 1:
+			r.Report(ctx, sentry.Event{Message: sink + " authentication failed", Err: err, Tags: tags})
+		case !dns.IsAuthError(err) && threshold > 0 && failures == threshold:
+			r.Report(ctx, sentry.Event{Message: sink + " failing repeatedly", Err: err, Tags: tags})
+		}
+	}
+}
+
+// deferUntilReset returns an error that defers the update until the DigitalOcean rate limit resets,
+// if few requests remain and the update can wait; resyncs and retries can, but changes can't.
+func deferUntilReset(t *digitalocean.Throttle, req k8s.UpdateRequest) error {
+	if req.Trigger != "resync" && req.Trigger != "retry" {
+		return nil
+	}
+	if until, ok := t.Until(); ok {
+		return &digitalocean.ThrottledError{Until: until}
+	}
+	return nil
+}
+
+// discoverPublicIP periodically discovers the public address and updates the stores, until ctx is
+// done.  If discovery fails, the previously-discovered address remains published.
+func discoverPublicIP(ctx context.Context, d publicip.Discoverer, interval time.Duration, stores storeSet) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		tctx, c := context.WithTimeout(ctx, 30*time.Second)
+		ip, err := d.Discover(tctx)
+		c()
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			zap.L().Warn("problem discovering public ip", zap.Error(err))
+		default:
+			zap.L().Debug("discovered public ip", zap.Stringer("address", ip))
+			for _, st := range stores {
+				st.SetPublicIPs([]net.IP{ip})
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// without returns the addresses in ips that are not in remove.
+func without(ips, remove []net.IP) []net.IP {
+	result := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		var found bool
+		for _, r := range remove {
+			if ip.Equal(r) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, ip)
+		}
+	}
+	return result
+}
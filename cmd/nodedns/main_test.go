@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/k8s"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNodeRecordVars(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   "node-1",
+		Labels: map[string]string{k8s.RegionLabels[0]: "nyc3", "pool": "ingress"},
+	}})
+	got, err := nodeRecordVars(context.Background(), client, "node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"Labels": map[string]string{k8s.RegionLabels[0]: "nyc3", "pool": "ingress"},
+		"Zone":   "",
+		"Region": "nyc3",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("vars:\n%s", diff)
+	}
+	if _, err := nodeRecordVars(context.Background(), client, "node-2"); err == nil {
+		t.Error("missing node: expected error")
+	}
+}
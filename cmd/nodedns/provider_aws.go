@@ -0,0 +1,47 @@
+//go:build !no_aws
+// +build !no_aws
+
+package main
+
+import (
+	"github.com/jrockway/nodedns/pkg/aws"
+	"github.com/jrockway/nodedns/pkg/changes"
+	"github.com/jrockway/nodedns/pkg/k8s"
+)
+
+type awsflags struct {
+	SecurityGroup string `long:"aws_security_group" env:"AWS_SECURITY_GROUP" description:"keep this security group's ingress rules in sync with the nodes' external addresses"`
+	Region        string `long:"aws_region" env:"AWS_REGION" description:"the aws region that the security group is in"`
+	Protocol      string `long:"aws_ingress_protocol" env:"AWS_INGRESS_PROTOCOL" description:"the protocol to allow from the nodes, like tcp, or -1 for all protocols" default:"-1"`
+	FromPort      int64  `long:"aws_ingress_from_port" env:"AWS_INGRESS_FROM_PORT" description:"the first port to allow from the nodes; ignored if the protocol is -1"`
+	ToPort        int64  `long:"aws_ingress_to_port" env:"AWS_INGRESS_TO_PORT" description:"the last port to allow from the nodes; ignored if the protocol is -1"`
+}
+
+func init() {
+	af := new(awsflags)
+	var securityGroup *aws.SecurityGroupSync
+	register(&provider{
+		name:  "aws",
+		group: "AWS",
+		flags: af,
+		setup: func() error {
+			if af.SecurityGroup == "" {
+				return nil
+			}
+			var err error
+			securityGroup, err = aws.NewSecurityGroupSync(af.Region, af.SecurityGroup, af.Protocol, af.FromPort, af.ToPort)
+			return err
+		},
+		sinks: func(integration integrationFunc) []k8s.Sink {
+			if securityGroup == nil {
+				return nil
+			}
+			return []k8s.Sink{integration("security_group", k8s.External, func(req k8s.UpdateRequest) error {
+				return securityGroup.Sync(req.Ctx, req.Record.IPs)
+			})}
+		},
+	})
+	newArchiveUploader = func(cfg changes.ArchiveConfig) (changes.Uploader, error) {
+		return aws.NewS3Uploader(cfg)
+	}
+}
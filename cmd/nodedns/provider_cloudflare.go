@@ -0,0 +1,57 @@
+//go:build !no_cloudflare
+// +build !no_cloudflare
+
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jrockway/nodedns/pkg/cloudflare"
+	"github.com/jrockway/nodedns/pkg/dns"
+	"github.com/jrockway/nodedns/pkg/k8s"
+)
+
+type cfflags struct {
+	Token     string `long:"cloudflare_token" env:"CLOUDFLARE_API_TOKEN" description:"the cloudflare api token to use"`
+	AccountID string `long:"cloudflare_account_id" env:"CLOUDFLARE_ACCOUNT_ID" description:"the cloudflare account that owns the ip list"`
+	IPListID  string `long:"cloudflare_ip_list_id" env:"CLOUDFLARE_IP_LIST_ID" description:"keep this cloudflare ip list in sync with the nodes' external addresses"`
+	Proxied   bool   `long:"cloudflare_proxied" env:"CLOUDFLARE_PROXIED" description:"with --dns_provider=cloudflare, proxy the records through cloudflare, rather than publishing them as-is"`
+}
+
+func init() {
+	cf := new(cfflags)
+	var ipList *cloudflare.ListSync
+	register(&provider{
+		name:  "cloudflare",
+		group: "Cloudflare",
+		flags: cf,
+		diagnose: func(f allFlags) []problem {
+			var problems []problem
+			if cf.IPListID != "" && (cf.Token == "" || cf.AccountID == "") {
+				problems = append(problems, problem{what: "--cloudflare_ip_list_id", err: errors.New("requires --cloudflare_token and --cloudflare_account_id"), fix: "set both"})
+			}
+			if f.usesDNSProvider("cloudflare") && cf.Token == "" {
+				problems = append(problems, problem{what: "--dns_provider=cloudflare", err: errors.New("requires --cloudflare_token"), fix: "set --cloudflare_token to a token with the Zone:DNS:Edit permission"})
+			}
+			return problems
+		},
+		setup: func() error {
+			if cf.IPListID != "" {
+				ipList = cloudflare.NewListSync(cloudflare.NewClient(cf.Token), cf.AccountID, cf.IPListID)
+			}
+			return nil
+		},
+		sinks: func(integration integrationFunc) []k8s.Sink {
+			if ipList == nil {
+				return nil
+			}
+			return []k8s.Sink{integration("cloudflare_ip_list", k8s.External, func(req k8s.UpdateRequest) error {
+				return ipList.Sync(req.Ctx, req.Record.IPs)
+			})}
+		},
+		dns: func(ctx context.Context, opts dns.ProviderOptions) (dns.Provider, error) {
+			return dns.NewCloudflare(ctx, cloudflare.NewClient(cf.Token), cf.Proxied, opts)
+		},
+	})
+}
@@ -0,0 +1,46 @@
+//go:build !no_consul
+// +build !no_consul
+
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jrockway/nodedns/pkg/consul"
+	"github.com/jrockway/nodedns/pkg/dns"
+)
+
+type consulflags struct {
+	Config consul.Config
+	Node   string `long:"consul_node" env:"CONSUL_NODE" description:"the catalog node that services are registered on; nodedns creates it as an external node, and only manages the services on it" default:"nodedns"`
+}
+
+func init() {
+	cf := new(consulflags)
+	register(&provider{
+		name:  "consul",
+		group: "Consul",
+		flags: cf,
+		diagnose: func(f allFlags) []problem {
+			if !f.usesDNSProvider("consul") {
+				return nil
+			}
+			var problems []problem
+			if cf.Config.Address == "" {
+				problems = append(problems, problem{what: "--dns_provider=consul", err: errors.New("requires --consul_address"), fix: "set --consul_address to the url of a consul agent, like http://consul.consul:8500"})
+			}
+			if cf.Node == "" {
+				problems = append(problems, problem{what: "--consul_node", err: errors.New("must be set"), fix: "set --consul_node to the name of the catalog node to register services on, like nodedns"})
+			}
+			return problems
+		},
+		dns: func(ctx context.Context, opts dns.ProviderOptions) (dns.Provider, error) {
+			c, err := consul.NewClient(cf.Config)
+			if err != nil {
+				return nil, err
+			}
+			return dns.NewConsul(ctx, c, cf.Node, opts)
+		},
+	})
+}
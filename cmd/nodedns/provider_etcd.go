@@ -0,0 +1,46 @@
+//go:build !no_etcd
+// +build !no_etcd
+
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jrockway/nodedns/pkg/dns"
+	"github.com/jrockway/nodedns/pkg/etcd"
+)
+
+type etcdflags struct {
+	Config etcd.Config
+	Prefix string `long:"etcd_prefix" env:"ETCD_PREFIX" description:"the key that records are written under, which must match the path of coredns's etcd plugin" default:"/skydns"`
+}
+
+func init() {
+	ef := new(etcdflags)
+	register(&provider{
+		name:  "etcd",
+		group: "etcd (CoreDNS)",
+		flags: ef,
+		diagnose: func(f allFlags) []problem {
+			if !f.usesDNSProvider("etcd") {
+				return nil
+			}
+			var problems []problem
+			if ef.Config.Endpoint == "" {
+				problems = append(problems, problem{what: "--dns_provider=etcd", err: errors.New("requires --etcd_endpoint"), fix: "set --etcd_endpoint to the url of the etcd that coredns reads"})
+			}
+			if (ef.Config.Cert == "") != (ef.Config.Key == "") {
+				problems = append(problems, problem{what: "--etcd_cert", err: errors.New("requires --etcd_key, and vice versa"), fix: "set both, or neither"})
+			}
+			return problems
+		},
+		dns: func(ctx context.Context, opts dns.ProviderOptions) (dns.Provider, error) {
+			c, err := etcd.NewClient(ef.Config)
+			if err != nil {
+				return nil, err
+			}
+			return dns.NewEtcd(ctx, c, ef.Prefix, opts)
+		},
+	})
+}
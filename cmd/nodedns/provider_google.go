@@ -0,0 +1,50 @@
+//go:build !no_google
+// +build !no_google
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/jrockway/nodedns/pkg/clouddns"
+	"github.com/jrockway/nodedns/pkg/dns"
+)
+
+type googleflags struct {
+	Project      string            `long:"google_project" env:"GOOGLE_CLOUD_PROJECT" description:"with --dns_provider=google, the google cloud project that the managed zones are in"`
+	Credentials  string            `long:"google_credentials" env:"GOOGLE_APPLICATION_CREDENTIALS" description:"a service account key file to authenticate with; if empty, the metadata server's service account (or the pod's, with workload identity) is used"`
+	ManagedZones map[string]string `long:"google_managed_zone" env:"GOOGLE_MANAGED_ZONES" env-delim:"," description:"A zone:managed-zone pair, like example.com:example-com; records in that zone are published in that managed zone, instead of the one found by the zone's name.  May be repeated."`
+}
+
+func init() {
+	gf := new(googleflags)
+	register(&provider{
+		name:  "google",
+		group: "Google Cloud",
+		flags: gf,
+		diagnose: func(f allFlags) []problem {
+			if !f.usesDNSProvider("google") {
+				return nil
+			}
+			var problems []problem
+			if gf.Project == "" {
+				problems = append(problems, problem{what: "--dns_provider=google", err: errors.New("requires --google_project"), fix: "set --google_project to the project that owns the managed zones"})
+			}
+			if gf.Credentials != "" {
+				if _, err := os.Stat(gf.Credentials); err != nil {
+					problems = append(problems, problem{what: "--google_credentials", err: err, fix: "mount the service account key, or leave --google_credentials empty to use the metadata server"})
+				}
+			}
+			return problems
+		},
+		dns: func(ctx context.Context, opts dns.ProviderOptions) (dns.Provider, error) {
+			c, err := clouddns.NewClient(ctx, gf.Project, gf.Credentials)
+			if err != nil {
+				return nil, err
+			}
+			return dns.NewCloudDNS(ctx, c, gf.ManagedZones[opts.Zone], opts)
+		},
+	})
+}
@@ -0,0 +1,31 @@
+//go:build !no_rfc2136
+// +build !no_rfc2136
+
+package main
+
+import (
+	"context"
+
+	"github.com/jrockway/nodedns/pkg/dns"
+)
+
+func init() {
+	cfg := new(dns.RFC2136Config)
+	register(&provider{
+		name:  "rfc2136",
+		group: "RFC 2136 Dynamic Updates",
+		flags: cfg,
+		diagnose: func(f allFlags) []problem {
+			if !f.usesDNSProvider("rfc2136") {
+				return nil
+			}
+			if err := cfg.Validate(); err != nil {
+				return []problem{{what: "--dns_provider=rfc2136", err: err, fix: "set --rfc2136_server, both or neither of --rfc2136_tsig_key_name and --rfc2136_tsig_secret, and no empty --rfc2136_notify"}}
+			}
+			return nil
+		},
+		dns: func(ctx context.Context, opts dns.ProviderOptions) (dns.Provider, error) {
+			return dns.NewRFC2136(ctx, *cfg, opts)
+		},
+	})
+}
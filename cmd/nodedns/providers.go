@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/jrockway/nodedns/pkg/changes"
+	"github.com/jrockway/nodedns/pkg/dns"
+	"github.com/jrockway/nodedns/pkg/k8s"
+)
+
+// integrationFunc returns a sink that calls sync with changes to the record of the provided kind,
+// like main's integration helper.
+type integrationFunc func(name string, kind k8s.Kind, sync func(req k8s.UpdateRequest) error) k8s.Sink
+
+// provider is an optional integration with a cloud provider other than DigitalOcean, which nodedns
+// always needs, or with another DNS server.  Each provider registers itself from its own file, which a build tag like
+// "no_aws" leaves out, so that minimal images don't carry every cloud SDK.  The default build
+// includes every provider.
+type provider struct {
+	name  string      // Like "aws"; the build tag "no_<name>" leaves the provider out.
+	group string      // The name of the provider's flag group.
+	flags interface{} // The provider's flags.
+
+	// diagnose returns problems with the provider's flags, given the rest of the flags; it may be
+	// nil.
+	diagnose func(f allFlags) []problem
+	// setup connects to the provider's API, after the flags have been parsed; it may be nil.
+	setup func() error
+	// sinks returns the sinks that keep the provider's resources in sync with a store, made with
+	// integration; it may be nil.
+	sinks func(integration integrationFunc) []k8s.Sink
+	// dns, if non-nil, returns a client that publishes records in a zone, for
+	// --dns_provider=<name>.
+	dns func(ctx context.Context, opts dns.ProviderOptions) (dns.Provider, error)
+}
+
+// providers are the providers compiled into this binary.
+var providers = make(map[string]*provider)
+
+// register adds a provider to the registry; it's called from the init function of each provider's
+// file.
+func register(p *provider) {
+	if _, ok := providers[p.name]; ok {
+		panic("duplicate provider " + p.name)
+	}
+	providers[p.name] = p
+}
+
+// compiledProviders returns the providers compiled into this binary, in order of name.
+func compiledProviders() []*provider {
+	result := make([]*provider, 0, len(providers))
+	for _, p := range providers {
+		result = append(result, p)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].name < result[j].name })
+	return result
+}
+
+// dnsProviderNames returns the names of the dns providers compiled into this binary, the choices of
+// --dns_provider, in order of name.
+func dnsProviderNames() []string {
+	result := []string{"digitalocean", "fake", "file", "webhook"}
+	for _, p := range compiledProviders() {
+		if p.dns != nil {
+			result = append(result, p.name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// newArchiveUploader returns an uploader for the change archive; it's nil unless a provider that
+// supplies object storage is compiled in.
+var newArchiveUploader func(cfg changes.ArchiveConfig) (changes.Uploader, error)
+
+// providersMain prints the name of every provider compiled into this binary, one per line.
+func providersMain(args []string) int {
+	if code, ok := parseSubcommand("providers", args); !ok {
+		return code
+	}
+	for _, p := range compiledProviders() {
+		fmt.Println(p.name)
+	}
+	return 0
+}
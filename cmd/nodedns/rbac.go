@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/jrockway/nodedns/pkg/k8s"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+type rbacflags struct {
+	Name           string `long:"rbac_name" description:"the prefix of the generated objects' names" default:"nodedns"`
+	Namespace      string `long:"rbac_namespace" description:"the namespace that nodedns runs in" default:"kube-system"`
+	ServiceAccount string `long:"rbac_service_account" description:"the service account that nodedns runs as" default:"default"`
+}
+
+var (
+	readVerbs     = []string{"get", "list", "watch"}
+	electionVerbs = []string{"get", "list", "watch", "create", "update", "patch"}
+)
+
+// rbacMain prints the ClusterRoles, Roles, and bindings that nodedns needs with the provided
+// flags, and nothing more; for example, the karpenter rules only when nodes come from kubernetes,
+// and the leader election role only with --leader_elect.  It accepts nodedns's own flags (and
+// reads the same environment variables), ignoring those that don't affect permissions.
+func rbacMain(args []string) int {
+	rf := new(rbacflags)
+	kf := new(kflags)
+	ndf := new(nodednsflags)
+	adf := new(adminflags)
+	agf := new(agentflags)
+	if code, ok := parseSubcommandWithOptions(flags.Default|flags.IgnoreUnknown, "rbac [OPTIONS] [NODEDNS OPTIONS]", args,
+		flagGroup{"RBAC", rf}, flagGroup{"Kubernetes", kf}, flagGroup{"NodeDNS", ndf}, flagGroup{"Admin", adf}, flagGroup{"Agent", agf}); !ok {
+		return code
+	}
+	objects := rbacObjects(rf, kf, ndf, adf, agf)
+	if len(objects) == 0 {
+		fmt.Println("# nodedns needs no kubernetes permissions with these flags.")
+		return 0
+	}
+	for i, obj := range objects {
+		u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "convert: %v\n", err)
+			return 1
+		}
+		unstructured.RemoveNestedField(u, "metadata", "creationTimestamp")
+		b, err := yaml.Marshal(u)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "marshal: %v\n", err)
+			return 1
+		}
+		if i > 0 {
+			fmt.Println("---")
+		}
+		os.Stdout.Write(b) // nolint:errcheck
+	}
+	return 0
+}
+
+// rbacObjects returns the RBAC objects that nodedns needs with the provided flags.
+func rbacObjects(rf *rbacflags, kf *kflags, ndf *nodednsflags, adf *adminflags, agf *agentflags) []runtime.Object {
+	var clusterRules []rbacv1.PolicyRule
+	roleRules := make(map[string][]rbacv1.PolicyRule) // By namespace.
+	var namespaces []string
+	addRole := func(namespace string, rules ...rbacv1.PolicyRule) {
+		if _, ok := roleRules[namespace]; !ok {
+			namespaces = append(namespaces, namespace)
+		}
+		roleRules[namespace] = append(roleRules[namespace], rules...)
+	}
+
+	if ndf.Source == "kubernetes" || agf.NodeName != "" || ndf.VarsNode != "" {
+		clusterRules = append(clusterRules, rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"nodes"}, Verbs: readVerbs})
+	}
+	if ndf.Source == "kubernetes" {
+		clusterRules = append(clusterRules, rbacv1.PolicyRule{APIGroups: []string{"karpenter.sh"}, Resources: []string{"nodeclaims"}, Verbs: readVerbs})
+	}
+	if ndf.Source == "kubernetes" && kf.NodeDNSRecords {
+		group := k8s.NodeDNSRecordResource.Group
+		clusterRules = append(clusterRules,
+			rbacv1.PolicyRule{APIGroups: []string{group}, Resources: []string{"nodednsrecords"}, Verbs: readVerbs},
+			rbacv1.PolicyRule{APIGroups: []string{group}, Resources: []string{"nodednsrecords/status"}, Verbs: []string{"patch"}},
+		)
+	}
+	if kf.Services || len(kf.NodePortSRV) > 0 {
+		clusterRules = append(clusterRules, rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"services"}, Verbs: readVerbs})
+	}
+	if ndf.PinConfigMap != "" {
+		namespace, name := splitConfigMap(ndf.PinConfigMap)
+		rule := rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"configmaps"}, ResourceNames: []string{name}, Verbs: readVerbs}
+		if namespace == "" {
+			// The configmap is watched in every namespace.
+			clusterRules = append(clusterRules, rule)
+		} else {
+			addRole(namespace, rule)
+		}
+	}
+	if ndf.StatusConfigMap != "" {
+		namespace, _ := splitConfigMap(ndf.StatusConfigMap)
+		// The configmap is created if it doesn't exist, so its name can't be restricted.
+		addRole(namespace,
+			rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get", "create", "update"}},
+			rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"create", "patch"}},
+		)
+	}
+	if ndf.RecordsConfigMap != "" {
+		namespace, _ := splitConfigMap(ndf.RecordsConfigMap)
+		addRole(namespace, rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get", "create", "update"}})
+	}
+	if ndf.Source == "kubernetes" && kf.LeaderElection {
+		namespace := kf.LeaderElectionNamespace
+		if namespace == "" {
+			namespace = rf.Namespace
+		}
+		// controller-runtime locks both a configmap and a lease, and records an event when the
+		// leader changes; the reflector engine only needs the lease.
+		addRole(namespace,
+			rbacv1.PolicyRule{APIGroups: []string{"coordination.k8s.io"}, Resources: []string{"leases"}, Verbs: electionVerbs},
+			rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: electionVerbs},
+			rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"create", "patch"}},
+		)
+	}
+
+	subjects := []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: rf.ServiceAccount, Namespace: rf.Namespace}}
+	var result []runtime.Object
+	if len(clusterRules) > 0 {
+		name := rf.Name + "-reader"
+		result = append(result, &rbacv1.ClusterRole{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Rules:      clusterRules,
+		}, &rbacv1.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: name + "-binding"},
+			Subjects:   subjects,
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: name},
+		})
+	}
+	if adf.Kubernetes {
+		// TokenReviews and SubjectAccessReviews for the admin api.
+		result = append(result, &rbacv1.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: rf.Name + "-auth-delegator"},
+			Subjects:   subjects,
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "system:auth-delegator"},
+		})
+	}
+	for _, namespace := range namespaces {
+		name := rf.Name
+		result = append(result, &rbacv1.Role{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Rules:      roleRules[namespace],
+		}, &rbacv1.RoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: name + "-binding", Namespace: namespace},
+			Subjects:   subjects,
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: name},
+		})
+	}
+	return result
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/config"
+	"github.com/jrockway/nodedns/pkg/k8s"
+	"go.uber.org/zap"
+)
+
+// configStore is a store that the config file configures: a store, a rule, or an alias.
+type configStore struct {
+	name     string
+	selector string
+	rules    []config.Rule
+}
+
+// reloadableStore is a running store from the config file.
+type reloadableStore struct {
+	configStore
+	store     *k8s.NodeStore
+	publisher *storePublisher
+	restart   func() // Restarts the store's watch, listing its nodes again; nil if it can't be restarted.
+}
+
+// restarter returns a function that restarts the watch of the store, which stop stops, with its
+// current selector.
+func restarter(stop context.CancelFunc, start func(watchedStore) context.CancelFunc, rs *reloadableStore) func() {
+	return func() {
+		stop()
+		stop = start(watchedStore{store: rs.store, selector: rs.selector})
+	}
+}
+
+// configStores returns every store that cfg configures, in the order that they're run.
+func configStores(cfg *config.File) []configStore {
+	var result []configStore
+	for _, sc := range cfg.Stores {
+		result = append(result, configStore{name: sc.Name, selector: sc.Selector, rules: sc.Rules()})
+	}
+	for _, r := range cfg.Rules {
+		result = append(result, configStore{name: r.Name, selector: r.Selector, rules: []config.Rule{r}})
+	}
+	for _, a := range cfg.Aliases {
+		r := a.Rule()
+		result = append(result, configStore{name: r.Name, selector: r.Selector, rules: []config.Rule{r}})
+	}
+	return result
+}
+
+// nodes returns the names of the only nodes that the store publishes, if any.
+func (c configStore) nodes() []string {
+	var result []string
+	for _, r := range c.rules {
+		result = append(result, r.Nodes...)
+	}
+	return result
+}
+
+// relist returns true if changing the store from c to next changes which nodes it publishes, so
+// that its nodes must be listed again.
+func (c configStore) relist(next configStore) bool {
+	return c.selector != next.selector || !reflect.DeepEqual(c.nodes(), next.nodes())
+}
+
+// configChanges compares the running stores to the stores that the reloaded config file
+// configures.  It returns the stores whose configuration changed, and the names of stores that
+// were added or removed, which can't be changed without a restart.
+func configChanges(running map[string]configStore, next []configStore) (changed []configStore, added, removed []string) {
+	seen := make(map[string]bool)
+	for _, c := range next {
+		seen[c.name] = true
+		old, ok := running[c.name]
+		switch {
+		case !ok:
+			added = append(added, c.name)
+		case !reflect.DeepEqual(old, c):
+			changed = append(changed, c)
+		}
+	}
+	for name := range running {
+		if !seen[name] {
+			removed = append(removed, name)
+		}
+	}
+	return changed, added, removed
+}
+
+// readConfigFiles returns the contents of the config files, for noticing when they change.
+// Files that can't be read are skipped; loading them reports the problem.
+func readConfigFiles(paths []string) []byte {
+	var result []byte
+	for _, p := range paths {
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		result = append(result, content...)
+		result = append(result, 0)
+	}
+	return result
+}
+
+// watchConfig calls reload whenever the process receives SIGHUP and, if interval is non-zero,
+// whenever the contents of the files at paths change, until the context is done.  The files are
+// compared by contents rather than modification time, since Kubernetes updates mounted
+// ConfigMaps by swapping symlinks.
+func watchConfig(ctx context.Context, paths []string, interval time.Duration, reload func()) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	defer signal.Stop(sig)
+	var tick <-chan time.Time
+	if interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+	last := readConfigFiles(paths)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sig:
+			zap.L().Info("reloading configuration after SIGHUP")
+		case <-tick:
+			current := readConfigFiles(paths)
+			if bytes.Equal(current, last) {
+				continue
+			}
+			zap.L().Info("reloading configuration after a config file changed")
+		}
+		last = readConfigFiles(paths)
+		reload()
+	}
+}
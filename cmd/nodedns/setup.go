@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/jrockway/nodedns/pkg/digitalocean"
+	"github.com/jrockway/nodedns/pkg/dns"
+	"github.com/jrockway/nodedns/pkg/k8s"
+	"github.com/jrockway/nodedns/pkg/nodedns"
+	v1 "k8s.io/api/core/v1"
+)
+
+// godoClients are the DigitalOcean clients for each zone: zones with their own tokens (see
+// --zone_token) get their own client, and every other zone shares one.
+type godoClients struct {
+	cfg       *dns.Config
+	transport http.RoundTripper
+	shared    *godo.Client
+	zones     map[string]*godo.Client
+}
+
+// newGodoClients returns the DigitalOcean clients for the zones in cfg, which make their requests
+// through transport.
+func newGodoClients(cfg *dns.Config, transport http.RoundTripper) *godoClients {
+	return &godoClients{
+		cfg:       cfg,
+		transport: transport,
+		shared:    digitalocean.NewGodoClientWithTokenSources(cfg.TokenSources(""), transport),
+		zones:     make(map[string]*godo.Client),
+	}
+}
+
+// zone returns the client that updates records in the zone.
+func (c *godoClients) zone(zone string) *godo.Client {
+	if _, ok := c.cfg.ZoneTokens[zone]; !ok {
+		return c.shared
+	}
+	if gc, ok := c.zones[zone]; ok {
+		return gc
+	}
+	gc := digitalocean.NewGodoClientWithTokenSources(c.cfg.TokenSources(zone), c.transport)
+	c.zones[zone] = gc
+	return gc
+}
+
+// providerFactory builds the dns providers that publish records, configured by the flags: each
+// provider gets the ownership, create-only, and audit settings, and, unless nothing is ever
+// deleted anyway, is guarded against mass deletions.
+type providerFactory struct {
+	nd      *nodednsflags
+	guard   *dns.GuardConfig
+	webhook *dns.WebhookConfig
+	fake    *dns.FakeConfig
+	file    *dns.FileConfig
+	godo    func(zone string) *godo.Client // The DigitalOcean client for each zone.
+
+	onDrift    func(ctx context.Context, zone, record string, add, remove []string) // With --audit.
+	onMutation func(ctx context.Context, m dns.Mutation)
+}
+
+// newUnguarded returns a client for the named dns provider (--dns_provider, unless the config file
+// chooses another), for one zone.
+func (f *providerFactory) newUnguarded(ctx context.Context, provider string, opts dns.ProviderOptions) (dns.Provider, error) {
+	opts.CreateOnly, opts.Audit = f.nd.CreateOnly, f.nd.Audit
+	opts.Owner, opts.AdoptUnowned = f.nd.TXTOwnerID, f.nd.AdoptUnowned
+	opts.SkipUnchanged, opts.VerifyInterval = f.nd.SkipUnchanged, f.nd.VerifyEvery
+	opts.Parallelism = f.nd.Parallelism
+	if f.nd.Audit {
+		opts.OnDrift = f.onDrift
+	}
+	opts.OnMutation = f.onMutation
+	switch provider {
+	case "digitalocean":
+		return dns.NewDigitalOcean(ctx, f.godo(opts.Zone), opts)
+	case "webhook":
+		return dns.NewWebhook(*f.webhook, opts)
+	case "fake":
+		return dns.NewFake(*f.fake, opts), nil
+	case "file":
+		return dns.NewFile(*f.file, opts)
+	}
+	if p, ok := providers[provider]; ok && p.dns != nil {
+		return p.dns(ctx, opts)
+	}
+	return nil, fmt.Errorf("dns provider %q isn't compiled into this binary", provider)
+}
+
+// newProvider is like newUnguarded, but the records are guarded against mass deletions, unless
+// nothing is ever deleted anyway.
+func (f *providerFactory) newProvider(ctx context.Context, provider string, opts dns.ProviderOptions) (dns.Provider, error) {
+	p, err := f.newUnguarded(ctx, provider, opts)
+	if err != nil || !f.guard.Enabled() || f.nd.CreateOnly || f.nd.Audit {
+		return p, err
+	}
+	return dns.NewGuard(p, opts.Family, *f.guard), nil
+}
+
+// newMainRecords returns the records configured with flags, and the providers that publish them:
+// --dns_provider in --zone, and a provider of their own for the records whose ttl, provider, or
+// zone differ, like for split-horizon dns.  The records in a zone of their own are named relative
+// to it.
+func newMainRecords(ctx context.Context, f *providerFactory, dnsCfg *dns.Config, nd *nodednsflags) (*nodedns.Records, error) {
+	newProvider := func(provider string, opts dns.ProviderOptions) (dns.Provider, error) {
+		tctx, c := context.WithTimeout(ctx, 10*time.Second)
+		defer c()
+		return f.newProvider(tctx, provider, opts)
+	}
+	def, err := newProvider(nd.DNSProvider, dns.ProviderOptions{Zone: dnsCfg.Zone, TTL: dnsCfg.TTL})
+	if err != nil {
+		return nil, fmt.Errorf("dns provider %s: %w", nd.DNSProvider, err)
+	}
+	records := &nodedns.Records{
+		Names: map[k8s.Kind]string{
+			k8s.Internal: nd.Internal,
+			k8s.External: nd.External,
+			k8s.Overlay:  nd.Overlay,
+		},
+		Providers: make(map[k8s.Kind]dns.Provider),
+		Default:   def,
+	}
+	for _, k := range []struct {
+		kind           k8s.Kind
+		ttl            time.Duration
+		provider, zone string
+	}{
+		{k8s.Internal, nd.InternalTTL, nd.InternalDNS, nd.InternalZone},
+		{k8s.External, nd.ExternalTTL, nd.ExternalDNS, nd.ExternalZone},
+	} {
+		ttl, provider, zone := dnsCfg.TTL, nd.DNSProvider, dnsCfg.Zone
+		if k.ttl != 0 {
+			ttl = k.ttl
+		}
+		if k.provider != "" {
+			provider = k.provider
+		}
+		if k.zone != "" {
+			zone = k.zone
+			if name := records.Names[k.kind]; name != "" {
+				records.Names[k.kind] = dns.RelativeName(zone, name)
+			}
+		}
+		if ttl == dnsCfg.TTL && provider == nd.DNSProvider && zone == dnsCfg.Zone {
+			continue
+		}
+		p, err := newProvider(provider, dns.ProviderOptions{Zone: zone, TTL: ttl})
+		if err != nil {
+			return nil, fmt.Errorf("%s record: dns provider %s: %w", k.kind, provider, err)
+		}
+		records.Providers[k.kind] = p
+	}
+	return records, nil
+}
+
+// newStorePolicy returns the policy, configured by the flags, that every store follows.
+func newStorePolicy(nd *nodednsflags) (*nodedns.StorePolicy, error) {
+	p := &nodedns.StorePolicy{
+		UpdateTimeout:             nd.UpdateTimeout,
+		RetryMin:                  nd.RetryMin,
+		RetryMax:                  nd.RetryMax,
+		RetryLimit:                nd.RetryLimit,
+		ConcurrentUpdates:         nd.Concurrent,
+		Async:                     nd.Async,
+		ExcludeAnnotation:         nd.ExcludeAnnotation,
+		IncludeNetworkUnavailable: nd.IncludeNetworkUnavailable,
+		IncludeUnschedulable:      nd.IncludeUnschedulable,
+		NotReadyGrace:             nd.NotReadyGrace,
+		RemovalDelay:              nd.RemovalDelay,
+		ExcludeSpotExternal:       nd.ExcludeSpotExternal,
+		AllowPrivateExternal:      nd.AllowPrivateExternal,
+		RejectPublicInternal:      nd.RejectPublicInternal,
+		Family:                    nd.AddressFamily,
+		ValidateExternal:          nd.ValidateExternal,
+		VIPAnnotations:            nd.VIPAnnotations,
+		VIPKind:                   k8s.Kind(nd.VIPRecord),
+		VIPReplace:                nd.VIPReplace,
+		PinAnnotation:             nd.PinAnnotation,
+		AddressAnnotation:         nd.AddrAnnotation,
+	}
+	var err error
+	if p.AnnouncedNetworks, err = parseNetworks(nd.AnnouncedCIDRs); err != nil {
+		return nil, fmt.Errorf("announced network: %w", err)
+	}
+	if p.IncludeNetworks, err = parseNetworks(nd.IncludeCIDRs); err != nil {
+		return nil, fmt.Errorf("included network: %w", err)
+	}
+	if p.ExcludeNetworks, err = parseNetworks(nd.ExcludeCIDRs); err != nil {
+		return nil, fmt.Errorf("excluded network: %w", err)
+	}
+	for _, pattern := range nd.ExcludeNodeNames {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("excluded node name %q: %w", pattern, err)
+		}
+		p.ExcludeNames = append(p.ExcludeNames, re)
+	}
+	for _, c := range nd.ExcludeConditions {
+		p.ExcludeConditions = append(p.ExcludeConditions, v1.NodeConditionType(c))
+	}
+	return p, nil
+}
+
+// parseNetworks parses a list of CIDRs.
+func parseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	var result []*net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, n)
+	}
+	return result, nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/dns"
+	"github.com/jrockway/nodedns/pkg/handoff"
+	"github.com/jrockway/nodedns/pkg/k8s"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+)
+
+// testFactory returns a providerFactory configured by the flags, with the default deletion
+// safety thresholds.
+func testFactory(t *testing.T, nd *nodednsflags) *providerFactory {
+	return &providerFactory{
+		nd:      nd,
+		guard:   &dns.GuardConfig{MaxDeleteFraction: 1, MinRecords: 1},
+		webhook: new(dns.WebhookConfig),
+		fake:    new(dns.FakeConfig),
+		file:    &dns.FileConfig{Path: filepath.Join(t.TempDir(), "zone"), Format: "zone", ReloadTimeout: time.Second},
+	}
+}
+
+// unguard returns the provider that p guards, if it's guarded.
+func unguard(p dns.Provider) dns.Provider {
+	if g, ok := p.(*dns.Guard); ok {
+		return g.Provider
+	}
+	return p
+}
+
+func TestNewMainRecords(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	dnsCfg := &dns.Config{Zone: "example.com", TTL: time.Minute}
+	testData := []struct {
+		name          string
+		nd            nodednsflags
+		wantNames     map[k8s.Kind]string
+		wantZones     map[k8s.Kind]string
+		wantProviders []string // The type of the internal and external records' providers.
+		wantOwn       []k8s.Kind
+		wantFQDNs     map[k8s.Kind][]string
+	}{
+		{
+			name: "one provider",
+			nd:   nodednsflags{DNSProvider: "fake", Internal: "internal", External: "nodes.example.com"},
+			wantNames: map[k8s.Kind]string{
+				k8s.Internal: "internal",
+				k8s.External: "nodes.example.com",
+			},
+			wantZones:     map[k8s.Kind]string{k8s.Internal: "example.com", k8s.External: "example.com"},
+			wantProviders: []string{"*dns.Fake", "*dns.Fake"},
+			wantFQDNs: map[k8s.Kind][]string{
+				k8s.Internal: {"internal.example.com"},
+				k8s.External: {"nodes.example.com"},
+			},
+		},
+		{
+			name: "internal zone",
+			nd: nodednsflags{
+				DNSProvider:  "fake",
+				Internal:     "nodes.corp.example",
+				External:     "nodes",
+				InternalZone: "corp.example",
+			},
+			wantNames: map[k8s.Kind]string{
+				k8s.Internal: "nodes",
+				k8s.External: "nodes",
+			},
+			wantZones:     map[k8s.Kind]string{k8s.Internal: "corp.example", k8s.External: "example.com"},
+			wantProviders: []string{"*dns.Fake", "*dns.Fake"},
+			wantOwn:       []k8s.Kind{k8s.Internal},
+			wantFQDNs: map[k8s.Kind][]string{
+				k8s.Internal: {"nodes.corp.example"},
+				k8s.External: {"nodes.example.com"},
+			},
+		},
+		{
+			name: "external provider",
+			nd: nodednsflags{
+				DNSProvider: "fake",
+				Internal:    "internal",
+				External:    "nodes",
+				Overlay:     "overlay",
+				ExternalDNS: "file",
+			},
+			wantNames: map[k8s.Kind]string{
+				k8s.Internal: "internal",
+				k8s.External: "nodes",
+				k8s.Overlay:  "overlay",
+			},
+			wantZones:     map[k8s.Kind]string{k8s.Internal: "example.com", k8s.External: "example.com"},
+			wantProviders: []string{"*dns.Fake", "*dns.File"},
+			wantOwn:       []k8s.Kind{k8s.External},
+			wantFQDNs: map[k8s.Kind][]string{
+				k8s.Internal: {"internal.example.com"},
+				k8s.External: {"nodes.example.com"},
+				k8s.Overlay:  {"overlay.example.com"},
+			},
+		},
+		{
+			name: "external ttl",
+			nd:   nodednsflags{DNSProvider: "fake", External: "nodes", ExternalTTL: time.Hour},
+			wantNames: map[k8s.Kind]string{
+				k8s.External: "nodes",
+			},
+			wantZones:     map[k8s.Kind]string{k8s.Internal: "example.com", k8s.External: "example.com"},
+			wantProviders: []string{"*dns.Fake", "*dns.Fake"},
+			wantOwn:       []k8s.Kind{k8s.External},
+			wantFQDNs:     map[k8s.Kind][]string{k8s.External: {"nodes.example.com"}},
+		},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			nd := test.nd
+			records, err := newMainRecords(context.Background(), testFactory(t, &nd), dnsCfg, &nd)
+			if err != nil {
+				t.Fatalf("newMainRecords: %v", err)
+			}
+			names := make(map[k8s.Kind]string)
+			for _, kind := range []k8s.Kind{k8s.Internal, k8s.External, k8s.Overlay} {
+				if name := records.Name(kind); name != "" {
+					names[kind] = name
+				}
+			}
+			if diff := cmp.Diff(names, test.wantNames); diff != "" {
+				t.Errorf("names:\n%s", diff)
+			}
+			var providers []string
+			for _, kind := range []k8s.Kind{k8s.Internal, k8s.External} {
+				p := records.Provider(kind)
+				if got, want := p.FQDN("@"), test.wantZones[kind]; got != want {
+					t.Errorf("%s zone:\n  got: %v\n want: %v", kind, got, want)
+				}
+				if _, ok := p.(*dns.Guard); !ok {
+					t.Errorf("%s provider: expected it to be guarded", kind)
+				}
+				switch unguard(p).(type) {
+				case *dns.Fake:
+					providers = append(providers, "*dns.Fake")
+				case *dns.File:
+					providers = append(providers, "*dns.File")
+				default:
+					providers = append(providers, "unexpected provider")
+				}
+			}
+			if diff := cmp.Diff(providers, test.wantProviders); diff != "" {
+				t.Errorf("providers:\n%s", diff)
+			}
+			var own []k8s.Kind
+			for _, kind := range []k8s.Kind{k8s.Internal, k8s.External, k8s.Overlay} {
+				if records.Provider(kind) != records.Default {
+					own = append(own, kind)
+				}
+			}
+			if diff := cmp.Diff(own, test.wantOwn); diff != "" {
+				t.Errorf("kinds with their own provider:\n%s", diff)
+			}
+			if diff := cmp.Diff(records.FQDNs(), test.wantFQDNs); diff != "" {
+				t.Errorf("fqdns:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNewMainRecordsUnknownProvider(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	nd := &nodednsflags{DNSProvider: "fake", Internal: "internal", InternalDNS: "nonexistent"}
+	if _, err := newMainRecords(context.Background(), testFactory(t, nd), &dns.Config{Zone: "example.com"}, nd); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestExportMainRecords(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	ctx := context.Background()
+	nd := &nodednsflags{
+		DNSProvider:  "fake",
+		Internal:     "nodes.corp.example",
+		External:     "nodes",
+		Overlay:      "overlay",
+		InternalZone: "corp.example",
+	}
+	records, err := newMainRecords(ctx, testFactory(t, nd), &dns.Config{Zone: "example.com", TTL: time.Minute}, nd)
+	if err != nil {
+		t.Fatalf("newMainRecords: %v", err)
+	}
+	for kind, ip := range map[k8s.Kind]string{k8s.Internal: "10.0.0.1", k8s.External: "1.2.3.4", k8s.Overlay: "100.64.0.1"} {
+		if err := records.Update(ctx, kind, []net.IP{net.ParseIP(ip)}); err != nil {
+			t.Fatalf("update %s: %v", kind, err)
+		}
+	}
+	// Each zone's records are only in its own provider.
+	if diff := cmp.Diff(unguard(records.Provider(k8s.Internal)).(*dns.Fake).Addresses(), map[string][]string{"nodes.corp.example": {"10.0.0.1"}}); diff != "" {
+		t.Errorf("internal provider's addresses:\n%s", diff)
+	}
+	if diff := cmp.Diff(unguard(records.Default).(*dns.Fake).Addresses(), map[string][]string{"nodes.example.com": {"1.2.3.4"}, "overlay.example.com": {"100.64.0.1"}}); diff != "" {
+		t.Errorf("default provider's addresses:\n%s", diff)
+	}
+
+	rs := &recordSet{main: records, gate: handoff.NewGate(true)}
+	snaps, err := rs.export(ctx)
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	got := make(map[string][]string)
+	for _, snap := range snaps {
+		for _, r := range snap.Records {
+			got[snap.Zone] = append(got[snap.Zone], r.Name+" "+r.Type+" "+r.Data)
+		}
+	}
+	want := map[string][]string{
+		"corp.example": {"nodes A 10.0.0.1"},
+		"example.com":  {"nodes A 1.2.3.4", "overlay A 100.64.0.1"},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("exported records:\n%s", diff)
+	}
+}
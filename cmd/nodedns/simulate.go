@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/jrockway/nodedns/pkg/simulate"
+)
+
+// simulateMain implements "nodedns simulate", which measures NodeStore performance under
+// synthetic node churn.
+func simulateMain(args []string) int {
+	cfg := new(simulate.Config)
+	if code, ok := parseSubcommand("simulate [OPTIONS]", args, flagGroup{"Simulation", cfg}); !ok {
+		return code
+	}
+	result, err := simulate.Run(context.Background(), *cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "simulate: %v\n", err)
+		return 1
+	}
+	if err := result.Report(os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "simulate: write report: %v\n", err)
+		return 1
+	}
+	return 0
+}
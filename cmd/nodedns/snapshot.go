@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/digitalocean"
+	"github.com/jrockway/nodedns/pkg/dns"
+)
+
+type exportflags struct {
+	Records []string `long:"record" description:"export this record; may be repeated; if unset, every A and AAAA record in the zone is exported"`
+	Format  string   `long:"format" description:"the snapshot format" choice:"json" choice:"zone" default:"json"`
+	Output  string   `long:"output" short:"o" description:"where to write the snapshot; - for stdout" default:"-"`
+}
+
+type importflags struct {
+	Format string `long:"format" description:"the snapshot format" choice:"json" choice:"zone" default:"json"`
+	Input  string `long:"input" short:"i" description:"where to read the snapshot from; - for stdin" default:"-"`
+}
+
+// exportMain implements "nodedns export", which writes a snapshot of the records in a zone.
+func exportMain(args []string) int {
+	dnsCfg, ef := new(dns.Config), new(exportflags)
+	if code, ok := parseSubcommand("export [OPTIONS]", args, flagGroup{"DigitalOcean", dnsCfg}, flagGroup{"Export", ef}); !ok {
+		return code
+	}
+	ctx, c := context.WithTimeout(context.Background(), time.Minute)
+	defer c()
+	client, err := dns.NewClientFromGodo(ctx, digitalocean.NewGodoClientWithTokenSources(dnsCfg.TokenSources(dnsCfg.Zone), nil), dnsCfg.Zone, dnsCfg.TTL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		return 1
+	}
+	snap, err := client.Export(ctx, ef.Records)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		return 1
+	}
+	w := io.Writer(os.Stdout)
+	if ef.Output != "-" {
+		f, err := os.Create(ef.Output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "export: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	switch ef.Format {
+	case "json":
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		err = e.Encode(snap)
+	case "zone":
+		err = snap.WriteZone(w)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export: write snapshot: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// importMain implements "nodedns import", which makes the records in a snapshot match the zone.
+func importMain(args []string) int {
+	dnsCfg, inf := new(dns.Config), new(importflags)
+	if code, ok := parseSubcommand("import [OPTIONS]", args, flagGroup{"DigitalOcean", dnsCfg}, flagGroup{"Import", inf}); !ok {
+		return code
+	}
+	r := io.Reader(os.Stdin)
+	if inf.Input != "-" {
+		f, err := os.Open(inf.Input)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "import: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		r = f
+	}
+	snap := new(dns.Snapshot)
+	var err error
+	switch inf.Format {
+	case "json":
+		err = json.NewDecoder(r).Decode(snap)
+	case "zone":
+		snap, err = dns.ReadZone(r)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import: read snapshot: %v\n", err)
+		return 1
+	}
+	// Import into the zone the snapshot came from, unless another zone is specified.
+	zone := dnsCfg.Zone
+	if zone == "" {
+		zone = snap.Zone
+	}
+	ctx, c := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer c()
+	client, err := dns.NewClientFromGodo(ctx, digitalocean.NewGodoClientWithTokenSources(dnsCfg.TokenSources(zone), nil), zone, dnsCfg.TTL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import: %v\n", err)
+		return 1
+	}
+	if err := client.Import(ctx, snap); err != nil {
+		fmt.Fprintf(os.Stderr, "import: %v\n", err)
+		return 1
+	}
+	return 0
+}
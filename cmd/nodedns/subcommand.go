@@ -0,0 +1,33 @@
+package main
+
+import "github.com/jessevdk/go-flags"
+
+// flagGroup is a named group of flags, like server.AddFlagGroup takes.
+type flagGroup struct {
+	name string
+	data interface{}
+}
+
+// parseSubcommand parses a subcommand's flags into groups.  If the command should not run, it
+// returns false and the exit code to use.
+func parseSubcommand(usage string, args []string, groups ...flagGroup) (int, bool) {
+	return parseSubcommandWithOptions(flags.Default, usage, args, groups...)
+}
+
+// parseSubcommandWithOptions is like parseSubcommand, but with the provided parser options.
+func parseSubcommandWithOptions(options flags.Options, usage string, args []string, groups ...flagGroup) (int, bool) {
+	parser := flags.NewParser(nil, options)
+	parser.Usage = usage
+	for _, g := range groups {
+		if _, err := parser.AddGroup(g.name, "", g.data); err != nil {
+			panic(err)
+		}
+	}
+	if _, err := parser.ParseArgs(args); err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			return 0, false
+		}
+		return 2, false
+	}
+	return 0, true
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/dns"
+	"github.com/jrockway/nodedns/pkg/k8s"
+	"github.com/jrockway/nodedns/pkg/nodedns"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type syncflags struct {
+	Kubeconfig string        `long:"kubeconfig" env:"KUBECONFIG" description:"kubeconfig to use to connect to the cluster, when running outside of the cluster"`
+	Master     string        `long:"master" env:"KUBE_MASTER" description:"url of the kubernetes master, only necessary when running outside of the cluster and when it's not specified in the provided kubeconfig"`
+	Selector   string        `long:"selector" env:"SELECTOR" description:"only publish nodes matching this label selector"`
+	Timeout    time.Duration `long:"timeout" description:"how long to allow for listing nodes and updating every record" default:"5m"`
+	JSON       bool          `long:"json" description:"print the plan for each record as json, rather than one line per record"`
+}
+
+// recordChange is what a sync did, or would do, to one record.
+type recordChange struct {
+	*dns.Plan
+	// Unplanned is true if the dns provider can't plan updates; Addresses are what the record was,
+	// or would be, set to.
+	Unplanned bool     `json:"unplanned,omitempty"`
+	Addresses []string `json:"addresses,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// syncMain implements "nodedns sync", which lists nodes, updates each record once, prints what
+// changed (or, with --dry_run, what would change), and exits.  It takes nodedns's own flags for the
+// records, their providers, and which nodes and addresses are published.
+func syncMain(args []string) int {
+	dnsCfg, ndf, sf := new(dns.Config), new(nodednsflags), new(syncflags)
+	guardCfg, webhookCfg, fakeCfg, fileCfg := new(dns.GuardConfig), new(dns.WebhookConfig), new(dns.FakeConfig), new(dns.FileConfig)
+	groups := []flagGroup{{"DigitalOcean", dnsCfg}, {"NodeDNS", ndf}}
+	for _, p := range compiledProviders() {
+		groups = append(groups, flagGroup{p.group, p.flags})
+	}
+	groups = append(groups, flagGroup{"Deletion Safety", guardCfg}, flagGroup{"DNS Webhook", webhookCfg}, flagGroup{"Fake DNS", fakeCfg}, flagGroup{"DNS File", fileCfg}, flagGroup{"Sync", sf})
+	if code, ok := parseSubcommand("sync [OPTIONS]", args, groups...); !ok {
+		return code
+	}
+	f := &providerFactory{nd: ndf, guard: guardCfg, webhook: webhookCfg, fake: fakeCfg, file: fileCfg}
+	if err := checkSyncFlags(f); err != nil {
+		fmt.Fprintf(os.Stderr, "sync: %v\n", err)
+		return 2
+	}
+	ctx, c := context.WithTimeout(context.Background(), sf.Timeout)
+	defer c()
+
+	clientset, err := k8s.Clientset(sf.Master, sf.Kubeconfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sync: %v\n", err)
+		return 1
+	}
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: sf.Selector})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sync: list nodes: %v\n", err)
+		return 1
+	}
+	f.godo = newGodoClients(dnsCfg, nil).zone
+	records, err := newMainRecords(ctx, f, dnsCfg, ndf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sync: %v\n", err)
+		return 1
+	}
+	changes, err := syncNodes(records, ndf, nodes.Items)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sync: %v\n", err)
+		return 2
+	}
+	if err := printSyncChanges(os.Stdout, changes, sf.JSON); err != nil {
+		fmt.Fprintf(os.Stderr, "sync: %v\n", err)
+		return 1
+	}
+	for _, c := range changes {
+		if c.Error != "" {
+			return 1
+		}
+	}
+	return 0
+}
+
+// checkSyncFlags returns the first problem with the flags that sync uses, like doctor would report
+// it.
+func checkSyncFlags(f *providerFactory) error {
+	nd := f.nd
+	if nd.Internal == "" && nd.External == "" && nd.Overlay == "" {
+		return errors.New("at least one of --internal_domain, --external_domain, or --overlay_domain is required")
+	}
+	if err := f.guard.Validate(); err != nil {
+		return fmt.Errorf("deletion safety: %w", err)
+	}
+	for _, p := range []string{nd.DNSProvider, nd.InternalDNS, nd.ExternalDNS} {
+		switch p {
+		case "", "digitalocean":
+		case "fake":
+			if err := f.fake.Validate(); err != nil {
+				return fmt.Errorf("--dns_provider=fake: %w", err)
+			}
+		case "file":
+			if err := f.file.Validate(); err != nil {
+				return fmt.Errorf("--dns_provider=file: %w", err)
+			}
+		case "webhook":
+			if f.webhook.URL == "" {
+				return errors.New("--dns_provider=webhook: requires --webhook_url")
+			}
+		}
+		if p != "" && p != "digitalocean" && nd.TXTOwnerID != "" {
+			return fmt.Errorf("--txt_owner_id: the %s provider doesn't keep an ownership registry", p)
+		}
+	}
+	return nil
+}
+
+// syncNodes publishes the nodes to the records, updating each record once, and returns what
+// changed in each; with --dry_run, nothing is changed.  Nodes and addresses are selected like the
+// store configured with flags selects them, but updates aren't retried.
+func syncNodes(records *nodedns.Records, nd *nodednsflags, nodes []v1.Node) ([]recordChange, error) {
+	policy, err := newStorePolicy(nd)
+	if err != nil {
+		return nil, fmt.Errorf("parse node policy: %w", err)
+	}
+	// Every record is updated while Resync runs, and failures are reported rather than retried.
+	policy.Async, policy.RetryMin = false, 0
+	ns := k8s.NewNodeStore("sync")
+	policy.Apply(ns)
+	if nd.Overlay != "" {
+		if ns.OverlayNetworks, err = parseNetworks(nd.OverlayCIDRs); err != nil {
+			return nil, fmt.Errorf("parse --overlay_cidr: %w", err)
+		}
+		ns.OverlayAnnotation = nd.OverlayAnnotation
+	}
+	objs := make([]interface{}, 0, len(nodes))
+	for i := range nodes {
+		objs = append(objs, &nodes[i])
+	}
+	// Load every node before subscribing, so that each record is updated exactly once, by the
+	// resync, including records that end up empty.
+	ns.Replace(objs, "") // nolint:errcheck
+
+	var mu sync.Mutex
+	var changes []recordChange
+	ns.Subscribe(k8s.SinkFunc("dns", func(req k8s.UpdateRequest) error {
+		name := records.Name(req.Record.Kind)
+		if name == "" {
+			return nil
+		}
+		change := syncRecord(req.Ctx, records.Provider(req.Record.Kind), name, req.Record.IPs, nd.IsDryRun)
+		mu.Lock()
+		changes = append(changes, change)
+		mu.Unlock()
+		if change.Error != "" {
+			return errors.New(change.Error)
+		}
+		return nil
+	}))
+	ns.Resync() // nolint:errcheck
+	return changes, nil
+}
+
+// syncRecord plans an update of one record, and makes it unless this is a dry run.  Providers that
+// can't plan are just updated, and a dry run reports the addresses that the record would be set to.
+func syncRecord(ctx context.Context, p dns.Provider, name string, ips []net.IP, dryRun bool) recordChange {
+	change := recordChange{Plan: &dns.Plan{Record: p.FQDN(name)}}
+	err := dns.ErrCannotPlan
+	if planner, ok := p.(dns.Planner); ok {
+		var plan *dns.Plan
+		if plan, err = planner.Plan(ctx, name, ips); err == nil {
+			change.Plan = plan
+		}
+	}
+	switch {
+	case errors.Is(err, dns.ErrCannotPlan):
+		change.Unplanned, err = true, nil
+		for _, ip := range ips {
+			change.Addresses = append(change.Addresses, ip.String())
+		}
+		if !dryRun {
+			err = p.UpdateDNS(ctx, name, ips)
+		}
+	case err != nil:
+	case change.Refused != "":
+		err = errors.New(change.Refused)
+	case !dryRun && !change.Empty():
+		err = p.UpdateDNS(ctx, name, ips)
+	}
+	if err != nil {
+		change.Error = err.Error()
+	}
+	return change
+}
+
+// printSyncChanges prints what a sync did, or would do, to each record.
+func printSyncChanges(w io.Writer, changes []recordChange, asJSON bool) error {
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Record < changes[j].Record })
+	if asJSON {
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(changes)
+	}
+	for _, c := range changes {
+		if c.Error != "" && c.Refused == "" {
+			fmt.Fprintf(w, "%s: failed: %s\n", c.Record, c.Error)
+			continue
+		}
+		if c.Unplanned {
+			addresses := "no addresses"
+			if len(c.Addresses) > 0 {
+				addresses = strings.Join(c.Addresses, " ")
+			}
+			fmt.Fprintf(w, "%s: %s (the dns provider can't plan updates)\n", c.Record, addresses)
+			continue
+		}
+		fmt.Fprintln(w, c.Plan.String())
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/dns"
+	"github.com/jrockway/nodedns/pkg/fakedo"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// syncTestNodes are two nodes with internal and external addresses; host-1 also has an overlay
+// address and an ipv6 address.
+func syncTestNodes() []v1.Node {
+	node := func(name string, addrs ...v1.NodeAddress) v1.Node {
+		return v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}, Status: v1.NodeStatus{Addresses: addrs}}
+	}
+	return []v1.Node{
+		node("host-1",
+			v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.1"},
+			v1.NodeAddress{Type: v1.NodeInternalIP, Address: "100.64.0.1"},
+			v1.NodeAddress{Type: v1.NodeExternalIP, Address: "1.2.3.4"},
+			v1.NodeAddress{Type: v1.NodeExternalIP, Address: "2001:db8::1"},
+		),
+		node("host-2",
+			v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.2"},
+			v1.NodeAddress{Type: v1.NodeExternalIP, Address: "1.2.3.5"},
+		),
+	}
+}
+
+func TestSyncNodes(t *testing.T) {
+	testData := []struct {
+		name     string
+		nd       nodednsflags
+		existing []godo.DomainRecord // Records in example.com before the sync.
+		want     map[string]map[string][]string
+		wantOut  string // With wantErr, only the start of the output.
+		wantErr  bool
+	}{
+		{
+			name: "publish",
+			nd:   nodednsflags{Internal: "internal", External: "nodes", Overlay: "overlay", OverlayCIDRs: []string{"100.64.0.0/10"}},
+			want: map[string]map[string][]string{
+				"example.com": {
+					"internal": {"10.0.0.1", "10.0.0.2"},
+					"nodes":    {"1.2.3.4", "1.2.3.5", "2001:db8::1"},
+					"overlay":  {"100.64.0.1"},
+				},
+				"corp.example": {},
+			},
+			wantOut: "internal.example.com: +10.0.0.1 +10.0.0.2 (0 unchanged)\n" +
+				"nodes.example.com: +1.2.3.4 +1.2.3.5 +2001:db8::1 (0 unchanged)\n" +
+				"overlay.example.com: +100.64.0.1 (0 unchanged)\n",
+		},
+		{
+			name: "address family",
+			nd:   nodednsflags{External: "nodes", AddressFamily: "ipv4"},
+			want: map[string]map[string][]string{
+				"example.com":  {"nodes": {"1.2.3.4", "1.2.3.5"}},
+				"corp.example": {},
+			},
+			wantOut: "nodes.example.com: +1.2.3.4 +1.2.3.5 (0 unchanged)\n",
+		},
+		{
+			name: "excluded node",
+			nd:   nodednsflags{External: "nodes", ExcludeNodeNames: []string{"^host-2$"}},
+			want: map[string]map[string][]string{
+				"example.com":  {"nodes": {"1.2.3.4", "2001:db8::1"}},
+				"corp.example": {},
+			},
+			wantOut: "nodes.example.com: +1.2.3.4 +2001:db8::1 (0 unchanged)\n",
+		},
+		{
+			name: "internal zone",
+			nd:   nodednsflags{Internal: "nodes.corp.example", InternalZone: "corp.example", External: "nodes"},
+			want: map[string]map[string][]string{
+				"example.com": {"nodes": {"1.2.3.4", "1.2.3.5", "2001:db8::1"}},
+				// Without --overlay_domain, overlay addresses are internal.
+				"corp.example": {"nodes": {"10.0.0.1", "10.0.0.2", "100.64.0.1"}},
+			},
+			wantOut: "nodes.corp.example: +10.0.0.1 +10.0.0.2 +100.64.0.1 (0 unchanged)\n" +
+				"nodes.example.com: +1.2.3.4 +1.2.3.5 +2001:db8::1 (0 unchanged)\n",
+		},
+		{
+			name:     "dry run",
+			nd:       nodednsflags{External: "nodes", IsDryRun: true},
+			existing: []godo.DomainRecord{{Type: "A", Name: "nodes", Data: "1.2.3.4", TTL: 60}, {Type: "A", Name: "nodes", Data: "1.2.3.9", TTL: 60}},
+			want: map[string]map[string][]string{
+				"example.com":  {"nodes": {"1.2.3.4", "1.2.3.9"}},
+				"corp.example": {},
+			},
+			wantOut: "nodes.example.com: +1.2.3.5 +2001:db8::1 -1.2.3.9 (1 unchanged)\n",
+		},
+		{
+			name: "owned by another",
+			nd:   nodednsflags{External: "nodes", TXTOwnerID: "main"},
+			existing: []godo.DomainRecord{
+				{Type: "A", Name: "nodes", Data: "1.2.3.9"},
+				{Type: "TXT", Name: "nodes", Data: dns.OwnerMarker("other")},
+			},
+			want: map[string]map[string][]string{
+				"example.com":  {"nodes": {"1.2.3.9"}},
+				"corp.example": {},
+			},
+			wantOut: "nodes.example.com: refused: nodes.example.com is marked as owned by someone else",
+			wantErr: true,
+		},
+		{
+			name:     "guarded",
+			nd:       nodednsflags{External: "nodes", ExcludeNodeNames: []string{"^host-"}},
+			existing: []godo.DomainRecord{{Type: "A", Name: "nodes", Data: "1.2.3.9"}},
+			want: map[string]map[string][]string{
+				"example.com":  {"nodes": {"1.2.3.9"}},
+				"corp.example": {},
+			},
+			wantOut: "nodes.example.com: refused: refusing to update nodes.example.com: it would go from 1 addresses to 0",
+			wantErr: true,
+		},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			zap.ReplaceGlobals(zaptest.NewLogger(t))
+			ctx := context.Background()
+			s := fakedo.New("example.com", "corp.example")
+			defer s.Close()
+			for _, r := range test.existing {
+				s.AddRecord("example.com", r)
+			}
+			nd := test.nd
+			nd.DNSProvider = "digitalocean"
+			f := testFactory(t, &nd)
+			f.godo = func(zone string) *godo.Client { return s.Client() }
+			if err := checkSyncFlags(f); err != nil {
+				t.Fatalf("check flags: %v", err)
+			}
+			records, err := newMainRecords(ctx, f, &dns.Config{Zone: "example.com", TTL: time.Minute}, &nd)
+			if err != nil {
+				t.Fatalf("newMainRecords: %v", err)
+			}
+			changes, err := syncNodes(records, &nd, syncTestNodes())
+			if err != nil {
+				t.Fatalf("sync: %v", err)
+			}
+			var failed bool
+			for _, c := range changes {
+				failed = failed || c.Error != ""
+			}
+			if got, want := failed, test.wantErr; got != want {
+				t.Errorf("failed:\n  got: %v\n want: %v", got, want)
+			}
+			out := new(bytes.Buffer)
+			if err := printSyncChanges(out, changes, false); err != nil {
+				t.Fatalf("print: %v", err)
+			}
+			if got, want := out.String(), test.wantOut; !strings.HasPrefix(got, want) || (!test.wantErr && got != want) {
+				t.Errorf("output:\n  got: %q\n want: %q", got, want)
+			}
+			got := map[string]map[string][]string{
+				"example.com":  s.Addresses("example.com"),
+				"corp.example": s.Addresses("corp.example"),
+			}
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("addresses after sync:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSyncNodesUnplanned(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	ctx := context.Background()
+	for _, dryRun := range []bool{false, true} {
+		nd := &nodednsflags{DNSProvider: "fake", External: "nodes", IsDryRun: dryRun}
+		records, err := newMainRecords(ctx, testFactory(t, nd), &dns.Config{Zone: "example.com", TTL: time.Minute}, nd)
+		if err != nil {
+			t.Fatalf("newMainRecords: %v", err)
+		}
+		changes, err := syncNodes(records, nd, syncTestNodes())
+		if err != nil {
+			t.Fatalf("sync: %v", err)
+		}
+		out := new(bytes.Buffer)
+		if err := printSyncChanges(out, changes, false); err != nil {
+			t.Fatalf("print: %v", err)
+		}
+		if got, want := out.String(), "nodes.example.com: 1.2.3.4 1.2.3.5 2001:db8::1 (the dns provider can't plan updates)\n"; got != want {
+			t.Errorf("dry run %v: output:\n  got: %q\n want: %q", dryRun, got, want)
+		}
+		want := map[string][]string{"nodes.example.com": {"1.2.3.4", "1.2.3.5", "2001:db8::1"}}
+		if dryRun {
+			want = map[string][]string{}
+		}
+		if diff := cmp.Diff(unguard(records.Default).(*dns.Fake).Addresses(), want); diff != "" {
+			t.Errorf("dry run %v: addresses after sync:\n%s", dryRun, diff)
+		}
+	}
+}
+
+func TestCheckSyncFlags(t *testing.T) {
+	testData := []struct {
+		name    string
+		nd      nodednsflags
+		wantErr bool
+	}{
+		{name: "ok", nd: nodednsflags{DNSProvider: "digitalocean", External: "nodes", TXTOwnerID: "main"}},
+		{name: "no records", nd: nodednsflags{DNSProvider: "digitalocean"}, wantErr: true},
+		{name: "owner without a registry", nd: nodednsflags{DNSProvider: "fake", External: "nodes", TXTOwnerID: "main"}, wantErr: true},
+		{name: "owner with a split provider", nd: nodednsflags{DNSProvider: "digitalocean", External: "nodes", ExternalDNS: "file", TXTOwnerID: "main"}, wantErr: true},
+		{name: "webhook without a url", nd: nodednsflags{DNSProvider: "webhook", External: "nodes"}, wantErr: true},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			nd := test.nd
+			err := checkSyncFlags(testFactory(t, &nd))
+			if got, want := err != nil, test.wantErr; got != want {
+				t.Errorf("error: %v, want error: %v", err, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/k8s"
+	"github.com/jrockway/nodedns/pkg/watchdog"
+)
+
+type watchflags struct {
+	URL      string        `long:"url" description:"the debug address of the nodedns to watch" default:"http://127.0.0.1:8081"`
+	Interval time.Duration `long:"interval" description:"how often to refresh" default:"2s"`
+	Resolver string        `long:"resolver" description:"the dns server (host:port) that records are resolved with, to show what's published; if empty, the system resolver is used"`
+	Errors   int           `long:"errors" description:"how many of the most recent errors to show" default:"10"`
+	Once     bool          `long:"once" description:"print the status once and exit, rather than redrawing it until interrupted"`
+}
+
+// watchMain shows what a running nodedns is doing, redrawing the terminal every interval: the
+// nodes of each store and why any aren't published, each record's desired and published
+// addresses, and the most recent errors.
+func watchMain(args []string) int {
+	wf := new(watchflags)
+	if code, ok := parseSubcommand("watch [OPTIONS]", args, flagGroup{"Watch", wf}); !ok {
+		return code
+	}
+	resolver := watchdog.NewResolver(wf.Resolver)
+	client := &http.Client{Timeout: wf.Interval}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	t := time.NewTicker(wf.Interval)
+	defer t.Stop()
+	for {
+		ctx, c := context.WithTimeout(context.Background(), wf.Interval)
+		stores, err := fetchStatus(ctx, client, wf.URL)
+		var frame strings.Builder
+		if !wf.Once {
+			// Move to the top left corner and clear the screen.
+			frame.WriteString("\x1b[H\x1b[2J")
+		}
+		fmt.Fprintf(&frame, "nodedns at %s, %s\n\n", wf.URL, time.Now().Format("15:04:05"))
+		if err != nil {
+			fmt.Fprintf(&frame, "problem fetching status: %v\n", err)
+		} else {
+			renderStatus(ctx, &frame, resolver, stores, wf.Errors)
+		}
+		c()
+		os.Stdout.WriteString(frame.String()) // nolint:errcheck
+		if wf.Once {
+			if err != nil {
+				return 1
+			}
+			return 0
+		}
+		select {
+		case <-sig:
+			return 0
+		case <-t.C:
+		}
+	}
+}
+
+// fetchStatus gets the status of every store from nodedns's debug server.
+func fetchStatus(ctx context.Context, client *http.Client, url string) ([]k8s.StoreStatus, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(url, "/")+"/debug/stores", nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("get status: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get status: unexpected status %s", res.Status)
+	}
+	var result []k8s.StoreStatus
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode status: %w", err)
+	}
+	return result, nil
+}
+
+// published resolves a record, returning its addresses sorted, or a description of the problem.
+func published(ctx context.Context, resolver watchdog.Resolver, name string) ([]string, string) {
+	addrs, err := resolver.LookupIPAddr(ctx, name)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+			return nil, err.Error()
+		}
+	}
+	result := []string{}
+	for _, a := range addrs {
+		result = append(result, a.IP.String())
+	}
+	sort.Strings(result)
+	return result, ""
+}
+
+// renderStatus writes one screenful of status.
+func renderStatus(ctx context.Context, out io.Writer, resolver watchdog.Resolver, stores []k8s.StoreStatus, maxErrors int) {
+	var errs []k8s.SinkHealth
+	for _, st := range stores {
+		fmt.Fprintf(out, "store %s\n", st.Store)
+		w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "  NODE\tSTATUS\tINTERNAL\tEXTERNAL\tOVERLAY")
+		for _, n := range st.Nodes {
+			status := "published"
+			switch {
+			case n.Terminating:
+				status = "terminating"
+			case n.Excluded != "":
+				status = "excluded: " + n.Excluded
+			case len(n.Internal)+len(n.External)+len(n.Overlay) == 0:
+				status = "no addresses"
+			}
+			if len(n.Pinned) > 0 {
+				status += " (pinned " + strings.Join(n.Pinned, ",") + ")"
+			}
+			name := n.Name
+			if n.Cluster != "" {
+				name = n.Cluster + "/" + n.Name
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n", name, status, strings.Join(n.Internal, ","), strings.Join(n.External, ","), strings.Join(n.Overlay, ","))
+		}
+		w.Flush() // nolint:errcheck
+		fmt.Fprintln(out)
+
+		w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "  RECORD\tKIND\tDESIRED\tPUBLISHED\t")
+		for _, r := range st.Records {
+			names := r.Names
+			if len(names) == 0 {
+				names = []string{"(not published)"}
+			}
+			for _, name := range names {
+				var live, state string
+				if len(r.Names) > 0 {
+					addrs, problem := published(ctx, resolver, name)
+					switch {
+					case problem != "":
+						live, state = problem, "UNKNOWN"
+					case strings.Join(addrs, ",") == strings.Join(r.Addresses, ","):
+						live, state = strings.Join(addrs, ","), "in sync"
+					default:
+						live, state = strings.Join(addrs, ","), "DIFFERS"
+					}
+				}
+				fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n", name, r.Kind, strings.Join(r.Addresses, ","), live, state)
+			}
+		}
+		w.Flush() // nolint:errcheck
+		fmt.Fprintln(out)
+		for _, h := range st.Sinks {
+			if h.LastError != "" {
+				errs = append(errs, h)
+			}
+		}
+	}
+
+	sort.Slice(errs, func(i, j int) bool { return errs[i].LastFailure.After(errs[j].LastFailure) })
+	if len(errs) > maxErrors {
+		errs = errs[:maxErrors]
+	}
+	fmt.Fprintln(out, "recent errors")
+	if len(errs) == 0 {
+		fmt.Fprintln(out, "  none")
+	}
+	for _, h := range errs {
+		streak := "since recovered"
+		if h.ConsecutiveFailures > 0 {
+			streak = fmt.Sprintf("%d in a row", h.ConsecutiveFailures)
+		}
+		fmt.Fprintf(out, "  %s %s/%s (%s): %s\n", h.LastFailure.Local().Format("15:04:05"), h.Store, h.Sink, streak, h.LastError)
+	}
+}
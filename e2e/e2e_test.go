@@ -0,0 +1,175 @@
+// Package e2e tests nodedns against a real Kubernetes API server (from envtest) and a fake DNS
+// provider.  The tests are skipped unless KUBEBUILDER_ASSETS points at the etcd and kube-apiserver
+// binaries, like "$(setup-envtest use -p path 1.21.x)", and fail instead if CI is set; see
+// https://book.kubebuilder.io/reference/envtest.html.
+package e2e
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/dns"
+	"github.com/jrockway/nodedns/pkg/fakedo"
+	"github.com/jrockway/nodedns/pkg/k8s"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+func startAPIServer(t *testing.T) *envtest.Environment {
+	t.Helper()
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		// CI installs the binaries, so a missing path there is a broken pipeline, not a
+		// developer's machine without them.
+		if os.Getenv("CI") != "" {
+			t.Fatal("KUBEBUILDER_ASSETS is not set, but CI is; install the binaries with setup-envtest")
+		}
+		t.Skip("KUBEBUILDER_ASSETS is not set; skipping end-to-end test")
+	}
+	env := &envtest.Environment{}
+	if _, err := env.Start(); err != nil {
+		t.Fatalf("start envtest: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := env.Stop(); err != nil {
+			t.Errorf("stop envtest: %v", err)
+		}
+	})
+	return env
+}
+
+func createNode(ctx context.Context, t *testing.T, k kubernetes.Interface, name string, labels map[string]string, addrs ...v1.NodeAddress) {
+	t.Helper()
+	node, err := k.CoreV1().Nodes().Create(ctx, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("create node %s: %v", name, err)
+	}
+	node.Status.Addresses = addrs
+	if _, err := k.CoreV1().Nodes().UpdateStatus(ctx, node, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update node %s status: %v", name, err)
+	}
+}
+
+// waitForRecords waits until the fake provider's records equal want.
+func waitForRecords(t *testing.T, f *fakedo.Server, want map[string][]string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		diff := cmp.Diff(f.Addresses("example.com"), want)
+		if diff == "" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("records did not converge:\n%s", diff)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestNodesToDNS(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	env := startAPIServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fake := fakedo.New("example.com")
+	defer fake.Close()
+	dnsClient, err := dns.NewClientFromGodo(ctx, fake.Client(), "example.com", time.Minute)
+	if err != nil {
+		t.Fatalf("new dns client: %v", err)
+	}
+	domains := map[k8s.Kind]string{k8s.Internal: "internal", k8s.External: "nodes"}
+	ns := k8s.NewNodeStore("e2e")
+	ns.OnChange = func(req k8s.UpdateRequest) error {
+		if err := dnsClient.UpdateDNS(req.Ctx, domains[req.Record.Kind], req.Record.IPs); err != nil {
+			t.Errorf("update dns: %v", err)
+		}
+		return nil
+	}
+	go func() {
+		if err := k8s.WatchNodesWithConfig(ctx, env.Config, "role=worker", 0, ns); err != nil {
+			t.Errorf("watch nodes: %v", err)
+		}
+	}()
+
+	k, err := kubernetes.NewForConfig(env.Config)
+	if err != nil {
+		t.Fatalf("new clientset: %v", err)
+	}
+	worker := map[string]string{"role": "worker"}
+	createNode(ctx, t, k, "node-1", worker,
+		v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.1"},
+		v1.NodeAddress{Type: v1.NodeExternalIP, Address: "42.0.0.1"})
+	createNode(ctx, t, k, "node-2", worker,
+		v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.2"},
+		v1.NodeAddress{Type: v1.NodeExternalIP, Address: "2001:db8::2"})
+	createNode(ctx, t, k, "control-plane", map[string]string{"role": "control-plane"},
+		v1.NodeAddress{Type: v1.NodeInternalIP, Address: "10.0.0.3"})
+	waitForRecords(t, fake, map[string][]string{
+		"internal": {"10.0.0.1", "10.0.0.2"},
+		"nodes":    {"2001:db8::2", "42.0.0.1"},
+	})
+
+	node, err := k.CoreV1().Nodes().Get(ctx, "node-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get node-1: %v", err)
+	}
+	node.Spec.Unschedulable = true
+	if _, err := k.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("cordon node-1: %v", err)
+	}
+	waitForRecords(t, fake, map[string][]string{
+		"internal": {"10.0.0.2"},
+		"nodes":    {"2001:db8::2"},
+	})
+
+	if err := k.CoreV1().Nodes().Delete(ctx, "node-2", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("delete node-2: %v", err)
+	}
+	waitForRecords(t, fake, map[string][]string{})
+}
+
+func TestAgent(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	env := startAPIServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fake := fakedo.New("example.com")
+	defer fake.Close()
+	dnsClient, err := dns.NewClientFromGodo(ctx, fake.Client(), "example.com", time.Minute)
+	if err != nil {
+		t.Fatalf("new dns client: %v", err)
+	}
+	ns := k8s.NewNodeStore("agent")
+	ns.OnChange = func(req k8s.UpdateRequest) error {
+		if req.Record.Kind != k8s.External {
+			return nil
+		}
+		if err := dnsClient.UpdateDNS(req.Ctx, "node-1", req.Record.IPs); err != nil {
+			t.Errorf("update dns: %v", err)
+		}
+		return nil
+	}
+	go func() {
+		if err := k8s.WatchNodeWithConfig(ctx, env.Config, "node-1", 0, ns); err != nil {
+			t.Errorf("watch node: %v", err)
+		}
+	}()
+
+	k, err := kubernetes.NewForConfig(env.Config)
+	if err != nil {
+		t.Fatalf("new clientset: %v", err)
+	}
+	createNode(ctx, t, k, "node-1", nil, v1.NodeAddress{Type: v1.NodeExternalIP, Address: "42.0.0.1"})
+	createNode(ctx, t, k, "node-2", nil, v1.NodeAddress{Type: v1.NodeExternalIP, Address: "42.0.0.2"})
+	waitForRecords(t, fake, map[string][]string{
+		"node-1": {"42.0.0.1"},
+	})
+}
@@ -3,14 +3,34 @@ module github.com/jrockway/nodedns
 go 1.13
 
 require (
+	github.com/aws/aws-sdk-go v1.40.56
+	github.com/coreos/go-oidc v2.2.1+incompatible
 	github.com/digitalocean/godo v1.60.0
-	github.com/google/go-cmp v0.5.5
+	github.com/go-logr/zapr v0.4.0
+	github.com/google/go-cmp v0.5.6
+	github.com/jessevdk/go-flags v1.5.0
 	github.com/jrockway/opinionated-server v0.0.22
-	github.com/opentracing/opentracing-go v1.2.0
+	github.com/miekg/dns v1.1.43
+	github.com/pquerna/cachecontrol v0.2.0 // indirect
 	github.com/prometheus/client_golang v1.11.0
+	github.com/prometheus/client_model v0.2.0
+	go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc v0.26.0
+	go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp v0.26.0
+	go.opentelemetry.io/otel v1.1.0
+	go.opentelemetry.io/otel/exporters/jaeger v1.1.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc v1.1.0
+	go.opentelemetry.io/otel/sdk v1.1.0
+	go.opentelemetry.io/otel/trace v1.1.0
 	go.uber.org/zap v1.19.1
+	golang.org/x/net v0.0.0-20210917221730-978cfadd31cf
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
-	k8s.io/api v0.21.0
-	k8s.io/apimachinery v0.21.0
-	k8s.io/client-go v0.21.0
+	golang.org/x/time v0.0.0-20210220033141-f8bda1e9f3ba
+	google.golang.org/grpc v1.42.0
+	google.golang.org/protobuf v1.27.1
+	gopkg.in/square/go-jose.v2 v2.6.0
+	k8s.io/api v0.21.1
+	k8s.io/apimachinery v0.21.1
+	k8s.io/client-go v0.21.1
+	sigs.k8s.io/controller-runtime v0.9.0
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -0,0 +1,229 @@
+// Package admin serves an HTTP API that lets operators control a running nodedns.
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/jrockway/nodedns/pkg/k8s"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var (
+	adminActions = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "admin_actions",
+			Help: "The number of admin API actions, by action and whether they were authorized.",
+		},
+		[]string{"action", "authorized"},
+	)
+	pausedGauge = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "updates_paused",
+			Help: "1 if updates have been paused via the admin API.",
+		},
+	)
+)
+
+// Authenticator identifies the caller of an admin API request.
+type Authenticator interface {
+	// Authenticate returns the identity of the caller, or an error if the caller may not use
+	// the admin API.
+	Authenticate(req *http.Request) (string, error)
+}
+
+// ErrNoCredentials is returned by Authenticators when the request has no credentials.
+var ErrNoCredentials = errors.New("no credentials provided")
+
+// ErrForbidden is wrapped by Authenticators that identified the caller, but found that they may not
+// perform the requested action.
+var ErrForbidden = errors.New("forbidden")
+
+// bearerToken returns the bearer token from the request's Authorization header.
+func bearerToken(req *http.Request) (string, error) {
+	h := req.Header.Get("Authorization")
+	if h == "" {
+		return "", ErrNoCredentials
+	}
+	parts := strings.SplitN(h, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") {
+		return "", errors.New("authorization header is not a bearer token")
+	}
+	return parts[1], nil
+}
+
+// Resyncer is something that can be forced to resync, like k8s.NodeStore.
+type Resyncer interface {
+	Resync() error
+}
+
+// Server is the admin API.
+type Server struct {
+	Store  Resyncer      // The store to resync when a force-sync is requested.
+	Auth   Authenticator // If nil, every request is allowed.
+	Logger *zap.Logger
+
+	// Handoff, if set, serves the handoff protocol at /api/handoff/ (see package handoff).
+	Handoff http.Handler
+
+	// Status, if set, reports the state of every store, for /api/nodes and /api/records.
+	Status func() []k8s.StoreStatus
+
+	paused int32
+}
+
+// NewServer returns an admin Server.
+func NewServer(store Resyncer, auth Authenticator) *Server {
+	return &Server{Store: store, Auth: auth, Logger: zap.L().Named("admin")}
+}
+
+// Paused returns true if updates have been paused by an operator.
+func (s *Server) Paused() bool {
+	return atomic.LoadInt32(&s.paused) == 1
+}
+
+func (s *Server) setPaused(paused bool) {
+	var v int32
+	if paused {
+		v = 1
+	}
+	atomic.StoreInt32(&s.paused, v)
+	pausedGauge.Set(float64(v))
+}
+
+// Handler returns an http.Handler that serves the admin API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/api/resync", s.action("resync", http.MethodPost, func(w http.ResponseWriter, req *http.Request, l *zap.Logger) {
+		// Resync blocks until the resync is complete, so that the caller can tell whether
+		// it worked.
+		if err := s.Store.Resync(); err != nil {
+			l.Error("forced resync failed", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"resynced": true})
+	}))
+	mux.Handle("/api/pause", s.action("pause", http.MethodPost, func(w http.ResponseWriter, req *http.Request, l *zap.Logger) {
+		s.setPaused(true)
+		writeJSON(w, map[string]interface{}{"paused": true})
+	}))
+	mux.Handle("/api/resume", s.action("resume", http.MethodPost, func(w http.ResponseWriter, req *http.Request, l *zap.Logger) {
+		s.setPaused(false)
+		// Changes that happened while paused were not applied, so apply the current state now.
+		if err := s.Store.Resync(); err != nil {
+			l.Error("resync after resume failed", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"paused": false})
+	}))
+	mux.Handle("/api/handoff/", s.action("handoff", http.MethodPost, func(w http.ResponseWriter, req *http.Request, l *zap.Logger) {
+		if s.Handoff == nil {
+			http.NotFound(w, req)
+			return
+		}
+		s.Handoff.ServeHTTP(w, req)
+	}))
+	mux.Handle("/api/status", s.action("status", http.MethodGet, func(w http.ResponseWriter, req *http.Request, l *zap.Logger) {
+		writeJSON(w, map[string]interface{}{"paused": s.Paused()})
+	}))
+	mux.Handle("/api/nodes", s.action("nodes", http.MethodGet, func(w http.ResponseWriter, req *http.Request, l *zap.Logger) {
+		type storeNodes struct {
+			Store string           `json:"store"`
+			Nodes []k8s.NodeStatus `json:"nodes"`
+		}
+		stores, err := s.stores(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		result := []storeNodes{}
+		for _, st := range stores {
+			result = append(result, storeNodes{Store: st.Store, Nodes: st.Nodes})
+		}
+		writeJSON(w, result)
+	}))
+	mux.Handle("/api/records", s.action("records", http.MethodGet, func(w http.ResponseWriter, req *http.Request, l *zap.Logger) {
+		type storeRecords struct {
+			Store   string             `json:"store"`
+			Records []k8s.RecordStatus `json:"records"`
+			Sinks   []k8s.SinkHealth   `json:"sinks"`
+		}
+		stores, err := s.stores(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		result := []storeRecords{}
+		for _, st := range stores {
+			result = append(result, storeRecords{Store: st.Store, Records: st.Records, Sinks: st.Sinks})
+		}
+		writeJSON(w, result)
+	}))
+	mux.HandleFunc("/api/openapi.json", serveOpenAPI)
+	return mux
+}
+
+// action wraps an admin API handler with method checking, authentication, and audit logging.
+func (s *Server) action(name, method string, h func(w http.ResponseWriter, req *http.Request, l *zap.Logger)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != method {
+			w.Header().Set("Allow", method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		identity := "anonymous"
+		if s.Auth != nil {
+			var err error
+			identity, err = s.Auth.Authenticate(req)
+			if err != nil {
+				adminActions.WithLabelValues(name, "false").Inc()
+				s.Logger.Warn("rejected admin api request", zap.String("action", name), zap.String("remote_addr", req.RemoteAddr), zap.Error(err))
+				if errors.Is(err, ErrForbidden) {
+					http.Error(w, err.Error(), http.StatusForbidden)
+					return
+				}
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized: "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+		}
+		adminActions.WithLabelValues(name, "true").Inc()
+		l := s.Logger.With(zap.String("action", name), zap.String("identity", identity))
+		if method != http.MethodGet {
+			l.Info("admin action")
+		}
+		h(w, req, l)
+	})
+}
+
+// stores returns the status of every store, or only the store named by the "store" query
+// parameter.
+func (s *Server) stores(req *http.Request) ([]k8s.StoreStatus, error) {
+	var all []k8s.StoreStatus
+	if s.Status != nil {
+		all = s.Status()
+	}
+	name := req.URL.Query().Get("store")
+	if name == "" {
+		return all, nil
+	}
+	for _, st := range all {
+		if st.Store == name {
+			return []k8s.StoreStatus{st}, nil
+		}
+	}
+	return nil, fmt.Errorf("no store named %q", name)
+}
+
+func writeJSON(w http.ResponseWriter, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(obj) // nolint:errcheck
+}
@@ -0,0 +1,234 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/k8s"
+	"go.uber.org/zap/zaptest"
+)
+
+type fakeStore struct {
+	resyncs int
+	err     error
+}
+
+func (s *fakeStore) Resync() error {
+	s.resyncs++
+	return s.err
+}
+
+type fakeAuth map[string]string
+
+func (a fakeAuth) Authenticate(req *http.Request) (string, error) {
+	raw, err := bearerToken(req)
+	if err != nil {
+		return "", err
+	}
+	id, ok := a[raw]
+	if !ok {
+		return "", errors.New("bad token")
+	}
+	return id, nil
+}
+
+func TestServer(t *testing.T) {
+	store := &fakeStore{}
+	s := NewServer(store, fakeAuth{"good": "alice@example.com"})
+	s.Logger = zaptest.NewLogger(t)
+	h := s.Handler()
+
+	testData := []struct {
+		name, method, path, token string
+		wantCode                  int
+		wantPaused                bool
+		wantResyncs               int
+	}{
+		{name: "no token", method: "POST", path: "/api/pause", wantCode: http.StatusUnauthorized},
+		{name: "bad token", method: "POST", path: "/api/pause", token: "bad", wantCode: http.StatusUnauthorized},
+		{name: "wrong method", method: "GET", path: "/api/pause", token: "good", wantCode: http.StatusMethodNotAllowed},
+		{name: "pause", method: "POST", path: "/api/pause", token: "good", wantCode: http.StatusOK, wantPaused: true},
+		{name: "status", method: "GET", path: "/api/status", token: "good", wantCode: http.StatusOK, wantPaused: true},
+		{name: "unauthenticated resume", method: "POST", path: "/api/resume", wantCode: http.StatusUnauthorized, wantPaused: true},
+		{name: "resync", method: "POST", path: "/api/resync", token: "good", wantCode: http.StatusOK, wantPaused: true, wantResyncs: 1},
+		{name: "resume", method: "POST", path: "/api/resume", token: "good", wantCode: http.StatusOK, wantResyncs: 2},
+	}
+	for _, test := range testData {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if got, want := rec.Code, test.wantCode; got != want {
+			t.Errorf("%s: status:\n  got: %v\n want: %v", test.name, got, want)
+		}
+		if got, want := s.Paused(), test.wantPaused; got != want {
+			t.Errorf("%s: paused:\n  got: %v\n want: %v", test.name, got, want)
+		}
+		if got, want := store.resyncs, test.wantResyncs; got != want {
+			t.Errorf("%s: resyncs:\n  got: %v\n want: %v", test.name, got, want)
+		}
+	}
+}
+
+func TestResyncError(t *testing.T) {
+	s := NewServer(&fakeStore{err: errors.New("boom")}, nil)
+	s.Logger = zaptest.NewLogger(t)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("POST", "/api/resync", nil))
+	if got, want := rec.Code, http.StatusInternalServerError; got != want {
+		t.Errorf("status:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+func TestHandoff(t *testing.T) {
+	s := NewServer(&fakeStore{}, fakeAuth{"good": "alice@example.com"})
+	s.Logger = zaptest.NewLogger(t)
+	h := s.Handler()
+	post := func(token string) int {
+		req := httptest.NewRequest("POST", "/api/handoff/prepare", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if got, want := post("good"), http.StatusNotFound; got != want {
+		t.Errorf("no handoff:\n  got: %v\n want: %v", got, want)
+	}
+	var called string
+	s.Handoff = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		called = req.URL.Path
+	})
+	if got, want := post(""), http.StatusUnauthorized; got != want {
+		t.Errorf("unauthenticated:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := post("good"), http.StatusOK; got != want {
+		t.Errorf("handoff:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := called, "/api/handoff/prepare"; got != want {
+		t.Errorf("handoff path:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+func TestState(t *testing.T) {
+	s := NewServer(&fakeStore{}, nil)
+	s.Logger = zaptest.NewLogger(t)
+	h := s.Handler()
+	get := func(path string, result interface{}) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), result); err != nil {
+				t.Fatalf("%s: unmarshal: %v", path, err)
+			}
+		}
+		return rec.Code
+	}
+	var nodes []map[string]interface{}
+	if got, want := get("/api/nodes", &nodes), http.StatusOK; got != want {
+		t.Errorf("no stores: status:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := len(nodes), 0; got != want {
+		t.Errorf("no stores: stores:\n  got: %v\n want: %v", got, want)
+	}
+
+	s.Status = func() []k8s.StoreStatus {
+		return []k8s.StoreStatus{
+			{
+				Store: "a",
+				Nodes: []k8s.NodeStatus{{Name: "host-1", External: []string{"42.0.0.1"}}},
+				Records: []k8s.RecordStatus{{
+					Kind:      k8s.External,
+					Addresses: []string{"42.0.0.1"},
+					Applied:   []k8s.AppliedRecord{{Sink: "dns", Addresses: []string{}, LastError: "boom"}},
+				}},
+				Sinks: []k8s.SinkHealth{{Store: "a", Sink: "dns", LastError: "boom", ConsecutiveFailures: 1}},
+			},
+			{Store: "b", Nodes: []k8s.NodeStatus{{Name: "host-2", Excluded: "not ready"}}},
+		}
+	}
+	type storeNodes struct {
+		Store string           `json:"store"`
+		Nodes []k8s.NodeStatus `json:"nodes"`
+	}
+	var gotNodes []storeNodes
+	if got, want := get("/api/nodes?store=b", &gotNodes), http.StatusOK; got != want {
+		t.Errorf("nodes: status:\n  got: %v\n want: %v", got, want)
+	}
+	if diff := cmp.Diff(gotNodes, []storeNodes{{Store: "b", Nodes: []k8s.NodeStatus{{Name: "host-2", Excluded: "not ready"}}}}); diff != "" {
+		t.Errorf("nodes:\n%s", diff)
+	}
+
+	var gotRecords []struct {
+		Store   string             `json:"store"`
+		Records []k8s.RecordStatus `json:"records"`
+		Sinks   []k8s.SinkHealth   `json:"sinks"`
+	}
+	if got, want := get("/api/records", &gotRecords), http.StatusOK; got != want {
+		t.Errorf("records: status:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := len(gotRecords), 2; got != want {
+		t.Fatalf("records: stores:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := gotRecords[0].Records[0].Applied[0].LastError, "boom"; got != want {
+		t.Errorf("records: last error:\n  got: %v\n want: %v", got, want)
+	}
+
+	if got, want := get("/api/records?store=c", &gotRecords), http.StatusNotFound; got != want {
+		t.Errorf("unknown store: status:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	s := NewServer(&fakeStore{}, fakeAuth{"good": "alice@example.com"})
+	s.Logger = zaptest.NewLogger(t)
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/api/openapi.json", nil))
+	if got, want := rec.Code, http.StatusOK; got != want {
+		t.Fatalf("status:\n  got: %v\n want: %v", got, want)
+	}
+	var spec struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	var got []string
+	for path, ops := range spec.Paths {
+		for method := range ops {
+			got = append(got, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(got)
+	want := []string{
+		"GET /api/nodes",
+		"GET /api/records",
+		"GET /api/status",
+		"POST /api/handoff/commit",
+		"POST /api/handoff/prepare",
+		"POST /api/pause",
+		"POST /api/resume",
+		"POST /api/resync",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("operations:\n%s", diff)
+	}
+	// Every described operation is served, and requires a token.
+	for _, op := range got {
+		parts := strings.SplitN(op, " ", 2)
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest(parts[0], parts[1], nil))
+		if got, want := rec.Code, http.StatusUnauthorized; got != want {
+			t.Errorf("%s: status:\n  got: %v\n want: %v", op, got, want)
+		}
+	}
+}
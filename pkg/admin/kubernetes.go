@@ -0,0 +1,92 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// RBACGroup and RBACResource name the resource that Kubernetes RBAC rules grant access to the admin
+// API on.  Each action is a subresource, like admin/resync; actions that change something need the
+// "create" verb, and the others "get".
+const (
+	RBACGroup    = "nodedns.jrockway.com"
+	RBACResource = "admin"
+)
+
+// Kubernetes authenticates requests that carry a Kubernetes token, like a service account token,
+// as a bearer token, with a TokenReview, and then asks the API server whether the caller may
+// perform the requested action with a SubjectAccessReview, so that cluster RBAC decides who may use
+// the admin API.
+type Kubernetes struct {
+	Client    kubernetes.Interface
+	Audiences []string // If set, the token must be issued for one of these audiences.
+}
+
+// NewKubernetes returns a Kubernetes Authenticator that reviews tokens with client.
+func NewKubernetes(client kubernetes.Interface, audiences []string) *Kubernetes {
+	return &Kubernetes{Client: client, Audiences: audiences}
+}
+
+// attributes returns the resource attributes that the request needs access to.
+func attributes(req *http.Request) *authorizationv1.ResourceAttributes {
+	action := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/api/"), "/", 2)[0]
+	verb := "create"
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		verb = "get"
+	}
+	return &authorizationv1.ResourceAttributes{
+		Group:       RBACGroup,
+		Resource:    RBACResource,
+		Subresource: action,
+		Verb:        verb,
+	}
+}
+
+// Authenticate implements Authenticator.
+func (a *Kubernetes) Authenticate(req *http.Request) (string, error) {
+	raw, err := bearerToken(req)
+	if err != nil {
+		return "", err
+	}
+	ctx := req.Context()
+	tr, err := a.Client.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: raw, Audiences: a.Audiences},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("review token: %w", err)
+	}
+	if !tr.Status.Authenticated {
+		if tr.Status.Error != "" {
+			return "", fmt.Errorf("token not authenticated: %s", tr.Status.Error)
+		}
+		return "", fmt.Errorf("token not authenticated")
+	}
+	user := tr.Status.User
+	extra := make(map[string]authorizationv1.ExtraValue)
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	attrs := attributes(req)
+	sar, err := a.Client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: attrs,
+			User:               user.Username,
+			UID:                user.UID,
+			Groups:             user.Groups,
+			Extra:              extra,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("review access: %w", err)
+	}
+	if !sar.Status.Allowed {
+		return "", fmt.Errorf("%s may not %s %s.%s/%s: %w", user.Username, attrs.Verb, attrs.Resource, attrs.Group, attrs.Subresource, ErrForbidden)
+	}
+	return user.Username, nil
+}
@@ -0,0 +1,69 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestKubernetes(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	// The "operator" token may do anything; the "viewer" token may only get the status.
+	client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		tr := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		switch tr.Spec.Token {
+		case "operator", "viewer":
+			tr.Status.Authenticated = true
+			tr.Status.User.Username = "system:serviceaccount:ops:" + tr.Spec.Token
+		default:
+			tr.Status.Error = "invalid token"
+		}
+		return true, tr, nil
+	})
+	client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		sar := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attrs := sar.Spec.ResourceAttributes
+		if attrs.Group != RBACGroup || attrs.Resource != RBACResource {
+			t.Errorf("unexpected resource %s.%s", attrs.Resource, attrs.Group)
+		}
+		sar.Status.Allowed = sar.Spec.User == "system:serviceaccount:ops:operator" || (attrs.Subresource == "status" && attrs.Verb == "get")
+		return true, sar, nil
+	})
+
+	store := &fakeStore{}
+	s := NewServer(store, NewKubernetes(client, nil))
+	s.Logger = zaptest.NewLogger(t)
+	h := s.Handler()
+
+	testData := []struct {
+		name, method, path, token string
+		wantCode                  int
+	}{
+		{name: "no token", method: "POST", path: "/api/pause", wantCode: http.StatusUnauthorized},
+		{name: "bad token", method: "POST", path: "/api/pause", token: "bad", wantCode: http.StatusUnauthorized},
+		{name: "viewer status", method: "GET", path: "/api/status", token: "viewer", wantCode: http.StatusOK},
+		{name: "viewer pause", method: "POST", path: "/api/pause", token: "viewer", wantCode: http.StatusForbidden},
+		{name: "operator pause", method: "POST", path: "/api/pause", token: "operator", wantCode: http.StatusOK},
+	}
+	for _, test := range testData {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		if test.token != "" {
+			req.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if got, want := rec.Code, test.wantCode; got != want {
+			t.Errorf("%s: code:\n  got: %v\n want: %v\n body: %s", test.name, got, want, rec.Body.String())
+		}
+	}
+	if !s.Paused() {
+		t.Error("not paused after the operator paused")
+	}
+}
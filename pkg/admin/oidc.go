@@ -0,0 +1,63 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/coreos/go-oidc"
+)
+
+// OIDC authenticates requests that carry an OIDC ID token, issued by a particular issuer for a
+// particular audience, as a bearer token.
+type OIDC struct {
+	verifier *oidc.IDTokenVerifier
+	allowed  map[string]struct{}
+}
+
+// NewOIDC discovers the issuer's configuration and returns an OIDC Authenticator.  If allowed is
+// non-empty, only tokens whose email (or, if there is no email claim, subject) is in allowed are
+// accepted.
+func NewOIDC(ctx context.Context, issuer, audience string, allowed []string) (*OIDC, error) {
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("discover oidc provider %q: %w", issuer, err)
+	}
+	a := &OIDC{
+		verifier: provider.Verifier(&oidc.Config{ClientID: audience}),
+		allowed:  make(map[string]struct{}),
+	}
+	for _, id := range allowed {
+		a.allowed[id] = struct{}{}
+	}
+	return a, nil
+}
+
+// Authenticate implements Authenticator.
+func (a *OIDC) Authenticate(req *http.Request) (string, error) {
+	raw, err := bearerToken(req)
+	if err != nil {
+		return "", err
+	}
+	token, err := a.verifier.Verify(req.Context(), raw)
+	if err != nil {
+		return "", fmt.Errorf("verify token: %w", err)
+	}
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified *bool  `json:"email_verified"`
+	}
+	if err := token.Claims(&claims); err != nil {
+		return "", fmt.Errorf("parse claims: %w", err)
+	}
+	identity := token.Subject
+	if claims.Email != "" && (claims.EmailVerified == nil || *claims.EmailVerified) {
+		identity = claims.Email
+	}
+	if len(a.allowed) > 0 {
+		if _, ok := a.allowed[identity]; !ok {
+			return "", fmt.Errorf("%s is not allowed to use the admin api", identity)
+		}
+	}
+	return identity, nil
+}
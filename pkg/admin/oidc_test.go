@@ -0,0 +1,112 @@
+package admin
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+// fakeIssuer is a minimal OIDC issuer that serves discovery and keys, and can sign tokens.
+type fakeIssuer struct {
+	*httptest.Server
+	signer jose.Signer
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: key, KeyID: "test"}}, nil)
+	if err != nil {
+		t.Fatalf("new signer: %v", err)
+	}
+	i := &fakeIssuer{signer: signer}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{ // nolint:errcheck
+			"issuer":                                i.URL,
+			"jwks_uri":                              i.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{ // nolint:errcheck
+			Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "test", Algorithm: "RS256", Use: "sig"}},
+		})
+	})
+	i.Server = httptest.NewServer(mux)
+	t.Cleanup(i.Close)
+	return i
+}
+
+func (i *fakeIssuer) token(t *testing.T, audience, subject, email string) string {
+	t.Helper()
+	claims := map[string]interface{}{
+		"iss": i.URL,
+		"aud": audience,
+		"sub": subject,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	if email != "" {
+		claims["email"] = email
+	}
+	raw, err := jwt.Signed(i.signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return raw
+}
+
+func TestOIDC(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	ctx := context.Background()
+	open, err := NewOIDC(ctx, issuer.URL, "nodedns", nil)
+	if err != nil {
+		t.Fatalf("new oidc: %v", err)
+	}
+	restricted, err := NewOIDC(ctx, issuer.URL, "nodedns", []string{"alice@example.com"})
+	if err != nil {
+		t.Fatalf("new oidc: %v", err)
+	}
+
+	testData := []struct {
+		name         string
+		auth         *OIDC
+		header       string
+		wantIdentity string
+		wantErr      bool
+	}{
+		{name: "no header", auth: open, wantErr: true},
+		{name: "basic auth", auth: open, header: "Basic Zm9vOmJhcg==", wantErr: true},
+		{name: "garbage", auth: open, header: "Bearer garbage", wantErr: true},
+		{name: "wrong audience", auth: open, header: "Bearer " + issuer.token(t, "other", "123", ""), wantErr: true},
+		{name: "subject", auth: open, header: "Bearer " + issuer.token(t, "nodedns", "123", ""), wantIdentity: "123"},
+		{name: "email", auth: open, header: "Bearer " + issuer.token(t, "nodedns", "123", "bob@example.com"), wantIdentity: "bob@example.com"},
+		{name: "allowed", auth: restricted, header: "Bearer " + issuer.token(t, "nodedns", "456", "alice@example.com"), wantIdentity: "alice@example.com"},
+		{name: "not allowed", auth: restricted, header: "Bearer " + issuer.token(t, "nodedns", "123", "bob@example.com"), wantErr: true},
+	}
+	for _, test := range testData {
+		req := httptest.NewRequest("POST", "/api/resync", nil)
+		if test.header != "" {
+			req.Header.Set("Authorization", test.header)
+		}
+		id, err := test.auth.Authenticate(req)
+		if got, want := err != nil, test.wantErr; got != want {
+			t.Errorf("%s: error:\n  got: %v\n want error: %v", test.name, err, want)
+		}
+		if got, want := id, test.wantIdentity; got != want {
+			t.Errorf("%s: identity:\n  got: %v\n want: %v", test.name, got, want)
+		}
+	}
+}
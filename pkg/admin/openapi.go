@@ -0,0 +1,237 @@
+package admin
+
+import "net/http"
+
+// OpenAPI is the OpenAPI 3 description of the admin API, served at /api/openapi.json.  Keep it
+// in sync with Handler; TestOpenAPI checks that every path is described.
+const OpenAPI = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "nodedns admin API",
+    "description": "Lets operators control a running nodedns.  Every action requires a bearer token: an OIDC ID token with --admin_oidc_issuer, or a Kubernetes token with --admin_kubernetes_auth.",
+    "version": "1"
+  },
+  "security": [{"bearer": []}],
+  "paths": {
+    "/api/resync": {
+      "post": {
+        "summary": "Push the current state of every record, immediately.",
+        "operationId": "resync",
+        "responses": {
+          "200": {"description": "The records were resynced.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Resynced"}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/pause": {
+      "post": {
+        "summary": "Stop changing DNS and every other integration until resumed.",
+        "operationId": "pause",
+        "responses": {
+          "200": {"description": "Updates are paused.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Paused"}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"}
+        }
+      }
+    },
+    "/api/resume": {
+      "post": {
+        "summary": "Resume updates, and apply the current state of every record.",
+        "operationId": "resume",
+        "responses": {
+          "200": {"description": "Updates are resumed.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Paused"}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "500": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/status": {
+      "get": {
+        "summary": "Report whether updates are paused.",
+        "operationId": "status",
+        "responses": {
+          "200": {"description": "The status.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Paused"}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"}
+        }
+      }
+    },
+    "/api/nodes": {
+      "get": {
+        "summary": "Report every node that each store knows about, and why it isn't published, if it isn't.",
+        "operationId": "nodes",
+        "parameters": [{"$ref": "#/components/parameters/Store"}],
+        "responses": {
+          "200": {"description": "The nodes of each store.", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/StoreNodes"}}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/records": {
+      "get": {
+        "summary": "Report the desired contents of each store's records, what each sink last applied, and the last error.",
+        "operationId": "records",
+        "parameters": [{"$ref": "#/components/parameters/Store"}],
+        "responses": {
+          "200": {"description": "The records of each store.", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/StoreRecords"}}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/handoff/prepare": {
+      "post": {
+        "summary": "Stop writing, for an upgrade, and return the published records.  Writing resumes unless the handoff is committed in time.",
+        "operationId": "handoffPrepare",
+        "responses": {
+          "200": {"description": "This instance stopped writing.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/HandoffState"}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"description": "This instance doesn't serve the handoff protocol."},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/api/handoff/commit": {
+      "post": {
+        "summary": "Complete a prepared handoff; this instance never writes again.",
+        "operationId": "handoffCommit",
+        "responses": {
+          "200": {"description": "The handoff is committed.", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Committed"}}}},
+          "401": {"$ref": "#/components/responses/Unauthorized"},
+          "403": {"$ref": "#/components/responses/Forbidden"},
+          "404": {"description": "This instance doesn't serve the handoff protocol."},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "bearer": {"type": "http", "scheme": "bearer"}
+    },
+    "parameters": {
+      "Store": {"name": "store", "in": "query", "required": false, "schema": {"type": "string"}, "description": "Only report the store with this name."}
+    },
+    "responses": {
+      "Unauthorized": {"description": "The request has no valid token.", "content": {"text/plain": {"schema": {"type": "string"}}}},
+      "Forbidden": {"description": "The caller may not perform this action.", "content": {"text/plain": {"schema": {"type": "string"}}}},
+      "Error": {"description": "The action failed.", "content": {"text/plain": {"schema": {"type": "string"}}}}
+    },
+    "schemas": {
+      "Resynced": {"type": "object", "required": ["resynced"], "properties": {"resynced": {"type": "boolean"}}},
+      "Paused": {"type": "object", "required": ["paused"], "properties": {"paused": {"type": "boolean"}}},
+      "Committed": {"type": "object", "required": ["committed"], "properties": {"committed": {"type": "boolean"}}},
+      "StoreNodes": {
+        "type": "object",
+        "required": ["store", "nodes"],
+        "properties": {
+          "store": {"type": "string"},
+          "nodes": {"type": "array", "items": {"$ref": "#/components/schemas/Node"}}
+        }
+      },
+      "Node": {
+        "type": "object",
+        "required": ["name"],
+        "properties": {
+          "name": {"type": "string"},
+          "cluster": {"type": "string", "description": "The cluster that the node is in, if it isn't the store's own."},
+          "excluded": {"type": "string", "description": "Why the node's own addresses aren't published, if they aren't."},
+          "terminating": {"type": "boolean"},
+          "internal": {"type": "array", "items": {"type": "string"}},
+          "external": {"type": "array", "items": {"type": "string"}},
+          "overlay": {"type": "array", "items": {"type": "string"}},
+          "pinned": {"type": "array", "items": {"type": "string"}, "description": "Pinned addresses, like external:192.0.2.1."}
+        }
+      },
+      "StoreRecords": {
+        "type": "object",
+        "required": ["store", "records", "sinks"],
+        "properties": {
+          "store": {"type": "string"},
+          "records": {"type": "array", "nullable": true, "items": {"$ref": "#/components/schemas/Record"}},
+          "sinks": {"type": "array", "items": {"$ref": "#/components/schemas/SinkHealth"}}
+        }
+      },
+      "Record": {
+        "type": "object",
+        "required": ["kind", "addresses"],
+        "properties": {
+          "kind": {"type": "string", "enum": ["internal", "external", "overlay"]},
+          "names": {"type": "array", "items": {"type": "string"}, "description": "The DNS records that the addresses are published in."},
+          "addresses": {"type": "array", "items": {"type": "string"}, "description": "The addresses that should be published."},
+          "applied": {"type": "array", "items": {"$ref": "#/components/schemas/AppliedRecord"}, "description": "What each sink last did with the record."}
+        }
+      },
+      "AppliedRecord": {
+        "type": "object",
+        "required": ["sink", "addresses", "last_success", "last_failure"],
+        "properties": {
+          "sink": {"type": "string"},
+          "addresses": {"type": "array", "items": {"type": "string"}, "description": "What the sink last published successfully."},
+          "last_success": {"type": "string", "format": "date-time"},
+          "last_failure": {"type": "string", "format": "date-time"},
+          "last_error": {"type": "string", "description": "Absent if the last update succeeded."}
+        }
+      },
+      "SinkHealth": {
+        "type": "object",
+        "required": ["store", "sink", "last_success", "last_failure", "consecutive_failures"],
+        "properties": {
+          "store": {"type": "string"},
+          "sink": {"type": "string"},
+          "last_success": {"type": "string", "format": "date-time"},
+          "last_failure": {"type": "string", "format": "date-time"},
+          "last_error": {"type": "string"},
+          "consecutive_failures": {"type": "integer"}
+        }
+      },
+      "HandoffState": {
+        "type": "object",
+        "required": ["stopped", "snapshots"],
+        "properties": {
+          "stopped": {"type": "string", "format": "date-time", "description": "When the instance stopped writing."},
+          "snapshots": {"type": "array", "nullable": true, "items": {"$ref": "#/components/schemas/Snapshot"}, "description": "The records that it published."}
+        }
+      },
+      "Snapshot": {
+        "type": "object",
+        "required": ["zone", "taken", "records"],
+        "properties": {
+          "zone": {"type": "string"},
+          "taken": {"type": "string", "format": "date-time"},
+          "records": {"type": "array", "nullable": true, "items": {"$ref": "#/components/schemas/SnapshotRecord"}}
+        }
+      },
+      "SnapshotRecord": {
+        "type": "object",
+        "required": ["name", "type", "ttl", "data"],
+        "properties": {
+          "name": {"type": "string", "description": "The name relative to the zone, like nodes."},
+          "type": {"type": "string", "enum": ["A", "AAAA"]},
+          "ttl": {"type": "integer", "description": "In seconds."},
+          "data": {"type": "string", "description": "The address."}
+        }
+      }
+    }
+  }
+}
+`
+
+// serveOpenAPI serves the OpenAPI description.  It doesn't require authentication, since it
+// describes nothing that isn't in the source code.
+func serveOpenAPI(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(OpenAPI)) // nolint:errcheck
+}
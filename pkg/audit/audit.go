@@ -0,0 +1,142 @@
+// Package audit keeps an append-only trail of every record that nodedns creates or deletes in DNS,
+// with the nodes and the store operation that caused each change, for when changes to public DNS
+// must be traceable.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"github.com/jrockway/nodedns/pkg/dns"
+	"github.com/jrockway/nodedns/pkg/k8s"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var auditEntries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "audit_entries",
+	Help: "The number of changes to DNS written to the audit log, by result (\"ok\" or \"error\").",
+}, []string{"result"})
+
+// Entry is one line of the audit log.
+type Entry struct {
+	Time          time.Time `json:"time"`
+	Action        string    `json:"action"` // "create" or "delete".
+	Provider      string    `json:"provider"`
+	Zone          string    `json:"zone"`
+	Record        string    `json:"record"`
+	Type          string    `json:"type"`
+	Address       string    `json:"address"`
+	ID            string    `json:"id,omitempty"`    // The provider's ID for the record; see dns.Mutation.
+	Nodes         []string  `json:"nodes,omitempty"` // The nodes that contributed the address.
+	Trigger       string    `json:"trigger,omitempty"`
+	CorrelationID string    `json:"correlation_id,omitempty"`
+}
+
+// Log writes an Entry for each dns.Mutation that it's given.  Deleted addresses usually belonged
+// to nodes that are gone, so a deletion lists the nodes that the address was created for, if this
+// instance created it.
+type Log struct {
+	Logger *zap.Logger
+
+	now   func() time.Time
+	mu    sync.Mutex
+	w     io.Writer // If nil, entries are logged instead.
+	nodes map[[2]string][]string
+}
+
+// Open returns a Log that appends to the file at path, creating it if necessary; or, if path is
+// "log", a Log that writes entries to its Logger instead.
+func Open(path string) (*Log, error) {
+	if path == "log" {
+		return newLog(nil), nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	return newLog(f), nil
+}
+
+// newLog returns a Log that writes to w, or logs entries if w is nil.
+func newLog(w io.Writer) *Log {
+	return &Log{Logger: zap.L().Named("audit"), now: time.Now, w: w, nodes: make(map[[2]string][]string)}
+}
+
+// Record writes an entry for a mutation.  Its signature matches dns.ProviderOptions.OnMutation.
+func (l *Log) Record(ctx context.Context, m dns.Mutation) {
+	e := &Entry{
+		Time:          l.now().UTC(),
+		Action:        m.Action,
+		Provider:      m.Provider,
+		Zone:          m.Zone,
+		Record:        m.Record,
+		Type:          m.Type,
+		Address:       m.Address,
+		ID:            m.ID,
+		CorrelationID: correlation.ID(ctx),
+	}
+	cause, ok := k8s.CauseFromContext(ctx)
+	if ok {
+		e.Trigger = cause.Trigger
+		e.Nodes = cause.NodesWith(m.Address)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := [2]string{m.Record, m.Address}
+	switch m.Action {
+	case "create":
+		l.nodes[key] = e.Nodes
+	case "delete":
+		if len(e.Nodes) == 0 {
+			e.Nodes = l.nodes[key]
+		}
+		delete(l.nodes, key)
+	}
+	if err := l.write(e); err != nil {
+		auditEntries.WithLabelValues("error").Inc()
+		l.Logger.Error("problem writing audit log entry", zap.Any("entry", e), zap.Error(err))
+		return
+	}
+	auditEntries.WithLabelValues("ok").Inc()
+}
+
+// write writes an entry as a single line, and syncs the file so that it survives a crash.  The
+// caller must hold the lock.
+func (l *Log) write(e *Entry) error {
+	if l.w == nil {
+		l.Logger.Info("dns record "+e.Action+"d", zap.Time("time", e.Time), zap.String("provider", e.Provider), zap.String("zone", e.Zone), zap.String("record", e.Record), zap.String("type", e.Type), zap.String("address", e.Address), zap.String("id", e.ID), zap.Strings("nodes", e.Nodes), zap.String("trigger", e.Trigger), zap.String("correlation_id", e.CorrelationID))
+		return nil
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal entry: %w", err)
+	}
+	if _, err := l.w.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write entry: %w", err)
+	}
+	if f, ok := l.w.(*os.File); ok {
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("sync: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close closes the file that the log is written to, if any.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c, ok := l.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
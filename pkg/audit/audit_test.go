@@ -0,0 +1,114 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/jrockway/nodedns/pkg/dns"
+	"github.com/jrockway/nodedns/pkg/k8s"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func node(name, external string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: external}},
+		},
+	}
+}
+
+func TestLog(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	buf := new(bytes.Buffer)
+	log := newLog(buf)
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	log.now = func() time.Time { return now }
+
+	// The sink stands in for a DNS provider, creating and deleting one record per address.
+	published := make(map[string]bool)
+	ns := k8s.NewNodeStore("test")
+	ns.Logger = l
+	ns.Subscribe(k8s.SinkFunc("dns", func(req k8s.UpdateRequest) error {
+		if req.Record.Kind != k8s.External {
+			return nil
+		}
+		desired := make(map[string]bool)
+		for _, ip := range req.Record.IPs {
+			desired[ip.String()] = true
+			if !published[ip.String()] {
+				log.Record(req.Ctx, dns.Mutation{Action: "create", Provider: "test", Zone: "example.com", Record: "nodes.example.com", Type: "A", Address: ip.String(), ID: "id-" + ip.String()})
+			}
+		}
+		for addr := range published {
+			if !desired[addr] {
+				log.Record(req.Ctx, dns.Mutation{Action: "delete", Provider: "test", Zone: "example.com", Record: "nodes.example.com", Type: "A", Address: addr, ID: "id-" + addr})
+			}
+		}
+		published = desired
+		return nil
+	}))
+	ns.Add(node("host-1", "42.0.0.1"))
+	ns.Add(node("host-2", "42.0.0.2"))
+	ns.Delete(node("host-1", "42.0.0.1"))
+
+	var got []Entry
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var e Entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("unmarshal %q: %v", line, err)
+		}
+		if e.CorrelationID == "" {
+			t.Errorf("entry without a correlation id: %s", line)
+		}
+		got = append(got, e)
+	}
+	entry := func(action, addr, node, trigger string) Entry {
+		return Entry{Time: now, Action: action, Provider: "test", Zone: "example.com", Record: "nodes.example.com", Type: "A", Address: addr, ID: "id-" + addr, Nodes: []string{node}, Trigger: trigger}
+	}
+	want := []Entry{
+		entry("create", "42.0.0.1", "host-1", "add"),
+		entry("create", "42.0.0.2", "host-2", "add"),
+		// The deleted node is gone, but the log remembers who the address was created for.
+		entry("delete", "42.0.0.1", "host-1", "delete"),
+	}
+	if diff := cmp.Diff(got, want, cmpopts.IgnoreFields(Entry{}, "CorrelationID")); diff != "" {
+		t.Errorf("entries:\n%s", diff)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	for i := 0; i < 2; i++ {
+		log, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		log.Record(context.Background(), dns.Mutation{Action: "create", Record: "nodes.example.com", Address: net.IPv4(42, 0, 0, 1).String()})
+		if err := log.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reopening the log appends to it.
+	if got, want := strings.Count(string(content), "\n"), 2; got != want {
+		t.Errorf("lines:\n  got: %v\n want: %v", got, want)
+	}
+}
@@ -0,0 +1,164 @@
+// Package aws keeps AWS resources in sync with the set of nodes, and archives record changes to S3.
+package aws
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/jrockway/nodedns/pkg/tracing"
+	"go.uber.org/zap"
+)
+
+// ruleDescription marks the ingress rules that nodedns manages.  Rules without this description
+// were added by someone else, and are never removed.
+const ruleDescription = "managed by nodedns"
+
+// SecurityGroupSync keeps a security group's ingress rules in sync with a set of addresses, so that
+// workloads in AWS can accept traffic from exactly the cluster's nodes.
+type SecurityGroupSync struct {
+	ec2      ec2iface.EC2API
+	GroupID  string // The ID of the security group, like sg-0123456789abcdef.
+	Protocol string // The IP protocol to allow, like "tcp", or "-1" for all protocols.
+	FromPort int64  // The start of the port range to allow; ignored if Protocol is "-1".
+	ToPort   int64  // The end of the port range to allow; ignored if Protocol is "-1".
+}
+
+// NewSecurityGroupSync returns a SecurityGroupSync that uses the default AWS credential chain
+// (environment, shared config, instance or pod role) in the provided region.
+func NewSecurityGroupSync(region, groupID, protocol string, fromPort, toPort int64) (*SecurityGroupSync, error) {
+	sess, err := session.NewSession(aws.NewConfig().WithRegion(region))
+	if err != nil {
+		return nil, fmt.Errorf("new aws session: %w", err)
+	}
+	return &SecurityGroupSync{
+		ec2:      ec2.New(sess),
+		GroupID:  groupID,
+		Protocol: protocol,
+		FromPort: fromPort,
+		ToPort:   toPort,
+	}, nil
+}
+
+// matches returns true if the permission is for the protocol and ports that we manage.
+func (s *SecurityGroupSync) matches(p *ec2.IpPermission) bool {
+	if aws.StringValue(p.IpProtocol) != s.Protocol {
+		return false
+	}
+	if s.Protocol == "-1" {
+		return true
+	}
+	return aws.Int64Value(p.FromPort) == s.FromPort && aws.Int64Value(p.ToPort) == s.ToPort
+}
+
+func (s *SecurityGroupSync) permission(v4, v6 []string) *ec2.IpPermission {
+	p := &ec2.IpPermission{IpProtocol: aws.String(s.Protocol)}
+	if s.Protocol != "-1" {
+		p.FromPort = aws.Int64(s.FromPort)
+		p.ToPort = aws.Int64(s.ToPort)
+	}
+	for _, cidr := range v4 {
+		p.IpRanges = append(p.IpRanges, &ec2.IpRange{CidrIp: aws.String(cidr), Description: aws.String(ruleDescription)})
+	}
+	for _, cidr := range v6 {
+		p.Ipv6Ranges = append(p.Ipv6Ranges, &ec2.Ipv6Range{CidrIpv6: aws.String(cidr), Description: aws.String(ruleDescription)})
+	}
+	return p
+}
+
+func toCIDR(ip net.IP) (string, bool) {
+	if ip.To4() != nil {
+		return ip.String() + "/32", false
+	}
+	return ip.String() + "/128", true
+}
+
+// Sync adds and removes ingress rules so that the managed rules allow exactly the provided
+// addresses.
+func (s *SecurityGroupSync) Sync(ctx context.Context, ips []net.IP) error {
+	ctx, span := tracing.Start(ctx, "aws_security_group_sync")
+	defer span.End()
+
+	res, err := s.ec2.DescribeSecurityGroupsWithContext(ctx, &ec2.DescribeSecurityGroupsInput{
+		GroupIds: []*string{aws.String(s.GroupID)},
+	})
+	if err != nil {
+		return fmt.Errorf("describe security group %s: %w", s.GroupID, err)
+	}
+	if len(res.SecurityGroups) != 1 {
+		return fmt.Errorf("describe security group %s: expected 1 group, got %d", s.GroupID, len(res.SecurityGroups))
+	}
+
+	existing := make(map[string]bool) // CIDR -> is ipv6
+	for _, p := range res.SecurityGroups[0].IpPermissions {
+		if !s.matches(p) {
+			continue
+		}
+		for _, r := range p.IpRanges {
+			if aws.StringValue(r.Description) == ruleDescription {
+				existing[aws.StringValue(r.CidrIp)] = false
+			}
+		}
+		for _, r := range p.Ipv6Ranges {
+			if aws.StringValue(r.Description) == ruleDescription {
+				existing[aws.StringValue(r.CidrIpv6)] = true
+			}
+		}
+	}
+
+	desired := make(map[string]bool)
+	for _, ip := range ips {
+		cidr, v6 := toCIDR(ip)
+		desired[cidr] = v6
+	}
+
+	var addV4, addV6, removeV4, removeV6 []string
+	for cidr, v6 := range desired {
+		if _, ok := existing[cidr]; ok {
+			continue
+		}
+		if v6 {
+			addV6 = append(addV6, cidr)
+		} else {
+			addV4 = append(addV4, cidr)
+		}
+	}
+	for cidr, v6 := range existing {
+		if _, ok := desired[cidr]; ok {
+			continue
+		}
+		if v6 {
+			removeV6 = append(removeV6, cidr)
+		} else {
+			removeV4 = append(removeV4, cidr)
+		}
+	}
+	for _, l := range [][]string{addV4, addV6, removeV4, removeV6} {
+		sort.Strings(l)
+	}
+
+	if len(addV4)+len(addV6) > 0 {
+		zap.L().Named("aws").Info("authorizing ingress", zap.String("group", s.GroupID), zap.Strings("ipv4", addV4), zap.Strings("ipv6", addV6))
+		if _, err := s.ec2.AuthorizeSecurityGroupIngressWithContext(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
+			GroupId:       aws.String(s.GroupID),
+			IpPermissions: []*ec2.IpPermission{s.permission(addV4, addV6)},
+		}); err != nil {
+			return fmt.Errorf("authorize ingress: %w", err)
+		}
+	}
+	if len(removeV4)+len(removeV6) > 0 {
+		zap.L().Named("aws").Info("revoking ingress", zap.String("group", s.GroupID), zap.Strings("ipv4", removeV4), zap.Strings("ipv6", removeV6))
+		if _, err := s.ec2.RevokeSecurityGroupIngressWithContext(ctx, &ec2.RevokeSecurityGroupIngressInput{
+			GroupId:       aws.String(s.GroupID),
+			IpPermissions: []*ec2.IpPermission{s.permission(removeV4, removeV6)},
+		}); err != nil {
+			return fmt.Errorf("revoke ingress: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package aws
+
+import (
+	"context"
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+)
+
+// fakeEC2 is an in-memory security group that supports the calls that SecurityGroupSync makes.
+type fakeEC2 struct {
+	ec2iface.EC2API
+	group *ec2.SecurityGroup
+	calls int
+}
+
+func (f *fakeEC2) DescribeSecurityGroupsWithContext(ctx aws.Context, in *ec2.DescribeSecurityGroupsInput, _ ...request.Option) (*ec2.DescribeSecurityGroupsOutput, error) {
+	return &ec2.DescribeSecurityGroupsOutput{SecurityGroups: []*ec2.SecurityGroup{f.group}}, nil
+}
+
+func (f *fakeEC2) AuthorizeSecurityGroupIngressWithContext(ctx aws.Context, in *ec2.AuthorizeSecurityGroupIngressInput, _ ...request.Option) (*ec2.AuthorizeSecurityGroupIngressOutput, error) {
+	f.calls++
+	f.group.IpPermissions = append(f.group.IpPermissions, in.IpPermissions...)
+	return &ec2.AuthorizeSecurityGroupIngressOutput{}, nil
+}
+
+func (f *fakeEC2) RevokeSecurityGroupIngressWithContext(ctx aws.Context, in *ec2.RevokeSecurityGroupIngressInput, _ ...request.Option) (*ec2.RevokeSecurityGroupIngressOutput, error) {
+	f.calls++
+	revoke := make(map[string]struct{})
+	for _, p := range in.IpPermissions {
+		for _, r := range p.IpRanges {
+			revoke[aws.StringValue(r.CidrIp)] = struct{}{}
+		}
+		for _, r := range p.Ipv6Ranges {
+			revoke[aws.StringValue(r.CidrIpv6)] = struct{}{}
+		}
+	}
+	for _, p := range f.group.IpPermissions {
+		var v4 []*ec2.IpRange
+		for _, r := range p.IpRanges {
+			if _, ok := revoke[aws.StringValue(r.CidrIp)]; !ok {
+				v4 = append(v4, r)
+			}
+		}
+		var v6 []*ec2.Ipv6Range
+		for _, r := range p.Ipv6Ranges {
+			if _, ok := revoke[aws.StringValue(r.CidrIpv6)]; !ok {
+				v6 = append(v6, r)
+			}
+		}
+		p.IpRanges, p.Ipv6Ranges = v4, v6
+	}
+	return &ec2.RevokeSecurityGroupIngressOutput{}, nil
+}
+
+func (f *fakeEC2) cidrs() []string {
+	var result []string
+	for _, p := range f.group.IpPermissions {
+		for _, r := range p.IpRanges {
+			result = append(result, aws.StringValue(r.CidrIp)+" "+aws.StringValue(r.Description))
+		}
+		for _, r := range p.Ipv6Ranges {
+			result = append(result, aws.StringValue(r.CidrIpv6)+" "+aws.StringValue(r.Description))
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestSync(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	f := &fakeEC2{
+		group: &ec2.SecurityGroup{
+			GroupId: aws.String("sg-1"),
+			IpPermissions: []*ec2.IpPermission{{
+				IpProtocol: aws.String("-1"),
+				IpRanges: []*ec2.IpRange{
+					{CidrIp: aws.String("192.0.2.0/24"), Description: aws.String("office")},
+					{CidrIp: aws.String("42.0.0.1/32"), Description: aws.String(ruleDescription)},
+					{CidrIp: aws.String("42.0.0.9/32"), Description: aws.String(ruleDescription)},
+				},
+			}},
+		},
+	}
+	s := &SecurityGroupSync{ec2: f, GroupID: "sg-1", Protocol: "-1"}
+	ctx := context.Background()
+	ips := []net.IP{net.IPv4(42, 0, 0, 1), net.IPv4(42, 0, 0, 2), net.ParseIP("2001:db8::1")}
+	if err := s.Sync(ctx, ips); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	want := []string{
+		"192.0.2.0/24 office",
+		"2001:db8::1/128 managed by nodedns",
+		"42.0.0.1/32 managed by nodedns",
+		"42.0.0.2/32 managed by nodedns",
+	}
+	if diff := cmp.Diff(f.cidrs(), want); diff != "" {
+		t.Errorf("rules:\n%s", diff)
+	}
+	if got, want := f.calls, 2; got != want {
+		t.Errorf("calls: got %d, want %d", got, want)
+	}
+
+	f.calls = 0
+	if err := s.Sync(ctx, ips); err != nil {
+		t.Fatalf("sync again: %v", err)
+	}
+	if got, want := f.calls, 0; got != want {
+		t.Errorf("calls after no-op sync: got %d, want %d", got, want)
+	}
+}
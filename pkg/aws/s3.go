@@ -0,0 +1,47 @@
+package aws
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/jrockway/nodedns/pkg/changes"
+)
+
+// S3Uploader uploads objects to a bucket in S3-compatible object storage.  It implements
+// changes.Uploader.
+type S3Uploader struct {
+	s3     *s3.S3
+	bucket string
+}
+
+// NewS3Uploader returns an S3Uploader for the configured bucket, using the default AWS credential
+// chain (environment, shared config, instance or pod role).  Other S3-compatible storage takes
+// credentials the same way, like HMAC keys for Google Cloud Storage.
+func NewS3Uploader(cfg changes.ArchiveConfig) (*S3Uploader, error) {
+	c := aws.NewConfig().WithRegion(cfg.Region).WithS3ForcePathStyle(cfg.PathStyle)
+	if cfg.Endpoint != "" {
+		c = c.WithEndpoint(cfg.Endpoint)
+	}
+	sess, err := session.NewSession(c)
+	if err != nil {
+		return nil, fmt.Errorf("new aws session: %w", err)
+	}
+	return &S3Uploader{s3: s3.New(sess), bucket: cfg.Bucket}, nil
+}
+
+// Upload implements changes.Uploader.
+func (u *S3Uploader) Upload(ctx context.Context, key string, body []byte) error {
+	if _, err := u.s3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(u.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/x-ndjson"),
+	}); err != nil {
+		return fmt.Errorf("put s3://%s/%s: %w", u.bucket, key, err)
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+// Package budget divides a DigitalOcean API token's rate limit among the nodedns instances that
+// share it, and staggers their resyncs, so that many clusters can share one token without
+// starving each other.
+//
+// Instances may be in different clusters, so they coordinate through the DNS provider itself: each
+// instance maintains a TXT record (a "marker") in the zone containing the time of its last
+// heartbeat.  Instances with a recent heartbeat are live, and each gets an equal share of the
+// budget.
+package budget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+var (
+	liveInstances = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "budget_live_instances",
+		Help: "The number of nodedns instances sharing the api budget, including this one.",
+	})
+	requestsPerHour = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "budget_requests_per_hour",
+		Help: "This instance's share of the api budget.",
+	})
+	throttledRequests = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "budget_throttled_requests",
+		Help: "The number of api requests that were delayed to stay within this instance's share of the budget.",
+	})
+	heartbeatErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "budget_heartbeat_errors",
+		Help: "The number of failed attempts to update this instance's marker record.",
+	})
+)
+
+// MarkerPrefix is the prefix of the names of marker records.  An instance's marker is named
+// MarkerPrefix + its ID.
+const MarkerPrefix = "_nodedns-budget."
+
+// Config configures budget coordination.
+type Config struct {
+	Enabled   bool          `long:"budget_coordination" env:"BUDGET_COORDINATION" description:"share the api budget with other nodedns instances that use the same token, coordinating through marker records in the zone"`
+	ID        string        `long:"budget_instance_id" env:"BUDGET_INSTANCE_ID" description:"the name of this instance, unique among the instances sharing the token; defaults to the hostname"`
+	Zone      string        `long:"budget_zone" env:"BUDGET_ZONE" description:"the zone that marker records are kept in; defaults to --zone"`
+	PerHour   int           `long:"budget_requests_per_hour" env:"BUDGET_REQUESTS_PER_HOUR" description:"the api budget shared by all instances; DigitalOcean allows 5000 requests per hour per token" default:"5000"`
+	Heartbeat time.Duration `long:"budget_heartbeat" env:"BUDGET_HEARTBEAT" description:"how often to update this instance's marker record; instances are considered gone after 3 missed heartbeats" default:"5m"`
+}
+
+// Validate returns an error if the configuration is invalid.
+func (c *Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.PerHour <= 0 {
+		return fmt.Errorf("budget requests per hour %d: must be positive", c.PerHour)
+	}
+	if c.Heartbeat <= 0 {
+		return fmt.Errorf("budget heartbeat %v: must be positive", c.Heartbeat)
+	}
+	if c.Zone == "" {
+		return errors.New("budget zone: must be set")
+	}
+	if c.ID == "" || strings.ContainsAny(c.ID, ". ") {
+		return fmt.Errorf("budget instance id %q: must be non-empty and not contain dots or spaces", c.ID)
+	}
+	return nil
+}
+
+// Coordinator maintains this instance's marker, tracks the other live instances, and limits this
+// instance's api requests to its share of the budget.
+type Coordinator struct {
+	Client   *godo.Client // Must be set before Run is called.
+	Config   Config
+	Logger   *zap.Logger
+	limiter  *rate.Limiter
+	now      func() time.Time
+	mu       sync.Mutex
+	index    int // Our position among the live instances, sorted by ID.
+	count    int // The number of live instances.
+	markerID int // The ID of our marker record, or 0 if it hasn't been created yet.
+}
+
+// New returns a Coordinator that assumes it's the only instance until the first heartbeat.
+func New(cfg Config) *Coordinator {
+	c := &Coordinator{
+		Config: cfg,
+		Logger: zap.L().Named("budget"),
+		now:    time.Now,
+		count:  1,
+	}
+	c.limiter = rate.NewLimiter(c.limit(1))
+	requestsPerHour.Set(float64(cfg.PerHour))
+	return c
+}
+
+// limit returns the rate limit and burst for an instance's share of the budget.
+func (c *Coordinator) limit(count int) (rate.Limit, int) {
+	perHour := float64(c.Config.PerHour) / float64(count)
+	burst := int(perHour / 60) // A minute's worth.
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.Limit(perHour / 3600), burst
+}
+
+// Share returns this instance's position among the live instances and the number of live
+// instances.
+func (c *Coordinator) Share() (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.index, c.count
+}
+
+// markerName returns the name of an instance's marker record.
+func markerName(id string) string {
+	return MarkerPrefix + id
+}
+
+// markers returns every marker record in the zone.
+func (c *Coordinator) markers(ctx context.Context) ([]godo.DomainRecord, error) {
+	var result []godo.DomainRecord
+	for page := 1; page <= 100; page++ {
+		recs, res, err := c.Client.Domains.Records(ctx, c.Config.Zone, &godo.ListOptions{Page: page, PerPage: 200})
+		if err != nil {
+			return nil, fmt.Errorf("get page %d of records for domain %s: %w", page, c.Config.Zone, err)
+		}
+		for _, rec := range recs {
+			if rec.Type == "TXT" && strings.HasPrefix(rec.Name, MarkerPrefix) {
+				result = append(result, rec)
+			}
+		}
+		if res.Links == nil || res.Links.IsLastPage() {
+			return result, nil
+		}
+	}
+	return result, errors.New("more than 100 pages!")
+}
+
+// Beat updates this instance's marker, and recomputes its share of the budget from the other
+// instances' markers.
+func (c *Coordinator) Beat(ctx context.Context) error {
+	now := c.now()
+	markers, err := c.markers(ctx)
+	if err != nil {
+		return fmt.Errorf("list markers: %w", err)
+	}
+
+	ours := markerName(c.Config.ID)
+	live := []string{c.Config.ID}
+	for _, m := range markers {
+		if m.Name == ours {
+			c.markerID = m.ID
+			continue
+		}
+		beat, err := time.Parse(time.RFC3339, m.Data)
+		if err != nil {
+			c.Logger.Warn("ignoring marker with unparseable heartbeat", zap.String("marker", m.Name), zap.String("data", m.Data))
+			continue
+		}
+		switch age := now.Sub(beat); {
+		case age < 3*c.Config.Heartbeat:
+			live = append(live, strings.TrimPrefix(m.Name, MarkerPrefix))
+		case age > 10*c.Config.Heartbeat:
+			// The instance is long gone; clean up after it.
+			if _, err := c.Client.Domains.DeleteRecord(ctx, c.Config.Zone, m.ID); err != nil {
+				c.Logger.Warn("problem deleting stale marker", zap.String("marker", m.Name), zap.Error(err))
+			}
+		}
+	}
+
+	req := &godo.DomainRecordEditRequest{
+		Type: "TXT",
+		Name: ours,
+		Data: now.UTC().Format(time.RFC3339),
+		TTL:  int(c.Config.Heartbeat.Seconds()),
+	}
+	if c.markerID == 0 {
+		rec, _, err := c.Client.Domains.CreateRecord(ctx, c.Config.Zone, req)
+		if err != nil {
+			return fmt.Errorf("create marker: %w", err)
+		}
+		c.markerID = rec.ID
+	} else if _, _, err := c.Client.Domains.EditRecord(ctx, c.Config.Zone, c.markerID, req); err != nil {
+		c.markerID = 0 // It may have been deleted; recreate it next time.
+		return fmt.Errorf("update marker: %w", err)
+	}
+
+	sort.Strings(live)
+	c.mu.Lock()
+	changed := c.count != len(live)
+	c.count = len(live)
+	c.index = sort.SearchStrings(live, c.Config.ID)
+	c.mu.Unlock()
+	limit, burst := c.limit(len(live))
+	c.limiter.SetLimit(limit)
+	c.limiter.SetBurst(burst)
+	liveInstances.Set(float64(len(live)))
+	requestsPerHour.Set(float64(c.Config.PerHour) / float64(len(live)))
+	if changed {
+		c.Logger.Info("api budget share changed", zap.Strings("instances", live), zap.Float64("requests_per_hour", float64(limit)*3600))
+	}
+	return nil
+}
+
+// Run sends a heartbeat every Config.Heartbeat until the context is done.
+func (c *Coordinator) Run(ctx context.Context) {
+	t := time.NewTicker(c.Config.Heartbeat)
+	defer t.Stop()
+	for {
+		tctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		if err := c.Beat(tctx); err != nil {
+			heartbeatErrors.Inc()
+			c.Logger.Warn("problem sending heartbeat", zap.Error(err))
+		}
+		cancel()
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Offset returns how long after the start of each interval (aligned to the wall clock) this
+// instance should resync, so that the live instances' resyncs are evenly spaced.
+func (c *Coordinator) Offset(interval time.Duration) time.Duration {
+	index, count := c.Share()
+	return interval * time.Duration(index) / time.Duration(count)
+}
+
+// RunResyncs calls resync every interval, at this instance's offset within the interval, until the
+// context is done.
+func (c *Coordinator) RunResyncs(ctx context.Context, interval time.Duration, resync func() error) {
+	for {
+		now := c.now()
+		next := now.Truncate(interval).Add(c.Offset(interval))
+		if !next.After(now) {
+			next = next.Add(interval)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(next.Sub(now)):
+		}
+		if err := resync(); err != nil {
+			c.Logger.Warn("problem resyncing", zap.Error(err))
+		}
+	}
+}
+
+// Transport is an http.RoundTripper that delays requests to stay within the instance's share of the
+// budget.
+type Transport struct {
+	Coordinator *Coordinator
+	Underlying  http.RoundTripper
+}
+
+// Wrap returns rt wrapped with rate limiting.
+func (c *Coordinator) Wrap(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &Transport{Coordinator: c, Underlying: rt}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.Coordinator.limiter.Allow() {
+		throttledRequests.Inc()
+		if err := t.Coordinator.limiter.Wait(req.Context()); err != nil {
+			return nil, fmt.Errorf("wait for api budget: %w", err)
+		}
+	}
+	return t.Underlying.RoundTrip(req)
+}
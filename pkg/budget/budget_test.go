@@ -0,0 +1,101 @@
+package budget
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/jrockway/nodedns/pkg/fakedo"
+	"go.uber.org/zap/zaptest"
+	"golang.org/x/time/rate"
+)
+
+func TestBeat(t *testing.T) {
+	s := fakedo.New("example.com")
+	defer s.Close()
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	s.AddRecord("example.com", godo.DomainRecord{Type: "TXT", Name: MarkerPrefix + "gone", Data: now.Add(-time.Hour).Format(time.RFC3339)})
+	s.AddRecord("example.com", godo.DomainRecord{Type: "TXT", Name: MarkerPrefix + "late", Data: now.Add(-20 * time.Minute).Format(time.RFC3339)})
+	s.AddRecord("example.com", godo.DomainRecord{Type: "TXT", Name: MarkerPrefix + "garbage", Data: "hello"})
+
+	newCoordinator := func(id string) *Coordinator {
+		c := New(Config{Enabled: true, ID: id, Zone: "example.com", PerHour: 3600, Heartbeat: 5 * time.Minute})
+		c.Client = s.Client()
+		c.Logger = zaptest.NewLogger(t)
+		c.now = func() time.Time { return now }
+		return c
+	}
+	a, b := newCoordinator("a"), newCoordinator("b")
+	ctx := context.Background()
+	for _, c := range []*Coordinator{a, b, a} {
+		if err := c.Beat(ctx); err != nil {
+			t.Fatalf("beat %s: %v", c.Config.ID, err)
+		}
+	}
+
+	if index, count := a.Share(); index != 0 || count != 2 {
+		t.Errorf("a's share:\n  got: %d of %d\n want: 0 of 2", index, count)
+	}
+	if got, want := a.limiter.Limit(), rate.Limit(0.5); got != want {
+		t.Errorf("a's limit:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := a.Offset(10*time.Minute), time.Duration(0); got != want {
+		t.Errorf("a's offset:\n  got: %v\n want: %v", got, want)
+	}
+	// b hasn't seen a's second heartbeat, but it saw the first.
+	if index, count := b.Share(); index != 1 || count != 2 {
+		t.Errorf("b's share:\n  got: %d of %d\n want: 1 of 2", index, count)
+	}
+	if got, want := b.Offset(10*time.Minute), 5*time.Minute; got != want {
+		t.Errorf("b's offset:\n  got: %v\n want: %v", got, want)
+	}
+
+	got := make(map[string]bool)
+	for _, r := range s.Records("example.com") {
+		got[r.Name] = true
+	}
+	for name, want := range map[string]bool{
+		MarkerPrefix + "a":       true,
+		MarkerPrefix + "b":       true,
+		MarkerPrefix + "late":    true,
+		MarkerPrefix + "garbage": true,
+		MarkerPrefix + "gone":    false,
+	} {
+		if got[name] != want {
+			t.Errorf("record %s exists:\n  got: %v\n want: %v", name, got[name], want)
+		}
+	}
+}
+
+func TestTransport(t *testing.T) {
+	var requests int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+	}))
+	defer s.Close()
+
+	c := New(Config{Enabled: true, ID: "a", PerHour: 60, Heartbeat: time.Minute})
+	client := &http.Client{Transport: c.Wrap(s.Client().Transport)}
+	// The burst is one minute's worth of requests, or one request.
+	res, err := client.Get(s.URL)
+	if err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+	res.Body.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", s.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := client.Do(req); err == nil {
+		res.Body.Close()
+		t.Error("second request: expected error")
+	}
+	if got, want := requests, 1; got != want {
+		t.Errorf("requests:\n  got: %v\n want: %v", got, want)
+	}
+}
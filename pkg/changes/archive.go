@@ -0,0 +1,151 @@
+package changes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var (
+	archiveUploads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "archive_uploads",
+		Help: "The number of segments of the record change log uploaded to object storage, by result (\"ok\", \"error\", or \"dropped\" after failing for too long).",
+	}, []string{"result"})
+)
+
+// ArchiveConfig configures archiving record changes to S3-compatible object storage.
+type ArchiveConfig struct {
+	Bucket    string        `long:"archive_bucket" env:"ARCHIVE_BUCKET" description:"upload a log of every change to a record to this bucket in S3-compatible object storage"`
+	Prefix    string        `long:"archive_prefix" env:"ARCHIVE_PREFIX" description:"the prefix of the uploaded objects' keys; give each instance its own" default:"nodedns/"`
+	Endpoint  string        `long:"archive_endpoint" env:"ARCHIVE_ENDPOINT" description:"the object storage endpoint, for storage other than AWS S3, like https://storage.googleapis.com or https://nyc3.digitaloceanspaces.com"`
+	Region    string        `long:"archive_region" env:"ARCHIVE_REGION" description:"the region of the bucket" default:"us-east-1"`
+	PathStyle bool          `long:"archive_path_style" env:"ARCHIVE_PATH_STYLE" description:"address the bucket in the url path rather than the hostname, as minio requires"`
+	Interval  time.Duration `long:"archive_interval" env:"ARCHIVE_INTERVAL" description:"how often to rotate the log and upload it" default:"1h"`
+	MaxBytes  int           `long:"archive_max_bytes" env:"ARCHIVE_MAX_BYTES" description:"rotate the log early when it reaches this size" default:"1048576"`
+}
+
+// Uploader uploads objects to object storage, like aws.S3Uploader.
+type Uploader interface {
+	Upload(ctx context.Context, key string, body []byte) error
+}
+
+// Archiver writes every change that a Server broadcasts to a log, one JSON-encoded RecordChange
+// per line, and uploads it to object storage each time the log is rotated.  A segment that fails
+// to upload is retried at the next rotation.
+type Archiver struct {
+	Config   ArchiveConfig
+	Uploader Uploader
+	Logger   *zap.Logger
+
+	now   func() time.Time
+	buf   bytes.Buffer
+	start time.Time // When the first change in buf was archived.
+}
+
+// NewArchiver returns an Archiver that uploads with u.
+func NewArchiver(cfg ArchiveConfig, u Uploader) *Archiver {
+	return &Archiver{
+		Config:   cfg,
+		Uploader: u,
+		Logger:   zap.L().Named("archive"),
+		now:      time.Now,
+	}
+}
+
+// Run archives every change that the server broadcasts until the context is done, and then
+// uploads what remains.  If the archiver falls too far behind, it resubscribes, and archives the
+// current contents of every record again.
+func (a *Archiver) Run(ctx context.Context, s *Server) error {
+	if a.Config.Interval <= 0 {
+		return errors.New("archive interval must be positive")
+	}
+	t := time.NewTicker(a.Config.Interval)
+	defer t.Stop()
+	for {
+		w, unsubscribe := s.subscribe(nil)
+		err := a.drain(ctx, w, t.C)
+		unsubscribe()
+		if err != nil {
+			// The context is done, but the last segment should still be saved.
+			fctx, c := context.WithTimeout(context.Background(), 30*time.Second)
+			a.rotate(fctx)
+			c()
+			return err
+		}
+		a.Logger.Warn("fell too far behind; resubscribing")
+	}
+}
+
+// drain archives changes from the watcher until it's closed or the context is done, rotating the
+// log on each tick.
+func (a *Archiver) drain(ctx context.Context, w *watcher, tick <-chan time.Time) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick:
+			a.rotate(ctx)
+		case change, ok := <-w.ch:
+			if !ok {
+				return nil
+			}
+			if err := a.append(change); err != nil {
+				a.Logger.Error("problem archiving change; skipping it", zap.String("store", change.GetStore()), zap.String("kind", change.GetKind()), zap.Error(err))
+				continue
+			}
+			if a.Config.MaxBytes > 0 && a.buf.Len() >= a.Config.MaxBytes {
+				a.rotate(ctx)
+			}
+		}
+	}
+}
+
+// append adds a change to the log.
+func (a *Archiver) append(change *RecordChange) error {
+	line, err := protojson.Marshal(change)
+	if err != nil {
+		return fmt.Errorf("marshal change: %w", err)
+	}
+	if a.buf.Len() == 0 {
+		a.start = a.now()
+	}
+	a.buf.Write(line)     // nolint:errcheck
+	a.buf.WriteByte('\n') // nolint:errcheck
+	return nil
+}
+
+// key returns the object key of the current segment, which is named for the time that its first
+// change was archived.
+func (a *Archiver) key() string {
+	return a.Config.Prefix + a.start.UTC().Format("2006/01/02/150405.000000000") + ".jsonl"
+}
+
+// rotate uploads the current segment, if it isn't empty, and starts a new one.  If the upload
+// fails, the segment is kept and uploaded with the next one, unless it has grown too large.
+func (a *Archiver) rotate(ctx context.Context) {
+	if a.buf.Len() == 0 {
+		return
+	}
+	key := a.key()
+	if err := a.Uploader.Upload(ctx, key, a.buf.Bytes()); err != nil {
+		if a.Config.MaxBytes > 0 && a.buf.Len() >= 10*a.Config.MaxBytes {
+			archiveUploads.WithLabelValues("dropped").Inc()
+			a.Logger.Error("problem uploading change log; dropping it", zap.String("key", key), zap.Int("bytes", a.buf.Len()), zap.Error(err))
+			a.buf.Reset()
+			return
+		}
+		archiveUploads.WithLabelValues("error").Inc()
+		a.Logger.Error("problem uploading change log; will retry at the next rotation", zap.String("key", key), zap.Error(err))
+		return
+	}
+	archiveUploads.WithLabelValues("ok").Inc()
+	a.Logger.Debug("uploaded change log", zap.String("key", key), zap.Int("bytes", a.buf.Len()))
+	a.buf.Reset()
+}
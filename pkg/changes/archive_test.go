@@ -0,0 +1,107 @@
+package changes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/k8s"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type fakeUploader struct {
+	sync.Mutex
+	failures int
+	objects  map[string][]*RecordChange
+	uploaded chan struct{}
+}
+
+func (u *fakeUploader) Upload(ctx context.Context, key string, body []byte) error {
+	u.Lock()
+	defer u.Unlock()
+	if u.failures > 0 {
+		u.failures--
+		return errors.New("injected error")
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(body), "\n"), "\n") {
+		change := new(RecordChange)
+		if err := protojson.Unmarshal([]byte(line), change); err != nil {
+			return fmt.Errorf("unmarshal %q: %w", line, err)
+		}
+		u.objects[key] = append(u.objects[key], change)
+	}
+	u.uploaded <- struct{}{}
+	return nil
+}
+
+func TestArchiver(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	u := &fakeUploader{failures: 1, objects: make(map[string][]*RecordChange), uploaded: make(chan struct{}, 10)}
+	s := NewServer()
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	// Every change is bigger than MaxBytes, so the log is rotated after each one.
+	a := NewArchiver(ArchiveConfig{Prefix: "test/", Interval: time.Hour, MaxBytes: 100}, u)
+	a.Logger = l
+	a.now = func() time.Time { return now }
+	done := make(chan error)
+	go func() { done <- a.Run(ctx, s) }()
+
+	for {
+		s.mu.Lock()
+		n := len(s.watchers)
+		s.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	sink := s.Sink("main", map[k8s.Kind][]string{k8s.Internal: {"internal.example.com"}})
+	for _, ip := range []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)} {
+		if err := sink.Update(k8s.UpdateRequest{
+			Ctx:     ctx,
+			Record:  k8s.Record{Kind: k8s.Internal, IPs: []net.IP{ip}},
+			Trigger: "add",
+		}); err != nil {
+			t.Fatalf("update: %v", err)
+		}
+	}
+
+	// The first upload fails, so both changes are uploaded together.
+	select {
+	case <-u.uploaded:
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for upload")
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("run: %v", err)
+	}
+
+	ts := timestamppb.New(now)
+	want := map[string][]*RecordChange{
+		"test/2021/06/01/000000.000000000.jsonl": {
+			{Store: "main", Kind: "internal", Records: []string{"internal.example.com"}, After: []string{"10.0.0.1"}, Trigger: "add", Time: ts},
+			{Store: "main", Kind: "internal", Records: []string{"internal.example.com"}, Before: []string{"10.0.0.1"}, After: []string{"10.0.0.2"}, Trigger: "add", Time: ts},
+		},
+	}
+	u.Lock()
+	defer u.Unlock()
+	if diff := cmp.Diff(u.objects, want, protocmp.Transform()); diff != "" {
+		t.Errorf("objects:\n%s", diff)
+	}
+}
@@ -0,0 +1,211 @@
+// Package changes streams changes to records over gRPC, so that other systems (inventories,
+// firewall automation, load balancer config generators) can follow the nodes' addresses without
+// watching the nodes themselves.
+package changes
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative changes.proto
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/k8s"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	watchers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "changes_watchers",
+		Help: "The number of clients watching record changes.",
+	})
+	droppedWatchers = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "changes_dropped_watchers",
+		Help: "The number of clients that were disconnected because they fell too far behind.",
+	})
+)
+
+// Buffer is how many changes a watcher may fall behind before it's disconnected.
+const Buffer = 1024
+
+type recordKey struct {
+	store string
+	kind  k8s.Kind
+}
+
+// watcher is a client watching changes.
+type watcher struct {
+	stores map[string]bool // If non-empty, the stores the watcher is interested in.
+	ch     chan *RecordChange
+	dead   bool // Set when the watcher falls behind; ch is closed.
+}
+
+func (w *watcher) wants(store string) bool {
+	return len(w.stores) == 0 || w.stores[store]
+}
+
+// Server is a ChangesServer that broadcasts the changes that it receives, as a sink of every store,
+// to every watcher.
+type Server struct {
+	UnimplementedChangesServer
+	now      func() time.Time
+	mu       sync.Mutex
+	current  map[recordKey]*RecordChange // The latest change to each record.
+	watchers map[*watcher]struct{}
+}
+
+// NewServer returns a new Server.
+func NewServer() *Server {
+	return &Server{
+		now:      time.Now,
+		current:  make(map[recordKey]*RecordChange),
+		watchers: make(map[*watcher]struct{}),
+	}
+}
+
+// Sink returns a sink that streams changes to the store's records to watchers.  records maps each
+// kind of address to the names of the DNS records that it's published in.
+func (s *Server) Sink(store string, records map[k8s.Kind][]string) k8s.Sink {
+	return s.DynamicSink(store, func(kind k8s.Kind) []string { return records[kind] })
+}
+
+// DynamicSink is like Sink, but looks up the names of the DNS records with each change, for stores
+// whose records change when the configuration is reloaded.
+func (s *Server) DynamicSink(store string, records func(k8s.Kind) []string) k8s.Sink {
+	return k8s.SinkFunc("changes", func(req k8s.UpdateRequest) error {
+		s.update(store, records(req.Record.Kind), req)
+		return nil
+	})
+}
+
+func addresses(ips []net.IP) []string {
+	result := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		result = append(result, ip.String())
+	}
+	sort.Strings(result)
+	return result
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// update broadcasts a change if the record's addresses differ from the last broadcast.
+func (s *Server) update(store string, records []string, req k8s.UpdateRequest) {
+	key := recordKey{store: store, kind: req.Record.Kind}
+	after := addresses(req.Record.IPs)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var before []string
+	if prev, ok := s.current[key]; ok {
+		if equal(prev.After, after) {
+			return
+		}
+		before = prev.After
+	}
+	change := &RecordChange{
+		Store:   store,
+		Kind:    string(req.Record.Kind),
+		Records: records,
+		Before:  before,
+		After:   after,
+		Trigger: req.Trigger,
+		Time:    timestamppb.New(s.now()),
+	}
+	s.current[key] = change
+	for w := range s.watchers {
+		if w.wants(store) {
+			s.send(w, change)
+		}
+	}
+}
+
+// send sends a change to a watcher, disconnecting it if it has fallen behind.  The caller must
+// hold the lock.
+func (s *Server) send(w *watcher, change *RecordChange) {
+	if w.dead {
+		return
+	}
+	select {
+	case w.ch <- change:
+	default:
+		w.dead = true
+		close(w.ch)
+		droppedWatchers.Inc()
+	}
+}
+
+// subscribe returns a new watcher of the provided stores (or every store, if empty), which first
+// receives the current contents of every record, and a function that unsubscribes it.
+func (s *Server) subscribe(stores []string) (*watcher, func()) {
+	w := &watcher{stores: make(map[string]bool), ch: make(chan *RecordChange, Buffer)}
+	for _, st := range stores {
+		w.stores[st] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]recordKey, 0, len(s.current))
+	for key := range s.current {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].store != keys[j].store {
+			return keys[i].store < keys[j].store
+		}
+		return keys[i].kind < keys[j].kind
+	})
+	for _, key := range keys {
+		if !w.wants(key.store) {
+			continue
+		}
+		initial := proto.Clone(s.current[key]).(*RecordChange)
+		initial.Before = initial.After
+		initial.Trigger = "initial"
+		s.send(w, initial)
+	}
+	s.watchers[w] = struct{}{}
+	watchers.Inc()
+	return w, func() {
+		s.mu.Lock()
+		delete(s.watchers, w)
+		s.mu.Unlock()
+		watchers.Dec()
+	}
+}
+
+// Watch implements ChangesServer.
+func (s *Server) Watch(req *WatchRequest, stream Changes_WatchServer) error {
+	w, unsubscribe := s.subscribe(req.GetStores())
+	defer unsubscribe()
+	ctx := stream.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case change, ok := <-w.ch:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "watcher fell too far behind")
+			}
+			if err := stream.Send(change); err != nil {
+				zap.L().Debug("problem sending change to watcher", zap.Error(err))
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package changes
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/k8s"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestWatch(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s := NewServer()
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	lis := bufconn.Listen(1 << 20)
+	gs := grpc.NewServer()
+	RegisterChangesServer(gs, s)
+	go gs.Serve(lis)
+	defer gs.Stop()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithInsecure(), grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	main := s.Sink("main", map[k8s.Kind][]string{k8s.External: {"nodes.example.com"}})
+	other := s.Sink("other", nil)
+	update := func(sink k8s.Sink, trigger string, ips ...net.IP) {
+		t.Helper()
+		if err := sink.Update(k8s.UpdateRequest{Ctx: ctx, Record: k8s.Record{Kind: k8s.External, IPs: ips}, Trigger: trigger}); err != nil {
+			t.Fatalf("update: %v", err)
+		}
+	}
+	update(main, "add", net.IPv4(1, 2, 3, 4))
+
+	stream, err := NewChangesClient(conn).Watch(ctx, &WatchRequest{Stores: []string{"main"}})
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	var got []*RecordChange
+	recv := func() {
+		t.Helper()
+		c, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("recv: %v", err)
+		}
+		got = append(got, c)
+	}
+	recv()
+
+	update(other, "add", net.IPv4(5, 6, 7, 8))                         // Filtered out.
+	update(main, "resync", net.IPv4(1, 2, 3, 4))                       // Unchanged.
+	update(main, "update", net.IPv4(1, 2, 3, 5), net.IPv4(1, 2, 3, 4)) // Changed.
+	recv()
+
+	ts := timestamppb.New(now)
+	want := []*RecordChange{
+		{Store: "main", Kind: "external", Records: []string{"nodes.example.com"}, Before: []string{"1.2.3.4"}, After: []string{"1.2.3.4"}, Trigger: "initial", Time: ts},
+		{Store: "main", Kind: "external", Records: []string{"nodes.example.com"}, Before: []string{"1.2.3.4"}, After: []string{"1.2.3.4", "1.2.3.5"}, Trigger: "update", Time: ts},
+	}
+	if diff := cmp.Diff(got, want, protocmp.Transform()); diff != "" {
+		t.Errorf("changes:\n%s", diff)
+	}
+}
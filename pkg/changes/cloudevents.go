@@ -0,0 +1,157 @@
+package changes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var (
+	cloudEventsSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cloudevents_sent",
+		Help: "The number of CloudEvents sent, by whether the sink accepted them (\"ok\" or \"error\").",
+	}, []string{"result"})
+)
+
+// CloudEventType is the type of the CloudEvents that describe a change to a record.
+const CloudEventType = "com.github.jrockway.nodedns.record.changed"
+
+// CloudEventsConfig configures sending record changes to an HTTP endpoint as CloudEvents.
+type CloudEventsConfig struct {
+	Sink    string        `long:"cloudevents_sink" env:"CLOUDEVENTS_SINK" description:"the url to post a CloudEvent to for every change to a record, like a knative broker or an argo events webhook"`
+	Source  string        `long:"cloudevents_source" env:"CLOUDEVENTS_SOURCE" description:"the source attribute of the events, identifying this instance" default:"nodedns"`
+	Retries int           `long:"cloudevents_retries" env:"CLOUDEVENTS_RETRIES" description:"how many times to retry sending an event that the sink didn't accept, before dropping it" default:"5"`
+	Timeout time.Duration `long:"cloudevents_timeout" env:"CLOUDEVENTS_TIMEOUT" description:"how long each attempt to send an event may take" default:"10s"`
+}
+
+// CloudEventsSender sends the changes that a Server broadcasts to an HTTP endpoint, as CloudEvents in
+// binary content mode, with the change as a JSON-encoded RecordChange.
+type CloudEventsSender struct {
+	Config CloudEventsConfig
+	Logger *zap.Logger
+	http   *http.Client
+	wait   time.Duration // How long to wait before the first retry.
+	seq    uint64
+	start  int64
+}
+
+// NewCloudEventsSender returns a CloudEventsSender.
+func NewCloudEventsSender(cfg CloudEventsConfig) *CloudEventsSender {
+	return &CloudEventsSender{
+		Config: cfg,
+		Logger: zap.L().Named("cloudevents"),
+		http:   &http.Client{Transport: tracing.WrapRoundTripper(nil)},
+		wait:   time.Second,
+		start:  time.Now().UnixNano(),
+	}
+}
+
+// id returns a new event ID, unique for this source.
+func (c *CloudEventsSender) id() string {
+	return strconv.FormatInt(c.start, 36) + "-" + strconv.FormatUint(atomic.AddUint64(&c.seq, 1), 10)
+}
+
+// Send sends a change to the sink, once.
+func (c *CloudEventsSender) Send(ctx context.Context, change *RecordChange) error {
+	body, err := protojson.Marshal(change)
+	if err != nil {
+		return fmt.Errorf("marshal change: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.Config.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Config.Sink, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("ce-specversion", "1.0")
+	req.Header.Set("ce-id", c.id())
+	req.Header.Set("ce-source", c.Config.Source)
+	req.Header.Set("ce-type", CloudEventType)
+	req.Header.Set("ce-subject", change.GetStore()+"/"+change.GetKind())
+	req.Header.Set("ce-time", change.GetTime().AsTime().Format(time.RFC3339Nano))
+	res, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("post event: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("post event: unexpected status %s: %s", res.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// send sends a change to the sink, retrying with exponential backoff.
+func (c *CloudEventsSender) send(ctx context.Context, change *RecordChange) error {
+	wait := c.wait
+	for attempt := 0; ; attempt++ {
+		err := c.Send(ctx, change)
+		if err == nil || attempt >= c.Config.Retries {
+			return err
+		}
+		c.Logger.Debug("problem sending event; retrying", zap.Error(err), zap.Int("attempt", attempt+1), zap.Duration("wait", wait))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		if wait < time.Minute {
+			wait *= 2
+		}
+	}
+}
+
+// Run sends every change that the server broadcasts to the sink, until the context is done.  If
+// the sink falls too far behind, it resubscribes, and sends the current contents of every record
+// again.
+func (c *CloudEventsSender) Run(ctx context.Context, s *Server) error {
+	if c.Config.Sink == "" {
+		return errors.New("no sink configured")
+	}
+	for {
+		w, unsubscribe := s.subscribe(nil)
+		err := c.drain(ctx, w)
+		unsubscribe()
+		if err != nil {
+			return err
+		}
+		c.Logger.Warn("fell too far behind; resubscribing")
+	}
+}
+
+// drain sends changes from the watcher until it's closed or the context is done.
+func (c *CloudEventsSender) drain(ctx context.Context, w *watcher) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case change, ok := <-w.ch:
+			if !ok {
+				return nil
+			}
+			if err := c.send(ctx, change); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				cloudEventsSent.WithLabelValues("error").Inc()
+				c.Logger.Error("problem sending event; dropping it", zap.String("store", change.GetStore()), zap.String("kind", change.GetKind()), zap.Error(err))
+				continue
+			}
+			cloudEventsSent.WithLabelValues("ok").Inc()
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package changes
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/k8s"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestCloudEvents(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	type event struct {
+		headers map[string]string
+		change  *RecordChange
+	}
+	events := make(chan event, 10)
+	failures := 1
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if failures > 0 {
+			failures--
+			http.Error(w, "injected error", http.StatusServiceUnavailable)
+			return
+		}
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Errorf("read body: %v", err)
+		}
+		change := new(RecordChange)
+		if err := protojson.Unmarshal(body, change); err != nil {
+			t.Errorf("unmarshal body: %v", err)
+		}
+		headers := make(map[string]string)
+		for _, h := range []string{"Content-Type", "Ce-Specversion", "Ce-Source", "Ce-Type", "Ce-Subject", "Ce-Time"} {
+			headers[h] = req.Header.Get(h)
+		}
+		if req.Header.Get("Ce-Id") == "" {
+			t.Error("no event id")
+		}
+		events <- event{headers: headers, change: change}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer sink.Close()
+
+	s := NewServer()
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	c := NewCloudEventsSender(CloudEventsConfig{Sink: sink.URL, Source: "test", Retries: 2, Timeout: time.Second})
+	c.Logger = l
+	c.wait = time.Millisecond
+	done := make(chan error)
+	go func() { done <- c.Run(ctx, s) }()
+
+	// The sender subscribes asynchronously; a change made before it subscribes is sent as
+	// "initial", so wait for it to subscribe.
+	for {
+		s.mu.Lock()
+		n := len(s.watchers)
+		s.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := s.Sink("main", map[k8s.Kind][]string{k8s.Internal: {"internal.example.com"}}).Update(k8s.UpdateRequest{
+		Ctx:     ctx,
+		Record:  k8s.Record{Kind: k8s.Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 1)}},
+		Trigger: "add",
+	}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	var got event
+	select {
+	case got = <-events:
+	case <-ctx.Done():
+		t.Fatal("timed out waiting for event")
+	}
+	wantHeaders := map[string]string{
+		"Content-Type":   "application/json",
+		"Ce-Specversion": "1.0",
+		"Ce-Source":      "test",
+		"Ce-Type":        CloudEventType,
+		"Ce-Subject":     "main/internal",
+		"Ce-Time":        "2021-06-01T00:00:00Z",
+	}
+	if diff := cmp.Diff(got.headers, wantHeaders); diff != "" {
+		t.Errorf("headers:\n%s", diff)
+	}
+	wantChange := &RecordChange{Store: "main", Kind: "internal", Records: []string{"internal.example.com"}, After: []string{"10.0.0.1"}, Trigger: "add", Time: timestamppb.New(now)}
+	if diff := cmp.Diff(got.change, wantChange, protocmp.Transform()); diff != "" {
+		t.Errorf("change:\n%s", diff)
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("run: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package changes
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// JSONLinesWriter writes every change that a Server broadcasts to W, one JSON-encoded
+// RecordChange per line, for shell pipelines and log processors that would rather not parse the
+// human-oriented logs.
+type JSONLinesWriter struct {
+	W      io.Writer
+	Logger *zap.Logger
+}
+
+// NewJSONLinesWriter returns a JSONLinesWriter that writes to w.
+func NewJSONLinesWriter(w io.Writer) *JSONLinesWriter {
+	return &JSONLinesWriter{W: w, Logger: zap.L().Named("changes-jsonl")}
+}
+
+// Run writes every change that the server broadcasts until the context is done.  If the writer
+// falls too far behind, it resubscribes, and writes the current contents of every record again.
+func (j *JSONLinesWriter) Run(ctx context.Context, s *Server) error {
+	for {
+		w, unsubscribe := s.subscribe(nil)
+		err := j.drain(ctx, w)
+		unsubscribe()
+		if err != nil {
+			return err
+		}
+		j.Logger.Warn("fell too far behind; resubscribing")
+	}
+}
+
+// drain writes changes from the watcher until it's closed or the context is done.
+func (j *JSONLinesWriter) drain(ctx context.Context, w *watcher) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case change, ok := <-w.ch:
+			if !ok {
+				return nil
+			}
+			if err := j.write(change); err != nil {
+				j.Logger.Error("problem writing change; skipping it", zap.String("store", change.GetStore()), zap.String("kind", change.GetKind()), zap.Error(err))
+			}
+		}
+	}
+}
+
+// write writes one change as a single line, so that concurrent readers never see half of one.
+func (j *JSONLinesWriter) write(change *RecordChange) error {
+	line, err := protojson.Marshal(change)
+	if err != nil {
+		return fmt.Errorf("marshal change: %w", err)
+	}
+	if _, err := j.W.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write change: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package changes
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/k8s"
+	"go.uber.org/zap/zaptest"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestJSONLinesWriter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s := NewServer()
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	r, w := io.Pipe()
+	j := NewJSONLinesWriter(w)
+	j.Logger = zaptest.NewLogger(t)
+	done := make(chan error)
+	go func() { done <- j.Run(ctx, s) }()
+
+	for {
+		s.mu.Lock()
+		n := len(s.watchers)
+		s.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	sink := s.Sink("main", map[k8s.Kind][]string{k8s.External: {"example.com"}})
+	go func() {
+		for _, ip := range []net.IP{net.IPv4(42, 0, 0, 1), net.IPv4(42, 0, 0, 2)} {
+			if err := sink.Update(k8s.UpdateRequest{
+				Ctx:     ctx,
+				Record:  k8s.Record{Kind: k8s.External, IPs: []net.IP{ip}},
+				Trigger: "update",
+			}); err != nil {
+				t.Errorf("update: %v", err)
+			}
+		}
+	}()
+
+	var got []*RecordChange
+	scanner := bufio.NewScanner(r)
+	for len(got) < 2 && scanner.Scan() {
+		change := new(RecordChange)
+		if err := protojson.Unmarshal(scanner.Bytes(), change); err != nil {
+			t.Fatalf("unmarshal %q: %v", scanner.Text(), err)
+		}
+		got = append(got, change)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("run: %v", err)
+	}
+
+	ts := timestamppb.New(now)
+	want := []*RecordChange{
+		{Store: "main", Kind: "external", Records: []string{"example.com"}, After: []string{"42.0.0.1"}, Trigger: "update", Time: ts},
+		{Store: "main", Kind: "external", Records: []string{"example.com"}, Before: []string{"42.0.0.1"}, After: []string{"42.0.0.2"}, Trigger: "update", Time: ts},
+	}
+	if diff := cmp.Diff(got, want, protocmp.Transform()); diff != "" {
+		t.Errorf("changes:\n%s", diff)
+	}
+}
@@ -0,0 +1,114 @@
+// Package chaos injects faults into calls to providers, so that operators can see how nodedns and
+// their alerting behave when a provider is slow or broken, before it happens in production.
+package chaos
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var (
+	injectedFaults = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "chaos_injected_faults",
+			Help: "The number of faults injected by chaos mode, by kind of fault.",
+		},
+		[]string{"kind"},
+	)
+)
+
+// Config configures fault injection.  Rates are probabilities between 0 and 1, evaluated
+// independently for each request.
+type Config struct {
+	Enabled     bool          `long:"chaos" env:"CHAOS" description:"inject faults into provider api calls; never enable this in production"`
+	Latency     time.Duration `long:"chaos_latency" env:"CHAOS_LATENCY" description:"how much latency to add to slowed-down requests" default:"5s"`
+	LatencyRate float64       `long:"chaos_latency_rate" env:"CHAOS_LATENCY_RATE" description:"the fraction of requests to slow down"`
+	ErrorRate   float64       `long:"chaos_error_rate" env:"CHAOS_ERROR_RATE" description:"the fraction of requests to fail without sending them"`
+	PartialRate float64       `long:"chaos_partial_rate" env:"CHAOS_PARTIAL_RATE" description:"the fraction of requests to send, but then report as failed, as though the response was lost"`
+}
+
+// Validate returns an error if any rate is out of range.
+func (c *Config) Validate() error {
+	for name, r := range map[string]float64{"latency": c.LatencyRate, "error": c.ErrorRate, "partial": c.PartialRate} {
+		if r < 0 || r > 1 {
+			return fmt.Errorf("chaos %s rate %v: must be between 0 and 1", name, r)
+		}
+	}
+	return nil
+}
+
+// ErrInjected is returned (wrapped) for every injected failure.
+var ErrInjected = errors.New("chaos: injected failure")
+
+// Transport is an http.RoundTripper that injects faults into requests.
+type Transport struct {
+	Config     Config
+	Underlying http.RoundTripper
+	Logger     *zap.Logger
+
+	// Rand returns a random number in [0, 1).  If nil, math/rand is used.
+	Rand func() float64
+
+	mu sync.Mutex
+}
+
+// Wrap returns rt wrapped with fault injection, or rt itself if chaos mode is not enabled.
+func (c *Config) Wrap(rt http.RoundTripper) http.RoundTripper {
+	if !c.Enabled {
+		return rt
+	}
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &Transport{Config: *c, Underlying: rt, Logger: zap.L().Named("chaos")}
+}
+
+func (t *Transport) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Rand != nil {
+		return t.Rand() < rate
+	}
+	return rand.Float64() < rate // nolint:gosec
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	l := t.Logger.With(zap.String("method", req.Method), zap.String("url", req.URL.String()))
+	if t.roll(t.Config.LatencyRate) {
+		injectedFaults.WithLabelValues("latency").Inc()
+		l.Info("injecting latency", zap.Duration("latency", t.Config.Latency))
+		select {
+		case <-time.After(t.Config.Latency):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	if t.roll(t.Config.ErrorRate) {
+		injectedFaults.WithLabelValues("error").Inc()
+		l.Info("injecting error")
+		return nil, fmt.Errorf("%s %s: %w", req.Method, req.URL, ErrInjected)
+	}
+	res, err := t.Underlying.RoundTrip(req)
+	if err != nil {
+		return res, err
+	}
+	if t.roll(t.Config.PartialRate) {
+		injectedFaults.WithLabelValues("partial").Inc()
+		l.Info("injecting partial failure; the request was sent", zap.Int("status", res.StatusCode))
+		res.Body.Close()
+		return nil, fmt.Errorf("%s %s: response lost: %w", req.Method, req.URL, ErrInjected)
+	}
+	return res, nil
+}
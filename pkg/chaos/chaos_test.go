@@ -0,0 +1,76 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zaptest"
+)
+
+func TestTransport(t *testing.T) {
+	var requests int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+	}))
+	defer s.Close()
+
+	testData := []struct {
+		name         string
+		config       Config
+		timeout      time.Duration
+		wantErr      error
+		wantRequests int
+	}{
+		{name: "no faults", config: Config{Enabled: true}, wantRequests: 1},
+		{name: "error", config: Config{Enabled: true, ErrorRate: 1}, wantErr: ErrInjected},
+		{name: "partial", config: Config{Enabled: true, PartialRate: 1}, wantErr: ErrInjected, wantRequests: 1},
+		{name: "latency", config: Config{Enabled: true, Latency: time.Minute, LatencyRate: 1}, timeout: 10 * time.Millisecond, wantErr: context.DeadlineExceeded},
+		{name: "unlikely", config: Config{Enabled: true, ErrorRate: 0.5}, wantRequests: 1},
+	}
+	for _, test := range testData {
+		requests = 0
+		tr := test.config.Wrap(s.Client().Transport).(*Transport)
+		tr.Logger = zaptest.NewLogger(t)
+		tr.Rand = func() float64 { return 0.75 }
+		ctx := context.Background()
+		if test.timeout > 0 {
+			var c func()
+			ctx, c = context.WithTimeout(ctx, test.timeout)
+			defer c()
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := tr.RoundTrip(req)
+		if err == nil {
+			res.Body.Close()
+		}
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("%s: error:\n  got: %v\n want: %v", test.name, err, test.wantErr)
+		}
+		if got, want := requests, test.wantRequests; got != want {
+			t.Errorf("%s: requests:\n  got: %v\n want: %v", test.name, got, want)
+		}
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	rt := http.DefaultTransport
+	if got := (&Config{ErrorRate: 1}).Wrap(rt); got != rt {
+		t.Errorf("disabled chaos mode wrapped the transport: %#v", got)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := (&Config{ErrorRate: 1.5}).Validate(); err == nil {
+		t.Error("expected error for rate > 1")
+	}
+	if err := (&Config{ErrorRate: 0.5}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+	var got []time.Duration
+	record := func() { got = append(got, c.Now().Sub(start)) }
+	c.AfterFunc(2*time.Second, record)
+	c.AfterFunc(time.Second, func() {
+		record()
+		// Scheduled by a function that's being called; due before the end of the Advance.
+		c.AfterFunc(500*time.Millisecond, record)
+	})
+	stopped := c.AfterFunc(1500*time.Millisecond, record)
+	if !stopped.Stop() {
+		t.Error("stop: expected to stop a pending timer")
+	}
+	c.AfterFunc(time.Minute, record)
+
+	c.Advance(3 * time.Second)
+	want := []time.Duration{time.Second, 1500 * time.Millisecond, 2 * time.Second}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("calls:\n%s", diff)
+	}
+	if got, want := c.Now(), start.Add(3*time.Second); !got.Equal(want) {
+		t.Errorf("now:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := c.Pending(), 1; got != want {
+		t.Errorf("pending:\n  got: %v\n want: %v", got, want)
+	}
+	if stopped.Stop() {
+		t.Error("stop: expected stopping a stopped timer to return false")
+	}
+}
@@ -0,0 +1,175 @@
+// Package clouddns is a minimal client for the parts of the Google Cloud DNS API that nodedns uses.
+package clouddns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"github.com/jrockway/nodedns/pkg/tracing"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/jwt"
+)
+
+const (
+	// DefaultBaseURL is the base URL of the Cloud DNS v1 API.
+	DefaultBaseURL = "https://dns.googleapis.com/dns/v1"
+	// Scope is the OAuth scope that allows changing records.
+	Scope = "https://www.googleapis.com/auth/ndev.clouddns.readwrite"
+	// MetadataTokenURL is where the GCE and GKE metadata server hands out tokens for the
+	// instance's (or, with Workload Identity, the pod's) service account.
+	MetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// Client is a Cloud DNS API client for the managed zones in one project.
+type Client struct {
+	BaseURL string
+	Project string
+	http    *http.Client
+}
+
+// NewClient returns a Client for the project that authenticates with the service account key in
+// credentialsFile, or, if that's empty, with the metadata server's service account.
+func NewClient(ctx context.Context, project, credentialsFile string) (*Client, error) {
+	if credentialsFile == "" {
+		return NewClientWithTokenSource(project, &MetadataTokenSource{URL: MetadataTokenURL}), nil
+	}
+	ts, err := serviceAccountTokenSource(ctx, credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("read credentials: %w", err)
+	}
+	return NewClientWithTokenSource(project, ts), nil
+}
+
+// NewClientWithTokenSource returns a Client for the project that authenticates with tokens from ts.
+func NewClientWithTokenSource(project string, ts oauth2.TokenSource) *Client {
+	return &Client{
+		BaseURL: DefaultBaseURL,
+		Project: project,
+		http: &http.Client{Transport: &oauth2.Transport{
+			Source: oauth2.ReuseTokenSource(nil, ts),
+			Base:   tracing.WrapRoundTripper(nil),
+		}},
+	}
+}
+
+// serviceAccountTokenSource returns a TokenSource for the service account key in the JSON file.
+func serviceAccountTokenSource(ctx context.Context, file string) (oauth2.TokenSource, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var key struct {
+		Type         string `json:"type"`
+		ClientEmail  string `json:"client_email"`
+		PrivateKey   string `json:"private_key"`
+		PrivateKeyID string `json:"private_key_id"`
+		TokenURI     string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(content, &key); err != nil {
+		return nil, fmt.Errorf("unmarshal %s: %w", file, err)
+	}
+	if key.Type != "service_account" {
+		return nil, fmt.Errorf("%s: not a service account key (type %q)", file, key.Type)
+	}
+	cfg := &jwt.Config{
+		Email:        key.ClientEmail,
+		PrivateKey:   []byte(key.PrivateKey),
+		PrivateKeyID: key.PrivateKeyID,
+		Scopes:       []string{Scope},
+		TokenURL:     key.TokenURI,
+	}
+	return cfg.TokenSource(ctx), nil
+}
+
+// MetadataTokenSource gets tokens from the metadata server.
+type MetadataTokenSource struct {
+	URL string
+}
+
+// Token implements oauth2.TokenSource.
+func (s *MetadataTokenSource) Token() (*oauth2.Token, error) {
+	req, err := http.NewRequest("GET", s.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("get token from metadata server: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get token from metadata server: %s", res.Status)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		TokenType   string `json:"token_type"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("decode token from metadata server: %w", err)
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("metadata server returned an empty token")
+	}
+	return &oauth2.Token{
+		AccessToken: token.AccessToken,
+		TokenType:   token.TokenType,
+		Expiry:      time.Now().Add(time.Duration(token.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// APIError is an error returned by the Cloud DNS API.
+type APIError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string { return fmt.Sprintf("%d: %s", e.Code, e.Message) }
+
+// do makes an API request to a path relative to the project, JSON-encoding in as the body (if
+// non-nil) and decoding the response into out (if non-nil).
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return fmt.Errorf("marshal request: %w", err)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+"/projects/"+c.Project+path, &body)
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	correlation.SetHeader(req)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		apiErr := &APIError{Code: res.StatusCode, Message: res.Status}
+		var r struct {
+			Error *APIError `json:"error"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&r); err == nil && r.Error != nil {
+			apiErr.Message = r.Error.Message
+		}
+		return fmt.Errorf("%s %s: %w", method, path, apiErr)
+	}
+	if out != nil {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			return fmt.Errorf("%s %s: decode response: %w", method, path, err)
+		}
+	}
+	return nil
+}
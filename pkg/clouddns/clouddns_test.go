@@ -0,0 +1,91 @@
+package clouddns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/oauth2"
+)
+
+func TestMetadataTokenSource(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if got, want := req.Header.Get("Metadata-Flavor"), "Google"; got != want {
+			http.Error(w, "missing Metadata-Flavor", http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "token", "expires_in": 3600, "token_type": "Bearer"}) // nolint:errcheck
+	}))
+	defer s.Close()
+	token, err := (&MetadataTokenSource{URL: s.URL}).Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := token.AccessToken, "token"; got != want {
+		t.Errorf("access token:\n  got: %v\n want: %v", got, want)
+	}
+	if !token.Valid() {
+		t.Error("token should be valid")
+	}
+}
+
+func TestClient(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/projects/project/managedZones", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{ // nolint:errcheck
+			"managedZones": []ManagedZone{{Name: "example-com", DNSName: req.URL.Query().Get("dnsName")}},
+		})
+	})
+	mux.HandleFunc("/projects/project/managedZones/example-com/rrsets", func(w http.ResponseWriter, req *http.Request) {
+		if got, want := req.Header.Get("Authorization"), "Bearer token"; got != want {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": 401, "message": "bad token"}}) // nolint:errcheck
+			return
+		}
+		res := map[string]interface{}{"rrsets": []ResourceRecordSet{{Name: "nodes.example.com.", Type: "A", TTL: 60, RRDatas: []string{"10.0.0.1"}}}}
+		if req.URL.Query().Get("pageToken") == "" {
+			res["rrsets"] = []ResourceRecordSet{{Name: "nodes.example.com.", Type: "AAAA", TTL: 60, RRDatas: []string{"2001:db8::1"}}}
+			res["nextPageToken"] = "next"
+		}
+		json.NewEncoder(w).Encode(res) // nolint:errcheck
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+	ctx := context.Background()
+
+	c := NewClientWithTokenSource("project", oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}))
+	c.BaseURL = s.URL
+	zone, err := c.ManagedZoneForDNSName(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(zone, &ManagedZone{Name: "example-com", DNSName: "example.com."}); diff != "" {
+		t.Errorf("managed zone:\n%s", diff)
+	}
+	sets, err := c.ResourceRecordSets(ctx, "example-com", "nodes.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ResourceRecordSet{
+		{Name: "nodes.example.com.", Type: "AAAA", TTL: 60, RRDatas: []string{"2001:db8::1"}},
+		{Name: "nodes.example.com.", Type: "A", TTL: 60, RRDatas: []string{"10.0.0.1"}},
+	}
+	if diff := cmp.Diff(sets, want); diff != "" {
+		t.Errorf("record sets:\n%s", diff)
+	}
+
+	c = NewClientWithTokenSource("project", oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "wrong"}))
+	c.BaseURL = s.URL
+	_, err = c.ResourceRecordSets(ctx, "example-com", "nodes.example.com")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an APIError, got %v", err)
+	}
+	if diff := cmp.Diff(apiErr, &APIError{Code: 401, Message: "bad token"}); diff != "" {
+		t.Errorf("error:\n%s", diff)
+	}
+}
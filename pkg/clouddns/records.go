@@ -0,0 +1,95 @@
+package clouddns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ManagedZone is a zone hosted by Cloud DNS.
+type ManagedZone struct {
+	Name    string `json:"name"`    // Like "example-com".
+	DNSName string `json:"dnsName"` // Like "example.com.".
+}
+
+// ManagedZone returns the managed zone with the provided name.
+func (c *Client) ManagedZone(ctx context.Context, name string) (*ManagedZone, error) {
+	var zone ManagedZone
+	if err := c.do(ctx, "GET", "/managedZones/"+url.PathEscape(name), nil, &zone); err != nil {
+		return nil, fmt.Errorf("get managed zone: %w", err)
+	}
+	return &zone, nil
+}
+
+// ManagedZoneForDNSName returns the managed zone that serves the provided domain, like
+// "example.com".
+func (c *Client) ManagedZoneForDNSName(ctx context.Context, dnsName string) (*ManagedZone, error) {
+	dnsName = strings.TrimSuffix(dnsName, ".") + "."
+	var res struct {
+		ManagedZones []ManagedZone `json:"managedZones"`
+	}
+	if err := c.do(ctx, "GET", "/managedZones?dnsName="+url.QueryEscape(dnsName), nil, &res); err != nil {
+		return nil, fmt.Errorf("find managed zone: %w", err)
+	}
+	for _, z := range res.ManagedZones {
+		if strings.EqualFold(z.DNSName, dnsName) {
+			return &z, nil
+		}
+	}
+	return nil, fmt.Errorf("no managed zone serves %q", dnsName)
+}
+
+// ResourceRecordSet is every record of one type with one name.
+type ResourceRecordSet struct {
+	Name    string   `json:"name"` // Fully-qualified, with a trailing dot.
+	Type    string   `json:"type"`
+	TTL     int      `json:"ttl"` // In seconds.
+	RRDatas []string `json:"rrdatas"`
+}
+
+// ResourceRecordSets returns the record sets, of any type, with the provided fully-qualified name.
+func (c *Client) ResourceRecordSets(ctx context.Context, zone, name string) ([]ResourceRecordSet, error) {
+	name = strings.TrimSuffix(name, ".") + "."
+	var result []ResourceRecordSet
+	var token string
+	for page := 1; page <= 100; page++ {
+		path := fmt.Sprintf("/managedZones/%s/rrsets?name=%s", url.PathEscape(zone), url.QueryEscape(name))
+		if token != "" {
+			path += "&pageToken=" + url.QueryEscape(token)
+		}
+		var res struct {
+			RRSets        []ResourceRecordSet `json:"rrsets"`
+			NextPageToken string              `json:"nextPageToken"`
+		}
+		if err := c.do(ctx, "GET", path, nil, &res); err != nil {
+			return nil, fmt.Errorf("list record sets: %w", err)
+		}
+		result = append(result, res.RRSets...)
+		if res.NextPageToken == "" {
+			return result, nil
+		}
+		token = res.NextPageToken
+	}
+	return nil, errors.New("more than 100 pages!")
+}
+
+// Change replaces record sets.  The deletions must exactly match the existing record sets, or the
+// whole change is rejected, so deleting a record set and adding its replacement in the same change
+// swaps it atomically.
+type Change struct {
+	ID        string              `json:"id,omitempty"`
+	Status    string              `json:"status,omitempty"` // "pending" or "done".
+	Additions []ResourceRecordSet `json:"additions,omitempty"`
+	Deletions []ResourceRecordSet `json:"deletions,omitempty"`
+}
+
+// CreateChange applies a change to the zone.
+func (c *Client) CreateChange(ctx context.Context, zone string, change Change) (*Change, error) {
+	var result Change
+	if err := c.do(ctx, "POST", fmt.Sprintf("/managedZones/%s/changes", url.PathEscape(zone)), change, &result); err != nil {
+		return nil, fmt.Errorf("create change: %w", err)
+	}
+	return &result, nil
+}
@@ -0,0 +1,110 @@
+// Package cloudflare is a minimal client for the parts of the Cloudflare API that nodedns uses.
+package cloudflare
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"github.com/jrockway/nodedns/pkg/tracing"
+)
+
+// DefaultBaseURL is the base URL of the Cloudflare v4 API.
+const DefaultBaseURL = "https://api.cloudflare.com/client/v4"
+
+// Client is a Cloudflare API client that authenticates with an API token.
+type Client struct {
+	BaseURL string
+	token   string
+	http    *http.Client
+}
+
+// NewClient returns a Client that authenticates with the provided API token.
+func NewClient(token string) *Client {
+	return &Client{
+		BaseURL: DefaultBaseURL,
+		token:   token,
+		http:    &http.Client{Transport: tracing.WrapRoundTripper(nil)},
+	}
+}
+
+// APIError is an error returned by the Cloudflare API.
+type APIError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e APIError) Error() string { return fmt.Sprintf("%d: %s", e.Code, e.Message) }
+
+// StatusError is an unsuccessful response from the Cloudflare API.
+type StatusError struct {
+	Status int        // The HTTP status.
+	Errors []APIError // The errors in the response, if any.
+}
+
+func (e *StatusError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d %s: %s", e.Status, http.StatusText(e.Status), strings.Join(msgs, "; "))
+}
+
+// resultInfo contains pagination information.
+type resultInfo struct {
+	Page       int `json:"page"`
+	TotalPages int `json:"total_pages"`
+	Cursors    struct {
+		After string `json:"after"`
+	} `json:"cursors"`
+}
+
+// response is the envelope that every API response is wrapped in.
+type response struct {
+	Success    bool            `json:"success"`
+	Errors     []APIError      `json:"errors"`
+	Result     json.RawMessage `json:"result"`
+	ResultInfo *resultInfo     `json:"result_info"`
+}
+
+// do makes an API request, JSON-encoding in as the body (if non-nil) and decoding the result into
+// out (if non-nil).
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) (*resultInfo, error) {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return nil, fmt.Errorf("marshal request: %w", err)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, &body)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	correlation.SetHeader(req)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer res.Body.Close()
+	var r response
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("%s %s: decode response (status %s): %w", method, path, res.Status, err)
+	}
+	if !r.Success || res.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s: %w", method, path, &StatusError{Status: res.StatusCode, Errors: r.Errors})
+	}
+	if out != nil && len(r.Result) > 0 {
+		if err := json.Unmarshal(r.Result, out); err != nil {
+			return nil, fmt.Errorf("%s %s: unmarshal result: %w", method, path, err)
+		}
+	}
+	return r.ResultInfo, nil
+}
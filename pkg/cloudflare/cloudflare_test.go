@@ -0,0 +1,89 @@
+package cloudflare
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+)
+
+// reply writes an API response envelope.
+func reply(w http.ResponseWriter, result interface{}, info map[string]interface{}) {
+	json.NewEncoder(w).Encode(map[string]interface{}{ // nolint:errcheck
+		"success":     true,
+		"errors":      []interface{}{},
+		"result":      result,
+		"result_info": info,
+	})
+}
+
+func TestListSync(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	items := []listItem{{ID: "1", IP: "42.0.0.1"}, {ID: "2", IP: "42.0.0.9"}}
+	var puts int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if got, want := req.Header.Get("Authorization"), "Bearer token"; got != want {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "errors": []APIError{{Code: 10000, Message: "Authentication error"}}}) // nolint:errcheck
+			return
+		}
+		if req.URL.Path != "/accounts/acct/rules/lists/list/items" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		switch req.Method {
+		case http.MethodGet:
+			// Return one item per page, to exercise pagination.
+			i, _ := strconv.Atoi(req.URL.Query().Get("cursor"))
+			info := map[string]interface{}{"cursors": map[string]string{}}
+			if i+1 < len(items) {
+				info["cursors"] = map[string]string{"after": strconv.Itoa(i + 1)}
+			}
+			reply(w, items[i:i+1], info)
+		case http.MethodPut:
+			puts++
+			items = nil
+			if err := json.NewDecoder(req.Body).Decode(&items); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			reply(w, map[string]string{"operation_id": "op"}, nil)
+		}
+	}))
+	defer s.Close()
+
+	c := NewClient("token")
+	c.BaseURL = s.URL
+	l := NewListSync(c, "acct", "list")
+	ips := []net.IP{net.IPv4(42, 0, 0, 1), net.IPv4(42, 0, 0, 2), net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")}
+	if err := l.Sync(context.Background(), ips); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	want := []listItem{
+		{IP: "2001:db8::/64", Comment: "managed by nodedns"},
+		{IP: "42.0.0.1", Comment: "managed by nodedns"},
+		{IP: "42.0.0.2", Comment: "managed by nodedns"},
+	}
+	if diff := cmp.Diff(items, want); diff != "" {
+		t.Errorf("items:\n%s", diff)
+	}
+
+	if err := l.Sync(context.Background(), ips); err != nil {
+		t.Fatalf("sync again: %v", err)
+	}
+	if got, want := puts, 1; got != want {
+		t.Errorf("puts: got %d, want %d", got, want)
+	}
+
+	c.token = "wrong"
+	if err := l.Sync(context.Background(), ips); err == nil {
+		t.Error("sync with bad token: expected error")
+	}
+}
@@ -0,0 +1,109 @@
+package cloudflare
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/tracing"
+	"go.uber.org/zap"
+)
+
+// listItem is an item in an IP List.
+type listItem struct {
+	ID      string `json:"id,omitempty"`
+	IP      string `json:"ip"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// ListSync keeps a Cloudflare IP List (usable in firewall rules and Zero Trust policies) in sync
+// with a set of addresses.
+type ListSync struct {
+	c         *Client
+	AccountID string
+	ListID    string
+}
+
+// NewListSync returns a ListSync for the IP List with the provided ID.
+func NewListSync(c *Client, accountID, listID string) *ListSync {
+	return &ListSync{c: c, AccountID: accountID, ListID: listID}
+}
+
+// listEntry returns the IP List representation of an address.  Lists don't accept individual
+// IPv6 addresses, so those are widened to their /64.
+func listEntry(ip net.IP) string {
+	if ip.To4() != nil {
+		return ip.String()
+	}
+	n := net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}
+	return n.String()
+}
+
+func (l *ListSync) path() string {
+	return fmt.Sprintf("/accounts/%s/rules/lists/%s/items", url.PathEscape(l.AccountID), url.PathEscape(l.ListID))
+}
+
+func (l *ListSync) items(ctx context.Context) ([]string, error) {
+	var result []string
+	var cursor string
+	for page := 0; page < 100; page++ {
+		path := l.path()
+		if cursor != "" {
+			path += "?cursor=" + url.QueryEscape(cursor)
+		}
+		var items []listItem
+		info, err := l.c.do(ctx, "GET", path, nil, &items)
+		if err != nil {
+			return nil, fmt.Errorf("get list items: %w", err)
+		}
+		for _, item := range items {
+			result = append(result, item.IP)
+		}
+		if info == nil || info.Cursors.After == "" {
+			return result, nil
+		}
+		cursor = info.Cursors.After
+	}
+	return nil, errors.New("more than 100 pages!")
+}
+
+// Sync replaces the contents of the IP List with the provided addresses, if they differ.
+func (l *ListSync) Sync(ctx context.Context, ips []net.IP) error {
+	ctx, span := tracing.Start(ctx, "cloudflare_ip_list_sync")
+	defer span.End()
+
+	dedup := make(map[string]struct{})
+	for _, ip := range ips {
+		dedup[listEntry(ip)] = struct{}{}
+	}
+	want := make([]string, 0, len(dedup))
+	for entry := range dedup {
+		want = append(want, entry)
+	}
+	sort.Strings(want)
+
+	have, err := l.items(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Strings(have)
+	if cmp.Equal(have, want) {
+		return nil
+	}
+
+	zap.L().Named("cloudflare").Info("replacing ip list items", zap.String("list", l.ListID), zap.Strings("items", want))
+	items := make([]listItem, 0, len(want))
+	for _, entry := range want {
+		items = append(items, listItem{IP: entry, Comment: "managed by nodedns"})
+	}
+	// The replacement happens asynchronously on Cloudflare's end; the returned operation is
+	// not waited for, because the next sync will notice if it failed.
+	if _, err := l.c.do(ctx, "PUT", l.path(), items, nil); err != nil {
+		return fmt.Errorf("replace list items: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package cloudflare
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// DNSRecord is a record in a Cloudflare zone.
+type DNSRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"` // Fully-qualified.
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"` // In seconds; 1 means automatic, which proxied records always are.
+	Proxied bool   `json:"proxied"`
+}
+
+// ZoneID returns the ID of the zone with the provided name.
+func (c *Client) ZoneID(ctx context.Context, name string) (string, error) {
+	var zones []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	if _, err := c.do(ctx, "GET", "/zones?name="+url.QueryEscape(name), nil, &zones); err != nil {
+		return "", fmt.Errorf("find zone: %w", err)
+	}
+	for _, z := range zones {
+		if z.Name == name {
+			return z.ID, nil
+		}
+	}
+	return "", fmt.Errorf("no zone named %q found", name)
+}
+
+// DNSRecords returns every record, of any type, with the provided fully-qualified name.
+func (c *Client) DNSRecords(ctx context.Context, zoneID, name string) ([]DNSRecord, error) {
+	var result []DNSRecord
+	for page := 1; page <= 100; page++ {
+		path := fmt.Sprintf("/zones/%s/dns_records?name=%s&per_page=100&page=%d", url.PathEscape(zoneID), url.QueryEscape(name), page)
+		var records []DNSRecord
+		info, err := c.do(ctx, "GET", path, nil, &records)
+		if err != nil {
+			return nil, fmt.Errorf("list dns records: %w", err)
+		}
+		result = append(result, records...)
+		if info == nil || info.Page >= info.TotalPages {
+			return result, nil
+		}
+	}
+	return nil, errors.New("more than 100 pages!")
+}
+
+// CreateDNSRecord creates a record.
+func (c *Client) CreateDNSRecord(ctx context.Context, zoneID string, r DNSRecord) (DNSRecord, error) {
+	var created DNSRecord
+	if _, err := c.do(ctx, "POST", fmt.Sprintf("/zones/%s/dns_records", url.PathEscape(zoneID)), r, &created); err != nil {
+		return DNSRecord{}, fmt.Errorf("create dns record: %w", err)
+	}
+	return created, nil
+}
+
+// UpdateDNSRecord changes whether the record with r.ID is proxied, and its TTL.
+func (c *Client) UpdateDNSRecord(ctx context.Context, zoneID string, r DNSRecord) error {
+	patch := map[string]interface{}{"proxied": r.Proxied, "ttl": r.TTL}
+	if _, err := c.do(ctx, "PATCH", fmt.Sprintf("/zones/%s/dns_records/%s", url.PathEscape(zoneID), url.PathEscape(r.ID)), patch, nil); err != nil {
+		return fmt.Errorf("update dns record: %w", err)
+	}
+	return nil
+}
+
+// DeleteDNSRecord deletes the record with the provided ID.
+func (c *Client) DeleteDNSRecord(ctx context.Context, zoneID, id string) error {
+	if _, err := c.do(ctx, "DELETE", fmt.Sprintf("/zones/%s/dns_records/%s", url.PathEscape(zoneID), url.PathEscape(id)), nil, nil); err != nil {
+		return fmt.Errorf("delete dns record: %w", err)
+	}
+	return nil
+}
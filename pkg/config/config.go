@@ -0,0 +1,362 @@
+// Package config loads the nodedns configuration file, which describes things that are awkward to
+// express with flags.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// File is the configuration file.
+type File struct {
+	// Stores are independent sets of nodes, each published to their own records.  They are
+	// maintained in addition to the records configured with flags.
+	Stores []Store `json:"stores"`
+	// Rules each publish one class of address of a set of nodes to one record.  They are a
+	// more general form of Stores.
+	Rules []Rule `json:"rules"`
+	// Aliases publish handpicked nodes to dedicated records.  They may also be kept in their
+	// own file; see LoadAliases.
+	Aliases []Alias `json:"aliases"`
+}
+
+// Store configures an independent NodeStore.
+type Store struct {
+	// Name identifies the store in logs, metrics, and traces.
+	Name string `json:"name"`
+	// Selector is a Kubernetes label selector, like "node-role.kubernetes.io/ingress"; only
+	// matching nodes are published.  If empty, every node is published.
+	Selector string `json:"selector"`
+	// Family restricts the records to ipv4 or ipv6 addresses; see Rule.
+	Family string `json:"family"`
+	// Provider is the DNS provider that hosts the zone.  If empty, --dns_provider is used.
+	Provider string `json:"provider"`
+	// Zone is the DNS zone that the records are in.  If empty, --zone is used.
+	Zone string `json:"zone"`
+	// TTL is the TTL of the records.  If zero, --ttl is used.
+	TTL metav1.Duration `json:"ttl"`
+	// Internal, External, and Overlay are the records that the nodes' addresses of each
+	// class are published to.  Empty records are not maintained.
+	Internal string `json:"internal"`
+	External string `json:"external"`
+	Overlay  string `json:"overlay"`
+}
+
+// Rules returns the rules that are equivalent to the store; one for each record that it maintains.
+func (s Store) Rules() []Rule {
+	var result []Rule
+	for _, r := range []struct{ class, record string }{
+		{ClassInternal, s.Internal},
+		{ClassExternal, s.External},
+		{ClassOverlay, s.Overlay},
+	} {
+		if r.record == "" {
+			continue
+		}
+		result = append(result, Rule{
+			Name:     s.Name,
+			Selector: s.Selector,
+			Class:    r.class,
+			Family:   s.Family,
+			Provider: s.Provider,
+			Zone:     s.Zone,
+			TTL:      s.TTL,
+			Record:   r.record,
+		})
+	}
+	return result
+}
+
+// Address classes.
+const (
+	ClassInternal = "internal"
+	ClassExternal = "external"
+	ClassOverlay  = "overlay"
+)
+
+// Address families.
+const (
+	FamilyAny  = ""
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+)
+
+// ProviderDigitalOcean is the default DNS provider.
+const ProviderDigitalOcean = "digitalocean"
+
+// Providers are the DNS providers that rules may use; each is also a choice of --dns_provider.
+// Builds may leave some of them out.
+var Providers = []string{ProviderDigitalOcean, "cloudflare", "consul", "etcd", "google", "rfc2136", "webhook", "fake", "file"}
+
+// Rule publishes one class of address of a set of nodes to one record.
+type Rule struct {
+	// Name identifies the rule in logs, metrics, and traces.
+	Name string `json:"name"`
+	// Selector is a Kubernetes label selector; only matching nodes are published.  If empty,
+	// every node is published.
+	Selector string `json:"selector"`
+	// Class is the class of address to publish; internal, external, or overlay.
+	Class string `json:"class"`
+	// Family restricts the record to ipv4 (A records) or ipv6 (AAAA records) addresses.  If
+	// empty, both are published.
+	Family string `json:"family"`
+	// Provider is the DNS provider that hosts the zone, one of Providers.  If empty,
+	// --dns_provider is used.
+	Provider string `json:"provider"`
+	// Zone is the DNS zone that the record is in.  If empty, --zone is used.
+	Zone string `json:"zone"`
+	// TTL is the TTL of the records.  If zero, --ttl is used.
+	TTL metav1.Duration `json:"ttl"`
+	// Record is the name of the record, relative to the zone.
+	Record string `json:"record"`
+	// Nodes, if non-empty, are the names of the only nodes that may be published.
+	Nodes []string `json:"nodes"`
+}
+
+// Validate returns an error if the rule is invalid.  It does not check the name.
+func (r *Rule) Validate() error {
+	if _, err := labels.Parse(r.Selector); err != nil {
+		return fmt.Errorf("selector: %w", err)
+	}
+	for _, n := range r.Nodes {
+		if n == "" {
+			return errors.New("node names must not be empty")
+		}
+	}
+	switch r.Class {
+	case ClassInternal, ClassExternal, ClassOverlay:
+	default:
+		return fmt.Errorf("invalid class %q: must be internal, external, or overlay", r.Class)
+	}
+	switch r.Family {
+	case FamilyAny, FamilyIPv4, FamilyIPv6:
+	default:
+		return fmt.Errorf("invalid family %q: must be ipv4, ipv6, or empty for both", r.Family)
+	}
+	if r.Provider != "" && !validProvider(r.Provider) {
+		return fmt.Errorf("unknown provider %q: must be one of %s", r.Provider, strings.Join(Providers, ", "))
+	}
+	if r.Record == "" {
+		return errors.New("record must be set")
+	}
+	if r.TTL.Duration < 0 {
+		return errors.New("ttl must not be negative")
+	}
+	return nil
+}
+
+func validProvider(name string) bool {
+	for _, p := range Providers {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Alias publishes handpicked nodes, chosen by name or by label, to a dedicated record, like
+// build.example.com.
+type Alias struct {
+	// Name identifies the alias in logs, metrics, and traces.  If empty, it's derived from the
+	// record.
+	Name string `json:"name"`
+	// Record is the name of the record, relative to the zone.
+	Record string `json:"record"`
+	// Nodes are the names of the nodes to publish.
+	Nodes []string `json:"nodes"`
+	// Selector is a Kubernetes label selector, like "pool=build"; matching nodes are published.
+	// If both Nodes and Selector are set, nodes must match both.
+	Selector string `json:"selector"`
+	// Class is the class of address to publish; internal, external, or overlay.  If empty,
+	// external.
+	Class string `json:"class"`
+	// Zone is the DNS zone that the record is in.  If empty, --zone is used.
+	Zone string `json:"zone"`
+	// TTL is the TTL of the records.  If zero, --ttl is used.
+	TTL metav1.Duration `json:"ttl"`
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Rule returns the rule that is equivalent to the alias.
+func (a Alias) Rule() Rule {
+	name := a.Name
+	if name == "" {
+		name = "alias-" + strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(a.Record), "-"), "-")
+	}
+	class := a.Class
+	if class == "" {
+		class = ClassExternal
+	}
+	return Rule{
+		Name:     name,
+		Selector: a.Selector,
+		Class:    class,
+		Zone:     a.Zone,
+		TTL:      a.TTL,
+		Record:   a.Record,
+		Nodes:    a.Nodes,
+	}
+}
+
+// LabelAliases returns aliases that each publish the nodes matching a label selector to a record,
+// from a mapping like {"pool=ingress": "ingress.example.com", "pool=storage":
+// "storage.example.com"}, in order of record.  class is the class of address to publish; if empty,
+// external.
+func LabelAliases(records map[string]string, class string) []Alias {
+	result := make([]Alias, 0, len(records))
+	for selector, record := range records {
+		result = append(result, Alias{
+			Name:     "label-" + strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(record), "-"), "-"),
+			Record:   record,
+			Selector: selector,
+			Class:    class,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Record < result[j].Record })
+	return result
+}
+
+// Names end up in metric labels and logger names, so keep them simple.
+var validName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// Problems is every problem found in a configuration file.
+type Problems []error
+
+// Error implements error.
+func (p Problems) Error() string {
+	msgs := make([]string, len(p))
+	for i, err := range p {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Validate returns an error if the configuration is invalid.  Every problem is reported, as
+// Problems, rather than only the first.
+func (f *File) Validate() error {
+	var problems Problems
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+	// Stores and rules are both run as NodeStores, so their names must be unique together.
+	seen := make(map[string]struct{})
+	for i, s := range f.Stores {
+		if !validName.MatchString(s.Name) {
+			add("store %d: invalid name %q: must be lowercase letters, numbers, and dashes", i, s.Name)
+		}
+		if s.Name == "main" {
+			add("store %d: the name %q is reserved for the records configured with flags", i, s.Name)
+		}
+		if _, ok := seen[s.Name]; ok {
+			add("store %q: duplicate name", s.Name)
+		}
+		seen[s.Name] = struct{}{}
+		if _, err := labels.Parse(s.Selector); err != nil {
+			add("store %q: selector: %w", s.Name, err)
+		}
+		if s.Internal == "" && s.External == "" && s.Overlay == "" {
+			add("store %q: %w", s.Name, errors.New("at least one of internal, external, or overlay must be set"))
+		}
+		if s.TTL.Duration < 0 {
+			add("store %q: ttl must not be negative", s.Name)
+		}
+		switch s.Family {
+		case FamilyAny, FamilyIPv4, FamilyIPv6:
+		default:
+			add("store %q: invalid family %q: must be ipv4, ipv6, or empty for both", s.Name, s.Family)
+		}
+		if s.Provider != "" && !validProvider(s.Provider) {
+			add("store %q: unknown provider %q: must be one of %s", s.Name, s.Provider, strings.Join(Providers, ", "))
+		}
+	}
+	for i, r := range f.Rules {
+		if !validName.MatchString(r.Name) {
+			add("rule %d: invalid name %q: must be lowercase letters, numbers, and dashes", i, r.Name)
+		}
+		if r.Name == "main" {
+			add("rule %d: the name %q is reserved for the records configured with flags", i, r.Name)
+		}
+		if _, ok := seen[r.Name]; ok {
+			add("rule %q: duplicate name", r.Name)
+		}
+		seen[r.Name] = struct{}{}
+		if err := r.Validate(); err != nil {
+			add("rule %q: %w", r.Name, err)
+		}
+	}
+	for i, a := range f.Aliases {
+		r := a.Rule()
+		if !validName.MatchString(r.Name) {
+			add("alias %d: invalid name %q: must be lowercase letters, numbers, and dashes", i, r.Name)
+		}
+		if _, ok := seen[r.Name]; ok {
+			add("alias %q: duplicate name; set a name that's unique among stores, rules, and aliases", r.Name)
+		}
+		seen[r.Name] = struct{}{}
+		if len(a.Nodes) == 0 && a.Selector == "" {
+			add("alias %q: at least one of nodes or selector must be set", r.Name)
+		}
+		if err := r.Validate(); err != nil {
+			add("alias %q: %w", r.Name, err)
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// Parse parses and validates a configuration file's contents, in YAML or JSON.
+func Parse(data []byte) (*File, error) {
+	f := new(File)
+	if err := yaml.UnmarshalStrict(data, f); err != nil {
+		return nil, fmt.Errorf("unmarshal: %w", err)
+	}
+	if err := f.Validate(); err != nil {
+		return nil, fmt.Errorf("validate: %w", err)
+	}
+	return f, nil
+}
+
+// Load reads, parses, and validates the configuration file at path.
+func Load(path string) (*File, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	f, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// LoadAliases reads and parses a file that contains only aliases, like:
+//
+//	aliases:
+//	  - record: build
+//	    nodes: [builder-1, builder-2]
+//
+// The aliases are validated when they're added to a File.
+func LoadAliases(path string) ([]Alias, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read aliases: %w", err)
+	}
+	var f struct {
+		Aliases []Alias `json:"aliases"`
+	}
+	if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return nil, fmt.Errorf("parse aliases %s: unmarshal: %w", path, err)
+	}
+	return f.Aliases, nil
+}
@@ -0,0 +1,237 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParse(t *testing.T) {
+	testData := []struct {
+		name    string
+		input   string
+		want    *File
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			input: "",
+			want:  &File{},
+		},
+		{
+			name: "stores",
+			input: `
+stores:
+  - name: ingress
+    selector: node-role.kubernetes.io/ingress
+    zone: example.com
+    ttl: 30s
+    external: ingress
+  - name: gpu
+    selector: accelerator in (nvidia, amd)
+    internal: gpu.internal
+  - name: edge
+    selector: pool=edge
+    family: ipv6
+    provider: cloudflare
+    zone: example.net
+    external: edge
+`,
+			want: &File{Stores: []Store{
+				{Name: "ingress", Selector: "node-role.kubernetes.io/ingress", Zone: "example.com", TTL: metav1.Duration{Duration: 30 * time.Second}, External: "ingress"},
+				{Name: "gpu", Selector: "accelerator in (nvidia, amd)", Internal: "gpu.internal"},
+				{Name: "edge", Selector: "pool=edge", Family: "ipv6", Provider: "cloudflare", Zone: "example.net", External: "edge"},
+			}},
+		},
+		{
+			name: "rules",
+			input: `
+rules:
+  - name: workers-v4
+    selector: role=worker
+    class: external
+    family: ipv4
+    provider: digitalocean
+    zone: example.com
+    ttl: 30s
+    record: workers
+  - name: overlay
+    class: overlay
+    record: overlay.internal
+`,
+			want: &File{Rules: []Rule{
+				{Name: "workers-v4", Selector: "role=worker", Class: "external", Family: "ipv4", Provider: "digitalocean", Zone: "example.com", TTL: metav1.Duration{Duration: 30 * time.Second}, Record: "workers"},
+				{Name: "overlay", Class: "overlay", Record: "overlay.internal"},
+			}},
+		},
+		{
+			name: "aliases",
+			input: `
+aliases:
+  - record: build
+    nodes: [builder-1, builder-2]
+  - name: gpu
+    record: gpu.internal
+    selector: pool=gpu
+    class: internal
+`,
+			want: &File{Aliases: []Alias{
+				{Record: "build", Nodes: []string{"builder-1", "builder-2"}},
+				{Name: "gpu", Record: "gpu.internal", Selector: "pool=gpu", Class: "internal"},
+			}},
+		},
+		{
+			name:    "alias without nodes",
+			input:   "aliases: [{record: build}]",
+			wantErr: true,
+		},
+		{
+			name:    "alias named like a rule",
+			input:   "rules: [{name: alias-build, class: internal, record: a}]\naliases: [{record: build, nodes: [a]}]",
+			wantErr: true,
+		},
+		{
+			name:    "rule without record",
+			input:   "rules: [{name: a, class: internal}]",
+			wantErr: true,
+		},
+		{
+			name:    "rule with bad class",
+			input:   "rules: [{name: a, class: public, record: a}]",
+			wantErr: true,
+		},
+		{
+			name:    "rule with bad family",
+			input:   "rules: [{name: a, class: internal, family: ipx, record: a}]",
+			wantErr: true,
+		},
+		{
+			name:    "rule with unknown provider",
+			input:   "rules: [{name: a, class: internal, provider: route53, record: a}]",
+			wantErr: true,
+		},
+		{
+			name:    "store with unknown provider",
+			input:   "stores: [{name: a, provider: route53, internal: a}]",
+			wantErr: true,
+		},
+		{
+			name:    "rule named like a store",
+			input:   "stores: [{name: a, internal: a}]\nrules: [{name: a, class: internal, record: b}]",
+			wantErr: true,
+		},
+		{
+			name:    "unknown field",
+			input:   "stores: [{name: a, internal: a, extrenal: b}]",
+			wantErr: true,
+		},
+		{
+			name:    "no records",
+			input:   "stores: [{name: a}]",
+			wantErr: true,
+		},
+		{
+			name:    "bad name",
+			input:   "stores: [{name: Ingress Nodes, internal: a}]",
+			wantErr: true,
+		},
+		{
+			name:    "reserved name",
+			input:   "stores: [{name: main, internal: a}]",
+			wantErr: true,
+		},
+		{
+			name:    "duplicate name",
+			input:   "stores: [{name: a, internal: a}, {name: a, internal: b}]",
+			wantErr: true,
+		},
+		{
+			name:    "bad selector",
+			input:   "stores: [{name: a, internal: a, selector: 'foo in bar'}]",
+			wantErr: true,
+		},
+	}
+	for _, test := range testData {
+		got, err := Parse([]byte(test.input))
+		if gotErr := err != nil; gotErr != test.wantErr {
+			t.Errorf("%s: error:\n  got: %v\n want error: %v", test.name, err, test.wantErr)
+		}
+		if diff := cmp.Diff(got, test.want); diff != "" {
+			t.Errorf("%s: diff:\n%s", test.name, diff)
+		}
+	}
+}
+
+func TestStoreRules(t *testing.T) {
+	s := Store{Name: "ingress", Selector: "role=ingress", Family: FamilyIPv4, Provider: "etcd", Zone: "example.com", Internal: "ingress.internal", Overlay: "ingress.overlay"}
+	want := []Rule{
+		{Name: "ingress", Selector: "role=ingress", Class: ClassInternal, Family: FamilyIPv4, Provider: "etcd", Zone: "example.com", Record: "ingress.internal"},
+		{Name: "ingress", Selector: "role=ingress", Class: ClassOverlay, Family: FamilyIPv4, Provider: "etcd", Zone: "example.com", Record: "ingress.overlay"},
+	}
+	if diff := cmp.Diff(s.Rules(), want); diff != "" {
+		t.Errorf("rules:\n%s", diff)
+	}
+}
+
+func TestAliasRule(t *testing.T) {
+	testData := []struct {
+		alias Alias
+		want  Rule
+	}{
+		{
+			alias: Alias{Record: "Build.Example.com.", Nodes: []string{"builder-1"}},
+			want:  Rule{Name: "alias-build-example-com", Class: ClassExternal, Record: "Build.Example.com.", Nodes: []string{"builder-1"}},
+		},
+		{
+			alias: Alias{Name: "gpu", Record: "gpu", Selector: "pool=gpu", Class: ClassInternal, Zone: "example.com"},
+			want:  Rule{Name: "gpu", Selector: "pool=gpu", Class: ClassInternal, Zone: "example.com", Record: "gpu"},
+		},
+	}
+	for _, test := range testData {
+		if diff := cmp.Diff(test.alias.Rule(), test.want); diff != "" {
+			t.Errorf("%s: rule:\n%s", test.alias.Record, diff)
+		}
+	}
+}
+
+func TestLabelAliases(t *testing.T) {
+	got := LabelAliases(map[string]string{"pool=storage": "storage.example.com", "pool=ingress": "ingress.example.com"}, ClassInternal)
+	want := []Alias{
+		{Name: "label-ingress-example-com", Record: "ingress.example.com", Selector: "pool=ingress", Class: ClassInternal},
+		{Name: "label-storage-example-com", Record: "storage.example.com", Selector: "pool=storage", Class: ClassInternal},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("aliases:\n%s", diff)
+	}
+	f := &File{Aliases: got}
+	if err := f.Validate(); err != nil {
+		t.Errorf("validate: %v", err)
+	}
+}
+
+func TestValidateProblems(t *testing.T) {
+	f := &File{
+		Stores: []Store{{Name: "a", Selector: "foo in bar"}},
+		Rules:  []Rule{{Name: "a", Class: ClassInternal, Record: "b"}},
+	}
+	err := f.Validate()
+	var problems Problems
+	if !errors.As(err, &problems) {
+		t.Fatalf("validate: not Problems:\n  got: %#v", err)
+	}
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.Error())
+	}
+	want := []string{
+		`store "a": selector: unable to parse requirement: found 'bar' expected: '('`,
+		`store "a": at least one of internal, external, or overlay must be set`,
+		`rule "a": duplicate name`,
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("problems:\n%s", diff)
+	}
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// ExpandRecord expands a record name that's a Go template, like
+// "nodes.{{.ClusterName}}.{{.Region}}.example.com", with vars, so that many clusters can be
+// deployed from the same manifest.  Names without "{{" are returned as they are.  If vars is nil,
+// the template is only parsed.
+func ExpandRecord(name string, vars map[string]interface{}) (string, error) {
+	if !strings.Contains(name, "{{") {
+		return name, nil
+	}
+	tmpl, err := template.New("record").Option("missingkey=error").Parse(name)
+	if err != nil {
+		return "", fmt.Errorf("parse record template %q: %w", name, err)
+	}
+	if vars == nil {
+		return name, nil
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return "", fmt.Errorf("expand record template %q: %w", name, err)
+	}
+	result := strings.TrimSpace(buf.String())
+	if result == "" {
+		return "", fmt.Errorf("record template %q produced an empty name", name)
+	}
+	return result, nil
+}
+
+// Expand expands the record names of every store, rule, and alias; see ExpandRecord.  Every
+// problem is reported, as Problems.
+func (f *File) Expand(vars map[string]interface{}) error {
+	var problems Problems
+	expand := func(what string, name *string) {
+		result, err := ExpandRecord(*name, vars)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", what, err))
+			return
+		}
+		*name = result
+	}
+	for i := range f.Stores {
+		s := &f.Stores[i]
+		expand(fmt.Sprintf("store %q: internal", s.Name), &s.Internal)
+		expand(fmt.Sprintf("store %q: external", s.Name), &s.External)
+		expand(fmt.Sprintf("store %q: overlay", s.Name), &s.Overlay)
+	}
+	for i := range f.Rules {
+		expand(fmt.Sprintf("rule %q: record", f.Rules[i].Name), &f.Rules[i].Record)
+	}
+	for i := range f.Aliases {
+		expand(fmt.Sprintf("alias %q: record", f.Aliases[i].Rule().Name), &f.Aliases[i].Record)
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
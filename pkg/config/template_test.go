@@ -0,0 +1,60 @@
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExpandRecord(t *testing.T) {
+	vars := map[string]interface{}{
+		"ClusterName": "prod",
+		"Region":      "nyc3",
+		"Labels":      map[string]string{"pool": "ingress"},
+	}
+	testData := []struct {
+		name    string
+		vars    map[string]interface{}
+		want    string
+		wantErr bool
+	}{
+		{name: "nodes.example.com", vars: vars, want: "nodes.example.com"},
+		{name: "nodes.{{.ClusterName}}.{{.Region}}.example.com", vars: vars, want: "nodes.prod.nyc3.example.com"},
+		{name: `{{index .Labels "pool"}}.example.com`, vars: vars, want: "ingress.example.com"},
+		{name: "nodes.{{.Zone}}.example.com", vars: vars, wantErr: true},
+		{name: "nodes.{{.Zone}}.example.com", want: "nodes.{{.Zone}}.example.com"},
+		{name: "nodes.{{.Zone.example.com", wantErr: true},
+		{name: `{{""}}`, vars: vars, wantErr: true},
+	}
+	for _, test := range testData {
+		got, err := ExpandRecord(test.name, test.vars)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: error:\n  got: %v\n want error: %v", test.name, err, test.wantErr)
+		}
+		if got != test.want {
+			t.Errorf("%q:\n  got: %q\n want: %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	f := &File{
+		Stores:  []Store{{Name: "ingress", External: "ingress.{{.ClusterName}}", Internal: "ingress.internal"}},
+		Rules:   []Rule{{Name: "gpu", Record: "gpu.{{.ClusterName}}"}},
+		Aliases: []Alias{{Name: "build", Record: "build.{{.Nope}}"}},
+	}
+	err := f.Expand(map[string]interface{}{"ClusterName": "prod"})
+	var problems Problems
+	if !errors.As(err, &problems) || len(problems) != 1 {
+		t.Fatalf("problems:\n  got: %v\n want: one", err)
+	}
+	want := &File{
+		Stores:  []Store{{Name: "ingress", External: "ingress.prod", Internal: "ingress.internal"}},
+		Rules:   []Rule{{Name: "gpu", Record: "gpu.prod"}},
+		Aliases: []Alias{{Name: "build", Record: "build.{{.Nope}}"}},
+	}
+	if diff := cmp.Diff(f, want); diff != "" {
+		t.Errorf("expanded:\n%s", diff)
+	}
+}
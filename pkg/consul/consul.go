@@ -0,0 +1,164 @@
+// Package consul is a minimal client for the parts of Consul's catalog API that nodedns uses, so
+// that nodedns doesn't need Consul's API module.
+package consul
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"github.com/jrockway/nodedns/pkg/tracing"
+)
+
+// Config configures a connection to Consul.
+type Config struct {
+	Address    string `long:"consul_address" env:"CONSUL_HTTP_ADDR" description:"with --dns_provider=consul, the url of a consul agent or server" default:"http://127.0.0.1:8500"`
+	Token      string `long:"consul_token" env:"CONSUL_HTTP_TOKEN" description:"an acl token that may register services in the catalog, if consul has acls enabled"`
+	Datacenter string `long:"consul_datacenter" env:"CONSUL_DATACENTER" description:"the datacenter to register services in; the datacenter of --consul_address, if empty"`
+	CA         string `long:"consul_ca" env:"CONSUL_CACERT" description:"a file containing the ca certificate that consul's certificate is signed by; the system roots are used if empty"`
+}
+
+// Client is a Consul client.
+type Client struct {
+	cfg  Config
+	http *http.Client
+}
+
+// NewClient returns a Client for the configured server.
+func NewClient(cfg Config) (*Client, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("no address")
+	}
+	cfg.Address = strings.TrimSuffix(cfg.Address, "/")
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.CA != "" {
+		pem, err := ioutil.ReadFile(cfg.CA)
+		if err != nil {
+			return nil, fmt.Errorf("read ca: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", cfg.CA)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+	return &Client{cfg: cfg, http: &http.Client{Transport: tracing.WrapRoundTripper(transport)}}, nil
+}
+
+// APIError is an error returned by Consul.
+type APIError struct {
+	Status  int    // The HTTP status.
+	Message string // The body of the response.
+}
+
+func (e *APIError) Error() string { return fmt.Sprintf("%d: %s", e.Status, e.Message) }
+
+// do sends a request to an API path, like "/v1/catalog/register", and decodes the response into
+// out, if it's non-nil.
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		var buf bytes.Buffer
+		if err := json.NewEncoder(&buf).Encode(in); err != nil {
+			return fmt.Errorf("marshal request: %w", err)
+		}
+		body = &buf
+	}
+	u := c.cfg.Address + path
+	if c.cfg.Datacenter != "" {
+		u += "?dc=" + url.QueryEscape(c.cfg.Datacenter)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.cfg.Token != "" {
+		req.Header.Set("X-Consul-Token", c.cfg.Token)
+	}
+	correlation.SetHeader(req)
+	res, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("%s: %w", path, &APIError{Status: res.StatusCode, Message: strings.TrimSpace(string(msg))})
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s: decode response: %w", path, err)
+	}
+	return nil
+}
+
+// Leader returns the address of the cluster's leader, for checking that Consul can be reached.
+func (c *Client) Leader(ctx context.Context) (string, error) {
+	var leader string
+	if err := c.do(ctx, http.MethodGet, "/v1/status/leader", nil, &leader); err != nil {
+		return "", err
+	}
+	return leader, nil
+}
+
+// CatalogService is an instance of a service in the catalog.
+type CatalogService struct {
+	Node           string
+	ServiceID      string
+	ServiceName    string
+	ServiceAddress string
+	ServiceTags    []string
+}
+
+// Service returns every instance of the named service.
+func (c *Client) Service(ctx context.Context, name string) ([]CatalogService, error) {
+	var result []CatalogService
+	if err := c.do(ctx, http.MethodGet, "/v1/catalog/service/"+url.PathEscape(name), nil, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AgentService is a service to register.
+type AgentService struct {
+	ID      string
+	Service string
+	Address string
+	Tags    []string `json:",omitempty"`
+}
+
+// Register registers a service instance on an external node: a node in the catalog that no agent
+// runs on, and whose services aren't health checked.  The node is created if it doesn't exist.
+func (c *Client) Register(ctx context.Context, node string, service AgentService) error {
+	r := map[string]interface{}{
+		"Datacenter": c.cfg.Datacenter,
+		"Node":       node,
+		// The node's own address is never used, since every service has its own.
+		"Address": "127.0.0.1",
+		// Tell consul-esm not to health check the node.
+		"NodeMeta":       map[string]string{"external-node": "true", "external-probe": "false"},
+		"Service":        service,
+		"SkipNodeUpdate": true,
+	}
+	return c.do(ctx, http.MethodPut, "/v1/catalog/register", r, nil)
+}
+
+// Deregister removes a service instance from a node.
+func (c *Client) Deregister(ctx context.Context, node, serviceID string) error {
+	return c.do(ctx, http.MethodPut, "/v1/catalog/deregister", map[string]string{"Datacenter": c.cfg.Datacenter, "Node": node, "ServiceID": serviceID}, nil)
+}
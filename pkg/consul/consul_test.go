@@ -0,0 +1,55 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestClient(t *testing.T) {
+	var gotToken, gotDC string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gotToken, gotDC = req.Header.Get("X-Consul-Token"), req.URL.Query().Get("dc")
+		switch req.URL.Path {
+		case "/v1/catalog/service/nodes":
+			w.Write([]byte(`[{"Node":"nodedns","ServiceID":"a","ServiceName":"nodes","ServiceAddress":"10.0.0.1","ServiceTags":["nodedns"]}]`)) // nolint:errcheck
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Permission denied\n")) // nolint:errcheck
+		}
+	}))
+	defer s.Close()
+	ctx := context.Background()
+
+	c, err := NewClient(Config{Address: s.URL + "/", Token: "secret", Datacenter: "west"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Service(ctx, "nodes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []CatalogService{{Node: "nodedns", ServiceID: "a", ServiceName: "nodes", ServiceAddress: "10.0.0.1", ServiceTags: []string{"nodedns"}}}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("service:\n%s", diff)
+	}
+	if got, want := gotToken, "secret"; got != want {
+		t.Errorf("token:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := gotDC, "west"; got != want {
+		t.Errorf("datacenter:\n  got: %v\n want: %v", got, want)
+	}
+
+	err = c.Register(ctx, "nodedns", AgentService{ID: "b", Service: "nodes", Address: "10.0.0.2"})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("register: expected an APIError, got %v", err)
+	}
+	if got, want := apiErr.Error(), "403: Permission denied"; got != want {
+		t.Errorf("error:\n  got: %v\n want: %v", got, want)
+	}
+}
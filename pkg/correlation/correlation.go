@@ -0,0 +1,56 @@
+// Package correlation identifies each change to the nodes, so that the DNS updates that it causes
+// can be followed from the node event through logs, traces, and the providers' API requests (and,
+// where a provider records them, its audit logs).
+package correlation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// Header is the request header that carries the correlation ID to providers that accept it.
+const Header = "X-Correlation-ID"
+
+type key struct{}
+
+// New returns a random correlation ID.
+func New() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// crypto/rand never fails on supported platforms.
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// With returns a context that carries the correlation ID.
+func With(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, key{}, id)
+}
+
+// ID returns the context's correlation ID, or "" if it has none.
+func ID(ctx context.Context) string {
+	id, _ := ctx.Value(key{}).(string)
+	return id
+}
+
+// Field returns a log field containing the context's correlation ID, or a field that isn't logged
+// if it has none.
+func Field(ctx context.Context) zap.Field {
+	id := ID(ctx)
+	if id == "" {
+		return zap.Skip()
+	}
+	return zap.String("correlation_id", id)
+}
+
+// SetHeader adds the correlation ID of the request's context, if any, to the request.
+func SetHeader(req *http.Request) {
+	if id := ID(req.Context()); id != "" {
+		req.Header.Set(Header, id)
+	}
+}
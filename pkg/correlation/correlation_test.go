@@ -0,0 +1,43 @@
+package correlation
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestCorrelation(t *testing.T) {
+	a, b := New(), New()
+	if a == b {
+		t.Errorf("ids not unique:\n  got: %v and %v", a, b)
+	}
+
+	ctx := With(context.Background(), a)
+	if got, want := ID(ctx), a; got != want {
+		t.Errorf("id:\n  got: %v\n want: %v", got, want)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetHeader(req)
+	if got, want := req.Header.Get(Header), a; got != want {
+		t.Errorf("header:\n  got: %v\n want: %v", got, want)
+	}
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	l := zap.New(core)
+	l.Info("with id", Field(ctx))
+	l.Info("without id", Field(context.Background()))
+	var got []map[string]interface{}
+	for _, entry := range logs.All() {
+		got = append(got, entry.ContextMap())
+	}
+	if len(got) != 2 || got[0]["correlation_id"] != a || len(got[1]) != 0 {
+		t.Errorf("log fields:\n  got: %v\n want: [map[correlation_id:%s] map[]]", got, a)
+	}
+}
@@ -0,0 +1,188 @@
+// Package digitalocean integrates nodedns with DigitalOcean APIs other than DNS.
+package digitalocean
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/digitalocean/godo"
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"github.com/jrockway/nodedns/pkg/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+var (
+	doRequestsRemaining = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "digitalocean_requests_remaining",
+			Help: "The number of API requests remaining on the DigitalOcean client.",
+		},
+	)
+	unverifiedAddresses = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "digitalocean_unverified_addresses",
+			Help: "The number of addresses in the most recently verified record that do not belong to any matching droplet.",
+		},
+	)
+)
+
+// transport is an http.RoundTripper that adds a DO token to each request, rotating between the
+// available tokens round-robin, and the request's correlation ID, if it has one.
+type transport struct {
+	Tokens     []oauth2.TokenSource
+	next       uint64
+	underlying http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	i := (atomic.AddUint64(&t.next, 1) - 1) % uint64(len(t.Tokens))
+	token, err := t.Tokens[i].Token()
+	if err != nil {
+		return nil, fmt.Errorf("get token: %w", err)
+	}
+	token.SetAuthHeader(req)
+	correlation.SetHeader(req)
+	return t.underlying.RoundTrip(req)
+}
+
+// NewGodoClient returns a godo client that authenticates with the provided personal access token,
+// traces and logs requests, and exports the number of API requests remaining as a metric.
+func NewGodoClient(token string) *godo.Client {
+	return NewGodoClientWithTransport(token, nil)
+}
+
+// NewGodoClientWithTransport is like NewGodoClient, but sends requests with the provided
+// RoundTripper (after tracing and logging them).  A nil RoundTripper uses the default transport.
+func NewGodoClientWithTransport(token string, rt http.RoundTripper) *godo.Client {
+	return NewGodoClientWithTokens([]string{token}, rt)
+}
+
+// NewGodoClientWithTokens is like NewGodoClientWithTransport, but authenticates each request with
+// the next of the provided tokens, round-robin, so that their rate limits are shared.
+func NewGodoClientWithTokens(tokens []string, rt http.RoundTripper) *godo.Client {
+	var sources []oauth2.TokenSource
+	for _, token := range tokens {
+		sources = append(sources, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
+	}
+	return NewGodoClientWithTokenSources(sources, rt)
+}
+
+// NewGodoClientWithTokenSources is like NewGodoClientWithTokens, but gets each token from its
+// source with every request, so that tokens can change (see TokenFile).
+func NewGodoClientWithTokenSources(sources []oauth2.TokenSource, rt http.RoundTripper) *godo.Client {
+	t := &transport{Tokens: sources, underlying: tracing.WrapRoundTripper(rt)}
+	if len(t.Tokens) == 0 {
+		t.Tokens = []oauth2.TokenSource{oauth2.StaticTokenSource(&oauth2.Token{})}
+	}
+	httpClient := &http.Client{Transport: t}
+	godoClient := godo.NewClient(httpClient)
+	godoClient.OnRequestCompleted(func(req *http.Request, res *http.Response) {
+		if res == nil {
+			return
+		}
+		if remaining := res.Header.Get("RateLimit-Remaining"); remaining != "" {
+			val, err := strconv.Atoi(remaining)
+			if err == nil {
+				doRequestsRemaining.Set(float64(val))
+			}
+		}
+	})
+	return godoClient
+}
+
+// listDroplets returns every droplet in the account, or every droplet with the tag if tag is
+// non-empty.
+func listDroplets(ctx context.Context, c *godo.Client, tag string) ([]godo.Droplet, error) {
+	var result []godo.Droplet
+	for page := 1; page <= 100; page++ {
+		opts := &godo.ListOptions{Page: page, PerPage: 200}
+		var droplets []godo.Droplet
+		var res *godo.Response
+		var err error
+		if tag == "" {
+			droplets, res, err = c.Droplets.List(ctx, opts)
+		} else {
+			droplets, res, err = c.Droplets.ListByTag(ctx, tag, opts)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("list page %d of droplets: %w", page, err)
+		}
+		result = append(result, droplets...)
+		if res.Links == nil || res.Links.IsLastPage() {
+			return result, nil
+		}
+	}
+	return result, errors.New("more than 100 pages!")
+}
+
+// publicAddresses returns the public addresses of a droplet.
+func publicAddresses(d *godo.Droplet) []net.IP {
+	var result []net.IP
+	if d.Networks == nil {
+		return nil
+	}
+	for _, n := range d.Networks.V4 {
+		if n.Type == "public" {
+			if ip := net.ParseIP(n.IPAddress); ip != nil {
+				result = append(result, ip)
+			}
+		}
+	}
+	for _, n := range d.Networks.V6 {
+		if n.Type == "public" {
+			if ip := net.ParseIP(n.IPAddress); ip != nil {
+				result = append(result, ip)
+			}
+		}
+	}
+	return result
+}
+
+// Verifier checks that addresses belong to droplets in the account, as a guard against stale or
+// spoofed node status ending up in public DNS.
+type Verifier struct {
+	c   *godo.Client
+	Tag string // If set, addresses must belong to a droplet with this tag.
+}
+
+// NewVerifier returns a Verifier.
+func NewVerifier(c *godo.Client, tag string) *Verifier {
+	return &Verifier{c: c, Tag: tag}
+}
+
+// Verify returns the provided addresses that are not a public address of any matching droplet.
+func (v *Verifier) Verify(ctx context.Context, ips []net.IP) ([]net.IP, error) {
+	ctx, span := tracing.Start(ctx, "digitalocean_verify_droplets")
+	defer span.End()
+
+	droplets, err := listDroplets(ctx, v.c, v.Tag)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]struct{})
+	for i := range droplets {
+		for _, ip := range publicAddresses(&droplets[i]) {
+			known[ip.String()] = struct{}{}
+		}
+	}
+	var unknown []net.IP
+	for _, ip := range ips {
+		if _, ok := known[ip.String()]; !ok {
+			unknown = append(unknown, ip)
+		}
+	}
+	unverifiedAddresses.Set(float64(len(unknown)))
+	if len(unknown) > 0 {
+		zap.L().Named("digitalocean").Warn("addresses do not belong to any droplet", zap.String("tag", v.Tag), zap.Any("addresses", unknown))
+	}
+	return unknown, nil
+}
@@ -0,0 +1,177 @@
+package digitalocean
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// fakeDroplets serves the droplet list endpoint.
+func fakeDroplets(t *testing.T, droplets []godo.Droplet) *godo.Client {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v2/droplets" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		var result []godo.Droplet
+		tag := req.URL.Query().Get("tag_name")
+		for _, d := range droplets {
+			if tag == "" {
+				result = append(result, d)
+				continue
+			}
+			for _, dt := range d.Tags {
+				if dt == tag {
+					result = append(result, d)
+				}
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{ // nolint:errcheck
+			"droplets": result,
+			"links":    godo.Links{},
+			"meta":     godo.Meta{Total: len(result)},
+		})
+	}))
+	t.Cleanup(s.Close)
+	c := godo.NewClient(s.Client())
+	u, err := url.Parse(s.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BaseURL = u
+	return c
+}
+
+func droplet(id int, tag, public, private string) godo.Droplet {
+	return godo.Droplet{
+		ID:     id,
+		Name:   fmt.Sprintf("droplet-%d", id),
+		Status: "active",
+		Tags:   []string{tag},
+		Networks: &godo.Networks{
+			V4: []godo.NetworkV4{
+				{IPAddress: public, Type: "public"},
+				{IPAddress: private, Type: "private"},
+			},
+		},
+	}
+}
+
+func TestVerify(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	c := fakeDroplets(t, []godo.Droplet{
+		droplet(1, "k8s", "42.0.0.1", "10.0.0.1"),
+		droplet(2, "other", "42.0.0.2", "10.0.0.2"),
+	})
+	ips := []net.IP{net.IPv4(42, 0, 0, 1), net.IPv4(42, 0, 0, 2), net.IPv4(10, 0, 0, 1)}
+
+	testData := []struct {
+		tag  string
+		want []net.IP
+	}{
+		{tag: "", want: []net.IP{net.IPv4(10, 0, 0, 1)}},
+		{tag: "k8s", want: []net.IP{net.IPv4(42, 0, 0, 2), net.IPv4(10, 0, 0, 1)}},
+	}
+	for _, test := range testData {
+		got, err := NewVerifier(c, test.tag).Verify(context.Background(), ips)
+		if err != nil {
+			t.Fatalf("tag %q: verify: %v", test.tag, err)
+		}
+		if diff := cmp.Diff(got, test.want); diff != "" {
+			t.Errorf("tag %q:\n%s", test.tag, diff)
+		}
+	}
+}
+
+func TestPollDroplets(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	off := droplet(2, "k8s", "42.0.0.2", "10.0.0.2")
+	off.Status = "off"
+	c := fakeDroplets(t, []godo.Droplet{droplet(1, "k8s", "42.0.0.1", "10.0.0.1"), off})
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	if err := pollDroplets(context.Background(), c, "k8s", store); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	var got []string
+	for _, obj := range store.List() {
+		n := obj.(*v1.Node)
+		for _, cond := range n.Status.Conditions {
+			if cond.Type == v1.NodeReady && cond.Status == v1.ConditionTrue {
+				for _, addr := range n.Status.Addresses {
+					got = append(got, string(addr.Type)+"="+addr.Address)
+				}
+			}
+		}
+	}
+	want := []string{"Hostname=droplet-1", "ExternalIP=42.0.0.1", "InternalIP=10.0.0.1"}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("ready node addresses:\n%s", diff)
+	}
+}
+
+func TestTokenRotation(t *testing.T) {
+	var got []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = append(got, req.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"domains":[],"links":{},"meta":{"total":0}}`)
+	}))
+	defer s.Close()
+	c := NewGodoClientWithTokens([]string{"a", "b"}, s.Client().Transport)
+	u, err := url.Parse(s.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BaseURL = u
+	for i := 0; i < 3; i++ {
+		if _, _, err := c.Domains.List(context.Background(), nil); err != nil {
+			t.Fatalf("list domains: %v", err)
+		}
+	}
+	want := []string{"Bearer a", "Bearer b", "Bearer a"}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("authorization headers:\n%s", diff)
+	}
+}
+
+func TestCorrelationHeader(t *testing.T) {
+	var got []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = append(got, req.Header.Get(correlation.Header))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"domains":[],"links":{},"meta":{"total":0}}`)
+	}))
+	defer s.Close()
+	c := NewGodoClientWithTransport("token", s.Client().Transport)
+	u, err := url.Parse(s.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.BaseURL = u
+	ctx := context.Background()
+	if _, _, err := c.Domains.List(correlation.With(ctx, "0123456789abcdef"), nil); err != nil {
+		t.Fatalf("list domains: %v", err)
+	}
+	if _, _, err := c.Domains.List(ctx, nil); err != nil {
+		t.Fatalf("list domains: %v", err)
+	}
+	want := []string{"0123456789abcdef", ""}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("correlation headers:\n%s", diff)
+	}
+}
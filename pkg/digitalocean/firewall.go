@@ -0,0 +1,78 @@
+package digitalocean
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/tracing"
+	"go.uber.org/zap"
+)
+
+// FirewallSync keeps the source addresses of every inbound rule of a Cloud Firewall in sync with a
+// set of addresses, so that only the nodes can reach whatever the firewall protects.  Other kinds
+// of sources (tags, droplets, load balancers) are left alone.
+type FirewallSync struct {
+	c  *godo.Client
+	ID string // The ID of the firewall.
+}
+
+// NewFirewallSync returns a FirewallSync for the firewall with the provided ID.
+func NewFirewallSync(c *godo.Client, id string) *FirewallSync {
+	return &FirewallSync{c: c, ID: id}
+}
+
+// Sync updates the firewall's inbound rules to allow exactly the provided addresses.  To avoid
+// locking everyone out of the protected resources, an empty set of addresses is an error.
+func (f *FirewallSync) Sync(ctx context.Context, ips []net.IP) error {
+	if len(ips) == 0 {
+		return errors.New("refusing to remove every source address from the firewall")
+	}
+	ctx, span := tracing.Start(ctx, "digitalocean_firewall_sync")
+	defer span.End()
+
+	fw, _, err := f.c.Firewalls.Get(ctx, f.ID)
+	if err != nil {
+		return fmt.Errorf("get firewall %s: %w", f.ID, err)
+	}
+	want := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		want = append(want, ip.String())
+	}
+	sort.Strings(want)
+
+	var changed bool
+	rules := make([]godo.InboundRule, 0, len(fw.InboundRules))
+	for _, rule := range fw.InboundRules {
+		sources := new(godo.Sources)
+		if rule.Sources != nil {
+			*sources = *rule.Sources
+		}
+		have := append([]string{}, sources.Addresses...)
+		sort.Strings(have)
+		if !cmp.Equal(have, want) {
+			changed = true
+		}
+		sources.Addresses = want
+		rule.Sources = sources
+		rules = append(rules, rule)
+	}
+	if !changed {
+		return nil
+	}
+	zap.L().Named("digitalocean").Info("updating firewall source addresses", zap.String("firewall", fw.Name), zap.Strings("addresses", want))
+	if _, _, err := f.c.Firewalls.Update(ctx, f.ID, &godo.FirewallRequest{
+		Name:          fw.Name,
+		InboundRules:  rules,
+		OutboundRules: fw.OutboundRules,
+		DropletIDs:    fw.DropletIDs,
+		Tags:          fw.Tags,
+	}); err != nil {
+		return fmt.Errorf("update firewall %s: %w", f.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package digitalocean
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestFirewallSync(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	fw := &godo.Firewall{
+		ID:   "fw",
+		Name: "nodes-only",
+		InboundRules: []godo.InboundRule{
+			{Protocol: "tcp", PortRange: "5432", Sources: &godo.Sources{Addresses: []string{"42.0.0.1"}, Tags: []string{"bastion"}}},
+			{Protocol: "tcp", PortRange: "6379"},
+		},
+		Tags: []string{"database"},
+	}
+	var updates int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v2/firewalls/fw" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if req.Method == http.MethodPut {
+			updates++
+			var fr godo.FirewallRequest
+			if err := json.NewDecoder(req.Body).Decode(&fr); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			fw.InboundRules, fw.OutboundRules, fw.Tags, fw.DropletIDs = fr.InboundRules, fr.OutboundRules, fr.Tags, fr.DropletIDs
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"firewall": fw}) // nolint:errcheck
+	}))
+	defer s.Close()
+	c := godo.NewClient(s.Client())
+	c.BaseURL, _ = url.Parse(s.URL + "/")
+
+	f := NewFirewallSync(c, "fw")
+	ctx := context.Background()
+	if err := f.Sync(ctx, []net.IP{net.IPv4(42, 0, 0, 2), net.IPv4(42, 0, 0, 1)}); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	want := []godo.InboundRule{
+		{Protocol: "tcp", PortRange: "5432", Sources: &godo.Sources{Addresses: []string{"42.0.0.1", "42.0.0.2"}, Tags: []string{"bastion"}}},
+		{Protocol: "tcp", PortRange: "6379", Sources: &godo.Sources{Addresses: []string{"42.0.0.1", "42.0.0.2"}}},
+	}
+	if diff := cmp.Diff(fw.InboundRules, want); diff != "" {
+		t.Errorf("inbound rules:\n%s", diff)
+	}
+	if diff := cmp.Diff(fw.Tags, []string{"database"}); diff != "" {
+		t.Errorf("tags:\n%s", diff)
+	}
+
+	if err := f.Sync(ctx, []net.IP{net.IPv4(42, 0, 0, 1), net.IPv4(42, 0, 0, 2)}); err != nil {
+		t.Fatalf("sync again: %v", err)
+	}
+	if got, want := updates, 1; got != want {
+		t.Errorf("updates: got %d, want %d", got, want)
+	}
+
+	if err := f.Sync(ctx, nil); err == nil {
+		t.Error("sync with no addresses: expected error")
+	}
+}
@@ -0,0 +1,97 @@
+package digitalocean
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/digitalocean/godo"
+	"github.com/jrockway/nodedns/pkg/tracing"
+	"go.uber.org/zap"
+)
+
+// DropletID extracts the droplet ID from a Kubernetes node's provider ID, like
+// "digitalocean://1234".
+func DropletID(providerID string) (int, error) {
+	const prefix = "digitalocean://"
+	if !strings.HasPrefix(providerID, prefix) {
+		return 0, fmt.Errorf("provider id %q is not a digitalocean droplet", providerID)
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(providerID, prefix))
+	if err != nil {
+		return 0, fmt.Errorf("provider id %q: %w", providerID, err)
+	}
+	return id, nil
+}
+
+// LoadBalancerSync keeps a load balancer's droplet targets in sync with a set of droplets, so that
+// the load balancer and DNS agree on which nodes serve traffic.
+type LoadBalancerSync struct {
+	c  *godo.Client
+	ID string // The ID of the load balancer.
+}
+
+// NewLoadBalancerSync returns a LoadBalancerSync for the load balancer with the provided ID.
+func NewLoadBalancerSync(c *godo.Client, id string) *LoadBalancerSync {
+	return &LoadBalancerSync{c: c, ID: id}
+}
+
+// Sync adds and removes droplets from the load balancer so that it targets exactly the provided
+// droplet IDs.  An empty set of droplets is an error, as is a load balancer that selects its
+// targets by tag.
+func (l *LoadBalancerSync) Sync(ctx context.Context, dropletIDs []int) error {
+	if len(dropletIDs) == 0 {
+		return errors.New("refusing to remove every droplet from the load balancer")
+	}
+	ctx, span := tracing.Start(ctx, "digitalocean_load_balancer_sync")
+	defer span.End()
+
+	lb, _, err := l.c.LoadBalancers.Get(ctx, l.ID)
+	if err != nil {
+		return fmt.Errorf("get load balancer %s: %w", l.ID, err)
+	}
+	if lb.Tag != "" {
+		return fmt.Errorf("load balancer %s targets droplets by tag %q; not changing it", lb.Name, lb.Tag)
+	}
+
+	existing := make(map[int]struct{})
+	for _, id := range lb.DropletIDs {
+		existing[id] = struct{}{}
+	}
+	desired := make(map[int]struct{})
+	for _, id := range dropletIDs {
+		desired[id] = struct{}{}
+	}
+	var add, remove []int
+	for id := range desired {
+		if _, ok := existing[id]; !ok {
+			add = append(add, id)
+		}
+	}
+	for id := range existing {
+		if _, ok := desired[id]; !ok {
+			remove = append(remove, id)
+		}
+	}
+	sort.Ints(add)
+	sort.Ints(remove)
+
+	// Add before removing, so that the load balancer is never left with fewer targets than
+	// necessary.
+	if len(add) > 0 {
+		zap.L().Named("digitalocean").Info("adding droplets to load balancer", zap.String("load_balancer", lb.Name), zap.Ints("droplets", add))
+		if _, err := l.c.LoadBalancers.AddDroplets(ctx, l.ID, add...); err != nil {
+			return fmt.Errorf("add droplets to load balancer %s: %w", l.ID, err)
+		}
+	}
+	if len(remove) > 0 {
+		zap.L().Named("digitalocean").Info("removing droplets from load balancer", zap.String("load_balancer", lb.Name), zap.Ints("droplets", remove))
+		if _, err := l.c.LoadBalancers.RemoveDroplets(ctx, l.ID, remove...); err != nil {
+			return fmt.Errorf("remove droplets from load balancer %s: %w", l.ID, err)
+		}
+	}
+	return nil
+}
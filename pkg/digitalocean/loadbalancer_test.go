@@ -0,0 +1,83 @@
+package digitalocean
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"testing"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestDropletID(t *testing.T) {
+	if got, err := DropletID("digitalocean://1234"); err != nil || got != 1234 {
+		t.Errorf("valid id: got %d, %v", got, err)
+	}
+	for _, id := range []string{"", "aws:///us-east-1a/i-1234", "digitalocean://foo"} {
+		if _, err := DropletID(id); err == nil {
+			t.Errorf("%q: expected error", id)
+		}
+	}
+}
+
+func TestLoadBalancerSync(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	lb := &godo.LoadBalancer{ID: "lb", Name: "ingress", DropletIDs: []int{1, 2}}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.URL.Path == "/v2/load_balancers/lb" && req.Method == http.MethodGet:
+			json.NewEncoder(w).Encode(map[string]interface{}{"load_balancer": lb}) // nolint:errcheck
+		case req.URL.Path == "/v2/load_balancers/lb/droplets":
+			var body struct {
+				DropletIDs []int `json:"droplet_ids"`
+			}
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			ids := make(map[int]struct{})
+			for _, id := range lb.DropletIDs {
+				ids[id] = struct{}{}
+			}
+			for _, id := range body.DropletIDs {
+				if req.Method == http.MethodPost {
+					ids[id] = struct{}{}
+				} else {
+					delete(ids, id)
+				}
+			}
+			lb.DropletIDs = nil
+			for id := range ids {
+				lb.DropletIDs = append(lb.DropletIDs, id)
+			}
+			sort.Ints(lb.DropletIDs)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+	c := godo.NewClient(s.Client())
+	c.BaseURL, _ = url.Parse(s.URL + "/")
+
+	l := NewLoadBalancerSync(c, "lb")
+	if err := l.Sync(context.Background(), []int{2, 3}); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if diff := cmp.Diff(lb.DropletIDs, []int{2, 3}); diff != "" {
+		t.Errorf("droplets:\n%s", diff)
+	}
+	if err := l.Sync(context.Background(), nil); err == nil {
+		t.Error("sync with no droplets: expected error")
+	}
+	lb.Tag = "k8s"
+	if err := l.Sync(context.Background(), []int{2}); err == nil {
+		t.Error("sync with tag-based load balancer: expected error")
+	}
+}
@@ -0,0 +1,128 @@
+package digitalocean
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var (
+	doRequestRetries = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "digitalocean_request_retries",
+			Help: "The number of DigitalOcean API requests that were retried, by the status (or \"error\") of the failed attempt.",
+		},
+		[]string{"status"},
+	)
+)
+
+// RetryConfig configures retrying API requests that fail with a 429 or 5xx response, with
+// exponential backoff.  It mirrors the retry options of newer versions of godo, which implement
+// them by replacing the client's http.Client entirely, which would drop our tracing, chaos, and
+// budget transports.
+type RetryConfig struct {
+	RetryMax     int           `long:"do_retry_max" env:"DO_RETRY_MAX" description:"how many times to retry api requests that fail with a 429 or 5xx response; 0 disables retries" default:"4"`
+	RetryWaitMin time.Duration `long:"do_retry_wait_min" env:"DO_RETRY_WAIT_MIN" description:"how long to wait before the first retry; the wait doubles with each retry" default:"1s"`
+	RetryWaitMax time.Duration `long:"do_retry_wait_max" env:"DO_RETRY_WAIT_MAX" description:"the longest to wait between retries" default:"30s"`
+}
+
+// Wrap returns rt wrapped with retries, or rt itself if retries are disabled.  A nil rt uses the
+// default transport.
+func (c *RetryConfig) Wrap(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	if c.RetryMax <= 0 {
+		return rt
+	}
+	return &retryTransport{config: *c, underlying: rt}
+}
+
+type retryTransport struct {
+	config     RetryConfig
+	underlying http.RoundTripper
+}
+
+// idempotent returns true if a request with this method can be safely repeated even if we don't
+// know whether the first attempt reached the server.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// backoff returns how long to wait before retry number n (starting at 0), honoring the server's
+// requested wait if it sent one.
+func (t *retryTransport) backoff(n int, res *http.Response) time.Duration {
+	wait := t.config.RetryWaitMin << uint(n)
+	if res != nil && res.StatusCode == http.StatusTooManyRequests {
+		// DigitalOcean sends the time that the rate limit resets.
+		if reset, err := strconv.ParseInt(res.Header.Get("RateLimit-Reset"), 10, 64); err == nil {
+			if until := time.Until(time.Unix(reset, 0)); until > wait {
+				wait = until
+			}
+		}
+		if after, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+			if d := time.Duration(after) * time.Second; d > wait {
+				wait = d
+			}
+		}
+	}
+	if wait > t.config.RetryWaitMax || wait <= 0 {
+		wait = t.config.RetryWaitMax
+	}
+	return wait
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for n := 0; ; n++ {
+		if n > 0 && req.Body != nil {
+			if req.GetBody == nil {
+				return nil, fmt.Errorf("%s %s: cannot retry request with a body that can't be rewound", req.Method, req.URL)
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: rewind body: %w", req.Method, req.URL, err)
+			}
+			req.Body = body
+		}
+		res, err := t.underlying.RoundTrip(req)
+
+		var status string
+		switch {
+		case req.Context().Err() != nil:
+			return res, err
+		case err != nil && idempotent(req.Method):
+			status = "error"
+		case err != nil:
+			return res, err
+		case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+			status = strconv.Itoa(res.StatusCode)
+		default:
+			return res, nil
+		}
+		if n >= t.config.RetryMax {
+			return res, err
+		}
+
+		wait := t.backoff(n, res)
+		if res != nil {
+			res.Body.Close()
+		}
+		doRequestRetries.WithLabelValues(status).Inc()
+		zap.L().Debug("retrying digitalocean api request", zap.String("method", req.Method), zap.String("url", req.URL.String()), zap.String("status", status), zap.Error(err), zap.Int("attempt", n+1), zap.Duration("wait", wait))
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package digitalocean
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestRetry(t *testing.T) {
+	testData := []struct {
+		name         string
+		method       string
+		statuses     []int // The status of each attempt; 0 means a connection error.
+		wantStatus   int
+		wantErr      bool
+		wantRequests int
+	}{
+		{
+			name:         "success",
+			method:       "GET",
+			statuses:     []int{200},
+			wantStatus:   200,
+			wantRequests: 1,
+		},
+		{
+			name:         "not retried",
+			method:       "GET",
+			statuses:     []int{404, 200},
+			wantStatus:   404,
+			wantRequests: 1,
+		},
+		{
+			name:         "retried",
+			method:       "POST",
+			statuses:     []int{500, 429, 200},
+			wantStatus:   200,
+			wantRequests: 3,
+		},
+		{
+			name:         "gives up",
+			method:       "GET",
+			statuses:     []int{503, 503, 503, 503},
+			wantStatus:   503,
+			wantRequests: 3,
+		},
+		{
+			name:         "connection error",
+			method:       "DELETE",
+			statuses:     []int{0, 200},
+			wantStatus:   200,
+			wantRequests: 2,
+		},
+		{
+			name:         "connection error during post",
+			method:       "POST",
+			statuses:     []int{0, 200},
+			wantErr:      true,
+			wantRequests: 1,
+		},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			var requests int
+			var bodies []string
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				body, _ := ioutil.ReadAll(req.Body)
+				bodies = append(bodies, string(body))
+				w.WriteHeader(test.statuses[requests])
+				requests++
+			}))
+			defer s.Close()
+			rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				if test.statuses[requests] == 0 {
+					requests++
+					return nil, errors.New("connection reset")
+				}
+				return s.Client().Transport.RoundTrip(req)
+			})
+			c := &RetryConfig{RetryMax: 2, RetryWaitMin: time.Millisecond, RetryWaitMax: 2 * time.Millisecond}
+			client := &http.Client{Transport: c.Wrap(rt)}
+			req, err := http.NewRequest(test.method, s.URL, strings.NewReader("hello"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := client.Do(req)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("error:\n  got: %v\n want error: %v", err, test.wantErr)
+			}
+			if res != nil {
+				res.Body.Close()
+				if got, want := res.StatusCode, test.wantStatus; got != want {
+					t.Errorf("status:\n  got: %v\n want: %v", got, want)
+				}
+			}
+			if got, want := requests, test.wantRequests; got != want {
+				t.Errorf("requests:\n  got: %v\n want: %v", got, want)
+			}
+			for i, b := range bodies {
+				if b != "hello" {
+					t.Errorf("body of attempt %d:\n  got: %q\n want: %q", i, b, "hello")
+				}
+			}
+		})
+	}
+}
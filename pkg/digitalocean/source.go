@@ -0,0 +1,106 @@
+package digitalocean
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// dropletToNode converts a droplet to the equivalent Kubernetes node, so that droplets can be fed
+// into the same machinery that watches Kubernetes nodes.  Only active droplets are Ready.
+func dropletToNode(d *godo.Droplet) *v1.Node {
+	ready := v1.ConditionFalse
+	if d.Status == "active" {
+		ready = v1.ConditionTrue
+	}
+	n := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: d.Name,
+		},
+		Spec: v1.NodeSpec{
+			ProviderID: fmt.Sprintf("digitalocean://%d", d.ID),
+		},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: ready}},
+			Addresses:  []v1.NodeAddress{{Type: v1.NodeHostName, Address: d.Name}},
+		},
+	}
+	if d.Region != nil && d.Region.Slug != "" {
+		// Like DigitalOcean Kubernetes nodes, so that droplets can have per-region records.
+		n.Labels = map[string]string{"topology.kubernetes.io/region": d.Region.Slug}
+	}
+	if d.Networks == nil {
+		return n
+	}
+	for _, net := range d.Networks.V4 {
+		switch net.Type {
+		case "public":
+			n.Status.Addresses = append(n.Status.Addresses, v1.NodeAddress{Type: v1.NodeExternalIP, Address: net.IPAddress})
+		case "private":
+			n.Status.Addresses = append(n.Status.Addresses, v1.NodeAddress{Type: v1.NodeInternalIP, Address: net.IPAddress})
+		}
+	}
+	for _, net := range d.Networks.V6 {
+		if net.Type == "public" {
+			n.Status.Addresses = append(n.Status.Addresses, v1.NodeAddress{Type: v1.NodeExternalIP, Address: net.IPAddress})
+		}
+	}
+	return n
+}
+
+// pollDroplets lists the droplets and replaces the contents of the store with them.
+func pollDroplets(ctx context.Context, c *godo.Client, tag string, store cache.Store) error {
+	droplets, err := listDroplets(ctx, c, tag)
+	if err != nil {
+		return err
+	}
+	objs := make([]interface{}, 0, len(droplets))
+	for i := range droplets {
+		objs = append(objs, dropletToNode(&droplets[i]))
+	}
+	return store.Replace(objs, "")
+}
+
+// WatchDroplets polls the DigitalOcean API for droplets (with the tag, if tag is non-empty) every
+// interval until the context is finished, and publishes them to the provided cache.Store as if
+// they were Kubernetes nodes.  This allows nodedns to maintain records for fleets of droplets that
+// aren't Kubernetes nodes.
+//
+// The provided store will be resync'd at a scheduled interval regardless of any changes if resync
+// is non-zero.
+func WatchDroplets(ctx context.Context, c *godo.Client, tag string, interval, resync time.Duration, store cache.Store) error {
+	l := zap.L().Named("droplets")
+	poll := time.NewTicker(interval)
+	defer poll.Stop()
+	var resyncCh <-chan time.Time
+	if resync > 0 {
+		t := time.NewTicker(resync)
+		defer t.Stop()
+		resyncCh = t.C
+	}
+	for {
+		tctx, c2 := context.WithTimeout(ctx, interval)
+		if err := pollDroplets(tctx, c, tag, store); err != nil {
+			l.Warn("problem polling droplets", zap.String("tag", tag), zap.Error(err))
+		}
+		c2()
+		for wait := true; wait; {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-resyncCh:
+				if err := store.Resync(); err != nil {
+					l.Warn("problem resyncing", zap.Error(err))
+				}
+			case <-poll.C:
+				wait = false
+			}
+		}
+	}
+}
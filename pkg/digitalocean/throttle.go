@@ -0,0 +1,181 @@
+package digitalocean
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	doThrottled = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "digitalocean_throttled",
+			Help: "1 if non-urgent updates are being deferred until the DigitalOcean rate limit resets, 0 otherwise.",
+		},
+	)
+	doThrottleDelay = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "digitalocean_throttle_delay_seconds",
+			Help: "The total time that changes to DigitalOcean were delayed, to spread the remaining api requests out until the rate limit resets.",
+		},
+	)
+)
+
+// ThrottleConfig configures deferring non-urgent updates when few API requests remain.
+type ThrottleConfig struct {
+	Below   int           `long:"do_throttle_below" env:"DO_THROTTLE_BELOW" description:"when fewer than this many api requests remain, defer resyncs and retries until the rate limit resets; 0 disables throttling" default:"100"`
+	MaxWait time.Duration `long:"do_throttle_max_wait" env:"DO_THROTTLE_MAX_WAIT" description:"while throttled, delay each change (create, update, or delete) by up to this long, so that the remaining requests last until the rate limit resets; 0 never delays" default:"30s"`
+}
+
+// Throttle tracks the rate limit that DigitalOcean reports in its responses, and decides when
+// non-urgent work should wait for the limit to reset.
+type Throttle struct {
+	Below   int           // Throttle when fewer than this many requests remain.
+	MaxWait time.Duration // The longest to delay a change while throttled; see Delay.
+
+	mu         sync.Mutex
+	remaining  int
+	reset      time.Time
+	nextChange time.Time // When the next change may be sent, while throttled.
+	now        func() time.Time
+}
+
+// NewThrottle returns a Throttle configured by c.
+func NewThrottle(c *ThrottleConfig) *Throttle {
+	return &Throttle{Below: c.Below, MaxWait: c.MaxWait, remaining: -1, now: time.Now}
+}
+
+// Wrap returns rt wrapped so that the rate limit of each response is observed.  A nil rt uses the
+// default transport.
+func (t *Throttle) Wrap(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &throttleTransport{throttle: t, underlying: rt}
+}
+
+type throttleTransport struct {
+	throttle   *Throttle
+	underlying http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.  Changes are delayed while throttled; see Delay.
+func (t *throttleTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		if err := t.throttle.wait(req.Context()); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", req.Method, req.URL, err)
+		}
+	}
+	res, err := t.underlying.RoundTrip(req)
+	if res != nil {
+		t.throttle.Observe(res)
+	}
+	return res, err
+}
+
+// Observe records the rate limit reported in a response's RateLimit-Remaining and RateLimit-Reset
+// headers.
+func (t *Throttle) Observe(res *http.Response) {
+	remaining, err := strconv.Atoi(res.Header.Get("RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.ParseInt(res.Header.Get("RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.remaining, t.reset = remaining, time.Unix(reset, 0)
+	t.updateGauge()
+}
+
+// Until returns the time that the rate limit resets, if non-urgent work should wait until then.
+func (t *Throttle) Until() (time.Time, bool) {
+	if t == nil {
+		return time.Time{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.reset, t.updateGauge()
+}
+
+// Delay returns how long to wait before sending a change, and reserves the slot after the wait for
+// it.  While throttled, changes are spaced evenly so that the remaining requests last until the
+// rate limit resets, and once none remain, they wait for the reset; a burst of changes is spread
+// out instead of failing partway through.  No change is delayed by more than MaxWait.
+func (t *Throttle) Delay() time.Duration {
+	if t == nil || t.MaxWait <= 0 {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.updateGauge() {
+		return 0
+	}
+	now := t.now()
+	var d time.Duration
+	if t.remaining <= 0 {
+		d = t.reset.Sub(now)
+	} else {
+		if t.nextChange.Before(now) {
+			t.nextChange = now
+		}
+		d = t.nextChange.Sub(now)
+		t.nextChange = t.nextChange.Add(t.reset.Sub(now) / time.Duration(t.remaining))
+	}
+	if d > t.MaxWait {
+		d = t.MaxWait
+	}
+	return d
+}
+
+// wait waits for Delay, or until the context is done.
+func (t *Throttle) wait(ctx context.Context) error {
+	d := t.Delay()
+	if d <= 0 {
+		return nil
+	}
+	doThrottleDelay.Add(d.Seconds())
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("wait %v for the digitalocean rate limit: %w", d, ctx.Err())
+	}
+}
+
+// updateGauge updates the throttled gauge, and returns whether non-urgent work is throttled.  The
+// lock must be held.
+func (t *Throttle) updateGauge() bool {
+	throttled := t.Below > 0 && t.remaining >= 0 && t.remaining < t.Below && t.now().Before(t.reset)
+	if throttled {
+		doThrottled.Set(1)
+	} else {
+		doThrottled.Set(0)
+	}
+	return throttled
+}
+
+// ThrottledError is returned instead of doing non-urgent work while throttled.
+type ThrottledError struct {
+	Until time.Time // When the rate limit resets.
+}
+
+// Error implements error.
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("few digitalocean api requests remain; deferring until the rate limit resets at %s", e.Until.Format(time.RFC3339))
+}
+
+// RetryAfter returns how long to wait before retrying the deferred work.
+func (e *ThrottledError) RetryAfter() time.Duration {
+	return time.Until(e.Until)
+}
@@ -0,0 +1,108 @@
+package digitalocean
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	reset := now.Add(10 * time.Minute)
+	testData := []struct {
+		name      string
+		below     int
+		headers   map[string]string
+		wantUntil bool
+	}{
+		{
+			name:  "no rate limit seen",
+			below: 100,
+		},
+		{
+			name:    "plenty remaining",
+			below:   100,
+			headers: map[string]string{"RateLimit-Remaining": "4000", "RateLimit-Reset": strconv.FormatInt(reset.Unix(), 10)},
+		},
+		{
+			name:      "few remaining",
+			below:     100,
+			headers:   map[string]string{"RateLimit-Remaining": "99", "RateLimit-Reset": strconv.FormatInt(reset.Unix(), 10)},
+			wantUntil: true,
+		},
+		{
+			name:    "few remaining, but already reset",
+			below:   100,
+			headers: map[string]string{"RateLimit-Remaining": "99", "RateLimit-Reset": strconv.FormatInt(now.Add(-time.Second).Unix(), 10)},
+		},
+		{
+			name:    "disabled",
+			headers: map[string]string{"RateLimit-Remaining": "0", "RateLimit-Reset": strconv.FormatInt(reset.Unix(), 10)},
+		},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			th := NewThrottle(&ThrottleConfig{Below: test.below})
+			th.now = func() time.Time { return now }
+			rt := th.Wrap(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				res := &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Body: http.NoBody}
+				for k, v := range test.headers {
+					res.Header.Set(k, v)
+				}
+				return res, nil
+			}))
+			req, err := http.NewRequest("GET", "http://example.com", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := rt.RoundTrip(req); err != nil {
+				t.Fatalf("round trip: %v", err)
+			}
+			until, ok := th.Until()
+			if got, want := ok, test.wantUntil; got != want {
+				t.Errorf("throttled:\n  got: %v\n want: %v", got, want)
+			}
+			if ok && !until.Equal(reset) {
+				t.Errorf("until:\n  got: %v\n want: %v", until, reset)
+			}
+		})
+	}
+}
+
+func TestThrottleDelay(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	th := NewThrottle(&ThrottleConfig{Below: 100, MaxWait: time.Minute})
+	th.now = func() time.Time { return now }
+	observe := func(remaining int, reset time.Time) {
+		res := &http.Response{Header: make(http.Header)}
+		res.Header.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		res.Header.Set("RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		th.Observe(res)
+	}
+
+	observe(4000, now.Add(10*time.Minute))
+	if got, want := th.Delay(), time.Duration(0); got != want {
+		t.Errorf("delay with plenty remaining:\n  got: %v\n want: %v", got, want)
+	}
+	// 10 requests for 10 minutes; one a minute.
+	observe(10, now.Add(10*time.Minute))
+	for i, want := range []time.Duration{0, time.Minute, 2 * time.Minute, 3 * time.Minute} {
+		if want > th.MaxWait {
+			want = th.MaxWait
+		}
+		if got := th.Delay(); got != want {
+			t.Errorf("delay of change %d:\n  got: %v\n want: %v", i, got, want)
+		}
+	}
+	now = now.Add(5 * time.Minute)
+	observe(0, now.Add(30*time.Second))
+	if got, want := th.Delay(), 30*time.Second; got != want {
+		t.Errorf("delay with none remaining:\n  got: %v\n want: %v", got, want)
+	}
+
+	th.MaxWait = 0
+	if got, want := th.Delay(), time.Duration(0); got != want {
+		t.Errorf("delay with MaxWait disabled:\n  got: %v\n want: %v", got, want)
+	}
+}
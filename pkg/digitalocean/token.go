@@ -0,0 +1,74 @@
+package digitalocean
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/clock"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+// TokenFile is an oauth2.TokenSource that reads a personal access token from a file, like a
+// mounted Secret.  The file is read again when the token is used at least CheckInterval after it
+// was last read, so that a rotated token is picked up without a restart.
+type TokenFile struct {
+	Path          string
+	CheckInterval time.Duration
+	Clock         clock.Clock
+
+	mu    sync.Mutex
+	token *oauth2.Token // The token that was last read.
+	read  time.Time     // When the file was last read.
+}
+
+var _ oauth2.TokenSource = (*TokenFile)(nil)
+
+// NewTokenFile returns a TokenFile that reads the token at path, checking it for changes every
+// 10 seconds.
+func NewTokenFile(path string) *TokenFile {
+	return &TokenFile{Path: path, CheckInterval: 10 * time.Second, Clock: clock.Real{}}
+}
+
+// Token implements oauth2.TokenSource.  If the file can't be read again, the token that was last
+// read is used, so that a file that's briefly missing while it's being replaced doesn't break
+// requests.
+func (f *TokenFile) Token() (*oauth2.Token, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := f.Clock.Now()
+	if f.token != nil && now.Sub(f.read) < f.CheckInterval {
+		return f.token, nil
+	}
+	token, err := ReadTokenFile(f.Path)
+	if err != nil {
+		if f.token == nil {
+			return nil, err
+		}
+		zap.L().Warn("problem reading token file again; using the previous token", zap.String("path", f.Path), zap.Error(err))
+		f.read = now
+		return f.token, nil
+	}
+	if f.token != nil && f.token.AccessToken != token {
+		zap.L().Info("digitalocean token changed; using the new token", zap.String("path", f.Path))
+	}
+	f.token, f.read = &oauth2.Token{AccessToken: token}, now
+	return f.token, nil
+}
+
+// ReadTokenFile returns the personal access token in the file, without surrounding whitespace.
+func ReadTokenFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read token file: %w", err)
+	}
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return "", errors.New("read token file: file is empty")
+	}
+	return token, nil
+}
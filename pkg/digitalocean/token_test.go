@@ -0,0 +1,65 @@
+package digitalocean
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/clock"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestTokenFile(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	path := filepath.Join(t.TempDir(), "token")
+	c := clock.NewFake(time.Unix(0, 0))
+	f := NewTokenFile(path)
+	f.Clock = c
+
+	if _, err := f.Token(); err == nil {
+		t.Error("expected an error reading a missing file")
+	}
+	check := func(want string) {
+		t.Helper()
+		token, err := f.Token()
+		if err != nil {
+			t.Fatalf("token: %v", err)
+		}
+		if got := token.AccessToken; got != want {
+			t.Errorf("token:\n  got: %v\n want: %v", got, want)
+		}
+	}
+	write := func(token string) {
+		t.Helper()
+		if err := ioutil.WriteFile(path, []byte(token), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("first\n")
+	check("first")
+
+	// The file isn't read again until the check interval has passed.
+	write("second\n")
+	c.Advance(5 * time.Second)
+	check("first")
+	c.Advance(5 * time.Second)
+	check("second")
+
+	// While the file is missing, or empty, the previous token is used.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	c.Advance(10 * time.Second)
+	check("second")
+	write("")
+	c.Advance(10 * time.Second)
+	check("second")
+	write("third")
+	c.Advance(10 * time.Second)
+	check("third")
+}
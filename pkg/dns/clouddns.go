@@ -0,0 +1,236 @@
+//go:build !no_google
+// +build !no_google
+
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/clouddns"
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"github.com/jrockway/nodedns/pkg/tracing"
+	"go.uber.org/zap"
+)
+
+// CloudDNS is a Provider that publishes records in a Google Cloud DNS managed zone.
+type CloudDNS struct {
+	c           *clouddns.Client
+	managedZone string
+	opts        ProviderOptions
+}
+
+var (
+	_ Provider = (*CloudDNS)(nil)
+	_ Exporter = (*CloudDNS)(nil)
+)
+
+// Cloud DNS's API errors carry the HTTP status of the response.
+func init() {
+	statusChecks = append(statusChecks, func(err error) int {
+		var apiErr *clouddns.APIError
+		if errors.As(err, &apiErr) {
+			return apiErr.Code
+		}
+		return 0
+	})
+}
+
+// NewCloudDNS returns a CloudDNS Provider for the zone in opts.  managedZone is the name of the
+// managed zone that serves it, like "example-com"; if empty, it's looked up by the zone's name.
+func NewCloudDNS(ctx context.Context, c *clouddns.Client, managedZone string, opts ProviderOptions) (*CloudDNS, error) {
+	if managedZone == "" {
+		z, err := c.ManagedZoneForDNSName(ctx, opts.Zone)
+		if err != nil {
+			return nil, fmt.Errorf("clouddns: %w", err)
+		}
+		return &CloudDNS{c: c, managedZone: z.Name, opts: opts}, nil
+	}
+	z, err := c.ManagedZone(ctx, managedZone)
+	if err != nil {
+		return nil, fmt.Errorf("clouddns: %w", err)
+	}
+	if want := strings.TrimSuffix(opts.Zone, ".") + "."; !strings.EqualFold(z.DNSName, want) {
+		return nil, fmt.Errorf("clouddns: managed zone %q serves %q, not %q", managedZone, z.DNSName, want)
+	}
+	return &CloudDNS{c: c, managedZone: z.Name, opts: opts}, nil
+}
+
+// FQDN implements Provider.
+func (p *CloudDNS) FQDN(record string) string {
+	return fqdn(p.opts.Zone, record)
+}
+
+// UpdateDNS implements Provider.  Each record set that has to change is replaced as a whole, and
+// every replacement is sent in a single change, which Cloud DNS applies atomically.  If the record
+// sets changed since they were read, Cloud DNS rejects the whole change, and the update fails.
+func (p *CloudDNS) UpdateDNS(ctx context.Context, record string, addresses []net.IP) (err error) {
+	if record == "" {
+		return nil
+	}
+	ctx, span := tracing.Start(ctx, "clouddns_dns_update")
+	defer span.End()
+	zone, name := p.opts.Zone, p.FQDN(record)
+	dnsUpdateAttempts.WithLabelValues("clouddns", zone, record).Inc()
+	defer func(start time.Time) {
+		observeUpdate(ctx, "clouddns", zone, record, start, err)
+	}(time.Now())
+	l := zap.L().Named("clouddns").With(correlation.Field(ctx))
+
+	sets, err := p.c.ResourceRecordSets(ctx, p.managedZone, name)
+	if err != nil {
+		return fmt.Errorf("get existing records: %w", err)
+	}
+	existing := make(map[string]clouddns.ResourceRecordSet)
+	for _, s := range sets {
+		existing[s.Type] = s
+	}
+	desired := make(map[string]bool)
+	for _, ip := range addresses {
+		if manages(p.opts.Family, recordType(ip)) {
+			desired[ip.String()] = true
+		}
+	}
+	// published tracks what the record contains as changes are made, even if the change fails.
+	published := make(map[string]bool)
+	defer reportPublished("clouddns", zone, p.opts.Family, record, published)
+	var change clouddns.Change
+	var toCreate, toDelete, skipped, retimed []string
+	ttl := int(p.opts.TTL.Round(time.Second).Seconds())
+	for _, t := range []string{"A", "AAAA"} {
+		if !manages(p.opts.Family, t) {
+			continue
+		}
+		old, ok := existing[t]
+		have := make(map[string]bool)
+		for _, data := range old.RRDatas {
+			if ip := net.ParseIP(data); ip != nil {
+				have[ip.String()] = true
+				published[ip.String()] = true
+			}
+		}
+		var want []string
+		changed := false
+		for addr := range desired {
+			if recordType(net.ParseIP(addr)) == t {
+				want = append(want, addr)
+				if !have[addr] {
+					toCreate = append(toCreate, addr)
+					changed = true
+				}
+			}
+		}
+		for addr := range have {
+			if desired[addr] {
+				continue
+			}
+			if p.opts.CreateOnly {
+				skipped = append(skipped, addr)
+				want = append(want, addr)
+				continue
+			}
+			toDelete = append(toDelete, addr)
+			changed = true
+		}
+		if ok && len(want) > 0 && old.TTL != ttl {
+			// A set whose TTL was set by hand or by an earlier --ttl is replaced with the
+			// configured one.
+			retimed = append(retimed, t)
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		sort.Strings(want)
+		if ok {
+			change.Deletions = append(change.Deletions, old)
+		}
+		if len(want) > 0 {
+			change.Additions = append(change.Additions, clouddns.ResourceRecordSet{Name: name + ".", Type: t, TTL: ttl, RRDatas: want})
+		}
+	}
+	sort.Strings(toCreate)
+	sort.Strings(toDelete)
+	cname, hasCNAME := existing["CNAME"]
+
+	if hasCNAME {
+		dnsRecordConflict.WithLabelValues("clouddns", zone, record).Set(1)
+	} else {
+		dnsRecordConflict.WithLabelValues("clouddns", zone, record).Set(0)
+	}
+	if p.opts.Audit {
+		toDelete = append(toDelete, skipped...)
+		sort.Strings(toDelete)
+		dnsRecordDrift.WithLabelValues("clouddns", zone, record).Set(float64(len(toDelete) + len(toCreate)))
+		if len(toDelete) > 0 || len(toCreate) > 0 {
+			l.Info("dns record drifted; auditing, so not changing it", zap.String("record", name), zap.Strings("would_add", toCreate), zap.Strings("would_remove", toDelete))
+			if p.opts.OnDrift != nil {
+				p.opts.OnDrift(ctx, zone, name, toCreate, toDelete)
+			}
+		}
+		if len(retimed) > 0 {
+			l.Info("dns record ttl drifted; auditing, so not changing it", zap.String("record", name), zap.Strings("types", retimed), zap.Int("ttl", ttl))
+		}
+		return nil
+	}
+	if len(skipped) > 0 {
+		l.Info("create-only; not deleting records", zap.String("record", name), zap.Strings("would_delete", skipped))
+		dnsRecordsDeleteSkipped.WithLabelValues("clouddns", zone, record).Add(float64(len(skipped)))
+	}
+	if hasCNAME && len(toCreate) > 0 {
+		target := ""
+		if len(cname.RRDatas) > 0 {
+			target = strings.TrimSuffix(cname.RRDatas[0], ".")
+		}
+		return &ConflictError{Record: name, Target: target}
+	}
+	if len(change.Additions) == 0 && len(change.Deletions) == 0 {
+		dnsUpdatedOK.WithLabelValues("clouddns", zone, record).Inc()
+		return nil
+	}
+
+	result, err := p.c.CreateChange(ctx, p.managedZone, change)
+	if err != nil {
+		return fmt.Errorf("replacing record sets (adding %v, removing %v): %w", toCreate, toDelete, err)
+	}
+	for _, addr := range toCreate {
+		published[addr] = true
+		mutated(ctx, p.opts.OnMutation, Mutation{Action: "create", Provider: "clouddns", Zone: zone, Record: name, Address: addr, ID: result.ID})
+	}
+	for _, addr := range toDelete {
+		delete(published, addr)
+		mutated(ctx, p.opts.OnMutation, Mutation{Action: "delete", Provider: "clouddns", Zone: zone, Record: name, Address: addr, ID: result.ID})
+	}
+	dnsRecordsCreated.WithLabelValues("clouddns", zone, record).Add(float64(len(toCreate)))
+	dnsRecordsDeleted.WithLabelValues("clouddns", zone, record).Add(float64(len(toDelete)))
+	dnsRecordsTTLFixed.WithLabelValues("clouddns", zone, record).Add(float64(len(retimed)))
+	l.Info("dns record changed", zap.String("record", name), zap.Strings("added", toCreate), zap.Strings("removed", toDelete), zap.Strings("ttl_fixed", retimed), zap.Int("addresses", len(desired)), zap.String("change_id", result.ID))
+	dnsUpdatedOK.WithLabelValues("clouddns", zone, record).Inc()
+	return nil
+}
+
+// Export implements Exporter.
+func (p *CloudDNS) Export(ctx context.Context, names []string) (*Snapshot, error) {
+	snap := &Snapshot{Zone: p.opts.Zone, Taken: time.Now().UTC()}
+	for _, n := range names {
+		sets, err := p.c.ResourceRecordSets(ctx, p.managedZone, p.FQDN(n))
+		if err != nil {
+			return nil, fmt.Errorf("export %s: %w", n, err)
+		}
+		for _, s := range sets {
+			if s.Type != "A" && s.Type != "AAAA" {
+				continue
+			}
+			for _, data := range s.RRDatas {
+				snap.Records = append(snap.Records, SnapshotRecord{Name: RelativeName(p.opts.Zone, s.Name), Type: s.Type, TTL: s.TTL, Data: data})
+			}
+		}
+	}
+	snap.sort()
+	return snap, nil
+}
@@ -0,0 +1,160 @@
+//go:build !no_google
+// +build !no_google
+
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/clouddns"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	"golang.org/x/oauth2"
+)
+
+// fakeCloudDNS is a fake of the parts of the Cloud DNS API that CloudDNS uses, serving the managed
+// zone "example-com".
+type fakeCloudDNS struct {
+	sync.Mutex
+	sets    map[string]clouddns.ResourceRecordSet // By name and type.
+	changes int
+}
+
+func (f *fakeCloudDNS) add(s clouddns.ResourceRecordSet) {
+	f.sets[s.Name+" "+s.Type] = s
+}
+
+// contents returns each record set as "name type ttl rrdatas", sorted.
+func (f *fakeCloudDNS) contents() []string {
+	f.Lock()
+	defer f.Unlock()
+	var result []string
+	for _, s := range f.sets {
+		result = append(result, strings.Join([]string{s.Name, s.Type, strconv.Itoa(s.TTL), strings.Join(s.RRDatas, ",")}, " "))
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (f *fakeCloudDNS) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	reply := func(code int, v interface{}) {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(v) // nolint:errcheck
+	}
+	const prefix = "/projects/project/managedZones"
+	switch {
+	case req.URL.Path == prefix:
+		reply(http.StatusOK, map[string]interface{}{"managedZones": []clouddns.ManagedZone{{Name: "example-com", DNSName: "example.com."}}})
+	case req.URL.Path == prefix+"/example-com":
+		reply(http.StatusOK, clouddns.ManagedZone{Name: "example-com", DNSName: "example.com."})
+	case req.URL.Path == prefix+"/example-com/rrsets":
+		var result []clouddns.ResourceRecordSet
+		for _, s := range f.sets {
+			if s.Name == req.URL.Query().Get("name") {
+				result = append(result, s)
+			}
+		}
+		reply(http.StatusOK, map[string]interface{}{"rrsets": result})
+	case req.URL.Path == prefix+"/example-com/changes" && req.Method == http.MethodPost:
+		var change clouddns.Change
+		json.NewDecoder(req.Body).Decode(&change) // nolint:errcheck
+		for _, d := range change.Deletions {
+			if !cmp.Equal(f.sets[d.Name+" "+d.Type], d) {
+				reply(http.StatusPreconditionFailed, map[string]interface{}{"error": map[string]interface{}{"code": 412, "message": "conditionNotMet"}})
+				return
+			}
+		}
+		for _, d := range change.Deletions {
+			delete(f.sets, d.Name+" "+d.Type)
+		}
+		for _, a := range change.Additions {
+			f.add(a)
+		}
+		f.changes++
+		change.ID, change.Status = strconv.Itoa(f.changes), "pending"
+		reply(http.StatusOK, change)
+	default:
+		reply(http.StatusNotFound, map[string]interface{}{"error": map[string]interface{}{"code": 404, "message": "not found"}})
+	}
+}
+
+// newTestCloudDNS returns a fake Cloud DNS API serving example.com, with the records that
+// testProviderConformance expects, and a client of it.
+func newTestCloudDNS(t *testing.T) (*fakeCloudDNS, *clouddns.Client) {
+	f := &fakeCloudDNS{sets: make(map[string]clouddns.ResourceRecordSet)}
+	f.add(clouddns.ResourceRecordSet{Name: "nodes.example.com.", Type: "A", TTL: 300, RRDatas: []string{"10.0.0.1", "42.0.0.1"}})
+	f.add(clouddns.ResourceRecordSet{Name: "www.example.com.", Type: "CNAME", TTL: 300, RRDatas: []string{"example.com."}})
+	s := httptest.NewServer(f)
+	t.Cleanup(s.Close)
+	c := clouddns.NewClientWithTokenSource("project", oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"}))
+	c.BaseURL = s.URL
+	return f, c
+}
+
+func TestCloudDNSConformance(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	_, c := newTestCloudDNS(t)
+	testProviderConformance(t, conformanceFixture{CNAME: true, New: func(ctx context.Context, opts ProviderOptions) (Provider, error) {
+		return NewCloudDNS(ctx, c, "example-com", opts)
+	}})
+}
+
+func TestCloudDNS(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	f, c := newTestCloudDNS(t)
+	ctx := context.Background()
+
+	if _, err := NewCloudDNS(ctx, c, "example-com", ProviderOptions{Zone: "example.org"}); err == nil {
+		t.Error("expected an error for a managed zone that serves another domain")
+	}
+	// Without a managed zone, the one that serves the domain is found.
+	p, err := NewCloudDNS(ctx, c, "", ProviderOptions{Zone: "example.com", TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UpdateDNS(ctx, "nodes", []net.IP{net.IPv4(42, 0, 0, 1), net.ParseIP("2001:db8::1")}); err != nil {
+		t.Fatal(err)
+	}
+	// The replaced set gets the configured TTL, in one change.
+	want := []string{
+		"nodes.example.com. A 60 42.0.0.1",
+		"nodes.example.com. AAAA 60 2001:db8::1",
+		"www.example.com. CNAME 300 example.com.",
+	}
+	if diff := cmp.Diff(f.contents(), want); diff != "" {
+		t.Errorf("after update:\n%s", diff)
+	}
+	if got, want := f.changes, 1; got != want {
+		t.Errorf("changes:\n  got: %v\n want: %v", got, want)
+	}
+
+	// An update that changes nothing doesn't make a change.
+	if err := p.UpdateDNS(ctx, "nodes", []net.IP{net.IPv4(42, 0, 0, 1), net.ParseIP("2001:db8::1")}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.changes, 1; got != want {
+		t.Errorf("changes after a no-op update:\n  got: %v\n want: %v", got, want)
+	}
+
+	snap, err := p.Export(ctx, []string{"nodes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRecords := []SnapshotRecord{{Name: "nodes", Type: "A", TTL: 60, Data: "42.0.0.1"}, {Name: "nodes", Type: "AAAA", TTL: 60, Data: "2001:db8::1"}}
+	if diff := cmp.Diff(snap.Records, wantRecords); diff != "" {
+		t.Errorf("export:\n%s", diff)
+	}
+}
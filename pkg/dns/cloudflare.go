@@ -0,0 +1,249 @@
+//go:build !no_cloudflare
+// +build !no_cloudflare
+
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/cloudflare"
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"github.com/jrockway/nodedns/pkg/tracing"
+	"go.uber.org/zap"
+)
+
+// Cloudflare is a Provider that publishes records in a Cloudflare zone.
+type Cloudflare struct {
+	c       *cloudflare.Client
+	zoneID  string
+	proxied bool
+	opts    ProviderOptions
+}
+
+var (
+	_ Provider = (*Cloudflare)(nil)
+	_ Exporter = (*Cloudflare)(nil)
+)
+
+// Cloudflare's API errors carry the HTTP status of the response.
+func init() {
+	statusChecks = append(statusChecks, func(err error) int {
+		var cfErr *cloudflare.StatusError
+		if errors.As(err, &cfErr) {
+			return cfErr.Status
+		}
+		return 0
+	})
+}
+
+// NewCloudflare returns a Cloudflare Provider for the zone in opts.  If proxied is true, the
+// records are proxied through Cloudflare, and existing records are switched to match.
+func NewCloudflare(ctx context.Context, c *cloudflare.Client, proxied bool, opts ProviderOptions) (*Cloudflare, error) {
+	id, err := c.ZoneID(ctx, opts.Zone)
+	if err != nil {
+		return nil, fmt.Errorf("cloudflare: %w", err)
+	}
+	return &Cloudflare{c: c, zoneID: id, proxied: proxied, opts: opts}, nil
+}
+
+// FQDN implements Provider.
+func (p *Cloudflare) FQDN(record string) string {
+	return fqdn(p.opts.Zone, record)
+}
+
+// ttl returns the TTL of the records; proxied records' TTL is always automatic.
+func (p *Cloudflare) ttl() int {
+	if p.proxied {
+		return 1
+	}
+	ttl, _ := ClampTTL(p.opts.TTL)
+	return int(ttl.Round(time.Second).Seconds())
+}
+
+// UpdateDNS implements Provider.
+func (p *Cloudflare) UpdateDNS(ctx context.Context, record string, addresses []net.IP) (err error) {
+	if record == "" {
+		return nil
+	}
+	ctx, span := tracing.Start(ctx, "cloudflare_dns_update")
+	defer span.End()
+	zone, name := p.opts.Zone, p.FQDN(record)
+	dnsUpdateAttempts.WithLabelValues("cloudflare", zone, record).Inc()
+	defer func(start time.Time) {
+		observeUpdate(ctx, "cloudflare", zone, record, start, err)
+	}(time.Now())
+	l := zap.L().Named("cloudflare-dns").With(correlation.Field(ctx))
+
+	records, err := p.c.DNSRecords(ctx, p.zoneID, name)
+	if err != nil {
+		return fmt.Errorf("get existing records: %w", err)
+	}
+	desired := make(map[string]bool)
+	for _, ip := range addresses {
+		if manages(p.opts.Family, recordType(ip)) {
+			desired[ip.String()] = true
+		}
+	}
+	// published tracks what the record contains as changes are made, even if some of them fail.
+	published := make(map[string]bool)
+	defer reportPublished("cloudflare", zone, p.opts.Family, record, published)
+	var toDelete, toFix []cloudflare.DNSRecord
+	var cname *cloudflare.DNSRecord
+	for i, r := range records {
+		if r.Type == "CNAME" {
+			cname = &records[i]
+			continue
+		}
+		if !manages(p.opts.Family, r.Type) {
+			continue
+		}
+		ip := net.ParseIP(r.Content)
+		if ip == nil {
+			continue
+		}
+		addr := ip.String()
+		switch {
+		case !desired[addr] || published[addr]:
+			// Unwanted, or a duplicate of a record that's kept.
+			toDelete = append(toDelete, r)
+		case r.Proxied != p.proxied || r.TTL != p.ttl():
+			toFix = append(toFix, r)
+		}
+		published[addr] = true
+	}
+	var toCreate []string
+	for addr := range desired {
+		if !published[addr] {
+			toCreate = append(toCreate, addr)
+		}
+	}
+	sort.Strings(toCreate)
+	var toDeleteAddrs []string
+	for _, r := range toDelete {
+		toDeleteAddrs = append(toDeleteAddrs, r.Content)
+	}
+
+	if cname != nil {
+		dnsRecordConflict.WithLabelValues("cloudflare", zone, record).Set(1)
+	} else {
+		dnsRecordConflict.WithLabelValues("cloudflare", zone, record).Set(0)
+	}
+	if p.opts.Audit {
+		dnsRecordDrift.WithLabelValues("cloudflare", zone, record).Set(float64(len(toDelete) + len(toCreate)))
+		if len(toDelete) > 0 || len(toCreate) > 0 {
+			l.Info("dns record drifted; auditing, so not changing it", zap.String("record", name), zap.Strings("would_add", toCreate), zap.Strings("would_remove", toDeleteAddrs))
+			if p.opts.OnDrift != nil {
+				p.opts.OnDrift(ctx, zone, name, toCreate, toDeleteAddrs)
+			}
+		}
+		if len(toFix) > 0 {
+			var addrs []string
+			for _, r := range toFix {
+				addrs = append(addrs, r.Content)
+			}
+			l.Info("dns record settings drifted; auditing, so not changing them", zap.String("record", name), zap.Strings("addresses", addrs), zap.Bool("proxied", p.proxied), zap.Int("ttl", p.ttl()))
+		}
+		return nil
+	}
+	if p.opts.CreateOnly && len(toDelete) > 0 {
+		l.Info("create-only; not deleting records", zap.String("record", name), zap.Strings("would_delete", toDeleteAddrs))
+		dnsRecordsDeleteSkipped.WithLabelValues("cloudflare", zone, record).Add(float64(len(toDelete)))
+		toDelete = nil
+	}
+	if cname != nil && len(toCreate) > 0 {
+		return &ConflictError{Record: name, Target: cname.Content}
+	}
+
+	var added, removed, fixed []string
+	defer func() {
+		if len(added)+len(removed)+len(fixed) == 0 {
+			return
+		}
+		l.Info("dns record changed", zap.String("record", name), zap.Strings("added", added), zap.Strings("removed", removed), zap.Strings("fixed", fixed), zap.Int("addresses", len(desired)))
+	}()
+	// Every creation finishes before anything is deleted, so that the record is never empty.
+	errs := parallel(len(toCreate), p.opts.Parallelism, func(i int) error {
+		addr := toCreate[i]
+		ip := net.ParseIP(addr)
+		created, err := p.c.CreateDNSRecord(ctx, p.zoneID, cloudflare.DNSRecord{Type: recordType(ip), Name: name, Content: addr, TTL: p.ttl(), Proxied: p.proxied})
+		if err != nil {
+			return fmt.Errorf("creating record %s %s: %w", recordType(ip), addr, err)
+		}
+		dnsRecordsCreated.WithLabelValues("cloudflare", zone, record).Inc()
+		mutated(ctx, p.opts.OnMutation, Mutation{Action: "create", Provider: "cloudflare", Zone: zone, Record: name, Address: addr, ID: created.ID})
+		return nil
+	})
+	for i, addr := range toCreate {
+		if errs[i] == nil {
+			added = append(added, addr)
+			published[addr] = true
+		}
+	}
+	if err := firstError(errs); err != nil {
+		return err
+	}
+	errs = parallel(len(toFix), p.opts.Parallelism, func(i int) error {
+		r := toFix[i]
+		ttlChanged := r.TTL != p.ttl()
+		r.Proxied, r.TTL = p.proxied, p.ttl()
+		if err := p.c.UpdateDNSRecord(ctx, p.zoneID, r); err != nil {
+			return fmt.Errorf("updating record %s %s: %w", r.Type, r.Content, err)
+		}
+		if ttlChanged {
+			dnsRecordsTTLFixed.WithLabelValues("cloudflare", zone, record).Inc()
+		}
+		return nil
+	})
+	for i, r := range toFix {
+		if errs[i] == nil {
+			fixed = append(fixed, r.Content)
+		}
+	}
+	if err := firstError(errs); err != nil {
+		return err
+	}
+	errs = parallel(len(toDelete), p.opts.Parallelism, func(i int) error {
+		r := toDelete[i]
+		if err := p.c.DeleteDNSRecord(ctx, p.zoneID, r.ID); err != nil {
+			return fmt.Errorf("deleting record id %s: %w", r.ID, err)
+		}
+		dnsRecordsDeleted.WithLabelValues("cloudflare", zone, record).Inc()
+		mutated(ctx, p.opts.OnMutation, Mutation{Action: "delete", Provider: "cloudflare", Zone: zone, Record: name, Address: net.ParseIP(r.Content).String(), ID: r.ID})
+		return nil
+	})
+	for i, r := range toDelete {
+		if addr := net.ParseIP(r.Content).String(); errs[i] == nil && !desired[addr] {
+			removed = append(removed, addr)
+			delete(published, addr)
+		}
+	}
+	if err := firstError(errs); err != nil {
+		return err
+	}
+	dnsUpdatedOK.WithLabelValues("cloudflare", zone, record).Inc()
+	return nil
+}
+
+// Export implements Exporter.
+func (p *Cloudflare) Export(ctx context.Context, names []string) (*Snapshot, error) {
+	snap := &Snapshot{Zone: p.opts.Zone, Taken: time.Now().UTC()}
+	for _, n := range names {
+		records, err := p.c.DNSRecords(ctx, p.zoneID, p.FQDN(n))
+		if err != nil {
+			return nil, fmt.Errorf("export %s: %w", n, err)
+		}
+		for _, r := range records {
+			if r.Type != "A" && r.Type != "AAAA" {
+				continue
+			}
+			snap.Records = append(snap.Records, SnapshotRecord{Name: RelativeName(p.opts.Zone, r.Name), Type: r.Type, TTL: r.TTL, Data: r.Content})
+		}
+	}
+	snap.sort()
+	return snap, nil
+}
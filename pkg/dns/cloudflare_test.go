@@ -0,0 +1,174 @@
+//go:build !no_cloudflare
+// +build !no_cloudflare
+
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/cloudflare"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+)
+
+// fakeCloudflare is a fake of the parts of the Cloudflare DNS API that Cloudflare uses.
+type fakeCloudflare struct {
+	sync.Mutex
+	nextID  int
+	records map[string]cloudflare.DNSRecord
+}
+
+func (f *fakeCloudflare) add(r cloudflare.DNSRecord) cloudflare.DNSRecord {
+	f.nextID++
+	r.ID = strconv.Itoa(f.nextID)
+	f.records[r.ID] = r
+	return r
+}
+
+// contents returns each record as "name type content proxied", sorted.
+func (f *fakeCloudflare) contents() []string {
+	f.Lock()
+	defer f.Unlock()
+	var result []string
+	for _, r := range f.records {
+		result = append(result, strings.Join([]string{r.Name, r.Type, r.Content, strconv.FormatBool(r.Proxied)}, " "))
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (f *fakeCloudflare) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	reply := func(result interface{}, info map[string]interface{}) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "result": result, "result_info": info}) // nolint:errcheck
+	}
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	switch {
+	case req.URL.Path == "/zones":
+		reply([]map[string]string{{"id": "zone-id", "name": req.URL.Query().Get("name")}}, nil)
+	case len(parts) == 3 && req.Method == http.MethodGet:
+		var result []cloudflare.DNSRecord
+		for _, r := range f.records {
+			if r.Name == req.URL.Query().Get("name") {
+				result = append(result, r)
+			}
+		}
+		reply(result, map[string]interface{}{"page": 1, "total_pages": 1})
+	case len(parts) == 3 && req.Method == http.MethodPost:
+		var r cloudflare.DNSRecord
+		json.NewDecoder(req.Body).Decode(&r) // nolint:errcheck
+		reply(f.add(r), nil)
+	case len(parts) == 4 && req.Method == http.MethodPatch:
+		r := f.records[parts[3]]
+		json.NewDecoder(req.Body).Decode(&r) // nolint:errcheck
+		f.records[parts[3]] = r
+		reply(r, nil)
+	case len(parts) == 4 && req.Method == http.MethodDelete:
+		delete(f.records, parts[3])
+		reply(map[string]string{"id": parts[3]}, nil)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+// newTestCloudflare returns a fake Cloudflare API serving example.com, with the records that
+// testProviderConformance expects, 42.0.0.1 twice, and a client of it.
+func newTestCloudflare(t *testing.T) (*fakeCloudflare, *cloudflare.Client) {
+	f := &fakeCloudflare{records: make(map[string]cloudflare.DNSRecord)}
+	f.add(cloudflare.DNSRecord{Type: "A", Name: "nodes.example.com", Content: "10.0.0.1"})
+	f.add(cloudflare.DNSRecord{Type: "A", Name: "nodes.example.com", Content: "42.0.0.1"})
+	f.add(cloudflare.DNSRecord{Type: "A", Name: "nodes.example.com", Content: "42.0.0.1"})
+	f.add(cloudflare.DNSRecord{Type: "CNAME", Name: "www.example.com", Content: "example.com"})
+	s := httptest.NewServer(f)
+	t.Cleanup(s.Close)
+	c := cloudflare.NewClient("token")
+	c.BaseURL = s.URL
+	return f, c
+}
+
+func TestCloudflareConformance(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	_, c := newTestCloudflare(t)
+	testProviderConformance(t, conformanceFixture{CNAME: true, New: func(ctx context.Context, opts ProviderOptions) (Provider, error) {
+		return NewCloudflare(ctx, c, true, opts)
+	}})
+}
+
+func TestCloudflare(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	f, c := newTestCloudflare(t)
+	ctx := context.Background()
+
+	var mutations []Mutation
+	onMutation := func(ctx context.Context, m Mutation) { mutations = append(mutations, m) }
+	p, err := NewCloudflare(ctx, c, true, ProviderOptions{Zone: "example.com", TTL: time.Minute, OnMutation: onMutation})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UpdateDNS(ctx, "nodes", []net.IP{net.IPv4(42, 0, 0, 1), net.ParseIP("2001:db8::1")}); err != nil {
+		t.Fatal(err)
+	}
+	// New records are proxied, and other records are left alone.
+	want := []string{
+		"nodes.example.com A 42.0.0.1 true",
+		"nodes.example.com AAAA 2001:db8::1 true",
+		"www.example.com CNAME example.com false",
+	}
+	if diff := cmp.Diff(f.contents(), want); diff != "" {
+		t.Errorf("after update:\n%s", diff)
+	}
+	// Which of the duplicates is deleted is up to the order of the listing.
+	wantMutations := []Mutation{
+		{Action: "create", Provider: "cloudflare", Zone: "example.com", Record: "nodes.example.com", Type: "AAAA", Address: "2001:db8::1", ID: "5"},
+		{Action: "delete", Provider: "cloudflare", Zone: "example.com", Record: "nodes.example.com", Type: "A", Address: "10.0.0.1", ID: "1"},
+		{Action: "delete", Provider: "cloudflare", Zone: "example.com", Record: "nodes.example.com", Type: "A", Address: "42.0.0.1"},
+	}
+	sort.Slice(mutations, func(i, j int) bool {
+		return mutations[i].Action+mutations[i].Address < mutations[j].Action+mutations[j].Address
+	})
+	if len(mutations) == len(wantMutations) {
+		mutations[2].ID = ""
+	}
+	if diff := cmp.Diff(mutations, wantMutations); diff != "" {
+		t.Errorf("mutations:\n%s", diff)
+	}
+
+	// Proxied records have an automatic TTL.
+	snap, err := p.Export(ctx, []string{"nodes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRecords := []SnapshotRecord{{Name: "nodes", Type: "A", TTL: 1, Data: "42.0.0.1"}, {Name: "nodes", Type: "AAAA", TTL: 1, Data: "2001:db8::1"}}
+	if diff := cmp.Diff(snap.Records, wantRecords); diff != "" {
+		t.Errorf("export:\n%s", diff)
+	}
+}
+
+func TestCloudflareErrorClass(t *testing.T) {
+	testData := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "rate limit", err: fmt.Errorf("get: %w", &cloudflare.StatusError{Status: http.StatusTooManyRequests}), want: "rate_limit"},
+		{name: "auth", err: &cloudflare.StatusError{Status: http.StatusForbidden}, want: "auth"},
+	}
+	for _, test := range testData {
+		if got := errorClass(test.err); got != test.want {
+			t.Errorf("%s:\n  got: %v\n want: %v", test.name, got, test.want)
+		}
+	}
+}
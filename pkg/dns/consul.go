@@ -0,0 +1,226 @@
+//go:build !no_consul
+// +build !no_consul
+
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/consul"
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"github.com/jrockway/nodedns/pkg/tracing"
+	"go.uber.org/zap"
+)
+
+// Consul is a Provider that registers records as services in Consul's catalog, for Consul DNS to
+// serve: each address is an instance of a service named after the record, registered on an
+// external node (one that no agent runs on), so nodes.service.consul resolves to the addresses.
+// Only the instances on that node are managed; other instances of the service are left alone.
+type Consul struct {
+	c    *consul.Client
+	node string
+	opts ProviderOptions
+}
+
+var (
+	_ Provider = (*Consul)(nil)
+	_ Exporter = (*Consul)(nil)
+)
+
+// Consul's API errors carry the HTTP status of the response.
+func init() {
+	statusChecks = append(statusChecks, func(err error) int {
+		var consulErr *consul.APIError
+		if errors.As(err, &consulErr) {
+			return consulErr.Status
+		}
+		return 0
+	})
+}
+
+// NewConsul returns a Consul Provider that registers services on the named catalog node.  The zone
+// in opts is Consul's DNS domain, usually "consul".  Consul DNS has its own TTLs, so opts.TTL is
+// ignored.
+func NewConsul(ctx context.Context, c *consul.Client, node string, opts ProviderOptions) (*Consul, error) {
+	if _, err := c.Leader(ctx); err != nil {
+		return nil, fmt.Errorf("consul: %w", err)
+	}
+	return &Consul{c: c, node: node, opts: opts}, nil
+}
+
+// FQDN implements Provider.
+func (p *Consul) FQDN(record string) string {
+	return p.service(record) + ".service." + strings.TrimSuffix(p.opts.Zone, ".")
+}
+
+// service returns the name of the service that the record is registered as; records may be a
+// service name, like "nodes", or a name in the zone, like "nodes.service.consul".
+func (p *Consul) service(record string) string {
+	record = strings.TrimSuffix(record, ".")
+	record = strings.TrimSuffix(record, "."+strings.TrimSuffix(p.opts.Zone, "."))
+	return strings.TrimSuffix(record, ".service")
+}
+
+// serviceID returns the ID of the instance of the service with the address.
+func serviceID(service, addr string) string {
+	return "nodedns-" + service + "-" + strings.NewReplacer(".", "-", ":", "-").Replace(addr)
+}
+
+// instances returns the instances of the service on the provider's node.
+func (p *Consul) instances(ctx context.Context, service string) ([]consul.CatalogService, error) {
+	all, err := p.c.Service(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	var result []consul.CatalogService
+	for _, s := range all {
+		if s.Node == p.node {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+// UpdateDNS implements Provider.  Consul has no transactions for catalog registrations, so if
+// the update fails part way, some of the changes are left applied until it's retried.
+func (p *Consul) UpdateDNS(ctx context.Context, record string, addresses []net.IP) (err error) {
+	if record == "" {
+		return nil
+	}
+	ctx, span := tracing.Start(ctx, "consul_dns_update")
+	defer span.End()
+	zone, name, service := p.opts.Zone, p.FQDN(record), p.service(record)
+	if strings.Contains(service, ".") {
+		return fmt.Errorf("record %q: consul service names can't contain dots", record)
+	}
+	dnsUpdateAttempts.WithLabelValues("consul", zone, record).Inc()
+	defer func(start time.Time) {
+		observeUpdate(ctx, "consul", zone, record, start, err)
+	}(time.Now())
+	l := zap.L().Named("consul-dns").With(correlation.Field(ctx))
+
+	instances, err := p.instances(ctx, service)
+	if err != nil {
+		return fmt.Errorf("get existing instances: %w", err)
+	}
+	desired := make(map[string]bool)
+	for _, ip := range addresses {
+		if manages(p.opts.Family, recordType(ip)) {
+			desired[ip.String()] = true
+		}
+	}
+	// published tracks what the record contains as changes are made, even if the update fails.
+	published := make(map[string]bool)
+	defer reportPublished("consul", zone, p.opts.Family, record, published)
+	var toDelete []consul.CatalogService
+	var toDeleteAddrs []string
+	for _, s := range instances {
+		ip := net.ParseIP(s.ServiceAddress)
+		if ip == nil || !manages(p.opts.Family, recordType(ip)) {
+			continue
+		}
+		addr := ip.String()
+		if !desired[addr] || published[addr] {
+			// Unwanted, or a duplicate of an instance that's kept.
+			toDelete = append(toDelete, s)
+			if !desired[addr] {
+				toDeleteAddrs = append(toDeleteAddrs, addr)
+			}
+		}
+		published[addr] = true
+	}
+	var toCreate []string
+	for addr := range desired {
+		if !published[addr] {
+			toCreate = append(toCreate, addr)
+		}
+	}
+	sort.Strings(toCreate)
+
+	if p.opts.Audit {
+		dnsRecordDrift.WithLabelValues("consul", zone, record).Set(float64(len(toDelete) + len(toCreate)))
+		if len(toDelete) > 0 || len(toCreate) > 0 {
+			l.Info("dns record drifted; auditing, so not changing it", zap.String("record", name), zap.Strings("would_add", toCreate), zap.Strings("would_remove", toDeleteAddrs))
+			if p.opts.OnDrift != nil {
+				p.opts.OnDrift(ctx, zone, name, toCreate, toDeleteAddrs)
+			}
+		}
+		return nil
+	}
+	if p.opts.CreateOnly && len(toDelete) > 0 {
+		l.Info("create-only; not deleting records", zap.String("record", name), zap.Strings("would_delete", toDeleteAddrs))
+		dnsRecordsDeleteSkipped.WithLabelValues("consul", zone, record).Add(float64(len(toDelete)))
+		toDelete, toDeleteAddrs = nil, nil
+	}
+	if len(toCreate) == 0 && len(toDelete) == 0 {
+		dnsUpdatedOK.WithLabelValues("consul", zone, record).Inc()
+		return nil
+	}
+
+	// Every registration finishes before anything is deregistered, so that the record is never
+	// empty.
+	errs := parallel(len(toCreate), p.opts.Parallelism, func(i int) error {
+		addr := toCreate[i]
+		id := serviceID(service, addr)
+		if err := p.c.Register(ctx, p.node, consul.AgentService{ID: id, Service: service, Address: addr, Tags: []string{"nodedns"}}); err != nil {
+			return fmt.Errorf("register %s: %w", addr, err)
+		}
+		dnsRecordsCreated.WithLabelValues("consul", zone, record).Inc()
+		mutated(ctx, p.opts.OnMutation, Mutation{Action: "create", Provider: "consul", Zone: zone, Record: name, Address: addr, ID: id})
+		return nil
+	})
+	for i, addr := range toCreate {
+		if errs[i] == nil {
+			published[addr] = true
+		}
+	}
+	if err := firstError(errs); err != nil {
+		return err
+	}
+	errs = parallel(len(toDelete), p.opts.Parallelism, func(i int) error {
+		s := toDelete[i]
+		if err := p.c.Deregister(ctx, p.node, s.ServiceID); err != nil {
+			return fmt.Errorf("deregister %s: %w", s.ServiceID, err)
+		}
+		dnsRecordsDeleted.WithLabelValues("consul", zone, record).Inc()
+		mutated(ctx, p.opts.OnMutation, Mutation{Action: "delete", Provider: "consul", Zone: zone, Record: name, Address: net.ParseIP(s.ServiceAddress).String(), ID: s.ServiceID})
+		return nil
+	})
+	for i, s := range toDelete {
+		if addr := net.ParseIP(s.ServiceAddress).String(); errs[i] == nil && !desired[addr] {
+			delete(published, addr)
+		}
+	}
+	if err := firstError(errs); err != nil {
+		return err
+	}
+	l.Info("dns record changed", zap.String("record", name), zap.Strings("added", toCreate), zap.Strings("removed", toDeleteAddrs), zap.Int("addresses", len(desired)))
+	dnsUpdatedOK.WithLabelValues("consul", zone, record).Inc()
+	return nil
+}
+
+// Export implements Exporter.
+func (p *Consul) Export(ctx context.Context, names []string) (*Snapshot, error) {
+	snap := &Snapshot{Zone: p.opts.Zone, Taken: time.Now().UTC()}
+	for _, n := range names {
+		instances, err := p.instances(ctx, p.service(n))
+		if err != nil {
+			return nil, fmt.Errorf("export %s: %w", n, err)
+		}
+		for _, s := range instances {
+			ip := net.ParseIP(s.ServiceAddress)
+			if ip == nil {
+				continue
+			}
+			snap.Records = append(snap.Records, SnapshotRecord{Name: RelativeName(p.opts.Zone, p.FQDN(n)), Type: recordType(ip), Data: ip.String()})
+		}
+	}
+	snap.sort()
+	return snap, nil
+}
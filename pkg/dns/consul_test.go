@@ -0,0 +1,178 @@
+//go:build !no_consul
+// +build !no_consul
+
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/consul"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+)
+
+// fakeConsul is a fake of the parts of Consul's catalog API that the client uses.
+type fakeConsul struct {
+	sync.Mutex
+	instances []consul.CatalogService
+}
+
+// contents returns each instance as "node service id address", sorted.
+func (f *fakeConsul) contents() []string {
+	f.Lock()
+	defer f.Unlock()
+	var result []string
+	for _, s := range f.instances {
+		result = append(result, strings.Join([]string{s.Node, s.ServiceName, s.ServiceID, s.ServiceAddress}, " "))
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	switch {
+	case req.URL.Path == "/v1/status/leader":
+		json.NewEncoder(w).Encode("10.0.0.100:8300") // nolint:errcheck
+	case strings.HasPrefix(req.URL.Path, "/v1/catalog/service/"):
+		name := strings.TrimPrefix(req.URL.Path, "/v1/catalog/service/")
+		result := []consul.CatalogService{}
+		for _, s := range f.instances {
+			if s.ServiceName == name {
+				result = append(result, s)
+			}
+		}
+		json.NewEncoder(w).Encode(result) // nolint:errcheck
+	case req.URL.Path == "/v1/catalog/register" && req.Method == http.MethodPut:
+		var r struct {
+			Node    string
+			Service consul.AgentService
+		}
+		json.NewDecoder(req.Body).Decode(&r) // nolint:errcheck
+		f.instances = append(f.instances, consul.CatalogService{Node: r.Node, ServiceID: r.Service.ID, ServiceName: r.Service.Service, ServiceAddress: r.Service.Address})
+		json.NewEncoder(w).Encode(true) // nolint:errcheck
+	case req.URL.Path == "/v1/catalog/deregister" && req.Method == http.MethodPut:
+		var r struct{ Node, ServiceID string }
+		json.NewDecoder(req.Body).Decode(&r) // nolint:errcheck
+		var kept []consul.CatalogService
+		for _, s := range f.instances {
+			if s.Node != r.Node || s.ServiceID != r.ServiceID {
+				kept = append(kept, s)
+			}
+		}
+		f.instances = kept
+		json.NewEncoder(w).Encode(true) // nolint:errcheck
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// newTestConsul returns a fake Consul catalog with the instances that testProviderConformance
+// expects, registered by the node "nodedns", with 42.0.0.1 twice, an instance of the service on
+// another node, and another service, and a client of it.
+func newTestConsul(t *testing.T) (*fakeConsul, *consul.Client) {
+	f := &fakeConsul{instances: []consul.CatalogService{
+		{Node: "nodedns", ServiceID: "a", ServiceName: "nodes", ServiceAddress: "10.0.0.1"},
+		{Node: "nodedns", ServiceID: "b", ServiceName: "nodes", ServiceAddress: "42.0.0.1"},
+		{Node: "nodedns", ServiceID: "c", ServiceName: "nodes", ServiceAddress: "42.0.0.1"},
+		{Node: "worker-1", ServiceID: "nodes", ServiceName: "nodes", ServiceAddress: "10.9.0.1"},
+		{Node: "nodedns", ServiceID: "web", ServiceName: "web", ServiceAddress: "10.0.0.2"},
+	}}
+	s := httptest.NewServer(f)
+	t.Cleanup(s.Close)
+	c, err := consul.NewClient(consul.Config{Address: s.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, c
+}
+
+func TestConsulConformance(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	_, c := newTestConsul(t)
+	testProviderConformance(t, conformanceFixture{Zone: "consul", New: func(ctx context.Context, opts ProviderOptions) (Provider, error) {
+		return NewConsul(ctx, c, "nodedns", opts)
+	}})
+}
+
+func TestConsul(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	f, c := newTestConsul(t)
+	ctx := context.Background()
+
+	p, err := NewConsul(ctx, c, "nodedns", ProviderOptions{Zone: "consul"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := p.FQDN("nodes"), "nodes.service.consul"; got != want {
+		t.Errorf("fqdn:\n  got: %v\n want: %v", got, want)
+	}
+	if err := p.UpdateDNS(ctx, "nodes", []net.IP{net.IPv4(42, 0, 0, 1), net.ParseIP("2001:db8::1")}); err != nil {
+		t.Fatal(err)
+	}
+	// New instances are named after the service and their address, and other nodes' instances
+	// are left alone.
+	want := []string{
+		"nodedns nodes b 42.0.0.1",
+		"nodedns nodes nodedns-nodes-2001-db8--1 2001:db8::1",
+		"nodedns web web 10.0.0.2",
+		"worker-1 nodes nodes 10.9.0.1",
+	}
+	if diff := cmp.Diff(f.contents(), want); diff != "" {
+		t.Errorf("after update:\n%s", diff)
+	}
+
+	snap, err := p.Export(ctx, []string{"nodes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRecords := []SnapshotRecord{{Name: "nodes.service", Type: "A", Data: "42.0.0.1"}, {Name: "nodes.service", Type: "AAAA", Data: "2001:db8::1"}}
+	if diff := cmp.Diff(snap.Records, wantRecords); diff != "" {
+		t.Errorf("export:\n%s", diff)
+	}
+
+	if err := p.UpdateDNS(ctx, "nodes.example", []net.IP{net.IPv4(42, 0, 0, 1)}); err == nil {
+		t.Error("expected an error for a service name with a dot")
+	}
+
+	// Fully-qualified names and zones are accepted.
+	p, err = NewConsul(ctx, c, "nodedns", ProviderOptions{Zone: "consul."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UpdateDNS(ctx, "nodes.service.consul.", nil); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		"nodedns web web 10.0.0.2",
+		"worker-1 nodes nodes 10.9.0.1",
+	}
+	if diff := cmp.Diff(f.contents(), want); diff != "" {
+		t.Errorf("after removing every address:\n%s", diff)
+	}
+}
+
+func TestConsulErrorClass(t *testing.T) {
+	testData := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "auth", err: &consul.APIError{Status: http.StatusForbidden}, want: "auth"},
+	}
+	for _, test := range testData {
+		if got := errorClass(test.err); got != test.want {
+			t.Errorf("%s:\n  got: %v\n want: %v", test.name, got, test.want)
+		}
+	}
+}
@@ -2,17 +2,25 @@
 package dns
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/digitalocean/godo"
-	"github.com/jrockway/opinionated-server/client"
-	"github.com/opentracing/opentracing-go"
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"github.com/jrockway/nodedns/pkg/digitalocean"
+	"github.com/jrockway/nodedns/pkg/tracing"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"go.uber.org/zap"
@@ -41,6 +49,13 @@ var (
 		},
 		[]string{"provider", "zone", "record"},
 	)
+	dnsListedPages = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_listed_pages",
+			Help: "The number of pages of records listed, by whether the page had changed since it was last listed (\"changed\"), was downloaded but unchanged (\"unchanged\"), or wasn't downloaded again because the API reported it unmodified (\"not_modified\").",
+		},
+		[]string{"provider", "zone", "result"},
+	)
 	dnsRecordsDeleted = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "dns_records_deleted",
@@ -48,11 +63,83 @@ var (
 		},
 		[]string{"provider", "zone", "record"},
 	)
-	doRequestsRemaining = promauto.NewGauge(
+	dnsRecordDrift = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_record_drift",
+			Help: "In audit mode, the number of A/AAAA records that would have to be added to or removed from DNS for the record to contain the desired addresses.",
+		},
+		[]string{"provider", "zone", "record"},
+	)
+	dnsUpdateDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "dns_update_duration_seconds",
+			Help:    "How long each attempt to update a record took, including listing the existing records.  Exemplars link to the update's trace.",
+			Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+		},
+		[]string{"provider", "zone", "record"},
+	)
+	dnsPublishedAddresses = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_published_addresses",
+			Help: "The number of distinct addresses in a record after the most recent update, by record and type (\"A\" or \"AAAA\").",
+		},
+		[]string{"provider", "zone", "record", "type"},
+	)
+	dnsRecordConflict = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_record_conflict",
+			Help: "1 if a CNAME record with the same name prevents A/AAAA records from being added to the record, 0 otherwise.",
+		},
+		[]string{"provider", "zone", "record"},
+	)
+	dnsRecordUnowned = promauto.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "digitalocean_requests_remaining",
-			Help: "The number of API requests remaining on the DigitalOcean client.",
+			Name: "dns_record_unowned",
+			Help: "1 if a record has A/AAAA records, or an ownership TXT record, that another owner created, so nodedns refuses to change it; 0 otherwise.",
+		},
+		[]string{"provider", "zone", "record"},
+	)
+	dnsUpdatesSkipped = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_updates_skipped",
+			Help: "The number of updates that were skipped without listing the zone, because the record already had the desired addresses when it was last updated or checked.",
+		},
+		[]string{"provider", "zone", "record"},
+	)
+	dnsLastSuccess = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_last_successful_sync_timestamp_seconds",
+			Help: "When each record was last updated successfully, or found to be unchanged since its last successful update.",
+		},
+		[]string{"provider", "zone", "record"},
+	)
+	dnsUpdateFailures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_update_failures",
+			Help: "The number of attempts to update DNS that failed, by the class of error: \"rate_limit\", \"auth\", \"timeout\", or \"other\".",
+		},
+		[]string{"provider", "zone", "record", "class"},
+	)
+	dnsRecordsManaged = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_records_managed",
+			Help: "The number of A/AAAA records that nodedns manages in each record, after the most recent update.",
+		},
+		[]string{"provider", "zone", "record"},
+	)
+	dnsRecordsTTLFixed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_records_ttl_fixed",
+			Help: "The number of existing A/AAAA records (record sets, for Cloud DNS) whose TTL was changed to the configured TTL.",
 		},
+		[]string{"provider", "zone", "record"},
+	)
+	dnsRecordsDeleteSkipped = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_records_delete_skipped",
+			Help: "The number of A/AAAA records that would have been removed from DNS, but weren't because the client is create-only.",
+		},
+		[]string{"provider", "zone", "record"},
 	)
 )
 
@@ -60,166 +147,944 @@ var (
 type Config struct {
 	// Personal authentication token.
 	PAToken string `long:"token" env:"DIGITALOCEAN_TOKEN" description:"The DigitalOcean personal access token to use to update DNS."`
+	// A file to read the personal authentication token from instead, and to read again when it changes.
+	TokenFile string `long:"token_file" env:"DIGITALOCEAN_TOKEN_FILE" description:"A file containing the DigitalOcean personal access token, like a mounted Secret, to use instead of --token; the file is checked for a new token every 10 seconds."`
+	// Additional tokens to rotate between, round-robin, with PAToken.
+	ExtraTokens []string `long:"extra_token" env:"DIGITALOCEAN_EXTRA_TOKENS" env-delim:"," description:"Additional personal access tokens; requests rotate between --token and these, round-robin.  May be repeated."`
+	// Tokens to use instead of PAToken for particular zones.
+	ZoneTokens map[string]string `long:"zone_token" env:"DIGITALOCEAN_ZONE_TOKENS" env-delim:"," description:"A zone:token pair; records in that zone are updated with that token instead of --token.  May be repeated."`
 	// Name of the DNS zone to create/update the record in.
 	Zone string `long:"zone" env:"DNS_ZONE" description:"The name of the DigitalOcean DNS zone that your records are in."`
-	// TTL of the created DNS records.
-	TTL time.Duration `long:"ttl" env:"DNS_TTL" description:"The TTL to apply to newly-created records." default:"60s"`
+	// TTL of the DNS records.
+	TTL time.Duration `long:"ttl" env:"DNS_TTL" description:"The TTL of the records; existing records with another TTL are changed to it." default:"60s"`
 }
 
-// transport is an http.RoundTripper that adds the DO token to each request.
-type transport struct {
-	Token      *oauth2.Token
-	underlying http.RoundTripper
-}
+// The range of TTLs that DigitalOcean accepts; it rejects records with TTLs outside of it.
+const (
+	MinTTL = 30 * time.Second
+	MaxTTL = 24 * time.Hour
+)
 
-// RoundTrip implements http.RoundTripper.
-func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
-	t.Token.SetAuthHeader(req)
-	return t.underlying.RoundTrip(req)
+// ClampTTL rounds a TTL to the nearest second and clamps it to [MinTTL, MaxTTL], returning the
+// result and whether the TTL had to be clamped.
+func ClampTTL(ttl time.Duration) (time.Duration, bool) {
+	ttl = ttl.Round(time.Second)
+	switch {
+	case ttl < MinTTL:
+		return MinTTL, true
+	case ttl > MaxTTL:
+		return MaxTTL, true
+	}
+	return ttl, false
 }
 
-// Client is a DigitalOcean API client configured to use opentracing.
+// Client is a DigitalOcean API client whose requests are traced.
 type Client struct {
-	c    *godo.Client
-	zone string
-	ttl  time.Duration
+	c      *godo.Client
+	zone   string
+	ttl    time.Duration
+	family string // "ipv4" or "ipv6" to only manage A or AAAA records; empty for both.
+	pages  *listingCache
+	seen   *seenRecords
+	// applied, if set, remembers the addresses that each record was last updated with; see
+	// SkipUnchanged.
+	applied *appliedRecords
+
+	owner      string // If set, only records marked with a TXT record naming this owner are changed.
+	adopt      bool   // If true, unmarked records are marked as the owner's, rather than refused.
+	createOnly bool   // If true, records are never deleted.
+	audit      bool   // If true, records are never changed.
+	onDrift    func(ctx context.Context, zone, record string, add, remove []string)
+	onMutation func(ctx context.Context, m Mutation)
+	// parallelism is how many records are created or deleted at a time.
+	parallelism int
 }
 
-// NewClient creates a new DigitalOcean API client and checks that it works.
-func NewClient(ctx context.Context, c *Config) (*Client, error) {
-	httpClient := &http.Client{
-		Transport: &transport{
-			Token: &oauth2.Token{
-				AccessToken: c.PAToken,
-			},
-			underlying: client.WrapRoundTripper(nil),
-		},
+// listingCache remembers the most recent listing of each page of a zone's records, or of one
+// name's records, so that unchanged pages aren't downloaded again (if the API supports ETags) or
+// parsed again.
+type listingCache struct {
+	sync.Mutex
+	pages map[listingKey]*listedPage
+}
+
+// listingKey identifies a page of a listing.
+type listingKey struct {
+	name string // The fully-qualified name that the listing is filtered to; empty for the whole zone.
+	page int
+}
+
+// seenRecords remembers which records a client has updated, so that the existing records that it
+// adopts can be logged the first time.
+type seenRecords struct {
+	sync.Mutex
+	names map[string]bool
+}
+
+// adopt returns the addresses of the existing records that will be kept, if this is the first
+// update of the record.
+func (s *seenRecords) adopt(record string, existing map[string][]int, desired map[string]bool) []string {
+	if s == nil {
+		return nil
 	}
-	godoClient := godo.NewClient(httpClient)
-	godoClient.OnRequestCompleted(func(req *http.Request, res *http.Response) {
-		if res == nil {
-			return
-		}
-		if remaining := res.Header.Get("RateLimit-Remaining"); remaining != "" {
-			val, err := strconv.Atoi(remaining)
-			if err == nil {
-				doRequestsRemaining.Set(float64(val))
-			}
+	s.Lock()
+	defer s.Unlock()
+	if s.names[record] {
+		return nil
+	}
+	s.names[record] = true
+	var result []string
+	for addr := range existing {
+		if desired[addr] {
+			result = append(result, addr)
 		}
-	})
+	}
+	sort.Strings(result)
+	return result
+}
+
+// appliedRecords remembers the addresses that a client last applied to each record, and when the
+// record was last listed, so that updates that wouldn't change anything can be skipped.
+type appliedRecords struct {
+	sync.Mutex
+	verify  time.Duration         // How long to trust an entry before listing the zone again; forever if 0.
+	now     func() time.Time      // The time, for tests.
+	records map[string]appliedSet // By family and record name.
+}
+
+// appliedSet is the addresses that a record was last updated with.
+type appliedSet struct {
+	addresses string // The canonical addresses, sorted and comma-separated.
+	checked   time.Time
+}
+
+// appliedKey returns the key of a record's entry, and the entry's addresses.
+func appliedKey(family, record string, addresses []net.IP) (string, string) {
+	addrs := make([]string, 0, len(addresses))
+	for _, ip := range addresses {
+		addrs = append(addrs, ip.String())
+	}
+	sort.Strings(addrs)
+	return family + "/" + record, strings.Join(addrs, ",")
+}
+
+// unchanged returns true if the record was last updated with the addresses recently enough that
+// listing the zone can be skipped.
+func (a *appliedRecords) unchanged(family, record string, addresses []net.IP) bool {
+	if a == nil {
+		return false
+	}
+	key, addrs := appliedKey(family, record, addresses)
+	a.Lock()
+	defer a.Unlock()
+	set, ok := a.records[key]
+	if !ok || set.addresses != addrs {
+		return false
+	}
+	return a.verify <= 0 || a.now().Sub(set.checked) < a.verify
+}
+
+// set records that the record was updated with the addresses.
+func (a *appliedRecords) set(family, record string, addresses []net.IP) {
+	if a == nil {
+		return
+	}
+	key, addrs := appliedKey(family, record, addresses)
+	a.Lock()
+	defer a.Unlock()
+	a.records[key] = appliedSet{addresses: addrs, checked: a.now()}
+}
+
+// forget removes the record's entry, so that its next update lists the zone.
+func (a *appliedRecords) forget(family, record string) {
+	if a == nil {
+		return
+	}
+	key, _ := appliedKey(family, record, nil)
+	a.Lock()
+	defer a.Unlock()
+	delete(a.records, key)
+}
+
+// listedPage is one page of a zone's records.
+type listedPage struct {
+	etag    string
+	sum     [sha256.Size]byte
+	records []godo.DomainRecord // Only A and AAAA records.
+	cnames  []godo.DomainRecord // CNAME records, which conflict with A and AAAA records of the same name.
+	txts    []godo.DomainRecord // TXT records, which mark the owners of records; see WithOwner.
+	last    bool
+}
+
+func (l *listingCache) get(key listingKey) *listedPage {
+	if l == nil {
+		return nil
+	}
+	l.Lock()
+	defer l.Unlock()
+	return l.pages[key]
+}
+
+func (l *listingCache) put(key listingKey, p *listedPage) {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.pages[key] = p
+}
+
+// Tokens returns the tokens to use for updating records in the provided zone; the zone's own
+// token, if it has one, or else PAToken and ExtraTokens.
+func (c *Config) Tokens(zone string) []string {
+	if token, ok := c.ZoneTokens[zone]; ok {
+		return []string{token}
+	}
+	return append([]string{c.PAToken}, c.ExtraTokens...)
+}
+
+// TokenSources is like Tokens, but reads the token from TokenFile instead of using PAToken, if
+// TokenFile is set.
+func (c *Config) TokenSources(zone string) []oauth2.TokenSource {
+	var result []oauth2.TokenSource
+	tokens := c.Tokens(zone)
+	if _, ok := c.ZoneTokens[zone]; !ok && c.TokenFile != "" {
+		result = append(result, digitalocean.NewTokenFile(c.TokenFile))
+		tokens = c.ExtraTokens
+	}
+	for _, token := range tokens {
+		result = append(result, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
+	}
+	return result
+}
+
+// NewClient creates a new DigitalOcean API client and checks that it works.
+func NewClient(ctx context.Context, c *Config) (*Client, error) {
+	return NewClientFromGodo(ctx, digitalocean.NewGodoClientWithTokenSources(c.TokenSources(c.Zone), nil), c.Zone, c.TTL)
+}
+
+// NewClientFromGodo is like NewClient, but uses the provided godo client, for talking to something
+// other than the real DigitalOcean API.  A TTL that DigitalOcean would reject is clamped, with a
+// warning.
+func NewClientFromGodo(ctx context.Context, godoClient *godo.Client, zone string, ttl time.Duration) (*Client, error) {
 	domains, _, err := godoClient.Domains.List(ctx, &godo.ListOptions{PerPage: 100})
 	if err != nil {
 		return nil, fmt.Errorf("list domains: %w", err)
 	}
 	var found bool
 	for _, d := range domains {
-		if d.Name == c.Zone {
+		if d.Name == zone {
 			found = true
 			break
 		}
 	}
 	if !found {
-		return nil, fmt.Errorf("no domain named %q found", c.Zone)
+		return nil, fmt.Errorf("no domain named %q found", zone)
 	}
 
-	return &Client{c: godoClient, zone: c.Zone, ttl: c.TTL}, nil
+	if clamped, ok := ClampTTL(ttl); ok {
+		zap.L().Warn("ttl out of range; clamping", zap.String("zone", zone), zap.Duration("ttl", ttl), zap.Duration("clamped_ttl", clamped), zap.Duration("min", MinTTL), zap.Duration("max", MaxTTL))
+		ttl = clamped
+	}
+	return &Client{c: godoClient, zone: zone, ttl: ttl, pages: &listingCache{pages: make(map[listingKey]*listedPage)}, seen: &seenRecords{names: make(map[string]bool)}}, nil
 }
 
-func (c *Client) getRecords(ctx context.Context, name string) (map[string]int, error) {
-	result := make(map[string]int)
-	for page := 0; page < 100; page++ {
-		recs, res, err := c.c.Domains.Records(ctx, c.zone, &godo.ListOptions{
-			Page:    page,
-			PerPage: 100,
-		})
+// WithFamily returns a copy of the client that only manages A records (if family is "ipv4") or AAAA
+// records (if family is "ipv6"), leaving records of the other type alone.  An empty family manages
+// both.
+func (c *Client) WithFamily(family string) *Client {
+	cc := *c
+	cc.family = family
+	return &cc
+}
+
+// CreateOnly returns a copy of the client that adds records, but never deletes them; the records
+// that it would have deleted are logged instead.
+func (c *Client) CreateOnly() *Client {
+	cc := *c
+	cc.createOnly = true
+	return &cc
+}
+
+// WithOwner returns a copy of the client that keeps an ownership registry, like external-dns: it
+// writes a TXT record containing OwnerMarker(owner) beside each record that it creates, and refuses
+// to change records that lack it.  Existing A and AAAA records that nodedns didn't create are
+// never deleted or replaced, and neither are records marked with another owner.
+func (c *Client) WithOwner(owner string) *Client {
+	cc := *c
+	cc.owner = owner
+	return &cc
+}
+
+// AdoptUnowned returns a copy of the client that, with an ownership registry (see WithOwner),
+// takes over records that have A or AAAA records but no ownership marker, by writing its marker
+// beside them, rather than refusing to change them.  Records marked with another owner are still
+// refused.  This migrates records made by hand, or by nodedns before it kept a registry.
+func (c *Client) AdoptUnowned() *Client {
+	cc := *c
+	cc.adopt = true
+	return &cc
+}
+
+// OwnerMarker returns the contents of the TXT record that marks an owner's records.
+func OwnerMarker(owner string) string {
+	return "owner=nodedns/" + owner
+}
+
+// OwnershipError is returned when a record can't be updated because it's not marked as the
+// client's own.
+type OwnershipError struct {
+	Record string // The fully-qualified name of the record.
+	Owner  string // The marker of the record's owner, if another owner has marked it.
+	Marker string // The marker that the record would need.
+}
+
+// Error implements error.
+func (e *OwnershipError) Error() string {
+	if e.Owner != "" {
+		return fmt.Sprintf("%s is marked as owned by someone else (%q, not %q); publish the addresses to another name, or change the TXT record to hand it over", e.Record, e.Owner, e.Marker)
+	}
+	return fmt.Sprintf("%s has A or AAAA records that nodedns didn't create; delete them, or add a TXT record containing %q to let nodedns take them over", e.Record, e.Marker)
+}
+
+// SkipUnchanged returns a copy of the client that remembers the addresses that it last applied to
+// each record, and skips updates that would apply the same addresses again without listing the
+// zone; resyncs of a large zone then make no API calls at all.  If verify is positive, a record
+// that hasn't been listed for that long is listed anyway, so that changes made by something else
+// are eventually undone.  Failed updates are always listed again.  Audits are never skipped.
+func (c *Client) SkipUnchanged(verify time.Duration) *Client {
+	cc := *c
+	cc.applied = &appliedRecords{verify: verify, now: time.Now, records: make(map[string]appliedSet)}
+	return &cc
+}
+
+// Audit returns a copy of the client that never changes records.  UpdateDNS only compares the
+// records to the desired addresses, and logs and reports (in dns_record_drift) the changes that it
+// would have made.
+func (c *Client) Audit() *Client {
+	cc := *c
+	cc.audit = true
+	return &cc
+}
+
+// authChecks return true if err is a provider refusing an update in a way that retrying won't fix,
+// other than with an HTTP status; and statusChecks return the HTTP status of an unsuccessful
+// response from a provider's API that caused err, or 0.  Each provider's file adds its own, so
+// that a build that leaves the provider out doesn't carry its client.
+var (
+	authChecks   []func(err error) bool
+	statusChecks []func(err error) int
+)
+
+// IsAuthError returns true if err is a provider refusing the credentials, or a DNS server refusing
+// an update or its TSIG signature, which retrying won't fix.
+func IsAuthError(err error) bool {
+	for _, check := range authChecks {
+		if check(err) {
+			return true
+		}
+	}
+	status := errorStatus(err)
+	return status == http.StatusUnauthorized || status == http.StatusForbidden
+}
+
+// errorStatus returns the HTTP status of an unsuccessful response from one of the providers' APIs
+// that caused err, or 0 if err wasn't caused by one.
+func errorStatus(err error) int {
+	var webhookErr *WebhookError
+	if errors.As(err, &webhookErr) {
+		return webhookErr.Status
+	}
+	var errRes *godo.ErrorResponse
+	if errors.As(err, &errRes) && errRes.Response != nil {
+		return errRes.Response.StatusCode
+	}
+	for _, check := range statusChecks {
+		if status := check(err); status != 0 {
+			return status
+		}
+	}
+	return 0
+}
+
+// errorClass classifies the error of a failed update for dns_update_failures.
+func errorClass(err error) string {
+	var netErr net.Error
+	switch status := errorStatus(err); {
+	case IsAuthError(err):
+		return "auth"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit"
+	case status == http.StatusGatewayTimeout || errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	}
+	return "other"
+}
+
+// OnDrift returns a copy of the client that calls f, when auditing, with the fully-qualified name
+// of each record that has drifted from the desired addresses, and the addresses that would have
+// been added and removed.
+func (c *Client) OnDrift(f func(ctx context.Context, zone, record string, add, remove []string)) *Client {
+	cc := *c
+	cc.onDrift = f
+	return &cc
+}
+
+// OnMutation returns a copy of the client that calls f with each record that it creates or
+// deletes.
+func (c *Client) OnMutation(f func(ctx context.Context, m Mutation)) *Client {
+	cc := *c
+	cc.onMutation = f
+	return &cc
+}
+
+// Parallel returns a copy of the client that creates, and then deletes, up to n records at a time,
+// rather than one at a time.
+func (c *Client) Parallel(n int) *Client {
+	cc := *c
+	cc.parallelism = n
+	return &cc
+}
+
+// manages returns true if records of the given type ("A" or "AAAA") are managed by this client.
+func (c *Client) manages(recordType string) bool {
+	return manages(c.family, recordType)
+}
+
+// manages returns true if records of the given type ("A" or "AAAA") are in the family ("ipv4",
+// "ipv6", or "" for both).
+func manages(family, recordType string) bool {
+	switch family {
+	case "ipv4":
+		return recordType == "A"
+	case "ipv6":
+		return recordType == "AAAA"
+	}
+	return recordType == "A" || recordType == "AAAA"
+}
+
+func recordType(ip net.IP) string {
+	if ip.To4() == nil {
+		return "AAAA"
+	}
+	return "A"
+}
+
+// FQDN returns the fully-qualified name of a record in the client's zone.  Records may be named
+// relative to the zone ("nodes"), absolutely ("nodes.example.com"), or "@" for the zone apex.
+func (c *Client) FQDN(record string) string {
+	return fqdn(c.zone, record)
+}
+
+// fqdn returns the fully-qualified name of a record in the zone.
+func fqdn(zone, record string) string {
+	zone = strings.TrimSuffix(zone, ".")
+	record = strings.TrimSuffix(record, ".")
+	switch {
+	case record == "@" || record == zone:
+		return zone
+	case strings.HasSuffix(record, "."+zone):
+		return record
+	}
+	return record + "." + zone
+}
+
+// listAddressRecords returns every A and AAAA record in the zone.
+func (c *Client) listAddressRecords(ctx context.Context) ([]godo.DomainRecord, error) {
+	result, _, _, err := c.listRecords(ctx, "")
+	return result, err
+}
+
+// listRecords is like listAddressRecords, but also returns every CNAME and TXT record.  If name
+// is set, only the records with that fully-qualified name are listed.
+func (c *Client) listRecords(ctx context.Context, name string) ([]godo.DomainRecord, []godo.DomainRecord, []godo.DomainRecord, error) {
+	var result, cnames, txts []godo.DomainRecord
+	for page := 1; ; page++ {
+		p, err := c.listPage(ctx, listingKey{name: name, page: page})
 		if err != nil {
-			return nil, fmt.Errorf("get page %d of records for domain %s: %w", page, c.zone, err)
+			return nil, nil, nil, fmt.Errorf("get page %d of records for domain %s: %w", page, c.zone, err)
 		}
-		for _, rec := range recs {
-			if (rec.Type == "A" || rec.Type == "AAAA") && rec.Name == name {
-				result[rec.Data] = rec.ID
-			}
+		result = append(result, p.records...)
+		cnames = append(cnames, p.cnames...)
+		txts = append(txts, p.txts...)
+		if p.last {
+			return result, cnames, txts, nil
 		}
-		if res.Links != nil && res.Links.IsLastPage() {
-			return result, nil
+	}
+}
+
+// listPage returns the A, AAAA, CNAME, and TXT records on one page of a listing.  If the page is
+// unchanged since it was last listed, the records from last time are returned; the API is asked to
+// skip sending the page with If-None-Match, and if it sends it anyway, it isn't parsed again.
+func (c *Client) listPage(ctx context.Context, key listingKey) (*listedPage, error) {
+	path := fmt.Sprintf("v2/domains/%s/records?page=%d&per_page=100", c.zone, key.page)
+	if key.name != "" {
+		path += "&name=" + url.QueryEscape(key.name)
+	}
+	req, err := c.c.NewRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	cached := c.pages.get(key)
+	if cached != nil && cached.etag != "" {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+	body := new(bytes.Buffer)
+	res, err := c.c.Do(ctx, req, body)
+	if err != nil {
+		var errRes *godo.ErrorResponse
+		if cached != nil && errors.As(err, &errRes) && errRes.Response != nil && errRes.Response.StatusCode == http.StatusNotModified {
+			dnsListedPages.WithLabelValues("digitalocean", c.zone, "not_modified").Inc()
+			return cached, nil
 		}
+		return nil, err
 	}
-	return result, errors.New("more than 100 pages!")
+	sum := sha256.Sum256(body.Bytes())
+	etag := res.Header.Get("ETag")
+	if cached != nil && cached.sum == sum {
+		dnsListedPages.WithLabelValues("digitalocean", c.zone, "unchanged").Inc()
+		if cached.etag != etag {
+			cached = &listedPage{etag: etag, sum: sum, records: cached.records, cnames: cached.cnames, txts: cached.txts, last: cached.last}
+			c.pages.put(key, cached)
+		}
+		return cached, nil
+	}
+	var listing struct {
+		Records []godo.DomainRecord `json:"domain_records"`
+		Links   *godo.Links         `json:"links"`
+	}
+	if err := json.Unmarshal(body.Bytes(), &listing); err != nil {
+		return nil, fmt.Errorf("unmarshal records: %w", err)
+	}
+	p := &listedPage{etag: etag, sum: sum, last: listing.Links == nil || listing.Links.IsLastPage()}
+	for _, rec := range listing.Records {
+		switch rec.Type {
+		case "A", "AAAA":
+			p.records = append(p.records, rec)
+		case "CNAME":
+			p.cnames = append(p.cnames, rec)
+		case "TXT":
+			p.txts = append(p.txts, rec)
+		}
+	}
+	dnsListedPages.WithLabelValues("digitalocean", c.zone, "changed").Inc()
+	c.pages.put(key, p)
+	return p, nil
 }
 
-// diffDNS diffs the desired addresses against the existing map[address]id records, and returns a
-// slice of IDs to delete, a slice of A/AAAA records to create, and a slice of the data in the
-// records to delete (for logging).
-func diffDNS(desired []net.IP, existing map[string]int) ([]int, []net.IP, []string) {
+// Canonical returns the canonical textual form of an address (RFC 5952 for IPv6 addresses), so that
+// different spellings of the same address compare equal.  Data that isn't an address is returned
+// unchanged.
+func Canonical(data string) string {
+	if ip := net.ParseIP(strings.TrimSpace(data)); ip != nil {
+		return ip.String()
+	}
+	return data
+}
+
+// getRecords returns the IDs of the A and AAAA records that the client manages with the provided
+// name, which may be absolute or relative to the zone, keyed by their canonical address; the TTL of
+// each of those records by ID; any CNAME record with the name; the TXT records with the name that
+// mark an owner; and any error listing them.  There may be more than one record for an address, if
+// they're spelled differently.
+func (c *Client) getRecords(ctx context.Context, name string) (map[string][]int, map[int]int, *godo.DomainRecord, []godo.DomainRecord, error) {
+	// The API filters by fully-qualified name, but returns names relative to the zone.
+	name = RelativeName(c.zone, name)
+	recs, cnames, txts, err := c.listRecords(ctx, c.FQDN(name))
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	var owners []godo.DomainRecord
+	for _, rec := range txts {
+		if rec.Name == name && strings.HasPrefix(strings.Trim(rec.Data, `"`), "owner=") {
+			owners = append(owners, rec)
+		}
+	}
+	result := make(map[string][]int)
+	ttls := make(map[int]int)
+	for _, rec := range recs {
+		if rec.Name == name && c.manages(rec.Type) {
+			addr := Canonical(rec.Data)
+			result[addr] = append(result[addr], rec.ID)
+			ttls[rec.ID] = rec.TTL
+		}
+	}
+	for i, rec := range cnames {
+		if rec.Name == name {
+			return result, ttls, &cnames[i], owners, nil
+		}
+	}
+	return result, ttls, nil, owners, nil
+}
+
+// checkOwner returns an error if the client keeps an ownership registry and the record, whose
+// existing A and AAAA records and ownership TXT records are provided, isn't its own.  A record with
+// no owner is free to be claimed if it has no addresses, or if the client adopts unowned records
+// and is about to publish addresses in it; emptying a record that was never claimed is refused even
+// when adopting.  It also returns the ownership TXT record, if the record is already marked as the
+// client's own.
+func (c *Client) checkOwner(record string, existing map[string][]int, owners []godo.DomainRecord, addresses []net.IP) (*godo.DomainRecord, error) {
+	if c.owner == "" {
+		return nil, nil
+	}
+	marker := OwnerMarker(c.owner)
+	for i, rec := range owners {
+		if strings.Trim(rec.Data, `"`) == marker {
+			return &owners[i], nil
+		}
+	}
+	if len(owners) > 0 {
+		return nil, &OwnershipError{Record: c.FQDN(record), Owner: strings.Trim(owners[0].Data, `"`), Marker: marker}
+	}
+	if len(existing) > 0 && (!c.adopt || len(addresses) == 0) {
+		return nil, &OwnershipError{Record: c.FQDN(record), Marker: marker}
+	}
+	return nil, nil
+}
+
+// ConflictError is returned when a record can't be updated, because a CNAME record with the same
+// name exists; DNS doesn't allow a CNAME to have other records beside it.
+type ConflictError struct {
+	Record string // The fully-qualified name of the record.
+	Target string // The CNAME record's target.
+}
+
+// Error implements error.
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s is a CNAME to %s, so A and AAAA records can't be added to it; delete the CNAME record, or publish the addresses to another name", e.Record, e.Target)
+}
+
+// diffDNS diffs the desired addresses against the existing map[canonical address]ids records, and
+// returns a slice of IDs to delete, a slice of A/AAAA records to create, and a slice of the data in
+// the records to delete (for logging).  Redundant records for a desired address are deleted.
+func diffDNS(desired []net.IP, existing map[string][]int) ([]int, []net.IP, []string) {
 	addrs := make(map[string]struct{})
 	for _, addr := range desired {
 		addrs[addr.String()] = struct{}{}
 	}
 
-	toDeleteMap := make(map[int]struct{})
+	var toDelete []int
 	var toDeleteAddrs []string
-	for ip, id := range existing {
-		if _, ok := addrs[ip]; !ok {
-			toDeleteMap[id] = struct{}{}
+	for ip, ids := range existing {
+		if _, ok := addrs[ip]; ok {
+			// Keep one record for the address.
+			ids = ids[1:]
+		}
+		for _, id := range ids {
+			toDelete = append(toDelete, id)
 			toDeleteAddrs = append(toDeleteAddrs, ip)
 		}
 	}
-	var toDelete []int
-	for id := range toDeleteMap {
-		toDelete = append(toDelete, id)
-	}
 
 	var toCreate []net.IP
+	created := make(map[string]struct{})
 	for _, addr := range desired {
-		if _, ok := existing[addr.String()]; !ok {
-			toCreate = append(toCreate, addr)
+		key := addr.String()
+		if _, ok := existing[key]; ok {
+			continue
 		}
+		if _, ok := created[key]; ok {
+			continue
+		}
+		created[key] = struct{}{}
+		toCreate = append(toCreate, addr)
 	}
 	return toDelete, toCreate, toDeleteAddrs
 }
 
-func (c *Client) UpdateDNS(ctx context.Context, record string, addresses []net.IP) error {
+// staleTTLs returns the IDs of the records that diffDNS keeps for the desired addresses whose TTL,
+// in ttls, isn't ttl, and their addresses, in order of address.
+func staleTTLs(desired map[string]bool, existing map[string][]int, ttls map[int]int, ttl int) ([]int, []string) {
+	var addrs []string
+	for addr, ids := range existing {
+		if desired[addr] && ttls[ids[0]] != ttl {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Strings(addrs)
+	ids := make([]int, len(addrs))
+	for i, addr := range addrs {
+		ids[i] = existing[addr][0]
+	}
+	return ids, addrs
+}
+
+// ttlSeconds returns the TTL of the client's records, in seconds.
+func (c *Client) ttlSeconds() int {
+	return int(c.ttl.Round(time.Second).Seconds())
+}
+
+// reportPublished sets dns_published_addresses for each type of record that the client manages.
+func (c *Client) reportPublished(record string, published map[string]bool) {
+	reportPublished("digitalocean", c.zone, c.family, record, published)
+}
+
+// managedRecords is the number of records of each type in each record, by provider, zone, record,
+// and type, for dns_records_managed; clients that manage one family only know about half of a
+// record.
+var managedRecords = struct {
+	sync.Mutex
+	counts map[[4]string]int
+}{counts: make(map[[4]string]int)}
+
+// reportPublished sets dns_published_addresses for each type of record in the family, and
+// dns_records_managed.  The series of a record with no addresses left are deleted.
+func reportPublished(provider, zone, family, record string, published map[string]bool) {
+	counts := make(map[string]int)
+	for addr := range published {
+		if ip := net.ParseIP(addr); ip != nil {
+			counts[recordType(ip)]++
+		}
+	}
+	managedRecords.Lock()
+	defer managedRecords.Unlock()
+	var total int
+	for _, t := range []string{"A", "AAAA"} {
+		key := [4]string{provider, zone, record, t}
+		if manages(family, t) {
+			dnsPublishedAddresses.WithLabelValues(provider, zone, record, t).Set(float64(counts[t]))
+			if counts[t] == 0 {
+				delete(managedRecords.counts, key)
+			} else {
+				managedRecords.counts[key] = counts[t]
+			}
+		}
+		total += managedRecords.counts[key]
+	}
+	if total == 0 {
+		// The record is gone.  Per-node and per-zone records come and go with the nodes, so
+		// their series would otherwise pile up.
+		for _, t := range []string{"A", "AAAA"} {
+			dnsPublishedAddresses.DeleteLabelValues(provider, zone, record, t)
+		}
+		dnsRecordsManaged.DeleteLabelValues(provider, zone, record)
+		return
+	}
+	dnsRecordsManaged.WithLabelValues(provider, zone, record).Set(float64(total))
+}
+
+// observeUpdate records how long an attempt to update a record took, and its result.
+func observeUpdate(ctx context.Context, provider, zone, record string, start time.Time, err error) {
+	tracing.Observe(ctx, dnsUpdateDuration.WithLabelValues(provider, zone, record), time.Since(start).Seconds())
+	if err != nil {
+		dnsUpdateFailures.WithLabelValues(provider, zone, record, errorClass(err)).Inc()
+		return
+	}
+	dnsLastSuccess.WithLabelValues(provider, zone, record).SetToCurrentTime()
+}
+
+// UpdateDNS implements Provider.  It replaces the A and AAAA records that the client manages with
+// the provided name, absolute or relative to the zone, with one record per address, creating every
+// new record before deleting any old ones so that the name never stops resolving, and fixing the
+// TTL of records that are kept.  A create-only client never deletes records; an auditing client
+// only reports how the record differs.  With an owner, records that are marked as owned by someone
+// else, or that have addresses but no owner and aren't being adopted, are refused with an
+// OwnershipError (see checkOwner), and the name is claimed before anything is created in it.  With
+// SkipUnchanged, an update with the addresses that
+// were last applied successfully is skipped without listing the zone.
+func (c *Client) UpdateDNS(ctx context.Context, record string, addresses []net.IP) (err error) {
 	if record == "" {
 		return nil
 	}
-	span, ctx := opentracing.StartSpanFromContext(ctx, "digitalocean_dns_update")
-	defer span.Finish()
+	// The API names records relative to the zone; metrics and logs use the name that was passed.
+	name := RelativeName(c.zone, record)
+	ctx, span := tracing.Start(ctx, "digitalocean_dns_update")
+	defer span.End()
 	dnsUpdateAttempts.WithLabelValues("digitalocean", c.zone, record).Inc()
+	defer func(start time.Time) {
+		observeUpdate(ctx, "digitalocean", c.zone, record, start, err)
+	}(time.Now())
+	l := zap.L().Named("digitalocean-dns").With(correlation.Field(ctx))
 
-	existing, err := c.getRecords(ctx, record)
+	if c.family != "" {
+		var managed []net.IP
+		for _, ip := range addresses {
+			if c.manages(recordType(ip)) {
+				managed = append(managed, ip)
+			}
+		}
+		addresses = managed
+	}
+	if !c.audit && c.applied.unchanged(c.family, name, addresses) {
+		dnsUpdatesSkipped.WithLabelValues("digitalocean", c.zone, record).Inc()
+		return nil
+	}
+	// Until this update succeeds, the record's contents are unknown.
+	c.applied.forget(c.family, name)
+
+	existing, ttls, cname, owners, err := c.getRecords(ctx, name)
 	if err != nil {
 		return fmt.Errorf("get existing records: %w", err)
 	}
 	toDelete, toCreate, toDeleteAddrs := diffDNS(addresses, existing)
-	if len(toDelete) > 0 || len(toCreate) > 0 {
-		zap.L().Named("digitalocean-dns").Debug("dns changes needed", zap.Any("to_create", toCreate), zap.Strings("to_delete", toDeleteAddrs))
+	desired := make(map[string]bool, len(addresses))
+	for _, ip := range addresses {
+		desired[ip.String()] = true
+	}
+	// Records that are kept, but whose TTL was set by hand or by an earlier --ttl, are edited.
+	toFix, toFixAddrs := staleTTLs(desired, existing, ttls, c.ttlSeconds())
+	// published tracks what the record contains as changes are made, even if some of them fail.
+	published := make(map[string]bool, len(existing))
+	for addr := range existing {
+		published[addr] = true
+	}
+	defer c.reportPublished(record, published)
+	if cname != nil {
+		dnsRecordConflict.WithLabelValues("digitalocean", c.zone, record).Set(1)
+	} else {
+		dnsRecordConflict.WithLabelValues("digitalocean", c.zone, record).Set(0)
+	}
+	if c.audit {
+		dnsRecordDrift.WithLabelValues("digitalocean", c.zone, record).Set(float64(len(toDelete) + len(toCreate)))
+		if len(toDelete) > 0 || len(toCreate) > 0 {
+			l.Info("dns record drifted; auditing, so not changing it", zap.String("record", c.FQDN(record)), zap.Any("would_add", toCreate), zap.Strings("would_remove", toDeleteAddrs))
+			if c.onDrift != nil {
+				var add []string
+				for _, ip := range toCreate {
+					add = append(add, ip.String())
+				}
+				c.onDrift(ctx, c.zone, c.FQDN(record), add, toDeleteAddrs)
+			}
+		}
+		if len(toFix) > 0 {
+			l.Info("dns record ttl drifted; auditing, so not changing it", zap.String("record", c.FQDN(record)), zap.Strings("addresses", toFixAddrs), zap.Int("ttl", c.ttlSeconds()))
+		}
+		return nil
+	}
+	ownerRecord, err := c.checkOwner(record, existing, owners, addresses)
+	if err != nil {
+		dnsRecordUnowned.WithLabelValues("digitalocean", c.zone, record).Set(1)
+		return err
+	}
+	dnsRecordUnowned.WithLabelValues("digitalocean", c.zone, record).Set(0)
+	if c.createOnly && len(toDelete) > 0 {
+		l.Info("create-only; not deleting records", zap.String("record", c.FQDN(record)), zap.Strings("would_delete", toDeleteAddrs))
+		dnsRecordsDeleteSkipped.WithLabelValues("digitalocean", c.zone, record).Add(float64(len(toDelete)))
+		toDelete, toDeleteAddrs = nil, nil
+	}
+	if cname != nil && len(toCreate) > 0 {
+		// DigitalOcean would reject the creation with an unhelpful error.
+		return &ConflictError{Record: c.FQDN(record), Target: cname.Data}
+	}
+	if len(toDelete) > 0 || len(toCreate) > 0 || len(toFix) > 0 {
+		l.Debug("dns changes needed", zap.Any("to_create", toCreate), zap.Strings("to_delete", toDeleteAddrs), zap.Strings("to_fix_ttl", toFixAddrs))
+	}
+	if adopted := c.seen.adopt(name, existing, desired); len(adopted) > 0 {
+		// Records made by hand or by another tool are kept in place, rather than being
+		// recreated, so that they never stop resolving.
+		l.Info("adopted existing records", zap.String("record", c.FQDN(record)), zap.Strings("addresses", adopted))
 	}
 
-	for _, ip := range toCreate {
-		kind := "A"
-		if ip.To4() == nil {
-			kind = "AAAA"
+	// Log what was actually changed, even if only some of the changes could be made, so that
+	// standard log pipelines capture every change without debug logging.
+	var added, removed, fixed []string
+	var duplicates int
+	defer func() {
+		if len(added)+len(removed)+len(fixed)+duplicates == 0 {
+			return
+		}
+		l.Info("dns record changed", zap.String("record", c.FQDN(record)), zap.Strings("added", added), zap.Strings("removed", removed), zap.Strings("ttl_fixed", fixed), zap.Int("duplicates_removed", duplicates), zap.Int("addresses", len(addresses)))
+	}()
+
+	adopting := c.owner != "" && ownerRecord == nil && len(existing) > 0 && len(addresses) > 0
+	if c.owner != "" && ownerRecord == nil && (len(toCreate) > 0 || adopting) {
+		// Claim the record before creating anything in it.
+		rec, _, err := c.c.Domains.CreateRecord(ctx, c.zone, &godo.DomainRecordEditRequest{
+			Name: name,
+			Data: OwnerMarker(c.owner),
+			TTL:  c.ttlSeconds(),
+			Type: "TXT",
+		})
+		if err != nil {
+			return fmt.Errorf("creating ownership record: %w", err)
+		}
+		ownerRecord = rec
+		if adopting {
+			l.Info("adopted unowned record", zap.String("record", c.FQDN(record)), zap.String("owner", OwnerMarker(c.owner)))
+		} else {
+			l.Info("claimed record", zap.String("record", c.FQDN(record)), zap.String("owner", OwnerMarker(c.owner)))
 		}
-		_, _, err := c.c.Domains.CreateRecord(ctx, c.zone, &godo.DomainRecordEditRequest{
-			Name: record,
+	}
+	// Every creation finishes before anything is deleted, so that the record is never empty.
+	errs := parallel(len(toCreate), c.parallelism, func(i int) error {
+		ip := toCreate[i]
+		kind := recordType(ip)
+		rec, _, err := c.c.Domains.CreateRecord(ctx, c.zone, &godo.DomainRecordEditRequest{
+			Name: name,
 			Data: ip.String(),
-			TTL:  int(c.ttl.Round(time.Second).Seconds()),
+			TTL:  c.ttlSeconds(),
 			Type: kind,
 		})
 		if err != nil {
 			return fmt.Errorf("creating record %s %s: %w", kind, ip.String(), err)
 		}
 		dnsRecordsCreated.WithLabelValues("digitalocean", c.zone, record).Inc()
-		zap.L().Debug("created record")
+		mutated(ctx, c.onMutation, Mutation{Action: "create", Provider: "digitalocean", Zone: c.zone, Record: c.FQDN(record), Address: ip.String(), ID: strconv.Itoa(rec.ID)})
+		return nil
+	})
+	for i, ip := range toCreate {
+		if errs[i] == nil {
+			added = append(added, ip.String())
+			published[ip.String()] = true
+		}
+	}
+	if err := firstError(errs); err != nil {
+		return err
+	}
+	errs = parallel(len(toFix), c.parallelism, func(i int) error {
+		ip := net.ParseIP(toFixAddrs[i])
+		if _, _, err := c.c.Domains.EditRecord(ctx, c.zone, toFix[i], &godo.DomainRecordEditRequest{
+			Name: name,
+			Data: ip.String(),
+			TTL:  c.ttlSeconds(),
+			Type: recordType(ip),
+		}); err != nil {
+			return fmt.Errorf("updating ttl of record id %d: %w", toFix[i], err)
+		}
+		dnsRecordsTTLFixed.WithLabelValues("digitalocean", c.zone, record).Inc()
+		return nil
+	})
+	for i, addr := range toFixAddrs {
+		if errs[i] == nil {
+			fixed = append(fixed, addr)
+		}
+	}
+	if err := firstError(errs); err != nil {
+		return err
 	}
-	for _, id := range toDelete {
+	errs = parallel(len(toDelete), c.parallelism, func(i int) error {
+		id := toDelete[i]
 		if _, err := c.c.Domains.DeleteRecord(ctx, c.zone, id); err != nil {
 			return fmt.Errorf("deleting record id %d: %w", id, err)
 		}
 		dnsRecordsDeleted.WithLabelValues("digitalocean", c.zone, record).Inc()
-		zap.L().Debug("deleted record")
+		mutated(ctx, c.onMutation, Mutation{Action: "delete", Provider: "digitalocean", Zone: c.zone, Record: c.FQDN(record), Address: toDeleteAddrs[i], ID: strconv.Itoa(id)})
+		return nil
+	})
+	for i, addr := range toDeleteAddrs {
+		switch {
+		case errs[i] != nil:
+		case desired[addr]:
+			// Another record still contains the address.
+			duplicates++
+		default:
+			removed = append(removed, addr)
+			delete(published, addr)
+		}
+	}
+	if err := firstError(errs); err != nil {
+		return err
+	}
+	if ownerRecord != nil && len(published) == 0 && c.family == "" && !c.createOnly {
+		// The record is gone; release the name.  Clients that only manage one family can't
+		// tell whether the other family's records are gone too, so they leave the marker.
+		if _, err := c.c.Domains.DeleteRecord(ctx, c.zone, ownerRecord.ID); err != nil {
+			return fmt.Errorf("deleting ownership record: %w", err)
+		}
+		l.Info("released record", zap.String("record", c.FQDN(record)), zap.String("owner", OwnerMarker(c.owner)))
 	}
 
+	c.applied.set(c.family, name, addresses)
 	dnsUpdatedOK.WithLabelValues("digitalocean", c.zone, record).Inc()
 	return nil
 }
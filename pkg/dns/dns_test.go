@@ -1,24 +1,27 @@
 package dns
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net"
 	"net/http"
-	"strings"
+	"net/url"
+	"sort"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/digitalocean/godo"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
-	"github.com/jrockway/opinionated-server/client"
+	"github.com/jrockway/nodedns/pkg/digitalocean"
+	"github.com/jrockway/nodedns/pkg/fakedo"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"go.uber.org/zap/zaptest"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func lessIPs(a, b net.IP) bool {
@@ -27,7 +30,7 @@ func lessIPs(a, b net.IP) bool {
 
 func TestDiffDNS(t *testing.T) {
 	testData := []struct {
-		existing   map[string]int
+		existing   map[string][]int
 		desired    []net.IP
 		wantDelete []int
 		wantCreate []net.IP
@@ -39,41 +42,66 @@ func TestDiffDNS(t *testing.T) {
 			wantCreate: nil,
 		},
 		{
-			existing:   map[string]int{},
+			existing:   map[string][]int{},
 			desired:    []net.IP{net.IPv4(1, 2, 3, 4), net.IPv4(1, 2, 3, 5)},
 			wantDelete: nil,
 			wantCreate: []net.IP{net.IPv4(1, 2, 3, 4), net.IPv4(1, 2, 3, 5)},
 		},
 		{
-			existing:   map[string]int{"1.2.3.4": 1234},
+			existing:   map[string][]int{"1.2.3.4": {1234}},
 			desired:    nil,
 			wantDelete: []int{1234},
 			wantCreate: nil,
 		},
 		{
-			existing:   map[string]int{"1.2.3.4": 1234},
+			existing:   map[string][]int{"1.2.3.4": {1234}},
 			desired:    []net.IP{net.IPv4(1, 2, 3, 4)},
 			wantDelete: nil,
 			wantCreate: nil,
 		},
 		{
-			existing:   map[string]int{"1.2.3.4": 1234},
+			existing:   map[string][]int{"1.2.3.4": {1234}},
 			desired:    []net.IP{net.IPv4(1, 2, 3, 5)},
 			wantDelete: []int{1234},
 			wantCreate: []net.IP{net.IPv4(1, 2, 3, 5)},
 		},
 		{
-			existing:   map[string]int{"1.2.3.4": 1234, "1.2.3.5": 1235},
+			existing:   map[string][]int{"1.2.3.4": {1234}, "1.2.3.5": {1235}},
 			desired:    []net.IP{net.IPv4(1, 2, 3, 5), net.IPv4(1, 2, 3, 6)},
 			wantDelete: []int{1234},
 			wantCreate: []net.IP{net.IPv4(1, 2, 3, 6)},
 		},
 		{
-			existing:   map[string]int{"1.2.3.4": 1234},
+			existing:   map[string][]int{"1.2.3.4": {1234}},
 			desired:    []net.IP{net.IPv4(1, 2, 3, 4).To16()},
 			wantDelete: nil,
 			wantCreate: nil,
 		},
+		{
+			existing:   map[string][]int{"2001:db8::1": {1234, 1235}},
+			desired:    []net.IP{net.ParseIP("2001:db8::1")},
+			wantDelete: []int{1235},
+			wantCreate: nil,
+		},
+		{
+			existing:   map[string][]int{},
+			desired:    []net.IP{net.IPv4(1, 2, 3, 4), net.IPv4(1, 2, 3, 4).To4()},
+			wantDelete: nil,
+			wantCreate: []net.IP{net.IPv4(1, 2, 3, 4)},
+		},
+		{
+			// Publishing only one family removes the other.
+			existing:   map[string][]int{"1.2.3.4": {1234}, "2001:db8::1": {1235}},
+			desired:    []net.IP{net.IPv4(1, 2, 3, 4)},
+			wantDelete: []int{1235},
+			wantCreate: nil,
+		},
+		{
+			existing:   map[string][]int{"1.2.3.4": {1234}, "2001:db8::1": {1235}},
+			desired:    []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")},
+			wantDelete: []int{1234},
+			wantCreate: []net.IP{net.ParseIP("2001:db8::2")},
+		},
 	}
 
 	for i, test := range testData {
@@ -87,106 +115,852 @@ func TestDiffDNS(t *testing.T) {
 	}
 }
 
-type testTransport struct {
-	t     *testing.T
-	pause time.Duration
-	err   error
+func TestUpdateDNS(t *testing.T) {
+	l := zaptest.NewLogger(t, zaptest.Level(zapcore.DebugLevel))
+	zap.ReplaceGlobals(l)
+	s := fakedo.New("example.com")
+	defer s.Close()
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "nodes", Data: "10.0.0.1"})
+	// Enough unrelated records to need several pages.
+	for i := 0; i < 250; i++ {
+		s.AddRecord("example.com", godo.DomainRecord{Type: "TXT", Name: fmt.Sprintf("txt-%d", i), Data: "hello"})
+	}
+	ctx := context.Background()
+	c, err := NewClientFromGodo(ctx, s.Client(), "example.com", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test a "change" flow.
+	if err := c.UpdateDNS(ctx, "nodes.example.com", []net.IP{net.IPv4(1, 2, 3, 4)}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"nodes": {"1.2.3.4"}}
+	if diff := cmp.Diff(s.Addresses("example.com"), want); diff != "" {
+		t.Errorf("after change:\n%s", diff)
+	}
+
+	// Test the change flow with a context that expires.
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	s.InjectFault(func(*http.Request) *fakedo.Fault { return &fakedo.Fault{Delay: time.Second} })
+	err = c.UpdateDNS(ctx, "nodes.example.com", []net.IP{net.IPv4(10, 0, 0, 1)})
+	if err == nil {
+		t.Fatal("expected error, but got success")
+	}
+	cancel()
 }
 
-func (t *testTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	if t.pause != 0 {
-		t.t.Logf("pause %v", t.pause.String())
-		time.Sleep(t.pause)
+func TestListingCache(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	s := fakedo.New("example.com")
+	defer s.Close()
+	for i := 0; i < 150; i++ {
+		s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "nodes", Data: fmt.Sprintf("10.0.%d.%d", i/256, i%256)})
 	}
-	if t.err != nil {
-		t.t.Logf("transport erroring: %v", t.err)
-		return nil, t.err
+	ctx := context.Background()
+	c, err := NewClientFromGodo(ctx, s.Client(), "example.com", time.Minute)
+	if err != nil {
+		t.Fatal(err)
 	}
-	if err := req.Context().Err(); err != nil {
-		return nil, err
+	pages := func(result string) float64 {
+		return testutil.ToFloat64(dnsListedPages.WithLabelValues("digitalocean", "example.com", result))
 	}
-	if req.URL.Path == "/v2/domains/example.com/records/1" {
-		if req.Method == "DELETE" {
-			return &http.Response{
-				StatusCode: http.StatusNoContent,
-				Status:     "204 No Content",
-				Body:       jsonReader(make(map[string]interface{})),
-			}, nil
+	list := func(want int) {
+		t.Helper()
+		recs, err := c.listAddressRecords(ctx)
+		if err != nil {
+			t.Fatalf("list: %v", err)
 		}
+		if got := len(recs); got != want {
+			t.Errorf("records:\n  got: %v\n want: %v", got, want)
+		}
+	}
+	changed, notModified := pages("changed"), pages("not_modified")
+	list(150)
+	if got, want := pages("changed")-changed, 2.0; got != want {
+		t.Errorf("changed pages after first listing:\n  got: %v\n want: %v", got, want)
+	}
+	list(150)
+	if got, want := pages("not_modified")-notModified, 2.0; got != want {
+		t.Errorf("unmodified pages after second listing:\n  got: %v\n want: %v", got, want)
+	}
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "nodes", Data: "10.1.0.1"})
+	list(151)
+	// Both pages change, because each contains the total number of records.
+	if got, want := pages("changed")-changed, 4.0; got != want {
+		t.Errorf("changed pages after adding a record:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+func TestUpdateDNSSkipUnchanged(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	s := fakedo.New("example.com")
+	defer s.Close()
+	ctx := context.Background()
+	c, err := NewClientFromGodo(ctx, s.Client(), "example.com", time.Minute)
+	if err != nil {
+		t.Fatal(err)
 	}
-	if req.URL.Path == "/v2/domains/example.com/records" {
-		if req.Method == "POST" {
-			return &http.Response{
-				StatusCode: http.StatusCreated,
-				Status:     "201 Created",
-				Body:       jsonReader(make(map[string]interface{})),
-			}, nil
+	c = c.SkipUnchanged(time.Hour)
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	c.applied.now = func() time.Time { return now }
+	ips := []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)}
+	update := func(ips []net.IP, wantRequests int) {
+		t.Helper()
+		before := s.Requests()
+		if err := c.UpdateDNS(ctx, "nodes", ips); err != nil {
+			t.Fatalf("update: %v", err)
 		}
-		if page := req.URL.Query().Get("page"); page == "1" || page == "" {
-			return &http.Response{
-				StatusCode: http.StatusOK,
-				Status:     "200 OK",
-				Body: jsonReader(map[string]interface{}{
-					"domain_records": []godo.DomainRecord{
-						{
-							ID:   1,
-							Type: "A",
-							Name: "nodes.example.com",
-							Data: "10.0.0.1",
-						},
-					},
-					"meta": godo.Meta{},
-					"links": godo.Links{
-						Pages: &godo.Pages{},
-					},
-				}),
-			}, nil
-		} else {
-			return &http.Response{
-				StatusCode: http.StatusNotFound,
-				Status:     "404 Not Found",
-				Body:       io.NopCloser(strings.NewReader("not found")),
-			}, nil
+		if got := s.Requests() - before; got != wantRequests {
+			t.Errorf("requests:\n  got: %v\n want: %v", got, wantRequests)
 		}
 	}
-	panic("no error or response to return")
+	// The first update lists the zone and creates two records; the second, with the same
+	// addresses in another order, makes no requests.
+	update(ips, 3)
+	update([]net.IP{net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 1)}, 0)
+	// Something else adds a record; it's noticed after the verify interval.
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "nodes", Data: "10.0.0.3"})
+	update(ips, 0)
+	now = now.Add(time.Hour)
+	update(ips, 2) // List, and delete the extra record.
+	update(ips[:1], 2)
+
+	// A failed update is listed again.
+	s.InjectFault(func(req *http.Request) *fakedo.Fault {
+		if req.Method == http.MethodPost {
+			return &fakedo.Fault{Status: http.StatusInternalServerError}
+		}
+		return nil
+	})
+	if err := c.UpdateDNS(ctx, "nodes", ips); err == nil {
+		t.Error("expected error from injected fault")
+	}
+	s.InjectFault(nil)
+	update(ips, 2)
+
+	want := map[string][]string{"nodes": {"10.0.0.1", "10.0.0.2"}}
+	if diff := cmp.Diff(s.Addresses("example.com"), want); diff != "" {
+		t.Errorf("addresses:\n%s", diff)
+	}
 }
 
-func jsonReader(obj map[string]interface{}) io.ReadCloser {
-	buf := new(bytes.Buffer)
-	b, err := json.Marshal(obj)
+func TestUpdateDNSFiltered(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	s := fakedo.New("example.com")
+	defer s.Close()
+	// More than 100 pages of unrelated records.
+	for i := 0; i < 10050; i++ {
+		s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: fmt.Sprintf("host-%d", i), Data: "10.0.0.1"})
+	}
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "nodes", Data: "10.0.0.1"})
+	ctx := context.Background()
+	c, err := NewClientFromGodo(ctx, s.Client(), "example.com", time.Minute)
 	if err != nil {
-		panic(fmt.Sprintf("invalid json: %v", err))
+		t.Fatal(err)
+	}
+	before := s.Requests()
+	if err := c.UpdateDNS(ctx, "nodes", []net.IP{net.IPv4(10, 0, 0, 2)}); err != nil {
+		t.Fatal(err)
+	}
+	// Only the record's own page is listed, before one record is created and another deleted.
+	if got, want := s.Requests()-before, 3; got != want {
+		t.Errorf("requests:\n  got: %v\n want: %v", got, want)
+	}
+	recs, err := c.listAddressRecords(ctx)
+	if err != nil {
+		t.Fatalf("list the whole zone: %v", err)
+	}
+	if got, want := len(recs), 10051; got != want {
+		t.Errorf("records in the zone:\n  got: %v\n want: %v", got, want)
 	}
-	buf.Write(b)
-	return io.NopCloser(buf)
 }
 
-func TestUpdateDNS(t *testing.T) {
-	l := zaptest.NewLogger(t, zaptest.Level(zapcore.DebugLevel))
+func TestUpdateDNSLostCreate(t *testing.T) {
+	l := zaptest.NewLogger(t)
 	zap.ReplaceGlobals(l)
-	tr := &testTransport{t: t}
-	doc := godo.NewClient(&http.Client{
-		Transport: client.WrapRoundTripper(tr),
+	s := fakedo.New("example.com")
+	defer s.Close()
+	// The first create is applied, but its response is lost, so the create is retried.
+	lost := false
+	s.InjectFault(func(req *http.Request) *fakedo.Fault {
+		if req.Method != http.MethodPost || lost {
+			return nil
+		}
+		lost = true
+		return &fakedo.Fault{Status: http.StatusBadGateway, Lost: true}
 	})
-	c := &Client{
-		c:    doc,
-		zone: "example.com",
-		ttl:  time.Second,
+	gc := digitalocean.NewGodoClientWithTransport("", (&digitalocean.RetryConfig{RetryMax: 1, RetryWaitMin: time.Millisecond, RetryWaitMax: time.Millisecond}).Wrap(nil))
+	u, err := url.Parse(s.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gc.BaseURL = u
+	ctx := context.Background()
+	c, err := NewClientFromGodo(ctx, gc, "example.com", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UpdateDNS(ctx, "nodes", []net.IP{net.IPv4(1, 2, 3, 4)}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if !lost {
+		t.Error("fault was not injected")
 	}
+	// DigitalOcean has no idempotency keys, so the retried create duplicates the record, and the
+	// next update removes the duplicate.
+	want := map[string][]string{"nodes": {"1.2.3.4", "1.2.3.4"}}
+	if diff := cmp.Diff(s.Addresses("example.com"), want); diff != "" {
+		t.Errorf("addresses after the retried create:\n%s", diff)
+	}
+	if err := c.UpdateDNS(ctx, "nodes", []net.IP{net.IPv4(1, 2, 3, 4)}); err != nil {
+		t.Fatalf("update again: %v", err)
+	}
+	want = map[string][]string{"nodes": {"1.2.3.4"}}
+	if diff := cmp.Diff(s.Addresses("example.com"), want); diff != "" {
+		t.Errorf("addresses after the next update:\n%s", diff)
+	}
+}
 
-	// Test a "change" flow.
+func TestUpdateDNSAbsoluteName(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	s := fakedo.New("example.com")
+	defer s.Close()
+	// The API returns names relative to the zone.
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "nodes", Data: "10.0.0.1", TTL: 60})
+	s.AddRecord("example.com", godo.DomainRecord{Type: "TXT", Name: "nodes", Data: OwnerMarker("main")})
 	ctx := context.Background()
+	c, err := NewClientFromGodo(ctx, s.Client(), "example.com", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = c.WithOwner("main")
+	for _, name := range []string{"nodes.example.com", "nodes.example.com.", "nodes"} {
+		if err := c.UpdateDNS(ctx, name, []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)}); err != nil {
+			t.Fatalf("update %s: %v", name, err)
+		}
+		// The existing record is kept rather than duplicated, and its owner is recognized.
+		want := map[string][]string{"nodes": {"10.0.0.1", "10.0.0.2"}}
+		if diff := cmp.Diff(s.Addresses("example.com"), want); diff != "" {
+			t.Errorf("after updating %s:\n%s", name, diff)
+		}
+	}
+	if got, want := len(s.Records("example.com")), 3; got != want {
+		t.Errorf("records:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+func TestUpdateDNSFamily(t *testing.T) {
+	l := zaptest.NewLogger(t, zaptest.Level(zapcore.DebugLevel))
+	zap.ReplaceGlobals(l)
+	s := fakedo.New("example.com")
+	defer s.Close()
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "nodes.example.com", Data: "10.0.0.1"})
+	s.AddRecord("example.com", godo.DomainRecord{Type: "AAAA", Name: "nodes.example.com", Data: "2001:db8::1"})
+	ctx := context.Background()
+	c, err := NewClientFromGodo(ctx, s.Client(), "example.com", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v4 := c.WithFamily("ipv4")
+	if err := v4.UpdateDNS(ctx, "nodes.example.com", []net.IP{net.IPv4(1, 2, 3, 4), net.ParseIP("2001:db8::2")}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"nodes": {"1.2.3.4", "2001:db8::1"}}
+	if diff := cmp.Diff(s.Addresses("example.com"), want); diff != "" {
+		t.Errorf("after ipv4 update:\n%s", diff)
+	}
+}
+
+func TestUpdateDNSPublished(t *testing.T) {
+	l := zaptest.NewLogger(t, zaptest.Level(zapcore.DebugLevel))
+	zap.ReplaceGlobals(l)
+	s := fakedo.New("example.com")
+	defer s.Close()
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "published.example.com", Data: "10.0.0.1"})
+	s.AddRecord("example.com", godo.DomainRecord{Type: "AAAA", Name: "published.example.com", Data: "2001:db8::1"})
+	ctx := context.Background()
+	c, err := NewClientFromGodo(ctx, s.Client(), "example.com", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	published := func() map[string]float64 {
+		return map[string]float64{
+			"A":       testutil.ToFloat64(dnsPublishedAddresses.WithLabelValues("digitalocean", "example.com", "published.example.com", "A")),
+			"AAAA":    testutil.ToFloat64(dnsPublishedAddresses.WithLabelValues("digitalocean", "example.com", "published.example.com", "AAAA")),
+			"managed": testutil.ToFloat64(dnsRecordsManaged.WithLabelValues("digitalocean", "example.com", "published.example.com")),
+		}
+	}
+
+	if err := c.UpdateDNS(ctx, "published.example.com", []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), net.ParseIP("2001:db8::1")}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := published(), map[string]float64{"A": 2, "AAAA": 1, "managed": 3}; !cmp.Equal(got, want) {
+		t.Errorf("after growing:\n  got: %v\n want: %v", got, want)
+	}
+
+	// Only the managed family is reported by a single-family client.
+	if err := c.WithFamily("ipv6").UpdateDNS(ctx, "published.example.com", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := published(), map[string]float64{"A": 2, "AAAA": 0, "managed": 2}; !cmp.Equal(got, want) {
+		t.Errorf("after removing ipv6:\n  got: %v\n want: %v", got, want)
+	}
+
+	// A create-only client reports the addresses that it couldn't delete.
+	if err := c.CreateOnly().UpdateDNS(ctx, "published.example.com", []net.IP{net.IPv4(10, 0, 0, 3)}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := published(), map[string]float64{"A": 3, "AAAA": 0, "managed": 3}; !cmp.Equal(got, want) {
+		t.Errorf("after create-only update:\n  got: %v\n want: %v", got, want)
+	}
+
+	// An emptied record's series are deleted.
+	if err := c.UpdateDNS(ctx, "published.example.com", nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, typ := range []string{"A", "AAAA"} {
+		if dnsPublishedAddresses.DeleteLabelValues("digitalocean", "example.com", "published.example.com", typ) {
+			t.Errorf("after emptying: expected the %s series to be deleted", typ)
+		}
+	}
+	if dnsRecordsManaged.DeleteLabelValues("digitalocean", "example.com", "published.example.com") {
+		t.Error("after emptying: expected the managed records series to be deleted")
+	}
+}
+
+func TestUpdateDNSMutations(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	s := fakedo.New("example.com")
+	defer s.Close()
+	old := s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "nodes.example.com", Data: "10.0.0.1"})
+	ctx := context.Background()
+	c, err := NewClientFromGodo(ctx, s.Client(), "example.com", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []Mutation
+	c = c.OnMutation(func(ctx context.Context, m Mutation) { got = append(got, m) })
+	if err := c.UpdateDNS(ctx, "nodes.example.com", []net.IP{net.ParseIP("2001:db8::1")}); err != nil {
+		t.Fatal(err)
+	}
+	records := s.Records("example.com")
+	if len(records) != 1 {
+		t.Fatalf("records:\n  got: %v\n want: one", records)
+	}
+	want := []Mutation{
+		{Action: "create", Provider: "digitalocean", Zone: "example.com", Record: "nodes.example.com", Type: "AAAA", Address: "2001:db8::1", ID: strconv.Itoa(records[0].ID)},
+		{Action: "delete", Provider: "digitalocean", Zone: "example.com", Record: "nodes.example.com", Type: "A", Address: "10.0.0.1", ID: strconv.Itoa(old)},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("mutations:\n%s", diff)
+	}
+}
+
+func TestUpdateDNSParallel(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	s := fakedo.New("example.com")
+	defer s.Close()
+	for i := 1; i <= 10; i++ {
+		s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "nodes.example.com", Data: fmt.Sprintf("10.0.0.%d", i)})
+	}
+	ctx := context.Background()
+	c, err := NewClientFromGodo(ctx, s.Client(), "example.com", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = c.Parallel(4)
+	var addresses []net.IP
+	want := map[string][]string{"nodes": nil}
+	for i := 1; i <= 20; i++ {
+		addresses = append(addresses, net.IPv4(10, 0, 1, byte(i)))
+		want["nodes"] = append(want["nodes"], fmt.Sprintf("10.0.1.%d", i))
+	}
+	sort.Strings(want["nodes"])
+	if err := c.UpdateDNS(ctx, "nodes.example.com", addresses); err != nil {
+		t.Fatal(err)
+	}
+	got := s.Addresses("example.com")
+	sort.Strings(got["nodes"])
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("after update:\n%s", diff)
+	}
+}
+
+func TestUpdateDNSCreateOnly(t *testing.T) {
+	l := zaptest.NewLogger(t, zaptest.Level(zapcore.DebugLevel))
+	zap.ReplaceGlobals(l)
+	s := fakedo.New("example.com")
+	defer s.Close()
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "nodes.example.com", Data: "10.0.0.1"})
+	ctx := context.Background()
+	c, err := NewClientFromGodo(ctx, s.Client(), "example.com", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateOnly().UpdateDNS(ctx, "nodes.example.com", []net.IP{net.IPv4(1, 2, 3, 4)}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"nodes": {"1.2.3.4", "10.0.0.1"}}
+	if diff := cmp.Diff(s.Addresses("example.com"), want); diff != "" {
+		t.Errorf("after create-only update:\n%s", diff)
+	}
 	if err := c.UpdateDNS(ctx, "nodes.example.com", []net.IP{net.IPv4(1, 2, 3, 4)}); err != nil {
 		t.Fatal(err)
 	}
+	want = map[string][]string{"nodes": {"1.2.3.4"}}
+	if diff := cmp.Diff(s.Addresses("example.com"), want); diff != "" {
+		t.Errorf("after normal update:\n%s", diff)
+	}
+}
 
-	// Test the change flow with a context that expires.
-	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
-	tr.pause = time.Second
-	err := c.UpdateDNS(ctx, "nodes.example.com", []net.IP{net.IPv4(10, 0, 0, 1)})
-	if err == nil {
-		t.Fatal("expected error, but got success")
+func TestUpdateDNSAdopt(t *testing.T) {
+	l := zaptest.NewLogger(t, zaptest.Level(zapcore.DebugLevel))
+	zap.ReplaceGlobals(l)
+	s := fakedo.New("example.com")
+	defer s.Close()
+	// Records made by hand, with a TTL that nodedns wouldn't use.
+	id := s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "nodes", Data: "10.0.0.1", TTL: 3600})
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "nodes", Data: "10.0.0.9", TTL: 3600})
+	ctx := context.Background()
+	c, err := NewClientFromGodo(ctx, s.Client(), "example.com", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UpdateDNS(ctx, "nodes", []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"nodes": {"10.0.0.1", "10.0.0.2"}}
+	if diff := cmp.Diff(s.Addresses("example.com"), want); diff != "" {
+		t.Errorf("addresses:\n%s", diff)
+	}
+	var adopted bool
+	for _, rec := range s.Records("example.com") {
+		if rec.ID == id {
+			adopted = true
+			// Adopted records get the configured TTL.
+			if got, want := rec.TTL, 60; got != want {
+				t.Errorf("adopted record ttl:\n  got: %v\n want: %v", got, want)
+			}
+		}
+	}
+	if !adopted {
+		t.Errorf("record %d was recreated instead of adopted", id)
+	}
+}
+
+func TestUpdateDNSConflict(t *testing.T) {
+	l := zaptest.NewLogger(t, zaptest.Level(zapcore.DebugLevel))
+	zap.ReplaceGlobals(l)
+	s := fakedo.New("example.com")
+	defer s.Close()
+	s.AddRecord("example.com", godo.DomainRecord{Type: "CNAME", Name: "nodes", Data: "lb.example.net."})
+	ctx := context.Background()
+	c, err := NewClientFromGodo(ctx, s.Client(), "example.com", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conflict := func() float64 {
+		return testutil.ToFloat64(dnsRecordConflict.WithLabelValues("digitalocean", "example.com", "nodes"))
+	}
+	err = c.UpdateDNS(ctx, "nodes", []net.IP{net.IPv4(1, 2, 3, 4)})
+	var ce *ConflictError
+	if !errors.As(err, &ce) {
+		t.Fatalf("update:\n  got: %v\n want: a ConflictError", err)
+	}
+	if got, want := ce.Target, "lb.example.net."; got != want {
+		t.Errorf("target:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := conflict(), 1.0; got != want {
+		t.Errorf("conflict metric:\n  got: %v\n want: %v", got, want)
+	}
+
+	// Nothing needs to be added, so there's nothing to conflict with.
+	if err := c.UpdateDNS(ctx, "nodes", nil); err != nil {
+		t.Errorf("update with no addresses: %v", err)
+	}
+	if err := c.UpdateDNS(ctx, "other", []net.IP{net.IPv4(1, 2, 3, 4)}); err != nil {
+		t.Errorf("update of another record: %v", err)
+	}
+}
+
+func TestUpdateDNSOwner(t *testing.T) {
+	l := zaptest.NewLogger(t, zaptest.Level(zapcore.DebugLevel))
+	zap.ReplaceGlobals(l)
+	s := fakedo.New("example.com")
+	defer s.Close()
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "by-hand", Data: "10.0.0.1"})
+	s.AddRecord("example.com", godo.DomainRecord{Type: "TXT", Name: "other", Data: OwnerMarker("other")})
+	ctx := context.Background()
+	c, err := NewClientFromGodo(ctx, s.Client(), "example.com", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = c.WithOwner("main")
+	txts := func() map[string][]string {
+		result := make(map[string][]string)
+		for _, rec := range s.Records("example.com") {
+			if rec.Type == "TXT" {
+				result[rec.Name] = append(result[rec.Name], rec.Data)
+			}
+		}
+		return result
+	}
+
+	// A new record is claimed, and released when it's emptied.
+	if err := c.UpdateDNS(ctx, "nodes", []net.IP{net.IPv4(10, 0, 0, 2)}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"nodes": {"owner=nodedns/main"}, "other": {"owner=nodedns/other"}}
+	if diff := cmp.Diff(txts(), want); diff != "" {
+		t.Errorf("txt records after claim:\n%s", diff)
+	}
+	if err := c.UpdateDNS(ctx, "nodes", []net.IP{net.IPv4(10, 0, 0, 3)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UpdateDNS(ctx, "nodes", nil); err != nil {
+		t.Fatal(err)
+	}
+	want = map[string][]string{"other": {"owner=nodedns/other"}}
+	if diff := cmp.Diff(txts(), want); diff != "" {
+		t.Errorf("txt records after release:\n%s", diff)
+	}
+
+	// Records made by hand, and records that another owner marked, are left alone.
+	for _, record := range []string{"by-hand", "other"} {
+		err := c.UpdateDNS(ctx, record, []net.IP{net.IPv4(10, 0, 0, 4)})
+		var oe *OwnershipError
+		if !errors.As(err, &oe) {
+			t.Errorf("update %s:\n  got: %v\n want: an OwnershipError", record, err)
+		}
+		if got, want := testutil.ToFloat64(dnsRecordUnowned.WithLabelValues("digitalocean", "example.com", record)), 1.0; got != want {
+			t.Errorf("unowned metric for %s:\n  got: %v\n want: %v", record, got, want)
+		}
+	}
+	wantAddrs := map[string][]string{"by-hand": {"10.0.0.1"}}
+	if diff := cmp.Diff(s.Addresses("example.com"), wantAddrs); diff != "" {
+		t.Errorf("addresses:\n%s", diff)
+	}
+
+	// Adding the marker hands the record over.
+	s.AddRecord("example.com", godo.DomainRecord{Type: "TXT", Name: "by-hand", Data: `"owner=nodedns/main"`})
+	if err := c.UpdateDNS(ctx, "by-hand", []net.IP{net.IPv4(10, 0, 0, 4)}); err != nil {
+		t.Fatal(err)
+	}
+	wantAddrs = map[string][]string{"by-hand": {"10.0.0.4"}}
+	if diff := cmp.Diff(s.Addresses("example.com"), wantAddrs); diff != "" {
+		t.Errorf("addresses after handover:\n%s", diff)
+	}
+}
+
+func TestUpdateDNSAdoptUnowned(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	s := fakedo.New("example.com")
+	defer s.Close()
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "by-hand", Data: "10.0.0.1"})
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "other", Data: "10.0.0.2"})
+	s.AddRecord("example.com", godo.DomainRecord{Type: "TXT", Name: "other", Data: OwnerMarker("other")})
+	ctx := context.Background()
+	c, err := NewClientFromGodo(ctx, s.Client(), "example.com", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = c.WithOwner("main").AdoptUnowned()
+
+	// An unowned record is marked even when its addresses don't change.
+	if err := c.UpdateDNS(ctx, "by-hand", []net.IP{net.IPv4(10, 0, 0, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	owned, err := c.Owned(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(owned, []string{"by-hand.example.com"}); diff != "" {
+		t.Errorf("owned:\n%s", diff)
+	}
+
+	// A record marked with another owner is still refused.
+	var oe *OwnershipError
+	if err := c.UpdateDNS(ctx, "other", []net.IP{net.IPv4(10, 0, 0, 3)}); !errors.As(err, &oe) {
+		t.Errorf("update other:\n  got: %v\n want: an OwnershipError", err)
+	}
+
+	// An unowned record isn't adopted just to be emptied.
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "stale", Data: "10.0.0.4"})
+	if err := c.UpdateDNS(ctx, "stale", nil); !errors.As(err, &oe) {
+		t.Errorf("update stale:\n  got: %v\n want: an OwnershipError", err)
+	}
+	wantAddrs := map[string][]string{"by-hand": {"10.0.0.1"}, "other": {"10.0.0.2"}, "stale": {"10.0.0.4"}}
+	if diff := cmp.Diff(s.Addresses("example.com"), wantAddrs); diff != "" {
+		t.Errorf("addresses:\n%s", diff)
+	}
+}
+
+func TestUpdateDNSAudit(t *testing.T) {
+	l := zaptest.NewLogger(t, zaptest.Level(zapcore.DebugLevel))
+	zap.ReplaceGlobals(l)
+	s := fakedo.New("example.com")
+	defer s.Close()
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "audit.example.com", Data: "10.0.0.1"})
+	ctx := context.Background()
+	c, err := NewClientFromGodo(ctx, s.Client(), "example.com", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var drifted []string
+	c = c.Audit().OnDrift(func(ctx context.Context, zone, record string, add, remove []string) {
+		drifted = append(drifted, fmt.Sprintf("%s in %s: +%v -%v", record, zone, add, remove))
+	})
+	drift := func() float64 {
+		return testutil.ToFloat64(dnsRecordDrift.WithLabelValues("digitalocean", "example.com", "audit.example.com"))
+	}
+	if err := c.UpdateDNS(ctx, "audit.example.com", []net.IP{net.IPv4(1, 2, 3, 4), net.IPv4(1, 2, 3, 5)}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"audit": {"10.0.0.1"}}
+	if diff := cmp.Diff(s.Addresses("example.com"), want); diff != "" {
+		t.Errorf("after audit:\n%s", diff)
+	}
+	if got, want := drift(), 3.0; got != want {
+		t.Errorf("drift:\n  got: %v\n want: %v", got, want)
+	}
+	if err := c.UpdateDNS(ctx, "audit.example.com", []net.IP{net.IPv4(10, 0, 0, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := drift(), 0.0; got != want {
+		t.Errorf("drift after matching:\n  got: %v\n want: %v", got, want)
+	}
+	if diff := cmp.Diff(drifted, []string{"audit.example.com in example.com: +[1.2.3.4 1.2.3.5] -[10.0.0.1]"}); diff != "" {
+		t.Errorf("reported drift:\n%s", diff)
+	}
+}
+
+func TestUpdateDNSCanonical(t *testing.T) {
+	l := zaptest.NewLogger(t, zaptest.Level(zapcore.DebugLevel))
+	zap.ReplaceGlobals(l)
+	s := fakedo.New("example.com")
+	defer s.Close()
+	s.AddRecord("example.com", godo.DomainRecord{Type: "AAAA", Name: "nodes.example.com", Data: "2001:0DB8:0000::0001", TTL: 30})
+	ctx := context.Background()
+	c, err := NewClientFromGodo(ctx, s.Client(), "example.com", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := s.Requests()
+	if err := c.UpdateDNS(ctx, "nodes.example.com", []net.IP{net.ParseIP("2001:db8::1")}); err != nil {
+		t.Fatal(err)
+	}
+	// Listing the records is the only request; nothing is created or deleted.
+	if got, want := s.Requests()-before, 1; got != want {
+		t.Errorf("requests:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+func TestUpdateDNSLogging(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	zap.ReplaceGlobals(zap.New(core))
+	defer zap.ReplaceGlobals(zaptest.NewLogger(t))
+	s := fakedo.New("example.com")
+	defer s.Close()
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "nodes", Data: "10.0.0.1", TTL: 30})
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "nodes", Data: "10.0.0.2", TTL: 3600})
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "nodes", Data: "10.0.0.2", TTL: 30})
+	ctx := context.Background()
+	c, err := NewClientFromGodo(ctx, s.Client(), "example.com", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UpdateDNS(ctx, "nodes", []net.IP{net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 3)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.UpdateDNS(ctx, "nodes", []net.IP{net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 3)}); err != nil {
+		t.Fatal(err)
+	}
+	entries := logs.FilterMessage("dns record changed").AllUntimed()
+	if got, want := len(entries), 1; got != want {
+		t.Fatalf("log entries:\n  got: %v\n want: %v", got, want)
+	}
+	got := entries[0].ContextMap()
+	want := map[string]interface{}{
+		"record":             "nodes.example.com",
+		"added":              []interface{}{"10.0.0.3"},
+		"removed":            []interface{}{"10.0.0.1"},
+		"ttl_fixed":          []interface{}{"10.0.0.2"},
+		"duplicates_removed": int64(1),
+		"addresses":          int64(2),
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("logged fields:\n%s", diff)
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	for input, want := range map[string]string{
+		"2001:0DB8:0000::0001": "2001:db8::1",
+		"2001:db8:0:0:1:0:0:1": "2001:db8::1:0:0:1",
+		"::ffff:192.0.2.1":     "192.0.2.1",
+		" 10.0.0.1":            "10.0.0.1",
+		"not an address":       "not an address",
+	} {
+		if got := Canonical(input); got != want {
+			t.Errorf("%s:\n  got: %v\n want: %v", input, got, want)
+		}
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	s := fakedo.New("example.com")
+	defer s.Close()
+	ctx := context.Background()
+	if _, err := NewClientFromGodo(ctx, s.Client(), "example.com", time.Second); err != nil {
+		t.Errorf("existing zone: %v", err)
+	}
+	if _, err := NewClientFromGodo(ctx, s.Client(), "example.org", time.Second); err == nil {
+		t.Error("missing zone: expected error")
+	}
+}
+
+func TestClampTTL(t *testing.T) {
+	testData := []struct {
+		ttl         time.Duration
+		want        time.Duration
+		wantClamped bool
+	}{
+		{ttl: 0, want: MinTTL, wantClamped: true},
+		{ttl: 10 * time.Second, want: MinTTL, wantClamped: true},
+		{ttl: 30 * time.Second, want: 30 * time.Second},
+		{ttl: 60*time.Second + 200*time.Millisecond, want: 60 * time.Second},
+		{ttl: 48 * time.Hour, want: MaxTTL, wantClamped: true},
+	}
+	for _, test := range testData {
+		got, clamped := ClampTTL(test.ttl)
+		if got != test.want || clamped != test.wantClamped {
+			t.Errorf("%v:\n  got: %v, %v\n want: %v, %v", test.ttl, got, clamped, test.want, test.wantClamped)
+		}
+	}
+}
+
+func TestTokens(t *testing.T) {
+	c := &Config{PAToken: "main", ExtraTokens: []string{"extra"}, ZoneTokens: map[string]string{"team.example.com": "team"}}
+	for zone, want := range map[string][]string{
+		"example.com":      {"main", "extra"},
+		"team.example.com": {"team"},
+	} {
+		if diff := cmp.Diff(c.Tokens(zone), want); diff != "" {
+			t.Errorf("%s: tokens:\n%s", zone, diff)
+		}
+	}
+}
+
+func TestFQDN(t *testing.T) {
+	c := &Client{zone: "example.com"}
+	for record, want := range map[string]string{
+		"nodes":              "nodes.example.com",
+		"nodes.example.com":  "nodes.example.com",
+		"nodes.example.com.": "nodes.example.com",
+		"@":                  "example.com",
+		"example.com":        "example.com",
+		"a.b":                "a.b.example.com",
+	} {
+		if got := c.FQDN(record); got != want {
+			t.Errorf("%s:\n  got: %v\n want: %v", record, got, want)
+		}
+	}
+}
+
+func TestIsAuthError(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	s := fakedo.New("example.com")
+	defer s.Close()
+	ctx := context.Background()
+	c, err := NewClientFromGodo(ctx, s.Client(), "example.com", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for status, want := range map[int]bool{
+		http.StatusUnauthorized:       true,
+		http.StatusForbidden:          true,
+		http.StatusServiceUnavailable: false,
+	} {
+		s.InjectFault(func(req *http.Request) *fakedo.Fault {
+			return &fakedo.Fault{Status: status, Message: http.StatusText(status)}
+		})
+		err := c.UpdateDNS(ctx, "nodes", []net.IP{net.IPv4(1, 2, 3, 4)})
+		if err == nil {
+			t.Errorf("%d: expected error", status)
+			continue
+		}
+		if got := IsAuthError(err); got != want {
+			t.Errorf("%d: IsAuthError(%v):\n  got: %v\n want: %v", status, err, got, want)
+		}
+	}
+	if IsAuthError(errors.New("boom")) {
+		t.Error("IsAuthError of a plain error")
+	}
+}
+
+func TestUpdateFailureMetrics(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	s := fakedo.New("example.com")
+	defer s.Close()
+	ctx := context.Background()
+	c, err := NewClientFromGodo(ctx, s.Client(), "example.com", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failures := func() map[string]float64 {
+		result := make(map[string]float64)
+		for _, class := range []string{"rate_limit", "auth", "timeout", "other"} {
+			result[class] = testutil.ToFloat64(dnsUpdateFailures.WithLabelValues("digitalocean", "example.com", "failing", class))
+		}
+		return result
+	}
+	lastSuccess := func() float64 {
+		return testutil.ToFloat64(dnsLastSuccess.WithLabelValues("digitalocean", "example.com", "failing"))
+	}
+
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusForbidden, http.StatusGatewayTimeout, http.StatusInternalServerError} {
+		status := status
+		s.InjectFault(func(req *http.Request) *fakedo.Fault {
+			return &fakedo.Fault{Status: status, Message: http.StatusText(status)}
+		})
+		if err := c.UpdateDNS(ctx, "failing", []net.IP{net.IPv4(1, 2, 3, 4)}); err == nil {
+			t.Fatalf("%d: expected error", status)
+		}
+	}
+	if got, want := failures(), map[string]float64{"rate_limit": 1, "auth": 1, "timeout": 1, "other": 1}; !cmp.Equal(got, want) {
+		t.Errorf("failures:\n  got: %v\n want: %v", got, want)
+	}
+	if got := lastSuccess(); got != 0 {
+		t.Errorf("last success before succeeding:\n  got: %v\n want: 0", got)
+	}
+
+	s.InjectFault(nil)
+	before := float64(time.Now().Unix())
+	if err := c.UpdateDNS(ctx, "failing", []net.IP{net.IPv4(1, 2, 3, 4)}); err != nil {
+		t.Fatal(err)
+	}
+	if got := lastSuccess(); got < before {
+		t.Errorf("last success after succeeding:\n  got: %v\n want: at least %v", got, before)
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	testData := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "deadline", err: fmt.Errorf("list: %w", context.DeadlineExceeded), want: "timeout"},
+		{name: "plain", err: errors.New("boom"), want: "other"},
+	}
+	for _, test := range testData {
+		if got := errorClass(test.err); got != test.want {
+			t.Errorf("%s:\n  got: %v\n want: %v", test.name, got, test.want)
+		}
 	}
-	cancel()
 }
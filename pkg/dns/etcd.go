@@ -0,0 +1,272 @@
+//go:build !no_etcd
+// +build !no_etcd
+
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"github.com/jrockway/nodedns/pkg/etcd"
+	"github.com/jrockway/nodedns/pkg/tracing"
+	"go.uber.org/zap"
+)
+
+// Etcd is a Provider that writes records to etcd in the format of SkyDNS and CoreDNS's etcd
+// plugin: each address is a key under the record's name, reversed, like
+// /skydns/com/example/nodes/nodedns-10-0-0-1, whose value is {"host":"10.0.0.1","ttl":60}.
+type Etcd struct {
+	c      *etcd.Client
+	prefix string
+	opts   ProviderOptions
+}
+
+var (
+	_ Provider = (*Etcd)(nil)
+	_ Exporter = (*Etcd)(nil)
+)
+
+// etcd's API errors carry the HTTP status of the response.
+func init() {
+	statusChecks = append(statusChecks, func(err error) int {
+		var etcdErr *etcd.APIError
+		if errors.As(err, &etcdErr) {
+			return etcdErr.Status
+		}
+		return 0
+	})
+}
+
+// skyDNSService is the part of a SkyDNS service that nodedns reads and writes.  A host that isn't
+// an address makes the name a CNAME.
+type skyDNSService struct {
+	Host string `json:"host"`
+	TTL  uint32 `json:"ttl,omitempty"`
+}
+
+// NewEtcd returns an Etcd Provider for the zone in opts, which writes keys under prefix, like
+// "/skydns".
+func NewEtcd(ctx context.Context, c *etcd.Client, prefix string, opts ProviderOptions) (*Etcd, error) {
+	p := &Etcd{c: c, prefix: "/" + strings.Trim(prefix, "/"), opts: opts}
+	if _, err := c.Prefix(ctx, p.path(opts.Zone)); err != nil {
+		return nil, fmt.Errorf("etcd: %w", err)
+	}
+	return p, nil
+}
+
+// FQDN implements Provider.
+func (p *Etcd) FQDN(record string) string {
+	return fqdn(p.opts.Zone, record)
+}
+
+// path returns the key that the fully-qualified name's records are under.
+func (p *Etcd) path(name string) string {
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(name, ".")), ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return p.prefix + "/" + strings.Join(labels, "/")
+}
+
+// etcdRecord is an entry for a name.
+type etcdRecord struct {
+	key     string
+	value   []byte
+	service skyDNSService
+}
+
+// withTTL returns the entry's value with its TTL replaced, keeping any fields that nodedns doesn't
+// know about.
+func (r etcdRecord) withTTL(ttl uint32) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(r.value, &fields); err != nil {
+		return nil, err
+	}
+	fields["ttl"] = json.RawMessage(strconv.FormatUint(uint64(ttl), 10))
+	return json.Marshal(fields)
+}
+
+// records returns the entries that define the fully-qualified name: the key for the name itself,
+// and the keys directly under it.  Keys further down are subdomains.
+func (p *Etcd) records(ctx context.Context, name string) ([]etcdRecord, error) {
+	path := p.path(name)
+	kvs, err := p.c.Prefix(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	var result []etcdRecord
+	for _, kv := range kvs {
+		if kv.Key != path && (!strings.HasPrefix(kv.Key, path+"/") || strings.Contains(kv.Key[len(path)+1:], "/")) {
+			continue
+		}
+		var s skyDNSService
+		if err := json.Unmarshal(kv.Value, &s); err != nil {
+			zap.L().Named("etcd-dns").Debug("ignoring malformed entry", zap.String("key", kv.Key), zap.Error(err))
+			continue
+		}
+		result = append(result, etcdRecord{key: kv.Key, value: kv.Value, service: s})
+	}
+	return result, nil
+}
+
+// UpdateDNS implements Provider.  Every change to the record is applied in one transaction.
+func (p *Etcd) UpdateDNS(ctx context.Context, record string, addresses []net.IP) (err error) {
+	if record == "" {
+		return nil
+	}
+	ctx, span := tracing.Start(ctx, "etcd_dns_update")
+	defer span.End()
+	zone, name := p.opts.Zone, p.FQDN(record)
+	dnsUpdateAttempts.WithLabelValues("etcd", zone, record).Inc()
+	defer func(start time.Time) {
+		observeUpdate(ctx, "etcd", zone, record, start, err)
+	}(time.Now())
+	l := zap.L().Named("etcd-dns").With(correlation.Field(ctx))
+
+	records, err := p.records(ctx, name)
+	if err != nil {
+		return fmt.Errorf("get existing records: %w", err)
+	}
+	desired := make(map[string]bool)
+	for _, ip := range addresses {
+		if manages(p.opts.Family, recordType(ip)) {
+			desired[ip.String()] = true
+		}
+	}
+	// published tracks what the record contains as changes are made, even if the update fails.
+	published := make(map[string]bool)
+	defer reportPublished("etcd", zone, p.opts.Family, record, published)
+	ttl := uint32(p.opts.TTL.Round(time.Second).Seconds())
+	var toDelete, toDeleteAddrs, toFixAddrs []string
+	var toFix []etcdRecord
+	deletedAddrs := make(map[string]string) // Key -> address, for OnMutation.
+	var cname string
+	for _, r := range records {
+		ip := net.ParseIP(r.service.Host)
+		if ip == nil {
+			cname = r.service.Host
+			continue
+		}
+		if !manages(p.opts.Family, recordType(ip)) {
+			continue
+		}
+		addr := ip.String()
+		if !desired[addr] || published[addr] {
+			// Unwanted, or a duplicate of an entry that's kept.
+			toDelete = append(toDelete, r.key)
+			deletedAddrs[r.key] = addr
+			if !desired[addr] {
+				toDeleteAddrs = append(toDeleteAddrs, addr)
+			}
+		} else if r.service.TTL != ttl {
+			// Entries written by hand or with an earlier --ttl get the configured TTL.
+			toFix = append(toFix, r)
+			toFixAddrs = append(toFixAddrs, addr)
+		}
+		published[addr] = true
+	}
+	var toCreate []string
+	for addr := range desired {
+		if !published[addr] {
+			toCreate = append(toCreate, addr)
+		}
+	}
+	sort.Strings(toCreate)
+
+	if cname != "" {
+		dnsRecordConflict.WithLabelValues("etcd", zone, record).Set(1)
+	} else {
+		dnsRecordConflict.WithLabelValues("etcd", zone, record).Set(0)
+	}
+	if p.opts.Audit {
+		dnsRecordDrift.WithLabelValues("etcd", zone, record).Set(float64(len(toDelete) + len(toCreate)))
+		if len(toDelete) > 0 || len(toCreate) > 0 {
+			l.Info("dns record drifted; auditing, so not changing it", zap.String("record", name), zap.Strings("would_add", toCreate), zap.Strings("would_remove", toDeleteAddrs))
+			if p.opts.OnDrift != nil {
+				p.opts.OnDrift(ctx, zone, name, toCreate, toDeleteAddrs)
+			}
+		}
+		if len(toFix) > 0 {
+			l.Info("dns record ttl drifted; auditing, so not changing it", zap.String("record", name), zap.Strings("addresses", toFixAddrs), zap.Uint32("ttl", ttl))
+		}
+		return nil
+	}
+	if p.opts.CreateOnly && len(toDelete) > 0 {
+		l.Info("create-only; not deleting records", zap.String("record", name), zap.Strings("would_delete", toDeleteAddrs))
+		dnsRecordsDeleteSkipped.WithLabelValues("etcd", zone, record).Add(float64(len(toDelete)))
+		toDelete, toDeleteAddrs = nil, nil
+	}
+	if cname != "" && len(toCreate) > 0 {
+		return &ConflictError{Record: name, Target: strings.TrimSuffix(cname, ".")}
+	}
+	if len(toCreate) == 0 && len(toDelete) == 0 && len(toFix) == 0 {
+		dnsUpdatedOK.WithLabelValues("etcd", zone, record).Inc()
+		return nil
+	}
+
+	var toPut []etcd.KV
+	for _, r := range toFix {
+		value, err := r.withTTL(ttl)
+		if err != nil {
+			return fmt.Errorf("update ttl of %s: %w", r.key, err)
+		}
+		toPut = append(toPut, etcd.KV{Key: r.key, Value: value})
+	}
+	keys := make(map[string]string) // Address -> key, for OnMutation.
+	for _, addr := range toCreate {
+		value, err := json.Marshal(skyDNSService{Host: addr, TTL: ttl})
+		if err != nil {
+			return fmt.Errorf("marshal entry for %s: %w", addr, err)
+		}
+		id := "nodedns-" + strings.NewReplacer(".", "-", ":", "-").Replace(addr)
+		keys[addr] = p.path(name) + "/" + id
+		toPut = append(toPut, etcd.KV{Key: keys[addr], Value: value})
+	}
+	if err := p.c.Apply(ctx, toPut, toDelete); err != nil {
+		return fmt.Errorf("writing entries (adding %v, removing %v): %w", toCreate, toDeleteAddrs, err)
+	}
+	for _, addr := range toCreate {
+		published[addr] = true
+		mutated(ctx, p.opts.OnMutation, Mutation{Action: "create", Provider: "etcd", Zone: zone, Record: name, Address: addr, ID: keys[addr]})
+	}
+	for _, addr := range toDeleteAddrs {
+		delete(published, addr)
+	}
+	for _, key := range toDelete {
+		mutated(ctx, p.opts.OnMutation, Mutation{Action: "delete", Provider: "etcd", Zone: zone, Record: name, Address: deletedAddrs[key], ID: key})
+	}
+	dnsRecordsCreated.WithLabelValues("etcd", zone, record).Add(float64(len(toCreate)))
+	dnsRecordsDeleted.WithLabelValues("etcd", zone, record).Add(float64(len(toDelete)))
+	dnsRecordsTTLFixed.WithLabelValues("etcd", zone, record).Add(float64(len(toFix)))
+	l.Info("dns record changed", zap.String("record", name), zap.Strings("added", toCreate), zap.Strings("removed", toDeleteAddrs), zap.Strings("ttl_fixed", toFixAddrs), zap.Int("addresses", len(desired)))
+	dnsUpdatedOK.WithLabelValues("etcd", zone, record).Inc()
+	return nil
+}
+
+// Export implements Exporter.
+func (p *Etcd) Export(ctx context.Context, names []string) (*Snapshot, error) {
+	snap := &Snapshot{Zone: p.opts.Zone, Taken: time.Now().UTC()}
+	for _, n := range names {
+		records, err := p.records(ctx, p.FQDN(n))
+		if err != nil {
+			return nil, fmt.Errorf("export %s: %w", n, err)
+		}
+		for _, r := range records {
+			ip := net.ParseIP(r.service.Host)
+			if ip == nil {
+				continue
+			}
+			snap.Records = append(snap.Records, SnapshotRecord{Name: RelativeName(p.opts.Zone, p.FQDN(n)), Type: recordType(ip), TTL: int(r.service.TTL), Data: ip.String()})
+		}
+	}
+	snap.sort()
+	return snap, nil
+}
@@ -0,0 +1,174 @@
+//go:build !no_etcd
+// +build !no_etcd
+
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/etcd"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+)
+
+// fakeEtcd is a fake of the range and txn methods of etcd's JSON gateway.
+type fakeEtcd struct {
+	sync.Mutex
+	kvs map[string]string
+}
+
+// contents returns each key and value as "key value", sorted.
+func (f *fakeEtcd) contents() []string {
+	f.Lock()
+	defer f.Unlock()
+	var result []string
+	for k, v := range f.kvs {
+		result = append(result, k+" "+v)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	type kv struct {
+		Key   []byte `json:"key"`
+		Value []byte `json:"value,omitempty"`
+	}
+	switch req.URL.Path {
+	case "/v3/kv/range":
+		var r struct {
+			Key      []byte `json:"key"`
+			RangeEnd []byte `json:"range_end"`
+		}
+		json.NewDecoder(req.Body).Decode(&r) // nolint:errcheck
+		var kvs []kv
+		for k, v := range f.kvs {
+			if k >= string(r.Key) && k < string(r.RangeEnd) {
+				kvs = append(kvs, kv{Key: []byte(k), Value: []byte(v)})
+			}
+		}
+		sort.Slice(kvs, func(i, j int) bool { return string(kvs[i].Key) < string(kvs[j].Key) })
+		json.NewEncoder(w).Encode(map[string]interface{}{"kvs": kvs}) // nolint:errcheck
+	case "/v3/kv/txn":
+		var r struct {
+			Success []struct {
+				Put    *kv `json:"request_put"`
+				Delete *kv `json:"request_delete_range"`
+			} `json:"success"`
+		}
+		json.NewDecoder(req.Body).Decode(&r) // nolint:errcheck
+		for _, op := range r.Success {
+			if op.Put != nil {
+				f.kvs[string(op.Put.Key)] = string(op.Put.Value)
+			}
+			if op.Delete != nil {
+				delete(f.kvs, string(op.Delete.Key))
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"succeeded": true}) // nolint:errcheck
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// newTestEtcd returns a fake etcd holding SkyDNS entries for example.com, with the records that
+// testProviderConformance expects, 42.0.0.1 twice, and entries that aren't the record's own
+// addresses, and a client of it.
+func newTestEtcd(t *testing.T) (*fakeEtcd, *etcd.Client) {
+	f := &fakeEtcd{kvs: map[string]string{
+		"/skydns/com/example/nodes/a":        `{"host":"10.0.0.1","ttl":300}`,
+		"/skydns/com/example/nodes/b":        `{"host":"42.0.0.1","ttl":300,"priority":10}`,
+		"/skydns/com/example/nodes/c":        `{"host":"42.0.0.1","ttl":300}`,
+		"/skydns/com/example/nodes/sub/x":    `{"host":"10.1.0.1"}`,
+		"/skydns/com/example/nodes2/x":       `{"host":"10.2.0.1"}`,
+		"/skydns/com/example/www":            `{"host":"example.com"}`,
+		"/skydns/com/example/nodes/not-json": `nope`,
+	}}
+	s := httptest.NewServer(f)
+	t.Cleanup(s.Close)
+	c, err := etcd.NewClient(etcd.Config{Endpoint: s.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, c
+}
+
+func TestEtcdConformance(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	_, c := newTestEtcd(t)
+	testProviderConformance(t, conformanceFixture{CNAME: true, New: func(ctx context.Context, opts ProviderOptions) (Provider, error) {
+		return NewEtcd(ctx, c, "/skydns/", opts)
+	}})
+}
+
+func TestEtcd(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	f, c := newTestEtcd(t)
+	ctx := context.Background()
+
+	p, err := NewEtcd(ctx, c, "/skydns/", ProviderOptions{Zone: "example.com", TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UpdateDNS(ctx, "nodes", []net.IP{net.IPv4(42, 0, 0, 1), net.ParseIP("2001:db8::1")}); err != nil {
+		t.Fatal(err)
+	}
+	// The kept entry gets the configured TTL, and keeps the fields that nodedns doesn't know about;
+	// new entries are named after their address, and entries below the record or in other
+	// records are left alone.
+	want := []string{
+		`/skydns/com/example/nodes/b {"host":"42.0.0.1","priority":10,"ttl":60}`,
+		`/skydns/com/example/nodes/nodedns-2001-db8--1 {"host":"2001:db8::1","ttl":60}`,
+		`/skydns/com/example/nodes/not-json nope`,
+		`/skydns/com/example/nodes/sub/x {"host":"10.1.0.1"}`,
+		`/skydns/com/example/nodes2/x {"host":"10.2.0.1"}`,
+		`/skydns/com/example/www {"host":"example.com"}`,
+	}
+	if diff := cmp.Diff(f.contents(), want); diff != "" {
+		t.Errorf("after update:\n%s", diff)
+	}
+
+	snap, err := p.Export(ctx, []string{"nodes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRecords := []SnapshotRecord{{Name: "nodes", Type: "A", TTL: 60, Data: "42.0.0.1"}, {Name: "nodes", Type: "AAAA", TTL: 60, Data: "2001:db8::1"}}
+	if diff := cmp.Diff(snap.Records, wantRecords); diff != "" {
+		t.Errorf("export:\n%s", diff)
+	}
+
+	// The prefix doesn't need its slashes.
+	p, err = NewEtcd(ctx, c, "skydns", ProviderOptions{Zone: "example.com", TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UpdateDNS(ctx, "nodes.example.com", []net.IP{net.IPv4(42, 0, 0, 2)}); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, kv := range f.contents() {
+		if strings.HasPrefix(kv, "/skydns/com/example/nodes/") {
+			got = append(got, kv)
+		}
+	}
+	want = []string{
+		`/skydns/com/example/nodes/nodedns-42-0-0-2 {"host":"42.0.0.2","ttl":60}`,
+		`/skydns/com/example/nodes/not-json nope`,
+		`/skydns/com/example/nodes/sub/x {"host":"10.1.0.1"}`,
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("after update without slashes:\n%s", diff)
+	}
+}
@@ -0,0 +1,226 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"github.com/jrockway/nodedns/pkg/tracing"
+	"go.uber.org/zap"
+)
+
+// FakeConfig configures the in-memory provider.
+type FakeConfig struct {
+	Latency     time.Duration `long:"fake_dns_latency" env:"FAKE_DNS_LATENCY" description:"with --dns_provider=fake, how long each update takes"`
+	FailureRate float64       `long:"fake_dns_failure_rate" env:"FAKE_DNS_FAILURE_RATE" description:"with --dns_provider=fake, the fraction of updates that fail, to exercise retries and alerts"`
+}
+
+// Validate returns an error if the configuration is invalid.
+func (cfg *FakeConfig) Validate() error {
+	if cfg.Latency < 0 {
+		return fmt.Errorf("latency %v: must not be negative", cfg.Latency)
+	}
+	if cfg.FailureRate < 0 || cfg.FailureRate > 1 {
+		return fmt.Errorf("failure rate %v: must be between 0 and 1", cfg.FailureRate)
+	}
+	return nil
+}
+
+// ErrInjectedFailure is the error that the Fake provider fails updates with.
+var ErrInjectedFailure = errors.New("fake: injected failure")
+
+// Fake is a Provider that keeps records in memory, for tests and for trialing a configuration
+// without changing any real records: every change is logged, counted, and reported to
+// OnMutation like a real provider's, but nothing leaves the process.  It's safe for concurrent
+// use.
+type Fake struct {
+	cfg  FakeConfig
+	opts ProviderOptions
+
+	// Fail, if non-nil, is called before each update with the fully-qualified name of the
+	// record; a non-nil error fails the update without changing the record.  It replaces
+	// FailureRate.  Set it before the provider is used.
+	Fail func(record string) error
+
+	mu      sync.Mutex
+	records map[string]map[string]bool // The addresses of each record, by fully-qualified name.
+}
+
+var (
+	_ Provider = (*Fake)(nil)
+	_ Exporter = (*Fake)(nil)
+)
+
+// NewFake returns a Fake Provider for the zone in opts, with no records.
+func NewFake(cfg FakeConfig, opts ProviderOptions) *Fake {
+	return &Fake{cfg: cfg, opts: opts, records: make(map[string]map[string]bool)}
+}
+
+// FQDN implements Provider.
+func (p *Fake) FQDN(record string) string {
+	return fqdn(p.opts.Zone, record)
+}
+
+// Set replaces the addresses of a record, without logging or counting anything; for tests that
+// need existing records.
+func (p *Fake) Set(record string, addresses ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	name := strings.ToLower(p.FQDN(record))
+	p.records[name] = make(map[string]bool)
+	for _, addr := range addresses {
+		if ip := net.ParseIP(addr); ip != nil {
+			p.records[name][ip.String()] = true
+		}
+	}
+}
+
+// Addresses returns the sorted addresses of every record that has any, by fully-qualified name.
+func (p *Fake) Addresses() map[string][]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result := make(map[string][]string)
+	for name, addrs := range p.records {
+		for addr := range addrs {
+			result[name] = append(result[name], addr)
+		}
+		sort.Strings(result[name])
+	}
+	for name, addrs := range result {
+		if len(addrs) == 0 {
+			delete(result, name)
+		}
+	}
+	return result
+}
+
+// fail returns the error that an update of the record should fail with, if any.
+func (p *Fake) fail(name string) error {
+	if p.Fail != nil {
+		return p.Fail(name)
+	}
+	if p.cfg.FailureRate > 0 && rand.Float64() < p.cfg.FailureRate { // nolint:gosec
+		return ErrInjectedFailure
+	}
+	return nil
+}
+
+// UpdateDNS implements Provider.
+func (p *Fake) UpdateDNS(ctx context.Context, record string, addresses []net.IP) (err error) {
+	if record == "" {
+		return nil
+	}
+	ctx, span := tracing.Start(ctx, "fake_dns_update")
+	defer span.End()
+	zone, name := p.opts.Zone, strings.ToLower(p.FQDN(record))
+	dnsUpdateAttempts.WithLabelValues("fake", zone, record).Inc()
+	defer func(start time.Time) {
+		observeUpdate(ctx, "fake", zone, record, start, err)
+	}(time.Now())
+	l := zap.L().Named("fake-dns").With(correlation.Field(ctx))
+
+	if p.cfg.Latency > 0 {
+		t := time.NewTimer(p.cfg.Latency)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return fmt.Errorf("%s: %w", name, ctx.Err())
+		case <-t.C:
+		}
+	}
+	if err := p.fail(name); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	current := p.records[name]
+	if current == nil {
+		current = make(map[string]bool)
+		p.records[name] = current
+	}
+	updateSet(ctx, l, "fake", p.opts, record, name, current, addresses)
+	reportPublished("fake", zone, p.opts.Family, record, current)
+	dnsUpdatedOK.WithLabelValues("fake", zone, record).Inc()
+	return nil
+}
+
+// updateSet makes current, the addresses of a record that's kept in memory, contain exactly the
+// provided addresses of the types that opts manages, honoring Audit and CreateOnly.  It logs,
+// counts, and reports each change like the providers that make changes through an API, and
+// returns true if current changed.
+func updateSet(ctx context.Context, l *zap.Logger, provider string, opts ProviderOptions, record, name string, current map[string]bool, addresses []net.IP) bool {
+	zone := opts.Zone
+	desired := make(map[string]bool)
+	for _, ip := range addresses {
+		if manages(opts.Family, recordType(ip)) {
+			desired[ip.String()] = true
+		}
+	}
+	var toCreate, toDelete []string
+	for addr := range desired {
+		if !current[addr] {
+			toCreate = append(toCreate, addr)
+		}
+	}
+	for addr := range current {
+		if !desired[addr] && manages(opts.Family, recordType(net.ParseIP(addr))) {
+			toDelete = append(toDelete, addr)
+		}
+	}
+	sort.Strings(toCreate)
+	sort.Strings(toDelete)
+
+	if opts.Audit {
+		dnsRecordDrift.WithLabelValues(provider, zone, record).Set(float64(len(toDelete) + len(toCreate)))
+		if len(toDelete) > 0 || len(toCreate) > 0 {
+			l.Info("dns record drifted; auditing, so not changing it", zap.String("record", name), zap.Strings("would_add", toCreate), zap.Strings("would_remove", toDelete))
+			if opts.OnDrift != nil {
+				opts.OnDrift(ctx, zone, name, toCreate, toDelete)
+			}
+		}
+		return false
+	}
+	if opts.CreateOnly && len(toDelete) > 0 {
+		l.Info("create-only; not deleting records", zap.String("record", name), zap.Strings("would_delete", toDelete))
+		dnsRecordsDeleteSkipped.WithLabelValues(provider, zone, record).Add(float64(len(toDelete)))
+		toDelete = nil
+	}
+	for _, addr := range toCreate {
+		current[addr] = true
+		dnsRecordsCreated.WithLabelValues(provider, zone, record).Inc()
+		mutated(ctx, opts.OnMutation, Mutation{Action: "create", Provider: provider, Zone: zone, Record: name, Address: addr})
+	}
+	for _, addr := range toDelete {
+		delete(current, addr)
+		dnsRecordsDeleted.WithLabelValues(provider, zone, record).Inc()
+		mutated(ctx, opts.OnMutation, Mutation{Action: "delete", Provider: provider, Zone: zone, Record: name, Address: addr})
+	}
+	if len(toCreate) == 0 && len(toDelete) == 0 {
+		return false
+	}
+	l.Info("dns record changed", zap.String("record", name), zap.Strings("added", toCreate), zap.Strings("removed", toDelete), zap.Int("addresses", len(current)))
+	return true
+}
+
+// Export implements Exporter.
+func (p *Fake) Export(ctx context.Context, names []string) (*Snapshot, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	snap := &Snapshot{Zone: p.opts.Zone, Taken: time.Now().UTC()}
+	for _, n := range names {
+		name := strings.ToLower(p.FQDN(n))
+		for addr := range p.records[name] {
+			snap.Records = append(snap.Records, SnapshotRecord{Name: RelativeName(p.opts.Zone, name), Type: recordType(net.ParseIP(addr)), TTL: int(p.opts.TTL.Seconds()), Data: addr})
+		}
+	}
+	snap.sort()
+	return snap, nil
+}
@@ -0,0 +1,102 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestFake(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	ctx := context.Background()
+	var mutations []string
+	p := NewFake(FakeConfig{}, ProviderOptions{Zone: "example.com", OnMutation: func(ctx context.Context, m Mutation) {
+		mutations = append(mutations, m.Action+" "+m.Record+" "+m.Address)
+	}})
+	p.Set("nodes", "10.0.0.1", "10.0.0.2")
+
+	if err := p.UpdateDNS(ctx, "nodes", []net.IP{net.ParseIP("10.0.0.2"), net.ParseIP("2001:db8::1")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UpdateDNS(ctx, "Internal.example.com.", []net.IP{net.ParseIP("10.0.1.1")}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"nodes.example.com":    {"10.0.0.2", "2001:db8::1"},
+		"internal.example.com": {"10.0.1.1"},
+	}
+	if diff := cmp.Diff(p.Addresses(), want); diff != "" {
+		t.Errorf("addresses:\n%s", diff)
+	}
+	wantMutations := []string{
+		"create nodes.example.com 2001:db8::1",
+		"delete nodes.example.com 10.0.0.1",
+		"create internal.example.com 10.0.1.1",
+	}
+	if diff := cmp.Diff(mutations, wantMutations); diff != "" {
+		t.Errorf("mutations:\n%s", diff)
+	}
+
+	snap, err := p.Export(ctx, []string{"nodes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSnap := []SnapshotRecord{{Name: "nodes", Type: "A", Data: "10.0.0.2"}, {Name: "nodes", Type: "AAAA", Data: "2001:db8::1"}}
+	if diff := cmp.Diff(snap.Records, wantSnap); diff != "" {
+		t.Errorf("export:\n%s", diff)
+	}
+
+	// An injected failure leaves the record alone.
+	p.Fail = func(record string) error { return ErrInjectedFailure }
+	if err := p.UpdateDNS(ctx, "nodes", nil); !errors.Is(err, ErrInjectedFailure) {
+		t.Errorf("injected failure:\n  got: %v\n want: %v", err, ErrInjectedFailure)
+	}
+	if diff := cmp.Diff(p.Addresses(), want); diff != "" {
+		t.Errorf("addresses after failure:\n%s", diff)
+	}
+}
+
+func TestFakeModes(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	ctx := context.Background()
+	testData := []struct {
+		name string
+		opts ProviderOptions
+		want []string
+	}{
+		{name: "create only", opts: ProviderOptions{CreateOnly: true}, want: []string{"10.0.0.1", "10.0.0.2"}},
+		{name: "audit", opts: ProviderOptions{Audit: true}, want: []string{"10.0.0.1"}},
+		{name: "ipv6 only", opts: ProviderOptions{Family: "ipv6"}, want: []string{"10.0.0.1"}},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			test.opts.Zone = "example.com"
+			p := NewFake(FakeConfig{}, test.opts)
+			p.Set("nodes", "10.0.0.1")
+			if err := p.UpdateDNS(ctx, "nodes", []net.IP{net.ParseIP("10.0.0.2")}); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(p.Addresses()["nodes.example.com"], test.want); diff != "" {
+				t.Errorf("addresses:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFakeLatency(t *testing.T) {
+	p := NewFake(FakeConfig{Latency: time.Hour}, ProviderOptions{Zone: "example.com"})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.UpdateDNS(ctx, "nodes", []net.IP{net.ParseIP("10.0.0.1")}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("slow update:\n  got: %v\n want: %v", err, context.DeadlineExceeded)
+	}
+	if cfg := (&FakeConfig{FailureRate: 2}); cfg.Validate() == nil {
+		t.Error("failure rate 2: expected error")
+	}
+}
@@ -0,0 +1,344 @@
+package dns
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"github.com/jrockway/nodedns/pkg/tracing"
+	"go.uber.org/zap"
+)
+
+// FileConfig configures publishing records by writing them to a file.
+type FileConfig struct {
+	Path          string        `long:"file_path" env:"FILE_PATH" description:"with --dns_provider=file, the file to write the records to"`
+	Format        string        `long:"file_format" env:"FILE_FORMAT" description:"the format of --file_path; a bind zone file for the zone, or an /etc/hosts file" choice:"zone" choice:"hosts" default:"zone"`
+	ReloadCommand string        `long:"file_reload_command" env:"FILE_RELOAD_COMMAND" description:"a command to run after each change to --file_path, like 'rndc reload example.com'; it's split into arguments on spaces, outside of quotes, and run without a shell; $NODEDNS_FILE in an argument, and in the environment, is the file's path"`
+	ReloadTimeout time.Duration `long:"file_reload_timeout" env:"FILE_RELOAD_TIMEOUT" description:"how long --file_reload_command may take" default:"30s"`
+}
+
+// Validate returns an error if the configuration is invalid.
+func (cfg *FileConfig) Validate() error {
+	if cfg.Path == "" {
+		return errors.New("no path")
+	}
+	if cfg.Format != "zone" && cfg.Format != "hosts" {
+		return fmt.Errorf("format %q: must be zone or hosts", cfg.Format)
+	}
+	if cfg.ReloadTimeout <= 0 {
+		return fmt.Errorf("reload timeout %v: must be positive", cfg.ReloadTimeout)
+	}
+	if _, err := splitCommand(cfg.ReloadCommand); err != nil {
+		return fmt.Errorf("reload command: %w", err)
+	}
+	return nil
+}
+
+// splitCommand splits a command into its arguments on whitespace, like a shell does, except that
+// nothing but quotes is special: text in single or double quotes is kept together, and a backslash
+// escapes the next character outside of single quotes.  The command is run without a shell, since
+// the nodedns image doesn't have one.
+func splitCommand(command string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg, escaped := false, false
+	var quote rune
+	for _, r := range command {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote or escape")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// File is a Provider that renders records to a BIND zone file or an /etc/hosts file, for
+// resolvers that are fed files by other tooling.  Every File writing to the same path shares its
+// records, so that the records of every store end up in the file.  The file is replaced
+// atomically, only when a record changes, and then the reload command runs; if writing or
+// reloading fails, the update fails and the next update tries again.
+type File struct {
+	f    *recordFile
+	opts ProviderOptions
+}
+
+var (
+	_ Provider = (*File)(nil)
+	_ Exporter = (*File)(nil)
+)
+
+// recordFile is the contents of one file.
+type recordFile struct {
+	mu      sync.Mutex
+	cfg     FileConfig
+	zone    string                     // For zone files, the zone that every record is in.
+	records map[string]map[string]bool // The addresses of each record, by fully-qualified name.
+	ttls    map[string]int             // The TTL of each record, in seconds, by fully-qualified name.
+	dirty   bool                       // True if the records have changed since the file was written.
+}
+
+// files are the files that Files write to, by path.
+var files = struct {
+	sync.Mutex
+	m map[string]*recordFile
+}{m: make(map[string]*recordFile)}
+
+// NewFile returns a File Provider for the zone in opts.  The records already in the file are
+// read, so that they're kept until they're updated.  Zone files can only hold the records of one
+// zone.
+func NewFile(cfg FileConfig, opts ProviderOptions) (*File, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("file: %w", err)
+	}
+	zone := strings.ToLower(strings.TrimSuffix(opts.Zone, "."))
+	path, err := filepath.Abs(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("file: %w", err)
+	}
+	files.Lock()
+	defer files.Unlock()
+	if f, ok := files.m[path]; ok {
+		if f.cfg.Format != cfg.Format {
+			return nil, fmt.Errorf("file %s: already written as a %s file", path, f.cfg.Format)
+		}
+		if cfg.Format == "zone" && f.zone != zone {
+			return nil, fmt.Errorf("file %s: already holds zone %s, not %s", path, f.zone, zone)
+		}
+		return &File{f: f, opts: opts}, nil
+	}
+	f := &recordFile{cfg: cfg, zone: zone, records: make(map[string]map[string]bool), ttls: make(map[string]int), dirty: true}
+	f.cfg.Path = path
+	if err := f.load(); err != nil {
+		return nil, fmt.Errorf("file %s: %w", path, err)
+	}
+	files.m[path] = f
+	return &File{f: f, opts: opts}, nil
+}
+
+// load reads the records that are already in the file, if it exists.
+func (f *recordFile) load() error {
+	content, err := ioutil.ReadFile(f.cfg.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if f.cfg.Format == "hosts" {
+		sc := bufio.NewScanner(bytes.NewReader(content))
+		for sc.Scan() {
+			text := sc.Text()
+			if i := strings.Index(text, "#"); i >= 0 {
+				text = text[:i]
+			}
+			fields := strings.Fields(text)
+			if len(fields) < 2 {
+				continue
+			}
+			if ip := net.ParseIP(fields[0]); ip != nil {
+				f.add(strings.ToLower(strings.TrimSuffix(fields[1], ".")), ip.String(), 0)
+			}
+		}
+		return sc.Err()
+	}
+	snap, err := ReadZone(bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	if snap.Zone != "" && !strings.EqualFold(snap.Zone, f.zone) {
+		return fmt.Errorf("holds zone %s, not %s", snap.Zone, f.zone)
+	}
+	for _, r := range snap.Records {
+		if ip := net.ParseIP(r.Data); ip != nil {
+			f.add(strings.ToLower(fqdn(f.zone, r.Name)), ip.String(), r.TTL)
+		}
+	}
+	return nil
+}
+
+// add adds an address to a record.
+func (f *recordFile) add(name, addr string, ttl int) {
+	if f.records[name] == nil {
+		f.records[name] = make(map[string]bool)
+	}
+	f.records[name][addr] = true
+	if ttl > 0 {
+		f.ttls[name] = ttl
+	}
+}
+
+// render returns the contents of the file.
+func (f *recordFile) render() []byte {
+	type line struct{ name, addr string }
+	var lines []line
+	for name, addrs := range f.records {
+		for addr := range addrs {
+			lines = append(lines, line{name: name, addr: addr})
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].name != lines[j].name {
+			return lines[i].name < lines[j].name
+		}
+		return lines[i].addr < lines[j].addr
+	})
+	buf := new(bytes.Buffer)
+	if f.cfg.Format == "hosts" {
+		buf.WriteString("# maintained by nodedns; changes will be overwritten\n")
+		for _, l := range lines {
+			fmt.Fprintf(buf, "%s\t%s\n", l.addr, l.name)
+		}
+		return buf.Bytes()
+	}
+	fmt.Fprintf(buf, "; maintained by nodedns; changes will be overwritten\n$ORIGIN %s.\n", f.zone)
+	for _, l := range lines {
+		fmt.Fprintf(buf, "%s\t%d\tIN\t%s\t%s\n", RelativeName(f.zone, l.name), f.ttls[l.name], recordType(net.ParseIP(l.addr)), l.addr)
+	}
+	return buf.Bytes()
+}
+
+// write replaces the file with the current records, and runs the reload command.
+func (f *recordFile) write(ctx context.Context) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(f.cfg.Path), "."+filepath.Base(f.cfg.Path)+".*")
+	if err != nil {
+		return fmt.Errorf("create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // nolint:errcheck
+	if _, err := tmp.Write(f.render()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write temporary file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close temporary file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("chmod temporary file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), f.cfg.Path); err != nil {
+		return fmt.Errorf("replace file: %w", err)
+	}
+	args, err := splitCommand(f.cfg.ReloadCommand)
+	if err != nil {
+		return fmt.Errorf("reload command: %w", err)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, f.cfg.ReloadTimeout)
+	defer cancel()
+	for i, arg := range args {
+		args[i] = strings.NewReplacer("${NODEDNS_FILE}", f.cfg.Path, "$NODEDNS_FILE", f.cfg.Path).Replace(arg)
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...) // nolint:gosec
+	cmd.Env = append(os.Environ(), "NODEDNS_FILE="+f.cfg.Path)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("run reload command: %w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// FQDN implements Provider.
+func (p *File) FQDN(record string) string {
+	return fqdn(p.opts.Zone, record)
+}
+
+// UpdateDNS implements Provider.
+func (p *File) UpdateDNS(ctx context.Context, record string, addresses []net.IP) (err error) {
+	if record == "" {
+		return nil
+	}
+	ctx, span := tracing.Start(ctx, "file_dns_update")
+	defer span.End()
+	zone, name := p.opts.Zone, strings.ToLower(p.FQDN(record))
+	dnsUpdateAttempts.WithLabelValues("file", zone, record).Inc()
+	defer func(start time.Time) {
+		observeUpdate(ctx, "file", zone, record, start, err)
+	}(time.Now())
+	l := zap.L().Named("file-dns").With(correlation.Field(ctx))
+
+	f := p.f
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	current := f.records[name]
+	if current == nil {
+		current = make(map[string]bool)
+		f.records[name] = current
+	}
+	if updateSet(ctx, l, "file", p.opts, record, name, current, addresses) {
+		f.dirty = true
+	}
+	if len(current) == 0 {
+		delete(f.records, name)
+		delete(f.ttls, name)
+	} else if ttl := int(p.opts.TTL.Seconds()); ttl > 0 && f.ttls[name] != ttl && !p.opts.Audit {
+		f.ttls[name] = ttl
+		f.dirty = true
+	}
+	reportPublished("file", zone, p.opts.Family, record, current)
+	if f.dirty && !p.opts.Audit {
+		if err := f.write(ctx); err != nil {
+			return fmt.Errorf("%s: %w", f.cfg.Path, err)
+		}
+		f.dirty = false
+		l.Debug("wrote records", zap.String("path", f.cfg.Path))
+	}
+	dnsUpdatedOK.WithLabelValues("file", zone, record).Inc()
+	return nil
+}
+
+// Export implements Exporter.
+func (p *File) Export(ctx context.Context, names []string) (*Snapshot, error) {
+	f := p.f
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	snap := &Snapshot{Zone: p.opts.Zone, Taken: time.Now().UTC()}
+	for _, n := range names {
+		name := strings.ToLower(p.FQDN(n))
+		for addr := range f.records[name] {
+			snap.Records = append(snap.Records, SnapshotRecord{Name: RelativeName(p.opts.Zone, name), Type: recordType(net.ParseIP(addr)), TTL: f.ttls[name], Data: addr})
+		}
+	}
+	snap.sort()
+	return snap, nil
+}
@@ -0,0 +1,139 @@
+package dns
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestFile(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "example.com.zone")
+	reloads := filepath.Join(dir, "reloads")
+	cfg := FileConfig{Path: path, Format: "zone", ReloadCommand: `sh -c 'echo "$NODEDNS_FILE" >> "$1"' reload ` + reloads, ReloadTimeout: time.Minute}
+	external, err := NewFile(cfg, ProviderOptions{Zone: "example.com", TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A second provider for the same file shares its records.
+	internal, err := NewFile(cfg, ProviderOptions{Zone: "example.com.", TTL: 5 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFile(cfg, ProviderOptions{Zone: "example.org"}); err == nil {
+		t.Error("another zone in the same zone file: expected error")
+	}
+
+	if err := external.UpdateDNS(ctx, "nodes", []net.IP{net.ParseIP("1.2.3.4"), net.ParseIP("2001:db8::1")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := internal.UpdateDNS(ctx, "internal.example.com", []net.IP{net.ParseIP("10.0.0.1")}); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing changes, so the file isn't written again.
+	if err := external.UpdateDNS(ctx, "nodes", []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("1.2.3.4")}); err != nil {
+		t.Fatal(err)
+	}
+	want := `; maintained by nodedns; changes will be overwritten
+$ORIGIN example.com.
+internal	300	IN	A	10.0.0.1
+nodes	60	IN	A	1.2.3.4
+nodes	60	IN	AAAA	2001:db8::1
+`
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(got), want); diff != "" {
+		t.Errorf("zone file:\n%s", diff)
+	}
+	gotReloads, err := ioutil.ReadFile(reloads)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(strings.Fields(string(gotReloads)), []string{path, path}); diff != "" {
+		t.Errorf("reloads:\n%s", diff)
+	}
+
+	// A failing reload command fails the update, and the file is written again next time.
+	failing := cfg
+	failing.Path, failing.ReloadCommand = filepath.Join(dir, "failing.zone"), "sh -c 'echo oops; exit 1'"
+	p, err := NewFile(failing, ProviderOptions{Zone: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UpdateDNS(ctx, "nodes", []net.IP{net.ParseIP("1.2.3.4")}); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("failing reload:\n  got: %v\n want: an error containing the command's output", err)
+	}
+	if !p.f.dirty {
+		t.Error("expected the file to be written again after a failed reload")
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	testData := []struct {
+		command string
+		want    []string
+		wantErr bool
+	}{
+		{command: "", want: nil},
+		{command: "rndc reload example.com", want: []string{"rndc", "reload", "example.com"}},
+		{command: "  touch\t$NODEDNS_FILE.done  ", want: []string{"touch", "$NODEDNS_FILE.done"}},
+		{command: `sh -c 'echo "$NODEDNS_FILE"; exit 1'`, want: []string{"sh", "-c", `echo "$NODEDNS_FILE"; exit 1`}},
+		{command: `kill -HUP "" a\ b "it's"`, want: []string{"kill", "-HUP", "", "a b", "it's"}},
+		{command: "echo 'oops", wantErr: true},
+		{command: `echo oops\`, wantErr: true},
+	}
+	for _, test := range testData {
+		got, err := splitCommand(test.command)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: error:\n  got: %v\n want error: %v", test.command, err, test.wantErr)
+			continue
+		}
+		if diff := cmp.Diff(got, test.want); diff != "" {
+			t.Errorf("%q:\n%s", test.command, diff)
+		}
+	}
+}
+
+func TestFileHosts(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "hosts")
+	// The records already in the file are kept until they're updated.
+	if err := ioutil.WriteFile(path, []byte("10.0.0.9 old.example.com # a comment\n10.0.0.1\tnodes.example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewFile(FileConfig{Path: path, Format: "hosts", ReloadTimeout: time.Minute}, ProviderOptions{Zone: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UpdateDNS(ctx, "nodes", []net.IP{net.ParseIP("10.0.0.2")}); err != nil {
+		t.Fatal(err)
+	}
+	want := "# maintained by nodedns; changes will be overwritten\n10.0.0.2\tnodes.example.com\n10.0.0.9\told.example.com\n"
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(got), want); diff != "" {
+		t.Errorf("hosts file:\n%s", diff)
+	}
+	snap, err := p.Export(ctx, []string{"nodes"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(snap.Records, []SnapshotRecord{{Name: "nodes", Type: "A", Data: "10.0.0.2"}}); diff != "" {
+		t.Errorf("export:\n%s", diff)
+	}
+}
@@ -0,0 +1,207 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var dnsUpdatesRefused = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "dns_updates_refused",
+		Help: "The number of updates that were refused because they would have deleted too many of a record's addresses at once.",
+	},
+	[]string{"zone", "record"},
+)
+
+// GuardConfig configures the safety threshold for mass deletions.
+type GuardConfig struct {
+	MaxDeleteFraction float64 `long:"max_delete_fraction" env:"MAX_DELETE_FRACTION" description:"refuse to update a record if it would remove more than this fraction of its addresses at once; 1 to allow any update" default:"1"`
+	MinRecords        int     `long:"min_records" env:"MIN_RECORDS" description:"refuse to update a record if it would leave fewer than this many addresses in it, unless it already has fewer; the default refuses to empty a record, like when the node list comes back empty; 0 to allow emptying records" default:"1"`
+}
+
+// Enabled returns true if the configuration would refuse any updates.
+func (cfg *GuardConfig) Enabled() bool {
+	return cfg.MaxDeleteFraction < 1 || cfg.MinRecords > 0
+}
+
+// Validate returns an error if the configuration is invalid.
+func (cfg *GuardConfig) Validate() error {
+	if cfg.MaxDeleteFraction < 0 || cfg.MaxDeleteFraction > 1 {
+		return fmt.Errorf("max delete fraction %v: must be between 0 and 1", cfg.MaxDeleteFraction)
+	}
+	if cfg.MinRecords < 0 {
+		return fmt.Errorf("min records %v: must not be negative", cfg.MinRecords)
+	}
+	return nil
+}
+
+// GuardError is returned when an update is refused because it would delete too many addresses.
+type GuardError struct {
+	Record    string // The fully-qualified name of the record.
+	Existing  int    // How many addresses the record has.
+	Kept      int    // How many of them the update would keep.
+	Desired   int    // How many addresses the update would leave in the record.
+	MaxDelete int    // How many addresses may be deleted at once.
+
+	// TooFew is true if the update was refused because it would leave fewer than MinRecords
+	// addresses, rather than because it would delete more than MaxDelete.
+	TooFew     bool
+	MinRecords int
+	// Records is true if the counts are of whole records in a set, rather than of one record's
+	// addresses; see CheckRemovals.
+	Records bool
+}
+
+// Error implements error.
+func (e *GuardError) Error() string {
+	what := "addresses"
+	if e.Records {
+		what = "records"
+	}
+	if e.TooFew {
+		return fmt.Sprintf("refusing to update %s: it would go from %d %s to %d, fewer than the minimum of %d", e.Record, e.Existing, what, e.Desired, e.MinRecords)
+	}
+	return fmt.Sprintf("refusing to update %s: it would delete %d of its %d %s, more than the maximum of %d at once", e.Record, e.Existing-e.Kept, e.Existing, what, e.MaxDelete)
+}
+
+// Guard is a Provider that refuses updates that would delete too many of a record's addresses at
+// once, like when a problem with the Kubernetes API makes every node seem to disappear.  A refused
+// update returns a GuardError, so that it's retried; when the nodes come back, the next update goes
+// through.  The record's current addresses come from the Provider's Export, if it's an Exporter,
+// or else from the last successful update.
+type Guard struct {
+	Provider Provider
+	cfg      GuardConfig
+	family   string
+
+	mu        sync.Mutex
+	published map[string]map[string]bool // The addresses in each record after its last update.
+}
+
+var (
+	_ Provider = (*Guard)(nil)
+	_ Exporter = (*Guard)(nil)
+)
+
+// NewGuard returns a Guard that updates p, which manages the records of the family.
+func NewGuard(p Provider, family string, cfg GuardConfig) *Guard {
+	return &Guard{Provider: p, cfg: cfg, family: family, published: make(map[string]map[string]bool)}
+}
+
+// FQDN implements Provider.
+func (g *Guard) FQDN(record string) string {
+	return g.Provider.FQDN(record)
+}
+
+// Export implements Exporter, if the underlying Provider does.
+func (g *Guard) Export(ctx context.Context, names []string) (*Snapshot, error) {
+	e, ok := g.Provider.(Exporter)
+	if !ok {
+		return nil, errors.New("the dns provider can't export records")
+	}
+	return e.Export(ctx, names)
+}
+
+// current returns the addresses that the record has now, if they're known.
+func (g *Guard) current(ctx context.Context, record string) (map[string]bool, error) {
+	if e, ok := g.Provider.(Exporter); ok {
+		snap, err := e.Export(ctx, []string{record})
+		if err != nil {
+			return nil, fmt.Errorf("export: %w", err)
+		}
+		result := make(map[string]bool)
+		for _, r := range snap.Records {
+			if manages(g.family, r.Type) {
+				result[Canonical(r.Data)] = true
+			}
+		}
+		return result, nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.published[record], nil
+}
+
+// check returns an error if an update that would change the named record from existing addresses
+// to desired, keeping kept of them, should be refused.
+func (g *Guard) check(name string, existing, kept, desired int) *GuardError {
+	gerr := &GuardError{
+		Record:     name,
+		Existing:   existing,
+		Kept:       kept,
+		Desired:    desired,
+		MaxDelete:  int(math.Floor(g.cfg.MaxDeleteFraction * float64(existing))),
+		MinRecords: g.cfg.MinRecords,
+	}
+	switch {
+	case desired < g.cfg.MinRecords && desired < existing:
+		gerr.TooFew = true
+	case existing-kept <= gerr.MaxDelete:
+		return nil
+	}
+	return gerr
+}
+
+// UpdateDNS implements Provider.
+func (g *Guard) UpdateDNS(ctx context.Context, record string, addresses []net.IP) error {
+	if record == "" {
+		return nil
+	}
+	existing, err := g.current(ctx, record)
+	if err != nil {
+		return fmt.Errorf("get existing addresses: %w", err)
+	}
+	desired := make(map[string]bool)
+	for _, ip := range addresses {
+		if manages(g.family, recordType(ip)) {
+			desired[ip.String()] = true
+		}
+	}
+	var kept int
+	for addr := range existing {
+		if desired[addr] {
+			kept++
+		}
+	}
+	if gerr := g.check(g.FQDN(record), len(existing), kept, len(desired)); gerr != nil {
+		dnsUpdatesRefused.WithLabelValues(g.FQDN("@"), record).Inc()
+		zap.L().Named("dns-guard").Warn("refusing to delete too many addresses at once", zap.String("record", gerr.Record), zap.Int("existing", gerr.Existing), zap.Int("desired", gerr.Desired), correlation.Field(ctx))
+		return gerr
+	}
+	if err := g.Provider.UpdateDNS(ctx, record, addresses); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	g.published[record] = desired
+	g.mu.Unlock()
+	return nil
+}
+
+// CheckRemovals returns a GuardError if emptying removed of a set of existing records at once
+// should be refused, applying the thresholds to whole records instead of addresses.  It's for
+// sinks that publish a record per node or per group, like per-node records: each record is
+// emptied as a whole when its node goes away, which UpdateDNS would refuse, but a problem that
+// makes every node seem to disappear would empty all of them at once.  set names the records in
+// the error, like "per-node records".
+func (g *Guard) CheckRemovals(set string, existing, removed int) error {
+	if removed == 0 {
+		return nil
+	}
+	gerr := g.check(set, existing, existing-removed, existing-removed)
+	if gerr == nil {
+		return nil
+	}
+	gerr.Records = true
+	dnsUpdatesRefused.WithLabelValues(g.FQDN("@"), set).Inc()
+	zap.L().Named("dns-guard").Warn("refusing to empty too many records at once", zap.String("records", set), zap.Int("existing", existing), zap.Int("removed", removed))
+	return gerr
+}
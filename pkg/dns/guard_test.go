@@ -0,0 +1,108 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/fakedo"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+)
+
+// memoryProvider is a Provider that can't export its records.
+type memoryProvider struct {
+	records map[string][]net.IP
+}
+
+func (p *memoryProvider) FQDN(record string) string { return fqdn("example.com", record) }
+
+func (p *memoryProvider) UpdateDNS(ctx context.Context, record string, addresses []net.IP) error {
+	p.records[record] = addresses
+	return nil
+}
+
+func TestGuard(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	s := fakedo.New("example.com")
+	defer s.Close()
+	for _, addr := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"} {
+		s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "nodes", Data: addr})
+	}
+	ctx := context.Background()
+	c, err := NewClientFromGodo(ctx, s.Client(), "example.com", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ips := func(n int) []net.IP {
+		var result []net.IP
+		for i := 1; i <= n; i++ {
+			result = append(result, net.IPv4(10, 0, 0, byte(i)))
+		}
+		return result
+	}
+
+	g := NewGuard(c, "", GuardConfig{MaxDeleteFraction: 0.5, MinRecords: 1})
+	var ge *GuardError
+	if err := g.UpdateDNS(ctx, "nodes", ips(1)); !errors.As(err, &ge) || ge.TooFew {
+		t.Errorf("deleting 3 of 4:\n  got: %v\n want: a GuardError for deleting too many", err)
+	}
+	if err := g.UpdateDNS(ctx, "nodes", ips(2)); err != nil {
+		t.Errorf("deleting 2 of 4: %v", err)
+	}
+	if err := g.UpdateDNS(ctx, "nodes", ips(1)); err != nil {
+		t.Errorf("deleting 1 of 2: %v", err)
+	}
+	if err := g.UpdateDNS(ctx, "nodes", nil); !errors.As(err, &ge) || !ge.TooFew {
+		t.Errorf("emptying the record:\n  got: %v\n want: a GuardError for leaving too few", err)
+	}
+	want := map[string][]string{"nodes": {"10.0.0.1"}}
+	if diff := cmp.Diff(s.Addresses("example.com"), want); diff != "" {
+		t.Errorf("addresses:\n%s", diff)
+	}
+	// New records can be created with fewer addresses than the minimum.
+	if err := g.UpdateDNS(ctx, "other", nil); err != nil {
+		t.Errorf("update of an empty record: %v", err)
+	}
+
+	// Without an export, the guard goes by what it published last.
+	m := &memoryProvider{records: make(map[string][]net.IP)}
+	g = NewGuard(m, "ipv4", GuardConfig{MaxDeleteFraction: 0.5})
+	if err := g.UpdateDNS(ctx, "nodes", ips(4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.UpdateDNS(ctx, "nodes", append(ips(1), net.ParseIP("2001:db8::1"))); !errors.As(err, &ge) {
+		t.Errorf("deleting 3 of 4 from memory:\n  got: %v\n want: a GuardError", err)
+	}
+	if got, want := len(m.records["nodes"]), 4; got != want {
+		t.Errorf("addresses in memory provider:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+func TestGuardCheckRemovals(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	g := NewGuard(&memoryProvider{}, "", GuardConfig{MaxDeleteFraction: 0.5, MinRecords: 1})
+	for _, test := range []struct {
+		existing, removed int
+		wantErr           bool
+	}{
+		{existing: 4, removed: 0},
+		{existing: 4, removed: 1},
+		{existing: 4, removed: 2},
+		{existing: 4, removed: 3, wantErr: true},
+		{existing: 1, removed: 1, wantErr: true},
+	} {
+		err := g.CheckRemovals("per-node records", test.existing, test.removed)
+		var ge *GuardError
+		if got, want := errors.As(err, &ge), test.wantErr; got != want {
+			t.Errorf("removing %d of %d:\n  got: %v\n want: error=%v", test.removed, test.existing, err, want)
+		}
+		if ge != nil && !ge.Records {
+			t.Errorf("removing %d of %d: error isn't about records: %v", test.removed, test.existing, err)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package dns
+
+import (
+	"context"
+	"net"
+)
+
+// Mutation is a change that a Provider made to a zone: one A, AAAA, or SRV record created or
+// deleted.
+type Mutation struct {
+	Action   string // "create" or "delete".
+	Provider string // The name of the provider, like "digitalocean".
+	Zone     string
+	Record   string // The fully-qualified name of the record.
+	Type     string // "A", "AAAA", or "SRV".
+	Address  string // For SRV records, the record's data, like "0 0 30443 nodes.example.com.".
+	// ID is the provider's ID for the record, if it has them: the record ID for DigitalOcean and
+	// Cloudflare, the service ID for Consul, the key for etcd, and the ID of the change that
+	// replaced the record set for Cloud DNS.
+	ID string
+}
+
+// mutated calls f, if it's non-nil, with a record that was created or deleted.
+func mutated(ctx context.Context, f func(context.Context, Mutation), m Mutation) {
+	if f == nil {
+		return
+	}
+	if ip := net.ParseIP(m.Address); ip != nil {
+		m.Type = recordType(ip)
+	}
+	f(ctx, m)
+}
@@ -0,0 +1,89 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var dnsOrphansDeleted = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "dns_orphans_deleted",
+		Help: "The number of records marked with this instance's owner that were deleted because nothing publishes them any more.",
+	},
+	[]string{"provider", "zone"},
+)
+
+// OrphanCollector is a Provider that keeps an ownership registry, and can delete the records that
+// it owns but no longer publishes.
+type OrphanCollector interface {
+	Owned(ctx context.Context) ([]string, error)
+	DeleteOrphans(ctx context.Context, keep func(name string) bool) ([]string, error)
+}
+
+var _ OrphanCollector = (*Client)(nil)
+
+// Owned returns the fully-qualified names of the records in the zone that are marked with the
+// client's owner (see WithOwner), sorted.  It returns nothing if the client doesn't keep an
+// ownership registry.
+func (c *Client) Owned(ctx context.Context) ([]string, error) {
+	if c.owner == "" {
+		return nil, nil
+	}
+	_, _, txts, err := c.listRecords(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	marker := OwnerMarker(c.owner)
+	seen := make(map[string]bool)
+	var result []string
+	for _, rec := range txts {
+		name := strings.ToLower(c.FQDN(rec.Name))
+		if strings.Trim(rec.Data, `"`) == marker && !seen[name] {
+			seen[name] = true
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// DeleteOrphans deletes the A and AAAA records, and the ownership marker, of each record that the
+// client owns and keep returns false for, given the record's lowercase fully-qualified name, and
+// returns the names of the records that it deleted.  Auditing and create-only clients only log
+// them.  Records of both families are deleted, even if the client only manages one.  It returns
+// the first error encountered, after attempting to delete every orphan.
+func (c *Client) DeleteOrphans(ctx context.Context, keep func(name string) bool) ([]string, error) {
+	owned, err := c.Owned(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list owned records: %w", err)
+	}
+	l := zap.L().Named("orphans")
+	cc := c.WithFamily("")
+	var deleted []string
+	var result error
+	for _, name := range owned {
+		if keep(name) {
+			continue
+		}
+		if c.audit || c.createOnly {
+			l.Info("orphaned record; auditing or create-only, so not deleting it", zap.String("record", name))
+			continue
+		}
+		if err := cc.UpdateDNS(ctx, RelativeName(c.zone, name), nil); err != nil {
+			if result == nil {
+				result = fmt.Errorf("delete %s: %w", name, err)
+			}
+			continue
+		}
+		l.Info("deleted orphaned record", zap.String("record", name), zap.String("owner", OwnerMarker(c.owner)))
+		dnsOrphansDeleted.WithLabelValues("digitalocean", c.zone).Inc()
+		deleted = append(deleted, name)
+	}
+	return deleted, result
+}
@@ -0,0 +1,69 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/fakedo"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestDeleteOrphans(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	s := fakedo.New("example.com")
+	defer s.Close()
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "by-hand", Data: "10.0.0.1"})
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "other", Data: "10.0.0.2"})
+	s.AddRecord("example.com", godo.DomainRecord{Type: "TXT", Name: "other", Data: OwnerMarker("other")})
+	ctx := context.Background()
+	c, err := NewClientFromGodo(ctx, s.Client(), "example.com", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = c.WithOwner("main")
+	for _, record := range []string{"nodes", "old-nodes", "@"} {
+		if err := c.UpdateDNS(ctx, record, []net.IP{net.ParseIP("10.0.0.3"), net.ParseIP("2001:db8::1")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	owned, err := c.Owned(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(owned, []string{"example.com", "nodes.example.com", "old-nodes.example.com"}); diff != "" {
+		t.Errorf("owned:\n%s", diff)
+	}
+
+	// Auditing only reports orphans.
+	keep := func(name string) bool { return name == "nodes.example.com" }
+	if _, err := c.Audit().DeleteOrphans(ctx, keep); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(s.Addresses("example.com")), 5; got != want {
+		t.Errorf("records after audit:\n  got: %v\n want: %v", got, want)
+	}
+
+	// A client that only manages one family still deletes the whole record, and its marker.
+	deleted, err := c.WithFamily("ipv4").DeleteOrphans(ctx, keep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(deleted, []string{"example.com", "old-nodes.example.com"}); diff != "" {
+		t.Errorf("deleted:\n%s", diff)
+	}
+	wantAddrs := map[string][]string{"by-hand": {"10.0.0.1"}, "other": {"10.0.0.2"}, "nodes": {"10.0.0.3", "2001:db8::1"}}
+	if diff := cmp.Diff(s.Addresses("example.com"), wantAddrs); diff != "" {
+		t.Errorf("addresses:\n%s", diff)
+	}
+	if owned, err := c.Owned(ctx); err != nil {
+		t.Fatal(err)
+	} else if diff := cmp.Diff(owned, []string{"nodes.example.com"}); diff != "" {
+		t.Errorf("owned after deleting orphans:\n%s", diff)
+	}
+}
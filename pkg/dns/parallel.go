@@ -0,0 +1,65 @@
+package dns
+
+import (
+	"errors"
+	"sync"
+)
+
+// errNotAttempted is the result of a change that wasn't attempted because another one failed.
+var errNotAttempted = errors.New("not attempted")
+
+// parallel calls f with each index in [0, n), running at most limit calls at a time (one at a time
+// if limit is less than 2), and returns each call's error.  Once a call fails, no more are started;
+// the calls that weren't started return errNotAttempted.
+func parallel(n, limit int, f func(i int) error) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = errNotAttempted
+	}
+	if limit < 1 {
+		limit = 1
+	}
+	if limit > n {
+		limit = n
+	}
+	var mu sync.Mutex
+	var next int
+	var failed bool
+	var wg sync.WaitGroup
+	for w := 0; w < limit; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				if failed || next >= n {
+					mu.Unlock()
+					return
+				}
+				i := next
+				next++
+				mu.Unlock()
+
+				err := f(i)
+				mu.Lock()
+				errs[i] = err
+				if err != nil {
+					failed = true
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+// firstError returns the first error in errs that isn't errNotAttempted.
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil && err != errNotAttempted {
+			return err
+		}
+	}
+	return nil
+}
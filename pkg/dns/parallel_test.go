@@ -0,0 +1,52 @@
+package dns
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParallel(t *testing.T) {
+	var mu sync.Mutex
+	var running, most int
+	errs := parallel(20, 4, func(i int) error {
+		mu.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})
+	if err := firstError(errs); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if most < 2 || most > 4 {
+		t.Errorf("most calls at once:\n  got: %v\n want: 2 to 4", most)
+	}
+
+	// Nothing is started after a failure; with one at a time, that's everything after it.
+	boom := errors.New("boom")
+	var calls []int
+	errs = parallel(5, 1, func(i int) error {
+		calls = append(calls, i)
+		if i == 2 {
+			return boom
+		}
+		return nil
+	})
+	if got, want := len(calls), 3; got != want {
+		t.Errorf("calls after a failure:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := firstError(errs), boom; got != want {
+		t.Errorf("first error:\n  got: %v\n want: %v", got, want)
+	}
+	if errs[1] != nil || errs[4] != errNotAttempted {
+		t.Errorf("errors:\n  got: %v\n want: success before the failure, and not attempted after", errs)
+	}
+}
@@ -0,0 +1,191 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Planner is a Provider that can work out what UpdateDNS would change, without changing anything,
+// for dry runs.
+type Planner interface {
+	Plan(ctx context.Context, record string, addresses []net.IP) (*Plan, error)
+}
+
+// ErrCannotPlan is returned by Plan when the provider can't plan updates.
+var ErrCannotPlan = errors.New("the dns provider can't plan updates")
+
+var (
+	_ Planner = (*Client)(nil)
+	_ Planner = (*Guard)(nil)
+)
+
+// Plan is what an update would change in a record.
+type Plan struct {
+	Record     string   `json:"record"`               // The fully-qualified name of the record.
+	Create     []string `json:"create,omitempty"`     // Addresses that would be added.
+	Delete     []string `json:"delete,omitempty"`     // Addresses that would be removed.
+	Keep       []string `json:"keep,omitempty"`       // Addresses that are already in the record.
+	Duplicates int      `json:"duplicates,omitempty"` // Redundant records for kept addresses that would be removed.
+	TTL        []string `json:"ttl,omitempty"`        // Kept addresses whose records' TTL would be changed.
+	Refused    string   `json:"refused,omitempty"`    // Why the update would fail without changing anything, if it would.
+}
+
+// Empty returns true if the update wouldn't change anything.
+func (p *Plan) Empty() bool {
+	return p.Refused != "" || len(p.Create)+len(p.Delete)+len(p.TTL)+p.Duplicates == 0
+}
+
+// String returns a one-line summary of the plan, like "nodes.example.com: +10.0.0.2 -10.0.0.1 (1
+// unchanged)".
+func (p *Plan) String() string {
+	if p.Refused != "" {
+		return fmt.Sprintf("%s: refused: %s", p.Record, p.Refused)
+	}
+	if p.Empty() {
+		return fmt.Sprintf("%s: unchanged (%d addresses)", p.Record, len(p.Keep))
+	}
+	var parts []string
+	for _, ip := range p.Create {
+		parts = append(parts, "+"+ip)
+	}
+	for _, ip := range p.Delete {
+		parts = append(parts, "-"+ip)
+	}
+	if p.Duplicates > 0 {
+		parts = append(parts, fmt.Sprintf("-%d duplicates", p.Duplicates))
+	}
+	for _, ip := range p.TTL {
+		parts = append(parts, "~"+ip)
+	}
+	return fmt.Sprintf("%s: %s (%d unchanged)", p.Record, strings.Join(parts, " "), len(p.Keep))
+}
+
+// Plan implements Planner.  It lists the record like UpdateDNS does, and reports the changes that
+// UpdateDNS would make, including those that an audit, ownership registry, or CNAME would stop.
+func (c *Client) Plan(ctx context.Context, record string, addresses []net.IP) (*Plan, error) {
+	result := &Plan{Record: c.FQDN(record)}
+	if record == "" {
+		return result, nil
+	}
+	existing, ttls, cname, owners, err := c.getRecords(ctx, record)
+	if err != nil {
+		return nil, fmt.Errorf("get existing records: %w", err)
+	}
+	var managed []net.IP
+	desired := make(map[string]bool)
+	for _, ip := range addresses {
+		if c.manages(recordType(ip)) {
+			managed = append(managed, ip)
+			desired[ip.String()] = true
+		}
+	}
+	_, toCreate, toDeleteAddrs := diffDNS(managed, existing)
+	_, result.TTL = staleTTLs(desired, existing, ttls, c.ttlSeconds())
+	for _, ip := range toCreate {
+		result.Create = append(result.Create, ip.String())
+	}
+	deleted := make(map[string]bool)
+	for _, addr := range toDeleteAddrs {
+		if desired[addr] {
+			// Another record still contains the address.
+			result.Duplicates++
+			continue
+		}
+		if !deleted[addr] {
+			deleted[addr] = true
+			result.Delete = append(result.Delete, addr)
+		}
+	}
+	for addr := range existing {
+		if !deleted[addr] {
+			result.Keep = append(result.Keep, addr)
+		}
+	}
+	sort.Strings(result.Create)
+	sort.Strings(result.Delete)
+	sort.Strings(result.Keep)
+	if c.createOnly {
+		result.Keep = append(result.Keep, result.Delete...)
+		sort.Strings(result.Keep)
+		result.Delete, result.Duplicates = nil, 0
+	}
+	switch _, err := c.checkOwner(record, existing, owners, managed); {
+	case c.audit:
+		result.Refused = "auditing; records are never changed"
+	case err != nil:
+		result.Refused = err.Error()
+	case cname != nil && len(result.Create) > 0:
+		result.Refused = (&ConflictError{Record: c.FQDN(record), Target: cname.Data}).Error()
+	}
+	return result, nil
+}
+
+// Plan implements Planner, if the underlying Provider does.  Updates that the guard would refuse
+// are planned as refused.
+func (g *Guard) Plan(ctx context.Context, record string, addresses []net.IP) (*Plan, error) {
+	p, ok := g.Provider.(Planner)
+	if !ok {
+		return nil, ErrCannotPlan
+	}
+	result, err := p.Plan(ctx, record, addresses)
+	if err != nil || result.Refused != "" {
+		return result, err
+	}
+	existing := len(result.Keep) + len(result.Delete)
+	if gerr := g.check(g.FQDN(record), existing, len(result.Keep), len(result.Keep)+len(result.Create)); gerr != nil {
+		result.Refused = gerr.Error()
+	}
+	return result, nil
+}
+
+// PlanLog keeps the latest plan for each record, and serves them: as JSON, or one line per record
+// with ?format=text.
+type PlanLog struct {
+	mu    sync.Mutex
+	plans map[string]*Plan
+}
+
+// Add records a plan, replacing the record's previous plan.
+func (l *PlanLog) Add(p *Plan) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.plans == nil {
+		l.plans = make(map[string]*Plan)
+	}
+	l.plans[p.Record] = p
+}
+
+// Plans returns the latest plan for each record, sorted by record.
+func (l *PlanLog) Plans() []*Plan {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	result := make([]*Plan, 0, len(l.plans))
+	for _, p := range l.plans {
+		result = append(result, p)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Record < result[j].Record })
+	return result
+}
+
+// ServeHTTP implements http.Handler.
+func (l *PlanLog) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	plans := l.Plans()
+	if req.URL.Query().Get("format") == "text" {
+		w.Header().Set("content-type", "text/plain")
+		for _, p := range plans {
+			fmt.Fprintln(w, p.String())
+		}
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	e.Encode(plans) // nolint:errcheck
+}
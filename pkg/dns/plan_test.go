@@ -0,0 +1,152 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/fakedo"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestPlan(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	s := fakedo.New("example.com")
+	defer s.Close()
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "nodes", Data: "10.0.0.1", TTL: 30})
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "nodes", Data: "10.0.0.1", TTL: 30})
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "nodes", Data: "10.0.0.2", TTL: 3600})
+	s.AddRecord("example.com", godo.DomainRecord{Type: "CNAME", Name: "lb", Data: "lb.example.net."})
+	ctx := context.Background()
+	c, err := NewClientFromGodo(ctx, s.Client(), "example.com", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := s.Addresses("example.com")
+	desired := []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 3)}
+
+	testData := []struct {
+		name   string
+		plan   func() (*Plan, error)
+		want   *Plan
+		String string
+	}{
+		{
+			name: "update",
+			plan: func() (*Plan, error) { return c.Plan(ctx, "nodes", desired) },
+			want: &Plan{
+				Record:     "nodes.example.com",
+				Create:     []string{"10.0.0.3"},
+				Delete:     []string{"10.0.0.2"},
+				Keep:       []string{"10.0.0.1"},
+				Duplicates: 1,
+			},
+			String: "nodes.example.com: +10.0.0.3 -10.0.0.2 -1 duplicates (1 unchanged)",
+		},
+		{
+			name: "create only",
+			plan: func() (*Plan, error) { return c.CreateOnly().Plan(ctx, "nodes", desired) },
+			want: &Plan{
+				Record: "nodes.example.com",
+				Create: []string{"10.0.0.3"},
+				Keep:   []string{"10.0.0.1", "10.0.0.2"},
+			},
+			String: "nodes.example.com: +10.0.0.3 (2 unchanged)",
+		},
+		{
+			name: "unchanged",
+			plan: func() (*Plan, error) {
+				return c.Plan(ctx, "nodes", []net.IP{net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 1)})
+			},
+			want:   &Plan{Record: "nodes.example.com", Keep: []string{"10.0.0.1", "10.0.0.2"}, Duplicates: 1, TTL: []string{"10.0.0.2"}},
+			String: "nodes.example.com: -1 duplicates ~10.0.0.2 (2 unchanged)",
+		},
+		{
+			name: "cname",
+			plan: func() (*Plan, error) { return c.Plan(ctx, "lb", desired) },
+			want: &Plan{
+				Record:  "lb.example.com",
+				Create:  []string{"10.0.0.1", "10.0.0.3"},
+				Refused: "lb.example.com is a CNAME to lb.example.net., so A and AAAA records can't be added to it; delete the CNAME record, or publish the addresses to another name",
+			},
+			String: "lb.example.com: refused: lb.example.com is a CNAME to lb.example.net., so A and AAAA records can't be added to it; delete the CNAME record, or publish the addresses to another name",
+		},
+		{
+			name: "guard",
+			plan: func() (*Plan, error) {
+				return NewGuard(c, "", GuardConfig{MaxDeleteFraction: 1, MinRecords: 3}).Plan(ctx, "nodes", []net.IP{net.IPv4(10, 0, 0, 1)})
+			},
+			want: &Plan{
+				Record:     "nodes.example.com",
+				Delete:     []string{"10.0.0.2"},
+				Keep:       []string{"10.0.0.1"},
+				Duplicates: 1,
+				Refused:    "refusing to update nodes.example.com: it would go from 2 addresses to 1, fewer than the minimum of 3",
+			},
+			String: "nodes.example.com: refused: refusing to update nodes.example.com: it would go from 2 addresses to 1, fewer than the minimum of 3",
+		},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.plan()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("plan:\n%s", diff)
+			}
+			if got, want := got.String(), test.String; got != want {
+				t.Errorf("string:\n  got: %v\n want: %v", got, want)
+			}
+		})
+	}
+	if diff := cmp.Diff(s.Addresses("example.com"), before); diff != "" {
+		t.Errorf("planning changed the zone:\n%s", diff)
+	}
+
+	_, err = NewGuard(&memoryProvider{records: make(map[string][]net.IP)}, "", GuardConfig{}).Plan(ctx, "nodes", desired)
+	if !errors.Is(err, ErrCannotPlan) {
+		t.Errorf("planning without a planner:\n  got: %v\n want: %v", err, ErrCannotPlan)
+	}
+}
+
+func TestPlanLog(t *testing.T) {
+	var l PlanLog
+	l.Add(&Plan{Record: "b.example.com", Keep: []string{"10.0.0.1"}})
+	l.Add(&Plan{Record: "a.example.com", Create: []string{"10.0.0.2"}})
+	l.Add(&Plan{Record: "b.example.com", Delete: []string{"10.0.0.1"}})
+
+	rec := httptest.NewRecorder()
+	l.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/plan?format=text", nil))
+	if got, want := rec.Body.String(), "a.example.com: +10.0.0.2 (0 unchanged)\nb.example.com: -10.0.0.1 (0 unchanged)\n"; got != want {
+		t.Errorf("text:\n  got: %q\n want: %q", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	l.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/plan", nil))
+	want := `[
+  {
+    "record": "a.example.com",
+    "create": [
+      "10.0.0.2"
+    ]
+  },
+  {
+    "record": "b.example.com",
+    "delete": [
+      "10.0.0.1"
+    ]
+  }
+]
+`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("json:\n  got: %v\n want: %v", got, want)
+	}
+}
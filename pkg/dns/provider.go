@@ -0,0 +1,91 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+// Provider publishes address records in a DNS zone.  Client, for DigitalOcean, is one
+// implementation.
+type Provider interface {
+	// UpdateDNS makes the A and AAAA records named record contain exactly the provided
+	// addresses.
+	UpdateDNS(ctx context.Context, record string, addresses []net.IP) error
+	// FQDN returns the fully-qualified name of a record that UpdateDNS would update.
+	FQDN(record string) string
+}
+
+// Exporter is a Provider that can copy its records to a Snapshot, for handing off to another
+// instance.
+type Exporter interface {
+	Export(ctx context.Context, names []string) (*Snapshot, error)
+}
+
+var (
+	_ Provider = (*Client)(nil)
+	_ Exporter = (*Client)(nil)
+)
+
+// ProviderOptions configure a Provider for one zone.
+type ProviderOptions struct {
+	Zone       string
+	TTL        time.Duration
+	Family     string // "ipv4" or "ipv6" to only manage A or AAAA records; empty for both.
+	CreateOnly bool   // Never delete records; log them instead.
+	Audit      bool   // Never change records; only report drift.
+	Owner      string // Keep an ownership registry in TXT records; only DigitalOcean supports it.  See Client.WithOwner.
+	// SkipUnchanged skips updates that wouldn't change a record, listing it again after
+	// VerifyInterval (if positive); only DigitalOcean supports it.  See Client.SkipUnchanged.
+	SkipUnchanged  bool
+	VerifyInterval time.Duration
+	// OnDrift, if set, is called with each record that has drifted, when auditing.
+	OnDrift func(ctx context.Context, zone, record string, add, remove []string)
+	// OnMutation, if set, is called with each record that is created or deleted, after the
+	// provider accepts the change; with Parallelism, it's called concurrently.  The webhook
+	// provider doesn't know what changed, so it never calls it.
+	OnMutation func(ctx context.Context, m Mutation)
+	// Parallelism is how many records to create, and then delete, at a time, for providers that
+	// make a request per record (DigitalOcean, Cloudflare, and Consul); 0 or 1 for one at a time.
+	Parallelism int
+	// AdoptUnowned marks existing records without an ownership marker as Owner's, rather than
+	// refusing to change them.  See Client.AdoptUnowned.
+	AdoptUnowned bool
+}
+
+// NewDigitalOcean returns a DigitalOcean Provider for the zone in opts, which must exist in the
+// account that godoClient is authorized for.
+func NewDigitalOcean(ctx context.Context, godoClient *godo.Client, opts ProviderOptions) (*Client, error) {
+	c, err := NewClientFromGodo(ctx, godoClient, opts.Zone, opts.TTL)
+	if err != nil {
+		return nil, fmt.Errorf("digitalocean: %w", err)
+	}
+	if opts.CreateOnly {
+		c = c.CreateOnly()
+	}
+	if opts.Audit {
+		c = c.Audit()
+	}
+	if opts.OnDrift != nil {
+		c = c.OnDrift(opts.OnDrift)
+	}
+	if opts.OnMutation != nil {
+		c = c.OnMutation(opts.OnMutation)
+	}
+	if opts.Parallelism > 1 {
+		c = c.Parallel(opts.Parallelism)
+	}
+	if opts.Owner != "" {
+		c = c.WithOwner(opts.Owner)
+	}
+	if opts.AdoptUnowned {
+		c = c.AdoptUnowned()
+	}
+	if opts.SkipUnchanged {
+		c = c.SkipUnchanged(opts.VerifyInterval)
+	}
+	return c.WithFamily(opts.Family), nil
+}
@@ -0,0 +1,185 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/fakedo"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestNewDigitalOcean(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	s := fakedo.New("example.com")
+	defer s.Close()
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "nodes.example.com", Data: "10.0.0.1"})
+	s.AddRecord("example.com", godo.DomainRecord{Type: "AAAA", Name: "nodes.example.com", Data: "2001:db8::1"})
+	ctx := context.Background()
+	ips := []net.IP{net.IPv4(1, 2, 3, 4)}
+
+	var drifted []string
+	var p Provider
+	p, err := NewDigitalOcean(ctx, s.Client(), ProviderOptions{
+		Zone:  "example.com",
+		TTL:   time.Minute,
+		Audit: true,
+		OnDrift: func(ctx context.Context, zone, record string, add, remove []string) {
+			drifted = append(drifted, record)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UpdateDNS(ctx, "nodes.example.com", ips); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"nodes": {"10.0.0.1", "2001:db8::1"}}
+	if diff := cmp.Diff(s.Addresses("example.com"), want); diff != "" {
+		t.Errorf("after audit:\n%s", diff)
+	}
+	if diff := cmp.Diff(drifted, []string{"nodes.example.com"}); diff != "" {
+		t.Errorf("drift:\n%s", diff)
+	}
+
+	p, err = NewDigitalOcean(ctx, s.Client(), ProviderOptions{Zone: "example.com", TTL: time.Minute, Family: "ipv4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UpdateDNS(ctx, "nodes.example.com", ips); err != nil {
+		t.Fatal(err)
+	}
+	want = map[string][]string{"nodes": {"1.2.3.4", "2001:db8::1"}}
+	if diff := cmp.Diff(s.Addresses("example.com"), want); diff != "" {
+		t.Errorf("after ipv4 update:\n%s", diff)
+	}
+
+	if _, err := NewDigitalOcean(ctx, s.Client(), ProviderOptions{Zone: "missing.example.com"}); err == nil {
+		t.Error("expected error for a zone that doesn't exist")
+	}
+}
+
+func TestDigitalOceanConformance(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	s := fakedo.New("example.com")
+	defer s.Close()
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "nodes", Data: "10.0.0.1"})
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "nodes", Data: "42.0.0.1"})
+	s.AddRecord("example.com", godo.DomainRecord{Type: "CNAME", Name: "www", Data: "example.com."})
+	testProviderConformance(t, conformanceFixture{CNAME: true, New: func(ctx context.Context, opts ProviderOptions) (Provider, error) {
+		return NewDigitalOcean(ctx, s.Client(), opts)
+	}})
+}
+
+// conformanceFixture is a provider's fake backend, for testProviderConformance.  The backend starts
+// out with the A records 10.0.0.1 and 42.0.0.1 in the "nodes" record, and, if CNAME is set, a
+// CNAME named "www".
+type conformanceFixture struct {
+	Zone  string // The zone that the provider serves; example.com if empty.
+	CNAME bool   // Whether the backend has the "www" CNAME, which updates must refuse to replace.
+	// New returns a provider of the backend, with the options.
+	New func(ctx context.Context, opts ProviderOptions) (Provider, error)
+}
+
+// testProviderConformance runs the scenarios that every provider has to get right against a fresh
+// fixture: replacing a record, leaving other records alone, and honoring ProviderOptions.  The
+// records are checked with the provider's Export, so provider-specific details like TTLs and
+// record IDs are left to the provider's own tests.
+func testProviderConformance(t *testing.T, f conformanceFixture) {
+	t.Helper()
+	ctx := context.Background()
+	zone := f.Zone
+	if zone == "" {
+		zone = "example.com"
+	}
+	testData := []struct {
+		name    string
+		opts    ProviderOptions // Zone and TTL are set for every scenario.
+		record  string          // The record to update; empty for the fully-qualified name of "nodes".
+		ips     []string
+		want    []string // The contents of "nodes" afterwards, as "type data", sorted.
+		wantErr bool
+		cname   bool // Whether the scenario needs the fixture's CNAME.
+	}{
+		{
+			name:   "replace",
+			record: "nodes",
+			ips:    []string{"42.0.0.1", "2001:db8::1"},
+			want:   []string{"A 42.0.0.1", "AAAA 2001:db8::1"},
+		},
+		{
+			name:   "unchanged",
+			record: "nodes",
+			ips:    []string{"42.0.0.1", "2001:db8::1"},
+			want:   []string{"A 42.0.0.1", "AAAA 2001:db8::1"},
+		},
+		{
+			name:    "cname conflict",
+			record:  "www",
+			ips:     []string{"42.0.0.1"},
+			want:    []string{"A 42.0.0.1", "AAAA 2001:db8::1"},
+			wantErr: true,
+			cname:   true,
+		},
+		{
+			// The AAAA record and the old address are left alone.
+			name: "ipv4-only and create-only",
+			opts: ProviderOptions{Family: "ipv4", CreateOnly: true},
+			ips:  []string{"42.0.0.2"},
+			want: []string{"A 42.0.0.1", "A 42.0.0.2", "AAAA 2001:db8::1"},
+		},
+		{
+			name:   "remove",
+			record: "nodes",
+		},
+	}
+	for _, test := range testData {
+		if test.cname && !f.CNAME {
+			continue
+		}
+		opts := test.opts
+		opts.Zone, opts.TTL = zone, time.Minute
+		p, err := f.New(ctx, opts)
+		if err != nil {
+			t.Fatalf("%s: new provider: %v", test.name, err)
+		}
+		record := test.record
+		if record == "" {
+			record = p.FQDN("nodes")
+		}
+		var ips []net.IP
+		for _, ip := range test.ips {
+			ips = append(ips, net.ParseIP(ip))
+		}
+		if err := p.UpdateDNS(ctx, record, ips); (err != nil) != test.wantErr {
+			t.Errorf("%s: update %s: %v, want error: %v", test.name, record, err, test.wantErr)
+		}
+
+		// What's there is checked with a provider that sees every family.
+		p, err = f.New(ctx, ProviderOptions{Zone: zone, TTL: time.Minute})
+		if err != nil {
+			t.Fatalf("%s: new provider: %v", test.name, err)
+		}
+		e, ok := p.(Exporter)
+		if !ok {
+			t.Fatalf("%T doesn't implement Exporter", p)
+		}
+		snap, err := e.Export(ctx, []string{"nodes"})
+		if err != nil {
+			t.Fatalf("%s: export: %v", test.name, err)
+		}
+		var got []string
+		for _, r := range snap.Records {
+			got = append(got, r.Type+" "+r.Data)
+		}
+		sort.Strings(got)
+		if diff := cmp.Diff(got, test.want); diff != "" {
+			t.Errorf("%s: records after update:\n%s", test.name, diff)
+		}
+	}
+}
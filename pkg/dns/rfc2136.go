@@ -0,0 +1,368 @@
+//go:build !no_rfc2136
+// +build !no_rfc2136
+
+package dns
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"github.com/jrockway/nodedns/pkg/tracing"
+	mdns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var rfc2136Notifies = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "rfc2136_notifies",
+		Help: "The number of DNS NOTIFY messages sent to secondary servers after updates, by whether the secondary acknowledged them (\"ok\") or not (\"error\").",
+	},
+	[]string{"zone", "server", "result"},
+)
+
+// RFC2136Config configures a server that accepts RFC 2136 dynamic updates, like BIND or Knot.
+type RFC2136Config struct {
+	Server       string        `long:"rfc2136_server" env:"RFC2136_SERVER" description:"with --dns_provider=rfc2136, the host:port of the primary server to send dynamic updates to; the port defaults to 53"`
+	KeyName      string        `long:"rfc2136_tsig_key_name" env:"RFC2136_TSIG_KEY_NAME" description:"the name of the TSIG key to sign updates with; updates are unsigned if empty"`
+	KeySecret    string        `long:"rfc2136_tsig_secret" env:"RFC2136_TSIG_SECRET" description:"the base64-encoded secret of the TSIG key"`
+	KeyAlgorithm string        `long:"rfc2136_tsig_algorithm" env:"RFC2136_TSIG_ALGORITHM" description:"the algorithm of the TSIG key" choice:"hmac-sha1" choice:"hmac-sha224" choice:"hmac-sha256" choice:"hmac-sha384" choice:"hmac-sha512" default:"hmac-sha256"`
+	Timeout      time.Duration `long:"rfc2136_timeout" env:"RFC2136_TIMEOUT" description:"how long to wait for the server to answer each query or update" default:"10s"`
+	Notify       []string      `long:"rfc2136_notify" env:"RFC2136_NOTIFY" env-delim:"," description:"the host:port of a secondary server to send a DNS NOTIFY to after each update, so that it transfers the zone right away instead of at its next refresh; the port defaults to 53.  May be repeated."`
+}
+
+// Validate returns an error if the configuration can't be used.
+func (c *RFC2136Config) Validate() error {
+	if c.Server == "" {
+		return errors.New("no server")
+	}
+	if (c.KeyName == "") != (c.KeySecret == "") {
+		return errors.New("the tsig key name and secret must be set together")
+	}
+	if c.KeySecret != "" {
+		if _, err := base64.StdEncoding.DecodeString(c.KeySecret); err != nil {
+			return fmt.Errorf("tsig secret: %w", err)
+		}
+	}
+	for _, server := range c.Notify {
+		if server == "" {
+			return errors.New("empty notify server")
+		}
+	}
+	return nil
+}
+
+// RcodeError is a DNS server answering with an error.
+type RcodeError struct {
+	Rcode int
+}
+
+func (e *RcodeError) Error() string {
+	return "server answered " + mdns.RcodeToString[e.Rcode]
+}
+
+// RFC2136 is a Provider that publishes records with RFC 2136 dynamic updates, signed with TSIG.
+type RFC2136 struct {
+	cfg    RFC2136Config
+	client *mdns.Client
+	opts   ProviderOptions
+}
+
+var (
+	_ Provider = (*RFC2136)(nil)
+	_ Exporter = (*RFC2136)(nil)
+)
+
+// DNS servers refuse updates, or their TSIG signatures, with an rcode rather than an HTTP status.
+func init() {
+	authChecks = append(authChecks, func(err error) bool {
+		var rcodeErr *RcodeError
+		if errors.As(err, &rcodeErr) {
+			return rcodeErr.Rcode == mdns.RcodeNotAuth || rcodeErr.Rcode == mdns.RcodeRefused
+		}
+		return errors.Is(err, mdns.ErrSig) || errors.Is(err, mdns.ErrAuth)
+	})
+}
+
+// NewRFC2136 returns an RFC2136 Provider for the zone in opts, which the server must be
+// authoritative for.
+func NewRFC2136(ctx context.Context, cfg RFC2136Config, opts ProviderOptions) (*RFC2136, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("rfc2136: %w", err)
+	}
+	if _, _, err := net.SplitHostPort(cfg.Server); err != nil {
+		cfg.Server = net.JoinHostPort(cfg.Server, "53")
+	}
+	notify := make([]string, 0, len(cfg.Notify))
+	for _, server := range cfg.Notify {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		notify = append(notify, server)
+	}
+	cfg.Notify = notify
+	// Updates are sent over TCP, so that large record sets aren't truncated.
+	client := &mdns.Client{Net: "tcp", Timeout: cfg.Timeout}
+	if cfg.KeyName != "" {
+		cfg.KeyName = mdns.Fqdn(strings.ToLower(cfg.KeyName))
+		cfg.KeyAlgorithm = mdns.Fqdn(strings.ToLower(cfg.KeyAlgorithm))
+		client.TsigSecret = map[string]string{cfg.KeyName: cfg.KeySecret}
+	}
+	p := &RFC2136{cfg: cfg, client: client, opts: opts}
+	q := new(mdns.Msg)
+	q.SetQuestion(mdns.Fqdn(opts.Zone), mdns.TypeSOA)
+	r, err := p.exchange(ctx, q)
+	if err != nil {
+		return nil, fmt.Errorf("rfc2136: get soa of zone %q: %w", opts.Zone, err)
+	}
+	if !r.Authoritative {
+		return nil, fmt.Errorf("rfc2136: %s is not authoritative for zone %q", cfg.Server, opts.Zone)
+	}
+	return p, nil
+}
+
+// FQDN implements Provider.
+func (p *RFC2136) FQDN(record string) string {
+	return fqdn(p.opts.Zone, record)
+}
+
+// exchange signs m, if there's a key, sends it to the server, and returns the answer.
+func (p *RFC2136) exchange(ctx context.Context, m *mdns.Msg) (*mdns.Msg, error) {
+	if p.cfg.KeyName != "" {
+		m.SetTsig(p.cfg.KeyName, p.cfg.KeyAlgorithm, 300, time.Now().Unix())
+	}
+	r, _, err := p.client.ExchangeContext(ctx, m, p.cfg.Server)
+	if err != nil {
+		return nil, err
+	}
+	if r.Rcode != mdns.RcodeSuccess {
+		return r, &RcodeError{Rcode: r.Rcode}
+	}
+	return r, nil
+}
+
+// lookup returns the A, AAAA, and CNAME records named name, asking the server directly.
+func (p *RFC2136) lookup(ctx context.Context, name string) ([]mdns.RR, error) {
+	var result []mdns.RR
+	for _, t := range []uint16{mdns.TypeA, mdns.TypeAAAA, mdns.TypeCNAME} {
+		q := new(mdns.Msg)
+		q.SetQuestion(mdns.Fqdn(name), t)
+		q.RecursionDesired = false
+		r, err := p.exchange(ctx, q)
+		var rcodeErr *RcodeError
+		if errors.As(err, &rcodeErr) && rcodeErr.Rcode == mdns.RcodeNameError {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("query %s %s: %w", name, mdns.TypeToString[t], err)
+		}
+		for _, rr := range r.Answer {
+			// An address query for a CNAME answers with the CNAME, which the CNAME query
+			// finds on its own.
+			if rr.Header().Rrtype == t && strings.EqualFold(rr.Header().Name, mdns.Fqdn(name)) {
+				result = append(result, rr)
+			}
+		}
+	}
+	return result, nil
+}
+
+// address returns the address of an A or AAAA record, or nil for other records.
+func address(rr mdns.RR) net.IP {
+	switch rr := rr.(type) {
+	case *mdns.A:
+		return rr.A
+	case *mdns.AAAA:
+		return rr.AAAA
+	}
+	return nil
+}
+
+// UpdateDNS implements Provider.  Every change to the record is sent in a single update, which the
+// server applies atomically.
+func (p *RFC2136) UpdateDNS(ctx context.Context, record string, addresses []net.IP) (err error) {
+	if record == "" {
+		return nil
+	}
+	ctx, span := tracing.Start(ctx, "rfc2136_dns_update")
+	defer span.End()
+	zone, name := p.opts.Zone, p.FQDN(record)
+	dnsUpdateAttempts.WithLabelValues("rfc2136", zone, record).Inc()
+	defer func(start time.Time) {
+		observeUpdate(ctx, "rfc2136", zone, record, start, err)
+	}(time.Now())
+	l := zap.L().Named("rfc2136-dns").With(correlation.Field(ctx))
+
+	records, err := p.lookup(ctx, name)
+	if err != nil {
+		return fmt.Errorf("get existing records: %w", err)
+	}
+	desired := make(map[string]bool)
+	for _, ip := range addresses {
+		if manages(p.opts.Family, recordType(ip)) {
+			desired[ip.String()] = true
+		}
+	}
+	// published tracks what the record contains as changes are made, even if the update fails.
+	published := make(map[string]bool)
+	defer reportPublished("rfc2136", zone, p.opts.Family, record, published)
+	ttl := uint32(p.opts.TTL.Round(time.Second).Seconds())
+	var toDelete, toFix []mdns.RR
+	var toDeleteAddrs, toFixAddrs []string
+	var cname *mdns.CNAME
+	for _, rr := range records {
+		if c, ok := rr.(*mdns.CNAME); ok {
+			cname = c
+			continue
+		}
+		ip := address(rr)
+		if ip == nil || !manages(p.opts.Family, recordType(ip)) {
+			continue
+		}
+		addr := ip.String()
+		switch {
+		case !desired[addr]:
+			toDelete = append(toDelete, rr)
+			toDeleteAddrs = append(toDeleteAddrs, addr)
+		case rr.Header().Ttl != ttl:
+			// Records added by hand or with an earlier --ttl get the configured TTL.
+			toFix = append(toFix, rr)
+			toFixAddrs = append(toFixAddrs, addr)
+		}
+		published[addr] = true
+	}
+	var toCreate []string
+	for addr := range desired {
+		if !published[addr] {
+			toCreate = append(toCreate, addr)
+		}
+	}
+	sort.Strings(toCreate)
+
+	if cname != nil {
+		dnsRecordConflict.WithLabelValues("rfc2136", zone, record).Set(1)
+	} else {
+		dnsRecordConflict.WithLabelValues("rfc2136", zone, record).Set(0)
+	}
+	if p.opts.Audit {
+		dnsRecordDrift.WithLabelValues("rfc2136", zone, record).Set(float64(len(toDelete) + len(toCreate)))
+		if len(toDelete) > 0 || len(toCreate) > 0 {
+			l.Info("dns record drifted; auditing, so not changing it", zap.String("record", name), zap.Strings("would_add", toCreate), zap.Strings("would_remove", toDeleteAddrs))
+			if p.opts.OnDrift != nil {
+				p.opts.OnDrift(ctx, zone, name, toCreate, toDeleteAddrs)
+			}
+		}
+		if len(toFix) > 0 {
+			l.Info("dns record ttl drifted; auditing, so not changing it", zap.String("record", name), zap.Strings("addresses", toFixAddrs), zap.Uint32("ttl", ttl))
+		}
+		return nil
+	}
+	if p.opts.CreateOnly && len(toDelete) > 0 {
+		l.Info("create-only; not deleting records", zap.String("record", name), zap.Strings("would_delete", toDeleteAddrs))
+		dnsRecordsDeleteSkipped.WithLabelValues("rfc2136", zone, record).Add(float64(len(toDelete)))
+		toDelete, toDeleteAddrs = nil, nil
+	}
+	if cname != nil && len(toCreate) > 0 {
+		return &ConflictError{Record: name, Target: strings.TrimSuffix(cname.Target, ".")}
+	}
+	if len(toCreate) == 0 && len(toDelete) == 0 && len(toFix) == 0 {
+		dnsUpdatedOK.WithLabelValues("rfc2136", zone, record).Inc()
+		return nil
+	}
+
+	// There's no way to change a record's TTL in place, so the records with the wrong TTL are
+	// replaced in the same update; it's applied atomically, so they never stop resolving.
+	var toInsert []mdns.RR
+	for _, rr := range toFix {
+		fixed := mdns.Copy(rr)
+		fixed.Header().Ttl = ttl
+		toInsert = append(toInsert, fixed)
+	}
+	for _, addr := range toCreate {
+		ip := net.ParseIP(addr)
+		hdr := mdns.RR_Header{Name: mdns.Fqdn(name), Class: mdns.ClassINET, Ttl: ttl}
+		if recordType(ip) == "A" {
+			hdr.Rrtype = mdns.TypeA
+			toInsert = append(toInsert, &mdns.A{Hdr: hdr, A: ip.To4()})
+		} else {
+			hdr.Rrtype = mdns.TypeAAAA
+			toInsert = append(toInsert, &mdns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+	}
+	m := new(mdns.Msg)
+	m.SetUpdate(mdns.Fqdn(zone))
+	m.Remove(append(toDelete, toFix...))
+	m.Insert(toInsert)
+	if _, err := p.exchange(ctx, m); err != nil {
+		return fmt.Errorf("sending update (adding %v, removing %v): %w", toCreate, toDeleteAddrs, err)
+	}
+	for _, addr := range toCreate {
+		published[addr] = true
+		mutated(ctx, p.opts.OnMutation, Mutation{Action: "create", Provider: "rfc2136", Zone: zone, Record: name, Address: addr})
+	}
+	for _, addr := range toDeleteAddrs {
+		delete(published, addr)
+		mutated(ctx, p.opts.OnMutation, Mutation{Action: "delete", Provider: "rfc2136", Zone: zone, Record: name, Address: addr})
+	}
+	dnsRecordsCreated.WithLabelValues("rfc2136", zone, record).Add(float64(len(toCreate)))
+	dnsRecordsDeleted.WithLabelValues("rfc2136", zone, record).Add(float64(len(toDelete)))
+	dnsRecordsTTLFixed.WithLabelValues("rfc2136", zone, record).Add(float64(len(toFix)))
+	l.Info("dns record changed", zap.String("record", name), zap.Strings("added", toCreate), zap.Strings("removed", toDeleteAddrs), zap.Strings("ttl_fixed", toFixAddrs), zap.Int("addresses", len(desired)))
+	p.notify(ctx, l)
+	dnsUpdatedOK.WithLabelValues("rfc2136", zone, record).Inc()
+	return nil
+}
+
+// notify sends a DNS NOTIFY for the zone to each secondary server, so that it transfers the
+// changed zone right away.  The update has already succeeded, and a secondary that misses the
+// NOTIFY still picks up the change at its next refresh, so failures are only logged and counted.
+// NOTIFYs are sent unsigned, over UDP, like a primary server's.
+func (p *RFC2136) notify(ctx context.Context, l *zap.Logger) {
+	if len(p.cfg.Notify) == 0 {
+		return
+	}
+	client := &mdns.Client{Timeout: p.cfg.Timeout}
+	for _, server := range p.cfg.Notify {
+		m := new(mdns.Msg)
+		m.SetNotify(mdns.Fqdn(p.opts.Zone))
+		r, _, err := client.ExchangeContext(ctx, m, server)
+		if err == nil && r.Rcode != mdns.RcodeSuccess {
+			err = &RcodeError{Rcode: r.Rcode}
+		}
+		if err != nil {
+			rfc2136Notifies.WithLabelValues(p.opts.Zone, server, "error").Inc()
+			l.Warn("problem sending notify to secondary server; it will pick up the change at its next refresh", zap.String("zone", p.opts.Zone), zap.String("server", server), zap.Error(err))
+			continue
+		}
+		rfc2136Notifies.WithLabelValues(p.opts.Zone, server, "ok").Inc()
+		l.Debug("notified secondary server", zap.String("zone", p.opts.Zone), zap.String("server", server))
+	}
+}
+
+// Export implements Exporter.
+func (p *RFC2136) Export(ctx context.Context, names []string) (*Snapshot, error) {
+	snap := &Snapshot{Zone: p.opts.Zone, Taken: time.Now().UTC()}
+	for _, n := range names {
+		records, err := p.lookup(ctx, p.FQDN(n))
+		if err != nil {
+			return nil, fmt.Errorf("export %s: %w", n, err)
+		}
+		for _, rr := range records {
+			ip := address(rr)
+			if ip == nil {
+				continue
+			}
+			snap.Records = append(snap.Records, SnapshotRecord{Name: RelativeName(p.opts.Zone, rr.Header().Name), Type: recordType(ip), TTL: int(rr.Header().Ttl), Data: ip.String()})
+		}
+	}
+	snap.sort()
+	return snap, nil
+}
@@ -0,0 +1,269 @@
+//go:build !no_rfc2136
+// +build !no_rfc2136
+
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	mdns "github.com/miekg/dns"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+)
+
+const testTSIGSecret = "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0"
+
+// fakeRFC2136 is an authoritative server for example.com that applies dynamic updates signed with
+// the key "nodedns.".
+type fakeRFC2136 struct {
+	sync.Mutex
+	records []mdns.RR
+}
+
+func (f *fakeRFC2136) add(rr string) {
+	r, err := mdns.NewRR(rr)
+	if err != nil {
+		panic(err)
+	}
+	f.records = append(f.records, r)
+}
+
+// contents returns each record in zone file format, with tabs replaced by spaces, sorted.
+func (f *fakeRFC2136) contents() []string {
+	f.Lock()
+	defer f.Unlock()
+	var result []string
+	for _, r := range f.records {
+		result = append(result, strings.Join(strings.Fields(r.String()), " "))
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (f *fakeRFC2136) ServeDNS(w mdns.ResponseWriter, req *mdns.Msg) {
+	f.Lock()
+	defer f.Unlock()
+	resp := new(mdns.Msg)
+	resp.SetReply(req)
+	resp.Authoritative = true
+	defer w.WriteMsg(resp) // nolint:errcheck
+	if req.IsTsig() == nil || w.TsigStatus() != nil {
+		resp.Rcode = mdns.RcodeNotAuth
+		return
+	}
+	resp.SetTsig("nodedns.", mdns.HmacSHA256, 300, time.Now().Unix())
+
+	if req.Opcode == mdns.OpcodeUpdate {
+		for _, rr := range req.Ns {
+			if rr.Header().Class == mdns.ClassNONE {
+				for i, r := range f.records {
+					rr.Header().Class, rr.Header().Ttl = mdns.ClassINET, r.Header().Ttl
+					if mdns.IsDuplicate(r, rr) {
+						f.records = append(f.records[:i], f.records[i+1:]...)
+						break
+					}
+				}
+				continue
+			}
+			f.records = append(f.records, rr)
+		}
+		return
+	}
+	q := req.Question[0]
+	if q.Qtype == mdns.TypeSOA && q.Name == "example.com." {
+		resp.Answer = append(resp.Answer, &mdns.SOA{Hdr: mdns.RR_Header{Name: q.Name, Rrtype: mdns.TypeSOA, Class: mdns.ClassINET}, Ns: "ns.example.com.", Mbox: "admin.example.com."})
+		return
+	}
+	resp.Rcode = mdns.RcodeNameError
+	for _, r := range f.records {
+		if r.Header().Name != q.Name {
+			continue
+		}
+		resp.Rcode = mdns.RcodeSuccess
+		if r.Header().Rrtype == q.Qtype {
+			resp.Answer = append(resp.Answer, r)
+		}
+	}
+}
+
+// notifyRecorder is a secondary server that records the zones it's notified about.
+type notifyRecorder struct {
+	sync.Mutex
+	zones []string
+}
+
+func (n *notifyRecorder) ServeDNS(w mdns.ResponseWriter, req *mdns.Msg) {
+	resp := new(mdns.Msg)
+	resp.SetReply(req)
+	if req.Opcode != mdns.OpcodeNotify {
+		resp.Rcode = mdns.RcodeRefused
+	} else {
+		n.Lock()
+		n.zones = append(n.zones, req.Question[0].Name)
+		n.Unlock()
+	}
+	w.WriteMsg(resp) // nolint:errcheck
+}
+
+func (n *notifyRecorder) notified() []string {
+	n.Lock()
+	defer n.Unlock()
+	return append([]string{}, n.zones...)
+}
+
+// newTestRFC2136 starts a fake authoritative server for example.com, with the records that
+// testProviderConformance expects, and a secondary that records notifies, and returns the
+// configuration of a provider that updates the server and notifies the secondary.
+func newTestRFC2136(t *testing.T) (*fakeRFC2136, *notifyRecorder, RFC2136Config) {
+	f := new(fakeRFC2136)
+	f.add("nodes.example.com. 60 IN A 10.0.0.1")
+	f.add("nodes.example.com. 300 IN A 42.0.0.1")
+	f.add("www.example.com. 60 IN CNAME example.com.")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	s := &mdns.Server{
+		Listener:          l,
+		Handler:           f,
+		TsigSecret:        map[string]string{"nodedns.": testTSIGSecret},
+		NotifyStartedFunc: func() { close(started) },
+		// The default rejects updates that change more than one record.
+		MsgAcceptFunc: func(mdns.Header) mdns.MsgAcceptAction { return mdns.MsgAccept },
+	}
+	go s.ActivateAndServe()            // nolint:errcheck
+	t.Cleanup(func() { s.Shutdown() }) // nolint:errcheck
+	<-started
+	secondary := new(notifyRecorder)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifyStarted := make(chan struct{})
+	ns := &mdns.Server{PacketConn: pc, Handler: secondary, NotifyStartedFunc: func() { close(notifyStarted) }}
+	go ns.ActivateAndServe()            // nolint:errcheck
+	t.Cleanup(func() { ns.Shutdown() }) // nolint:errcheck
+	<-notifyStarted
+	return f, secondary, RFC2136Config{Server: l.Addr().String(), KeyName: "NodeDNS", KeySecret: testTSIGSecret, KeyAlgorithm: "hmac-sha256", Timeout: 5 * time.Second, Notify: []string{pc.LocalAddr().String()}}
+}
+
+func TestRFC2136Conformance(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	_, _, cfg := newTestRFC2136(t)
+	testProviderConformance(t, conformanceFixture{CNAME: true, New: func(ctx context.Context, opts ProviderOptions) (Provider, error) {
+		return NewRFC2136(ctx, cfg, opts)
+	}})
+}
+
+func TestRFC2136(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	f, secondary, cfg := newTestRFC2136(t)
+	ctx := context.Background()
+
+	bad := cfg
+	bad.KeySecret = "d3Jvbmd3cm9uZ3dyb25nd3Jvbmc="
+	if _, err := NewRFC2136(ctx, bad, ProviderOptions{Zone: "example.com"}); !IsAuthError(err) {
+		t.Errorf("with the wrong secret:\n  got: %v\n want: an auth error", err)
+	}
+
+	p, err := NewRFC2136(ctx, cfg, ProviderOptions{Zone: "example.com", TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UpdateDNS(ctx, "nodes", []net.IP{net.IPv4(42, 0, 0, 1), net.ParseIP("2001:db8::1")}); err != nil {
+		t.Fatal(err)
+	}
+	// The kept record is replaced with one with the configured TTL.
+	want := []string{
+		"nodes.example.com. 60 IN A 42.0.0.1",
+		"nodes.example.com. 60 IN AAAA 2001:db8::1",
+		"www.example.com. 60 IN CNAME example.com.",
+	}
+	if diff := cmp.Diff(f.contents(), want); diff != "" {
+		t.Errorf("after update:\n%s", diff)
+	}
+	// Only updates that change something notify the secondaries.
+	if err := p.UpdateDNS(ctx, "nodes", []net.IP{net.IPv4(42, 0, 0, 1), net.ParseIP("2001:db8::1")}); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(secondary.notified(), []string{"example.com."}); diff != "" {
+		t.Errorf("notifies:\n%s", diff)
+	}
+
+	// Names that don't exist are skipped.
+	snap, err := p.Export(ctx, []string{"nodes", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRecords := []SnapshotRecord{{Name: "nodes", Type: "A", TTL: 60, Data: "42.0.0.1"}, {Name: "nodes", Type: "AAAA", TTL: 60, Data: "2001:db8::1"}}
+	if diff := cmp.Diff(snap.Records, wantRecords); diff != "" {
+		t.Errorf("export:\n%s", diff)
+	}
+
+	// A create-only provider adds records with its own TTL, and leaves the others' alone.
+	p, err = NewRFC2136(ctx, cfg, ProviderOptions{Zone: "example.com", TTL: 30 * time.Second, CreateOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UpdateDNS(ctx, "nodes", []net.IP{net.IPv4(42, 0, 0, 2)}); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		"nodes.example.com. 30 IN A 42.0.0.2",
+		"nodes.example.com. 60 IN A 42.0.0.1",
+		"nodes.example.com. 60 IN AAAA 2001:db8::1",
+		"www.example.com. 60 IN CNAME example.com.",
+	}
+	if diff := cmp.Diff(f.contents(), want); diff != "" {
+		t.Errorf("after create-only update:\n%s", diff)
+	}
+}
+
+func TestRFC2136ConfigValidate(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		cfg     RFC2136Config
+		wantErr bool
+	}{
+		{name: "unsigned", cfg: RFC2136Config{Server: "ns1"}},
+		{name: "signed", cfg: RFC2136Config{Server: "ns1", KeyName: "nodedns", KeySecret: testTSIGSecret}},
+		{name: "no server", cfg: RFC2136Config{}, wantErr: true},
+		{name: "no secret", cfg: RFC2136Config{Server: "ns1", KeyName: "nodedns"}, wantErr: true},
+		{name: "bad secret", cfg: RFC2136Config{Server: "ns1", KeyName: "nodedns", KeySecret: "!"}, wantErr: true},
+		{name: "notify", cfg: RFC2136Config{Server: "ns1", Notify: []string{"ns2", "ns3:5353"}}},
+		{name: "empty notify", cfg: RFC2136Config{Server: "ns1", Notify: []string{""}}, wantErr: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.cfg.Validate()
+			if got, want := err != nil, test.wantErr; got != want {
+				t.Errorf("error:\n  got: %v\n want: %v", err, want)
+			}
+		})
+	}
+}
+
+func TestRFC2136ErrorClass(t *testing.T) {
+	testData := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "tsig", err: mdns.ErrSig, want: "auth"},
+		{name: "refused", err: fmt.Errorf("update: %w", &RcodeError{Rcode: mdns.RcodeRefused}), want: "auth"},
+		{name: "server failure", err: &RcodeError{Rcode: mdns.RcodeServerFailure}, want: "other"},
+	}
+	for _, test := range testData {
+		if got := errorClass(test.err); got != test.want {
+			t.Errorf("%s:\n  got: %v\n want: %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package dns
+
+import (
+	"net"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var (
+	recordAddresses = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_record_addresses",
+			Help: "The number of addresses that nodedns wants to publish in a record, before any cap is enforced.",
+		},
+		[]string{"record"},
+	)
+	recordResponseBytes = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_record_response_bytes",
+			Help: "The estimated size of a DNS response for a record, before any cap is enforced.",
+		},
+		[]string{"record", "type"},
+	)
+	recordOversized = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_record_oversized",
+			Help: "1 if a record has more addresses, or a larger estimated response, than the configured limits.",
+		},
+		[]string{"record"},
+	)
+)
+
+// SizeLimit limits the size of records.  Large responses are truncated over UDP, making clients
+// retry over TCP (which some can't), and some resolvers don't handle them at all.
+type SizeLimit struct {
+	MaxAddresses    int  `long:"max_record_addresses" env:"MAX_RECORD_ADDRESSES" description:"warn when a record would have more than this many addresses; 0 for no limit"`
+	MaxResponseSize int  `long:"max_response_size" env:"MAX_RESPONSE_SIZE" description:"warn when the estimated size of a dns response for a record, in bytes, would exceed this; 512 is the classic udp limit, 1232 the common edns limit; 0 for no limit" default:"1232"`
+	Enforce         bool `long:"enforce_record_size" env:"ENFORCE_RECORD_SIZE" description:"publish only as many addresses as fit within --max_record_addresses and --max_response_size, rather than just warning"`
+}
+
+// ResponseSize estimates the size of a DNS response (with an EDNS OPT record, without
+// compression of the answers' owner names beyond a pointer to the question) that answers a
+// query for name with n addresses of size addrLen (4 for A records, 16 for AAAA).
+func ResponseSize(name string, n, addrLen int) int {
+	name = strings.TrimSuffix(name, ".")
+	qname := len(name) + 2 // Length-prefixed labels and the root label.
+	if name == "" {
+		qname = 1
+	}
+	const (
+		header = 12
+		opt    = 11
+		answer = 2 + 2 + 2 + 4 + 2 // Name pointer, type, class, ttl, rdlength.
+	)
+	return header + qname + 4 + n*(answer+addrLen) + opt
+}
+
+// Apply checks the addresses to be published in the record with the provided fully-qualified
+// name against the limits, updates metrics, and warns if they're exceeded.  If the limits are
+// enforced, it returns the addresses that fit; otherwise it returns ips unchanged.  A nil
+// SizeLimit does nothing.
+func (l *SizeLimit) Apply(fqdn string, ips []net.IP) []net.IP {
+	if l == nil {
+		return ips
+	}
+	var v4, v6 int
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4++
+		} else {
+			v6++
+		}
+	}
+	sizeA, sizeAAAA := ResponseSize(fqdn, v4, net.IPv4len), ResponseSize(fqdn, v6, net.IPv6len)
+	recordAddresses.WithLabelValues(fqdn).Set(float64(len(ips)))
+	recordResponseBytes.WithLabelValues(fqdn, "A").Set(float64(sizeA))
+	recordResponseBytes.WithLabelValues(fqdn, "AAAA").Set(float64(sizeAAAA))
+
+	tooMany := l.MaxAddresses > 0 && len(ips) > l.MaxAddresses
+	tooBig := l.MaxResponseSize > 0 && (sizeA > l.MaxResponseSize || sizeAAAA > l.MaxResponseSize)
+	if !tooMany && !tooBig {
+		recordOversized.WithLabelValues(fqdn).Set(0)
+		return ips
+	}
+	recordOversized.WithLabelValues(fqdn).Set(1)
+	log := zap.L().Named("record-size").With(zap.String("record", fqdn), zap.Int("addresses", len(ips)), zap.Int("max_addresses", l.MaxAddresses), zap.Int("a_response_bytes", sizeA), zap.Int("aaaa_response_bytes", sizeAAAA), zap.Int("max_response_size", l.MaxResponseSize))
+	if !l.Enforce {
+		log.Warn("record exceeds size limits")
+		return ips
+	}
+
+	result := make([]net.IP, 0, len(ips))
+	v4, v6 = 0, 0
+	for _, ip := range ips {
+		if l.MaxAddresses > 0 && len(result) >= l.MaxAddresses {
+			break
+		}
+		n, addrLen := &v4, net.IPv4len
+		if ip.To4() == nil {
+			n, addrLen = &v6, net.IPv6len
+		}
+		if l.MaxResponseSize > 0 && ResponseSize(fqdn, *n+1, addrLen) > l.MaxResponseSize {
+			continue
+		}
+		*n++
+		result = append(result, ip)
+	}
+	log.Warn("record exceeds size limits; publishing only some addresses", zap.Int("published", len(result)))
+	return result
+}
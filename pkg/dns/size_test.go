@@ -0,0 +1,73 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestResponseSize(t *testing.T) {
+	// 12 byte header, 23 byte question, 16 byte answer, 11 byte OPT record.
+	if got, want := ResponseSize("nodes.example.com.", 1, net.IPv4len), 62; got != want {
+		t.Errorf("one A record:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := ResponseSize("nodes.example.com", 0, net.IPv6len), 46; got != want {
+		t.Errorf("no AAAA records:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+func TestSizeLimit(t *testing.T) {
+	ips := []net.IP{
+		net.ParseIP("2001:db8::1"),
+		net.ParseIP("2001:db8::2"),
+		net.IPv4(10, 0, 0, 1),
+		net.IPv4(10, 0, 0, 2),
+		net.IPv4(10, 0, 0, 3),
+	}
+	testData := []struct {
+		name  string
+		limit *SizeLimit
+		want  []net.IP
+	}{
+		{
+			name: "nil",
+			want: ips,
+		},
+		{
+			name:  "within limits",
+			limit: &SizeLimit{MaxAddresses: 5, MaxResponseSize: 512, Enforce: true},
+			want:  ips,
+		},
+		{
+			name:  "warn only",
+			limit: &SizeLimit{MaxAddresses: 1},
+			want:  ips,
+		},
+		{
+			name:  "too many addresses",
+			limit: &SizeLimit{MaxAddresses: 3, Enforce: true},
+			want:  ips[:3],
+		},
+		{
+			name: "response at the limit",
+			// Room for 2 AAAA records, or 4 A records.
+			limit: &SizeLimit{MaxResponseSize: ResponseSize("nodes.example.com", 2, net.IPv6len), Enforce: true},
+			want:  ips,
+		},
+		{
+			name: "only one AAAA fits",
+			// Room for 1 AAAA record, or 3 A records.
+			limit: &SizeLimit{MaxResponseSize: ResponseSize("nodes.example.com", 3, net.IPv4len), Enforce: true},
+			want:  []net.IP{ips[0], ips[2], ips[3], ips[4]},
+		},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.limit.Apply("nodes.example.com", ips)
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("addresses:\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package dns
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+// SnapshotRecord is a single A or AAAA record in a Snapshot.
+type SnapshotRecord struct {
+	Name string `json:"name"` // The name relative to the zone, like "nodes".
+	Type string `json:"type"` // A or AAAA.
+	TTL  int    `json:"ttl"`  // In seconds.
+	Data string `json:"data"` // The address.
+}
+
+// Snapshot is a copy of the address records in a zone, for migrations and disaster recovery.
+type Snapshot struct {
+	Zone    string           `json:"zone"`
+	Taken   time.Time        `json:"taken"`
+	Records []SnapshotRecord `json:"records"`
+}
+
+// Export returns a snapshot of the A and AAAA records with the provided names, or every A and
+// AAAA record in the zone if names is empty.
+func (c *Client) Export(ctx context.Context, names []string) (*Snapshot, error) {
+	want := make(map[string]struct{})
+	for _, n := range names {
+		// The API returns names relative to the zone.
+		want[RelativeName(c.zone, n)] = struct{}{}
+	}
+	var recs []godo.DomainRecord
+	if len(want) == 0 {
+		var err error
+		recs, err = c.listAddressRecords(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list records: %w", err)
+		}
+	}
+	// Each name is listed separately, so that a few records can be exported from a large zone
+	// cheaply.
+	for n := range want {
+		named, _, _, err := c.listRecords(ctx, c.FQDN(n))
+		if err != nil {
+			return nil, fmt.Errorf("list records named %s: %w", n, err)
+		}
+		recs = append(recs, named...)
+	}
+	s := &Snapshot{Zone: c.zone, Taken: time.Now().UTC()}
+	for _, rec := range recs {
+		if _, ok := want[rec.Name]; len(want) > 0 && !ok {
+			continue
+		}
+		s.Records = append(s.Records, SnapshotRecord{Name: rec.Name, Type: rec.Type, TTL: rec.TTL, Data: rec.Data})
+	}
+	s.sort()
+	return s, nil
+}
+
+func (s *Snapshot) sort() {
+	sort.Slice(s.Records, func(i, j int) bool {
+		a, b := s.Records[i], s.Records[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Data < b.Data
+	})
+}
+
+// Import makes every record set named in the snapshot contain exactly the snapshot's addresses.
+// Records with names that aren't in the snapshot are left alone.  The client's zone is used,
+// regardless of the zone the snapshot was taken from.
+func (c *Client) Import(ctx context.Context, s *Snapshot) error {
+	byName := make(map[string][]net.IP)
+	ttls := make(map[string]int)
+	var names []string
+	for _, rec := range s.Records {
+		ip := net.ParseIP(rec.Data)
+		if ip == nil {
+			return fmt.Errorf("record %s: invalid address %q", rec.Name, rec.Data)
+		}
+		if _, ok := byName[rec.Name]; !ok {
+			names = append(names, rec.Name)
+		}
+		byName[rec.Name] = append(byName[rec.Name], ip)
+		if rec.TTL > 0 {
+			ttls[rec.Name] = rec.TTL
+		}
+	}
+	for _, name := range names {
+		rc := *c
+		if ttl, ok := ttls[name]; ok {
+			rc.ttl = time.Duration(ttl) * time.Second
+		}
+		if err := rc.UpdateDNS(ctx, name, byName[name]); err != nil {
+			return fmt.Errorf("import record %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// WriteZone writes the snapshot in BIND zone file format.
+func (s *Snapshot) WriteZone(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "; nodedns snapshot taken %s\n$ORIGIN %s.\n", s.Taken.Format(time.RFC3339), strings.TrimSuffix(s.Zone, ".")); err != nil {
+		return err
+	}
+	for _, r := range s.Records {
+		if _, err := fmt.Fprintf(w, "%s\t%d\tIN\t%s\t%s\n", r.Name, r.TTL, r.Type, r.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadZone reads a snapshot in the zone file format written by WriteZone.  Only simple A and AAAA
+// records with explicit TTLs are understood.
+func ReadZone(r io.Reader) (*Snapshot, error) {
+	s := new(Snapshot)
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := sc.Text()
+		if i := strings.Index(text, ";"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "$ORIGIN" {
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: invalid $ORIGIN", line)
+			}
+			s.Zone = strings.TrimSuffix(fields[1], ".")
+			continue
+		}
+		if len(fields) != 5 || fields[2] != "IN" || (fields[3] != "A" && fields[3] != "AAAA") {
+			return nil, fmt.Errorf("line %d: expected \"<name> <ttl> IN A|AAAA <address>\"", line)
+		}
+		ttl, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid ttl: %w", line, err)
+		}
+		s.Records = append(s.Records, SnapshotRecord{Name: fields[0], TTL: ttl, Type: fields[3], Data: fields[4]})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("read zone: %w", err)
+	}
+	s.sort()
+	return s, nil
+}
@@ -0,0 +1,87 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/jrockway/nodedns/pkg/fakedo"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestSnapshot(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	ctx := context.Background()
+	src := fakedo.New("example.com")
+	defer src.Close()
+	src.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "nodes", Data: "42.0.0.1", TTL: 60})
+	src.AddRecord("example.com", godo.DomainRecord{Type: "AAAA", Name: "nodes", Data: "2001:db8::1", TTL: 60})
+	src.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "internal", Data: "10.0.0.1", TTL: 30})
+	src.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "www", Data: "42.0.0.100", TTL: 3600})
+	src.AddRecord("example.com", godo.DomainRecord{Type: "TXT", Name: "nodes", Data: "hello", TTL: 60})
+	srcClient, err := NewClientFromGodo(ctx, src.Client(), "example.com", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err := srcClient.Export(ctx, []string{"nodes", "internal"})
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	want := []SnapshotRecord{
+		{Name: "internal", Type: "A", TTL: 30, Data: "10.0.0.1"},
+		{Name: "nodes", Type: "A", TTL: 60, Data: "42.0.0.1"},
+		{Name: "nodes", Type: "AAAA", TTL: 60, Data: "2001:db8::1"},
+	}
+	if diff := cmp.Diff(snap.Records, want); diff != "" {
+		t.Errorf("export:\n%s", diff)
+	}
+
+	// Round-trip through the zone file format.
+	buf := new(bytes.Buffer)
+	if err := snap.WriteZone(buf); err != nil {
+		t.Fatalf("write zone: %v", err)
+	}
+	read, err := ReadZone(buf)
+	if err != nil {
+		t.Fatalf("read zone: %v", err)
+	}
+	if diff := cmp.Diff(read, snap, cmpopts.IgnoreFields(Snapshot{}, "Taken")); diff != "" {
+		t.Errorf("zone file round trip:\n%s", diff)
+	}
+
+	dst := fakedo.New("example.org")
+	defer dst.Close()
+	dst.AddRecord("example.org", godo.DomainRecord{Type: "A", Name: "nodes", Data: "192.0.2.1", TTL: 60})
+	dstClient, err := NewClientFromGodo(ctx, dst.Client(), "example.org", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dstClient.Import(ctx, read); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	imported, err := dstClient.Export(ctx, nil)
+	if err != nil {
+		t.Fatalf("export after import: %v", err)
+	}
+	if diff := cmp.Diff(imported.Records, want); diff != "" {
+		t.Errorf("import:\n%s", diff)
+	}
+}
+
+func TestReadZoneErrors(t *testing.T) {
+	for _, input := range []string{
+		"nodes 60 IN CNAME example.com.",
+		"nodes sixty IN A 10.0.0.1",
+		"nodes IN A 10.0.0.1",
+		"$ORIGIN",
+	} {
+		if _, err := ReadZone(bytes.NewBufferString(input)); err == nil {
+			t.Errorf("%q: expected error", input)
+		}
+	}
+}
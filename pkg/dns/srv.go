@@ -0,0 +1,157 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"github.com/jrockway/nodedns/pkg/tracing"
+	"go.uber.org/zap"
+)
+
+// SRV is the data of an SRV record.
+type SRV struct {
+	Priority, Weight, Port int
+	Target                 string // The fully-qualified name of the target.
+}
+
+// String returns the record's data in zone file format, like "0 0 30443 nodes.example.com.".
+func (s SRV) String() string {
+	return fmt.Sprintf("%d %d %d %s.", s.Priority, s.Weight, s.Port, strings.ToLower(strings.TrimSuffix(s.Target, ".")))
+}
+
+// SRVUpdater is a Provider that can also publish SRV records, like _https._tcp.nodes.example.com.
+type SRVUpdater interface {
+	// UpdateSRV replaces the SRV records with the provided name, relative to the zone or
+	// fully-qualified, with the provided ones.  No records removes them all.
+	UpdateSRV(ctx context.Context, record string, srvs []SRV) error
+}
+
+var _ SRVUpdater = (*Client)(nil)
+
+// srvRecords returns the SRV records with the provided name.
+func (c *Client) srvRecords(ctx context.Context, record string) ([]godo.DomainRecord, error) {
+	var result []godo.DomainRecord
+	opt := &godo.ListOptions{PerPage: 100}
+	for {
+		// The API filters by fully-qualified name.
+		recs, res, err := c.c.Domains.RecordsByTypeAndName(ctx, c.zone, "SRV", c.FQDN(record), opt)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, recs...)
+		if res.Links == nil || res.Links.IsLastPage() {
+			return result, nil
+		}
+		page, err := res.Links.CurrentPage()
+		if err != nil {
+			return nil, fmt.Errorf("current page: %w", err)
+		}
+		opt.Page = page + 1
+	}
+}
+
+// srvData returns an existing SRV record's data in zone file format.
+func (c *Client) srvData(rec godo.DomainRecord) string {
+	target := rec.Data
+	if target == "@" {
+		target = c.zone
+	}
+	return SRV{Priority: rec.Priority, Weight: rec.Weight, Port: rec.Port, Target: target}.String()
+}
+
+// UpdateSRV implements SRVUpdater.  Missing records are created before extra ones are deleted.
+func (c *Client) UpdateSRV(ctx context.Context, record string, srvs []SRV) (err error) {
+	// The API names records relative to the zone.
+	name := RelativeName(c.zone, record)
+	ctx, span := tracing.Start(ctx, "digitalocean_srv_update")
+	defer span.End()
+	dnsUpdateAttempts.WithLabelValues("digitalocean", c.zone, record).Inc()
+	defer func(start time.Time) {
+		observeUpdate(ctx, "digitalocean", c.zone, record, start, err)
+	}(time.Now())
+	l := zap.L().Named("digitalocean-dns").With(correlation.Field(ctx))
+
+	existing, err := c.srvRecords(ctx, record)
+	if err != nil {
+		return fmt.Errorf("get existing srv records: %w", err)
+	}
+	desired := make(map[string]SRV)
+	for _, srv := range srvs {
+		desired[srv.String()] = srv
+	}
+	kept := make(map[string]bool)
+	var toDelete []godo.DomainRecord
+	for _, rec := range existing {
+		data := c.srvData(rec)
+		if _, ok := desired[data]; ok && !kept[data] {
+			kept[data] = true
+			continue
+		}
+		toDelete = append(toDelete, rec)
+	}
+	var toCreate []string
+	for data := range desired {
+		if !kept[data] {
+			toCreate = append(toCreate, data)
+		}
+	}
+	sort.Strings(toCreate)
+	var toDeleteData []string
+	for _, rec := range toDelete {
+		toDeleteData = append(toDeleteData, c.srvData(rec))
+	}
+
+	if c.audit {
+		if len(toCreate) > 0 || len(toDelete) > 0 {
+			l.Info("srv record drifted; auditing, so not changing it", zap.String("record", c.FQDN(record)), zap.Strings("would_add", toCreate), zap.Strings("would_remove", toDeleteData))
+		}
+		return nil
+	}
+	if c.createOnly && len(toDelete) > 0 {
+		l.Info("create-only; not deleting records", zap.String("record", c.FQDN(record)), zap.Strings("would_delete", toDeleteData))
+		dnsRecordsDeleteSkipped.WithLabelValues("digitalocean", c.zone, record).Add(float64(len(toDelete)))
+		toDelete, toDeleteData = nil, nil
+	}
+
+	var added, removed []string
+	defer func() {
+		if len(added)+len(removed) == 0 {
+			return
+		}
+		l.Info("srv record changed", zap.String("record", c.FQDN(record)), zap.Strings("added", added), zap.Strings("removed", removed))
+	}()
+	for _, data := range toCreate {
+		srv := desired[data]
+		rec, _, err := c.c.Domains.CreateRecord(ctx, c.zone, &godo.DomainRecordEditRequest{
+			Type:     "SRV",
+			Name:     name,
+			Data:     strings.TrimSuffix(srv.Target, ".") + ".",
+			Priority: srv.Priority,
+			Weight:   srv.Weight,
+			Port:     srv.Port,
+			TTL:      c.ttlSeconds(),
+		})
+		if err != nil {
+			return fmt.Errorf("creating srv record %s: %w", data, err)
+		}
+		added = append(added, data)
+		dnsRecordsCreated.WithLabelValues("digitalocean", c.zone, record).Inc()
+		mutated(ctx, c.onMutation, Mutation{Action: "create", Provider: "digitalocean", Zone: c.zone, Record: c.FQDN(record), Type: "SRV", Address: data, ID: strconv.Itoa(rec.ID)})
+	}
+	for i, rec := range toDelete {
+		if _, err := c.c.Domains.DeleteRecord(ctx, c.zone, rec.ID); err != nil {
+			return fmt.Errorf("deleting srv record id %d: %w", rec.ID, err)
+		}
+		removed = append(removed, toDeleteData[i])
+		dnsRecordsDeleted.WithLabelValues("digitalocean", c.zone, record).Inc()
+		mutated(ctx, c.onMutation, Mutation{Action: "delete", Provider: "digitalocean", Zone: c.zone, Record: c.FQDN(record), Type: "SRV", Address: toDeleteData[i], ID: strconv.Itoa(rec.ID)})
+	}
+	dnsUpdatedOK.WithLabelValues("digitalocean", c.zone, record).Inc()
+	return nil
+}
@@ -0,0 +1,76 @@
+package dns
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/fakedo"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestUpdateSRV(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	s := fakedo.New("example.com")
+	defer s.Close()
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "nodes", Data: "10.0.0.1"})
+	s.AddRecord("example.com", godo.DomainRecord{Type: "SRV", Name: "_https._tcp.nodes", Data: "nodes.example.com.", Port: 30443})
+	s.AddRecord("example.com", godo.DomainRecord{Type: "SRV", Name: "_ssh._tcp.nodes", Data: "nodes.example.com.", Port: 30022})
+	ctx := context.Background()
+	c, err := NewClientFromGodo(ctx, s.Client(), "example.com", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mutations []string
+	c = c.OnMutation(func(ctx context.Context, m Mutation) {
+		mutations = append(mutations, m.Action+" "+m.Type+" "+m.Address)
+	})
+	srvs := func() map[string][]string {
+		result := make(map[string][]string)
+		for _, r := range s.Records("example.com") {
+			if r.Type == "SRV" {
+				result[r.Name] = append(result[r.Name], c.srvData(r))
+			}
+		}
+		return result
+	}
+
+	// The NodePort changes.
+	if err := c.UpdateSRV(ctx, "_https._tcp.nodes", []SRV{{Port: 32443, Target: "nodes.example.com"}}); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing changes.
+	if err := c.UpdateSRV(ctx, "_https._tcp.nodes.example.com", []SRV{{Port: 32443, Target: "Nodes.example.com."}}); err != nil {
+		t.Fatal(err)
+	}
+	// The port goes away.
+	if err := c.UpdateSRV(ctx, "_ssh._tcp.nodes", nil); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"_https._tcp.nodes": {"0 0 32443 nodes.example.com."}}
+	if diff := cmp.Diff(srvs(), want); diff != "" {
+		t.Errorf("srv records:\n%s", diff)
+	}
+	wantMutations := []string{
+		"create SRV 0 0 32443 nodes.example.com.",
+		"delete SRV 0 0 30443 nodes.example.com.",
+		"delete SRV 0 0 30022 nodes.example.com.",
+	}
+	if diff := cmp.Diff(mutations, wantMutations); diff != "" {
+		t.Errorf("mutations:\n%s", diff)
+	}
+	if got, want := s.Addresses("example.com"), map[string][]string{"nodes": {"10.0.0.1"}}; !cmp.Equal(got, want) {
+		t.Errorf("a records:\n  got: %v\n want: %v", got, want)
+	}
+
+	// Audit mode leaves the records alone.
+	if err := c.Audit().UpdateSRV(ctx, "_https._tcp.nodes", nil); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(srvs(), want); diff != "" {
+		t.Errorf("srv records after audit:\n%s", diff)
+	}
+}
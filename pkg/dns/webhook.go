@@ -0,0 +1,155 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"github.com/jrockway/nodedns/pkg/tracing"
+	"go.uber.org/zap"
+)
+
+// WebhookSignatureHeader is the header that carries the HMAC-SHA256 of a webhook's body, like
+// "sha256=<hex>", when a secret is configured.
+const WebhookSignatureHeader = "X-Nodedns-Signature"
+
+// WebhookConfig configures publishing records by posting them to an HTTP endpoint.
+type WebhookConfig struct {
+	URL     string        `long:"webhook_url" env:"WEBHOOK_URL" description:"with --dns_provider=webhook, the url to post each record's addresses to"`
+	Secret  string        `long:"webhook_secret" env:"WEBHOOK_SECRET" description:"if set, sign each request's body with hmac-sha256 and this secret, in the X-Nodedns-Signature header"`
+	Timeout time.Duration `long:"webhook_timeout" env:"WEBHOOK_TIMEOUT" description:"how long each request may take" default:"10s"`
+}
+
+// WebhookPayload is the body of webhook requests: the complete set of addresses of one type that a
+// record should contain.
+type WebhookPayload struct {
+	Zone       string   `json:"zone"`
+	Record     string   `json:"record"` // Fully-qualified.
+	Type       string   `json:"type"`   // A or AAAA.
+	IPs        []string `json:"ips"`    // Empty if the record should have no addresses of this type.
+	CreateOnly bool     `json:"create_only,omitempty"`
+}
+
+// WebhookError is a webhook endpoint responding with an unsuccessful status.
+type WebhookError struct {
+	Status int
+}
+
+func (e *WebhookError) Error() string {
+	return fmt.Sprintf("unexpected status %d %s", e.Status, http.StatusText(e.Status))
+}
+
+// Webhook is a Provider that posts records to an HTTP endpoint, for DNS backends that nodedns
+// doesn't support natively.  It can't read the records back, so every update sends the complete
+// set of addresses, and drift can't be audited.
+type Webhook struct {
+	cfg  WebhookConfig
+	opts ProviderOptions
+	http *http.Client
+}
+
+var _ Provider = (*Webhook)(nil)
+
+// NewWebhook returns a Webhook Provider for the zone in opts.
+func NewWebhook(cfg WebhookConfig, opts ProviderOptions) (*Webhook, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("webhook: no url")
+	}
+	return &Webhook{cfg: cfg, opts: opts, http: &http.Client{Transport: tracing.WrapRoundTripper(nil)}}, nil
+}
+
+// FQDN implements Provider.
+func (p *Webhook) FQDN(record string) string {
+	return fqdn(p.opts.Zone, record)
+}
+
+// Sign returns the value of the signature header for a body.
+func (cfg *WebhookConfig) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(cfg.Secret))
+	mac.Write(body) // nolint:errcheck
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// send posts one payload.
+func (p *Webhook) send(ctx context.Context, payload *WebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal payload: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.cfg.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, p.cfg.Sign(body))
+	}
+	correlation.SetHeader(req)
+	res, err := p.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("post: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("post: %w", &WebhookError{Status: res.StatusCode})
+	}
+	return nil
+}
+
+// UpdateDNS implements Provider.  It posts one payload for each type of address that it manages,
+// even if the addresses haven't changed, so that the endpoint can fix records that have drifted.
+func (p *Webhook) UpdateDNS(ctx context.Context, record string, addresses []net.IP) (err error) {
+	if record == "" {
+		return nil
+	}
+	ctx, span := tracing.Start(ctx, "webhook_dns_update")
+	defer span.End()
+	zone, name := p.opts.Zone, p.FQDN(record)
+	l := zap.L().Named("webhook-dns").With(correlation.Field(ctx))
+	if p.opts.Audit {
+		l.Debug("auditing; not sending webhook", zap.String("record", name))
+		return nil
+	}
+	dnsUpdateAttempts.WithLabelValues("webhook", zone, record).Inc()
+	defer func(start time.Time) {
+		observeUpdate(ctx, "webhook", zone, record, start, err)
+	}(time.Now())
+
+	published := make(map[string]bool)
+	defer reportPublished("webhook", zone, p.opts.Family, record, published)
+	for _, t := range []string{"A", "AAAA"} {
+		if !manages(p.opts.Family, t) {
+			continue
+		}
+		payload := &WebhookPayload{Zone: zone, Record: name, Type: t, IPs: []string{}, CreateOnly: p.opts.CreateOnly}
+		seen := make(map[string]bool)
+		for _, ip := range addresses {
+			if addr := ip.String(); recordType(ip) == t && !seen[addr] {
+				seen[addr] = true
+				payload.IPs = append(payload.IPs, addr)
+			}
+		}
+		sort.Strings(payload.IPs)
+		if err := p.send(ctx, payload); err != nil {
+			return fmt.Errorf("%s %s: %w", name, t, err)
+		}
+		for _, addr := range payload.IPs {
+			published[addr] = true
+		}
+		l.Debug("sent webhook", zap.String("record", name), zap.String("type", t), zap.Strings("ips", payload.IPs))
+	}
+	dnsUpdatedOK.WithLabelValues("webhook", zone, record).Inc()
+	return nil
+}
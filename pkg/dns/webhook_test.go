@@ -0,0 +1,78 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestWebhook(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	server := WebhookConfig{Secret: "secret"}
+	cfg := WebhookConfig{Secret: "secret", Timeout: 5 * time.Second}
+	var got []WebhookPayload
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Errorf("read body: %v", err)
+		}
+		if sig, want := req.Header.Get(WebhookSignatureHeader), server.Sign(body); sig != want {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		var p WebhookPayload
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Errorf("unmarshal payload: %v", err)
+		}
+		got = append(got, p)
+	}))
+	defer s.Close()
+	cfg.URL = s.URL
+	ctx := context.Background()
+
+	p, err := NewWebhook(cfg, ProviderOptions{Zone: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UpdateDNS(ctx, "nodes", []net.IP{net.IPv4(42, 0, 0, 2), net.IPv4(42, 0, 0, 1), net.IPv4(42, 0, 0, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	want := []WebhookPayload{
+		{Zone: "example.com", Record: "nodes.example.com", Type: "A", IPs: []string{"42.0.0.1", "42.0.0.2"}},
+		{Zone: "example.com", Record: "nodes.example.com", Type: "AAAA", IPs: []string{}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("payloads:\n%s", diff)
+	}
+
+	got = nil
+	p, err = NewWebhook(cfg, ProviderOptions{Zone: "example.com", Family: "ipv6", CreateOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UpdateDNS(ctx, "nodes", []net.IP{net.IPv4(42, 0, 0, 1), net.ParseIP("2001:db8::1")}); err != nil {
+		t.Fatal(err)
+	}
+	want = []WebhookPayload{{Zone: "example.com", Record: "nodes.example.com", Type: "AAAA", IPs: []string{"2001:db8::1"}, CreateOnly: true}}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("ipv6, create-only payloads:\n%s", diff)
+	}
+
+	cfg.Secret = "wrong"
+	p, err = NewWebhook(cfg, ProviderOptions{Zone: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.UpdateDNS(ctx, "nodes", nil); !IsAuthError(err) {
+		t.Errorf("with the wrong secret:\n  got: %v\n want: an auth error", err)
+	}
+}
@@ -0,0 +1,65 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/digitalocean/godo"
+)
+
+// ListZones returns the name of every domain in the DigitalOcean account.
+func ListZones(ctx context.Context, godoClient *godo.Client) ([]string, error) {
+	var result []string
+	opts := &godo.ListOptions{PerPage: 100}
+	for {
+		domains, res, err := godoClient.Domains.List(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("list domains: %w", err)
+		}
+		for _, d := range domains {
+			result = append(result, d.Name)
+		}
+		if res.Links == nil || res.Links.IsLastPage() {
+			return result, nil
+		}
+		page, err := res.Links.CurrentPage()
+		if err != nil {
+			return nil, fmt.Errorf("list domains: current page: %w", err)
+		}
+		opts.Page = page + 1
+	}
+}
+
+// LongestZone returns the most specific of zones that contains the fully-qualified record, like
+// k8s.example.com rather than example.com for nodes.k8s.example.com, or false if none do.
+func LongestZone(zones []string, record string) (string, bool) {
+	record = strings.ToLower(strings.TrimSuffix(record, "."))
+	var result string
+	var longest int
+	for _, zone := range zones {
+		z := strings.ToLower(strings.TrimSuffix(zone, "."))
+		if record != z && !strings.HasSuffix(record, "."+z) {
+			continue
+		}
+		if len(z) > longest {
+			result, longest = zone, len(z)
+		}
+	}
+	return result, result != ""
+}
+
+// RelativeName returns the name of the fully-qualified record relative to the zone that contains
+// it, like "nodes" for nodes.k8s.example.com in k8s.example.com, or "@" for the zone apex.
+// DigitalOcean lists records by their relative names.
+func RelativeName(zone, record string) string {
+	z := strings.ToLower(strings.TrimSuffix(zone, "."))
+	r := strings.TrimSuffix(record, ".")
+	switch lower := strings.ToLower(r); {
+	case lower == z:
+		return "@"
+	case strings.HasSuffix(lower, "."+z):
+		return r[:len(r)-len(z)-1]
+	}
+	return record
+}
@@ -0,0 +1,60 @@
+package dns
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/fakedo"
+)
+
+func TestLongestZone(t *testing.T) {
+	zones := []string{"example.com", "k8s.example.com", "example.net", "ample.com"}
+	testData := []struct {
+		record string
+		want   string
+		wantOK bool
+	}{
+		{record: "nodes.k8s.example.com", want: "k8s.example.com", wantOK: true},
+		{record: "Nodes.K8s.Example.com.", want: "k8s.example.com", wantOK: true},
+		{record: "nodes.example.com", want: "example.com", wantOK: true},
+		{record: "k8s.example.com", want: "k8s.example.com", wantOK: true},
+		{record: "nodes.example.org"},
+		{record: "nodes"},
+	}
+	for _, test := range testData {
+		got, ok := LongestZone(zones, test.record)
+		if got != test.want || ok != test.wantOK {
+			t.Errorf("%s:\n  got: %v, %v\n want: %v, %v", test.record, got, ok, test.want, test.wantOK)
+		}
+	}
+}
+
+func TestListZones(t *testing.T) {
+	s := fakedo.New("example.com", "k8s.example.com")
+	defer s.Close()
+	got, err := ListZones(context.Background(), s.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"example.com", "k8s.example.com"}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("zones:\n%s", diff)
+	}
+}
+
+func TestRelativeName(t *testing.T) {
+	testData := []struct {
+		zone, record, want string
+	}{
+		{zone: "k8s.example.com", record: "nodes.k8s.example.com", want: "nodes"},
+		{zone: "k8s.example.com", record: "Internal.Nodes.K8s.Example.com.", want: "Internal.Nodes"},
+		{zone: "k8s.example.com", record: "k8s.example.com", want: "@"},
+		{zone: "k8s.example.com", record: "nodes.example.com", want: "nodes.example.com"},
+	}
+	for _, test := range testData {
+		if got := RelativeName(test.zone, test.record); got != test.want {
+			t.Errorf("%s in %s:\n  got: %v\n want: %v", test.record, test.zone, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+// Package etcd is a minimal client for the parts of the etcd v3 API that nodedns uses, spoken
+// through etcd's JSON gateway, so that nodedns doesn't need the gRPC client.
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"github.com/jrockway/nodedns/pkg/tracing"
+)
+
+// Config configures a connection to etcd.
+type Config struct {
+	Endpoint string `long:"etcd_endpoint" env:"ETCD_ENDPOINT" description:"with --dns_provider=etcd, the url of an etcd server, like https://etcd.kube-system:2379"`
+	CA       string `long:"etcd_ca" env:"ETCD_CA" description:"a file containing the ca certificate that the etcd server's certificate is signed by; the system roots are used if empty"`
+	Cert     string `long:"etcd_cert" env:"ETCD_CERT" description:"a file containing a client certificate to present to etcd"`
+	Key      string `long:"etcd_key" env:"ETCD_KEY" description:"a file containing the private key of --etcd_cert"`
+	Username string `long:"etcd_username" env:"ETCD_USERNAME" description:"the etcd user to authenticate as, if etcd has authentication enabled"`
+	Password string `long:"etcd_password" env:"ETCD_PASSWORD" description:"the password of --etcd_username"`
+}
+
+// Client is an etcd client.
+type Client struct {
+	cfg  Config
+	http *http.Client
+
+	mu    sync.Mutex
+	token string // The auth token, if authenticated with a username and password.
+}
+
+// NewClient returns a Client for the configured server.
+func NewClient(cfg Config) (*Client, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("no endpoint")
+	}
+	if (cfg.Cert == "") != (cfg.Key == "") {
+		return nil, errors.New("the client certificate and key must be set together")
+	}
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	tlsConfig := new(tls.Config)
+	if cfg.CA != "" {
+		pem, err := ioutil.ReadFile(cfg.CA)
+		if err != nil {
+			return nil, fmt.Errorf("read ca: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", cfg.CA)
+		}
+	}
+	if cfg.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &Client{cfg: cfg, http: &http.Client{Transport: tracing.WrapRoundTripper(transport)}}, nil
+}
+
+// APIError is an error returned by etcd.
+type APIError struct {
+	Status  int    // The HTTP status.
+	Code    int    `json:"code"` // The gRPC status code.
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string { return fmt.Sprintf("%d: %s", e.Code, e.Message) }
+
+// post sends a request to an API method, like "/v3/kv/range", and decodes the response into out.
+func (c *Client) post(ctx context.Context, method string, in, out interface{}) error {
+	err := c.postOnce(ctx, method, in, out)
+	var apiErr *APIError
+	if c.cfg.Username != "" && errors.As(err, &apiErr) && apiErr.Status == http.StatusUnauthorized {
+		// The token expired; get a new one and try again.
+		c.mu.Lock()
+		c.token = ""
+		c.mu.Unlock()
+		err = c.postOnce(ctx, method, in, out)
+	}
+	return err
+}
+
+func (c *Client) postOnce(ctx context.Context, method string, in, out interface{}) error {
+	token, err := c.authenticate(ctx)
+	if err != nil {
+		return fmt.Errorf("authenticate: %w", err)
+	}
+	return c.do(ctx, method, token, in, out)
+}
+
+// authenticate returns a token for the configured user, or "" if there's no user.
+func (c *Client) authenticate(ctx context.Context) (string, error) {
+	if c.cfg.Username == "" {
+		return "", nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" {
+		return c.token, nil
+	}
+	var res struct {
+		Token string `json:"token"`
+	}
+	if err := c.do(ctx, "/v3/auth/authenticate", "", map[string]string{"name": c.cfg.Username, "password": c.cfg.Password}, &res); err != nil {
+		return "", err
+	}
+	c.token = res.Token
+	return c.token, nil
+}
+
+func (c *Client) do(ctx context.Context, method, token string, in, out interface{}) error {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(in); err != nil {
+		return fmt.Errorf("marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.cfg.Endpoint+method, &body)
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	correlation.SetHeader(req)
+	res, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		apiErr := &APIError{Status: res.StatusCode, Message: res.Status}
+		json.NewDecoder(res.Body).Decode(apiErr) // nolint:errcheck
+		return fmt.Errorf("%s: %w", method, apiErr)
+	}
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s: decode response: %w", method, err)
+	}
+	return nil
+}
+
+// KV is a key and its value.
+type KV struct {
+	Key   string
+	Value []byte
+}
+
+// prefixEnd returns the end of the range of keys that start with prefix.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	// Every byte is 0xff; the range extends to the end of the keyspace.
+	return "\x00"
+}
+
+func b64(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+// Prefix returns every key that starts with prefix, in order.
+func (c *Client) Prefix(ctx context.Context, prefix string) ([]KV, error) {
+	var res struct {
+		KVs []struct {
+			Key   []byte `json:"key"`
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := c.post(ctx, "/v3/kv/range", map[string]string{"key": b64(prefix), "range_end": b64(prefixEnd(prefix))}, &res); err != nil {
+		return nil, fmt.Errorf("range %s: %w", prefix, err)
+	}
+	result := make([]KV, 0, len(res.KVs))
+	for _, kv := range res.KVs {
+		result = append(result, KV{Key: string(kv.Key), Value: kv.Value})
+	}
+	return result, nil
+}
+
+// Apply puts and deletes keys in one transaction, which etcd applies atomically.
+func (c *Client) Apply(ctx context.Context, put []KV, del []string) error {
+	var ops []interface{}
+	for _, kv := range put {
+		ops = append(ops, map[string]interface{}{"request_put": map[string]interface{}{"key": b64(kv.Key), "value": kv.Value}})
+	}
+	for _, k := range del {
+		ops = append(ops, map[string]interface{}{"request_delete_range": map[string]interface{}{"key": b64(k)}})
+	}
+	var res struct {
+		Succeeded bool `json:"succeeded"`
+	}
+	if err := c.post(ctx, "/v3/kv/txn", map[string]interface{}{"success": ops}, &res); err != nil {
+		return fmt.Errorf("txn: %w", err)
+	}
+	if !res.Succeeded {
+		return errors.New("txn: not applied")
+	}
+	return nil
+}
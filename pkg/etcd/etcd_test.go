@@ -0,0 +1,82 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPrefixEnd(t *testing.T) {
+	for prefix, want := range map[string]string{
+		"/skydns/com":  "/skydns/con",
+		"a\xff":        "b",
+		"\xff\xff":     "\x00",
+		"/skydns/com/": "/skydns/com0",
+	} {
+		if got := prefixEnd(prefix); got != want {
+			t.Errorf("prefixEnd(%q):\n  got: %q\n want: %q", prefix, got, want)
+		}
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	var tokens int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v3/auth/authenticate":
+			var r map[string]string
+			json.NewDecoder(req.Body).Decode(&r) // nolint:errcheck
+			if r["name"] != "nodedns" || r["password"] != "secret" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]interface{}{"code": 3, "message": "etcdserver: authentication failed, invalid user ID or password"}) // nolint:errcheck
+				return
+			}
+			tokens++
+			json.NewEncoder(w).Encode(map[string]interface{}{"token": "token-" + strconv.Itoa(tokens)}) // nolint:errcheck
+		case "/v3/kv/range":
+			// The first token has expired.
+			if req.Header.Get("Authorization") != "token-2" {
+				w.WriteHeader(http.StatusUnauthorized)
+				json.NewEncoder(w).Encode(map[string]interface{}{"code": 16, "message": "etcdserver: invalid auth token"}) // nolint:errcheck
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"kvs": []map[string][]byte{{"key": []byte("/a"), "value": []byte("b")}}}) // nolint:errcheck
+		}
+	}))
+	defer s.Close()
+	ctx := context.Background()
+
+	c, err := NewClient(Config{Endpoint: s.URL + "/", Username: "nodedns", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kvs, err := c.Prefix(ctx, "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(kvs, []KV{{Key: "/a", Value: []byte("b")}}); diff != "" {
+		t.Errorf("kvs:\n%s", diff)
+	}
+	if got, want := tokens, 2; got != want {
+		t.Errorf("tokens issued:\n  got: %v\n want: %v", got, want)
+	}
+
+	c, err = NewClient(Config{Endpoint: s.URL, Username: "nodedns", Password: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Prefix(ctx, "/")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an APIError, got %v", err)
+	}
+	if got, want := apiErr.Code, 3; got != want {
+		t.Errorf("code:\n  got: %v\n want: %v", got, want)
+	}
+}
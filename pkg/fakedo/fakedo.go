@@ -0,0 +1,398 @@
+// Package fakedo is a fake of the DigitalOcean domains API, for testing code that uses godo without
+// talking to the real API.  It supports zones, record CRUD, pagination, rate-limit headers, ETags on
+// record listings, and fault injection.  Like the real API, it accepts fully-qualified record names,
+// but stores and returns them relative to the zone.
+package fakedo
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/godo"
+)
+
+// Fault describes how to fail a request.
+type Fault struct {
+	Delay   time.Duration // Wait this long before handling the request (or failing it).
+	Status  int           // If non-zero, respond with this status instead of handling the request.
+	Message string        // The error message to return with Status.
+	Lost    bool          // Handle the request anyway, as though only the response was lost.
+}
+
+// FaultFunc decides whether to inject a fault into a request.  Returning nil handles the request
+// normally.
+type FaultFunc func(req *http.Request) *Fault
+
+// Server is a fake DigitalOcean API server.
+type Server struct {
+	*httptest.Server
+
+	// RateLimit is the number of requests allowed before requests fail with 429 Too Many
+	// Requests.  If zero, requests are not limited, but rate-limit headers are still sent.
+	RateLimit int
+
+	sync.Mutex
+	nextID   int
+	zones    map[string]map[int]godo.DomainRecord
+	fault    FaultFunc
+	requests int
+}
+
+// New starts a fake DigitalOcean API server that hosts the provided zones.  Call Close when done.
+func New(zones ...string) *Server {
+	s := &Server{nextID: 1, zones: make(map[string]map[int]godo.DomainRecord)}
+	for _, z := range zones {
+		s.zones[z] = make(map[int]godo.DomainRecord)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Client returns a godo client that talks to this server.
+func (s *Server) Client() *godo.Client {
+	c := godo.NewClient(s.Server.Client())
+	u, err := url.Parse(s.URL + "/")
+	if err != nil {
+		panic(fmt.Sprintf("parse httptest url: %v", err))
+	}
+	c.BaseURL = u
+	return c
+}
+
+// InjectFault arranges for f to be consulted before handling each request.  Pass nil to stop
+// injecting faults.
+func (s *Server) InjectFault(f FaultFunc) {
+	s.Lock()
+	defer s.Unlock()
+	s.fault = f
+}
+
+// Requests returns the number of requests served so far.
+func (s *Server) Requests() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.requests
+}
+
+// AddRecord adds a record to a zone, bypassing the API, and returns its ID.
+func (s *Server) AddRecord(zone string, r godo.DomainRecord) int {
+	s.Lock()
+	defer s.Unlock()
+	r.ID = s.nextID
+	r.Name = relativeName(zone, r.Name)
+	s.nextID++
+	s.zones[zone][r.ID] = r
+	return r.ID
+}
+
+// Records returns every record in a zone, sorted by ID.
+func (s *Server) Records(zone string) []godo.DomainRecord {
+	s.Lock()
+	defer s.Unlock()
+	return s.records(zone)
+}
+
+func (s *Server) records(zone string) []godo.DomainRecord {
+	result := make([]godo.DomainRecord, 0, len(s.zones[zone]))
+	for _, r := range s.zones[zone] {
+		result = append(result, r)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// Addresses returns the sorted data of every A and AAAA record in a zone, by record name.
+func (s *Server) Addresses(zone string) map[string][]string {
+	result := make(map[string][]string)
+	for _, r := range s.Records(zone) {
+		if r.Type == "A" || r.Type == "AAAA" {
+			result[r.Name] = append(result[r.Name], r.Data)
+		}
+	}
+	for _, addrs := range result {
+		sort.Strings(addrs)
+	}
+	return result
+}
+
+func writeJSON(w http.ResponseWriter, status int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(obj) // nolint:errcheck
+}
+
+func writeError(w http.ResponseWriter, status int, id, message string) {
+	writeJSON(w, status, map[string]string{"id": id, "message": message})
+}
+
+// paginate returns the page of items requested by req, and the links to send along with it.
+func paginate(req *http.Request, n int) (start, end int, links godo.Links) {
+	page, _ := strconv.Atoi(req.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	perPage, _ := strconv.Atoi(req.URL.Query().Get("per_page"))
+	if perPage < 1 {
+		perPage = 20
+	}
+	if perPage > 200 {
+		perPage = 200
+	}
+	start, end = (page-1)*perPage, page*perPage
+	if start > n {
+		start = n
+	}
+	if end > n {
+		end = n
+	}
+	pageURL := func(p int) string {
+		u := *req.URL
+		q := u.Query()
+		q.Set("page", strconv.Itoa(p))
+		u.RawQuery = q.Encode()
+		return "https://api.digitalocean.com" + u.RequestURI()
+	}
+	last := (n + perPage - 1) / perPage
+	if last > 1 {
+		links.Pages = &godo.Pages{}
+		if page > 1 {
+			links.Pages.First = pageURL(1)
+			links.Pages.Prev = pageURL(page - 1)
+		}
+		if page < last {
+			links.Pages.Next = pageURL(page + 1)
+			links.Pages.Last = pageURL(last)
+		}
+	}
+	return start, end, links
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	s.Lock()
+	fault := s.fault
+	s.Unlock()
+	if fault != nil {
+		if f := fault(req); f != nil {
+			if f.Delay > 0 {
+				select {
+				case <-time.After(f.Delay):
+				case <-req.Context().Done():
+					return
+				}
+			}
+			if f.Status != 0 {
+				if f.Lost {
+					s.handle(httptest.NewRecorder(), req)
+				}
+				writeError(w, f.Status, "injected_fault", f.Message)
+				return
+			}
+		}
+	}
+	s.handle(w, req)
+}
+
+// handle handles a request, after any fault has been injected.
+func (s *Server) handle(w http.ResponseWriter, req *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	s.requests++
+	remaining := 5000 - s.requests
+	if s.RateLimit > 0 {
+		remaining = s.RateLimit - s.requests
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+	limit := 5000
+	if s.RateLimit > 0 {
+		limit = s.RateLimit
+	}
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+	if s.RateLimit > 0 && s.requests > s.RateLimit {
+		writeError(w, http.StatusTooManyRequests, "too_many_requests", "API Rate limit exceeded.")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "v2" || parts[1] != "domains" {
+		writeError(w, http.StatusNotFound, "not_found", "The resource you were accessing could not be found.")
+		return
+	}
+	parts = parts[2:]
+	switch {
+	case len(parts) == 0 && req.Method == http.MethodGet:
+		s.listDomains(w, req)
+		return
+	case len(parts) == 0:
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+		return
+	}
+	zone, ok := s.zones[parts[0]]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "The resource you were accessing could not be found.")
+		return
+	}
+	switch {
+	case len(parts) == 1 && req.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, map[string]interface{}{"domain": godo.Domain{Name: parts[0]}})
+	case len(parts) == 2 && parts[1] == "records" && req.Method == http.MethodGet:
+		s.listRecords(w, req, parts[0])
+	case len(parts) == 2 && parts[1] == "records" && req.Method == http.MethodPost:
+		var edit godo.DomainRecordEditRequest
+		if err := json.NewDecoder(req.Body).Decode(&edit); err != nil {
+			writeError(w, http.StatusBadRequest, "bad_request", err.Error())
+			return
+		}
+		if edit.Type == "" || edit.Data == "" {
+			writeError(w, http.StatusUnprocessableEntity, "unprocessable_entity", "type and data are required")
+			return
+		}
+		r := godo.DomainRecord{ID: s.nextID}
+		s.nextID++
+		applyEdit(parts[0], &r, &edit)
+		zone[r.ID] = r
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"domain_record": r})
+	case len(parts) == 3 && parts[1] == "records":
+		id, err := strconv.Atoi(parts[2])
+		r, ok := zone[id]
+		if err != nil || !ok {
+			writeError(w, http.StatusNotFound, "not_found", "The resource you were accessing could not be found.")
+			return
+		}
+		switch req.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, map[string]interface{}{"domain_record": r})
+		case http.MethodPut, http.MethodPatch:
+			var edit godo.DomainRecordEditRequest
+			if err := json.NewDecoder(req.Body).Decode(&edit); err != nil {
+				writeError(w, http.StatusBadRequest, "bad_request", err.Error())
+				return
+			}
+			applyEdit(parts[0], &r, &edit)
+			zone[id] = r
+			writeJSON(w, http.StatusOK, map[string]interface{}{"domain_record": r})
+		case http.MethodDelete:
+			delete(zone, id)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not_found", "The resource you were accessing could not be found.")
+	}
+}
+
+func applyEdit(zone string, r *godo.DomainRecord, edit *godo.DomainRecordEditRequest) {
+	if edit.Type != "" {
+		r.Type = edit.Type
+	}
+	if edit.Name != "" {
+		r.Name = relativeName(zone, edit.Name)
+	}
+	if edit.Data != "" {
+		r.Data = edit.Data
+	}
+	if edit.TTL != 0 {
+		r.TTL = edit.TTL
+	}
+	r.Priority = edit.Priority
+	r.Port = edit.Port
+	r.Weight = edit.Weight
+	r.Flags = edit.Flags
+	r.Tag = edit.Tag
+}
+
+func (s *Server) listDomains(w http.ResponseWriter, req *http.Request) {
+	var names []string
+	for z := range s.zones {
+		names = append(names, z)
+	}
+	sort.Strings(names)
+	start, end, links := paginate(req, len(names))
+	domains := make([]godo.Domain, 0, end-start)
+	for _, n := range names[start:end] {
+		domains = append(domains, godo.Domain{Name: n})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"domains": domains,
+		"links":   links,
+		"meta":    godo.Meta{Total: len(names)},
+	})
+}
+
+// recordFQDN returns the fully-qualified name of a record, whose name may already be
+// fully-qualified, like the real API accepts.
+func recordFQDN(zone, name string) string {
+	switch {
+	case name == "@":
+		return zone
+	case name == zone || strings.HasSuffix(name, "."+zone):
+		return name
+	}
+	return name + "." + zone
+}
+
+// relativeName returns the name of a record relative to the zone, like the real API stores it.
+func relativeName(zone, name string) string {
+	name = strings.TrimSuffix(name, ".")
+	switch {
+	case name == zone:
+		return "@"
+	case strings.HasSuffix(name, "."+zone):
+		return strings.TrimSuffix(name, "."+zone)
+	}
+	return name
+}
+
+func (s *Server) listRecords(w http.ResponseWriter, req *http.Request, zone string) {
+	records := s.records(zone)
+	if name := req.URL.Query().Get("name"); name != "" {
+		// Like the real API, the filter is a fully-qualified name.
+		var filtered []godo.DomainRecord
+		for _, r := range records {
+			if recordFQDN(zone, r.Name) == name {
+				filtered = append(filtered, r)
+			}
+		}
+		records = filtered
+	}
+	if typ := req.URL.Query().Get("type"); typ != "" {
+		var filtered []godo.DomainRecord
+		for _, r := range records {
+			if r.Type == typ {
+				filtered = append(filtered, r)
+			}
+		}
+		records = filtered
+	}
+	start, end, links := paginate(req, len(records))
+	body, err := json.Marshal(map[string]interface{}{
+		"domain_records": records[start:end],
+		"links":          links,
+		"meta":           godo.Meta{Total: len(records)},
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(body))
+	w.Header().Set("ETag", etag)
+	if req.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body) // nolint:errcheck
+}
@@ -0,0 +1,149 @@
+package fakedo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/digitalocean/godo"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRecords(t *testing.T) {
+	s := New("example.com")
+	defer s.Close()
+	c := s.Client()
+	ctx := context.Background()
+
+	rec, _, err := c.Domains.CreateRecord(ctx, "example.com", &godo.DomainRecordEditRequest{Type: "A", Name: "nodes", Data: "10.0.0.1", TTL: 60})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, _, err := c.Domains.EditRecord(ctx, "example.com", rec.ID, &godo.DomainRecordEditRequest{Data: "10.0.0.2"}); err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+	got, _, err := c.Domains.Record(ctx, "example.com", rec.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	want := &godo.DomainRecord{ID: rec.ID, Type: "A", Name: "nodes", Data: "10.0.0.2", TTL: 60}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("get:\n%s", diff)
+	}
+	if _, err := c.Domains.DeleteRecord(ctx, "example.com", rec.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := c.Domains.DeleteRecord(ctx, "example.com", rec.ID); err == nil {
+		t.Error("delete of deleted record: expected error")
+	}
+	if _, _, err := c.Domains.Records(ctx, "example.org", nil); err == nil {
+		t.Error("list records in unknown zone: expected error")
+	}
+	if got := s.Records("example.com"); len(got) != 0 {
+		t.Errorf("records remain after delete: %v", got)
+	}
+}
+
+func TestRelativeNames(t *testing.T) {
+	s := New("example.com")
+	defer s.Close()
+	c := s.Client()
+	ctx := context.Background()
+
+	// Like the real API, fully-qualified names are accepted, but returned relative to the zone.
+	for _, name := range []string{"nodes.example.com", "example.com."} {
+		if _, _, err := c.Domains.CreateRecord(ctx, "example.com", &godo.DomainRecordEditRequest{Type: "A", Name: name, Data: "10.0.0.1"}); err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+	}
+	s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "internal.example.com", Data: "10.0.0.2"})
+	recs, _, err := c.Domains.Records(ctx, "example.com", nil)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	var got []string
+	for _, r := range recs {
+		got = append(got, r.Name)
+	}
+	if diff := cmp.Diff(got, []string{"nodes", "@", "internal"}); diff != "" {
+		t.Errorf("names:\n%s", diff)
+	}
+}
+
+func TestPagination(t *testing.T) {
+	s := New("example.com")
+	defer s.Close()
+	for i := 0; i < 25; i++ {
+		s.AddRecord("example.com", godo.DomainRecord{Type: "A", Name: "nodes", Data: fmt.Sprintf("10.0.0.%d", i)})
+	}
+	c := s.Client()
+	var got int
+	opts := &godo.ListOptions{Page: 1, PerPage: 10}
+	for {
+		recs, res, err := c.Domains.Records(context.Background(), "example.com", opts)
+		if err != nil {
+			t.Fatalf("list page %d: %v", opts.Page, err)
+		}
+		got += len(recs)
+		if res.Links.IsLastPage() {
+			break
+		}
+		page, err := res.Links.CurrentPage()
+		if err != nil {
+			t.Fatalf("current page: %v", err)
+		}
+		opts.Page = page + 1
+	}
+	if want := 25; got != want {
+		t.Errorf("records listed:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	s := New("example.com")
+	defer s.Close()
+	s.RateLimit = 2
+	c := s.Client()
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, res, err := c.Domains.Records(ctx, "example.com", nil); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		} else if got, want := res.Rate.Remaining, 1-i; got != want {
+			t.Errorf("request %d: remaining:\n  got: %v\n want: %v", i, got, want)
+		}
+	}
+	_, _, err := c.Domains.Records(ctx, "example.com", nil)
+	var errRes *godo.ErrorResponse
+	if !errors.As(err, &errRes) || errRes.Response.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected rate limit error, got %v", err)
+	}
+}
+
+func TestFault(t *testing.T) {
+	s := New("example.com")
+	defer s.Close()
+	s.InjectFault(func(req *http.Request) *Fault {
+		if req.Method == http.MethodPost {
+			return &Fault{Status: http.StatusServiceUnavailable, Message: "try again"}
+		}
+		return nil
+	})
+	c := s.Client()
+	ctx := context.Background()
+	if _, _, err := c.Domains.Records(ctx, "example.com", nil); err != nil {
+		t.Errorf("list: unexpected error: %v", err)
+	}
+	_, res, err := c.Domains.CreateRecord(ctx, "example.com", &godo.DomainRecordEditRequest{Type: "A", Name: "nodes", Data: "10.0.0.1"})
+	if err == nil {
+		t.Fatal("create: expected error")
+	}
+	if got, want := res.StatusCode, http.StatusServiceUnavailable; got != want {
+		t.Errorf("create: status:\n  got: %v\n want: %v", got, want)
+	}
+	s.InjectFault(nil)
+	if _, _, err := c.Domains.CreateRecord(ctx, "example.com", &godo.DomainRecordEditRequest{Type: "A", Name: "nodes", Data: "10.0.0.1"}); err != nil {
+		t.Errorf("create after removing fault: %v", err)
+	}
+}
@@ -0,0 +1,265 @@
+// Package handoff hands the job of writing DNS from one nodedns to another, during upgrades, so
+// that there is no moment when both of them write.
+//
+// The protocol has two phases, both driven by the new instance (the Target) against the old
+// instance's admin API (the Source).  First, the new instance checks that it can reach the DNS
+// provider.  Then it asks the old instance to prepare: the old instance stops writing, waits for
+// writes in progress to finish, and returns the records it published.  Finally, the new instance
+// commits, and only then starts writing.  If the old instance doesn't hear the commit in time, it
+// resumes writing, so a new instance that dies halfway doesn't leave nobody writing.
+package handoff
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var (
+	writingGauge = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "handoff_writing",
+			Help: "1 if this instance may write, 0 if it has handed off to another instance or is waiting to take over from one.",
+		},
+	)
+)
+
+// Gate stops writes, and waits for the writes in progress to finish, when this instance hands off.
+type Gate struct {
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewGate returns a Gate that lets writes through if open is true.
+func NewGate(open bool) *Gate {
+	g := &Gate{closed: !open}
+	g.set(open)
+	return g
+}
+
+func (g *Gate) set(open bool) {
+	g.closed = !open
+	if open {
+		writingGauge.Set(1)
+	} else {
+		writingGauge.Set(0)
+	}
+}
+
+// Enter returns true if a write may proceed; the caller must call Exit when the write is done.
+// It returns false if the gate is closed, and Exit must not be called.
+func (g *Gate) Enter() bool {
+	g.mu.RLock()
+	if g.closed {
+		g.mu.RUnlock()
+		return false
+	}
+	return true
+}
+
+// Exit ends a write started by a successful Enter.
+func (g *Gate) Exit() {
+	g.mu.RUnlock()
+}
+
+// IsOpen returns true if writes may proceed, for work that doesn't hold up a handoff, like
+// reporting status.
+func (g *Gate) IsOpen() bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return !g.closed
+}
+
+// Close stops new writes, and returns once the writes in progress have finished.
+func (g *Gate) Close() {
+	g.mu.Lock()
+	g.set(false)
+	g.mu.Unlock()
+}
+
+// Open lets writes through.
+func (g *Gate) Open() {
+	g.mu.Lock()
+	g.set(true)
+	g.mu.Unlock()
+}
+
+// State is what an instance that has stopped writing hands off.
+type State struct {
+	Stopped   time.Time       `json:"stopped"`   // When the instance stopped writing.
+	Snapshots []*dns.Snapshot `json:"snapshots"` // The records that it published.
+}
+
+// Source hands off writing to another instance.  It serves the prepare and commit phases of the
+// protocol at <prefix>/prepare and <prefix>/commit.
+type Source struct {
+	Gate   *Gate
+	Export func(ctx context.Context) ([]*dns.Snapshot, error) // Returns the records this instance publishes.
+	// Timeout is how long to wait for a commit after preparing, before writing again.
+	Timeout time.Duration
+	Logger  *zap.Logger
+
+	mu        sync.Mutex
+	timer     *time.Timer // Reopens the gate if the commit doesn't arrive; nil if not prepared.
+	committed bool
+}
+
+// NewSource returns a Source.
+func NewSource(gate *Gate, export func(ctx context.Context) ([]*dns.Snapshot, error), timeout time.Duration) *Source {
+	return &Source{Gate: gate, Export: export, Timeout: timeout, Logger: zap.L().Named("handoff")}
+}
+
+// ErrCommitted is returned when a handoff is requested from an instance that has already handed
+// off.
+var ErrCommitted = errors.New("already handed off")
+
+// Prepare stops writing and returns the records that this instance published.  If Commit isn't
+// called within the timeout, or the records can't be exported, writing resumes.
+func (s *Source) Prepare(ctx context.Context) (*State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.committed {
+		return nil, ErrCommitted
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.Gate.Close()
+	state := &State{Stopped: time.Now().UTC()}
+	snaps, err := s.Export(ctx)
+	if err != nil {
+		s.Gate.Open()
+		return nil, fmt.Errorf("export records: %w", err)
+	}
+	state.Snapshots = snaps
+	s.timer = time.AfterFunc(s.Timeout, s.abort)
+	s.Logger.Info("stopped writing for handoff; waiting for commit", zap.Duration("timeout", s.Timeout))
+	return state, nil
+}
+
+// abort resumes writing after a handoff that wasn't committed in time.
+func (s *Source) abort() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.committed || s.timer == nil {
+		return
+	}
+	s.timer = nil
+	s.Gate.Open()
+	s.Logger.Warn("handoff wasn't committed in time; writing again")
+}
+
+// Commit completes a prepared handoff.  This instance never writes again.
+func (s *Source) Commit() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.committed {
+		return nil
+	}
+	if s.timer == nil {
+		return errors.New("handoff not prepared, or it timed out")
+	}
+	s.timer.Stop()
+	s.timer = nil
+	s.committed = true
+	s.Logger.Info("handed off; no longer writing")
+	return nil
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Source) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/prepare"):
+		state, err := s.Prepare(req.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(state) // nolint:errcheck
+	case strings.HasSuffix(req.URL.Path, "/commit"):
+		if err := s.Commit(); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"committed": true}) // nolint:errcheck
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// Target takes over writing from the instance whose admin API is at URL.
+type Target struct {
+	URL    string // Like http://nodedns-old.kube-system:8080; the handoff is at /api/handoff.
+	Token  string // A bearer token for the admin API, if it requires one.
+	Client *http.Client
+}
+
+func (t *Target) post(ctx context.Context, phase string, result interface{}) error {
+	url := strings.TrimSuffix(t.URL, "/") + "/api/handoff/" + phase
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &bytes.Buffer{})
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	if t.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.Token)
+	}
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post %s: %w", url, err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("post %s: read body: %w", url, err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("post %s: unexpected status %s: %s", url, res.Status, strings.TrimSpace(string(body)))
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("post %s: unmarshal response: %w", url, err)
+	}
+	return nil
+}
+
+// Takeover checks that this instance can reach the DNS provider with validate, then asks the old
+// instance to stop writing and commits the handoff.  When it returns successfully, the old instance
+// has stopped writing for good, and the caller should open its gate and resync.  If it fails, the
+// old instance resumes writing, if it ever stopped.
+func (t *Target) Takeover(ctx context.Context, validate func(ctx context.Context) error) (*State, error) {
+	if err := validate(ctx); err != nil {
+		return nil, fmt.Errorf("validate: %w", err)
+	}
+	state := new(State)
+	if err := t.post(ctx, "prepare", state); err != nil {
+		return nil, fmt.Errorf("prepare: %w", err)
+	}
+	var committed struct{}
+	if err := t.post(ctx, "commit", &committed); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	return state, nil
+}
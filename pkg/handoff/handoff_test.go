@@ -0,0 +1,116 @@
+package handoff
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/dns"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestGate(t *testing.T) {
+	g := NewGate(true)
+	if !g.Enter() {
+		t.Fatal("open gate refused a write")
+	}
+	closed := make(chan struct{})
+	go func() {
+		g.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("gate closed during a write")
+	case <-time.After(10 * time.Millisecond):
+	}
+	g.Exit()
+	<-closed
+	if g.Enter() {
+		t.Error("closed gate allowed a write")
+	}
+	g.Open()
+	if !g.Enter() {
+		t.Error("reopened gate refused a write")
+	}
+	g.Exit()
+}
+
+func TestHandoff(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	snap := &dns.Snapshot{Zone: "example.com", Records: []dns.SnapshotRecord{{Name: "nodes", Type: "A", TTL: 60, Data: "42.0.0.1"}}}
+	gate := NewGate(true)
+	src := NewSource(gate, func(ctx context.Context) ([]*dns.Snapshot, error) {
+		return []*dns.Snapshot{snap}, nil
+	}, time.Minute)
+	src.Logger = l
+	mux := http.NewServeMux()
+	mux.Handle("/api/handoff/", src)
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	target := &Target{URL: s.URL}
+	if _, err := target.Takeover(ctx, func(ctx context.Context) error { return errors.New("provider unreachable") }); err == nil {
+		t.Error("takeover succeeded without a valid provider")
+	}
+	if !gate.Enter() {
+		t.Fatal("old instance stopped writing although the new instance couldn't reach the provider")
+	}
+	gate.Exit()
+
+	state, err := target.Takeover(ctx, func(ctx context.Context) error { return nil })
+	if err != nil {
+		t.Fatalf("takeover: %v", err)
+	}
+	if diff := cmp.Diff(state.Snapshots, []*dns.Snapshot{snap}); diff != "" {
+		t.Errorf("snapshots:\n%s", diff)
+	}
+	if gate.Enter() {
+		t.Error("old instance still writing after handoff")
+	}
+	if _, err := target.Takeover(ctx, func(ctx context.Context) error { return nil }); err == nil || !strings.Contains(err.Error(), ErrCommitted.Error()) {
+		t.Errorf("second takeover:\n  got: %v\n want: %v", err, ErrCommitted)
+	}
+}
+
+func TestHandoffTimeout(t *testing.T) {
+	gate := NewGate(true)
+	src := NewSource(gate, func(ctx context.Context) ([]*dns.Snapshot, error) { return nil, nil }, 10*time.Millisecond)
+	src.Logger = zaptest.NewLogger(t)
+	if _, err := src.Prepare(context.Background()); err != nil {
+		t.Fatalf("prepare: %v", err)
+	}
+	if gate.Enter() {
+		t.Fatal("still writing after prepare")
+	}
+	// The new instance never commits, so the old instance writes again.
+	deadline := time.Now().Add(10 * time.Second)
+	for !gate.Enter() {
+		if time.Now().After(deadline) {
+			t.Fatal("not writing again after the commit timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	gate.Exit()
+	if err := src.Commit(); err == nil {
+		t.Error("late commit succeeded")
+	}
+
+	// A failed export resumes writing right away.
+	src.Export = func(ctx context.Context) ([]*dns.Snapshot, error) { return nil, errors.New("injected error") }
+	if _, err := src.Prepare(context.Background()); err == nil {
+		t.Error("prepare succeeded despite a failed export")
+	}
+	if !gate.Enter() {
+		t.Error("not writing after a failed prepare")
+	}
+	gate.Exit()
+}
@@ -0,0 +1,63 @@
+package k8s
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAsync(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	ns.Logger = l
+	ns.Async = true
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	var mu sync.Mutex
+	var got []Record
+	ns.OnChange = func(req UpdateRequest) error {
+		if req.Record.Kind != Internal {
+			return nil
+		}
+		started <- struct{}{}
+		<-release
+		mu.Lock()
+		got = append(got, req.Record)
+		mu.Unlock()
+		return nil
+	}
+	node := func(name, addr string) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: addr}},
+			},
+		}
+	}
+
+	// Events don't wait for the update that they started.
+	ns.Add(node("host-1", "10.0.0.1")) // nolint:errcheck
+	<-started
+	ns.Add(node("host-2", "10.0.0.2")) // nolint:errcheck
+	ns.Add(node("host-3", "10.0.0.3")) // nolint:errcheck
+	close(release)
+	if err := ns.Drain(context.Background()); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+
+	// The two events that arrived during the first update are sent together.
+	want := []Record{
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 1)}},
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 3)}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("updates:\n%s", diff)
+	}
+}
@@ -0,0 +1,74 @@
+package k8s
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jrockway/nodedns/pkg/tracing"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Cluster returns a cache.Store that feeds the nodes of another cluster, called name, into the
+// store, alongside the store's own nodes and those of any other clusters.  Each cluster's nodes are
+// tracked separately, so nodes with the same name in different clusters don't collide, and a list
+// of one cluster's nodes (after its watch reconnects, for example) doesn't remove the others'.
+// Pass it to WatchNodesWithConfig, with a config from ClusterConfig.  The store isn't Synced until
+// the cluster's nodes have been listed.
+func (s *NodeStore) Cluster(name string) cache.Store {
+	s.Lock()
+	s.clusters[name] = true
+	s.Unlock()
+	return &clusterStore{store: s, cluster: name}
+}
+
+// clusterStore is a view of a NodeStore that only contains one cluster's nodes.
+type clusterStore struct {
+	store   *NodeStore
+	cluster string
+}
+
+// Add implements cache.Store.
+func (c *clusterStore) Add(obj interface{}) error { return c.store.set(c.cluster, "add", obj) }
+
+// Update implements cache.Store.
+func (c *clusterStore) Update(obj interface{}) error { return c.store.set(c.cluster, "update", obj) }
+
+// Delete implements cache.Store.
+func (c *clusterStore) Delete(obj interface{}) error { return c.store.delete(c.cluster, obj) }
+
+// Replace implements cache.Store.
+func (c *clusterStore) Replace(objs []interface{}, unusedResourceVersion string) error {
+	return c.store.replace(c.cluster, objs)
+}
+
+// Resync implements cache.Store.
+func (c *clusterStore) Resync() error { return c.store.Resync() }
+
+// Like NodeStore, clusterStore only implements cache.Store for cache.Reflector.
+func (c *clusterStore) List() []interface{} { return nil }
+func (c *clusterStore) ListKeys() []string  { return nil }
+func (c *clusterStore) Get(obj interface{}) (item interface{}, exists bool, err error) {
+	return nil, false, errors.New("unimplemented")
+}
+func (c *clusterStore) GetByKey(key string) (item interface{}, exists bool, err error) {
+	return nil, false, errors.New("unimplemented")
+}
+
+// ClusterConfig builds a client configuration for the cluster that the kubeconfig's context (or,
+// if context is empty, its current context) refers to.
+func ClusterConfig(kubeconfig, context string) (*rest.Config, error) {
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig},
+		&clientcmd.ConfigOverrides{CurrentContext: context},
+	).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes: build config for context %q of %s: %w", context, kubeconfig, err)
+	}
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		return tracing.WrapRoundTripper(rt)
+	}
+	return config, nil
+}
@@ -0,0 +1,113 @@
+package k8s
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestClusters(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	node := func(name, ip string) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: ip}},
+			},
+		}
+	}
+	ns := NewNodeStore("test")
+	var got []net.IP
+	ns.OnChange = func(req UpdateRequest) error {
+		if req.Record.Kind == Internal {
+			got = req.Record.IPs
+		}
+		return nil
+	}
+	west := ns.Cluster("west")
+
+	check := func(step string, want ...string) {
+		t.Helper()
+		wantIPs := []net.IP{}
+		for _, ip := range want {
+			wantIPs = append(wantIPs, net.ParseIP(ip))
+		}
+		if diff := cmp.Diff(got, wantIPs); diff != "" {
+			t.Errorf("%s: internal record:\n%s", step, diff)
+		}
+	}
+
+	ns.Add(node("host-1", "10.0.0.1")) // nolint:errcheck
+	check("add local node", "10.0.0.1")
+
+	// A node with the same name in another cluster is a different node.
+	west.Replace([]interface{}{node("host-1", "10.1.0.1"), node("host-2", "10.1.0.2")}, "") // nolint:errcheck
+	check("list west", "10.0.0.1", "10.1.0.1", "10.1.0.2")
+
+	west.Delete(node("host-1", "10.1.0.1")) // nolint:errcheck
+	check("delete west node", "10.0.0.1", "10.1.0.2")
+
+	// Relisting one cluster leaves the other cluster's nodes alone.
+	ns.Replace(nil, "") // nolint:errcheck
+	check("relist local", "10.1.0.2")
+	west.Replace(nil, "") // nolint:errcheck
+	check("relist west")
+
+	ns.Add(node("host-1", "10.0.0.1"))      // nolint:errcheck
+	west.Update(node("host-1", "10.1.0.1")) // nolint:errcheck
+	if st := ns.Status(); len(st.Nodes) != 2 || st.Nodes[0].Cluster != "" || st.Nodes[1].Cluster != "west" {
+		t.Errorf("status nodes:\n  got: %v\n want: host-1 and west/host-1", st.Nodes)
+	}
+}
+
+func TestSynced(t *testing.T) {
+	zap.ReplaceGlobals(zaptest.NewLogger(t))
+	node := func(name, ip string) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: ip}},
+			},
+		}
+	}
+	ns := NewNodeStore("test")
+	var updates int
+	var syncedDuringUpdate bool
+	ns.OnChange = func(req UpdateRequest) error {
+		updates++
+		syncedDuringUpdate = syncedDuringUpdate || ns.Synced()
+		return nil
+	}
+	if ns.Synced() {
+		t.Error("synced before any nodes")
+	}
+	ns.Add(node("host-1", "10.0.0.1")) // nolint:errcheck
+	if ns.Synced() {
+		t.Error("synced after one node's event")
+	}
+	west := ns.Cluster("west")
+	ns.Replace([]interface{}{node("host-1", "10.0.0.1"), node("host-2", "10.0.0.2")}, "") // nolint:errcheck
+	if got, want := updates, 2; got != want {
+		t.Errorf("updates:\n  got: %v\n want: %v", got, want)
+	}
+	if syncedDuringUpdate {
+		t.Error("synced before the sinks were notified of the list")
+	}
+	if ns.Synced() {
+		t.Error("synced before another cluster was listed")
+	}
+	west.Add(node("host-1", "10.1.0.1")) // nolint:errcheck
+	if ns.Synced() {
+		t.Error("synced after one of another cluster's nodes")
+	}
+	west.Replace([]interface{}{node("host-1", "10.1.0.1")}, "") // nolint:errcheck
+	if !ns.Synced() {
+		t.Error("not synced after every cluster was listed")
+	}
+}
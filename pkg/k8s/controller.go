@@ -0,0 +1,193 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/zapr"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// SelectedStore is a NodeStore and the label selector that chooses the nodes it contains.
+type SelectedStore struct {
+	Store    *NodeStore
+	Selector string // A label selector; if empty, every node is selected.
+}
+
+// ControllerConfig configures RunController.
+type ControllerConfig struct {
+	Master, Kubeconfig string        // See WatchNodes.
+	Resync             time.Duration // If non-zero, every store is resync'd at this interval.
+
+	// If set, RunResyncs schedules each store's resyncs instead of a plain ticker; for example,
+	// to stagger them (see package budget).  It must return when the context is done.
+	RunResyncs func(ctx context.Context, interval time.Duration, resync func() error)
+
+	// If LeaderElection is true, only one replica publishes records at a time.
+	// LeaderElectionNamespace is required when running outside of the cluster.
+	LeaderElection          bool
+	LeaderElectionNamespace string
+	LeaderElectionID        string
+
+	// OnElected, if set, is called when this replica starts running the controllers: when it
+	// becomes the leader, or right away without leader election.
+	OnElected func()
+}
+
+// NodeReconciler is a controller-runtime Reconciler that keeps a NodeStore up to date.  Until Sync
+// has replaced the store's nodes with a full list, reconciles are held back; publishing a record
+// with only the first reconciled node would remove every other node's addresses.
+type NodeReconciler struct {
+	Client   ctrlclient.Reader
+	Store    *NodeStore
+	Selector labels.Selector
+
+	mu     sync.RWMutex // Held for writing by Sync, and for reading by each reconcile.
+	synced bool         // Whether Sync has listed the nodes.
+}
+
+var _ reconcile.Reconciler = (*NodeReconciler)(nil)
+
+// Reconcile implements reconcile.Reconciler.
+func (r *NodeReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	// Reconciles that Sync holds back start before it lists the nodes, so the list includes
+	// their changes.
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if !r.synced {
+		return reconcile.Result{}, nil
+	}
+	node := new(v1.Node)
+	if err := r.Client.Get(ctx, req.NamespacedName, node); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return reconcile.Result{}, fmt.Errorf("get node: %w", err)
+		}
+		node.Name = req.Name
+		return reconcile.Result{}, r.Store.Delete(node)
+	}
+	// Nodes that stop matching the selector are treated like deleted nodes; a predicate on
+	// the watch would never see them leave.
+	if r.Selector != nil && !r.Selector.Matches(labels.Set(node.GetLabels())) {
+		return reconcile.Result{}, r.Store.Delete(node)
+	}
+	return reconcile.Result{}, r.Store.Update(node)
+}
+
+// Sync replaces the store's nodes with every selected node that the client has, and then lets
+// reconciles through.  Call it once the client's cache has synced.
+func (r *NodeReconciler) Sync(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var opts []ctrlclient.ListOption
+	if r.Selector != nil {
+		opts = append(opts, ctrlclient.MatchingLabelsSelector{Selector: r.Selector})
+	}
+	list := new(v1.NodeList)
+	if err := r.Client.List(ctx, list, opts...); err != nil {
+		return fmt.Errorf("list nodes: %w", err)
+	}
+	objs := make([]interface{}, 0, len(list.Items))
+	for i := range list.Items {
+		objs = append(objs, &list.Items[i])
+	}
+	if err := r.Store.Replace(objs, list.ResourceVersion); err != nil {
+		return fmt.Errorf("replace nodes: %w", err)
+	}
+	r.synced = true
+	return nil
+}
+
+// RunController watches nodes with a controller-runtime manager until the context is done,
+// reconciling them into the provided stores.  Unlike WatchNodes, every store shares a single
+// watch, and leader election is available so that several replicas can be run.
+func RunController(ctx context.Context, cfg ControllerConfig, stores []SelectedStore) error {
+	config, err := restConfig(cfg.Master, cfg.Kubeconfig)
+	if err != nil {
+		return err
+	}
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return fmt.Errorf("kubernetes: build scheme: %w", err)
+	}
+	mgr, err := ctrl.NewManager(config, ctrl.Options{
+		Scheme: scheme,
+		Logger: zapr.NewLogger(zap.L().Named("controller-runtime")),
+		// Metrics are served on the debug server (see main); health is reported by the
+		// opinionated-server.
+		MetricsBindAddress:      "0",
+		HealthProbeBindAddress:  "0",
+		LeaderElection:          cfg.LeaderElection,
+		LeaderElectionNamespace: cfg.LeaderElectionNamespace,
+		LeaderElectionID:        cfg.LeaderElectionID,
+	})
+	if err != nil {
+		return fmt.Errorf("kubernetes: new manager: %w", err)
+	}
+	for _, s := range stores {
+		sel, err := labels.Parse(s.Selector)
+		if err != nil {
+			return fmt.Errorf("store %s: parse selector: %w", s.Store.Name, err)
+		}
+		r := &NodeReconciler{Client: mgr.GetClient(), Store: s.Store, Selector: sel}
+		if err := ctrl.NewControllerManagedBy(mgr).Named("nodes-" + s.Store.Name).For(&v1.Node{}).Complete(r); err != nil {
+			return fmt.Errorf("store %s: new controller: %w", s.Store.Name, err)
+		}
+		// Like WatchNodes' reflector, the store starts with a full list of the nodes, rather
+		// than one reconcile at a time, and is only resync'd after that.  RunnableFuncs only
+		// run while we are the leader, like the controllers.
+		store := s.Store
+		if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			if !mgr.GetCache().WaitForCacheSync(ctx) {
+				return nil
+			}
+			if err := r.Sync(ctx); err != nil {
+				return fmt.Errorf("store %s: initial sync: %w", store.Name, err)
+			}
+			if cfg.Resync <= 0 {
+				return nil
+			}
+			if cfg.RunResyncs != nil {
+				cfg.RunResyncs(ctx, cfg.Resync, store.Resync)
+				return nil
+			}
+			t := time.NewTicker(cfg.Resync)
+			defer t.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-t.C:
+					if err := store.Resync(); err != nil {
+						store.Logger.Error("resync failed", zap.Error(err))
+					}
+				}
+			}
+		})); err != nil {
+			return fmt.Errorf("store %s: add sync: %w", s.Store.Name, err)
+		}
+	}
+	if cfg.OnElected != nil {
+		go func() {
+			select {
+			case <-mgr.Elected():
+				cfg.OnElected()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	if err := mgr.Start(ctx); err != nil {
+		return fmt.Errorf("kubernetes: run manager: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package k8s
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestReconcile(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ctx := context.Background()
+	ns := NewNodeStore("test")
+	var got []Record
+	ns.OnChange = func(req UpdateRequest) error {
+		got = append(got, req.Record)
+		return nil
+	}
+
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress-1", Labels: map[string]string{"role": "ingress"}},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "42.0.0.1"}},
+		},
+	}
+	c := fake.NewClientBuilder().Build()
+	sel, err := labels.Parse("role=ingress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &NodeReconciler{Client: c, Store: ns, Selector: sel}
+	if err := r.Sync(ctx); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	got = nil
+	if err := c.Create(ctx, node); err != nil {
+		t.Fatalf("create node: %v", err)
+	}
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "ingress-1"}}
+
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("reconcile new node: %v", err)
+	}
+	want := []Record{{Kind: External, IPs: []net.IP{net.IPv4(42, 0, 0, 1)}}}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("new node:\n%s", diff)
+	}
+
+	got = nil
+	node.Labels["role"] = "storage"
+	if err := c.Update(ctx, node); err != nil {
+		t.Fatalf("relabel node: %v", err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("reconcile relabeled node: %v", err)
+	}
+	want = []Record{{Kind: External, IPs: []net.IP{}}}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("relabeled node:\n%s", diff)
+	}
+
+	got = nil
+	node.Labels["role"] = "ingress"
+	if err := c.Update(ctx, node); err != nil {
+		t.Fatalf("relabel node: %v", err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("reconcile relabeled node: %v", err)
+	}
+	if err := c.Delete(ctx, node); err != nil {
+		t.Fatalf("delete node: %v", err)
+	}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("reconcile deleted node: %v", err)
+	}
+	want = []Record{
+		{Kind: External, IPs: []net.IP{net.IPv4(42, 0, 0, 1)}},
+		{Kind: External, IPs: []net.IP{}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("deleted node:\n%s", diff)
+	}
+}
+
+func TestReconcileBeforeSync(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ctx := context.Background()
+	ns := NewNodeStore("test")
+	var got []Record
+	ns.OnChange = func(req UpdateRequest) error {
+		got = append(got, req.Record)
+		return nil
+	}
+
+	node := func(name, role, addr string) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"role": role}},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: addr}},
+			},
+		}
+	}
+	c := fake.NewClientBuilder().WithObjects(
+		node("ingress-1", "ingress", "42.0.0.1"),
+		node("ingress-2", "ingress", "42.0.0.2"),
+		node("storage-1", "storage", "42.0.0.3"),
+	).Build()
+	sel, err := labels.Parse("role=ingress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &NodeReconciler{Client: c, Store: ns, Selector: sel}
+
+	// A record with only the first reconciled node would remove the other node's address.
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "ingress-1"}}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("reconcile before sync: %v", err)
+	}
+	if len(got) > 0 {
+		t.Errorf("reconcile before sync published records: %v", got)
+	}
+	if err := ns.Ready(""); err == nil {
+		t.Error("store is ready before sync")
+	}
+
+	if err := r.Sync(ctx); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	want := []Record{{Kind: External, IPs: []net.IP{net.IPv4(42, 0, 0, 1), net.IPv4(42, 0, 0, 2)}}}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("sync:\n%s", diff)
+	}
+
+	got = nil
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatalf("reconcile after sync: %v", err)
+	}
+	if len(got) > 0 {
+		t.Errorf("reconcile of an unchanged node published records: %v", got)
+	}
+}
@@ -0,0 +1,47 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+)
+
+// Drain stops retrying failed updates, and waits for the events in progress, and the updates that
+// they started, to finish, or for the context to be done.  Records whose updates fail stay
+// pending, and are retried after a restart if PendingPath is set.  Stop the watch that feeds the
+// store first, so that no new events arrive.
+func (s *NodeStore) Drain(ctx context.Context) error {
+	s.Lock()
+	s.draining = true
+	for key, r := range s.retries {
+		if r.timer != nil {
+			r.timer.Stop()
+		}
+		delete(s.retries, key)
+	}
+	for name, g := range s.graced {
+		g.timer.Stop()
+		delete(s.graced, name)
+	}
+	for name, r := range s.removing {
+		r.timer.Stop()
+		delete(s.removing, name)
+	}
+	if s.inflight == 0 {
+		s.Unlock()
+		return nil
+	}
+	if s.drained == nil {
+		s.drained = make(chan struct{})
+	}
+	done := s.drained
+	s.Unlock()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.Lock()
+		n := s.inflight
+		s.Unlock()
+		return fmt.Errorf("wait for %d events in progress: %w", n, ctx.Err())
+	}
+}
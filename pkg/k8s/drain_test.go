@@ -0,0 +1,62 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/clock"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDrain(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	ns.Logger = l
+	fake := clock.NewFake(time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC))
+	ns.Clock = fake
+	ns.RetryMin, ns.RetryMax = time.Second, time.Second
+	started, release := make(chan struct{}), make(chan struct{})
+	ns.OnChange = func(req UpdateRequest) error {
+		close(started)
+		<-release
+		return errors.New("injected error")
+	}
+	go ns.Add(&v1.Node{ // nolint:errcheck
+		ObjectMeta: metav1.ObjectMeta{Name: "host-1"},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.1"}},
+		},
+	})
+	<-started
+
+	tctx, c := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer c()
+	if err := ns.Drain(tctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("drain with an update in progress:\n  got: %v\n want: %v", err, context.DeadlineExceeded)
+	}
+
+	done := make(chan error)
+	go func() { done <- ns.Drain(context.Background()) }()
+	select {
+	case err := <-done:
+		t.Fatalf("drain returned with an update in progress: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("drain: %v", err)
+	}
+	// The update failed, but the store is draining, so it isn't retried.
+	if got, want := fake.Pending(), 0; got != want {
+		t.Errorf("pending retries after drain:\n  got: %v\n want: %v", got, want)
+	}
+	if err := ns.Drain(context.Background()); err != nil {
+		t.Errorf("drain of an idle store: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package k8s
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// watchEvents are the store operations that come from the node watch, rather than from nodedns
+// itself.
+var watchEvents = map[string]bool{"add": true, "update": true, "delete": true, "replace": true}
+
+// eventAgeCollector exports how long it's been since each store received a node event.  A watch
+// whose connection to the API server dies without an error stops delivering events, but nothing
+// else notices; the age keeps growing until an alert fires.
+type eventAgeCollector struct {
+	desc   *prometheus.Desc
+	mu     sync.Mutex
+	stores map[string]*NodeStore // By name; a newer store with the same name replaces an older one.
+}
+
+var eventAges = &eventAgeCollector{
+	desc:   prometheus.NewDesc("node_event_age_seconds", "How long it has been since each store received a node event (an add, update, delete, or list) from its watch.", []string{"store"}, nil),
+	stores: make(map[string]*NodeStore),
+}
+
+func init() {
+	prometheus.MustRegister(eventAges)
+}
+
+func (c *eventAgeCollector) add(s *NodeStore) {
+	c.mu.Lock()
+	c.stores[s.Name] = s
+	c.mu.Unlock()
+}
+
+// Describe implements prometheus.Collector.
+func (c *eventAgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector.  Stores that haven't received an event yet aren't
+// reported.
+func (c *eventAgeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, s := range c.stores {
+		age, ok := s.EventAge()
+		if !ok {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, age.Seconds(), name)
+	}
+}
+
+// EventAge returns how long it has been since the store received a node event from its watch, or
+// false if it hasn't received one yet.
+func (s *NodeStore) EventAge() (time.Duration, bool) {
+	s.Lock()
+	defer s.Unlock()
+	if s.lastEvent.IsZero() {
+		return 0, false
+	}
+	return s.Clock.Now().Sub(s.lastEvent), true
+}
@@ -0,0 +1,45 @@
+package k8s
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/clock"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEventAge(t *testing.T) {
+	fake := clock.NewFake(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	ns := NewNodeStore("event-age")
+	ns.Logger = zaptest.NewLogger(t)
+	ns.Clock = fake
+	if age, ok := ns.EventAge(); ok {
+		t.Errorf("age before any events:\n  got: %v\n want: none", age)
+	}
+
+	ns.Add(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "host-1"}})
+	fake.Advance(time.Minute)
+	// Resyncs come from a timer, not the watch, so they don't count.
+	ns.Resync()
+	fake.Advance(time.Minute)
+	if got, want := mustEventAge(t, ns), 2*time.Minute; got != want {
+		t.Errorf("age after resync:\n  got: %v\n want: %v", got, want)
+	}
+
+	ns.Update(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "host-1"}})
+	fake.Advance(time.Second)
+	if got, want := mustEventAge(t, ns), time.Second; got != want {
+		t.Errorf("age after update:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+func mustEventAge(t *testing.T, ns *NodeStore) time.Duration {
+	t.Helper()
+	age, ok := ns.EventAge()
+	if !ok {
+		t.Fatal("no events recorded")
+	}
+	return age
+}
@@ -0,0 +1,233 @@
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	sinkLastSuccess = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sink_last_success_timestamp_seconds",
+			Help: "When each sink last updated a record successfully.",
+		},
+		[]string{"store", "sink"},
+	)
+	sinkConsecutiveFailures = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sink_consecutive_failures",
+			Help: "How many times in a row each sink has failed to update a record.",
+		},
+		[]string{"store", "sink"},
+	)
+)
+
+// SinkHealth is the health of one of a store's sinks.
+type SinkHealth struct {
+	Store               string    `json:"store"`
+	Sink                string    `json:"sink"`
+	LastSuccess         time.Time `json:"last_success"`
+	LastFailure         time.Time `json:"last_failure"`
+	LastError           string    `json:"last_error,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+}
+
+// recordHealth records the result of a sink's update, and returns how many times in a row the sink
+// has failed.
+func (s *NodeStore) recordHealth(sink Sink, err error) int {
+	s.Lock()
+	defer s.Unlock()
+	h, ok := s.health[sink.Name()]
+	if !ok {
+		h = &SinkHealth{Store: s.Name, Sink: sink.Name()}
+		s.health[sink.Name()] = h
+	}
+	now := s.Clock.Now()
+	if err == nil {
+		h.LastSuccess = now
+		h.ConsecutiveFailures = 0
+		sinkLastSuccess.WithLabelValues(s.Name, h.Sink).Set(float64(now.UnixNano()) / 1e9)
+	} else {
+		h.LastFailure = now
+		h.LastError = err.Error()
+		h.ConsecutiveFailures++
+	}
+	sinkConsecutiveFailures.WithLabelValues(s.Name, h.Sink).Set(float64(h.ConsecutiveFailures))
+	return h.ConsecutiveFailures
+}
+
+// AppliedRecord is the result of the last update of one of a store's records by one sink.
+type AppliedRecord struct {
+	Sink        string    `json:"sink"`
+	Addresses   []string  `json:"addresses"` // What the sink last published successfully.
+	LastSuccess time.Time `json:"last_success"`
+	LastFailure time.Time `json:"last_failure"`
+	LastError   string    `json:"last_error,omitempty"` // Empty if the last update succeeded.
+}
+
+// recordApplied records the result of a sink's update of a record, for Status.
+func (s *NodeStore) recordApplied(sink Sink, req UpdateRequest, err error) {
+	s.Lock()
+	defer s.Unlock()
+	key := retryKey{sink: sink.Name(), kind: req.Record.Kind}
+	a, ok := s.applied[key]
+	if !ok {
+		a = &AppliedRecord{Sink: sink.Name(), Addresses: []string{}}
+		s.applied[key] = a
+	}
+	now := s.Clock.Now()
+	if err == nil {
+		a.LastSuccess = now
+		a.LastError = ""
+		a.Addresses = ipStrings(req.Record.IPs)
+		if a.Addresses == nil {
+			a.Addresses = []string{}
+		}
+	} else {
+		a.LastFailure = now
+		a.LastError = err.Error()
+	}
+}
+
+// appliedRecords returns the result of the last update of the record of the provided kind by each sink,
+// sorted by sink.  The caller must hold the lock.
+func (s *NodeStore) appliedRecords(kind Kind) []AppliedRecord {
+	var result []AppliedRecord
+	for key, a := range s.applied {
+		if key.kind == kind {
+			result = append(result, *a)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Sink < result[j].Sink })
+	return result
+}
+
+// Health returns the health of each sink that has updated a record, sorted by name.
+func (s *NodeStore) Health() []SinkHealth {
+	s.Lock()
+	defer s.Unlock()
+	result := make([]SinkHealth, 0, len(s.health))
+	for _, h := range s.health {
+		result = append(result, *h)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Sink < result[j].Sink })
+	return result
+}
+
+// HealthHandler returns an http.Handler that reports the health of every sink of the provided
+// stores, as JSON.  It responds with 503 Service Unavailable if any sink has failed at least
+// maxFailures times in a row.
+func HealthHandler(stores []*NodeStore, maxFailures int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var result struct {
+			Healthy bool         `json:"healthy"`
+			Sinks   []SinkHealth `json:"sinks"`
+		}
+		result.Healthy = true
+		result.Sinks = []SinkHealth{}
+		for _, st := range stores {
+			for _, h := range st.Health() {
+				if maxFailures > 0 && h.ConsecutiveFailures >= maxFailures {
+					result.Healthy = false
+				}
+				result.Sinks = append(result.Sinks, h)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !result.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(result) // nolint:errcheck
+	})
+}
+
+// minEventAge is the shortest time without node events that Live treats as a broken watch.
+// Kubelets post their node's status at least every 5 minutes, even if nothing changed, so a
+// working watch of a cluster with nodes is never quiet for longer.
+const minEventAge = 10 * time.Minute
+
+// Ready returns an error unless the store has received its first full list of nodes from its
+// watch and, if sink is non-empty, the named sink has updated a record successfully at least once.
+// Events for single nodes don't count; until the list arrives, the store has only some of the
+// nodes.
+func (s *NodeStore) Ready(sink string) error {
+	s.Lock()
+	defer s.Unlock()
+	if !s.listed[""] {
+		return fmt.Errorf("store %s: no list of nodes received from the watch yet", s.Name)
+	}
+	if sink == "" {
+		return nil
+	}
+	h, ok := s.health[sink]
+	if !ok || h.LastSuccess.IsZero() {
+		if ok && h.LastError != "" {
+			return fmt.Errorf("store %s: sink %s hasn't updated a record successfully yet: %s", s.Name, sink, h.LastError)
+		}
+		return fmt.Errorf("store %s: sink %s hasn't updated a record successfully yet", s.Name, sink)
+	}
+	return nil
+}
+
+// Synced returns true once the store's sinks have been notified of a full list of its nodes, and
+// of the nodes of every cluster that Cluster has returned a view of (with Async, once their updates
+// are queued).  Until then, the sinks that publish a record per node or group may not have seen
+// every node, so records that they don't publish yet aren't known to be orphans.
+func (s *NodeStore) Synced() bool {
+	s.Lock()
+	defer s.Unlock()
+	if !s.synced[""] {
+		return false
+	}
+	for cluster := range s.clusters {
+		if !s.synced[cluster] {
+			return false
+		}
+	}
+	return true
+}
+
+// Live returns an error if the store hasn't started an operation (an event, resync, or retry)
+// for longer than maxAge, or hasn't received an event from its watch for longer than maxAge (or
+// 10 minutes, if that's longer).  Stores that haven't received anything yet are live; Ready
+// reports watches that never start.
+func (s *NodeStore) Live(maxAge time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.lastOp.IsZero() {
+		return nil
+	}
+	now := s.Clock.Now()
+	if age := now.Sub(s.lastOp); age > maxAge {
+		return fmt.Errorf("store %s: nothing reconciled for %v", s.Name, age.Round(time.Second))
+	}
+	eventAge := maxAge
+	if eventAge < minEventAge {
+		eventAge = minEventAge
+	}
+	if s.lastEvent.IsZero() {
+		return nil
+	}
+	if age := now.Sub(s.lastEvent); age > eventAge {
+		return fmt.Errorf("store %s: no events from the node watch for %v", s.Name, age.Round(time.Second))
+	}
+	return nil
+}
+
+// ProbeHandler returns an http.Handler for a Kubernetes readiness or liveness probe.  It responds
+// with 503 Service Unavailable and the error if check returns one.
+func ProbeHandler(check func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := check(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n")) // nolint:errcheck
+	})
+}
@@ -0,0 +1,151 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/clock"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestHealth(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	ns.Logger = l
+	ns.Subscribe(SinkFunc("ok", func(UpdateRequest) error { return nil }), SinkFunc("broken", func(UpdateRequest) error { return errors.New("injected error") }))
+	var failures []string
+	ns.OnFailure = func(ctx context.Context, sink string, kind Kind, n int, err error) {
+		failures = append(failures, fmt.Sprintf("%s %d %v", sink, n, err))
+	}
+	ns.Add(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "host-1"},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "10.0.0.1"},
+				{Type: v1.NodeExternalIP, Address: "1.2.3.4"},
+			},
+		},
+	})
+	if diff := cmp.Diff(failures, []string{"broken 1 injected error", "broken 2 injected error"}); diff != "" {
+		t.Errorf("reported failures:\n%s", diff)
+	}
+
+	type sink struct {
+		Sink                string `json:"sink"`
+		LastError           string `json:"last_error"`
+		ConsecutiveFailures int    `json:"consecutive_failures"`
+	}
+	type response struct {
+		Healthy bool   `json:"healthy"`
+		Sinks   []sink `json:"sinks"`
+	}
+	testData := []struct {
+		name        string
+		maxFailures int
+		wantStatus  int
+	}{
+		{name: "below threshold", maxFailures: 3, wantStatus: http.StatusOK},
+		{name: "at threshold", maxFailures: 2, wantStatus: http.StatusServiceUnavailable},
+		{name: "disabled", maxFailures: 0, wantStatus: http.StatusOK},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			HealthHandler([]*NodeStore{ns}, test.maxFailures).ServeHTTP(rec, httptest.NewRequest("GET", "/healthz/sinks", nil))
+			if got, want := rec.Code, test.wantStatus; got != want {
+				t.Errorf("status:\n  got: %v\n want: %v", got, want)
+			}
+			var got response
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			want := response{
+				Healthy: test.wantStatus == http.StatusOK,
+				Sinks: []sink{
+					{Sink: "broken", LastError: "injected error", ConsecutiveFailures: 2},
+					{Sink: "ok"},
+				},
+			}
+			if diff := cmp.Diff(got, want); diff != "" {
+				t.Errorf("health:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProbes(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	fake := clock.NewFake(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	ns := NewNodeStore("test")
+	ns.Logger, ns.Clock = l, fake
+	var dnsErr error
+	ns.Subscribe(SinkFunc("dns", func(UpdateRequest) error { return dnsErr }))
+	probe := func(check func() error) (int, string) {
+		rec := httptest.NewRecorder()
+		ProbeHandler(check).ServeHTTP(rec, httptest.NewRequest("GET", "/healthz/ready", nil))
+		return rec.Code, strings.TrimSpace(rec.Body.String())
+	}
+	ready := func() error { return ns.Ready("dns") }
+	live := func() error { return ns.Live(time.Minute) }
+
+	check := func(name string, check func() error, wantCode int, wantBody string) {
+		t.Helper()
+		code, body := probe(check)
+		if got, want := code, wantCode; got != want {
+			t.Errorf("%s: status:\n  got: %v\n want: %v", name, got, want)
+		}
+		if got, want := body, wantBody; got != want {
+			t.Errorf("%s: body:\n  got: %v\n want: %v", name, got, want)
+		}
+	}
+	check("ready before the watch starts", ready, http.StatusServiceUnavailable, "store test: no list of nodes received from the watch yet")
+	check("live before the watch starts", live, http.StatusOK, "ok")
+
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "host-1"},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+			Addresses:  []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "1.2.3.4"}},
+		},
+	}
+	dnsErr = errors.New("invalid token")
+	ns.Add(node)
+	check("ready before the first list", ready, http.StatusServiceUnavailable, "store test: no list of nodes received from the watch yet")
+	ns.Replace([]interface{}{node}, "")
+	check("ready after a failed update", ready, http.StatusServiceUnavailable, "store test: sink dns hasn't updated a record successfully yet: invalid token")
+	if err := ns.Ready(""); err != nil {
+		t.Errorf("ready without a sink: %v", err)
+	}
+
+	dnsErr = nil
+	ns.Resync()
+	check("ready after a successful update", ready, http.StatusOK, "ok")
+	dnsErr = errors.New("temporary failure")
+	ns.Resync()
+	check("ready after a later failure", ready, http.StatusOK, "ok")
+
+	fake.Advance(2 * time.Minute)
+	check("live without reconciles", live, http.StatusServiceUnavailable, "store test: nothing reconciled for 2m0s")
+	ns.Resync()
+	check("live after a resync", live, http.StatusOK, "ok")
+	fake.Advance(30 * time.Second)
+	ns.Resync()
+	fake.Advance(9 * time.Minute)
+	ns.Resync()
+	check("live without events", live, http.StatusServiceUnavailable, "store test: no events from the node watch for 11m30s")
+	ns.Update(node)
+	check("live after an event", live, http.StatusOK, "ok")
+}
@@ -7,20 +7,27 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"regexp"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/google/go-cmp/cmp"
-	"github.com/jrockway/opinionated-server/client"
-	"github.com/opentracing/opentracing-go"
-	"github.com/opentracing/opentracing-go/ext"
+	"github.com/jrockway/nodedns/pkg/clock"
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"github.com/jrockway/nodedns/pkg/tracing"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 )
@@ -47,86 +54,635 @@ var (
 		},
 		[]string{"store"},
 	)
+	recordRetries = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "record_update_retries",
+			Help: "The number of times that a record was retried because its last update failed, by store, sink, and kind.",
+		},
+		[]string{"store", "sink", "kind"},
+	)
+	recordRetriesExhausted = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "record_update_retries_exhausted",
+			Help: "The number of times that a record's update failed RetryLimit times in a row, and was left for the next change or resync, by store, sink, and kind.",
+		},
+		[]string{"store", "sink", "kind"},
+	)
+	recordsPending = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "record_updates_pending",
+			Help: "The number of records whose last update failed, by store; each sink's updates are counted separately.",
+		},
+		[]string{"store"},
+	)
+)
+
+// Kind is the class of address that a Record contains.
+type Kind string
+
+const (
+	Internal Kind = "internal" // Addresses reachable from inside the cluster's network.
+	External Kind = "external" // Addresses reachable from the Internet.
+	Overlay  Kind = "overlay"  // Addresses on an overlay network like Tailscale or WireGuard.
 )
 
 // Record is a DNS record that contains the full set of nodes.
 type Record struct {
-	IsInternal bool // Whether this record contains internal IPs or external IPs.
-	IPs        []net.IP
+	Kind Kind // Which class of addresses this record contains.
+	IPs  []net.IP
 }
 
 // UpdateRequest is a request to change a DNS address.
 type UpdateRequest struct {
-	Ctx    context.Context
-	Record Record
+	Ctx     context.Context
+	Record  Record
+	Nodes   []Node // Every node that currently has addresses to publish, sorted by name.
+	Trigger string // The store operation that caused the update, like "add", "delete", or "resync".
+}
+
+// triggerKey is the context key of the name of the store operation in progress.
+type triggerKey struct{}
+
+// causeKey is the context key of the Cause of an update.
+type causeKey struct{}
+
+// Cause is what led to an update, for sinks that only pass the update's context on, like to a DNS
+// provider.
+type Cause struct {
+	Trigger string
+	Kind    Kind
+	Nodes   []Node // Every node that had addresses to publish when the update started.
+}
+
+// CauseFromContext returns the cause of the update whose context ctx is, or is derived from.
+func CauseFromContext(ctx context.Context) (Cause, bool) {
+	c, ok := ctx.Value(causeKey{}).(Cause)
+	return c, ok
+}
+
+// NodesWith returns the nodes that contribute addr to the record, as "name", or "cluster/name"
+// for nodes in other clusters.
+func (c Cause) NodesWith(addr string) []string {
+	var result []string
+	for _, n := range c.Nodes {
+		for _, ip := range n.Addresses(c.Kind) {
+			if ip.String() == addr {
+				result = append(result, n.key())
+				break
+			}
+		}
+	}
+	return result
 }
 
 // Node contains Address information about Kubernetes nodes.
 type Node struct {
-	Name     string
-	Internal []net.IP
-	External []net.IP
+	Name       string
+	Cluster    string // The cluster that the node is in, if it isn't the store's own; see Cluster.
+	ProviderID string // The cloud provider's ID for the node, like digitalocean://1234.
+	Internal   []net.IP
+	External   []net.IP
+	Overlay    []net.IP
+	Spot       bool              // Whether the node is a spot or preemptible instance.
+	Zone       string            // The node's topology zone, like us-east-1a; empty if it isn't labeled.
+	Region     string            // The node's topology region, like us-east-1; empty if it isn't labeled.
+	Pinned     map[Kind][]net.IP // Addresses published even if the node isn't ready; see PinAnnotation.
+	Excluded   ExclusionReason   // Why the node's own addresses aren't published; empty if they are.
+}
+
+// ExclusionReason is why a node's own addresses aren't published, for status pages and logs.
+// Besides the constants, nodes with one of the ExcludeConditions are excluded with a reason like
+// "DiskPressure is true", and ineligible nodes with the reason that Eligible returns.
+type ExclusionReason string
+
+// The reasons that NodeStore excludes nodes for.
+const (
+	ExcludedNotListed     ExclusionReason = "name not listed"
+	ExcludedName          ExclusionReason = "name excluded"
+	ExcludedFromLB        ExclusionReason = "excluded from external load balancers"
+	ExcludedByAnnotation  ExclusionReason = "excluded by annotation"
+	ExcludedUnschedulable ExclusionReason = "marked unschedulable"
+	ExcludedToBeDeleted   ExclusionReason = "being deleted by cluster-autoscaler"
+	ExcludedNotReady      ExclusionReason = "not ready"
+	ExcludedNetwork       ExclusionReason = "network unavailable"
+)
+
+// Addresses returns the node's addresses of a kind, including pinned ones.
+func (n Node) Addresses(kind Kind) []net.IP {
+	var result []net.IP
+	switch kind {
+	case Internal:
+		result = append(result, n.Internal...)
+	case External:
+		result = append(result, n.External...)
+	case Overlay:
+		result = append(result, n.Overlay...)
+	}
+	return append(result, n.Pinned[kind]...)
+}
+
+// key returns the name that the node is tracked under; nodes in other clusters may have the same
+// names as the store's own.
+func (n Node) key() string {
+	if n.Cluster == "" {
+		return n.Name
+	}
+	return n.Cluster + "/" + n.Name
 }
 
 // NodeStore is a cache.Store that maintains the full set of nodes, and notifies interested parties
 // of changes.
 type NodeStore struct {
 	sync.Mutex
-	Name     string              // The name of the NodeStore, for observability (logging, metrics, tracing).
-	Timeout  time.Duration       // How long to block (worst case) on events.
-	OnChange func(UpdateRequest) // A function that will be called whenever DNS records change.
+	Name     string                    // The name of the NodeStore, for observability (logging, metrics, tracing).
+	Timeout  time.Duration             // How long to block (worst case) on events.
+	OnChange func(UpdateRequest) error // A function that will be called whenever DNS records change; see also Subscribe.
 	Logger   *zap.Logger
-	nodes    map[string]Node // The nodes, a map from hostname to information about that host.
+	Clock    clock.Clock // Schedules retries and timestamps sink health; tests may replace it.
+
+	// UpdateTimeout, if non-zero, gives each OnChange call its own deadline, instead of sharing
+	// the event's Timeout with every other record that the event changed.  Updating DNS can
+	// take much longer than maintaining the store.
+	UpdateTimeout time.Duration
+
+	// RetryMin and RetryMax bound the exponential backoff between retries of records that a
+	// sink (or OnChange) failed to update.  A failed record is retried, with its current
+	// contents, until the sink succeeds; a new change to the record resets the backoff.  If RetryMin is zero,
+	// failed records aren't retried until the next resync.
+	RetryMin time.Duration
+	RetryMax time.Duration
+
+	// RetryLimit, if positive, is how many times in a row a failed record is retried before
+	// it's left for the next change to the record or the next resync.
+	RetryLimit int
+
+	// ConcurrentUpdates, if true, sends each record that an event changes to each sink in its
+	// own goroutine, so that a slow update of one record doesn't hold up the others.  Sinks
+	// must then be safe for concurrent use.  Either way, updates of the same record by the
+	// same sink are serialized, and each is sent the record's contents when it starts.
+	ConcurrentUpdates bool
+
+	// Async, if true, updates sinks in the background instead of while handling the event that
+	// changed the records, so that a slow or hanging sink never holds up the watch.  Each
+	// record is updated by each sink in its own goroutine, so sinks must be safe for concurrent
+	// use.  Changes that arrive while a record is being updated are coalesced into one more
+	// update with the record's latest contents.
+	Async bool
+
+	// OverlayNetworks and OverlayAnnotation control detection of overlay addresses.  Node
+	// addresses inside any of OverlayNetworks, and any addresses listed (comma-separated) in
+	// the node annotation named by OverlayAnnotation, are published in the Overlay record
+	// instead of the Internal or External record.  If both are empty, there is no Overlay
+	// record.
+	OverlayNetworks   []*net.IPNet
+	OverlayAnnotation string
+
+	// VIPAnnotations are node annotations that contain (comma-separated) virtual addresses
+	// that float between nodes, as managed by kube-vip or keepalived.  They are published in
+	// the VIPKind record (External, if empty), in addition to the node's own addresses of that kind, or, if
+	// VIPReplace is true, instead of them.
+	VIPAnnotations []string
+	VIPKind        Kind
+	VIPReplace     bool
+
+	// AddressAnnotation names the node annotations that override the addresses that a node
+	// reports, for nodes behind 1:1 NAT whose reported addresses aren't the ones clients should
+	// use.  "{kind}" is replaced with internal, external, or overlay, like
+	// "nodedns.jrockway.io/{kind}-ip".  An annotation's (comma-separated) addresses replace
+	// the node's addresses of that kind, or, if the list starts with "+", are added to them.
+	AddressAnnotation string
+
+	// PinAnnotation is a node annotation that contains (comma-separated) addresses that stay in
+	// the records until the node is deleted or terminated, even if it isn't ready, is cordoned,
+	// or is about to be removed by the cluster autoscaler; for legacy endpoints that clients
+	// expect to always be there.  Addresses are pinned in the External record unless prefixed
+	// with a kind, like "internal:10.0.0.5".  Addresses that must outlive any node are pinned
+	// with SetPinned instead.
+	PinAnnotation string
+
+	// ExcludeNames keeps nodes whose names match any of these patterns out of DNS, for clusters
+	// where the nodes to exclude can't be selected by label.
+	ExcludeNames []*regexp.Regexp
+
+	// ExcludeAnnotation is a node annotation that, if "true", keeps the node out of DNS, so that
+	// operators can opt individual nodes out without cordoning them.  Nodes with the
+	// ExcludeFromExternalLBLabel are always left out, like the service controller does.
+	ExcludeAnnotation string
+
+	// Names, if non-empty, are the names of the only nodes that are published; for records
+	// that alias a handpicked set of nodes.
+	Names []string
+
+	// IncludeNetworkUnavailable publishes nodes whose NetworkUnavailable condition is true.  By
+	// default they are left out of DNS, like nodes that aren't Ready.
+	IncludeNetworkUnavailable bool
+
+	// IncludeUnschedulable publishes cordoned nodes, which are left out of DNS by default.
+	IncludeUnschedulable bool
+
+	// ExcludeConditions are node conditions, like MemoryPressure or a condition set by
+	// node-problem-detector, that must be False (or absent) for a node to be published.
+	ExcludeConditions []v1.NodeConditionType
+
+	// Eligible, if non-nil, is consulted after the built-in checks, for policy that they don't
+	// cover.  A non-empty reason leaves the node out of DNS, and is shown as the reason that it's
+	// excluded.  It's called with the store's configuration locked, so it must not call
+	// Reconfigure.
+	Eligible func(n *v1.Node) (reason string)
+
+	// NotReadyGrace keeps a published node that stops being Ready in DNS until its Ready
+	// condition has been false (or unknown) for this long, so that brief kubelet flaps don't
+	// remove it.  Nodes that weren't published when they stopped being Ready aren't affected.
+	NotReadyGrace time.Duration
+
+	// RemovalDelay keeps a published node that's deleted or stops being Ready in DNS for this
+	// long, so that nodes that flap during rolling upgrades don't churn the records.  Nodes are
+	// still added right away, and nodes that are cordoned, excluded, or being terminated are
+	// still removed right away.
+	RemovalDelay time.Duration
+
+	// ExcludeSpotExternal leaves spot and preemptible nodes (see SpotLabels) out of the External
+	// record.  They can disappear with little warning, which clients that cached the record
+	// would notice.
+	ExcludeSpotExternal bool
+
+	// External addresses in private networks (RFC 1918 and RFC 4193), which bare-metal clusters
+	// often report by mistake, are left out of the External record unless AllowPrivateExternal
+	// is true.  ValidateExternal also leaves out external addresses that can't be reached from
+	// the Internet (loopback, link-local, documentation, and other bogon addresses), in case a
+	// node reports garbage in its status.  AnnouncedNetworks, if non-empty, also leaves out
+	// external addresses outside of those networks.  Pinned and discovered public addresses are
+	// published as-is.
+	AllowPrivateExternal bool
+	ValidateExternal     bool
+	AnnouncedNetworks    []*net.IPNet
+
+	// IncludeNetworks, if non-empty, leaves the addresses that nodes report (of every kind)
+	// outside of these networks out of the records, and ExcludeNetworks leaves the addresses
+	// inside of these networks out.  Pinned and discovered public addresses are published as-is.
+	IncludeNetworks []*net.IPNet
+	ExcludeNetworks []*net.IPNet
+
+	// Family, if "ipv4" or "ipv6", leaves addresses of the other family out of every record,
+	// for providers whose routing of the other family is broken, or for single-stack clusters
+	// whose nodes report addresses that don't work.  Empty (or "dual") publishes both.
+	Family string
+
+	// RejectPublicInternal leaves addresses that can be reached from the Internet out of the
+	// Internal record, so that split-horizon setups don't publish public addresses in an
+	// internal zone.
+	RejectPublicInternal bool
+
+	// SampleReconciles, if non-nil, decides whether the trace of each event, resync, and retry
+	// is sampled, instead of the tracer's sampler.
+	SampleReconciles func() bool
+
+	// RecordNames are the fully-qualified names of the DNS records that each kind of record is
+	// published in, for Status.
+	RecordNames map[Kind][]string
+
+	// OnFailure, if non-nil, is called after each failed update with the sink, the kind of
+	// record, and how many times in a row the sink has failed, for reporting errors that need
+	// attention.  Deferred updates aren't failures.
+	OnFailure func(ctx context.Context, sink string, kind Kind, failures int, err error)
+
+	// OnUpdate, if non-nil, is called after each update by each sink with the request and its
+	// result (nil if it succeeded), for reporting the results of updates.  Deferred updates
+	// aren't reported.
+	OnUpdate func(req UpdateRequest, sink string, err error)
+
+	// PendingPath, if set, is a file that the records whose updates haven't succeeded yet are
+	// saved to, so that they're retried after a restart; see LoadPending.
+	PendingPath string
+
+	config    sync.RWMutex                    // Held for writing by Reconfigure, and for reading while nodes are evaluated.
+	nodes     map[string]Node                 // The nodes, by key; see Node.key.
+	publicIPs []net.IP                        // Discovered public addresses, for nodes that have no external address.
+	pinned    map[Kind][]net.IP               // Addresses that are always published; see SetPinned.
+	addresses map[Kind]map[string]*addressRef // The addresses in each record, by kind and address.
+	sorted    map[Kind][]string               // The keys of addresses, in sorted order.
+	exported  []string                        // The keys of nodes with addresses to publish, sorted.
+
+	terminating map[string]bool                // Nodes that are being terminated, and whose addresses aren't published.
+	graced      map[string]*graceTimer         // Nodes that are published only because of NotReadyGrace.
+	removing    map[string]*removalTimer       // Nodes that are published only because of RemovalDelay.
+	sinks       []Sink                         // Subscribers, other than OnChange.
+	retries     map[retryKey]*retry            // Records whose last update failed, by sink and kind.
+	health      map[string]*SinkHealth         // The health of each sink, by name.
+	applied     map[retryKey]*AppliedRecord    // The result of the last update of each record, by sink and kind.
+	updating    map[retryKey]*sync.Mutex       // Held while a sink updates a record.
+	pending     map[retryKey]struct{}          // Records whose last update failed, even if they aren't being retried.
+	restored    map[retryKey]struct{}          // Records that were pending at the last shutdown, until they're retried.
+	reconciling map[retryKey]*backgroundUpdate // Records that are being updated in the background, with Async.
+
+	lastEvent time.Time       // When the last event from the node watch arrived; see EventAge.
+	listed    map[string]bool // The clusters whose nodes have been listed by Replace; see Ready.
+	synced    map[string]bool // The clusters whose listed nodes the sinks have been notified of; see Synced.
+	clusters  map[string]bool // The clusters that Cluster has returned a view of; see Synced.
+	lastOp    time.Time       // When the last event, resync, or retry started; see Live.
+
+	inflight int           // Events (and the updates they started) that are in progress.
+	draining bool          // Set by Drain; failed updates are no longer retried.
+	drained  chan struct{} // Closed when inflight reaches zero while draining.
+}
+
+// retryKey identifies a record that a sink failed to update.
+type retryKey struct {
+	sink string
+	kind Kind
+}
+
+// graceTimer re-evaluates a node when its NotReadyGrace runs out.
+type graceTimer struct {
+	timer   clock.Timer
+	cluster string // The cluster that the node is in.
+}
+
+// removalTimer removes a node, or applies the change that stopped it being published, when its
+// RemovalDelay runs out.
+type removalTimer struct {
+	timer clock.Timer
+	node  *Node // The node's latest state; nil if it was deleted.
+}
+
+// retry is a scheduled retry of a record.
+type retry struct {
+	timer   clock.Timer
+	attempt int
+}
+
+// backgroundUpdate is an update of a record by a sink in the background; see Async.
+type backgroundUpdate struct {
+	dirty   bool         // Whether the record changed after the update started.
+	trigger string       // The store operation that caused the next update.
+	id      string       // The correlation ID of that operation.
+	links   []trace.Link // The traces of the operations that the next update covers.
 }
 
 // NewNodeStore returns an initialized NodeStore.
 func NewNodeStore(name string) *NodeStore {
-	return &NodeStore{Name: name, Timeout: 10 * time.Second, Logger: zap.L().Named(name), nodes: make(map[string]Node)}
+	s := &NodeStore{
+		Name:      name,
+		Timeout:   10 * time.Second,
+		Logger:    zap.L().Named(name),
+		Clock:     clock.Real{},
+		nodes:     make(map[string]Node),
+		addresses: make(map[Kind]map[string]*addressRef),
+		sorted:    make(map[Kind][]string),
+
+		terminating: make(map[string]bool),
+		graced:      make(map[string]*graceTimer),
+		removing:    make(map[string]*removalTimer),
+		retries:     make(map[retryKey]*retry),
+		health:      make(map[string]*SinkHealth),
+		applied:     make(map[retryKey]*AppliedRecord),
+		updating:    make(map[retryKey]*sync.Mutex),
+		pending:     make(map[retryKey]struct{}),
+		reconciling: make(map[retryKey]*backgroundUpdate),
+		listed:      make(map[string]bool),
+		synced:      make(map[string]bool),
+		clusters:    make(map[string]bool),
+	}
+	eventAges.add(s)
+	return s
 }
 
-func (s *NodeStore) startOp(opName string) (context.Context, func()) {
+func (s *NodeStore) startOp(opName string, links ...trace.Link) (context.Context, func()) {
 	nodeChangeEvents.WithLabelValues(s.Name, opName).Inc()
-	tctx, c := context.WithTimeout(context.Background(), s.Timeout)
-	span := opentracing.StartSpan("reflector." + opName)
-	ctx := opentracing.ContextWithSpan(tctx, span)
+	s.Lock()
+	s.lastOp = s.Clock.Now()
+	if watchEvents[opName] {
+		s.lastEvent = s.lastOp
+	}
+	s.inflight++
+	s.Unlock()
+	var tctx context.Context
+	var c context.CancelFunc
+	if s.UpdateTimeout > 0 {
+		// Notifications, the only thing that can block for long, have their own deadlines.
+		tctx, c = context.WithCancel(context.Background())
+	} else {
+		tctx, c = context.WithTimeout(context.Background(), s.Timeout)
+	}
+	// Every update that the operation causes shares its correlation ID, and is part of its trace.
+	id := correlation.New()
+	ctx := correlation.With(context.WithValue(tctx, triggerKey{}, opName), id)
+	if s.SampleReconciles != nil {
+		ctx = tracing.WithSampled(ctx, s.SampleReconciles())
+	}
+	ctx, span := tracing.Start(ctx, "reconcile."+opName, trace.WithLinks(links...), trace.WithAttributes(
+		attribute.String("store", s.Name),
+		attribute.String("correlation_id", id),
+	))
 
 	return ctx, func() {
 		select {
 		case <-ctx.Done():
-			ext.Error.Set(span, true)
-			s.Logger.Error("context expired during notification", zap.String("op", opName), zap.Error(ctx.Err()))
+			tracing.Fail(span, ctx.Err())
+			s.Logger.Error("context expired during notification", zap.String("op", opName), correlation.Field(ctx), zap.Error(ctx.Err()))
 		default:
 		}
 		c()
-		span.Finish()
+		span.End()
+		s.Lock()
+		s.finishOp()
+		s.Unlock()
+	}
+}
+
+// finishOp records that an operation is no longer in progress, for Drain.  The caller must hold
+// the lock.
+func (s *NodeStore) finishOp() {
+	s.inflight--
+	if s.inflight == 0 && s.drained != nil {
+		close(s.drained)
+		s.drained = nil
+	}
+}
+
+// inFamily returns the addresses that belong to the store's Family.
+func (s *NodeStore) inFamily(ips []net.IP) []net.IP {
+	var v4 bool
+	switch s.Family {
+	case "ipv4":
+		v4 = true
+	case "ipv6":
+	default:
+		return ips
+	}
+	var result []net.IP
+	for _, ip := range ips {
+		if (ip.To4() != nil) == v4 {
+			result = append(result, ip)
+		}
+	}
+	return result
+}
+
+func (s *NodeStore) isOverlay(ip net.IP) bool {
+	for _, n := range s.OverlayNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// SpotLabels are the node labels (and values) that cloud providers use to identify spot and
+// preemptible nodes.
+var SpotLabels = map[string]string{
+	"eks.amazonaws.com/capacityType":        "SPOT",
+	"karpenter.sh/capacity-type":            "spot",
+	"cloud.google.com/gke-spot":             "true",
+	"cloud.google.com/gke-preemptible":      "true",
+	"kubernetes.azure.com/scalesetpriority": "spot",
+}
+
+// isSpot returns true if the node's labels identify it as a spot or preemptible instance.
+func isSpot(n *v1.Node) bool {
+	labels := n.GetLabels()
+	for k, v := range SpotLabels {
+		if got, ok := labels[k]; ok && got == v {
+			return true
+		}
+	}
+	return false
+}
+
+// ZoneLabels and RegionLabels are the node labels that name a node's topology zone and region, in
+// order of preference; the second is the deprecated label that older clusters still set.
+var (
+	ZoneLabels   = []string{"topology.kubernetes.io/zone", "failure-domain.beta.kubernetes.io/zone"}
+	RegionLabels = []string{"topology.kubernetes.io/region", "failure-domain.beta.kubernetes.io/region"}
+)
+
+// TopologyLabel returns the value of the first of the labels, like ZoneLabels, that the node has.
+func TopologyLabel(n *v1.Node, labels []string) string {
+	for _, l := range labels {
+		if v := n.GetLabels()[l]; v != "" {
+			return v
+		}
 	}
+	return ""
 }
 
-func toNode(obj interface{}) Node {
+// ExcludeFromExternalLBLabel is the node label that keeps nodes out of cloud load balancers.
+const ExcludeFromExternalLBLabel = "node.kubernetes.io/exclude-from-external-load-balancers"
+
+// ToBeDeletedTaint is the taint that the cluster autoscaler adds to nodes that it's about to remove.
+const ToBeDeletedTaint = "ToBeDeletedByClusterAutoscaler"
+
+// toNode extracts the information that's published about a node.  If the node is only published
+// because it's within its NotReadyGrace, it also returns how long that lasts.
+func (s *NodeStore) toNode(cluster string, obj interface{}) (Node, time.Duration) {
 	n, ok := obj.(*v1.Node)
 	if !ok {
 		// The reflector also does this check, so this should never happen.
 		zap.L().Error("wrong-type object", zap.Any("obj", obj))
-		return Node{}
+		return Node{Cluster: cluster}, 0
+	}
+	// The node's published state is read before the configuration is locked: Reconfigure locks
+	// the store and then the configuration, so locking them the other way around would deadlock.
+	s.Lock()
+	old, published := s.nodes[Node{Name: n.GetName(), Cluster: cluster}.key()]
+	s.Unlock()
+	s.config.RLock()
+	defer s.config.RUnlock()
+	result := Node{Name: n.GetName(), Cluster: cluster, ProviderID: n.Spec.ProviderID, Spot: isSpot(n), Zone: TopologyLabel(n, ZoneLabels), Region: TopologyLabel(n, RegionLabels)}
+
+	if len(s.Names) > 0 {
+		listed := false
+		for _, name := range s.Names {
+			if name == n.GetName() {
+				listed = true
+				break
+			}
+		}
+		if !listed {
+			zap.L().Debug("node not considered for dns, name not listed", zap.String("node", n.GetName()))
+			result.Excluded = ExcludedNotListed
+			return result, 0
+		}
+	}
+	for _, re := range s.ExcludeNames {
+		if re.MatchString(n.GetName()) {
+			zap.L().Debug("node not considered for dns, name excluded", zap.String("node", n.GetName()), zap.Stringer("pattern", re))
+			result.Excluded = ExcludedName
+			return result, 0
+		}
+	}
+	if _, ok := n.GetLabels()[ExcludeFromExternalLBLabel]; ok {
+		zap.L().Debug("node not considered for dns, excluded from external load balancers", zap.String("node", n.GetName()))
+		result.Excluded = ExcludedFromLB
+		return result, 0
+	}
+	if s.ExcludeAnnotation != "" {
+		if v, ok := n.GetAnnotations()[s.ExcludeAnnotation]; ok && strings.EqualFold(strings.TrimSpace(v), "true") {
+			zap.L().Debug("node not considered for dns, excluded by annotation", zap.String("node", n.GetName()), zap.String("annotation", s.ExcludeAnnotation))
+			result.Excluded = ExcludedByAnnotation
+			return result, 0
+		}
+	}
+	// Pinned addresses are published regardless of the node's state.
+	result.Pinned = s.pins(n)
+	for kind, ips := range result.Pinned {
+		result.Pinned[kind] = s.inFamily(ips)
 	}
-	result := Node{Name: n.GetName()}
 
 	// This is a subset of the functionality that k8s normally uses to decide whether to add
 	// nodes to services.  See
 	// https://github.com/kubernetes/kubernetes/blob/master/pkg/controller/service/controller.go#getNodeConditionPredicate.
-	if n.Spec.Unschedulable {
+	if n.Spec.Unschedulable && !s.IncludeUnschedulable {
 		zap.L().Debug("node not considered for dns, marked unschedulable", zap.String("node", n.GetName()))
-		return result
+		result.Excluded = ExcludedUnschedulable
+		return result, 0
+	}
+	for _, taint := range n.Spec.Taints {
+		if taint.Key == ToBeDeletedTaint {
+			// The cluster autoscaler has decided to delete the node; stop sending clients
+			// to it now, rather than when the node object disappears.
+			zap.L().Debug("node not considered for dns, being deleted by cluster-autoscaler", zap.String("node", n.GetName()))
+			result.Excluded = ExcludedToBeDeleted
+			return result, 0
+		}
 	}
+	var grace time.Duration
 	for _, cond := range n.Status.Conditions {
 		if cond.Type == v1.NodeReady && cond.Status != v1.ConditionTrue {
-			zap.L().Debug("node not considered for dns, not ready", zap.String("node", n.GetName()))
-			return result
+			if grace = s.notReadyGrace(old, published, cond); grace <= 0 {
+				zap.L().Debug("node not considered for dns, not ready", zap.String("node", n.GetName()))
+				result.Excluded = ExcludedNotReady
+				return result, 0
+			}
+			zap.L().Debug("node not ready, but still within its grace period", zap.String("node", n.GetName()), zap.Duration("remaining", grace))
+		}
+		if cond.Type == v1.NodeNetworkUnavailable && cond.Status == v1.ConditionTrue && !s.IncludeNetworkUnavailable {
+			zap.L().Debug("node not considered for dns, network unavailable", zap.String("node", n.GetName()))
+			result.Excluded = ExcludedNetwork
+			return result, 0
+		}
+		for _, t := range s.ExcludeConditions {
+			if cond.Type == t && cond.Status != v1.ConditionFalse {
+				zap.L().Debug("node not considered for dns, excluded condition", zap.String("node", n.GetName()), zap.String("condition", string(t)), zap.String("status", string(cond.Status)))
+				result.Excluded = ExclusionReason(string(t) + " is " + strings.ToLower(string(cond.Status)))
+				return result, 0
+			}
+		}
+	}
+	if s.Eligible != nil {
+		if reason := s.Eligible(n); reason != "" {
+			zap.L().Debug("node not considered for dns, not eligible", zap.String("node", n.GetName()), zap.String("reason", reason))
+			result.Excluded = ExclusionReason(reason)
+			return result, 0
 		}
 	}
 
 	for _, addr := range n.Status.Addresses {
 		parsed := net.ParseIP(addr.Address)
+		if parsed != nil && s.isOverlay(parsed) {
+			result.Overlay = append(result.Overlay, parsed)
+			continue
+		}
 		switch addr.Type {
 		case v1.NodeExternalIP:
 			result.External = append(result.External, parsed)
@@ -138,135 +694,788 @@ func toNode(obj interface{}) Node {
 			// We ignore these, but they could be used to generate CNAME records.
 		}
 	}
+	if s.OverlayAnnotation != "" {
+		if ann, ok := n.GetAnnotations()[s.OverlayAnnotation]; ok {
+			for _, addr := range strings.Split(ann, ",") {
+				parsed := net.ParseIP(strings.TrimSpace(addr))
+				if parsed == nil {
+					zap.L().Warn("invalid address in overlay annotation", zap.String("node", n.GetName()), zap.String("address", addr))
+					continue
+				}
+				result.Overlay = append(result.Overlay, parsed)
+			}
+		}
+	}
+	for _, kind := range []Kind{Internal, External, Overlay} {
+		addrs := &result.External
+		switch kind {
+		case Internal:
+			addrs = &result.Internal
+		case Overlay:
+			addrs = &result.Overlay
+		}
+		*addrs = s.overrideAddresses(n, kind, *addrs)
+	}
+	if vips := s.vips(n); len(vips) > 0 {
+		addrs := &result.External
+		switch s.VIPKind {
+		case Internal:
+			addrs = &result.Internal
+		case Overlay:
+			addrs = &result.Overlay
+		}
+		if s.VIPReplace {
+			*addrs = nil
+		}
+		*addrs = append(*addrs, vips...)
+	}
+	result.External = s.validExternal(n.GetName(), s.filterNetworks(n.GetName(), External, s.inFamily(result.External)))
+	result.Internal = s.validInternal(n.GetName(), s.filterNetworks(n.GetName(), Internal, s.inFamily(result.Internal)))
+	result.Overlay = s.filterNetworks(n.GetName(), Overlay, s.inFamily(result.Overlay))
+	if result.Spot && s.ExcludeSpotExternal && len(result.External) > 0 {
+		zap.L().Debug("not publishing external addresses of spot node", zap.String("node", n.GetName()))
+		result.External = nil
+	}
+	return result, grace
+}
+
+// notReadyGrace returns how much longer a node whose Ready condition is cond, and which is currently
+// old (if published), stays published; zero if it shouldn't be.
+func (s *NodeStore) notReadyGrace(old Node, published bool, cond v1.NodeCondition) time.Duration {
+	if s.NotReadyGrace <= 0 || cond.LastTransitionTime.IsZero() {
+		return 0
+	}
+	if !published || old.Excluded != "" {
+		return 0
+	}
+	return s.NotReadyGrace - s.Clock.Now().Sub(cond.LastTransitionTime.Time)
+}
+
+// setGrace schedules obj, the node in the cluster tracked under key, to be re-evaluated after d,
+// replacing any re-evaluation that's already scheduled.  If d isn't positive, nothing is scheduled.
+// The caller must hold the lock.
+func (s *NodeStore) setGrace(cluster, key string, obj interface{}, d time.Duration) {
+	if g, ok := s.graced[key]; ok {
+		g.timer.Stop()
+		delete(s.graced, key)
+	}
+	if d <= 0 || s.draining {
+		return
+	}
+	g := &graceTimer{cluster: cluster}
+	g.timer = s.Clock.AfterFunc(d, func() { s.endGrace(key, obj, g) })
+	s.graced[key] = g
+}
+
+// endGrace re-evaluates a node whose NotReadyGrace has run out, unless it's changed since.
+func (s *NodeStore) endGrace(key string, obj interface{}, g *graceTimer) {
+	ctx, c := s.startOp("grace")
+	defer c()
+	node, grace := s.toNode(g.cluster, obj)
+	changes := s.mutateNodes(func(m mutation) {
+		if s.graced[key] != g {
+			return
+		}
+		s.setNode(m, key, &node)
+		s.setGrace(g.cluster, key, obj, grace)
+	})
+	s.notify(ctx, changes)
+}
+
+// overrideAddresses returns the node's addresses of the kind, as overridden by its address
+// annotation; see AddressAnnotation.  An annotation with an invalid address is ignored.
+func (s *NodeStore) overrideAddresses(n *v1.Node, kind Kind, ips []net.IP) []net.IP {
+	if s.AddressAnnotation == "" {
+		return ips
+	}
+	name := strings.ReplaceAll(s.AddressAnnotation, "{kind}", string(kind))
+	ann, ok := n.GetAnnotations()[name]
+	if !ok {
+		return ips
+	}
+	ann = strings.TrimSpace(ann)
+	add := strings.HasPrefix(ann, "+")
+	var result []net.IP
+	for _, addr := range strings.Split(strings.TrimPrefix(ann, "+"), ",") {
+		parsed := net.ParseIP(strings.TrimSpace(addr))
+		if parsed == nil {
+			zap.L().Warn("invalid address in address annotation; ignoring it", zap.String("node", n.GetName()), zap.String("annotation", name), zap.String("address", addr))
+			return ips
+		}
+		result = append(result, parsed)
+	}
+	if add {
+		return append(ips, result...)
+	}
 	return result
 }
 
-func (s *NodeStore) externalRecord() Record {
-	result := Record{IsInternal: false}
-	for _, node := range s.nodes {
-		result.IPs = append(result.IPs, node.External...)
+// vips returns the virtual addresses in the node's VIP annotations.
+func (s *NodeStore) vips(n *v1.Node) []net.IP {
+	var result []net.IP
+	for _, name := range s.VIPAnnotations {
+		ann, ok := n.GetAnnotations()[name]
+		if !ok {
+			continue
+		}
+		for _, addr := range strings.Split(ann, ",") {
+			addr = strings.TrimSpace(addr)
+			if addr == "" {
+				continue
+			}
+			parsed := net.ParseIP(addr)
+			if parsed == nil {
+				zap.L().Warn("invalid address in vip annotation", zap.String("node", n.GetName()), zap.String("annotation", name), zap.String("address", addr))
+				continue
+			}
+			result = append(result, parsed)
+		}
 	}
-	cleanupRecord(&result)
 	return result
 }
 
-func (s *NodeStore) internalRecord() Record {
-	result := Record{IsInternal: true}
-	for _, node := range s.nodes {
-		result.IPs = append(result.IPs, node.Internal...)
+// kinds returns the kinds of records that this NodeStore maintains.
+func (s *NodeStore) kinds() []Kind {
+	if len(s.OverlayNetworks) > 0 || s.OverlayAnnotation != "" {
+		return []Kind{Internal, External, Overlay}
+	}
+	return []Kind{Internal, External}
+}
+
+func (s *NodeStore) record(kind Kind) Record {
+	set := s.addresses[kind]
+	keys := s.sorted[kind]
+	result := Record{Kind: kind, IPs: make([]net.IP, 0, len(keys))}
+	for _, key := range keys {
+		result.IPs = append(result.IPs, set[key].ip)
 	}
-	cleanupRecord(&result)
 	return result
 }
 
-func cleanupRecord(r *Record) {
-	dedup := make(map[string]net.IP)
-	for _, addr := range r.IPs {
-		dedup[addr.To16().String()] = addr
+// records returns every record that this NodeStore maintains.
+func (s *NodeStore) records() []Record {
+	var result []Record
+	for _, kind := range s.kinds() {
+		result = append(result, s.record(kind))
 	}
-	keys := make([]string, 0, len(dedup))
-	for key := range dedup {
-		keys = append(keys, key)
+	return result
+}
+
+// addressRef is an address in a record, and the number of nodes that contribute it.
+type addressRef struct {
+	ip    net.IP
+	count int
+}
+
+// mutation tracks which addresses a set of changes to the store touched, so that changed records
+// can be found without rebuilding and comparing every record.
+type mutation map[Kind]map[string]bool // Kind -> address -> whether it was in the record before.
+
+// contribute adds (delta > 0) or removes (delta < 0) a node's contribution of ips to a record.
+// The caller must hold the lock.
+func (s *NodeStore) contribute(m mutation, kind Kind, ips []net.IP, delta int) {
+	ips = s.inFamily(ips)
+	set, ok := s.addresses[kind]
+	if !ok {
+		set = make(map[string]*addressRef)
+		s.addresses[kind] = set
 	}
-	sort.Strings(keys)
-	r.IPs = make([]net.IP, 0, len(dedup))
-	for _, key := range keys {
-		r.IPs = append(r.IPs, dedup[key])
+	touched, ok := m[kind]
+	if !ok {
+		touched = make(map[string]bool)
+		m[kind] = touched
+	}
+	for _, ip := range ips {
+		key := ip.String()
+		ref, present := set[key]
+		if _, ok := touched[key]; !ok {
+			touched[key] = present
+		}
+		if !present {
+			ref = &addressRef{ip: ip}
+			set[key] = ref
+			s.sorted[kind] = insertSorted(s.sorted[kind], key)
+		}
+		ref.count += delta
+		if ref.count <= 0 {
+			delete(set, key)
+			s.sorted[kind] = removeSorted(s.sorted[kind], key)
+		}
 	}
 }
 
-func (s *NodeStore) mutateNodes(f func(*map[string]Node)) []Record {
-	s.Lock()
-	defer s.Unlock()
+// insertSorted inserts x into the sorted slice xs.
+func insertSorted(xs []string, x string) []string {
+	i := sort.SearchStrings(xs, x)
+	if i < len(xs) && xs[i] == x {
+		return xs
+	}
+	xs = append(xs, "")
+	copy(xs[i+1:], xs[i:])
+	xs[i] = x
+	return xs
+}
 
-	beforeInternal, beforeExternal := s.externalRecord(), s.internalRecord()
+// removeSorted removes x from the sorted slice xs.
+func removeSorted(xs []string, x string) []string {
+	i := sort.SearchStrings(xs, x)
+	if i == len(xs) || xs[i] != x {
+		return xs
+	}
+	return append(xs[:i], xs[i+1:]...)
+}
 
-	f(&s.nodes)
+// natted returns true if the node is probably behind NAT, and should have the discovered public
+// addresses (if any) published in its place.
+func (s *NodeStore) natted(n Node) bool {
+	if s.terminating[n.key()] {
+		return false
+	}
+	if n.Spot && s.ExcludeSpotExternal {
+		return false
+	}
+	return len(n.External) == 0 && len(n.Internal) > 0
+}
 
-	nodeCount.WithLabelValues(s.Name).Set(float64(len(s.nodes)))
-	var nOk int
-	for _, n := range s.nodes {
-		if len(n.External)+len(n.Internal) > 0 {
-			nOk++
+func exported(n Node) bool {
+	return len(n.External)+len(n.Internal)+len(n.Overlay) > 0
+}
+
+// addNode adds a node's addresses to the records.  The caller must hold the lock.
+func (s *NodeStore) addNode(m mutation, n Node, delta int) {
+	if s.terminating[n.key()] {
+		return
+	}
+	s.contribute(m, Internal, n.Internal, delta)
+	s.contribute(m, External, n.External, delta)
+	if s.natted(n) {
+		s.contribute(m, External, s.publicIPs, delta)
+	}
+	s.contribute(m, Overlay, n.Overlay, delta)
+	for kind, ips := range n.Pinned {
+		s.contribute(m, kind, ips, delta)
+	}
+	if exported(n) {
+		if delta > 0 {
+			s.exported = insertSorted(s.exported, n.key())
+		} else {
+			s.exported = removeSorted(s.exported, n.key())
+		}
+	}
+}
+
+func equalIPs(a, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalNodes(a, b Node) bool {
+	return a.Name == b.Name && a.ProviderID == b.ProviderID && a.Spot == b.Spot && a.Zone == b.Zone && a.Region == b.Region && a.Excluded == b.Excluded && equalIPs(a.Internal, b.Internal) &&
+		equalIPs(a.External, b.External) && equalIPs(a.Overlay, b.Overlay) && equalPins(a.Pinned, b.Pinned)
+}
+
+func equalPins(a, b map[Kind][]net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for kind, ips := range a {
+		if !equalIPs(ips, b[kind]) {
+			return false
+		}
+	}
+	return true
+}
+
+// setNode adds, replaces, or (if node is nil) removes the node tracked under key.  The caller must
+// hold the lock.
+func (s *NodeStore) setNode(m mutation, key string, node *Node) {
+	old, ok := s.nodes[key]
+	if ok && node != nil && equalNodes(old, *node) {
+		return
+	}
+	if ok {
+		s.addNode(m, old, -1)
+		delete(s.nodes, key)
+	}
+	if node == nil {
+		delete(s.terminating, key)
+	}
+	if node != nil {
+		s.nodes[key] = *node
+		s.addNode(m, *node, 1)
+	}
+}
+
+// applyNode is setNode, except that a published node that's being deleted or stopped being Ready
+// stays as it is until its RemovalDelay runs out.  The caller must hold the lock.
+func (s *NodeStore) applyNode(m mutation, key string, node *Node) {
+	r, delayed := s.removing[key]
+	if node == nil || node.Excluded == ExcludedNotReady {
+		if delayed {
+			// Keep the original deadline, but apply the latest state when it's reached.
+			r.node = node
+			return
+		}
+		old, ok := s.nodes[key]
+		if ok && old.Excluded == "" && !s.terminating[key] && s.RemovalDelay > 0 && !s.draining {
+			s.Logger.Info("node stopped being published; keeping it for the removal delay", zap.String("node", key), zap.Duration("delay", s.RemovalDelay))
+			r := &removalTimer{node: node}
+			r.timer = s.Clock.AfterFunc(s.RemovalDelay, func() { s.endRemoval(key, r) })
+			s.removing[key] = r
+			return
 		}
+	} else if delayed {
+		r.timer.Stop()
+		delete(s.removing, key)
 	}
-	nodeExportedCount.WithLabelValues(s.Name).Set(float64(nOk))
+	s.setNode(m, key, node)
+}
+
+// endRemoval applies the change that a node's RemovalDelay held back, unless it's been published
+// again since.
+func (s *NodeStore) endRemoval(key string, r *removalTimer) {
+	ctx, c := s.startOp("removal_delay")
+	defer c()
+	changes := s.mutateNodes(func(m mutation) {
+		if s.removing[key] != r {
+			return
+		}
+		delete(s.removing, key)
+		s.setNode(m, key, r.node)
+	})
+	s.notify(ctx, changes)
+}
 
-	afterInternal, afterExternal := s.externalRecord(), s.internalRecord()
+// changed returns the records that a mutation changed.  The caller must hold the lock.
+func (s *NodeStore) changed(m mutation) []Record {
+	nodeCount.WithLabelValues(s.Name).Set(float64(len(s.nodes)))
+	nodeExportedCount.WithLabelValues(s.Name).Set(float64(len(s.exported)))
 
 	var result []Record
-	if diff := cmp.Diff(beforeExternal, afterExternal); diff != "" {
-		result = append(result, afterExternal)
+	for _, kind := range s.kinds() {
+		set := s.addresses[kind]
+		for key, before := range m[kind] {
+			if _, after := set[key]; after != before {
+				result = append(result, s.record(kind))
+				break
+			}
+		}
+	}
+	return result
+}
+
+// SetPublicIPs sets the public addresses that are published in the external record on behalf of
+// nodes that have internal addresses but no external addresses.  This is for clusters behind NAT,
+// where the public address must be discovered by other means (see package publicip).  Once the
+// store is draining, new addresses are ignored, so that the external record isn't written again
+// after shutdown begins.
+func (s *NodeStore) SetPublicIPs(ips []net.IP) {
+	ctx, c := s.startOp("public_ip")
+	defer c()
+	s.Lock()
+	if s.draining {
+		s.Unlock()
+		return
 	}
-	if diff := cmp.Diff(beforeInternal, afterInternal); diff != "" {
-		result = append(result, afterInternal)
+	m := make(mutation)
+	for _, n := range s.nodes {
+		if s.natted(n) {
+			s.contribute(m, External, s.publicIPs, -1)
+			s.contribute(m, External, ips, 1)
+		}
+	}
+	s.publicIPs = ips
+	changes := s.changed(m)
+	s.Unlock()
+	s.notify(ctx, changes)
+}
+
+// Terminate removes a node's addresses from the records before the node itself is deleted, because
+// something (like Karpenter) has started terminating it.  The node stays out of the records until
+// it's deleted.  Nodes that aren't in the store are ignored, as are nodes in other clusters; see
+// Cluster.
+func (s *NodeStore) Terminate(name string) {
+	ctx, c := s.startOp("terminate")
+	defer c()
+	changes := s.mutateNodes(func(m mutation) {
+		n, ok := s.nodes[name]
+		if !ok || s.terminating[name] {
+			return
+		}
+		s.addNode(m, n, -1)
+		s.terminating[name] = true
+	})
+	s.notify(ctx, changes)
+}
+
+// mutateNodes runs f, which may add or remove nodes with setNode, and returns the records that
+// changed.
+func (s *NodeStore) mutateNodes(f func(m mutation)) []Record {
+	s.Lock()
+	defer s.Unlock()
+	m := make(mutation)
+	f(m)
+	return s.changed(m)
+}
+
+// exportedNodes returns the nodes that have addresses to publish, sorted by key.  The caller must
+// hold the lock.
+func (s *NodeStore) exportedNodes() []Node {
+	result := make([]Node, 0, len(s.exported))
+	for _, key := range s.exported {
+		result = append(result, s.nodes[key])
 	}
 	return result
 }
 
 func (s *NodeStore) notify(ctx context.Context, changes []Record) {
-	opentracing.SpanFromContext(ctx).SetTag("entries.changed", len(changes))
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("entries.changed", len(changes)))
+	if len(changes) == 0 {
+		return
+	}
+	s.Lock()
+	nodes := s.exportedNodes()
+	sinks := s.subscribers()
+	if s.Async {
+		for _, change := range changes {
+			for _, sink := range sinks {
+				s.enqueue(ctx, sink, change.Kind)
+			}
+		}
+		s.Unlock()
+		return
+	}
+	s.Unlock()
+	if !s.ConcurrentUpdates {
+		for _, change := range changes {
+			for _, sink := range sinks {
+				s.update(ctx, sink, UpdateRequest{Record: change, Nodes: nodes})
+			}
+		}
+		return
+	}
+	var wg sync.WaitGroup
 	for _, change := range changes {
-		span, ctx := opentracing.StartSpanFromContext(ctx, "notify_dns")
-		kind := "external"
-		if change.IsInternal {
-			kind = "internal"
+		for _, sink := range sinks {
+			wg.Add(1)
+			go func(sink Sink, change Record) {
+				defer wg.Done()
+				s.update(ctx, sink, UpdateRequest{Record: change, Nodes: nodes})
+			}(sink, change)
+		}
+	}
+	wg.Wait()
+}
+
+// enqueue arranges for the sink to update the record of the provided kind in the background,
+// starting a goroutine to do so unless one is already running.  The caller must hold the lock.
+func (s *NodeStore) enqueue(ctx context.Context, sink Sink, kind Kind) {
+	trigger, _ := ctx.Value(triggerKey{}).(string)
+	key := retryKey{sink: sink.Name(), kind: kind}
+	if r, ok := s.reconciling[key]; ok {
+		r.dirty = true
+		// A resync republishes records even if they didn't change; don't let a later event
+		// hide it from the sink.
+		if r.trigger != "resync" {
+			r.trigger = trigger
 		}
-		span.SetTag("dns.type", kind)
-		s.OnChange(UpdateRequest{Ctx: ctx, Record: change})
-		span.Finish()
+		r.id = correlation.ID(ctx)
+		r.links = append(r.links, trace.LinkFromContext(ctx))
+		return
 	}
+	s.reconciling[key] = &backgroundUpdate{trigger: trigger, id: correlation.ID(ctx), links: []trace.Link{trace.LinkFromContext(ctx)}}
+	// The goroutine counts as an operation in progress until it's done, so that Drain waits
+	// for it.
+	s.inflight++
+	go s.reconcile(sink, kind)
+}
+
+// reconcile updates the sink with the record of the provided kind until the record stops
+// changing.
+func (s *NodeStore) reconcile(sink Sink, kind Kind) {
+	key := retryKey{sink: sink.Name(), kind: kind}
+	for {
+		s.Lock()
+		r := s.reconciling[key]
+		r.dirty = false
+		trigger, id, links := r.trigger, r.id, r.links
+		r.links = nil
+		s.Unlock()
+
+		// The update is its own trace, linked to the traces of the events that it covers.
+		ctx, c := s.startOp("reconcile", links...)
+		ctx = correlation.With(context.WithValue(ctx, triggerKey{}, trigger), id)
+		s.update(ctx, sink, UpdateRequest{Record: Record{Kind: kind}})
+		c()
+
+		s.Lock()
+		if !r.dirty {
+			delete(s.reconciling, key)
+			s.finishOp()
+			s.Unlock()
+			return
+		}
+		s.Unlock()
+	}
+}
+
+// lockRecord waits for any other update of the record by the sink to finish, and returns a
+// function that allows the next one to start.
+func (s *NodeStore) lockRecord(sink Sink, kind Kind) func() {
+	key := retryKey{sink: sink.Name(), kind: kind}
+	s.Lock()
+	mu, ok := s.updating[key]
+	if !ok {
+		mu = new(sync.Mutex)
+		s.updating[key] = mu
+	}
+	s.Unlock()
+	mu.Lock()
+	return mu.Unlock
+}
+
+// update sends a changed record to a sink, and schedules a retry if the sink fails.
+func (s *NodeStore) update(ctx context.Context, sink Sink, req UpdateRequest) {
+	ctx, span := tracing.Start(ctx, "update_sink", trace.WithAttributes(
+		attribute.String("dns.type", string(req.Record.Kind)),
+		attribute.String("sink", sink.Name()),
+		attribute.String("correlation_id", correlation.ID(ctx)),
+	))
+	defer span.End()
+	if s.UpdateTimeout > 0 {
+		var c context.CancelFunc
+		ctx, c = context.WithTimeout(ctx, s.UpdateTimeout)
+		defer c()
+	}
+	req.Ctx = ctx
+	req.Trigger, _ = ctx.Value(triggerKey{}).(string)
+	unlock := s.lockRecord(sink, req.Record.Kind)
+	defer unlock()
+	// Another update may have finished while this one waited; make sure that the sink ends up
+	// with the latest contents.
+	s.Lock()
+	req.Record, req.Nodes = s.record(req.Record.Kind), s.exportedNodes()
+	s.Unlock()
+	req.Ctx = context.WithValue(req.Ctx, causeKey{}, Cause{Trigger: req.Trigger, Kind: req.Record.Kind, Nodes: req.Nodes})
+	err := sink.Update(req)
+	if s.UpdateTimeout > 0 && ctx.Err() != nil {
+		tracing.Fail(span, ctx.Err())
+		s.Logger.Error("context expired during update", zap.String("sink", sink.Name()), zap.String("kind", string(req.Record.Kind)), zap.Duration("timeout", s.UpdateTimeout), correlation.Field(ctx), zap.Error(ctx.Err()))
+	}
+	if errors.Is(err, ErrDeferred) {
+		// Nothing changed and nothing failed; the record is published at the next update or
+		// resync.
+		return
+	}
+	var deferred Deferred
+	if !errors.As(err, &deferred) {
+		failures := s.recordHealth(sink, err)
+		s.recordApplied(sink, req, err)
+		if err != nil && s.OnFailure != nil {
+			s.OnFailure(ctx, sink.Name(), req.Record.Kind, failures, err)
+		}
+		if s.OnUpdate != nil {
+			s.OnUpdate(req, sink.Name(), err)
+		}
+	}
+	s.scheduleRetry(ctx, sink, req.Record.Kind, err)
+}
+
+// scheduleRetry schedules a retry of the sink's update of the record of the provided kind if err,
+// the result of the update, is non-nil, replacing any retry that was already scheduled.  Deferred
+// updates are retried when the sink asked, even if retries are disabled, without backing off.
+func (s *NodeStore) scheduleRetry(ctx context.Context, sink Sink, kind Kind, err error) {
+	s.Lock()
+	defer s.Unlock()
+	key := retryKey{sink: sink.Name(), kind: kind}
+	s.markPending(key, err != nil)
+	r := s.retries[key]
+	if r != nil {
+		r.timer.Stop()
+	}
+	if err == nil || s.draining {
+		delete(s.retries, key)
+		return
+	}
+	var deferred Deferred
+	if errors.As(err, &deferred) {
+		if r == nil {
+			r = new(retry)
+			s.retries[key] = r
+		}
+		wait := deferred.RetryAfter()
+		s.Logger.Info("update deferred", zap.String("sink", sink.Name()), zap.String("kind", string(kind)), zap.Duration("wait", wait), correlation.Field(ctx), zap.Error(err))
+		r.timer = s.Clock.AfterFunc(wait, func() { s.retry(sink, kind) })
+		return
+	}
+	tracing.Fail(trace.SpanFromContext(ctx), err)
+	if s.RetryMin <= 0 {
+		return
+	}
+	if r == nil {
+		r = new(retry)
+		s.retries[key] = r
+	}
+	wait := s.RetryMin
+	for i := 0; i < r.attempt && (s.RetryMax <= 0 || wait < s.RetryMax); i++ {
+		wait *= 2
+	}
+	if s.RetryMax > 0 && wait > s.RetryMax {
+		wait = s.RetryMax
+	}
+	if s.RetryLimit > 0 && r.attempt >= s.RetryLimit {
+		s.Logger.Warn("update failed; giving up until the record changes or is resynced", zap.String("sink", sink.Name()), zap.String("kind", string(kind)), zap.Int("attempts", r.attempt), correlation.Field(ctx), zap.Error(err))
+		recordRetriesExhausted.WithLabelValues(s.Name, sink.Name(), string(kind)).Inc()
+		delete(s.retries, key)
+		return
+	}
+	r.attempt++
+	s.Logger.Info("update failed; retrying", zap.String("sink", sink.Name()), zap.String("kind", string(kind)), zap.Int("attempt", r.attempt), zap.Duration("wait", wait), correlation.Field(ctx), zap.Error(err))
+	r.timer = s.Clock.AfterFunc(wait, func() { s.retry(sink, kind) })
+}
+
+// retry updates the sink with the current contents of the record of the provided kind.
+func (s *NodeStore) retry(sink Sink, kind Kind) {
+	ctx, c := s.startOp("retry")
+	defer c()
+	recordRetries.WithLabelValues(s.Name, sink.Name(), string(kind)).Inc()
+	s.Lock()
+	req := UpdateRequest{Record: s.record(kind), Nodes: s.exportedNodes()}
+	s.Unlock()
+	s.update(ctx, sink, req)
 }
 
 // Add implements cache.Store.
 func (s *NodeStore) Add(obj interface{}) error {
-	ctx, c := s.startOp("add")
-	defer c()
-	node := toNode(obj)
-	changes := s.mutateNodes(func(nodes *map[string]Node) {
-		(*nodes)[node.Name] = node
-	})
-	s.notify(ctx, changes)
-	return nil
+	return s.set("", "add", obj)
 }
 
 // Update implements cache.Store.
 func (s *NodeStore) Update(obj interface{}) error {
-	ctx, c := s.startOp("update")
+	return s.set("", "update", obj)
+}
+
+// Delete implements cache.Store.
+func (s *NodeStore) Delete(obj interface{}) error {
+	return s.delete("", obj)
+}
+
+// Replace implements cache.Store.
+func (s *NodeStore) Replace(objs []interface{}, unusedResourceVersion string) error {
+	return s.replace("", objs)
+}
+
+// asNode returns obj as a node.  Objects that aren't nodes, or that have no name, are logged and
+// ignored, rather than being published as a node without a name.
+func asNode(obj interface{}) (*v1.Node, bool) {
+	n, ok := obj.(*v1.Node)
+	if !ok || n.GetName() == "" {
+		zap.L().Error("ignoring malformed node object", zap.Any("obj", obj))
+		return nil, false
+	}
+	return n, true
+}
+
+// deletedNodeName returns the name of a deleted node.  When the watch missed the deletion, the
+// reflector delivers a cache.DeletedFinalStateUnknown whose object may be stale, or not a node at
+// all; its key is the node's name, since nodes aren't namespaced.
+func deletedNodeName(obj interface{}) (string, bool) {
+	tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+	if !ok {
+		n, ok := asNode(obj)
+		if !ok {
+			return "", false
+		}
+		return n.GetName(), true
+	}
+	if n, ok := tombstone.Obj.(*v1.Node); ok && n.GetName() != "" {
+		return n.GetName(), true
+	}
+	_, name, err := cache.SplitMetaNamespaceKey(tombstone.Key)
+	if err != nil || name == "" {
+		zap.L().Error("ignoring malformed node tombstone", zap.String("key", tombstone.Key), zap.Any("obj", tombstone.Obj), zap.Error(err))
+		return "", false
+	}
+	return name, true
+}
+
+// set adds or updates a node in the cluster.
+func (s *NodeStore) set(cluster, op string, obj interface{}) error {
+	if _, ok := asNode(obj); !ok {
+		return nil
+	}
+	ctx, c := s.startOp(op)
 	defer c()
-	node := toNode(obj)
-	changes := s.mutateNodes(func(nodes *map[string]Node) {
-		(*nodes)[node.Name] = node
+	node, grace := s.toNode(cluster, obj)
+	changes := s.mutateNodes(func(m mutation) {
+		s.applyNode(m, node.key(), &node)
+		s.setGrace(cluster, node.key(), obj, grace)
 	})
 	s.notify(ctx, changes)
 	return nil
 }
 
-// Delete implements cache.Store.
-func (s *NodeStore) Delete(obj interface{}) error {
+// delete removes a node in the cluster.  Nodes are found by name, so that deletions are applied
+// even when the object is a tombstone.
+func (s *NodeStore) delete(cluster string, obj interface{}) error {
+	name, ok := deletedNodeName(obj)
+	if !ok {
+		return nil
+	}
 	ctx, c := s.startOp("delete")
 	defer c()
-	node := toNode(obj)
-	changes := s.mutateNodes(func(nodes *map[string]Node) {
-		delete(*nodes, node.Name)
+	key := Node{Name: name, Cluster: cluster}.key()
+	changes := s.mutateNodes(func(m mutation) {
+		s.applyNode(m, key, nil)
+		s.setGrace(cluster, key, nil, 0)
 	})
 	s.notify(ctx, changes)
 	return nil
 }
 
-// Replace implements cache.Store.
-func (s *NodeStore) Replace(objs []interface{}, unusedResourceVersion string) error {
+// replace replaces every node in the cluster, leaving the nodes of other clusters alone.
+func (s *NodeStore) replace(cluster string, objs []interface{}) error {
 	ctx, c := s.startOp("replace")
 	defer c()
-	changes := s.mutateNodes(func(nodes *map[string]Node) {
-		newNodes := make(map[string]Node)
-		for _, obj := range objs {
-			node := toNode(obj)
-			newNodes[node.Name] = node
+	newNodes := make(map[string]Node, len(objs))
+	graces := make(map[string]time.Duration, len(objs))
+	newObjs := make(map[string]interface{}, len(objs))
+	for _, obj := range objs {
+		if _, ok := asNode(obj); !ok {
+			continue
+		}
+		node, grace := s.toNode(cluster, obj)
+		key := node.key()
+		newNodes[key], graces[key], newObjs[key] = node, grace, obj
+	}
+	changes := s.mutateNodes(func(m mutation) {
+		for key, n := range s.nodes {
+			if _, ok := newNodes[key]; !ok && n.Cluster == cluster {
+				s.applyNode(m, key, nil)
+				s.setGrace(cluster, key, nil, 0)
+			}
+		}
+		for key := range newNodes {
+			node := newNodes[key]
+			s.applyNode(m, key, &node)
+			s.setGrace(cluster, key, newObjs[key], graces[key])
 		}
-		*nodes = newNodes
 	})
+	s.Lock()
+	s.listed[cluster] = true
+	s.Unlock()
 	s.notify(ctx, changes)
+	s.Lock()
+	s.synced[cluster] = true
+	s.Unlock()
+	s.retryRestored(ctx)
 	return nil
 }
 
@@ -274,11 +1483,35 @@ func (s *NodeStore) Replace(objs []interface{}, unusedResourceVersion string) er
 func (s *NodeStore) Resync() error {
 	ctx, c := s.startOp("resync")
 	defer c()
-	ext, int := s.externalRecord(), s.internalRecord()
-	s.notify(ctx, []Record{ext, int})
+	s.Lock()
+	records := s.records()
+	s.Unlock()
+	s.notify(ctx, records)
+	s.retryRestored(ctx)
 	return nil
 }
 
+// Reconfigure calls f, which changes the store's configuration (like Names, OverlayNetworks, or
+// RecordNames), while no node is being evaluated, and then resyncs, so that every record is
+// published with the new configuration right away.  Nodes that the store already has are only
+// re-evaluated when they change, or when the watch lists them again.
+func (s *NodeStore) Reconfigure(f func()) error {
+	s.Lock()
+	s.config.Lock()
+	f()
+	s.config.Unlock()
+	s.Unlock()
+	return s.Resync()
+}
+
+// PublishedIn returns the fully-qualified names of the DNS records that the kind of record is
+// published in; see RecordNames.
+func (s *NodeStore) PublishedIn(kind Kind) []string {
+	s.Lock()
+	defer s.Unlock()
+	return s.RecordNames[kind]
+}
+
 // We only implement cache.Store for cache.Reflector, and cache.Reflector does not call List/Get methods.
 func (s *NodeStore) List() []interface{} { return nil }
 func (s *NodeStore) ListKeys() []string  { return nil }
@@ -289,27 +1522,126 @@ func (s *NodeStore) GetByKey(key string) (item interface{}, exists bool, err err
 	return nil, false, errors.New("unimplemented")
 }
 
+// restConfig builds a client configuration, using an in-cluster configuration if kubeconfig and
+// master are empty.
+func restConfig(master, kubeconfig string) (*rest.Config, error) {
+	config, err := clientcmd.BuildConfigFromFlags(master, kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes: build config: %w", err)
+	}
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		return tracing.WrapRoundTripper(rt)
+	}
+	return config, nil
+}
+
+// Clientset returns a client for the k8s API server, using an in-cluster configuration if
+// kubeconfig and master are empty.
+func Clientset(master, kubeconfig string) (kubernetes.Interface, error) {
+	config, err := restConfig(master, kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes: new client: %w", err)
+	}
+	return clientset, nil
+}
+
 // WatchNodes connects to the k8s API server (using an in-cluster configuration if kubconfig and
 // master are empty), watches nodes until the provided context is finished, and publishes any
-// changes to the provided cache.Store.
+// changes to the provided cache.Store.  If selector is non-empty, only nodes matching that label
+// selector are watched.
 //
 // The provided watcher will be resync'd at a scheduled interval regardless of any changes if
 // resync is non-zero.
-func WatchNodes(ctx context.Context, master, kubeconfig string, resync time.Duration, store cache.Store) error {
-	config, err := clientcmd.BuildConfigFromFlags(master, kubeconfig)
+//
+// If the watch fails, it's restarted with exponential backoff, so WatchNodes only returns an error
+// if the configuration is invalid.
+func WatchNodes(ctx context.Context, master, kubeconfig, selector string, resync time.Duration, store cache.Store) error {
+	config, err := restConfig(master, kubeconfig)
 	if err != nil {
-		return fmt.Errorf("kubernetes: build config: %w", err)
+		return err
 	}
-	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
-		return client.WrapRoundTripper(rt)
+	return WatchNodesWithConfig(ctx, config, selector, resync, store)
+}
+
+// WatchNodesWithConfig is like WatchNodes, but connects to the API server described by config.
+func WatchNodesWithConfig(ctx context.Context, config *rest.Config, selector string, resync time.Duration, store cache.Store) error {
+	return watchNodes(ctx, "nodes", clientForConfig(config), selector, fields.Everything(), resync, store)
+}
+
+// WatchNodesWithClient is like WatchNodes, but watches with the provided client, like the fake
+// clientset in tests.
+func WatchNodesWithClient(ctx context.Context, client kubernetes.Interface, selector string, resync time.Duration, store cache.Store) error {
+	return watchNodes(ctx, "nodes", func() (kubernetes.Interface, error) { return client, nil }, selector, fields.Everything(), resync, store)
+}
+
+// WatchNode is like WatchNodes, but only watches the node with the provided name.
+func WatchNode(ctx context.Context, master, kubeconfig, name string, resync time.Duration, store cache.Store) error {
+	config, err := restConfig(master, kubeconfig)
+	if err != nil {
+		return err
 	}
-	clientset, err := kubernetes.NewForConfig(config)
+	return WatchNodeWithConfig(ctx, config, name, resync, store)
+}
+
+// WatchNodeWithConfig is like WatchNode, but connects to the API server described by config.
+func WatchNodeWithConfig(ctx context.Context, config *rest.Config, name string, resync time.Duration, store cache.Store) error {
+	return watchNodes(ctx, "node", clientForConfig(config), "", fields.OneTermEqualSelector("metadata.name", name), resync, store)
+}
+
+// NodeNames returns the names of every node in the cluster, sorted.
+func NodeNames(ctx context.Context, client kubernetes.Interface) ([]string, error) {
+	nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
 	if err != nil {
-		return fmt.Errorf("kubernetes: new client: %w", err)
+		return nil, fmt.Errorf("kubernetes: list nodes: %w", err)
+	}
+	var result []string
+	for _, n := range nodes.Items {
+		result = append(result, n.Name)
 	}
+	sort.Strings(result)
+	return result, nil
+}
 
-	lw := cache.NewListWatchFromClient(clientset.CoreV1().RESTClient(), "nodes", "", fields.Everything())
-	r := cache.NewReflector(lw, &v1.Node{}, store, resync)
-	r.Run(ctx.Done())
+// clientForConfig returns a function that connects to the API server described by config.
+func clientForConfig(config *rest.Config) func() (kubernetes.Interface, error) {
+	return func() (kubernetes.Interface, error) {
+		clientset, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes: new client: %w", err)
+		}
+		return clientset, nil
+	}
+}
+
+// watchNodes runs a reflector that feeds the nodes matching the selectors to store until ctx is
+// done, restarting it (with a new client from newClient, and a fresh list) each time that listing
+// or watching fails.
+func watchNodes(ctx context.Context, what string, newClient func() (kubernetes.Interface, error), selector string, fieldSelector fields.Selector, resync time.Duration, store cache.Store) error {
+	watchSupervisor.run(ctx, what, func(ctx context.Context) error {
+		clientset, err := newClient()
+		if err != nil {
+			return err
+		}
+		nodes := clientset.CoreV1().Nodes()
+		filter := func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fieldSelector.String()
+			opts.LabelSelector = selector
+		}
+		lw := &cache.ListWatch{
+			ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+				filter(&opts)
+				return nodes.List(ctx, opts)
+			},
+			WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+				filter(&opts)
+				return nodes.Watch(ctx, opts)
+			},
+		}
+		return cache.NewReflector(lw, &v1.Node{}, store, resync).ListAndWatch(ctx.Done())
+	})
 	return nil
 }
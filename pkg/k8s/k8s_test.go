@@ -1,15 +1,28 @@
 package k8s
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net"
+	"regexp"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/clock"
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"github.com/jrockway/nodedns/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zaptest"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
 )
 
 func TestCache(t *testing.T) {
@@ -18,7 +31,10 @@ func TestCache(t *testing.T) {
 	ns := NewNodeStore("test")
 	ns.Timeout = time.Second
 	ch := make(chan UpdateRequest)
-	ns.OnChange = func(req UpdateRequest) { ch <- req }
+	ns.OnChange = func(req UpdateRequest) error {
+		ch <- req
+		return nil
+	}
 	readNext := func(n int) []Record {
 		t.Helper()
 		var result []Record
@@ -66,8 +82,8 @@ func TestCache(t *testing.T) {
 	}, "")
 	got := readNext(2)
 	want := []Record{
-		{IsInternal: true, IPs: []net.IP{net.IPv4(10, 0, 0, 1)}},
-		{IsInternal: false, IPs: []net.IP{net.IPv4(42, 0, 0, 1)}},
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 1)}},
+		{Kind: External, IPs: []net.IP{net.IPv4(42, 0, 0, 1)}},
 	}
 	if diff := cmp.Diff(got, want); diff != "" {
 		t.Errorf("replace:\n%s", diff)
@@ -132,7 +148,7 @@ func TestCache(t *testing.T) {
 		},
 	})
 	got = readNext(1)
-	want = []Record{{IsInternal: false, IPs: []net.IP{net.IPv4(42, 0, 0, 123)}}}
+	want = []Record{{Kind: External, IPs: []net.IP{net.IPv4(42, 0, 0, 123)}}}
 	if diff := cmp.Diff(got, want); diff != "" {
 		t.Errorf("update:\n %s", diff)
 	}
@@ -164,8 +180,8 @@ func TestCache(t *testing.T) {
 	})
 	got = readNext(2)
 	want = []Record{
-		{IsInternal: true, IPs: []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)}},
-		{IsInternal: false, IPs: []net.IP{net.IPv4(42, 0, 0, 123), net.IPv4(42, 0, 0, 2)}},
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)}},
+		{Kind: External, IPs: []net.IP{net.IPv4(42, 0, 0, 123), net.IPv4(42, 0, 0, 2)}},
 	}
 	if diff := cmp.Diff(got, want); diff != "" {
 		t.Errorf("update:\n%s", diff)
@@ -194,7 +210,7 @@ func TestCache(t *testing.T) {
 	})
 	got = readNext(1)
 	want = []Record{
-		{IsInternal: false, IPs: []net.IP{net.IPv4(42, 0, 0, 123)}},
+		{Kind: External, IPs: []net.IP{net.IPv4(42, 0, 0, 123)}},
 	}
 	if diff := cmp.Diff(got, want); diff != "" {
 		t.Errorf("update:\n%s", diff)
@@ -207,7 +223,7 @@ func TestCache(t *testing.T) {
 	})
 	got = readNext(1)
 	want = []Record{
-		{IsInternal: true, IPs: []net.IP{net.IPv4(10, 0, 0, 1)}},
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 1)}},
 	}
 	if diff := cmp.Diff(got, want); diff != "" {
 		t.Errorf("delete:\n%s", diff)
@@ -216,10 +232,1158 @@ func TestCache(t *testing.T) {
 	go ns.Resync()
 	got = readNext(2)
 	want = []Record{
-		{IsInternal: false, IPs: []net.IP{net.IPv4(42, 0, 0, 123)}},
-		{IsInternal: true, IPs: []net.IP{net.IPv4(10, 0, 0, 1)}},
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 1)}},
+		{Kind: External, IPs: []net.IP{net.IPv4(42, 0, 0, 123)}},
 	}
 	if diff := cmp.Diff(got, want); diff != "" {
 		t.Errorf("resync:\n%s", diff)
 	}
 }
+
+func TestOverlay(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	_, cgnat, err := net.ParseCIDR("100.64.0.0/10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.OverlayNetworks = []*net.IPNet{cgnat}
+	ns.OverlayAnnotation = "example.com/overlay-ips"
+	var got []Record
+	ns.OnChange = func(req UpdateRequest) error {
+		got = append(got, req.Record)
+		return nil
+	}
+	ns.Add(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "host-1",
+			Annotations: map[string]string{
+				"example.com/overlay-ips": "fd7a:115c:a1e0::1, invalid",
+			},
+		},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{
+				{
+					Type:    v1.NodeExternalIP,
+					Address: "42.0.0.1",
+				},
+				{
+					Type:    v1.NodeInternalIP,
+					Address: "10.0.0.1",
+				},
+				{
+					Type:    v1.NodeInternalIP,
+					Address: "100.100.0.1",
+				},
+			},
+		},
+	})
+	want := []Record{
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 1)}},
+		{Kind: External, IPs: []net.IP{net.IPv4(42, 0, 0, 1)}},
+		{Kind: Overlay, IPs: []net.IP{net.IPv4(100, 100, 0, 1), net.ParseIP("fd7a:115c:a1e0::1")}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("add:\n%s", diff)
+	}
+}
+
+func TestPublicIPs(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	var got []Record
+	ns.OnChange = func(req UpdateRequest) error {
+		got = append(got, req.Record)
+		return nil
+	}
+	ns.Replace([]interface{}{
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "natted"},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.1"}},
+			},
+		},
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "public"},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{
+					{Type: v1.NodeInternalIP, Address: "10.0.0.2"},
+					{Type: v1.NodeExternalIP, Address: "42.0.0.2"},
+				},
+			},
+		},
+	}, "")
+	got = nil
+	ns.SetPublicIPs([]net.IP{net.IPv4(203, 0, 113, 1)})
+	want := []Record{
+		{Kind: External, IPs: []net.IP{net.IPv4(203, 0, 113, 1), net.IPv4(42, 0, 0, 2)}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("set public ips:\n%s", diff)
+	}
+
+	got = nil
+	ns.SetPublicIPs([]net.IP{net.IPv4(203, 0, 113, 1)})
+	if len(got) != 0 {
+		t.Errorf("unchanged public ips: unexpected updates: %v", got)
+	}
+
+	if err := ns.Drain(context.Background()); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	ns.SetPublicIPs([]net.IP{net.IPv4(203, 0, 113, 2)})
+	if len(got) != 0 {
+		t.Errorf("public ips after drain: unexpected updates: %v", got)
+	}
+}
+
+func benchmarkNodes(n int) []interface{} {
+	var result []interface{}
+	for i := 0; i < n; i++ {
+		result = append(result, &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("node-%d", i)},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{
+					{Type: v1.NodeInternalIP, Address: fmt.Sprintf("10.0.%d.%d", i/256, i%256)},
+					{Type: v1.NodeExternalIP, Address: fmt.Sprintf("42.0.%d.%d", i/256, i%256)},
+				},
+			},
+		})
+	}
+	return result
+}
+
+func BenchmarkUpdate(b *testing.B) {
+	zap.ReplaceGlobals(zap.NewNop())
+	ns := NewNodeStore("bench")
+	ns.Logger = zap.NewNop()
+	ns.OnChange = func(UpdateRequest) error {
+		return nil
+	}
+	nodes := benchmarkNodes(5000)
+	if err := ns.Replace(nodes, ""); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Alternate between a no-op update and an address change.
+		node := nodes[i%len(nodes)].(*v1.Node).DeepCopy()
+		if i%2 == 1 {
+			node.Status.Addresses[1].Address = "192.0.2.1"
+		}
+		if err := ns.Update(node); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReplace(b *testing.B) {
+	zap.ReplaceGlobals(zap.NewNop())
+	ns := NewNodeStore("bench")
+	ns.Logger = zap.NewNop()
+	ns.OnChange = func(UpdateRequest) error {
+		return nil
+	}
+	nodes := benchmarkNodes(5000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := ns.Replace(nodes, ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestNetworkUnavailable(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "host-1",
+		},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{
+				{
+					Type:   v1.NodeReady,
+					Status: v1.ConditionTrue,
+				},
+				{
+					Type:   v1.NodeNetworkUnavailable,
+					Status: v1.ConditionTrue,
+				},
+			},
+			Addresses: []v1.NodeAddress{
+				{
+					Type:    v1.NodeInternalIP,
+					Address: "10.0.0.1",
+				},
+			},
+		},
+	}
+
+	testData := []struct {
+		name    string
+		include bool
+		want    []Record
+	}{
+		{
+			name: "excluded by default",
+		},
+		{
+			name:    "included",
+			include: true,
+			want: []Record{
+				{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 1)}},
+			},
+		},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			ns := NewNodeStore("test")
+			ns.IncludeNetworkUnavailable = test.include
+			var got []Record
+			ns.OnChange = func(req UpdateRequest) error {
+				got = append(got, req.Record)
+				return nil
+			}
+			ns.Add(node)
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("add:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestScaleDown(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	var got []Record
+	ns.OnChange = func(req UpdateRequest) error {
+		got = append(got, req.Record)
+		return nil
+	}
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "host-1",
+		},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{
+				{
+					Type:    v1.NodeInternalIP,
+					Address: "10.0.0.1",
+				},
+			},
+		},
+	}
+	ns.Add(node)
+	node = node.DeepCopy()
+	node.Spec.Taints = append(node.Spec.Taints, v1.Taint{
+		Key:    ToBeDeletedTaint,
+		Value:  "1623456789",
+		Effect: v1.TaintEffectNoSchedule,
+	})
+	ns.Update(node)
+	want := []Record{
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 1)}},
+		{Kind: Internal, IPs: []net.IP{}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("taint:\n%s", diff)
+	}
+}
+
+func TestTombstones(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	var got []Record
+	ns.OnChange = func(req UpdateRequest) error {
+		got = append(got, req.Record)
+		return nil
+	}
+	node := func(name, internal string) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: internal}},
+			},
+		}
+	}
+
+	ns.Replace([]interface{}{node("host-1", "10.0.0.1"), node("host-2", "10.0.0.2"), node("host-3", "10.0.0.3")}, "") // nolint:errcheck
+	// Objects that aren't nodes, or have no name, are ignored.
+	ns.Add("host-4")                                             // nolint:errcheck
+	ns.Add(node("", "10.0.0.4"))                                 // nolint:errcheck
+	ns.Delete(node("", "10.0.0.1"))                              // nolint:errcheck
+	ns.Delete(cache.DeletedFinalStateUnknown{Key: "", Obj: nil}) // nolint:errcheck
+	// A tombstone with a stale copy of the node removes it, even though the copy's addresses
+	// are out of date.
+	ns.Delete(cache.DeletedFinalStateUnknown{Key: "host-1", Obj: node("host-1", "10.0.0.100")}) // nolint:errcheck
+	// A tombstone without a node is found by its key.
+	ns.Delete(cache.DeletedFinalStateUnknown{Key: "host-2", Obj: "garbage"}) // nolint:errcheck
+	// After the watch reconnects, the relist drops the node whose deletion was missed.
+	ns.Replace([]interface{}{node("host-4", "10.0.0.4"), "garbage"}, "") // nolint:errcheck
+
+	want := []Record{
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 3)}},
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 3)}},
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 3)}},
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 4)}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("records:\n%s", diff)
+	}
+}
+
+func TestUpdateTimeout(t *testing.T) {
+	testData := []struct {
+		name          string
+		updateTimeout time.Duration
+		want          time.Duration
+	}{
+		{name: "shared", want: 10 * time.Second},
+		{name: "separate", updateTimeout: time.Minute, want: time.Minute},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			l := zaptest.NewLogger(t)
+			zap.ReplaceGlobals(l)
+			ns := NewNodeStore("test")
+			ns.UpdateTimeout = test.updateTimeout
+			var got []time.Duration
+			ns.OnChange = func(req UpdateRequest) error {
+				deadline, ok := req.Ctx.Deadline()
+				if !ok {
+					t.Fatal("update has no deadline")
+				}
+				got = append(got, time.Until(deadline).Round(time.Second))
+				return nil
+			}
+			ns.Add(&v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "host-1"},
+				Status: v1.NodeStatus{
+					Addresses: []v1.NodeAddress{
+						{Type: v1.NodeInternalIP, Address: "10.0.0.1"},
+						{Type: v1.NodeExternalIP, Address: "1.2.3.4"},
+					},
+				},
+			})
+			want := []time.Duration{test.want, test.want}
+			if diff := cmp.Diff(got, want); diff != "" {
+				t.Errorf("deadlines:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEligibility(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	nodes := []*v1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "cordoned"},
+			Spec:       v1.NodeSpec{Unschedulable: true},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.1"}},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "memory-pressure"},
+			Status: v1.NodeStatus{
+				Conditions: []v1.NodeCondition{{Type: v1.NodeMemoryPressure, Status: v1.ConditionUnknown}},
+				Addresses:  []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.2"}},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "healthy"},
+			Status: v1.NodeStatus{
+				Conditions: []v1.NodeCondition{{Type: v1.NodeMemoryPressure, Status: v1.ConditionFalse}},
+				Addresses:  []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.3"}},
+			},
+		},
+	}
+	testData := []struct {
+		name                 string
+		includeUnschedulable bool
+		excludeConditions    []v1.NodeConditionType
+		eligible             func(*v1.Node) string
+		want                 []string
+	}{
+		{
+			name: "defaults",
+			want: []string{"healthy", "memory-pressure"},
+		},
+		{
+			name:                 "include unschedulable",
+			includeUnschedulable: true,
+			want:                 []string{"cordoned", "healthy", "memory-pressure"},
+		},
+		{
+			name:              "exclude memory pressure",
+			excludeConditions: []v1.NodeConditionType{v1.NodeMemoryPressure},
+			want:              []string{"healthy"},
+		},
+		{
+			name: "custom predicate",
+			eligible: func(n *v1.Node) string {
+				if n.Name == "healthy" {
+					return "too healthy"
+				}
+				return ""
+			},
+			want: []string{"memory-pressure"},
+		},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			ns := NewNodeStore("test")
+			ns.IncludeUnschedulable = test.includeUnschedulable
+			ns.ExcludeConditions = test.excludeConditions
+			ns.Eligible = test.eligible
+			var got []string
+			ns.OnChange = func(req UpdateRequest) error {
+				got = nil
+				for _, n := range req.Nodes {
+					got = append(got, n.Name)
+				}
+				return nil
+			}
+			for _, n := range nodes {
+				ns.Add(n)
+			}
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("published nodes:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNotReadyGrace(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	fake := clock.NewFake(time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC))
+	ns.Clock = fake
+	ns.NotReadyGrace = time.Minute
+	var got []Record
+	ns.OnChange = func(req UpdateRequest) error {
+		got = append(got, req.Record)
+		return nil
+	}
+	node := func(name, address string, ready v1.ConditionStatus) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: v1.NodeStatus{
+				Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: ready, LastTransitionTime: metav1.NewTime(fake.Now())}},
+				Addresses:  []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: address}},
+			},
+		}
+	}
+	ns.Add(node("host-1", "10.0.0.1", v1.ConditionTrue))
+	// A node that has never been Ready isn't published, grace period or not.
+	ns.Add(node("host-2", "10.0.0.2", v1.ConditionFalse))
+
+	// A flap that ends within the grace period doesn't change the record.
+	ns.Update(node("host-1", "10.0.0.1", v1.ConditionUnknown))
+	fake.Advance(30 * time.Second)
+	ns.Update(node("host-1", "10.0.0.1", v1.ConditionTrue))
+	fake.Advance(time.Hour)
+
+	// One that outlasts it removes the node when the grace period runs out.
+	ns.Update(node("host-1", "10.0.0.1", v1.ConditionFalse))
+	fake.Advance(59 * time.Second)
+	if got, want := len(got), 1; got != want {
+		t.Fatalf("updates during grace period:\n  got: %v\n want: %v", got, want)
+	}
+	fake.Advance(time.Second)
+	want := []Record{
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 1)}},
+		{Kind: Internal, IPs: []net.IP{}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("updates:\n%s", diff)
+	}
+	if got, want := fake.Pending(), 0; got != want {
+		t.Errorf("pending timers:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+func TestNotReadyGraceReconfigure(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	fake := clock.NewFake(time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC))
+	ns.Clock = fake
+	ns.NotReadyGrace = time.Hour
+	node := func(ready v1.ConditionStatus) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "host-1"},
+			Status: v1.NodeStatus{
+				Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: ready, LastTransitionTime: metav1.NewTime(fake.Now())}},
+				Addresses:  []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.1"}},
+			},
+		}
+	}
+	ns.Add(node(v1.ConditionTrue))
+
+	// Updating a published NotReady node while the store is being reconfigured must not
+	// deadlock.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2000; i++ {
+			ns.Update(node(v1.ConditionFalse))
+		}
+	}()
+	for i := 0; i < 2000; i++ {
+		ns.Reconfigure(func() {})
+	}
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for updates; deadlock?")
+	}
+}
+
+func TestRemovalDelay(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	ns.Logger = l
+	fake := clock.NewFake(time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC))
+	ns.Clock = fake
+	ns.RemovalDelay = time.Minute
+	var got []Record
+	ns.OnChange = func(req UpdateRequest) error {
+		got = append(got, req.Record)
+		return nil
+	}
+	node := func(name, address string, ready v1.ConditionStatus) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: v1.NodeStatus{
+				Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: ready}},
+				Addresses:  []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: address}},
+			},
+		}
+	}
+	ns.Add(node("host-1", "10.0.0.1", v1.ConditionTrue))
+
+	// A flap that ends within the delay doesn't change the record.
+	ns.Update(node("host-1", "10.0.0.1", v1.ConditionFalse))
+	fake.Advance(30 * time.Second)
+	ns.Update(node("host-1", "10.0.0.1", v1.ConditionTrue))
+	fake.Advance(time.Hour)
+
+	// Additions are immediate.
+	ns.Add(node("host-2", "10.0.0.2", v1.ConditionTrue))
+
+	// A deletion is applied when the delay runs out, even if the node went un-Ready first.
+	ns.Update(node("host-1", "10.0.0.1", v1.ConditionFalse))
+	fake.Advance(30 * time.Second)
+	ns.Delete(node("host-1", "10.0.0.1", v1.ConditionFalse))
+	fake.Advance(29 * time.Second)
+	if got, want := len(got), 2; got != want {
+		t.Fatalf("updates during removal delay:\n  got: %v\n want: %v", got, want)
+	}
+	fake.Advance(time.Second)
+
+	// Cordoned nodes are removed right away.
+	cordoned := node("host-2", "10.0.0.2", v1.ConditionTrue)
+	cordoned.Spec.Unschedulable = true
+	ns.Update(cordoned)
+
+	want := []Record{
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 1)}},
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)}},
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 2)}},
+		{Kind: Internal, IPs: []net.IP{}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("updates:\n%s", diff)
+	}
+	if got, want := fake.Pending(), 0; got != want {
+		t.Errorf("pending timers:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	ns.Logger = l
+	fake := clock.NewFake(time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC))
+	ns.Clock = fake
+	ns.RetryMin, ns.RetryMax = time.Second, 2*time.Second
+	failures := 2
+	var got []Record
+	ns.OnChange = func(req UpdateRequest) error {
+		got = append(got, req.Record)
+		if failures > 0 {
+			failures--
+			return errors.New("injected error")
+		}
+		return nil
+	}
+	ns.Add(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "host-1"},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.1"}},
+		},
+	})
+	for _, step := range []struct {
+		advance time.Duration
+		want    int
+	}{
+		{advance: 999 * time.Millisecond, want: 1},
+		{advance: time.Millisecond, want: 2}, // The first retry, after RetryMin.
+		{advance: 1999 * time.Millisecond, want: 2},
+		{advance: time.Millisecond, want: 3}, // The second retry, after twice as long; it succeeds.
+		{advance: time.Hour, want: 3},
+	} {
+		fake.Advance(step.advance)
+		if got := len(got); got != step.want {
+			t.Fatalf("after %v: updates:\n  got: %v\n want: %v", fake.Now(), got, step.want)
+		}
+	}
+	if got, want := fake.Pending(), 0; got != want {
+		t.Errorf("pending retries after success:\n  got: %v\n want: %v", got, want)
+	}
+	record := Record{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 1)}}
+	want := []Record{record, record, record}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("updates:\n%s", diff)
+	}
+}
+
+func TestRetryLimit(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	ns.Logger = l
+	fake := clock.NewFake(time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC))
+	ns.Clock = fake
+	ns.RetryMin, ns.RetryMax, ns.RetryLimit = time.Second, time.Minute, 2
+	var updates int
+	ns.OnChange = func(req UpdateRequest) error {
+		if req.Record.Kind != Internal {
+			return nil
+		}
+		updates++
+		return errors.New("injected error")
+	}
+	ns.Add(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "host-1"},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.1"}},
+		},
+	})
+	fake.Advance(time.Hour)
+	if got, want := updates, 3; got != want {
+		t.Errorf("updates:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := fake.Pending(), 0; got != want {
+		t.Errorf("pending retries after giving up:\n  got: %v\n want: %v", got, want)
+	}
+	// A resync starts over.
+	ns.Resync() // nolint:errcheck
+	fake.Advance(time.Hour)
+	if got, want := updates, 6; got != want {
+		t.Errorf("updates after resync:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+func TestFamily(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "host-1"},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "10.0.0.1"},
+				{Type: v1.NodeInternalIP, Address: "fd00::1"},
+				{Type: v1.NodeExternalIP, Address: "42.0.0.1"},
+				{Type: v1.NodeExternalIP, Address: "2001:db8::1"},
+			},
+		},
+	}
+	testData := []struct {
+		family string
+		want   map[Kind][]net.IP
+	}{
+		{
+			family: "dual",
+			want: map[Kind][]net.IP{
+				Internal: {net.ParseIP("10.0.0.1"), net.ParseIP("fd00::1")},
+				External: {net.ParseIP("2001:db8::1"), net.ParseIP("42.0.0.1")},
+			},
+		},
+		{
+			family: "ipv4",
+			want: map[Kind][]net.IP{
+				Internal: {net.ParseIP("10.0.0.1")},
+				External: {net.ParseIP("42.0.0.1")},
+			},
+		},
+		{
+			family: "ipv6",
+			want: map[Kind][]net.IP{
+				Internal: {net.ParseIP("fd00::1")},
+				External: {net.ParseIP("2001:db8::1")},
+			},
+		},
+	}
+	for _, test := range testData {
+		t.Run(test.family, func(t *testing.T) {
+			ns := NewNodeStore("test")
+			ns.Family = test.family
+			got := make(map[Kind][]net.IP)
+			ns.OnChange = func(req UpdateRequest) error {
+				got[req.Record.Kind] = req.Record.IPs
+				return nil
+			}
+			ns.Add(node) // nolint:errcheck
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("records:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExcludeNames(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	ns.ExcludeNames = []*regexp.Regexp{regexp.MustCompile("^gpu-burst-"), regexp.MustCompile("-canary$")}
+	var got []Record
+	ns.OnChange = func(req UpdateRequest) error {
+		got = append(got, req.Record)
+		return nil
+	}
+	for i, name := range []string{"gpu-burst-1", "pool-canary", "pool-1", "my-gpu-burst-1"} {
+		ns.Add(&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: fmt.Sprintf("10.0.0.%d", i+1)}},
+			},
+		})
+	}
+	want := []Record{
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 3)}},
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 3), net.IPv4(10, 0, 0, 4)}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("records:\n%s", diff)
+	}
+}
+
+func TestExcludeLabelAndAnnotation(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	ns.ExcludeAnnotation = "nodedns.jrockway.io/exclude"
+	var got []Record
+	ns.OnChange = func(req UpdateRequest) error {
+		got = append(got, req.Record)
+		return nil
+	}
+	for i, meta := range []metav1.ObjectMeta{
+		{Name: "lb-excluded", Labels: map[string]string{ExcludeFromExternalLBLabel: ""}},
+		{Name: "annotated", Annotations: map[string]string{"nodedns.jrockway.io/exclude": "true"}},
+		{Name: "annotated-false", Annotations: map[string]string{"nodedns.jrockway.io/exclude": "false"}},
+		{Name: "plain"},
+	} {
+		ns.Add(&v1.Node{
+			ObjectMeta: meta,
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: fmt.Sprintf("10.0.0.%d", i+1)}},
+			},
+		})
+	}
+	want := []Record{
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 3)}},
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 3), net.IPv4(10, 0, 0, 4)}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("records:\n%s", diff)
+	}
+}
+
+func TestNames(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	ns.Names = []string{"builder-1", "builder-2"}
+	var got []Record
+	ns.OnChange = func(req UpdateRequest) error {
+		got = append(got, req.Record)
+		return nil
+	}
+	for i, name := range []string{"pool-1", "builder-1", "builder-10"} {
+		ns.Add(&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: fmt.Sprintf("10.0.0.%d", i+1)}},
+			},
+		})
+	}
+	want := []Record{
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 2)}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("records:\n%s", diff)
+	}
+}
+
+func TestReconfigure(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	ns.Names = []string{"builder-1"}
+	ns.RecordNames = map[Kind][]string{Internal: {"builders.example.com"}}
+	var got []Record
+	ns.OnChange = func(req UpdateRequest) error {
+		got = append(got, req.Record)
+		return nil
+	}
+	nodes := make([]interface{}, 0, 2)
+	for i, name := range []string{"builder-1", "builder-2"} {
+		nodes = append(nodes, &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: fmt.Sprintf("10.0.0.%d", i+1)}},
+			},
+		})
+	}
+	ns.Replace(nodes, "")
+	got = nil
+
+	if err := ns.Reconfigure(func() {
+		ns.Names = []string{"builder-1", "builder-2"}
+		ns.RecordNames = map[Kind][]string{Internal: {"build.example.com"}}
+	}); err != nil {
+		t.Fatalf("reconfigure: %v", err)
+	}
+	want := []Record{
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 1)}},
+		{Kind: External, IPs: []net.IP{}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("records after reconfigure:\n%s", diff)
+	}
+	if got, want := ns.PublishedIn(Internal), []string{"build.example.com"}; !cmp.Equal(got, want) {
+		t.Errorf("published in:\n  got: %v\n want: %v", got, want)
+	}
+
+	// Listing the nodes again picks up the new names.
+	got = nil
+	ns.Replace(nodes, "")
+	want = []Record{
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("records after relist:\n%s", diff)
+	}
+}
+
+func TestSpot(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "host-1",
+			Labels: map[string]string{
+				"cloud.google.com/gke-spot": "true",
+			},
+		},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{
+				{
+					Type:    v1.NodeExternalIP,
+					Address: "42.0.0.1",
+				},
+				{
+					Type:    v1.NodeInternalIP,
+					Address: "10.0.0.1",
+				},
+			},
+		},
+	}
+
+	testData := []struct {
+		name    string
+		exclude bool
+		want    []Record
+	}{
+		{
+			name: "included by default",
+			want: []Record{
+				{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 1)}},
+				{Kind: External, IPs: []net.IP{net.IPv4(42, 0, 0, 1)}},
+			},
+		},
+		{
+			name:    "excluded",
+			exclude: true,
+			want: []Record{
+				{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 1)}},
+			},
+		},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			ns := NewNodeStore("test")
+			ns.ExcludeSpotExternal = test.exclude
+			// The discovered public address must not be used in place of the spot node's
+			// external address.
+			ns.SetPublicIPs([]net.IP{net.IPv4(192, 0, 2, 1)})
+			var got []Record
+			ns.OnChange = func(req UpdateRequest) error {
+				got = append(got, req.Record)
+				return nil
+			}
+			ns.Add(node)
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("add:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestVIPs(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	nodes := []interface{}{
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "host-1",
+				Annotations: map[string]string{
+					"example.com/vip": "203.0.113.10, invalid",
+				},
+			},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{
+					{
+						Type:    v1.NodeExternalIP,
+						Address: "42.0.0.1",
+					},
+				},
+			},
+		},
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "host-2",
+				Annotations: map[string]string{
+					"example.com/vip": "203.0.113.10",
+				},
+			},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{
+					{
+						Type:    v1.NodeExternalIP,
+						Address: "42.0.0.2",
+					},
+				},
+			},
+		},
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "host-3",
+			},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{
+					{
+						Type:    v1.NodeExternalIP,
+						Address: "42.0.0.3",
+					},
+				},
+			},
+		},
+	}
+
+	testData := []struct {
+		name    string
+		kind    Kind
+		replace bool
+		want    []Record
+	}{
+		{
+			name: "added",
+			want: []Record{
+				{Kind: External, IPs: []net.IP{net.IPv4(203, 0, 113, 10), net.IPv4(42, 0, 0, 1), net.IPv4(42, 0, 0, 2), net.IPv4(42, 0, 0, 3)}},
+			},
+		},
+		{
+			name:    "replaced",
+			replace: true,
+			want: []Record{
+				{Kind: External, IPs: []net.IP{net.IPv4(203, 0, 113, 10), net.IPv4(42, 0, 0, 3)}},
+			},
+		},
+		{
+			name: "internal",
+			kind: Internal,
+			want: []Record{
+				{Kind: Internal, IPs: []net.IP{net.IPv4(203, 0, 113, 10)}},
+				{Kind: External, IPs: []net.IP{net.IPv4(42, 0, 0, 1), net.IPv4(42, 0, 0, 2), net.IPv4(42, 0, 0, 3)}},
+			},
+		},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			ns := NewNodeStore("test")
+			ns.VIPAnnotations = []string{"example.com/vip"}
+			ns.VIPKind = test.kind
+			ns.VIPReplace = test.replace
+			var got []Record
+			ns.OnChange = func(req UpdateRequest) error {
+				got = append(got, req.Record)
+				return nil
+			}
+			ns.Replace(nodes, "")
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("replace:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAddressAnnotation(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	node := func(name string, annotations map[string]string, internal, external string) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: internal}, {Type: v1.NodeExternalIP, Address: external}},
+			},
+		}
+	}
+	nodes := []interface{}{
+		// Behind 1:1 NAT; the reported external address is replaced.
+		node("host-1", map[string]string{"nodedns.jrockway.io/external-ip": "203.0.113.7"}, "10.0.0.1", "42.0.0.1"),
+		// An address is added to the reported one.
+		node("host-2", map[string]string{"nodedns.jrockway.io/internal-ip": "+10.1.0.2"}, "10.0.0.2", "42.0.0.2"),
+		// An invalid annotation is ignored.
+		node("host-3", map[string]string{"nodedns.jrockway.io/external-ip": "203.0.113.9, invalid"}, "10.0.0.3", "42.0.0.3"),
+	}
+	ns := NewNodeStore("test")
+	ns.AddressAnnotation = "nodedns.jrockway.io/{kind}-ip"
+	var got []Record
+	ns.OnChange = func(req UpdateRequest) error {
+		got = append(got, req.Record)
+		return nil
+	}
+	ns.Replace(nodes, "") // nolint:errcheck
+	want := []Record{
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 3), net.IPv4(10, 1, 0, 2)}},
+		{Kind: External, IPs: []net.IP{net.IPv4(203, 0, 113, 7), net.IPv4(42, 0, 0, 2), net.IPv4(42, 0, 0, 3)}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("replace:\n%s", diff)
+	}
+}
+
+func TestCorrelationID(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	ns.Logger = l
+	var ids []string
+	record := func(req UpdateRequest) error {
+		ids = append(ids, correlation.ID(req.Ctx))
+		return nil
+	}
+	ns.Subscribe(SinkFunc("a", record), SinkFunc("b", record))
+	node := func(name, internal, external string) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{
+					{Type: v1.NodeInternalIP, Address: internal},
+					{Type: v1.NodeExternalIP, Address: external},
+				},
+			},
+		}
+	}
+
+	// Every update caused by one event shares an ID.
+	ns.Add(node("host-1", "10.0.0.1", "42.0.0.1"))
+	if got, want := len(ids), 4; got != want {
+		t.Fatalf("updates:\n  got: %v\n want: %v", got, want)
+	}
+	for _, id := range ids {
+		if id == "" || id != ids[0] {
+			t.Errorf("ids for one event:\n  got: %v\n want: one non-empty id", ids)
+			break
+		}
+	}
+
+	// The next event has its own.
+	first := ids[0]
+	ids = nil
+	ns.Add(node("host-2", "10.0.0.2", "42.0.0.2"))
+	if len(ids) == 0 || ids[0] == "" || ids[0] == first {
+		t.Errorf("ids for the next event:\n  got: %v\n want: a new id, not %v", ids, first)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	ns := NewNodeStore("test")
+	ns.Logger = l
+	ns.Subscribe(SinkFunc("dns", func(req UpdateRequest) error {
+		_, span := tracing.Start(req.Ctx, "api_request")
+		span.End()
+		return nil
+	}))
+	ns.Add(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "host-1"},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.1"}},
+		},
+	})
+
+	// Everything that one event caused is in one trace, under a root span for the event.
+	spans := sr.Ended()
+	var root sdktrace.ReadOnlySpan
+	names := make(map[string]int)
+	for _, span := range spans {
+		names[span.Name()]++
+		if span.Name() == "reconcile.add" {
+			root = span
+		}
+	}
+	if root == nil {
+		t.Fatalf("no reconcile.add span in %v", names)
+	}
+	if root.Parent().IsValid() {
+		t.Errorf("reconcile.add has a parent:\n  got: %v\n want: none", root.Parent().SpanID())
+	}
+	if got, want := names["api_request"], names["update_sink"]; got == 0 || got != want {
+		t.Errorf("api requests:\n  got: %v\n want: one per update_sink span (%v)", got, want)
+	}
+	for _, span := range spans {
+		if got, want := span.SpanContext().TraceID(), root.SpanContext().TraceID(); got != want {
+			t.Errorf("trace of span %s:\n  got: %v\n want: %v", span.Name(), got, want)
+		}
+	}
+}
+
+func TestNodeNames(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "b"}}, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "a"}})
+	got, err := NodeNames(context.Background(), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, []string{"a", "b"}); diff != "" {
+		t.Errorf("node names:\n%s", diff)
+	}
+}
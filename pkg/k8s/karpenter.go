@@ -0,0 +1,165 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// NodeClaimResources are the versions of Karpenter's NodeClaim resource that WatchNodeClaims
+// understands, in order of preference.
+var NodeClaimResources = []schema.GroupVersionResource{
+	{Group: "karpenter.sh", Version: "v1", Resource: "nodeclaims"},
+	{Group: "karpenter.sh", Version: "v1beta1", Resource: "nodeclaims"},
+}
+
+// nodeClaimResource returns the preferred NodeClaim resource that the API server serves, or false if
+// Karpenter isn't installed.
+func nodeClaimResource(dc discovery.DiscoveryInterface) (schema.GroupVersionResource, bool, error) {
+	groups, err := dc.ServerGroups()
+	if err != nil {
+		return schema.GroupVersionResource{}, false, fmt.Errorf("list api groups: %w", err)
+	}
+	served := make(map[string]bool)
+	for _, g := range groups.Groups {
+		for _, v := range g.Versions {
+			served[v.GroupVersion] = true
+		}
+	}
+	for _, gvr := range NodeClaimResources {
+		gv := gvr.GroupVersion().String()
+		if !served[gv] {
+			continue
+		}
+		resources, err := dc.ServerResourcesForGroupVersion(gv)
+		if err != nil {
+			return schema.GroupVersionResource{}, false, fmt.Errorf("list resources in %s: %w", gv, err)
+		}
+		for _, r := range resources.APIResources {
+			if r.Name == gvr.Resource {
+				return gvr, true, nil
+			}
+		}
+	}
+	return schema.GroupVersionResource{}, false, nil
+}
+
+// nodeClaimStore is a cache.Store that watches Karpenter NodeClaims, and removes the nodes of
+// terminating NodeClaims from a set of NodeStores.
+type nodeClaimStore struct {
+	stores []*NodeStore
+}
+
+func (s *nodeClaimStore) handle(obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		zap.L().Error("wrong-type object", zap.Any("obj", obj))
+		return
+	}
+	if u.GetDeletionTimestamp() == nil {
+		return
+	}
+	node, _, err := unstructured.NestedString(u.Object, "status", "nodeName")
+	if err != nil || node == "" {
+		// The NodeClaim never launched a node.
+		return
+	}
+	zap.L().Debug("nodeclaim terminating; removing node from dns", zap.String("nodeclaim", u.GetName()), zap.String("node", node))
+	for _, st := range s.stores {
+		st.Terminate(node)
+	}
+}
+
+// Add implements cache.Store.
+func (s *nodeClaimStore) Add(obj interface{}) error {
+	s.handle(obj)
+	return nil
+}
+
+// Update implements cache.Store.
+func (s *nodeClaimStore) Update(obj interface{}) error {
+	s.handle(obj)
+	return nil
+}
+
+// Delete implements cache.Store.  Nodes stay terminated until they're deleted, so there is
+// nothing to do here.
+func (s *nodeClaimStore) Delete(obj interface{}) error { return nil }
+
+// Replace implements cache.Store.
+func (s *nodeClaimStore) Replace(objs []interface{}, unusedResourceVersion string) error {
+	for _, obj := range objs {
+		s.handle(obj)
+	}
+	return nil
+}
+
+// Resync implements cache.Store.
+func (s *nodeClaimStore) Resync() error { return nil }
+
+// These are unused by the reflector.
+func (s *nodeClaimStore) List() []interface{} { return nil }
+func (s *nodeClaimStore) ListKeys() []string  { return nil }
+func (s *nodeClaimStore) Get(obj interface{}) (item interface{}, exists bool, err error) {
+	return nil, false, errors.New("unimplemented")
+}
+func (s *nodeClaimStore) GetByKey(key string) (item interface{}, exists bool, err error) {
+	return nil, false, errors.New("unimplemented")
+}
+
+// WatchNodeClaims watches Karpenter NodeClaims, and removes a node from the provided stores as
+// soon as its NodeClaim starts terminating, rather than waiting for the node to be deleted.  If
+// Karpenter isn't installed in the cluster, it returns immediately.
+func WatchNodeClaims(ctx context.Context, master, kubeconfig string, resync time.Duration, stores []*NodeStore) error {
+	config, err := restConfig(master, kubeconfig)
+	if err != nil {
+		return err
+	}
+	return WatchNodeClaimsWithConfig(ctx, config, resync, stores)
+}
+
+// WatchNodeClaimsWithConfig is like WatchNodeClaims, but connects to the API server described by
+// config.
+func WatchNodeClaimsWithConfig(ctx context.Context, config *rest.Config, resync time.Duration, stores []*NodeStore) error {
+	dc, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return fmt.Errorf("kubernetes: new discovery client: %w", err)
+	}
+	gvr, ok, err := nodeClaimResource(dc)
+	if err != nil {
+		return fmt.Errorf("kubernetes: discover nodeclaims: %w", err)
+	}
+	if !ok {
+		zap.L().Info("karpenter nodeclaims not found; not watching them")
+		return nil
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("kubernetes: new dynamic client: %w", err)
+	}
+	zap.L().Info("watching karpenter nodeclaims", zap.String("resource", gvr.String()))
+	nc := client.Resource(gvr)
+	lw := &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			return nc.List(ctx, opts)
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			return nc.Watch(ctx, opts)
+		},
+	}
+	r := cache.NewReflector(lw, &unstructured.Unstructured{}, &nodeClaimStore{stores: stores}, resync)
+	r.Run(ctx.Done())
+	return nil
+}
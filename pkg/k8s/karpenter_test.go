@@ -0,0 +1,126 @@
+package k8s
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestNodeClaimResource(t *testing.T) {
+	testData := []struct {
+		name      string
+		resources []*metav1.APIResourceList
+		want      schema.GroupVersionResource
+		wantOK    bool
+	}{
+		{
+			name: "not installed",
+			resources: []*metav1.APIResourceList{
+				{GroupVersion: "v1", APIResources: []metav1.APIResource{{Name: "nodes"}}},
+			},
+		},
+		{
+			name: "v1beta1",
+			resources: []*metav1.APIResourceList{
+				{GroupVersion: "karpenter.sh/v1beta1", APIResources: []metav1.APIResource{{Name: "nodepools"}, {Name: "nodeclaims"}}},
+			},
+			want:   NodeClaimResources[1],
+			wantOK: true,
+		},
+		{
+			name: "v1 preferred",
+			resources: []*metav1.APIResourceList{
+				{GroupVersion: "karpenter.sh/v1beta1", APIResources: []metav1.APIResource{{Name: "nodeclaims"}}},
+				{GroupVersion: "karpenter.sh/v1", APIResources: []metav1.APIResource{{Name: "nodeclaims"}}},
+			},
+			want:   NodeClaimResources[0],
+			wantOK: true,
+		},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			dc := &fakediscovery.FakeDiscovery{Fake: &k8stesting.Fake{Resources: test.resources}}
+			got, ok, err := nodeClaimResource(dc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != test.wantOK {
+				t.Errorf("found:\n  got: %v\n want: %v", ok, test.wantOK)
+			}
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("resource:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNodeClaimTermination(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	var got []Record
+	ns.OnChange = func(req UpdateRequest) error {
+		got = append(got, req.Record)
+		return nil
+	}
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "host-1",
+		},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{
+				{
+					Type:    v1.NodeInternalIP,
+					Address: "10.0.0.1",
+				},
+			},
+		},
+	}
+	ns.Add(node)
+
+	nodeClaim := func(terminating bool) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "karpenter.sh/v1",
+			"kind":       "NodeClaim",
+			"metadata": map[string]interface{}{
+				"name": "default-abcde",
+			},
+			"status": map[string]interface{}{
+				"nodeName": "host-1",
+			},
+		}}
+		if terminating {
+			now := metav1.Now()
+			u.SetDeletionTimestamp(&now)
+		}
+		return u
+	}
+	s := &nodeClaimStore{stores: []*NodeStore{ns}}
+	s.Add(nodeClaim(false))
+	s.Update(nodeClaim(true))
+	s.Update(nodeClaim(true)) // no change
+	// Updates to the node itself don't bring it back.
+	ns.Update(node)
+	s.Delete(nodeClaim(true))
+	ns.Delete(node)
+	// A new node with the same name is published.
+	ns.Add(node)
+
+	want := []Record{
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 1)}},
+		{Kind: Internal, IPs: []net.IP{}},
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 1)}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("records:\n%s", diff)
+	}
+}
@@ -0,0 +1,131 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+var leaderGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "leader_election_leader",
+	Help: "1 while this replica holds the leader election lease, 0 otherwise.",
+})
+
+// LeaderConfig configures RunLeaderElection.
+type LeaderConfig struct {
+	Master, Kubeconfig string // Locate the API server; see Clientset.
+
+	// Namespace and Name name the Lease.  If Namespace is empty, the namespace that nodedns runs
+	// in is used; it's required when running outside of the cluster.
+	Namespace, Name string
+
+	// Identity identifies this replica in the lease; it defaults to the hostname, which is the
+	// pod's name.
+	Identity string
+
+	// Lead is called when this replica becomes the leader, and Follow when it stops being the
+	// leader.  A replica that loses the lease campaigns for it again.
+	Lead, Follow func()
+}
+
+// inClusterNamespace is where the namespace of the pod that nodedns runs in is mounted.
+const inClusterNamespace = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// RunLeaderElection campaigns for a Lease until the context is done, so that only one of several
+// replicas acts at a time.  Unlike the leader election in RunController, it doesn't stop anything
+// else from running; callers decide what to do in Lead and Follow.  The lease is released when the
+// context is done, so that another replica can take over right away.
+func RunLeaderElection(ctx context.Context, cfg LeaderConfig) error {
+	if cfg.Namespace == "" {
+		ns, err := ioutil.ReadFile(inClusterNamespace)
+		if err != nil {
+			return fmt.Errorf("kubernetes: leader election namespace is required outside of the cluster: %w", err)
+		}
+		cfg.Namespace = strings.TrimSpace(string(ns))
+	}
+	if cfg.Identity == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("kubernetes: leader election identity: %w", err)
+		}
+		cfg.Identity = host
+	}
+	if cfg.Name == "" {
+		return errors.New("kubernetes: leader election lease name is required")
+	}
+	config, err := restConfig(cfg.Master, cfg.Kubeconfig)
+	if err != nil {
+		return err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("kubernetes: new client: %w", err)
+	}
+	return runLeaderElection(ctx, cfg, clientset)
+}
+
+// leaseTimings are the lease duration, renew deadline, and retry period of leader election, the
+// same as controller-runtime's defaults; tests may shorten them.
+var leaseTimings = struct{ Lease, Renew, Retry time.Duration }{15 * time.Second, 10 * time.Second, 2 * time.Second}
+
+func runLeaderElection(ctx context.Context, cfg LeaderConfig, clientset kubernetes.Interface) error {
+	var leading int32 // OnStoppedLeading is called even if we never led.
+	l := zap.L().Named("leader").With(zap.String("lease", cfg.Namespace+"/"+cfg.Name), zap.String("identity", cfg.Identity))
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Namespace: cfg.Namespace, Name: cfg.Name},
+			Client:     clientset.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: cfg.Identity},
+		},
+		LeaseDuration:   leaseTimings.Lease,
+		RenewDeadline:   leaseTimings.Renew,
+		RetryPeriod:     leaseTimings.Retry,
+		ReleaseOnCancel: true,
+		Name:            cfg.Name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				atomic.StoreInt32(&leading, 1)
+				l.Info("became the leader")
+				leaderGauge.Set(1)
+				if cfg.Lead != nil {
+					cfg.Lead()
+				}
+			},
+			OnStoppedLeading: func() {
+				if !atomic.CompareAndSwapInt32(&leading, 1, 0) {
+					return
+				}
+				l.Info("stopped being the leader")
+				leaderGauge.Set(0)
+				if cfg.Follow != nil {
+					cfg.Follow()
+				}
+			},
+			OnNewLeader: func(identity string) {
+				if identity != cfg.Identity {
+					l.Info("following", zap.String("leader", identity))
+				}
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("kubernetes: new leader elector: %w", err)
+	}
+	for ctx.Err() == nil {
+		le.Run(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLeaderElection(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	old := leaseTimings
+	leaseTimings.Lease, leaseTimings.Renew, leaseTimings.Retry = time.Second, 500*time.Millisecond, 50*time.Millisecond
+	defer func() { leaseTimings = old }()
+
+	clientset := fake.NewSimpleClientset()
+	events := make(chan string, 10)
+	run := func(ctx context.Context, identity string) chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			err := runLeaderElection(ctx, LeaderConfig{
+				Namespace: "kube-system",
+				Name:      "nodedns",
+				Identity:  identity,
+				Lead:      func() { events <- identity + " leads" },
+				Follow:    func() { events <- identity + " follows" },
+			}, clientset)
+			if err != nil {
+				t.Errorf("leader election %s: %v", identity, err)
+			}
+		}()
+		return done
+	}
+	next := func(want string) {
+		t.Helper()
+		select {
+		case got := <-events:
+			if got != want {
+				t.Errorf("event:\n  got: %v\n want: %v", got, want)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	defer cancelA()
+	doneA := run(ctxA, "a")
+	next("a leads")
+
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+	doneB := run(ctxB, "b")
+	select {
+	case got := <-events:
+		t.Errorf("unexpected event while a holds the lease: %v", got)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// a releases the lease when it stops, so b takes over without waiting for it to expire.
+	cancelA()
+	<-doneA
+	next("a follows")
+	next("b leads")
+	cancelB()
+	<-doneB
+	next("b follows")
+}
@@ -0,0 +1,272 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+)
+
+// NodeDNSRecordResource is the NodeDNSRecord custom resource, which asks for the addresses of the
+// nodes matching a selector to be published in a record; see deploy/crd.yaml.
+var NodeDNSRecordResource = schema.GroupVersionResource{Group: "nodedns.jrockway.io", Version: "v1alpha1", Resource: "nodednsrecords"}
+
+// NodeDNSRecordSpec is the spec of a NodeDNSRecord.
+type NodeDNSRecordSpec struct {
+	RecordName   string `json:"recordName"`             // The record, relative to the zone.
+	Zone         string `json:"zone,omitempty"`         // The zone; the zone that nodedns is configured with, if empty.
+	Provider     string `json:"provider,omitempty"`     // The dns provider; the one that nodedns is configured with, if empty.
+	AddressType  string `json:"addressType,omitempty"`  // "internal", "external" (the default), or "overlay".
+	NodeSelector string `json:"nodeSelector,omitempty"` // A label selector; every node, if empty.
+	TTL          int64  `json:"ttl,omitempty"`          // In seconds; nodedns's TTL, if zero.
+}
+
+// NodeDNSRecord is a NodeDNSRecord object.
+type NodeDNSRecord struct {
+	Namespace  string
+	Name       string
+	Generation int64
+	Spec       NodeDNSRecordSpec
+}
+
+// Key returns the namespace and name of the object, like "default/nodes".
+func (r *NodeDNSRecord) Key() string {
+	return r.Namespace + "/" + r.Name
+}
+
+// Kind returns the kind of addresses that the record publishes.
+func (r *NodeDNSRecord) Kind() Kind {
+	if r.Spec.AddressType == "" {
+		return External
+	}
+	return Kind(r.Spec.AddressType)
+}
+
+// TTL returns the TTL of the record, or 0 to use the default.
+func (r *NodeDNSRecord) TTL() time.Duration {
+	return time.Duration(r.Spec.TTL) * time.Second
+}
+
+// Validate checks the spec for problems that the CRD's schema doesn't catch.
+func (r *NodeDNSRecord) Validate() error {
+	if r.Spec.RecordName == "" {
+		return errors.New("recordName: required")
+	}
+	switch r.Kind() {
+	case Internal, External, Overlay:
+	default:
+		return fmt.Errorf("addressType %q: must be internal, external, or overlay", r.Spec.AddressType)
+	}
+	if _, err := labels.Parse(r.Spec.NodeSelector); err != nil {
+		return fmt.Errorf("nodeSelector: %w", err)
+	}
+	if r.Spec.TTL < 0 {
+		return fmt.Errorf("ttl %d: must not be negative", r.Spec.TTL)
+	}
+	return nil
+}
+
+// ParseNodeDNSRecord converts a NodeDNSRecord object from the API server.  The returned error
+// describes a problem with the spec; the record is returned anyway, so that its status can be
+// written.
+func ParseNodeDNSRecord(u *unstructured.Unstructured) (*NodeDNSRecord, error) {
+	r := &NodeDNSRecord{Namespace: u.GetNamespace(), Name: u.GetName(), Generation: u.GetGeneration()}
+	spec, ok, err := unstructured.NestedMap(u.Object, "spec")
+	if err != nil {
+		return r, fmt.Errorf("spec: %w", err)
+	}
+	if !ok {
+		return r, errors.New("spec: required")
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(spec, &r.Spec); err != nil {
+		return r, fmt.Errorf("spec: %w", err)
+	}
+	return r, r.Validate()
+}
+
+// NodeDNSRecordStatus is the status of a NodeDNSRecord.
+type NodeDNSRecordStatus struct {
+	ObservedGeneration int64        `json:"observedGeneration"`
+	FQDN               string       `json:"fqdn,omitempty"`
+	Addresses          []string     `json:"addresses"`
+	LastSyncTime       *metav1.Time `json:"lastSyncTime,omitempty"` // Only changed by successful updates.
+	Error              string       `json:"error"`                  // Empty after a successful update.
+}
+
+// NodeDNSRecordHandler is told about each NodeDNSRecord as it's created, changed, or deleted.
+type NodeDNSRecordHandler interface {
+	// SetRecord is called with each new record, and each record whose spec has changed.  err is
+	// the problem with its spec, if there is one.
+	SetRecord(r *NodeDNSRecord, err error)
+	// DeleteRecord is called when the record with the key is deleted.
+	DeleteRecord(key string)
+}
+
+// NodeDNSRecordLister is a NodeDNSRecordHandler that wants to know when it has been told about
+// every NodeDNSRecord.  Listed is called after each complete list of them.
+type NodeDNSRecordLister interface {
+	Listed()
+}
+
+// nodeDNSRecordStore is a cache.Store that tells a NodeDNSRecordHandler about changes to
+// NodeDNSRecords.  Updates that don't change an object's generation, like status updates, are
+// ignored.
+type nodeDNSRecordStore struct {
+	handler     NodeDNSRecordHandler
+	mu          sync.Mutex
+	generations map[string]int64 // The generation of each record that the handler knows about, by key.
+}
+
+func (s *nodeDNSRecordStore) set(obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		zap.L().Error("wrong-type object", zap.Any("obj", obj))
+		return
+	}
+	r, err := ParseNodeDNSRecord(u)
+	s.mu.Lock()
+	if gen, ok := s.generations[r.Key()]; ok && gen == r.Generation {
+		s.mu.Unlock()
+		return
+	}
+	s.generations[r.Key()] = r.Generation
+	s.mu.Unlock()
+	s.handler.SetRecord(r, err)
+}
+
+func (s *nodeDNSRecordStore) delete(key string) {
+	s.mu.Lock()
+	_, ok := s.generations[key]
+	delete(s.generations, key)
+	s.mu.Unlock()
+	if ok {
+		s.handler.DeleteRecord(key)
+	}
+}
+
+// Add implements cache.Store.
+func (s *nodeDNSRecordStore) Add(obj interface{}) error {
+	s.set(obj)
+	return nil
+}
+
+// Update implements cache.Store.
+func (s *nodeDNSRecordStore) Update(obj interface{}) error {
+	s.set(obj)
+	return nil
+}
+
+// Delete implements cache.Store.
+func (s *nodeDNSRecordStore) Delete(obj interface{}) error {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		zap.L().Error("wrong-type object", zap.Any("obj", obj))
+		return nil
+	}
+	s.delete(u.GetNamespace() + "/" + u.GetName())
+	return nil
+}
+
+// Replace implements cache.Store.
+func (s *nodeDNSRecordStore) Replace(objs []interface{}, unusedResourceVersion string) error {
+	keep := make(map[string]bool, len(objs))
+	for _, obj := range objs {
+		if u, ok := obj.(*unstructured.Unstructured); ok {
+			keep[u.GetNamespace()+"/"+u.GetName()] = true
+		}
+		s.set(obj)
+	}
+	s.mu.Lock()
+	var deleted []string
+	for key := range s.generations {
+		if !keep[key] {
+			deleted = append(deleted, key)
+		}
+	}
+	s.mu.Unlock()
+	for _, key := range deleted {
+		s.delete(key)
+	}
+	if l, ok := s.handler.(NodeDNSRecordLister); ok {
+		l.Listed()
+	}
+	return nil
+}
+
+// Resync implements cache.Store.
+func (s *nodeDNSRecordStore) Resync() error { return nil }
+
+// These are unused by the reflector.
+func (s *nodeDNSRecordStore) List() []interface{} { return nil }
+func (s *nodeDNSRecordStore) ListKeys() []string  { return nil }
+func (s *nodeDNSRecordStore) Get(obj interface{}) (item interface{}, exists bool, err error) {
+	return nil, false, errors.New("unimplemented")
+}
+func (s *nodeDNSRecordStore) GetByKey(key string) (item interface{}, exists bool, err error) {
+	return nil, false, errors.New("unimplemented")
+}
+
+// NodeDNSRecordClient watches NodeDNSRecords and writes their status.
+type NodeDNSRecordClient struct {
+	client dynamic.Interface
+}
+
+// NewNodeDNSRecordClient returns a NodeDNSRecordClient, using an in-cluster configuration if
+// kubeconfig and master are empty.
+func NewNodeDNSRecordClient(master, kubeconfig string) (*NodeDNSRecordClient, error) {
+	config, err := restConfig(master, kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes: new dynamic client: %w", err)
+	}
+	return &NodeDNSRecordClient{client: client}, nil
+}
+
+// Watch tells the handler about every NodeDNSRecord, in every namespace, and about changes to
+// them, until the context is done.
+func (c *NodeDNSRecordClient) Watch(ctx context.Context, resync time.Duration, h NodeDNSRecordHandler) {
+	records := c.client.Resource(NodeDNSRecordResource)
+	lw := &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			return records.List(ctx, opts)
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			return records.Watch(ctx, opts)
+		},
+	}
+	s := &nodeDNSRecordStore{handler: h, generations: make(map[string]int64)}
+	r := cache.NewReflector(lw, &unstructured.Unstructured{}, s, resync)
+	r.Run(ctx.Done())
+}
+
+// WriteStatus replaces the status of the NodeDNSRecord with the key.
+func (c *NodeDNSRecordClient) WriteStatus(ctx context.Context, key string, status NodeDNSRecordStatus) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return fmt.Errorf("parse key: %w", err)
+	}
+	patch, err := json.Marshal(map[string]interface{}{"status": status})
+	if err != nil {
+		return fmt.Errorf("marshal status: %w", err)
+	}
+	if _, err := c.client.Resource(NodeDNSRecordResource).Namespace(namespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{}, "status"); err != nil {
+		return fmt.Errorf("patch status of %s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func nodeDNSRecord(name string, generation int64, spec map[string]interface{}) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "nodedns.jrockway.io/v1alpha1",
+		"kind":       "NodeDNSRecord",
+		"spec":       spec,
+	}}
+	u.SetNamespace("default")
+	u.SetName(name)
+	u.SetGeneration(generation)
+	return u
+}
+
+func TestParseNodeDNSRecord(t *testing.T) {
+	testData := []struct {
+		name    string
+		spec    map[string]interface{}
+		want    NodeDNSRecordSpec
+		wantErr bool
+	}{
+		{
+			name: "minimal",
+			spec: map[string]interface{}{"recordName": "nodes"},
+			want: NodeDNSRecordSpec{RecordName: "nodes"},
+		},
+		{
+			name: "everything",
+			spec: map[string]interface{}{
+				"recordName":   "gpu",
+				"zone":         "example.com",
+				"provider":     "cloudflare",
+				"addressType":  "internal",
+				"nodeSelector": "pool=gpu",
+				"ttl":          int64(300),
+			},
+			want: NodeDNSRecordSpec{RecordName: "gpu", Zone: "example.com", Provider: "cloudflare", AddressType: "internal", NodeSelector: "pool=gpu", TTL: 300},
+		},
+		{
+			name:    "no record name",
+			spec:    map[string]interface{}{"zone": "example.com"},
+			want:    NodeDNSRecordSpec{Zone: "example.com"},
+			wantErr: true,
+		},
+		{
+			name:    "bad address type",
+			spec:    map[string]interface{}{"recordName": "nodes", "addressType": "public"},
+			want:    NodeDNSRecordSpec{RecordName: "nodes", AddressType: "public"},
+			wantErr: true,
+		},
+		{
+			name:    "bad selector",
+			spec:    map[string]interface{}{"recordName": "nodes", "nodeSelector": "pool in ("},
+			want:    NodeDNSRecordSpec{RecordName: "nodes", NodeSelector: "pool in ("},
+			wantErr: true,
+		},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := ParseNodeDNSRecord(nodeDNSRecord("test", 1, test.spec))
+			if got, want := err != nil, test.wantErr; got != want {
+				t.Errorf("error:\n  got: %v\n want error: %v", err, want)
+			}
+			if diff := cmp.Diff(r.Spec, test.want); diff != "" {
+				t.Errorf("spec:\n%s", diff)
+			}
+			if got, want := r.Key(), "default/test"; got != want {
+				t.Errorf("key:\n  got: %v\n want: %v", got, want)
+			}
+		})
+	}
+}
+
+type recordEvents []string
+
+func (e *recordEvents) SetRecord(r *NodeDNSRecord, err error) {
+	event := "set " + r.Key()
+	if err != nil {
+		event += ": " + err.Error()
+	}
+	*e = append(*e, event)
+}
+
+func (e *recordEvents) DeleteRecord(key string) {
+	*e = append(*e, "delete "+key)
+}
+
+func (e *recordEvents) Listed() {
+	*e = append(*e, "listed")
+}
+
+func TestNodeDNSRecordStore(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	var got recordEvents
+	s := &nodeDNSRecordStore{handler: &got, generations: make(map[string]int64)}
+	spec := map[string]interface{}{"recordName": "nodes"}
+	s.Replace([]interface{}{nodeDNSRecord("a", 1, spec), nodeDNSRecord("b", 1, spec)}, "")
+	s.Update(nodeDNSRecord("a", 1, spec)) // A status update.
+	s.Update(nodeDNSRecord("a", 2, map[string]interface{}{}))
+	s.Add(nodeDNSRecord("c", 1, spec))
+	s.Delete(nodeDNSRecord("c", 1, spec))
+	s.Replace([]interface{}{nodeDNSRecord("a", 2, spec)}, "")
+	want := recordEvents{
+		"set default/a",
+		"set default/b",
+		"listed",
+		"set default/a: recordName: required",
+		"set default/c",
+		"delete default/c",
+		"delete default/b",
+		"listed",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("events:\n%s", diff)
+	}
+}
+
+func TestWriteNodeDNSRecordStatus(t *testing.T) {
+	ctx := context.Background()
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		NodeDNSRecordResource: "NodeDNSRecordList",
+	}, nodeDNSRecord("nodes", 3, map[string]interface{}{"recordName": "nodes"}))
+	c := &NodeDNSRecordClient{client: client}
+	synced := metav1.Unix(1, 0)
+	if err := c.WriteStatus(ctx, "default/nodes", NodeDNSRecordStatus{ObservedGeneration: 3, FQDN: "nodes.example.com", Addresses: []string{"10.0.0.1"}, LastSyncTime: &synced}); err != nil {
+		t.Fatalf("write status: %v", err)
+	}
+	// A failed update keeps the last sync time.
+	if err := c.WriteStatus(ctx, "default/nodes", NodeDNSRecordStatus{ObservedGeneration: 3, FQDN: "nodes.example.com", Addresses: []string{"10.0.0.2"}, Error: "rate limited"}); err != nil {
+		t.Fatalf("write status: %v", err)
+	}
+	u, err := client.Resource(NodeDNSRecordResource).Namespace("default").Get(ctx, "nodes", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	got, _, err := unstructured.NestedMap(u.Object, "status")
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	want := map[string]interface{}{
+		"observedGeneration": int64(3),
+		"fqdn":               "nodes.example.com",
+		"addresses":          []interface{}{"10.0.0.2"},
+		"lastSyncTime":       "1970-01-01T00:00:01Z",
+		"error":              "rate limited",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("status:\n%s", diff)
+	}
+}
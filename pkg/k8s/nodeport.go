@@ -0,0 +1,258 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"github.com/jrockway/nodedns/pkg/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+var nodePortSRVRecords = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "nodeport_srv_records",
+	Help: "The number of SRV records that currently point at a Service's NodePort.",
+})
+
+// ServicePort names one port of a Service, by the port's name or number.
+type ServicePort struct {
+	Namespace, Service, Port string
+}
+
+// String returns the port as namespace/service/port.
+func (p ServicePort) String() string {
+	return p.Namespace + "/" + p.Service + "/" + p.Port
+}
+
+// ParseServicePort parses a port written as namespace/service/port, like "default/web/https" or
+// "default/web/443".
+func ParseServicePort(s string) (ServicePort, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return ServicePort{}, fmt.Errorf("service port %q: want namespace/service/port", s)
+	}
+	return ServicePort{Namespace: parts[0], Service: parts[1], Port: parts[2]}, nil
+}
+
+// NodePortSRV is a cache.Store that publishes an SRV record for each configured Service port,
+// pointing at the node record with the port's NodePort, so that clients can find a service that
+// is exposed on every node without knowing which port Kubernetes chose for it.  A record is
+// published again when the NodePort changes, and removed when the Service or its port goes away
+// or stops having a NodePort.
+type NodePortSRV struct {
+	Ports map[string]ServicePort // The port to publish in each SRV record, by record name.
+
+	// Publish points the named SRV record at the node record with the provided port.  A port of
+	// 0 removes the record.
+	Publish func(ctx context.Context, name string, port int32) error
+	Timeout time.Duration // How long each round of updates may take; 30 seconds if zero.
+	// Guard, if set, is called before records are emptied, with how many records are published
+	// and how many would be emptied; an error leaves them all alone, and is returned, so that
+	// they're tried again at the next update.  See dns.Guard.CheckRemovals.
+	Guard func(published, removed int) error
+
+	mu        sync.Mutex
+	services  map[string]*v1.Service // Services named by Ports, by namespace/name.
+	published map[string]int32       // The NodePort last published in each record, by name.
+}
+
+// wanted returns whether any of the configured ports is a port of the Service.
+func (s *NodePortSRV) wanted(svc *v1.Service) bool {
+	for _, p := range s.Ports {
+		if p.Namespace == svc.Namespace && p.Service == svc.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// nodePort returns the NodePort of the port, or 0 if the Service doesn't exist or the port doesn't
+// have a NodePort.  The caller must hold the lock.
+func (s *NodePortSRV) nodePort(p ServicePort) int32 {
+	svc, ok := s.services[p.Namespace+"/"+p.Service]
+	if !ok {
+		return 0
+	}
+	for _, port := range svc.Spec.Ports {
+		if port.Name == p.Port || strconv.Itoa(int(port.Port)) == p.Port {
+			return port.NodePort
+		}
+	}
+	return 0
+}
+
+// sync publishes every record whose NodePort changed since it was last published, or every record
+// on a resync.
+func (s *NodePortSRV) sync(trigger string) error {
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ctx = correlation.With(context.WithValue(ctx, triggerKey{}, trigger), correlation.New())
+	ctx, span := tracing.Start(ctx, "reconcile.nodeports."+trigger)
+	defer span.End()
+	l := zap.L().Named("nodeports").With(correlation.Field(ctx))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.published == nil {
+		s.published = make(map[string]int32)
+	}
+	var names []string
+	for name := range s.Ports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var result error
+	refused := false
+	if s.Guard != nil {
+		var removed int
+		for _, name := range names {
+			if s.published[name] != 0 && s.nodePort(s.Ports[name]) == 0 {
+				removed++
+			}
+		}
+		if removed > 0 {
+			if err := s.Guard(len(s.published), removed); err != nil {
+				result, refused = err, true
+			}
+		}
+	}
+	for _, name := range names {
+		p := s.Ports[name]
+		port := s.nodePort(p)
+		if port == s.published[name] && trigger != "resync" {
+			continue
+		}
+		if refused && port == 0 {
+			continue
+		}
+		if err := s.Publish(ctx, name, port); err != nil {
+			if errors.Is(err, ErrDeferred) {
+				continue
+			}
+			if result == nil {
+				result = fmt.Errorf("publish %s: %w", name, err)
+			}
+			continue
+		}
+		l.Info("published nodeport srv record", zap.String("record", name), zap.Stringer("service_port", p), zap.Int32("node_port", port))
+		if port == 0 {
+			delete(s.published, name)
+		} else {
+			s.published[name] = port
+		}
+	}
+	nodePortSRVRecords.Set(float64(len(s.published)))
+	if result != nil {
+		tracing.Fail(span, result)
+		l.Error("problem publishing nodeport srv records; they'll be tried again at the next change or resync", zap.Error(result))
+	}
+	return result
+}
+
+// Published returns the names of the SRV records that currently point at a NodePort, sorted.
+func (s *NodePortSRV) Published() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []string
+	for name := range s.published {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// set records the Service, if one of the configured ports belongs to it.  The caller must hold the
+// lock.
+func (s *NodePortSRV) set(obj interface{}) {
+	svc, ok := obj.(*v1.Service)
+	if !ok {
+		zap.L().Error("wrong-type object", zap.Any("obj", obj))
+		return
+	}
+	if !s.wanted(svc) {
+		return
+	}
+	if s.services == nil {
+		s.services = make(map[string]*v1.Service)
+	}
+	s.services[svc.Namespace+"/"+svc.Name] = svc
+}
+
+// Add implements cache.Store.  Errors are retried at the next resync, so they aren't returned to
+// the reflector.
+func (s *NodePortSRV) Add(obj interface{}) error {
+	s.mu.Lock()
+	s.set(obj)
+	s.mu.Unlock()
+	s.sync("add") // nolint:errcheck
+	return nil
+}
+
+// Update implements cache.Store.
+func (s *NodePortSRV) Update(obj interface{}) error {
+	s.mu.Lock()
+	s.set(obj)
+	s.mu.Unlock()
+	s.sync("update") // nolint:errcheck
+	return nil
+}
+
+// Delete implements cache.Store.
+func (s *NodePortSRV) Delete(obj interface{}) error {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	svc, ok := obj.(*v1.Service)
+	if !ok {
+		zap.L().Error("wrong-type object", zap.Any("obj", obj))
+		return nil
+	}
+	s.mu.Lock()
+	delete(s.services, svc.Namespace+"/"+svc.Name)
+	s.mu.Unlock()
+	s.sync("delete") // nolint:errcheck
+	return nil
+}
+
+// Replace implements cache.Store.
+func (s *NodePortSRV) Replace(objs []interface{}, unusedResourceVersion string) error {
+	s.mu.Lock()
+	s.services = make(map[string]*v1.Service)
+	for _, obj := range objs {
+		s.set(obj)
+	}
+	s.mu.Unlock()
+	s.sync("replace") // nolint:errcheck
+	return nil
+}
+
+// Resync implements cache.Store.  Every record is published again, and the records of ports
+// without a NodePort are removed, in case they were left behind by an earlier run.
+func (s *NodePortSRV) Resync() error {
+	return s.sync("resync")
+}
+
+// These are unused by the reflector.
+func (s *NodePortSRV) List() []interface{} { return nil }
+func (s *NodePortSRV) ListKeys() []string  { return nil }
+func (s *NodePortSRV) Get(obj interface{}) (item interface{}, exists bool, err error) {
+	return nil, false, errors.New("unimplemented")
+}
+func (s *NodePortSRV) GetByKey(key string) (item interface{}, exists bool, err error) {
+	return nil, false, errors.New("unimplemented")
+}
@@ -0,0 +1,87 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseServicePort(t *testing.T) {
+	testData := []struct {
+		in      string
+		want    ServicePort
+		wantErr bool
+	}{
+		{in: "default/web/https", want: ServicePort{Namespace: "default", Service: "web", Port: "https"}},
+		{in: "default/web/443", want: ServicePort{Namespace: "default", Service: "web", Port: "443"}},
+		{in: "web/https", wantErr: true},
+		{in: "default//https", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, test := range testData {
+		got, err := ParseServicePort(test.in)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: error:\n  got: %v\n want error: %v", test.in, err, test.wantErr)
+		}
+		if diff := cmp.Diff(got, test.want); diff != "" {
+			t.Errorf("%q: port:\n%s", test.in, diff)
+		}
+	}
+}
+
+func TestNodePortSRV(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	var got []string
+	s := &NodePortSRV{
+		Ports: map[string]ServicePort{
+			"_https._tcp.nodes.example.com": {Namespace: "default", Service: "web", Port: "https"},
+			"_ssh._tcp.nodes.example.com":   {Namespace: "git", Service: "ssh", Port: "22"},
+		},
+		Publish: func(ctx context.Context, name string, port int32) error {
+			got = append(got, fmt.Sprintf("%s %d", name, port))
+			return nil
+		},
+	}
+	service := func(namespace, name string, ports ...v1.ServicePort) *v1.Service {
+		return &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Spec:       v1.ServiceSpec{Type: v1.ServiceTypeNodePort, Ports: ports},
+		}
+	}
+
+	s.Replace([]interface{}{ // nolint:errcheck
+		service("default", "web", v1.ServicePort{Name: "http", Port: 80, NodePort: 30080}, v1.ServicePort{Name: "https", Port: 443, NodePort: 30443}),
+		service("default", "unrelated", v1.ServicePort{Name: "https", Port: 443, NodePort: 31443}),
+	}, "")
+	// The ssh Service appears later.
+	s.Add(service("git", "ssh", v1.ServicePort{Name: "ssh", Port: 22, NodePort: 30022})) // nolint:errcheck
+	// An update that doesn't change the NodePort changes nothing.
+	s.Update(service("default", "web", v1.ServicePort{Name: "https", Port: 443, NodePort: 30443})) // nolint:errcheck
+	// The NodePort changes.
+	s.Update(service("default", "web", v1.ServicePort{Name: "https", Port: 443, NodePort: 32443})) // nolint:errcheck
+	// The Service goes away.
+	s.Delete(service("git", "ssh")) // nolint:errcheck
+	s.Resync()                      // nolint:errcheck
+
+	want := []string{
+		"_https._tcp.nodes.example.com 30443",
+		"_ssh._tcp.nodes.example.com 30022",
+		"_https._tcp.nodes.example.com 32443",
+		"_ssh._tcp.nodes.example.com 0",
+		"_https._tcp.nodes.example.com 32443",
+		"_ssh._tcp.nodes.example.com 0",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("published:\n%s", diff)
+	}
+	if diff := cmp.Diff(s.Published(), []string{"_https._tcp.nodes.example.com"}); diff != "" {
+		t.Errorf("published records:\n%s", diff)
+	}
+}
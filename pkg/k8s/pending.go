@@ -0,0 +1,143 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+// pendingUpdate is a record that a sink hasn't successfully updated, as saved in PendingPath.
+type pendingUpdate struct {
+	Sink string `json:"sink"`
+	Kind Kind   `json:"kind"`
+}
+
+// markPending records whether the sink's update of the record of the provided kind is pending,
+// and saves the pending updates to PendingPath if that changed.  The lock must be held.
+func (s *NodeStore) markPending(key retryKey, pending bool) {
+	if _, ok := s.pending[key]; ok == pending {
+		return
+	}
+	if pending {
+		s.pending[key] = struct{}{}
+	} else {
+		delete(s.pending, key)
+	}
+	recordsPending.WithLabelValues(s.Name).Set(float64(len(s.pending)))
+	if s.PendingPath == "" {
+		return
+	}
+	if err := s.savePending(); err != nil {
+		s.Logger.Warn("problem saving pending updates", zap.String("path", s.PendingPath), zap.Error(err))
+	}
+}
+
+// savePending atomically replaces the contents of PendingPath with the pending updates.  The lock
+// must be held.
+func (s *NodeStore) savePending() error {
+	result := make([]pendingUpdate, 0, len(s.pending))
+	for key := range s.pending {
+		result = append(result, pendingUpdate{Sink: key.sink, Kind: key.kind})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Sink != result[j].Sink {
+			return result[i].Sink < result[j].Sink
+		}
+		return result[i].Kind < result[j].Kind
+	})
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.PendingPath), "."+filepath.Base(s.PendingPath)+".*")
+	if err != nil {
+		return fmt.Errorf("create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.PendingPath); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	return nil
+}
+
+// LoadPending reads the updates that were pending when the store last ran from PendingPath.  They
+// are retried, with the records' current contents, after the store is first filled by Replace or
+// resynced.  A missing file is not an error.
+func (s *NodeStore) LoadPending() error {
+	data, err := ioutil.ReadFile(s.PendingPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read pending updates: %w", err)
+	}
+	var pending []pendingUpdate
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return fmt.Errorf("unmarshal pending updates: %w", err)
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.restored = make(map[retryKey]struct{}, len(pending))
+	for _, p := range pending {
+		key := retryKey{sink: p.Sink, kind: p.Kind}
+		s.restored[key] = struct{}{}
+		// Until they're retried, they're still pending.
+		s.pending[key] = struct{}{}
+	}
+	return nil
+}
+
+// retryRestored retries the updates loaded by LoadPending, unless they've been updated since.
+func (s *NodeStore) retryRestored(ctx context.Context) {
+	s.Lock()
+	restored := s.restored
+	s.restored = nil
+	sinks := s.subscribers()
+	subscribed := make(map[string]bool, len(sinks))
+	for _, sink := range sinks {
+		subscribed[sink.Name()] = true
+	}
+	var retry []retryKey
+	for key := range restored {
+		if !subscribed[key.sink] {
+			// The sink is no longer configured.
+			s.markPending(key, false)
+			continue
+		}
+		if _, ok := s.pending[key]; ok {
+			if _, ok := s.retries[key]; !ok {
+				retry = append(retry, key)
+			}
+		}
+	}
+	req := UpdateRequest{Nodes: s.exportedNodes()}
+	records := make(map[Kind]Record)
+	for _, key := range retry {
+		records[key.kind] = s.record(key.kind)
+	}
+	s.Unlock()
+	for _, key := range retry {
+		for _, sink := range sinks {
+			if sink.Name() != key.sink {
+				continue
+			}
+			s.Logger.Info("retrying update that was pending at the last shutdown", zap.String("sink", key.sink), zap.String("kind", string(key.kind)))
+			req.Record = records[key.kind]
+			s.update(ctx, sink, req)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package k8s
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPending(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	path := filepath.Join(t.TempDir(), "test.json")
+	readPending := func() string {
+		t.Helper()
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read pending: %v", err)
+		}
+		return string(data)
+	}
+
+	// The first instance fails to remove the node's address, and then exits.
+	before := NewNodeStore("test")
+	before.Logger = l
+	before.PendingPath = path
+	failing := false
+	before.Subscribe(SinkFunc("dns", func(req UpdateRequest) error {
+		if failing {
+			return errors.New("injected error")
+		}
+		return nil
+	}))
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "host-1"},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.1"}},
+		},
+	}
+	before.Add(node)
+	failing = true
+	before.Delete(node)
+	if got, want := readPending(), `[{"sink":"dns","kind":"internal"}]`; got != want {
+		t.Errorf("pending after failure:\n  got: %v\n want: %v", got, want)
+	}
+
+	// The next instance starts with no nodes, so nothing changes, but the pending update must
+	// still be retried.
+	after := NewNodeStore("test")
+	after.Logger = l
+	after.PendingPath = path
+	if err := after.LoadPending(); err != nil {
+		t.Fatalf("load pending: %v", err)
+	}
+	var got []Record
+	after.Subscribe(SinkFunc("dns", func(req UpdateRequest) error {
+		if got, want := req.Trigger, "replace"; got != want {
+			t.Errorf("trigger:\n  got: %v\n want: %v", got, want)
+		}
+		got = append(got, req.Record)
+		return nil
+	}))
+	after.Replace(nil, "")
+	if diff := cmp.Diff(got, []Record{{Kind: Internal}}, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("retried records:\n%s", diff)
+	}
+	if got, want := readPending(), `[]`; got != want {
+		t.Errorf("pending after retry:\n  got: %v\n want: %v", got, want)
+	}
+
+}
@@ -0,0 +1,127 @@
+package k8s
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"text/template"
+
+	"go.uber.org/zap"
+)
+
+// PerNodeFuncs are the functions available to per-node record templates, in addition to the
+// standard ones.
+var PerNodeFuncs = template.FuncMap{
+	// short returns the first label of a name, for nodes named like ip-10-0-0-1.ec2.internal.
+	"short": func(name string) string { return strings.SplitN(name, ".", 2)[0] },
+}
+
+// ParsePerNodeTemplate parses a template that names a node's own record, like
+// "{{.Name}}.nodes.example.com".  It's executed with the Node.
+func ParsePerNodeTemplate(text string) (*template.Template, error) {
+	return template.New("per-node").Funcs(PerNodeFuncs).Option("missingkey=error").Parse(text)
+}
+
+// PerNode is a Sink that publishes a record for each node, containing the node's own addresses of
+// one kind, in addition to the records of every node.  A node's record is emptied when it stops
+// being published, because it was deleted, stopped being Ready, and so on.
+type PerNode struct {
+	Template *template.Template // Names each node's record; see ParsePerNodeTemplate.
+	Kind     Kind               // The kind of addresses to publish.
+
+	recordPublisher // Set Publish, and optionally Guard, before subscribing it.
+}
+
+var _ Sink = (*PerNode)(nil)
+
+// Name implements Sink.
+func (p *PerNode) Name() string { return "per_node" }
+
+// RecordName returns the name of a node's record.
+func (p *PerNode) RecordName(n Node) (string, error) {
+	var buf bytes.Buffer
+	if err := p.Template.Execute(&buf, n); err != nil {
+		return "", err
+	}
+	name := strings.TrimSpace(buf.String())
+	if name == "" {
+		return "", fmt.Errorf("template produced an empty name for node %s", n.Name)
+	}
+	return name, nil
+}
+
+// seedName is the name of the node that Matches executes the template with.  Its first label is
+// what {{short .Name}} produces.
+const seedName = "x0nodedns0x.y0nodedns0y"
+
+// Matches returns true if the name could be the record of some node: the template's output for a
+// node with a different name.  Templates that fail without labels or other fields match nothing.
+func (p *PerNode) Matches(name string) bool {
+	var buf bytes.Buffer
+	if err := p.Template.Execute(&buf, Node{Name: seedName}); err != nil {
+		return false
+	}
+	out := strings.ToLower(strings.TrimSpace(buf.String()))
+	name = strings.ToLower(name)
+	full := true
+	i := strings.Index(out, seedName)
+	if i < 0 {
+		full = false
+		i = strings.Index(out, strings.SplitN(seedName, ".", 2)[0])
+	}
+	if i < 0 {
+		return false
+	}
+	prefix, suffix := out[:i], out[i+len(seedName):]
+	if !full {
+		suffix = out[i+strings.Index(seedName, "."):]
+	}
+	if len(name) <= len(prefix)+len(suffix) || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return false
+	}
+	middle := name[len(prefix) : len(name)-len(suffix)]
+	return full || !strings.Contains(middle, ".")
+}
+
+// Seed adds records that may have been published before, like by an earlier run, and that match
+// the template, to the records that the sink has published; see recordPublisher.seed.  It returns
+// the names that were added.
+func (p *PerNode) Seed(names []string) []string {
+	return p.seed(names, p.Matches)
+}
+
+// addresses returns the node's addresses of the kind that the sink publishes.
+func (p *PerNode) addresses(n Node) []net.IP {
+	result := n.Addresses(p.Kind)
+	sort.Slice(result, func(i, j int) bool { return bytes.Compare(result[i].To16(), result[j].To16()) < 0 })
+	return result
+}
+
+// Update implements Sink.  Every node's record is considered whenever the record of the sink's
+// kind changes; only records whose addresses changed are published, except on resyncs.  It
+// returns the first error encountered, after attempting to publish every record; failed records
+// are tried again on the next update.
+func (p *PerNode) Update(req UpdateRequest) error {
+	if req.Record.Kind != p.Kind {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l := zap.L().Named("per-node")
+	desired := make(map[string][]net.IP)
+	for _, n := range req.Nodes {
+		ips := p.addresses(n)
+		if len(ips) == 0 {
+			continue
+		}
+		name, err := p.RecordName(n)
+		if err != nil {
+			l.Warn("not publishing a record for node", zap.String("node", n.Name), zap.Error(err))
+			continue
+		}
+		desired[name] = append(desired[name], ips...)
+	}
+	return p.publishChanged(req.Ctx, req.Trigger, desired)
+}
@@ -0,0 +1,110 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPerNode(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	tmpl, err := ParsePerNodeTemplate("{{short .Name}}.nodes.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	fail := false
+	p := &PerNode{Template: tmpl, Kind: External}
+	p.Publish = func(ctx context.Context, name string, ips []net.IP) error {
+		if fail {
+			return errors.New("injected error")
+		}
+		got = append(got, name+" "+fmt.Sprint(ips))
+		return nil
+	}
+	ns := NewNodeStore("test")
+	ns.Subscribe(p)
+	node := func(name, internal, external string) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: internal}, {Type: v1.NodeExternalIP, Address: external}},
+			},
+		}
+	}
+
+	ns.Add(node("host-1.ec2.internal", "10.0.0.1", "42.0.0.1"))
+	ns.Add(node("host-2.ec2.internal", "10.0.0.2", "42.0.0.2"))
+	// Only the internal address changes, so the per-node records don't.
+	ns.Update(node("host-1.ec2.internal", "10.0.0.3", "42.0.0.1"))
+	ns.Update(node("host-2.ec2.internal", "10.0.0.2", "42.0.0.3"))
+	// A failed removal is tried again on the next update.
+	fail = true
+	ns.Delete(node("host-1.ec2.internal", "10.0.0.3", "42.0.0.1"))
+	fail = false
+	ns.Resync() // nolint:errcheck
+
+	want := []string{
+		"host-1.nodes.example.com [42.0.0.1]",
+		"host-2.nodes.example.com [42.0.0.2]",
+		"host-2.nodes.example.com [42.0.0.3]",
+		"host-1.nodes.example.com []",
+		"host-2.nodes.example.com [42.0.0.3]",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("published:\n%s", diff)
+	}
+	if diff := cmp.Diff(p.Published(), []string{"host-2.nodes.example.com"}); diff != "" {
+		t.Errorf("published records:\n%s", diff)
+	}
+}
+
+func TestParsePerNodeTemplate(t *testing.T) {
+	if _, err := ParsePerNodeTemplate("{{.Name"); err == nil {
+		t.Error("expected error for unterminated action")
+	}
+	tmpl, err := ParsePerNodeTemplate("{{.Nope}}.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&PerNode{Template: tmpl}).RecordName(Node{Name: "host-1"}); err == nil {
+		t.Error("expected error for unknown field")
+	}
+}
+
+func TestPerNodeMatches(t *testing.T) {
+	testData := []struct {
+		template string
+		name     string
+		want     bool
+	}{
+		{template: "{{.Name}}.nodes.example.com", name: "host-1.nodes.example.com", want: true},
+		{template: "{{.Name}}.nodes.example.com", name: "host-1.us-east-1.internal.nodes.example.com", want: true},
+		{template: "{{.Name}}.nodes.example.com", name: "Host-1.Nodes.Example.com", want: true},
+		{template: "{{.Name}}.nodes.example.com", name: "nodes.example.com"},
+		{template: "{{.Name}}.nodes.example.com", name: "host-1.example.com"},
+		{template: "{{short .Name}}.nodes.example.com", name: "host-1.nodes.example.com", want: true},
+		{template: "{{short .Name}}.nodes.example.com", name: "host-1.internal.nodes.example.com"},
+		{template: "node-{{.Name}}.example.com", name: "node-host-1.example.com", want: true},
+		{template: "node-{{.Name}}.example.com", name: "host-1.example.com"},
+		{template: `{{index .Labels "pool"}}.example.com`, name: "pool.example.com"},
+	}
+	for _, test := range testData {
+		tmpl, err := ParsePerNodeTemplate(test.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := (&PerNode{Template: tmpl}).Matches(test.name); got != test.want {
+			t.Errorf("%s matches %s:\n  got: %v\n want: %v", test.template, test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// parseAddresses parses a comma-separated list of addresses, logging and skipping invalid ones.
+func parseAddresses(list string, fields ...zap.Field) []net.IP {
+	var result []net.IP
+	for _, addr := range strings.Split(list, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		parsed := net.ParseIP(addr)
+		if parsed == nil {
+			zap.L().Warn("invalid pinned address", append(fields, zap.String("address", addr))...)
+			continue
+		}
+		result = append(result, parsed)
+	}
+	return result
+}
+
+// pins returns the addresses pinned by the node's PinAnnotation, by kind.  Each address may be
+// prefixed with the kind of record that it's pinned in, like "internal:10.0.0.5"; it's otherwise
+// pinned in the External record.
+func (s *NodeStore) pins(n *v1.Node) map[Kind][]net.IP {
+	if s.PinAnnotation == "" {
+		return nil
+	}
+	ann, ok := n.GetAnnotations()[s.PinAnnotation]
+	if !ok {
+		return nil
+	}
+	result := make(map[Kind][]net.IP)
+	for _, addr := range strings.Split(ann, ",") {
+		kind := External
+		addr = strings.TrimSpace(addr)
+		if i := strings.Index(addr, ":"); i > 0 {
+			switch k := Kind(addr[:i]); k {
+			case Internal, External, Overlay:
+				kind, addr = k, addr[i+1:]
+			}
+		}
+		result[kind] = append(result[kind], parseAddresses(addr, zap.String("node", n.GetName()), zap.String("annotation", s.PinAnnotation))...)
+	}
+	return result
+}
+
+// SetPinned sets the addresses that are always published, regardless of the state of any node,
+// by kind.  They replace the addresses from any previous call.
+func (s *NodeStore) SetPinned(pinned map[Kind][]net.IP) {
+	ctx, c := s.startOp("pinned")
+	defer c()
+	s.Lock()
+	m := make(mutation)
+	for kind, ips := range s.pinned {
+		s.contribute(m, kind, ips, -1)
+	}
+	for kind, ips := range pinned {
+		s.contribute(m, kind, ips, 1)
+	}
+	s.pinned = pinned
+	changes := s.changed(m)
+	s.Unlock()
+	s.notify(ctx, changes)
+}
+
+// PinnedFor returns the addresses that the data of a pinned-address ConfigMap pins in the named
+// store's records.  Keys are "<store>.<kind>", like "ingress.external", or just "<kind>" for the
+// "main" store; values are comma-separated addresses.
+func PinnedFor(data map[string]string, store string) map[Kind][]net.IP {
+	result := make(map[Kind][]net.IP)
+	for key, list := range data {
+		name, kind := "main", key
+		if i := strings.LastIndex(key, "."); i >= 0 {
+			name, kind = key[:i], key[i+1:]
+		}
+		if name != store {
+			continue
+		}
+		switch Kind(kind) {
+		case Internal, External, Overlay:
+		default:
+			zap.L().Warn("unknown record kind in pinned addresses", zap.String("key", key))
+			continue
+		}
+		result[Kind(kind)] = append(result[Kind(kind)], parseAddresses(list, zap.String("key", key))...)
+	}
+	return result
+}
+
+// pinnedStore is a cache.Store that watches a ConfigMap of pinned addresses, and sets them in a
+// set of NodeStores.
+type pinnedStore struct {
+	stores []*NodeStore
+}
+
+func (s *pinnedStore) set(data map[string]string) {
+	for _, st := range s.stores {
+		st.SetPinned(PinnedFor(data, st.Name))
+	}
+}
+
+func (s *pinnedStore) handle(obj interface{}) {
+	cm, ok := obj.(*v1.ConfigMap)
+	if !ok {
+		zap.L().Error("wrong-type object", zap.Any("obj", obj))
+		return
+	}
+	s.set(cm.Data)
+}
+
+// Add implements cache.Store.
+func (s *pinnedStore) Add(obj interface{}) error {
+	s.handle(obj)
+	return nil
+}
+
+// Update implements cache.Store.
+func (s *pinnedStore) Update(obj interface{}) error {
+	s.handle(obj)
+	return nil
+}
+
+// Delete implements cache.Store.
+func (s *pinnedStore) Delete(obj interface{}) error {
+	s.set(nil)
+	return nil
+}
+
+// Replace implements cache.Store.
+func (s *pinnedStore) Replace(objs []interface{}, unusedResourceVersion string) error {
+	if len(objs) == 0 {
+		s.set(nil)
+	}
+	for _, obj := range objs {
+		s.handle(obj)
+	}
+	return nil
+}
+
+// Resync implements cache.Store.
+func (s *pinnedStore) Resync() error { return nil }
+
+// These are unused by the reflector.
+func (s *pinnedStore) List() []interface{} { return nil }
+func (s *pinnedStore) ListKeys() []string  { return nil }
+func (s *pinnedStore) Get(obj interface{}) (item interface{}, exists bool, err error) {
+	return nil, false, errors.New("unimplemented")
+}
+func (s *pinnedStore) GetByKey(key string) (item interface{}, exists bool, err error) {
+	return nil, false, errors.New("unimplemented")
+}
+
+// WatchPinned watches the named ConfigMap, and keeps the addresses that it pins (see PinnedFor)
+// published in the provided stores' records.  If the ConfigMap doesn't exist, nothing is pinned.
+func WatchPinned(ctx context.Context, master, kubeconfig, namespace, name string, resync time.Duration, stores []*NodeStore) error {
+	config, err := restConfig(master, kubeconfig)
+	if err != nil {
+		return err
+	}
+	return WatchPinnedWithConfig(ctx, config, namespace, name, resync, stores)
+}
+
+// WatchPinnedWithConfig is like WatchPinned, but connects to the API server described by config.
+func WatchPinnedWithConfig(ctx context.Context, config *rest.Config, namespace, name string, resync time.Duration, stores []*NodeStore) error {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("kubernetes: new client: %w", err)
+	}
+	lw := cache.NewListWatchFromClient(clientset.CoreV1().RESTClient(), "configmaps", namespace, fields.OneTermEqualSelector("metadata.name", name))
+	r := cache.NewReflector(lw, &v1.ConfigMap{}, &pinnedStore{stores: stores}, resync)
+	r.Run(ctx.Done())
+	return nil
+}
@@ -0,0 +1,129 @@
+package k8s
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPinAnnotation(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	ns.PinAnnotation = "example.com/pinned"
+	var got []Record
+	ns.OnChange = func(req UpdateRequest) error {
+		got = append(got, req.Record)
+		return nil
+	}
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "legacy",
+			Annotations: map[string]string{"example.com/pinned": "203.0.113.1, internal:10.0.0.9, invalid"},
+		},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionFalse}},
+			Addresses:  []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "42.0.0.1"}},
+		},
+	}
+	ns.Replace([]interface{}{node}, "")
+	want := []Record{
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 9)}},
+		{Kind: External, IPs: []net.IP{net.IPv4(203, 0, 113, 1)}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("not ready:\n%s", diff)
+	}
+
+	got = nil
+	ready := node.DeepCopy()
+	ready.Status.Conditions[0].Status = v1.ConditionTrue
+	ns.Update(ready)
+	want = []Record{
+		{Kind: External, IPs: []net.IP{net.IPv4(203, 0, 113, 1), net.IPv4(42, 0, 0, 1)}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("ready:\n%s", diff)
+	}
+
+	got = nil
+	ns.Delete(ready)
+	want = []Record{
+		{Kind: Internal, IPs: []net.IP{}},
+		{Kind: External, IPs: []net.IP{}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("deleted:\n%s", diff)
+	}
+}
+
+func TestSetPinned(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	var got []Record
+	ns.OnChange = func(req UpdateRequest) error {
+		got = append(got, req.Record)
+		return nil
+	}
+	ns.Replace([]interface{}{
+		&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "host-1"},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "42.0.0.1"}},
+			},
+		},
+	}, "")
+
+	got = nil
+	ns.SetPinned(map[Kind][]net.IP{External: {net.IPv4(203, 0, 113, 1), net.IPv4(42, 0, 0, 1)}})
+	want := []Record{
+		{Kind: External, IPs: []net.IP{net.IPv4(203, 0, 113, 1), net.IPv4(42, 0, 0, 1)}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("pinned:\n%s", diff)
+	}
+
+	got = nil
+	ns.SetPinned(map[Kind][]net.IP{External: {net.IPv4(203, 0, 113, 1), net.IPv4(42, 0, 0, 1)}})
+	if len(got) != 0 {
+		t.Errorf("unchanged pins: unexpected updates: %v", got)
+	}
+
+	got = nil
+	ns.SetPinned(nil)
+	want = []Record{
+		{Kind: External, IPs: []net.IP{net.IPv4(42, 0, 0, 1)}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unpinned:\n%s", diff)
+	}
+}
+
+func TestPinnedFor(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	data := map[string]string{
+		"external":         "203.0.113.1",
+		"ingress.internal": "10.0.0.1, invalid",
+		"ingress.bogus":    "10.0.0.2",
+	}
+	testData := []struct {
+		store string
+		want  map[Kind][]net.IP
+	}{
+		{store: "main", want: map[Kind][]net.IP{External: {net.IPv4(203, 0, 113, 1)}}},
+		{store: "ingress", want: map[Kind][]net.IP{Internal: {net.IPv4(10, 0, 0, 1)}}},
+		{store: "other", want: map[Kind][]net.IP{}},
+	}
+	for _, test := range testData {
+		if diff := cmp.Diff(PinnedFor(data, test.store), test.want); diff != "" {
+			t.Errorf("%s:\n%s", test.store, diff)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// recordPublisher publishes records whose names depend on the nodes or Services, like PerNode's,
+// and remembers what it published, so that only the records that changed are published again and
+// records that nothing needs any more are emptied.  It's embedded in those sinks.
+type recordPublisher struct {
+	// Publish replaces the addresses in the named record.  An empty list of addresses removes
+	// the record.
+	Publish func(ctx context.Context, name string, ips []net.IP) error
+	// Guard, if set, is called before records are emptied, with how many records are published
+	// and how many would be emptied; an error leaves them all alone, and is returned, so that
+	// they're tried again at the next update.  See dns.Guard.CheckRemovals.
+	Guard func(published, removed int) error
+
+	mu        sync.Mutex
+	published map[string][]net.IP // The addresses last published in each record, by name.
+}
+
+// Published returns the names of the records that currently contain addresses, sorted.
+func (r *recordPublisher) Published() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var result []string
+	for name := range r.published {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// seed adds records that may have been published before, like by an earlier run, to the records
+// that have been published, with unknown (nil) addresses; the next update publishes them again,
+// or empties them if nothing needs them any more.  Names that don't match are ignored.  It returns
+// the names that were added.
+func (r *recordPublisher) seed(names []string, matches func(string) bool) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.published == nil {
+		r.published = make(map[string][]net.IP)
+	}
+	var result []string
+	for _, name := range names {
+		if _, ok := r.published[name]; ok || !matches(name) {
+			continue
+		}
+		r.published[name] = nil
+		result = append(result, name)
+	}
+	return result
+}
+
+// publishChanged publishes each record in desired whose addresses differ from those last
+// published, or every record on a resync, and empties the published records that aren't in
+// desired, unless the guard refuses.  Seeded records are always published.  What was published is
+// updated to match, except for records whose publishing was deferred.  It returns the first error
+// encountered, after attempting to publish every record.  The caller must hold the lock.
+func (r *recordPublisher) publishChanged(ctx context.Context, trigger string, desired map[string][]net.IP) error {
+	if r.published == nil {
+		r.published = make(map[string][]net.IP)
+	}
+	var names []string
+	for name := range desired {
+		names = append(names, name)
+	}
+	for name := range r.published {
+		if _, ok := desired[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var result error
+	if removed := len(names) - len(desired); r.Guard != nil && removed > 0 {
+		if err := r.Guard(len(r.published), removed); err != nil {
+			result = err
+			names = names[:0]
+			for name := range desired {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		}
+	}
+	for _, name := range names {
+		ips := desired[name]
+		old, ok := r.published[name]
+		if ok && old != nil && equalIPs(old, ips) && trigger != "resync" {
+			continue
+		}
+		if err := r.Publish(ctx, name, ips); err != nil {
+			if errors.Is(err, ErrDeferred) {
+				continue
+			}
+			if result == nil {
+				result = fmt.Errorf("publish %s: %w", name, err)
+			}
+			continue
+		}
+		zap.L().Debug("published record", zap.String("record", name), zap.Any("addresses", ips))
+		if len(ips) == 0 {
+			delete(r.published, name)
+		} else {
+			r.published[name] = ips
+		}
+	}
+	return result
+}
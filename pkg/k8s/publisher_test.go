@@ -0,0 +1,92 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPublishChangedGuard(t *testing.T) {
+	ctx := context.Background()
+	var got []string
+	publish := func(ctx context.Context, name string, ips []net.IP) error {
+		got = append(got, name+" "+fmt.Sprint(ips))
+		return nil
+	}
+	guard := func(published, removed int) error {
+		if removed > published/2 {
+			return fmt.Errorf("refusing to remove %d of %d", removed, published)
+		}
+		return nil
+	}
+	ip := func(s string) []net.IP { return []net.IP{net.ParseIP(s)} }
+	r := &recordPublisher{Publish: publish, Guard: guard}
+	r.published = map[string][]net.IP{"a": ip("10.0.0.1"), "b": ip("10.0.0.2"), "c": ip("10.0.0.3")}
+
+	// Every node seems to disappear, and a new one appears; only the new one is published.
+	if err := r.publishChanged(ctx, "update", map[string][]net.IP{"d": ip("10.0.0.4")}); err == nil {
+		t.Error("removing 3 of 3: expected error")
+	}
+	// One node goes away.
+	if err := r.publishChanged(ctx, "update", map[string][]net.IP{"a": ip("10.0.0.1"), "b": ip("10.0.0.2"), "d": ip("10.0.0.4")}); err != nil {
+		t.Errorf("removing 1 of 4: %v", err)
+	}
+	want := []string{"d [10.0.0.4]", "c []"}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("published:\n%s", diff)
+	}
+	if got, want := len(r.published), 3; got != want {
+		t.Errorf("published records:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+func TestPublishChangedSeededAndDeferred(t *testing.T) {
+	ctx := context.Background()
+	var got []string
+	deferred := false
+	publish := func(ctx context.Context, name string, ips []net.IP) error {
+		if deferred {
+			return ErrDeferred
+		}
+		got = append(got, name+" "+fmt.Sprint(ips))
+		return nil
+	}
+	ip := func(s string) []net.IP { return []net.IP{net.ParseIP(s)} }
+	tmpl, err := ParsePerNodeTemplate("{{.Name}}.nodes.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &PerNode{Template: tmpl}
+	p.Publish = publish
+	seeded := p.Seed([]string{"a.nodes.example.com", "b.nodes.example.com", "nodes.example.com"})
+	if diff := cmp.Diff(seeded, []string{"a.nodes.example.com", "b.nodes.example.com"}); diff != "" {
+		t.Errorf("seeded:\n%s", diff)
+	}
+
+	// While updates are deferred, nothing is published and the seeded records are kept.
+	deferred = true
+	desired := map[string][]net.IP{"a.nodes.example.com": ip("10.0.0.1")}
+	if err := p.publishChanged(ctx, "update", desired); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(p.published), 2; got != want {
+		t.Errorf("published records after deferring:\n  got: %v\n want: %v", got, want)
+	}
+
+	// Once they aren't, the seeded record of a live node is published even though the addresses
+	// are unknown, and the other is emptied.
+	deferred = false
+	if err := p.publishChanged(ctx, "update", desired); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a.nodes.example.com [10.0.0.1]", "b.nodes.example.com []"}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("published:\n%s", diff)
+	}
+	if diff := cmp.Diff(p.Published(), []string{"a.nodes.example.com"}); diff != "" {
+		t.Errorf("published records:\n%s", diff)
+	}
+}
@@ -0,0 +1,79 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// RecordConfigMap keeps the addresses in the records of a set of stores in a ConfigMap, for
+// workloads that need the nodes' addresses but can't rely on DNS.  Keys are like the keys of a
+// pinned-address ConfigMap (see PinnedFor): "<store>.<kind>", like "ingress.external", or just
+// "<kind>" for the "main" store; values are comma-separated addresses.
+type RecordConfigMap struct {
+	Namespace, Name string
+	Enabled         func() bool // If non-nil and false, nothing is written; for replicas that aren't the leader.
+
+	client kubernetes.Interface
+	mu     sync.Mutex // Held while the ConfigMap is written, so that updates of different records don't conflict.
+}
+
+// NewRecordConfigMap returns a RecordConfigMap that writes the named ConfigMap.  Subscribe each
+// store to the result of Sink.
+func NewRecordConfigMap(client kubernetes.Interface, namespace, name string) *RecordConfigMap {
+	return &RecordConfigMap{Namespace: namespace, Name: name, client: client}
+}
+
+// RecordConfigMapKey returns the key that the record of the provided kind in the named store is
+// kept in.
+func RecordConfigMapKey(store string, kind Kind) string {
+	if store == "main" {
+		return string(kind)
+	}
+	return store + "." + string(kind)
+}
+
+// Sink returns a sink that writes the records of the named store to the ConfigMap.
+func (c *RecordConfigMap) Sink(store string) Sink {
+	return SinkFunc("configmap", func(req UpdateRequest) error {
+		if c.Enabled != nil && !c.Enabled() {
+			return nil
+		}
+		return c.write(req.Ctx, RecordConfigMapKey(store, req.Record.Kind), strings.Join(ipStrings(req.Record.IPs), ","))
+	})
+}
+
+// write sets the key of the ConfigMap to value, creating the ConfigMap if it doesn't exist.
+func (c *RecordConfigMap) write(ctx context.Context, key, value string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	configMaps := c.client.CoreV1().ConfigMaps(c.Namespace)
+	cm, err := configMaps.Get(ctx, c.Name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		cm = &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: c.Namespace, Name: c.Name}, Data: map[string]string{key: value}}
+		if _, err := configMaps.Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("create configmap: %w", err)
+		}
+		return nil
+	case err != nil:
+		return fmt.Errorf("get configmap: %w", err)
+	}
+	if old, ok := cm.Data[key]; ok && old == value {
+		return nil
+	}
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[key] = value
+	if _, err := configMaps.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("update configmap: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package k8s
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRecordConfigMap(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ctx := context.Background()
+	client := fake.NewSimpleClientset()
+	c := NewRecordConfigMap(client, "kube-system", "nodedns-records")
+	enabled := true
+	c.Enabled = func() bool { return enabled }
+	main, ingress := NewNodeStore("main"), NewNodeStore("ingress")
+	main.Subscribe(c.Sink(main.Name))
+	ingress.Subscribe(c.Sink(ingress.Name))
+
+	node := func(name, internal, external string) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: v1.NodeStatus{
+				Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+				Addresses: []v1.NodeAddress{
+					{Type: v1.NodeInternalIP, Address: internal},
+					{Type: v1.NodeExternalIP, Address: external},
+				},
+			},
+		}
+	}
+	main.Add(node("host-1", "10.0.0.1", "42.0.0.1"))
+	main.Add(node("host-2", "10.0.0.2", "42.0.0.2"))
+	ingress.Add(node("host-3", "10.0.0.3", "42.0.0.3"))
+	ingress.Delete(node("host-3", "10.0.0.3", "42.0.0.3"))
+	// Followers don't write.
+	enabled = false
+	main.Add(node("host-4", "10.0.0.4", "42.0.0.4"))
+
+	cm, err := client.CoreV1().ConfigMaps("kube-system").Get(ctx, "nodedns-records", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get configmap: %v", err)
+	}
+	want := map[string]string{
+		"internal":         "10.0.0.1,10.0.0.2",
+		"external":         "42.0.0.1,42.0.0.2",
+		"ingress.internal": "",
+		"ingress.external": "",
+	}
+	if diff := cmp.Diff(cm.Data, want); diff != "" {
+		t.Errorf("data:\n%s", diff)
+	}
+	// The keys match the keys of a pinned-address ConfigMap.
+	if got, want := PinnedFor(cm.Data, "main")[External], []net.IP{net.ParseIP("42.0.0.1"), net.ParseIP("42.0.0.2")}; !cmp.Equal(got, want) {
+		t.Errorf("pinned:\n  got: %v\n want: %v", got, want)
+	}
+}
@@ -0,0 +1,171 @@
+package k8s
+
+import (
+	"net"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var (
+	rejectedExternal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rejected_external_addresses",
+			Help: "The number of times that a node reported an external address that was left out of the external record, by store and reason.",
+		},
+		[]string{"store", "reason"},
+	)
+	rejectedInternal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rejected_internal_addresses",
+			Help: "The number of times that a node reported a public internal address that was left out of the internal record, by store.",
+		},
+		[]string{"store"},
+	)
+	filteredAddresses = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "filtered_addresses",
+			Help: "The number of times that a node reported an address that was left out of the records by IncludeNetworks or ExcludeNetworks, by store and kind.",
+		},
+		[]string{"store", "kind"},
+	)
+)
+
+// bogons are networks that are never routed on the Internet: special-purpose (RFC 6890),
+// private (RFC 1918, RFC 4193), shared (RFC 6598), documentation, and benchmarking networks,
+// and the unallocated parts of the IPv6 address space.  Loopback, link-local, multicast, and
+// unspecified addresses are checked separately, to give a more useful reason.
+var bogons = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"240.0.0.0/4",
+	"100::/64",
+	"2001:db8::/32",
+	"fc00::/7",
+)
+
+// privateNetworks are the private networks of RFC 1918, and their IPv6 counterpart, unique local
+// addresses (RFC 4193).
+var privateNetworks = mustParseCIDRs("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7")
+
+// globalUnicast is the part of the IPv6 address space that's allocated for global unicast.
+var globalUnicast = mustParseCIDRs("2000::/3")
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	var result []*net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		result = append(result, n)
+	}
+	return result
+}
+
+func inNetworks(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// unroutable returns why the address can't be reached from the Internet, or "" if it can.
+func unroutable(ip net.IP) string {
+	switch {
+	case ip == nil:
+		return "invalid"
+	case ip.IsUnspecified():
+		return "unspecified"
+	case ip.IsLoopback():
+		return "loopback"
+	case ip.IsLinkLocalUnicast():
+		return "link-local"
+	case ip.IsMulticast(), ip.IsInterfaceLocalMulticast(), ip.IsLinkLocalMulticast():
+		return "multicast"
+	case inNetworks(bogons, ip):
+		return "bogon"
+	case ip.To4() == nil && !inNetworks(globalUnicast, ip):
+		return "bogon"
+	}
+	return ""
+}
+
+// rejectExternal returns why an external address reported by a node shouldn't be published, or
+// "" if it should.
+func (s *NodeStore) rejectExternal(ip net.IP) string {
+	if !s.AllowPrivateExternal && ip != nil && inNetworks(privateNetworks, ip) {
+		return "private"
+	}
+	if s.ValidateExternal {
+		if reason := unroutable(ip); reason != "" {
+			return reason
+		}
+	}
+	if len(s.AnnouncedNetworks) > 0 && !inNetworks(s.AnnouncedNetworks, ip) {
+		return "unannounced"
+	}
+	return ""
+}
+
+// validExternal returns the node's external addresses that should be published, logging and
+// counting the rest.
+func (s *NodeStore) validExternal(node string, ips []net.IP) []net.IP {
+	var result []net.IP
+	for _, ip := range ips {
+		if reason := s.rejectExternal(ip); reason != "" {
+			rejectedExternal.WithLabelValues(s.Name, reason).Inc()
+			zap.L().Warn("not publishing invalid external address", zap.String("store", s.Name), zap.String("node", node), zap.Stringer("address", ip), zap.String("reason", reason))
+			continue
+		}
+		result = append(result, ip)
+	}
+	return result
+}
+
+// filterNetworks returns the node's addresses of the provided kind that IncludeNetworks and
+// ExcludeNetworks allow, logging and counting the rest.
+func (s *NodeStore) filterNetworks(node string, kind Kind, ips []net.IP) []net.IP {
+	if len(s.IncludeNetworks) == 0 && len(s.ExcludeNetworks) == 0 {
+		return ips
+	}
+	var result []net.IP
+	for _, ip := range ips {
+		if (len(s.IncludeNetworks) > 0 && !inNetworks(s.IncludeNetworks, ip)) || inNetworks(s.ExcludeNetworks, ip) {
+			filteredAddresses.WithLabelValues(s.Name, string(kind)).Inc()
+			zap.L().Debug("not publishing filtered address", zap.String("store", s.Name), zap.String("node", node), zap.String("kind", string(kind)), zap.Stringer("address", ip))
+			continue
+		}
+		result = append(result, ip)
+	}
+	return result
+}
+
+// validInternal returns the node's internal addresses that should be published, logging and
+// counting the rest.
+func (s *NodeStore) validInternal(node string, ips []net.IP) []net.IP {
+	if !s.RejectPublicInternal {
+		return ips
+	}
+	var result []net.IP
+	for _, ip := range ips {
+		if ip != nil && unroutable(ip) == "" {
+			rejectedInternal.WithLabelValues(s.Name).Inc()
+			zap.L().Warn("not publishing public internal address", zap.String("store", s.Name), zap.String("node", node), zap.Stringer("address", ip))
+			continue
+		}
+		result = append(result, ip)
+	}
+	return result
+}
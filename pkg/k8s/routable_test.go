@@ -0,0 +1,188 @@
+package k8s
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestUnroutable(t *testing.T) {
+	testData := []struct {
+		addr string
+		want string
+	}{
+		{addr: "42.0.0.1"},
+		{addr: "2600:1f18::1"},
+		{addr: "0.0.0.0", want: "unspecified"},
+		{addr: "::", want: "unspecified"},
+		{addr: "127.0.0.1", want: "loopback"},
+		{addr: "::1", want: "loopback"},
+		{addr: "169.254.169.254", want: "link-local"},
+		{addr: "fe80::1", want: "link-local"},
+		{addr: "224.0.0.251", want: "multicast"},
+		{addr: "ff02::fb", want: "multicast"},
+		{addr: "10.0.0.1", want: "bogon"},
+		{addr: "172.31.255.255", want: "bogon"},
+		{addr: "192.168.1.1", want: "bogon"},
+		{addr: "100.64.0.1", want: "bogon"},
+		{addr: "203.0.113.1", want: "bogon"},
+		{addr: "255.255.255.255", want: "bogon"},
+		{addr: "fd00::1", want: "bogon"},
+		{addr: "2001:db8::1", want: "bogon"},
+		{addr: "4000::1", want: "bogon"},
+	}
+	for _, test := range testData {
+		if got := unroutable(net.ParseIP(test.addr)); got != test.want {
+			t.Errorf("%s:\n  got: %v\n want: %v", test.addr, got, test.want)
+		}
+	}
+	if got, want := unroutable(nil), "invalid"; got != want {
+		t.Errorf("nil:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+func TestValidateExternal(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	ns.ValidateExternal = true
+	_, announced, _ := net.ParseCIDR("42.0.0.0/24")
+	ns.AnnouncedNetworks = []*net.IPNet{announced}
+	var got []Record
+	ns.OnChange = func(req UpdateRequest) error {
+		got = append(got, req.Record)
+		return nil
+	}
+	ns.Add(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{
+				{Type: v1.NodeExternalIP, Address: "42.0.0.1"},
+				{Type: v1.NodeExternalIP, Address: "127.0.0.1"},
+				{Type: v1.NodeExternalIP, Address: "10.0.0.1"},
+				{Type: v1.NodeExternalIP, Address: "43.0.0.1"},
+				{Type: v1.NodeInternalIP, Address: "10.0.0.1"},
+			},
+		},
+	})
+	want := []Record{
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 1)}},
+		{Kind: External, IPs: []net.IP{net.IPv4(42, 0, 0, 1)}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("records:\n%s", diff)
+	}
+}
+
+func TestFilterNetworks(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	ns.AllowPrivateExternal = true
+	_, nodes, _ := net.ParseCIDR("10.0.0.0/8")
+	_, pods, _ := net.ParseCIDR("10.244.0.0/16")
+	_, public, _ := net.ParseCIDR("42.0.0.0/24")
+	ns.IncludeNetworks = []*net.IPNet{nodes, public}
+	ns.ExcludeNetworks = []*net.IPNet{pods}
+	var got []Record
+	ns.OnChange = func(req UpdateRequest) error {
+		got = append(got, req.Record)
+		return nil
+	}
+	ns.Add(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{
+				{Type: v1.NodeExternalIP, Address: "42.0.0.1"},
+				{Type: v1.NodeExternalIP, Address: "43.0.0.1"},
+				{Type: v1.NodeExternalIP, Address: "10.0.0.2"},
+				{Type: v1.NodeInternalIP, Address: "10.0.0.1"},
+				{Type: v1.NodeInternalIP, Address: "10.244.0.1"},
+				{Type: v1.NodeInternalIP, Address: "192.168.0.1"},
+			},
+		},
+	})
+	want := []Record{
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 1)}},
+		{Kind: External, IPs: []net.IP{net.IPv4(10, 0, 0, 2), net.IPv4(42, 0, 0, 1)}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("records:\n%s", diff)
+	}
+}
+
+func TestPrivateExternal(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{
+				{Type: v1.NodeExternalIP, Address: "42.0.0.1"},
+				{Type: v1.NodeExternalIP, Address: "192.168.1.10"},
+				{Type: v1.NodeExternalIP, Address: "fd00::10"},
+			},
+		},
+	}
+	testData := []struct {
+		name  string
+		allow bool
+		want  []Record
+	}{
+		{
+			name: "default",
+			want: []Record{{Kind: External, IPs: []net.IP{net.IPv4(42, 0, 0, 1)}}},
+		},
+		{
+			name:  "allowed",
+			allow: true,
+			want:  []Record{{Kind: External, IPs: []net.IP{net.IPv4(192, 168, 1, 10), net.IPv4(42, 0, 0, 1), net.ParseIP("fd00::10")}}},
+		},
+	}
+	for _, test := range testData {
+		ns := NewNodeStore("test")
+		ns.AllowPrivateExternal = test.allow
+		var got []Record
+		ns.OnChange = func(req UpdateRequest) error {
+			got = append(got, req.Record)
+			return nil
+		}
+		ns.Add(node)
+		if diff := cmp.Diff(got, test.want); diff != "" {
+			t.Errorf("%s:\n%s", test.name, diff)
+		}
+	}
+}
+
+func TestRejectPublicInternal(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	ns.RejectPublicInternal = true
+	var got []Record
+	ns.OnChange = func(req UpdateRequest) error {
+		got = append(got, req.Record)
+		return nil
+	}
+	ns.Add(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "10.0.0.1"},
+				{Type: v1.NodeInternalIP, Address: "42.0.0.1"},
+				{Type: v1.NodeInternalIP, Address: "fd00::1"},
+			},
+		},
+	})
+	want := []Record{
+		{Kind: Internal, IPs: []net.IP{net.IPv4(10, 0, 0, 1), net.ParseIP("fd00::1")}},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("records:\n%s", diff)
+	}
+}
@@ -0,0 +1,293 @@
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"github.com/jrockway/nodedns/pkg/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// DefaultServiceAnnotation is the annotation that names the records of a Service's load balancer
+// addresses.
+const DefaultServiceAnnotation = "nodedns.jrockway.io/hostname"
+
+var serviceRecords = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "service_records",
+	Help: "The number of records that contain the load balancer addresses of annotated Services.",
+})
+
+// ServiceRecords is a cache.Store that publishes the load balancer addresses of Services of type
+// LoadBalancer to the records named by an annotation, for clusters that want their load balancers
+// in DNS without running external-dns.  The annotation's value is a comma-separated list of names;
+// Services that name the same record are merged into it.  Load balancers that report a hostname
+// instead of an address, like AWS's, are published as the hostname's addresses, which are looked
+// up again at every resync.  A record is emptied when no Service names it any more.
+type ServiceRecords struct {
+	Annotation string // The annotation that names a Service's records; DefaultServiceAnnotation if empty.
+
+	// Resolve looks up the addresses of a load balancer's hostname; net.DefaultResolver if nil.
+	Resolve func(ctx context.Context, host string) ([]net.IP, error)
+	Timeout time.Duration // How long each round of updates may take; 30 seconds if zero.
+
+	recordPublisher // Set Publish, and optionally Guard, before using it.
+
+	services map[string]*v1.Service // Annotated Services of type LoadBalancer, by namespace/name; guarded by mu.
+	synced   bool                   // True once the first list of Services has been published.
+}
+
+// names returns the records that the Service names, or nil if it isn't an annotated Service of type
+// LoadBalancer.
+func (s *ServiceRecords) names(svc *v1.Service) []string {
+	annotation := s.Annotation
+	if annotation == "" {
+		annotation = DefaultServiceAnnotation
+	}
+	if svc.Spec.Type != v1.ServiceTypeLoadBalancer {
+		return nil
+	}
+	var result []string
+	for _, name := range strings.Split(svc.GetAnnotations()[annotation], ",") {
+		if name = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), ".")); name != "" {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+// addresses returns the Service's load balancer addresses, looking up any hostnames.
+func (s *ServiceRecords) addresses(ctx context.Context, svc *v1.Service) ([]net.IP, error) {
+	resolve := s.Resolve
+	if resolve == nil {
+		resolve = func(ctx context.Context, host string) ([]net.IP, error) {
+			addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+			result := make([]net.IP, len(addrs))
+			for i, addr := range addrs {
+				result[i] = addr.IP
+			}
+			return result, nil
+		}
+	}
+	var result []net.IP
+	for _, ing := range svc.Status.LoadBalancer.Ingress {
+		switch {
+		case ing.IP != "":
+			ip := net.ParseIP(ing.IP)
+			if ip == nil {
+				zap.L().Warn("invalid load balancer address", zap.String("service", svc.Namespace+"/"+svc.Name), zap.String("address", ing.IP))
+				continue
+			}
+			result = append(result, ip)
+		case ing.Hostname != "":
+			ips, err := resolve(ctx, ing.Hostname)
+			if err != nil {
+				return nil, fmt.Errorf("look up load balancer hostname %s: %w", ing.Hostname, err)
+			}
+			result = append(result, ips...)
+		}
+	}
+	return result, nil
+}
+
+// sync publishes every record whose addresses changed since it was last published, or every
+// record on a resync, and empties the records that no Service names any more.  A record that
+// contains a Service whose hostname couldn't be looked up is left as it is.
+func (s *ServiceRecords) sync(trigger string) error {
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ctx = correlation.With(context.WithValue(ctx, triggerKey{}, trigger), correlation.New())
+	ctx, span := tracing.Start(ctx, "reconcile.services."+trigger)
+	defer span.End()
+	l := zap.L().Named("services").With(correlation.Field(ctx))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	desired := make(map[string][]net.IP)
+	unknown := make(map[string]bool)
+	for key, svc := range s.services {
+		ips, err := s.addresses(ctx, svc)
+		for _, name := range s.names(svc) {
+			if err != nil {
+				unknown[name] = true
+				continue
+			}
+			desired[name] = append(desired[name], ips...)
+		}
+		if err != nil {
+			l.Warn("problem getting load balancer addresses; leaving its records alone", zap.String("service", key), zap.Error(err))
+		}
+	}
+	for name, ips := range desired {
+		if len(ips) == 0 {
+			delete(desired, name)
+		} else {
+			desired[name] = uniqueIPs(ips)
+		}
+	}
+	// Records with a Service whose addresses are unknown keep what they have.
+	for name := range unknown {
+		delete(desired, name)
+		if ips, ok := s.published[name]; ok {
+			desired[name] = ips
+		}
+	}
+	result := s.publishChanged(ctx, trigger, desired)
+	serviceRecords.Set(float64(len(s.published)))
+	if result != nil {
+		tracing.Fail(span, result)
+		l.Error("problem publishing service records; they'll be tried again at the next change or resync", zap.Error(result))
+	}
+	return result
+}
+
+// Synced returns true once the first list of Services has been received and its records
+// published, so that Published includes every record that the Services name.
+func (s *ServiceRecords) Synced() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.synced
+}
+
+// uniqueIPs returns the addresses sorted, without duplicates.
+func uniqueIPs(ips []net.IP) []net.IP {
+	sort.Slice(ips, func(i, j int) bool { return bytes.Compare(ips[i].To16(), ips[j].To16()) < 0 })
+	var result []net.IP
+	for i, ip := range ips {
+		if i == 0 || !ip.Equal(ips[i-1]) {
+			result = append(result, ip)
+		}
+	}
+	return result
+}
+
+// set records the Service, or forgets it if it doesn't name any records.  The caller must hold the
+// lock.
+func (s *ServiceRecords) set(obj interface{}) {
+	svc, ok := obj.(*v1.Service)
+	if !ok {
+		zap.L().Error("wrong-type object", zap.Any("obj", obj))
+		return
+	}
+	if s.services == nil {
+		s.services = make(map[string]*v1.Service)
+	}
+	key := svc.Namespace + "/" + svc.Name
+	if len(s.names(svc)) == 0 {
+		delete(s.services, key)
+		return
+	}
+	s.services[key] = svc
+}
+
+// Add implements cache.Store.  Errors are retried at the next resync, so they aren't returned to
+// the reflector.
+func (s *ServiceRecords) Add(obj interface{}) error {
+	s.mu.Lock()
+	s.set(obj)
+	s.mu.Unlock()
+	s.sync("add") // nolint:errcheck
+	return nil
+}
+
+// Update implements cache.Store.
+func (s *ServiceRecords) Update(obj interface{}) error {
+	s.mu.Lock()
+	s.set(obj)
+	s.mu.Unlock()
+	s.sync("update") // nolint:errcheck
+	return nil
+}
+
+// Delete implements cache.Store.
+func (s *ServiceRecords) Delete(obj interface{}) error {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	svc, ok := obj.(*v1.Service)
+	if !ok {
+		zap.L().Error("wrong-type object", zap.Any("obj", obj))
+		return nil
+	}
+	s.mu.Lock()
+	delete(s.services, svc.Namespace+"/"+svc.Name)
+	s.mu.Unlock()
+	s.sync("delete") // nolint:errcheck
+	return nil
+}
+
+// Replace implements cache.Store.
+func (s *ServiceRecords) Replace(objs []interface{}, unusedResourceVersion string) error {
+	s.mu.Lock()
+	s.services = make(map[string]*v1.Service)
+	for _, obj := range objs {
+		s.set(obj)
+	}
+	s.mu.Unlock()
+	s.sync("replace") // nolint:errcheck
+	s.mu.Lock()
+	s.synced = true
+	s.mu.Unlock()
+	return nil
+}
+
+// Resync implements cache.Store.  Every record is published again, with its hostnames looked up
+// again.
+func (s *ServiceRecords) Resync() error {
+	return s.sync("resync")
+}
+
+// These are unused by the reflector.
+func (s *ServiceRecords) List() []interface{} { return nil }
+func (s *ServiceRecords) ListKeys() []string  { return nil }
+func (s *ServiceRecords) Get(obj interface{}) (item interface{}, exists bool, err error) {
+	return nil, false, errors.New("unimplemented")
+}
+func (s *ServiceRecords) GetByKey(key string) (item interface{}, exists bool, err error) {
+	return nil, false, errors.New("unimplemented")
+}
+
+// WatchServices watches the Services in every namespace, and feeds them to store, like a
+// ServiceRecords or NodePortSRV, until ctx is done.
+func WatchServices(ctx context.Context, master, kubeconfig string, resync time.Duration, store cache.Store) error {
+	config, err := restConfig(master, kubeconfig)
+	if err != nil {
+		return err
+	}
+	return WatchServicesWithConfig(ctx, config, resync, store)
+}
+
+// WatchServicesWithConfig is like WatchServices, but connects to the API server described by
+// config.
+func WatchServicesWithConfig(ctx context.Context, config *rest.Config, resync time.Duration, store cache.Store) error {
+	watchSupervisor.run(ctx, "services", func(ctx context.Context) error {
+		clientset, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return fmt.Errorf("kubernetes: new client: %w", err)
+		}
+		lw := cache.NewListWatchFromClient(clientset.CoreV1().RESTClient(), "services", metav1.NamespaceAll, fields.Everything())
+		return cache.NewReflector(lw, &v1.Service{}, store, resync).ListAndWatch(ctx.Done())
+	})
+	return nil
+}
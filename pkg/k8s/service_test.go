@@ -0,0 +1,86 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestServiceRecords(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	var got []string
+	hosts := map[string][]net.IP{"lb-1.elb.example.net": {net.IPv4(42, 0, 0, 2), net.IPv4(42, 0, 0, 3)}}
+	s := &ServiceRecords{
+		Resolve: func(ctx context.Context, host string) ([]net.IP, error) {
+			ips, ok := hosts[host]
+			if !ok {
+				return nil, errors.New("no such host")
+			}
+			return ips, nil
+		},
+	}
+	s.Publish = func(ctx context.Context, name string, ips []net.IP) error {
+		got = append(got, name+" "+fmt.Sprint(ips))
+		return nil
+	}
+	service := func(name, typ, hostnames string, ingress ...v1.LoadBalancerIngress) *v1.Service {
+		svc := &v1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Spec:       v1.ServiceSpec{Type: v1.ServiceType(typ)},
+			Status:     v1.ServiceStatus{LoadBalancer: v1.LoadBalancerStatus{Ingress: ingress}},
+		}
+		if hostnames != "" {
+			svc.Annotations = map[string]string{DefaultServiceAnnotation: hostnames}
+		}
+		return svc
+	}
+
+	if s.Synced() {
+		t.Error("synced before the first list of services")
+	}
+	s.Replace([]interface{}{ // nolint:errcheck
+		service("web", "LoadBalancer", "web.example.com, www.example.com.", v1.LoadBalancerIngress{IP: "42.0.0.1"}),
+		service("internal", "ClusterIP", "internal.example.com"),
+		service("unannotated", "LoadBalancer", "", v1.LoadBalancerIngress{IP: "42.0.0.9"}),
+	}, "")
+	if !s.Synced() {
+		t.Error("not synced after the first list of services")
+	}
+	// A second load balancer in the same record, that reports a hostname.
+	s.Add(service("web-2", "LoadBalancer", "WEB.example.com", v1.LoadBalancerIngress{Hostname: "lb-1.elb.example.net"})) // nolint:errcheck
+	// A load balancer that hasn't been given an address yet.
+	s.Add(service("pending", "LoadBalancer", "pending.example.com")) // nolint:errcheck
+	// A hostname that can't be looked up leaves the record alone.
+	s.Update(service("web-2", "LoadBalancer", "web.example.com", v1.LoadBalancerIngress{Hostname: "lb-2.elb.example.net"})) // nolint:errcheck
+	// The load balancer's addresses change, and are looked up again at the resync.
+	hosts["lb-2.elb.example.net"] = []net.IP{net.IPv4(42, 0, 0, 4)}
+	s.Resync() // nolint:errcheck
+	// Removing the annotation empties the record that only the Service was in.
+	s.Update(service("web", "LoadBalancer", "web.example.com", v1.LoadBalancerIngress{IP: "42.0.0.1"})) // nolint:errcheck
+	s.Delete(service("web-2", "LoadBalancer", "web.example.com"))                                       // nolint:errcheck
+
+	want := []string{
+		"web.example.com [42.0.0.1]",
+		"www.example.com [42.0.0.1]",
+		"web.example.com [42.0.0.1 42.0.0.2 42.0.0.3]",
+		"web.example.com [42.0.0.1 42.0.0.4]",
+		"www.example.com [42.0.0.1]",
+		"www.example.com []",
+		"web.example.com [42.0.0.1]",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("published:\n%s", diff)
+	}
+	if diff := cmp.Diff(s.Published(), []string{"web.example.com"}); diff != "" {
+		t.Errorf("published records:\n%s", diff)
+	}
+}
@@ -0,0 +1,69 @@
+package k8s
+
+import (
+	"errors"
+	"time"
+)
+
+// Sink consumes changes to a NodeStore's records.  DNS providers, cloud firewalls, and anything
+// else that follows the records are sinks; each one subscribes to a store with Subscribe, and is
+// updated (and retried) independently of the others.
+type Sink interface {
+	// Name identifies the sink in logs, metrics, and traces.  It must be unique among the sinks
+	// subscribed to a store.
+	Name() string
+
+	// Update is called, synchronously, with each record that changes, and with every record
+	// when the store is resynced.  If it returns an error, the record is retried for this sink
+	// only; see NodeStore.RetryMin.  With NodeStore.ConcurrentUpdates or NodeStore.Async, it
+	// may be called concurrently for different records.
+	Update(UpdateRequest) error
+}
+
+// Deferred is implemented by errors that a sink returns when it chose to put off an update, like
+// while an API's rate limit is nearly exhausted.  The update is retried after RetryAfter, and
+// doesn't count against the sink's health.
+type Deferred interface {
+	error
+	RetryAfter() time.Duration
+}
+
+// ErrDeferred is returned by a sink, or by the Publish function of a sink like PerNode, that
+// deliberately didn't change the record, like while updates are paused or another instance is
+// writing the records.  The record is left as it was published before, and the change is made at
+// a later update or resync; unlike Deferred, it isn't retried, and it doesn't count against the
+// sink's health.
+var ErrDeferred = errors.New("update deferred")
+
+// SinkFunc returns a Sink with the provided name that calls f.
+func SinkFunc(name string, f func(UpdateRequest) error) Sink {
+	return &funcSink{name: name, f: f}
+}
+
+type funcSink struct {
+	name string
+	f    func(UpdateRequest) error
+}
+
+func (s *funcSink) Name() string                   { return s.name }
+func (s *funcSink) Update(req UpdateRequest) error { return s.f(req) }
+
+// onChangeSink is the name of the sink that calls NodeStore.OnChange.
+const onChangeSink = "on_change"
+
+// Subscribe adds sinks that will be updated when records change.
+func (s *NodeStore) Subscribe(sinks ...Sink) {
+	s.Lock()
+	defer s.Unlock()
+	s.sinks = append(s.sinks, sinks...)
+}
+
+// subscribers returns every sink that's subscribed to the store, including OnChange.  The caller
+// must hold the lock.
+func (s *NodeStore) subscribers() []Sink {
+	result := make([]Sink, 0, len(s.sinks)+1)
+	if s.OnChange != nil {
+		result = append(result, SinkFunc(onChangeSink, s.OnChange))
+	}
+	return append(result, s.sinks...)
+}
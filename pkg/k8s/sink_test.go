@@ -0,0 +1,190 @@
+package k8s
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/clock"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSinks(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	ns.Logger = l
+	fake := clock.NewFake(time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC))
+	ns.Clock = fake
+	ns.RetryMin, ns.RetryMax = time.Second, time.Second
+
+	got := make(map[string]int)
+	failed := false
+	ns.OnChange = func(req UpdateRequest) error {
+		got[onChangeSink]++
+		return nil
+	}
+	ns.Subscribe(SinkFunc("ok", func(req UpdateRequest) error {
+		got["ok"]++
+		return nil
+	}), SinkFunc("flaky", func(req UpdateRequest) error {
+		got["flaky"]++
+		if !failed {
+			if got, want := req.Trigger, "add"; got != want {
+				t.Errorf("trigger:\n  got: %v\n want: %v", got, want)
+			}
+			failed = true
+			return errors.New("injected error")
+		}
+		if got, want := req.Trigger, "retry"; got != want {
+			t.Errorf("retry trigger:\n  got: %v\n want: %v", got, want)
+		}
+		if want := []net.IP{net.IPv4(10, 0, 0, 1)}; !cmp.Equal(req.Record.IPs, want) || len(req.Nodes) != 1 {
+			t.Errorf("retried with wrong request: %v", req)
+		}
+		return nil
+	}))
+	ns.Add(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "host-1"},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.1"}},
+		},
+	})
+	fake.Advance(time.Minute)
+
+	want := map[string]int{onChangeSink: 1, "ok": 1, "flaky": 2}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("updates by sink:\n%s", diff)
+	}
+}
+
+type deferredError time.Duration
+
+func (e deferredError) Error() string             { return "deferred" }
+func (e deferredError) RetryAfter() time.Duration { return time.Duration(e) }
+
+func TestDeferred(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	ns.Logger = l
+	fake := clock.NewFake(time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC))
+	ns.Clock = fake
+	// Deferred updates are retried even though retries are disabled.
+	ns.RetryMin = 0
+
+	var triggers []string
+	ns.Subscribe(SinkFunc("throttled", func(req UpdateRequest) error {
+		triggers = append(triggers, req.Trigger)
+		if len(triggers) == 1 {
+			return fmt.Errorf("publish: %w", deferredError(time.Hour))
+		}
+		return nil
+	}))
+	ns.Add(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "host-1"},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.1"}},
+		},
+	})
+	fake.Advance(time.Hour - time.Second)
+	if diff := cmp.Diff(triggers, []string{"add"}); diff != "" {
+		t.Errorf("triggers before the deferred time:\n%s", diff)
+	}
+	fake.Advance(time.Second)
+	if diff := cmp.Diff(triggers, []string{"add", "retry"}); diff != "" {
+		t.Errorf("triggers:\n%s", diff)
+	}
+	for _, h := range ns.Health() {
+		if h.LastError != "" {
+			t.Errorf("deferred update counted against health: %v", h)
+		}
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	ns.Logger = l
+	ns.ConcurrentUpdates = true
+
+	// The internal record's update can only finish once the external record's update has
+	// started, which would deadlock if they were serialized.
+	external := make(chan struct{})
+	ns.Subscribe(SinkFunc("slow", func(req UpdateRequest) error {
+		switch req.Record.Kind {
+		case Internal:
+			select {
+			case <-external:
+			case <-time.After(5 * time.Second):
+				t.Error("internal record's update blocked the external record's update")
+			}
+		case External:
+			close(external)
+		}
+		return nil
+	}))
+	ns.Add(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "host-1"},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "10.0.0.1"},
+				{Type: v1.NodeExternalIP, Address: "1.2.3.4"},
+			},
+		},
+	})
+}
+
+func TestErrDeferred(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	ns.Logger = l
+	fake := clock.NewFake(time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC))
+	ns.Clock = fake
+	ns.RetryMin, ns.RetryMax = time.Second, time.Second
+
+	var triggers []string
+	paused := true
+	ns.Subscribe(SinkFunc("paused", func(req UpdateRequest) error {
+		if req.Record.Kind != Internal {
+			return nil
+		}
+		triggers = append(triggers, req.Trigger)
+		if paused {
+			return fmt.Errorf("publish: %w", ErrDeferred)
+		}
+		return nil
+	}))
+	ns.Add(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "host-1"},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "10.0.0.1"}},
+		},
+	})
+	// Deferred updates aren't retried, or counted against the sink's health.
+	fake.Advance(time.Minute)
+	if diff := cmp.Diff(triggers, []string{"add"}); diff != "" {
+		t.Errorf("triggers while paused:\n%s", diff)
+	}
+	for _, h := range ns.Health() {
+		if h.LastError != "" || h.ConsecutiveFailures != 0 {
+			t.Errorf("deferred update counted against health: %v", h)
+		}
+	}
+	// They're made at the next resync.
+	paused = false
+	if err := ns.Resync(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(triggers, []string{"add", "resync"}); diff != "" {
+		t.Errorf("triggers:\n%s", diff)
+	}
+}
@@ -0,0 +1,99 @@
+package k8s
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+)
+
+// NodeStatus is what a store knows about one node.
+type NodeStatus struct {
+	Name        string   `json:"name"`
+	Cluster     string   `json:"cluster,omitempty"`  // The cluster that the node is in, if it isn't the store's own; see NodeStore.Cluster.
+	Excluded    string   `json:"excluded,omitempty"` // Why the node's own addresses aren't published, if they aren't.
+	Terminating bool     `json:"terminating,omitempty"`
+	Internal    []string `json:"internal,omitempty"`
+	External    []string `json:"external,omitempty"`
+	Overlay     []string `json:"overlay,omitempty"`
+	Pinned      []string `json:"pinned,omitempty"`
+}
+
+// RecordStatus is the desired contents of one of a store's records, and what each sink last did
+// with it.
+type RecordStatus struct {
+	Kind      Kind            `json:"kind"`
+	Names     []string        `json:"names,omitempty"` // The DNS records that the addresses are published in; see RecordNames.
+	Addresses []string        `json:"addresses"`
+	Applied   []AppliedRecord `json:"applied,omitempty"` // By sink; empty until the record is first updated.
+}
+
+// StoreStatus is a snapshot of a store, for debugging.
+type StoreStatus struct {
+	Store   string         `json:"store"`
+	Nodes   []NodeStatus   `json:"nodes"`
+	Records []RecordStatus `json:"records"`
+	Sinks   []SinkHealth   `json:"sinks"`
+}
+
+func ipStrings(ips []net.IP) []string {
+	var result []string
+	for _, ip := range ips {
+		result = append(result, ip.String())
+	}
+	return result
+}
+
+// Status returns a snapshot of every node that the store knows about, sorted by cluster and name,
+// whether it's published, the desired contents of each record, and the health of each sink.
+func (s *NodeStore) Status() StoreStatus {
+	sinks := s.Health()
+	s.Lock()
+	defer s.Unlock()
+	result := StoreStatus{Store: s.Name, Nodes: make([]NodeStatus, 0, len(s.nodes)), Sinks: sinks}
+	for _, n := range s.nodes {
+		ns := NodeStatus{
+			Name:        n.Name,
+			Cluster:     n.Cluster,
+			Excluded:    string(n.Excluded),
+			Terminating: s.terminating[n.key()],
+			Internal:    ipStrings(n.Internal),
+			External:    ipStrings(n.External),
+			Overlay:     ipStrings(n.Overlay),
+		}
+		for kind, ips := range n.Pinned {
+			for _, ip := range ips {
+				ns.Pinned = append(ns.Pinned, string(kind)+":"+ip.String())
+			}
+		}
+		sort.Strings(ns.Pinned)
+		result.Nodes = append(result.Nodes, ns)
+	}
+	sort.Slice(result.Nodes, func(i, j int) bool {
+		a, b := result.Nodes[i], result.Nodes[j]
+		if a.Cluster != b.Cluster {
+			return a.Cluster < b.Cluster
+		}
+		return a.Name < b.Name
+	})
+	for _, kind := range s.kinds() {
+		addrs := ipStrings(s.record(kind).IPs)
+		if addrs == nil {
+			addrs = []string{}
+		}
+		result.Records = append(result.Records, RecordStatus{Kind: kind, Names: s.RecordNames[kind], Addresses: addrs, Applied: s.appliedRecords(kind)})
+	}
+	return result
+}
+
+// StatusHandler returns an http.Handler that reports the status of every store, as JSON.
+func StatusHandler(stores []*NodeStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		result := make([]StoreStatus, 0, len(stores))
+		for _, st := range stores {
+			result = append(result, st.Status())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result) // nolint:errcheck
+	})
+}
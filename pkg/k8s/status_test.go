@@ -0,0 +1,117 @@
+package k8s
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/clock"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStatus(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	ns.Logger = l
+	ns.RecordNames = map[Kind][]string{External: {"nodes.example.com"}}
+	node := func(name string, ready v1.ConditionStatus, internal, external string) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: v1.NodeStatus{
+				Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: ready}},
+				Addresses: []v1.NodeAddress{
+					{Type: v1.NodeInternalIP, Address: internal},
+					{Type: v1.NodeExternalIP, Address: external},
+				},
+			},
+		}
+	}
+	ns.Add(node("host-2", v1.ConditionFalse, "10.0.0.2", "42.0.0.2"))
+	ns.Add(node("host-1", v1.ConditionTrue, "10.0.0.1", "42.0.0.1"))
+	ns.Add(node("host-3", v1.ConditionTrue, "10.0.0.3", "42.0.0.3"))
+	ns.Terminate("host-3")
+	// A node that stops being ready for another reason is reported with the new reason.
+	ns.Update(node("host-2", v1.ConditionUnknown, "10.0.0.2", "42.0.0.2"))
+	unschedulable := node("host-2", v1.ConditionUnknown, "10.0.0.2", "42.0.0.2")
+	unschedulable.Spec.Unschedulable = true
+	ns.Update(unschedulable)
+
+	rec := httptest.NewRecorder()
+	StatusHandler([]*NodeStore{ns}).ServeHTTP(rec, httptest.NewRequest("GET", "/debug/stores", nil))
+	var got []StoreStatus
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := []StoreStatus{{
+		Store: "test",
+		Nodes: []NodeStatus{
+			{Name: "host-1", Internal: []string{"10.0.0.1"}, External: []string{"42.0.0.1"}},
+			{Name: "host-2", Excluded: "marked unschedulable"},
+			{Name: "host-3", Terminating: true, Internal: []string{"10.0.0.3"}, External: []string{"42.0.0.3"}},
+		},
+		Records: []RecordStatus{
+			{Kind: Internal, Addresses: []string{"10.0.0.1"}},
+			{Kind: External, Names: []string{"nodes.example.com"}, Addresses: []string{"42.0.0.1"}},
+		},
+		Sinks: []SinkHealth{},
+	}}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("status:\n%s", diff)
+	}
+}
+
+func TestStatusApplied(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ns := NewNodeStore("test")
+	ns.Logger = l
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	ns.Clock = clock.NewFake(now)
+	ns.RetryMin = 0
+	broken := false
+	ns.Subscribe(SinkFunc("dns", func(req UpdateRequest) error {
+		if broken {
+			return errors.New("injected error")
+		}
+		return nil
+	}))
+	node := func(name, internal string) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: internal}},
+			},
+		}
+	}
+	ns.Add(node("host-1", "10.0.0.1"))
+	broken = true
+	ns.Add(node("host-2", "10.0.0.2"))
+
+	var got []RecordStatus
+	for _, r := range ns.Status().Records {
+		if r.Kind == Internal {
+			got = append(got, r)
+		}
+	}
+	want := []RecordStatus{{
+		Kind:      Internal,
+		Addresses: []string{"10.0.0.1", "10.0.0.2"},
+		Applied: []AppliedRecord{{
+			Sink:        "dns",
+			Addresses:   []string{"10.0.0.1"},
+			LastSuccess: now,
+			LastFailure: now,
+			LastError:   "injected error",
+		}},
+	}}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("records:\n%s", diff)
+	}
+}
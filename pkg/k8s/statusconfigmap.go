@@ -0,0 +1,144 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// StatusConfigMap keeps a ConfigMap up to date with the status of a set of stores (see
+// NodeStore.Status), and records an Event on it when a record is updated or an update fails, so
+// that operators can see why a node is or isn't in DNS with kubectl.  Each store's status is kept
+// in the key <store>.json.
+type StatusConfigMap struct {
+	Namespace, Name string
+	Stores          []*NodeStore
+	Interval        time.Duration // How often to write the ConfigMap, if any record was updated.
+	Enabled         func() bool   // If non-nil and false, nothing is written; for replicas that aren't the leader.
+
+	client   kubernetes.Interface
+	recorder record.EventRecorder
+	dirty    int32 // Set when a record is updated, and cleared when the ConfigMap is written.
+
+	mu  sync.Mutex
+	ref *v1.ObjectReference // The ConfigMap, as the object of Events.
+}
+
+// NewStatusConfigMap returns a StatusConfigMap that writes the named ConfigMap every 10 seconds.
+// Call Run to start writing it, and set each store's OnUpdate to the result of OnUpdate.
+func NewStatusConfigMap(client kubernetes.Interface, namespace, name string, stores []*NodeStore) *StatusConfigMap {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events(namespace)})
+	return &StatusConfigMap{
+		Namespace: namespace,
+		Name:      name,
+		Stores:    stores,
+		Interval:  10 * time.Second,
+		client:    client,
+		recorder:  broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "nodedns"}),
+		ref:       &v1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Namespace: namespace, Name: name},
+	}
+}
+
+func (c *StatusConfigMap) enabled() bool {
+	return c.Enabled == nil || c.Enabled()
+}
+
+// OnUpdate returns a NodeStore.OnUpdate function for the store, which records an Event for each
+// failed update, and each successful update of DNS that wasn't part of a resync, and marks the
+// ConfigMap as needing to be written.
+func (c *StatusConfigMap) OnUpdate(st *NodeStore) func(req UpdateRequest, sink string, err error) {
+	return func(req UpdateRequest, sink string, err error) {
+		atomic.StoreInt32(&c.dirty, 1)
+		if !c.enabled() {
+			return
+		}
+		record := strings.Join(st.PublishedIn(req.Record.Kind), ",")
+		if record == "" {
+			record = string(req.Record.Kind)
+		}
+		c.mu.Lock()
+		ref := c.ref
+		c.mu.Unlock()
+		switch {
+		case err != nil:
+			c.recorder.Eventf(ref, v1.EventTypeWarning, "UpdateFailed", "%s: %s failed to update %s record %s: %v", st.Name, sink, req.Record.Kind, record, err)
+		case sink == "dns" && req.Trigger != "resync":
+			addresses := strings.Join(ipStrings(req.Record.IPs), ", ")
+			if addresses == "" {
+				addresses = "no addresses"
+			}
+			c.recorder.Eventf(ref, v1.EventTypeNormal, "Updated", "%s: updated %s record %s after %s: %s", st.Name, req.Record.Kind, record, req.Trigger, addresses)
+		}
+	}
+}
+
+// Run writes the ConfigMap every Interval, if any record has been updated since it was last
+// written, until the context is done.
+func (c *StatusConfigMap) Run(ctx context.Context) {
+	t := time.NewTicker(c.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if !c.enabled() || !atomic.CompareAndSwapInt32(&c.dirty, 1, 0) {
+			continue
+		}
+		tctx, cancel := context.WithTimeout(ctx, c.Interval)
+		err := c.write(tctx)
+		cancel()
+		if err != nil {
+			atomic.StoreInt32(&c.dirty, 1)
+			zap.L().Warn("problem writing status configmap", zap.String("namespace", c.Namespace), zap.String("name", c.Name), zap.Error(err))
+		}
+	}
+}
+
+// write creates or replaces the ConfigMap.
+func (c *StatusConfigMap) write(ctx context.Context) error {
+	data := make(map[string]string, len(c.Stores))
+	for _, st := range c.Stores {
+		b, err := json.MarshalIndent(st.Status(), "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshal status of store %s: %w", st.Name, err)
+		}
+		data[st.Name+".json"] = string(b)
+	}
+	configMaps := c.client.CoreV1().ConfigMaps(c.Namespace)
+	cm, err := configMaps.Get(ctx, c.Name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		cm, err = configMaps.Create(ctx, &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: c.Namespace, Name: c.Name}, Data: data}, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("create configmap: %w", err)
+		}
+	case err != nil:
+		return fmt.Errorf("get configmap: %w", err)
+	default:
+		cm.Data = data
+		cm, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		if err != nil {
+			return fmt.Errorf("update configmap: %w", err)
+		}
+	}
+	c.mu.Lock()
+	c.ref = &v1.ObjectReference{APIVersion: "v1", Kind: "ConfigMap", Namespace: c.Namespace, Name: c.Name, UID: cm.UID}
+	c.mu.Unlock()
+	return nil
+}
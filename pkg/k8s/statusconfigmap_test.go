@@ -0,0 +1,77 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestStatusConfigMap(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ctx := context.Background()
+	ns := NewNodeStore("main")
+	ns.RecordNames = map[Kind][]string{External: {"nodes.example.com"}}
+	client := fake.NewSimpleClientset()
+	c := NewStatusConfigMap(client, "kube-system", "nodedns-status", []*NodeStore{ns})
+	recorder := record.NewFakeRecorder(10)
+	c.recorder = recorder
+	ns.OnUpdate = c.OnUpdate(ns)
+	fail := true
+	ns.Subscribe(SinkFunc("dns", func(req UpdateRequest) error {
+		if fail && req.Record.Kind == External {
+			return errors.New("rate limited")
+		}
+		return nil
+	}))
+
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status:     v1.NodeStatus{Addresses: []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: "42.0.0.1"}}},
+	}
+	ns.Add(node)
+	fail = false
+	ns.Delete(node)
+
+	// The store is written on the next tick.
+	if err := c.write(ctx); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	cm, err := client.CoreV1().ConfigMaps("kube-system").Get(ctx, "nodedns-status", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get configmap: %v", err)
+	}
+	var status StoreStatus
+	if err := json.Unmarshal([]byte(cm.Data["main.json"]), &status); err != nil {
+		t.Fatalf("unmarshal status: %v", err)
+	}
+	if got, want := status.Store, "main"; got != want {
+		t.Errorf("store:\n  got: %v\n want: %v", got, want)
+	}
+	// Writing again updates the existing ConfigMap.
+	if err := c.write(ctx); err != nil {
+		t.Fatalf("write again: %v", err)
+	}
+
+	close(recorder.Events)
+	var got []string
+	for e := range recorder.Events {
+		got = append(got, e)
+	}
+	want := []string{
+		"Warning UpdateFailed main: dns failed to update external record nodes.example.com: rate limited",
+		"Normal Updated main: updated external record nodes.example.com after delete: no addresses",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("events:\n%s", diff)
+	}
+}
@@ -0,0 +1,75 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/clock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var watchRestarts = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "node_watch_restarts",
+		Help: "The number of times that a watch of the API server failed and was restarted, by watch.",
+	},
+	[]string{"watch"},
+)
+
+// supervisor restarts a watch that fails, with exponential backoff and jitter, until the watch's
+// context is done, so that a brief API server outage or an expired token doesn't take down the
+// process.  Watches run their reflector's ListAndWatch rather than Run, which would retry failed
+// lists and watches on its own, so that every failure is counted and backed off here.
+type supervisor struct {
+	Min, Max time.Duration // Bound the backoff between restarts; a watch that ran for Max resets it.
+	Clock    clock.Clock
+}
+
+// watchSupervisor supervises the node watches.
+var watchSupervisor = &supervisor{Min: time.Second, Max: 2 * time.Minute, Clock: clock.Real{}}
+
+// run runs f, restarting it if it returns before ctx is done.  It returns when ctx is done.
+func (s *supervisor) run(ctx context.Context, watch string, f func(ctx context.Context) error) {
+	delay := s.Min
+	for {
+		start := s.Clock.Now()
+		err := f(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = errors.New("watch stopped unexpectedly")
+		}
+		if s.Clock.Now().Sub(start) >= s.Max {
+			delay = s.Min
+		}
+		// Half of the delay, plus a random amount up to the other half, so that many replicas
+		// don't all reconnect at once when the API server comes back.
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1)) // nolint:gosec
+		watchRestarts.WithLabelValues(watch).Inc()
+		zap.L().Warn("watch failed; restarting", zap.String("watch", watch), zap.Duration("backoff", wait), zap.Error(err))
+		if !s.sleep(ctx, wait) {
+			return
+		}
+		if delay *= 2; delay > s.Max {
+			delay = s.Max
+		}
+	}
+}
+
+// sleep waits for d, and returns false if ctx is done first.
+func (s *supervisor) sleep(ctx context.Context, d time.Duration) bool {
+	done := make(chan struct{})
+	t := s.Clock.AfterFunc(d, func() { close(done) })
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		t.Stop()
+		return false
+	}
+}
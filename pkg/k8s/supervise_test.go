@@ -0,0 +1,111 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/clock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestSupervisor(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	s := &supervisor{Min: time.Millisecond, Max: 4 * time.Millisecond, Clock: clock.Real{}}
+	before := testutil.ToFloat64(watchRestarts.WithLabelValues("test"))
+
+	ctx, c := context.WithTimeout(context.Background(), 10*time.Second)
+	defer c()
+	var runs int
+	s.run(ctx, "test", func(ctx context.Context) error {
+		runs++
+		switch runs {
+		case 1:
+			return errors.New("api server unavailable")
+		case 2, 3:
+			return nil // The reflector stopped, but the context isn't done.
+		}
+		c()
+		return nil
+	})
+	if got, want := runs, 4; got != want {
+		t.Errorf("runs:\n  got: %v\n want: %v", got, want)
+	}
+	if got, want := testutil.ToFloat64(watchRestarts.WithLabelValues("test"))-before, 3.0; got != want {
+		t.Errorf("restarts:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+func TestSupervisorCancelDuringBackoff(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	s := &supervisor{Min: time.Hour, Max: time.Hour, Clock: clock.Real{}}
+	ctx, c := context.WithCancel(context.Background())
+	var runs int
+	done := make(chan struct{})
+	go func() {
+		s.run(ctx, "test", func(ctx context.Context) error {
+			runs++
+			return errors.New("api server unavailable")
+		})
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	c()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("supervisor did not return after its context was cancelled")
+	}
+	if got, want := runs, 1; got != want {
+		t.Errorf("runs:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+func TestWatchNodesRestartsFailedLists(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	defer func(s *supervisor) { watchSupervisor = s }(watchSupervisor)
+	watchSupervisor = &supervisor{Min: time.Millisecond, Max: 4 * time.Millisecond, Clock: clock.Real{}}
+	before := testutil.ToFloat64(watchRestarts.WithLabelValues("nodes"))
+
+	// The API server fails the first three lists, like during an outage or with an expired token.
+	client := fake.NewSimpleClientset(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "a"}})
+	var lists int32
+	client.PrependReactor("list", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if atomic.AddInt32(&lists, 1) <= 3 {
+			return true, nil, errors.New("api server unavailable")
+		}
+		return false, nil, nil
+	})
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	ctx, c := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		WatchNodesWithClient(ctx, client, "", 0, store) // nolint:errcheck
+		close(done)
+	}()
+	deadline := time.Now().Add(10 * time.Second)
+	for len(store.ListKeys()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("nodes never listed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c()
+	<-done
+	if got, want := testutil.ToFloat64(watchRestarts.WithLabelValues("nodes"))-before, 3.0; got != want {
+		t.Errorf("restarts:\n  got: %v\n want: %v", got, want)
+	}
+}
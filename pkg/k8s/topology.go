@@ -0,0 +1,175 @@
+package k8s
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"go.uber.org/zap"
+)
+
+// Topology is a Sink that publishes a record for each topology zone or region, containing the
+// addresses of one kind of the nodes in it, in addition to the records of every node; clients can
+// then prefer the nodes near them.  Nodes without the labels that the template needs aren't in any
+// of its records.  Only the records of zones whose nodes' addresses changed are published, except
+// on resyncs, and a record is emptied when its zone has no more nodes.  Create it with
+// NewTopology.
+type Topology struct {
+	Kind Kind // The kind of addresses to publish.
+
+	recordPublisher // Set Publish, and optionally Guard, before subscribing it.
+
+	template     *template.Template
+	zone, region bool           // Whether the template uses the node's zone and region.
+	match        *regexp.Regexp // Matches the names that the template can produce.
+}
+
+var _ Sink = (*Topology)(nil)
+
+// The zone and region that NewTopology executes the template with, to find out which of them it
+// uses and what the names it produces look like.
+const (
+	seedZone   = "x0zone0x"
+	seedRegion = "y0region0y"
+)
+
+// NewTopology returns a Topology that publishes the addresses of the kind.  The template names
+// each group's record, like "nodes.{{.Zone}}.example.com"; it's executed with a Node that only has
+// a Zone and Region, lowercased, and must use at least one of them.
+func NewTopology(text string, kind Kind) (*Topology, error) {
+	tmpl, err := template.New("topology").Funcs(PerNodeFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	t := &Topology{Kind: kind, template: tmpl}
+	out, err := t.execute(Node{Zone: seedZone, Region: seedRegion})
+	if err != nil {
+		return nil, err
+	}
+	t.zone, t.region = strings.Contains(out, seedZone), strings.Contains(out, seedRegion)
+	if !t.zone && !t.region {
+		return nil, fmt.Errorf("template produced %q, which doesn't depend on .Zone or .Region", out)
+	}
+	pattern := regexp.QuoteMeta(out)
+	for _, seed := range []string{seedZone, seedRegion} {
+		pattern = strings.ReplaceAll(pattern, seed, `[^.]+`)
+	}
+	if t.match, err = regexp.Compile("^" + pattern + "$"); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// execute returns the template's output for the node, or an error if it's empty.
+func (t *Topology) execute(n Node) (string, error) {
+	var buf bytes.Buffer
+	if err := t.template.Execute(&buf, n); err != nil {
+		return "", err
+	}
+	name := strings.ToLower(strings.TrimSpace(buf.String()))
+	if name == "" {
+		return "", errors.New("template produced an empty name")
+	}
+	return name, nil
+}
+
+// Name implements Sink.
+func (t *Topology) Name() string { return "topology" }
+
+// Matches returns true if the name could be the record of some zone or region.
+func (t *Topology) Matches(name string) bool {
+	return t.match.MatchString(strings.ToLower(name))
+}
+
+// Seed adds records that may have been published before, like by an earlier run, and that match
+// the template, to the records that the sink has published; see recordPublisher.seed.  It returns
+// the names that were added.
+func (t *Topology) Seed(names []string) []string {
+	return t.seed(names, t.Matches)
+}
+
+// RecordName returns the name of the record that contains the node, or false if the node doesn't
+// have the labels that the template needs.
+func (t *Topology) RecordName(n Node) (string, bool) {
+	if (t.zone && n.Zone == "") || (t.region && n.Region == "") {
+		return "", false
+	}
+	name, err := t.execute(Node{Zone: strings.ToLower(n.Zone), Region: strings.ToLower(n.Region)})
+	if err != nil {
+		zap.L().Named("topology").Debug("problem naming node's topology record", zap.String("node", n.Name), zap.Error(err))
+		return "", false
+	}
+	return name, true
+}
+
+// Update implements Sink.  Every group's record is considered whenever the record of the sink's
+// kind changes.
+func (t *Topology) Update(req UpdateRequest) error {
+	if req.Record.Kind != t.Kind {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	desired, ungrouped := groupAddresses(req.Nodes, t.Kind, t.RecordName)
+	if ungrouped > 0 {
+		zap.L().Named("topology").Debug("nodes without topology labels are only in the main records", zap.Int("nodes", ungrouped))
+	}
+	return t.publishChanged(req.Ctx, req.Trigger, desired)
+}
+
+// Groups is a Sink that publishes a record for each group of nodes, containing the addresses of
+// one kind of the nodes in it, in addition to the records of every node.  It's like Topology, but
+// Group decides which record, if any, each node is in; for programs that embed NodeStore with
+// their own grouping, like by node pool or by owner.
+type Groups struct {
+	// Group returns the name of the record that contains the node, or false if the node isn't
+	// in any group.
+	Group func(n Node) (string, bool)
+	Kind  Kind // The kind of addresses to publish.
+
+	recordPublisher // Set Publish, and optionally Guard, before subscribing it.
+}
+
+var _ Sink = (*Groups)(nil)
+
+// Name implements Sink.
+func (g *Groups) Name() string { return "groups" }
+
+// Update implements Sink.  Every group's record is considered whenever the record of the sink's
+// kind changes.
+func (g *Groups) Update(req UpdateRequest) error {
+	if req.Record.Kind != g.Kind {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	desired, _ := groupAddresses(req.Nodes, g.Kind, g.Group)
+	return g.publishChanged(req.Ctx, req.Trigger, desired)
+}
+
+// groupAddresses returns the addresses of the kind of each group of nodes, by the name of the
+// group's record, and how many nodes with addresses weren't in any group.
+func groupAddresses(nodes []Node, kind Kind, group func(Node) (string, bool)) (map[string][]net.IP, int) {
+	ungrouped := 0
+	result := make(map[string][]net.IP)
+	for _, n := range nodes {
+		ips := n.Addresses(kind)
+		if len(ips) == 0 {
+			continue
+		}
+		name, ok := group(n)
+		if !ok {
+			ungrouped++
+			continue
+		}
+		result[name] = append(result[name], ips...)
+	}
+	for name, ips := range result {
+		result[name] = uniqueIPs(ips)
+	}
+	return result, ungrouped
+}
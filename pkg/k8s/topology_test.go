@@ -0,0 +1,155 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTopology(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	var got []string
+	topo, err := NewTopology("nodes.{{.Zone}}.example.com", External)
+	if err != nil {
+		t.Fatal(err)
+	}
+	topo.Publish = func(ctx context.Context, name string, ips []net.IP) error {
+		got = append(got, name+" "+fmt.Sprint(ips))
+		return nil
+	}
+	ns := NewNodeStore("test")
+	ns.Subscribe(topo)
+	node := func(name, zone, external string) *v1.Node {
+		n := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: external}},
+			},
+		}
+		if zone != "" {
+			n.Labels = map[string]string{ZoneLabels[0]: zone}
+		}
+		return n
+	}
+
+	ns.Add(node("node-1", "us-east-1a", "42.0.0.1"))
+	ns.Add(node("node-2", "us-east-1b", "42.0.0.2"))
+	// Only us-east-1a's record changes.
+	ns.Add(node("node-3", "us-east-1a", "42.0.0.3"))
+	// A node without a zone is only in the main record.
+	ns.Add(node("node-4", "", "42.0.0.4"))
+	// The deprecated label works too.
+	n := node("node-5", "", "42.0.0.5")
+	n.Labels = map[string]string{ZoneLabels[1]: "US-EAST-1C"}
+	ns.Add(n)
+	// us-east-1b has no more nodes.
+	ns.Delete(node("node-2", "us-east-1b", "42.0.0.2"))
+
+	want := []string{
+		"nodes.us-east-1a.example.com [42.0.0.1]",
+		"nodes.us-east-1b.example.com [42.0.0.2]",
+		"nodes.us-east-1a.example.com [42.0.0.1 42.0.0.3]",
+		"nodes.us-east-1c.example.com [42.0.0.5]",
+		"nodes.us-east-1b.example.com []",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("published:\n%s", diff)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	var got []string
+	groups := &Groups{
+		Group: func(n Node) (string, bool) {
+			if n.Name == "node-3" {
+				return "", false
+			}
+			return "pool-" + n.Name[len(n.Name)-1:] + ".example.com", true
+		},
+		Kind: Internal,
+	}
+	groups.Publish = func(ctx context.Context, name string, ips []net.IP) error {
+		got = append(got, name+" "+fmt.Sprint(ips))
+		return nil
+	}
+	ns := NewNodeStore("test")
+	ns.Subscribe(groups)
+	for i, name := range []string{"node-1", "node-2", "node-3"} {
+		ns.Add(&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: fmt.Sprintf("10.0.0.%d", i+1)}},
+			},
+		})
+	}
+	want := []string{
+		"pool-1.example.com [10.0.0.1]",
+		"pool-2.example.com [10.0.0.2]",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("published:\n%s", diff)
+	}
+}
+
+func TestTopologyRecordName(t *testing.T) {
+	topo, err := NewTopology("{{.Zone}}.{{.Region}}.example.com", External)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testData := []struct {
+		node   Node
+		want   string
+		wantOK bool
+	}{
+		{node: Node{Zone: "us-east-1a", Region: "us-east-1"}, want: "us-east-1a.us-east-1.example.com", wantOK: true},
+		{node: Node{Zone: "US-East-1a", Region: "us-east-1"}, want: "us-east-1a.us-east-1.example.com", wantOK: true},
+		{node: Node{Region: "us-east-1"}},
+		{node: Node{}},
+	}
+	for _, test := range testData {
+		got, ok := topo.RecordName(test.node)
+		if got != test.want || ok != test.wantOK {
+			t.Errorf("%v:\n  got: %q %v\n want: %q %v", test.node, got, ok, test.want, test.wantOK)
+		}
+	}
+	for name, want := range map[string]bool{
+		"us-east-1a.us-east-1.example.com": true,
+		"US-East-1a.us-east-1.example.com": true,
+		"us-east-1.example.com":            false,
+		"a.b.c.example.com":                false,
+		"us-east-1a.us-east-1.example.org": false,
+		"us-east-1a.us-east-1.examplexcom": false,
+	} {
+		if got := topo.Matches(name); got != want {
+			t.Errorf("matches %s:\n  got: %v\n want: %v", name, got, want)
+		}
+	}
+}
+
+func TestNewTopology(t *testing.T) {
+	for tmpl, wantErr := range map[string]bool{
+		"nodes.{{.Region}}.example.com":                 false,
+		"{{short .Zone}}.example.com":                   false,
+		"nodes.{{.Zone | printf \"%s-x\"}}.example.com": false,
+		"nodes.example.com":                             true,
+		"nodes.{zone}.example.com":                      true,
+		"{{.Name}}.example.com":                         true,
+		"{{.Zone}.example.com":                          true,
+		"{{.Pool}}.example.com":                         true,
+		"{{if false}}{{.Zone}}{{end}}":                  true,
+	} {
+		if _, err := NewTopology(tmpl, External); (err != nil) != wantErr {
+			t.Errorf("%s: error: %v, want error: %v", tmpl, err, wantErr)
+		}
+	}
+}
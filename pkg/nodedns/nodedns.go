@@ -0,0 +1,199 @@
+// Package nodedns embeds the pipeline that the nodedns command runs, watching a cluster's nodes and
+// publishing their addresses to DigitalOcean DNS (or any dns.Provider), in other Go programs.  It
+// covers the common case; the command's integrations (probes, firewalls, load balancers, and so
+// on) are left to callers, who can subscribe their own sinks, like a k8s.Groups.  StorePolicy and
+// Records are the pieces of the pipeline that the command builds its own stores from.
+package nodedns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/digitalocean/godo"
+	"github.com/jrockway/nodedns/pkg/digitalocean"
+	"github.com/jrockway/nodedns/pkg/dns"
+	"github.com/jrockway/nodedns/pkg/k8s"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Config configures a Controller.
+type Config struct {
+	// Master and Kubeconfig locate the API server.  If both are empty, the in-cluster
+	// configuration is used.  RestConfig, if set, is used instead, and Client, if set, is used
+	// instead of either; like the fake clientset, in tests.
+	Master, Kubeconfig string
+	RestConfig         *rest.Config
+	Client             kubernetes.Interface
+
+	// Selector is a Kubernetes label selector; only matching nodes are published.  If empty,
+	// every node is published.
+	Selector string
+
+	// Eligible, if non-nil, decides whether each node that passes the built-in checks is
+	// published; see k8s.NodeStore.
+	Eligible func(n *v1.Node) (reason string)
+
+	// Token is the DigitalOcean personal access token to update DNS with.  Godo, if set, is
+	// used instead; for a custom transport, or another implementation of the API.
+	Token string
+	Godo  *godo.Client
+
+	// Provider, if set, publishes the records instead of DigitalOcean; Token, Godo, Zone, TTL,
+	// CreateOnly, and Audit are then up to the provider, and ignored.
+	Provider dns.Provider
+
+	// Zone is the DNS zone that the records are in, and TTL is the TTL of the records.  If TTL
+	// is zero, 60s is used.
+	Zone string
+	TTL  time.Duration
+
+	// Records are the records that each class of address is published to, relative to Zone.
+	// Classes without a record aren't published.  Overlay addresses are only detected inside
+	// OverlayNetworks.
+	Records         map[k8s.Kind]string
+	OverlayNetworks []*net.IPNet
+
+	// AllowPrivateExternal publishes external addresses in private networks, which are
+	// otherwise left out of the External record; see k8s.NodeStore.
+	AllowPrivateExternal bool
+
+	// Family, if "ipv4" or "ipv6", leaves addresses of the other family out of every record.
+	Family string
+
+	// CreateOnly, if true, adds records but never deletes them; the records that would have
+	// been deleted are logged instead.  Audit, if true, never changes records, and only reports
+	// how they differ from the nodes.
+	CreateOnly, Audit bool
+
+	// Resync, if non-zero, republishes every record at this interval.
+	Resync time.Duration
+
+	// RetryMin and RetryMax bound the backoff between retries of failed updates.  If RetryMin
+	// is zero, failed updates wait for the next resync.
+	RetryMin, RetryMax time.Duration
+
+	// RetryLimit, if positive, is how many times in a row a failed update is retried before
+	// it waits for the next change or resync.
+	RetryLimit int
+
+	// Sinks are notified of every change to the records, in addition to DNS.
+	Sinks []k8s.Sink
+}
+
+// Controller publishes the addresses of a cluster's nodes to DNS.  The zero value is ready to
+// use.
+type Controller struct {
+	mu    sync.Mutex
+	store *k8s.NodeStore
+}
+
+// Store returns the NodeStore that the controller maintains, for inspecting its health, or nil if
+// the controller isn't running.
+func (c *Controller) Store() *k8s.NodeStore {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.store
+}
+
+// Run watches nodes and publishes their addresses until the context is done.
+func (c *Controller) Run(ctx context.Context, cfg Config) error {
+	store, err := c.start(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		c.mu.Lock()
+		c.store = nil
+		c.mu.Unlock()
+	}()
+	if cfg.Client != nil {
+		return k8s.WatchNodesWithClient(ctx, cfg.Client, cfg.Selector, cfg.Resync, store)
+	}
+	if cfg.RestConfig != nil {
+		return k8s.WatchNodesWithConfig(ctx, cfg.RestConfig, cfg.Selector, cfg.Resync, store)
+	}
+	return k8s.WatchNodes(ctx, cfg.Master, cfg.Kubeconfig, cfg.Selector, cfg.Resync, store)
+}
+
+// start validates the configuration, connects to DigitalOcean (unless there's a Provider), and
+// returns a NodeStore that publishes to DNS.
+func (c *Controller) start(ctx context.Context, cfg Config) (*k8s.NodeStore, error) {
+	if len(cfg.Records) == 0 {
+		return nil, errors.New("records: at least one must be set")
+	}
+	client := cfg.Provider
+	if client == nil {
+		var err error
+		if client, err = newDigitalOcean(ctx, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	store := k8s.NewNodeStore("main")
+	policy := StorePolicy{
+		RetryMin:             cfg.RetryMin,
+		RetryMax:             cfg.RetryMax,
+		RetryLimit:           cfg.RetryLimit,
+		AllowPrivateExternal: cfg.AllowPrivateExternal,
+		Family:               cfg.Family,
+		Eligible:             cfg.Eligible,
+	}
+	policy.Apply(store)
+	store.OverlayNetworks = cfg.OverlayNetworks
+	records := &Records{Default: client, Names: make(map[k8s.Kind]string, len(cfg.Records))}
+	for kind, name := range cfg.Records {
+		records.Names[kind] = name
+	}
+	store.RecordNames = records.FQDNs()
+	store.Subscribe(k8s.SinkFunc("dns", func(req k8s.UpdateRequest) error {
+		if err := records.Update(req.Ctx, req.Record.Kind, req.Record.IPs); err != nil {
+			zap.L().Error("problem updating dns", zap.String("record", client.FQDN(records.Name(req.Record.Kind))), zap.Error(err))
+			return err
+		}
+		return nil
+	}))
+	store.Subscribe(cfg.Sinks...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.store != nil {
+		return nil, errors.New("controller already running")
+	}
+	c.store = store
+	return store, nil
+}
+
+// newDigitalOcean validates the DigitalOcean configuration and connects to DigitalOcean.
+func newDigitalOcean(ctx context.Context, cfg Config) (dns.Provider, error) {
+	if cfg.Zone == "" {
+		return nil, errors.New("zone: must be set")
+	}
+	if cfg.TTL == 0 {
+		cfg.TTL = time.Minute
+	}
+	gc := cfg.Godo
+	if gc == nil {
+		if cfg.Token == "" {
+			return nil, errors.New("token: must be set")
+		}
+		gc = digitalocean.NewGodoClient(cfg.Token)
+	}
+	client, err := dns.NewClientFromGodo(ctx, gc, cfg.Zone, cfg.TTL)
+	if err != nil {
+		return nil, fmt.Errorf("connect to digitalocean: %w", err)
+	}
+	if cfg.CreateOnly {
+		client = client.CreateOnly()
+	}
+	if cfg.Audit {
+		client = client.Audit()
+	}
+	return client, nil
+}
@@ -0,0 +1,152 @@
+package nodedns
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/dns"
+	"github.com/jrockway/nodedns/pkg/fakedo"
+	"github.com/jrockway/nodedns/pkg/k8s"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestStart(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	s := fakedo.New("example.com")
+	defer s.Close()
+	ctx := context.Background()
+
+	var changes []k8s.Kind
+	cfg := Config{
+		Godo:    s.Client(),
+		Zone:    "example.com",
+		Records: map[k8s.Kind]string{k8s.Internal: "internal", k8s.External: "nodes"},
+		Sinks: []k8s.Sink{k8s.SinkFunc("test", func(req k8s.UpdateRequest) error {
+			changes = append(changes, req.Record.Kind)
+			return nil
+		})},
+	}
+	var c Controller
+	store, err := c.start(ctx, cfg)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if c.Store() != store {
+		t.Error("Store: expected the running store")
+	}
+	if _, err := c.start(ctx, cfg); err == nil {
+		t.Error("start again: expected error")
+	}
+	store.Add(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "host-1"},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "10.0.0.1"},
+				{Type: v1.NodeExternalIP, Address: "1.2.3.4"},
+			},
+		},
+	})
+	want := map[string][]string{"internal": {"10.0.0.1"}, "nodes": {"1.2.3.4"}}
+	if diff := cmp.Diff(s.Addresses("example.com"), want); diff != "" {
+		t.Errorf("addresses:\n%s", diff)
+	}
+	if diff := cmp.Diff(changes, []k8s.Kind{k8s.Internal, k8s.External}); diff != "" {
+		t.Errorf("changes sent to other sinks:\n%s", diff)
+	}
+}
+
+func TestStartInvalid(t *testing.T) {
+	for name, cfg := range map[string]Config{
+		"no zone":    {Token: "token", Records: map[k8s.Kind]string{k8s.External: "nodes"}},
+		"no records": {Token: "token", Zone: "example.com"},
+		"no token":   {Zone: "example.com", Records: map[k8s.Kind]string{k8s.External: "nodes"}},
+	} {
+		var c Controller
+		if _, err := c.start(context.Background(), cfg); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestEndToEnd(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	node := func(name, external string) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: v1.NodeStatus{
+				Addresses: []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: external}},
+			},
+		}
+	}
+	client := fake.NewSimpleClientset(node("host-1", "1.2.3.4"), node("host-2", "1.2.3.5"), node("canary", "1.2.3.6"))
+	provider := dns.NewFake(dns.FakeConfig{Latency: time.Millisecond}, dns.ProviderOptions{Zone: "example.com"})
+	// The first update fails, and is retried.
+	var failures int32
+	provider.Fail = func(record string) error {
+		if atomic.AddInt32(&failures, 1) == 1 {
+			return dns.ErrInjectedFailure
+		}
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var c Controller
+	errCh := make(chan error)
+	go func() {
+		errCh <- c.Run(ctx, Config{
+			Client:   client,
+			Provider: provider,
+			Records:  map[k8s.Kind]string{k8s.External: "nodes"},
+			RetryMin: time.Millisecond,
+			Eligible: func(n *v1.Node) string {
+				if n.Name == "canary" {
+					return "canary"
+				}
+				return ""
+			},
+		})
+	}()
+	waitFor := func(want map[string][]string) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for !cmp.Equal(provider.Addresses(), want) {
+			if time.Now().After(deadline) {
+				t.Fatalf("records:\n  got: %v\n want: %v", provider.Addresses(), want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor(map[string][]string{"nodes.example.com": {"1.2.3.4", "1.2.3.5"}})
+	if err := client.CoreV1().Nodes().Delete(ctx, "host-2", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(map[string][]string{"nodes.example.com": {"1.2.3.4"}})
+	if _, err := client.CoreV1().Nodes().Create(ctx, node("host-3", "1.2.3.7"), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(map[string][]string{"nodes.example.com": {"1.2.3.4", "1.2.3.7"}})
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Errorf("run: %v", err)
+	}
+	if atomic.LoadInt32(&failures) < 2 {
+		t.Error("expected the failed update to be retried")
+	}
+}
+
+func TestStartProvider(t *testing.T) {
+	var c Controller
+	provider := dns.NewFake(dns.FakeConfig{}, dns.ProviderOptions{Zone: "example.com"})
+	if _, err := c.start(context.Background(), Config{Provider: provider, Records: map[k8s.Kind]string{k8s.External: "nodes"}}); err != nil {
+		t.Errorf("start with a provider and no zone or token: %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package nodedns
+
+import (
+	"net"
+	"regexp"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/k8s"
+	v1 "k8s.io/api/core/v1"
+)
+
+// StorePolicy is which nodes and addresses a NodeStore publishes, and how it updates and retries
+// its records; the settings that every store the nodedns command runs shares, and that a
+// Controller's Config sets for its store.  Each field is the k8s.NodeStore field of the same
+// name.
+type StorePolicy struct {
+	UpdateTimeout               time.Duration
+	RetryMin, RetryMax          time.Duration
+	RetryLimit                  int
+	ConcurrentUpdates, Async    bool
+	ExcludeNames                []*regexp.Regexp
+	ExcludeAnnotation           string
+	IncludeNetworkUnavailable   bool
+	IncludeUnschedulable        bool
+	ExcludeConditions           []v1.NodeConditionType
+	Eligible                    func(n *v1.Node) (reason string)
+	NotReadyGrace, RemovalDelay time.Duration
+	ExcludeSpotExternal         bool
+	AllowPrivateExternal        bool
+	RejectPublicInternal        bool
+	Family                      string
+	ValidateExternal            bool
+	AnnouncedNetworks           []*net.IPNet
+	IncludeNetworks             []*net.IPNet
+	ExcludeNetworks             []*net.IPNet
+	VIPAnnotations              []string
+	VIPKind                     k8s.Kind
+	VIPReplace                  bool
+	PinAnnotation               string
+	AddressAnnotation           string
+	SampleReconciles            func() bool
+}
+
+// Apply configures the store with the policy.  Call it before the store is running.
+func (p *StorePolicy) Apply(st *k8s.NodeStore) {
+	st.UpdateTimeout = p.UpdateTimeout
+	st.RetryMin, st.RetryMax, st.RetryLimit = p.RetryMin, p.RetryMax, p.RetryLimit
+	st.ConcurrentUpdates, st.Async = p.ConcurrentUpdates, p.Async
+	st.ExcludeNames, st.ExcludeAnnotation = p.ExcludeNames, p.ExcludeAnnotation
+	st.IncludeNetworkUnavailable, st.IncludeUnschedulable = p.IncludeNetworkUnavailable, p.IncludeUnschedulable
+	st.ExcludeConditions, st.Eligible = p.ExcludeConditions, p.Eligible
+	st.NotReadyGrace, st.RemovalDelay = p.NotReadyGrace, p.RemovalDelay
+	st.ExcludeSpotExternal = p.ExcludeSpotExternal
+	st.AllowPrivateExternal, st.RejectPublicInternal = p.AllowPrivateExternal, p.RejectPublicInternal
+	st.Family = p.Family
+	st.ValidateExternal, st.AnnouncedNetworks = p.ValidateExternal, p.AnnouncedNetworks
+	st.IncludeNetworks, st.ExcludeNetworks = p.IncludeNetworks, p.ExcludeNetworks
+	st.VIPAnnotations, st.VIPKind, st.VIPReplace = p.VIPAnnotations, p.VIPKind, p.VIPReplace
+	st.PinAnnotation, st.AddressAnnotation = p.PinAnnotation, p.AddressAnnotation
+	st.SampleReconciles = p.SampleReconciles
+}
@@ -0,0 +1,92 @@
+package nodedns
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/jrockway/nodedns/pkg/dns"
+	"github.com/jrockway/nodedns/pkg/k8s"
+)
+
+// kinds are the classes of address that Records publishes, in the order that they're published.
+var kinds = []k8s.Kind{k8s.Internal, k8s.External, k8s.Overlay}
+
+// Records are the record that each class of address is published to, and the provider that
+// publishes it; for split-horizon dns, the internal and external records can be in different
+// zones, or with different providers.
+type Records struct {
+	// Names are the records, relative to the zone of their provider.  Classes without a record
+	// aren't published.
+	Names map[k8s.Kind]string
+
+	// Providers publish the records of the classes that have their own provider; Default
+	// publishes the rest.
+	Providers map[k8s.Kind]dns.Provider
+	Default   dns.Provider
+}
+
+// Name returns the record that the class of address is published to, or "" if it isn't published.
+func (r *Records) Name(kind k8s.Kind) string {
+	return r.Names[kind]
+}
+
+// Provider returns the provider that publishes the record of the class.
+func (r *Records) Provider(kind k8s.Kind) dns.Provider {
+	if p, ok := r.Providers[kind]; ok {
+		return p
+	}
+	return r.Default
+}
+
+// FQDNs returns the fully-qualified name of each record, by class; for k8s.NodeStore.RecordNames.
+func (r *Records) FQDNs() map[k8s.Kind][]string {
+	result := make(map[k8s.Kind][]string)
+	for _, kind := range kinds {
+		if name := r.Names[kind]; name != "" {
+			result[kind] = []string{r.Provider(kind).FQDN(name)}
+		}
+	}
+	return result
+}
+
+// Publishes returns true if one of the records has the fully-qualified name.
+func (r *Records) Publishes(fqdn string) bool {
+	for _, kind := range kinds {
+		if name := r.Names[kind]; name != "" && strings.EqualFold(r.Provider(kind).FQDN(name), fqdn) {
+			return true
+		}
+	}
+	return false
+}
+
+// ByProvider returns every provider that publishes a record, and the records that each one
+// publishes, relative to its zone.  If there are no records, Default is returned alone.
+func (r *Records) ByProvider() ([]dns.Provider, map[dns.Provider][]string) {
+	var providers []dns.Provider
+	names := make(map[dns.Provider][]string)
+	for _, kind := range kinds {
+		name := r.Names[kind]
+		if name == "" {
+			continue
+		}
+		p := r.Provider(kind)
+		if _, ok := names[p]; !ok {
+			providers = append(providers, p)
+		}
+		names[p] = append(names[p], name)
+	}
+	if len(providers) == 0 && r.Default != nil {
+		providers = append(providers, r.Default)
+	}
+	return providers, names
+}
+
+// Update publishes the addresses to the record of the class, if it has one.
+func (r *Records) Update(ctx context.Context, kind k8s.Kind, addresses []net.IP) error {
+	name := r.Names[kind]
+	if name == "" {
+		return nil
+	}
+	return r.Provider(kind).UpdateDNS(ctx, name, addresses)
+}
@@ -0,0 +1,122 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// ICMP is a Probe that succeeds if the address replies to an ICMP echo request.
+//
+// Unprivileged ICMP sockets are used if the kernel allows them (see the net.ipv4.ping_group_range
+// sysctl on Linux); otherwise a raw socket is used, which requires CAP_NET_RAW.
+type ICMP struct{}
+
+const (
+	protocolICMP     = 1
+	protocolICMPv6   = 58
+	icmpProbeTimeout = 5 * time.Second // Used if the context has no deadline.
+)
+
+func (p *ICMP) String() string { return "icmp" }
+
+// listen opens an ICMP socket, preferring an unprivileged one.  It returns whether the socket is
+// privileged; unprivileged sockets have their echo ID rewritten by the kernel.
+func listenICMP(v6 bool) (*icmp.PacketConn, bool, error) {
+	unprivileged, privileged, addr := "udp4", "ip4:icmp", "0.0.0.0"
+	if v6 {
+		unprivileged, privileged, addr = "udp6", "ip6:ipv6-icmp", "::"
+	}
+	conn, err := icmp.ListenPacket(unprivileged, addr)
+	if err == nil {
+		return conn, false, nil
+	}
+	conn, perr := icmp.ListenPacket(privileged, addr)
+	if perr != nil {
+		return nil, false, fmt.Errorf("listen: unprivileged: %v; privileged: %w", err, perr)
+	}
+	return conn, true, nil
+}
+
+// Check implements Probe.
+func (p *ICMP) Check(ctx context.Context, ip net.IP) error {
+	v6 := ip.To4() == nil
+	conn, privileged, err := listenICMP(v6)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(icmpProbeTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return fmt.Errorf("set deadline: %w", err)
+	}
+	go func() {
+		// Unblock the read if the context is cancelled before the deadline.
+		<-ctx.Done()
+		conn.SetDeadline(time.Now()) // nolint:errcheck
+	}()
+
+	var reqType, replyType icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	proto := protocolICMP
+	if v6 {
+		reqType, replyType = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+		proto = protocolICMPv6
+	}
+	id, seq := os.Getpid()&0xffff, rand.Intn(0xffff) // nolint:gosec
+	msg := icmp.Message{
+		Type: reqType,
+		Body: &icmp.Echo{ID: id, Seq: seq, Data: []byte("nodedns")},
+	}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return fmt.Errorf("marshal echo request: %w", err)
+	}
+	var dst net.Addr = &net.UDPAddr{IP: ip}
+	if privileged {
+		dst = &net.IPAddr{IP: ip}
+	}
+	if _, err := conn.WriteTo(b, dst); err != nil {
+		return fmt.Errorf("send echo request: %w", err)
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return fmt.Errorf("wait for echo reply: %w", ctxErr)
+			}
+			return fmt.Errorf("wait for echo reply: %w", err)
+		}
+		var peerIP net.IP
+		switch a := peer.(type) {
+		case *net.UDPAddr:
+			peerIP = a.IP
+		case *net.IPAddr:
+			peerIP = a.IP
+		}
+		if !peerIP.Equal(ip) {
+			continue
+		}
+		reply, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil {
+			continue
+		}
+		echo, ok := reply.Body.(*icmp.Echo)
+		if reply.Type != replyType || !ok || echo.Seq != seq || (privileged && echo.ID != id) {
+			continue
+		}
+		return nil
+	}
+}
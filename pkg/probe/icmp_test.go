@@ -0,0 +1,23 @@
+package probe
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestICMP(t *testing.T) {
+	conn, _, err := listenICMP(false)
+	if err != nil {
+		t.Skipf("icmp sockets unavailable: %v", err)
+	}
+	conn.Close()
+
+	p := &ICMP{}
+	ctx, c := context.WithTimeout(context.Background(), time.Second)
+	defer c()
+	if err := p.Check(ctx, net.IPv4(127, 0, 0, 1)); err != nil {
+		t.Errorf("ping localhost: %v", err)
+	}
+}
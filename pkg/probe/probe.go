@@ -0,0 +1,428 @@
+// Package probe checks that node addresses are actually serving before they are published to DNS.
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+var (
+	probeSuccess = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "probe_success",
+			Help: "Whether the most recent probe of an address succeeded (1) or failed (0), by prober, probe, and address.",
+		},
+		[]string{"prober", "probe", "address"},
+	)
+	probeChecks = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "probe_checks",
+			Help: "The number of times that an address was probed, by prober, probe, address, and result (\"success\" or \"failure\"); for success rates.",
+		},
+		[]string{"prober", "probe", "address", "result"},
+	)
+	probeDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "probe_duration_seconds",
+			Help:    "How long each probe of an address took, by prober, probe, and address.",
+			Buckets: []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+		},
+		[]string{"prober", "probe", "address"},
+	)
+	probeHealthScore = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "probe_health_score",
+			Help: "An exponentially-weighted moving average of whether an address passed every probe, from 0 (always failing) to 1 (always passing), by prober and address.",
+		},
+		[]string{"prober", "address"},
+	)
+)
+
+// scoreWeight is the weight of the most recent check in an address's health score; about the last
+// 10 checks contribute to it.
+const scoreWeight = 0.2
+
+// Probe checks whether a single address is healthy.
+type Probe interface {
+	// Check returns nil if the address is healthy.
+	Check(ctx context.Context, ip net.IP) error
+	// String returns a description of the probe, for logging.
+	String() string
+}
+
+// TCP is a Probe that succeeds if a TCP connection can be established to the port.
+type TCP struct {
+	Port int
+}
+
+// Check implements Probe.
+func (p *TCP) Check(ctx context.Context, ip net.IP) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), strconv.Itoa(p.Port)))
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	return conn.Close()
+}
+
+func (p *TCP) String() string { return fmt.Sprintf("tcp:%d", p.Port) }
+
+// HTTP is a Probe that succeeds if an HTTP GET request to the path returns the expected status.
+type HTTP struct {
+	TLS            bool   // If true, use https.  Certificates are not verified.
+	Port           int    // The port to connect to.
+	Path           string // The path to request.
+	ExpectedStatus int    // The status code that indicates success.
+}
+
+var insecureTransport = &http.Transport{
+	TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, // nolint:gosec
+	DisableKeepAlives: true,
+}
+
+// Check implements Probe.
+func (p *HTTP) Check(ctx context.Context, ip net.IP) error {
+	scheme := "http"
+	if p.TLS {
+		scheme = "https"
+	}
+	url := fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(ip.String(), strconv.Itoa(p.Port)), p.Path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("User-Agent", "nodedns-probe")
+	res, err := insecureTransport.RoundTrip(req)
+	if err != nil {
+		return fmt.Errorf("get %s: %w", url, err)
+	}
+	res.Body.Close()
+	if res.StatusCode != p.ExpectedStatus {
+		return fmt.Errorf("get %s: unexpected status %s", url, res.Status)
+	}
+	return nil
+}
+
+func (p *HTTP) String() string {
+	scheme := "http"
+	if p.TLS {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s:%d%s", scheme, p.Port, p.Path)
+}
+
+// Parse parses a probe specification.  Supported formats are "tcp:<port>", "http:<port><path>",
+// "https:<port><path>", and "icmp", for example "tcp:6443" or "https:443/healthz".  HTTP probes
+// expect expectedStatus in the response.
+func Parse(spec string, expectedStatus int) (Probe, error) {
+	if spec == "icmp" {
+		return &ICMP{}, nil
+	}
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("probe %q: expected <protocol>:<port>", spec)
+	}
+	proto, rest := parts[0], parts[1]
+	path := "/"
+	if i := strings.Index(rest, "/"); i >= 0 {
+		rest, path = rest[:i], rest[i:]
+	}
+	port, err := strconv.Atoi(rest)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("probe %q: invalid port %q", spec, rest)
+	}
+	switch proto {
+	case "tcp":
+		if path != "/" {
+			return nil, fmt.Errorf("probe %q: tcp probes do not take a path", spec)
+		}
+		return &TCP{Port: port}, nil
+	case "http", "https":
+		return &HTTP{TLS: proto == "https", Port: port, Path: path, ExpectedStatus: expectedStatus}, nil
+	}
+	return nil, fmt.Errorf("probe %q: unknown protocol %q", spec, proto)
+}
+
+// Prober runs a set of probes against addresses.
+type Prober struct {
+	Name    string        // The name of the prober, for metrics.
+	Probes  []Probe       // The probes that every address must pass.
+	Timeout time.Duration // How long each probe may take.
+	Logger  *zap.Logger
+
+	// FailureThreshold and SuccessThreshold add hysteresis, so that transient packet loss
+	// doesn't make addresses flap in and out of DNS.  An address that's been passing is only
+	// filtered out after failing FailureThreshold checks in a row, and an address that's been
+	// failing is only published again after passing SuccessThreshold checks in a row.  An
+	// address's first check decides whether it's published right away.  Zero means 1.
+	FailureThreshold, SuccessThreshold int
+	// Periodic is true if Recheck runs between updates.  Then only its checks count towards the
+	// thresholds, and Filter only probes addresses that haven't been checked yet, using the most
+	// recent check of the others, so that the thresholds don't depend on how often records are
+	// updated.
+	Periodic bool
+
+	mu    sync.Mutex
+	state map[string]*addressState // The state of each address that has been checked.
+}
+
+// addressState is the history of an address's checks.
+type addressState struct {
+	score  float64 // The health score; see probe_health_score.
+	up     bool    // Whether the address is published.
+	streak int     // How many checks in a row disagreed with up.
+	err    error   // The error of the most recent check.
+}
+
+// Check runs every probe against the address, returning the first error encountered.  Every probe
+// is run, even if an earlier one fails, so that the probe_success metric is accurate for each
+// probe.
+func (p *Prober) Check(ctx context.Context, ip net.IP) error {
+	_, err := p.check(ctx, ip)
+	return err
+}
+
+// check is like Check, but also returns whether the address should be published, after hysteresis.
+func (p *Prober) check(ctx context.Context, ip net.IP) (bool, error) {
+	var result error
+	for _, probe := range p.Probes {
+		tctx, c := context.WithTimeout(ctx, p.Timeout)
+		start := time.Now()
+		err := probe.Check(tctx, ip)
+		probeDuration.WithLabelValues(p.Name, probe.String(), ip.String()).Observe(time.Since(start).Seconds())
+		c()
+		success := probeSuccess.WithLabelValues(p.Name, probe.String(), ip.String())
+		if err != nil {
+			success.Set(0)
+			probeChecks.WithLabelValues(p.Name, probe.String(), ip.String(), "failure").Inc()
+			if result == nil {
+				result = fmt.Errorf("%s: %w", probe.String(), err)
+			}
+			continue
+		}
+		success.Set(1)
+		probeChecks.WithLabelValues(p.Name, probe.String(), ip.String(), "success").Inc()
+	}
+	return p.observe(ip, result), result
+}
+
+func threshold(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// observe records the result of a check of the address, updating its health score, and returns
+// whether the address should be published.
+func (p *Prober) observe(ip net.IP, err error) bool {
+	ok := err == nil
+	var x float64
+	if ok {
+		x = 1
+	}
+	addr := ip.String()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state == nil {
+		p.state = make(map[string]*addressState)
+	}
+	st, seen := p.state[addr]
+	switch {
+	case !seen:
+		st = &addressState{score: x, up: ok}
+		p.state[addr] = st
+	case ok == st.up:
+		st.score += scoreWeight * (x - st.score)
+		st.streak = 0
+	default:
+		st.score += scoreWeight * (x - st.score)
+		st.streak++
+		limit := threshold(p.SuccessThreshold)
+		if st.up {
+			limit = threshold(p.FailureThreshold)
+		}
+		if st.streak >= limit {
+			st.up, st.streak = ok, 0
+		}
+	}
+	st.err = err
+	probeHealthScore.WithLabelValues(p.Name, addr).Set(st.score)
+	return st.up
+}
+
+// Prune forgets the addresses that aren't in ips, the current contents of every record that the
+// prober checks, and deletes their metrics, so that addresses of nodes that went away don't
+// accumulate.
+func (p *Prober) Prune(ips []net.IP) {
+	if p == nil {
+		return
+	}
+	keep := make(map[string]bool, len(ips))
+	for _, ip := range ips {
+		keep[ip.String()] = true
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for addr := range p.state {
+		if keep[addr] {
+			continue
+		}
+		delete(p.state, addr)
+		probeHealthScore.DeleteLabelValues(p.Name, addr)
+		for _, probe := range p.Probes {
+			probeSuccess.DeleteLabelValues(p.Name, probe.String(), addr)
+			probeDuration.DeleteLabelValues(p.Name, probe.String(), addr)
+			probeChecks.DeleteLabelValues(p.Name, probe.String(), addr, "success")
+			probeChecks.DeleteLabelValues(p.Name, probe.String(), addr, "failure")
+		}
+	}
+}
+
+// Score returns the address's health score, from 0 (always failing its probes) to 1 (always
+// passing them), weighted towards recent checks, or false if it hasn't been probed.
+func (p *Prober) Score(ip net.IP) (float64, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st, ok := p.state[ip.String()]
+	if !ok {
+		return 0, false
+	}
+	return st.score, true
+}
+
+// Filter probes each address concurrently and returns the addresses that pass every probe, or,
+// with hysteresis, that haven't failed enough checks in a row to be removed.  If the prober is
+// Periodic, only addresses that haven't been checked are probed.  The order of the input is
+// preserved.
+func (p *Prober) Filter(ctx context.Context, ips []net.IP) []net.IP {
+	if p == nil || len(p.Probes) == 0 {
+		return ips
+	}
+	ctx, span := tracing.Start(ctx, "probe")
+	defer span.End()
+
+	var up []bool
+	var errs []error
+	if p.Periodic {
+		up, errs = p.checkNew(ctx, ips)
+	} else {
+		up, errs = p.checkAll(ctx, ips)
+	}
+	result := make([]net.IP, 0, len(ips))
+	for i, ip := range ips {
+		err := errs[i]
+		switch {
+		case !up[i] && err != nil:
+			p.Logger.Info("address failed probe; not publishing", zap.String("prober", p.Name), zap.Stringer("address", ip), zap.Error(err))
+			continue
+		case !up[i]:
+			p.Logger.Info("address passed probe; not publishing until it passes more in a row", zap.String("prober", p.Name), zap.Stringer("address", ip))
+			continue
+		case err != nil:
+			p.Logger.Info("address failed probe; still publishing until it fails more in a row", zap.String("prober", p.Name), zap.Stringer("address", ip), zap.Error(err))
+		}
+		result = append(result, ip)
+	}
+	span.SetAttributes(attribute.Int("probe.failed", len(ips)-len(result)))
+	return result
+}
+
+// checkAll checks each address concurrently, returning whether each should be published and the
+// error of each check.
+func (p *Prober) checkAll(ctx context.Context, ips []net.IP) ([]bool, []error) {
+	errs := make([]error, len(ips))
+	up := make([]bool, len(ips))
+	var wg sync.WaitGroup
+	for i, ip := range ips {
+		wg.Add(1)
+		go func(i int, ip net.IP) {
+			defer wg.Done()
+			up[i], errs[i] = p.check(ctx, ip)
+		}(i, ip)
+	}
+	wg.Wait()
+	return up, errs
+}
+
+// checkNew is like checkAll, but only checks the addresses that haven't been checked before; the
+// others get the result of their most recent check.
+func (p *Prober) checkNew(ctx context.Context, ips []net.IP) ([]bool, []error) {
+	up := make([]bool, len(ips))
+	errs := make([]error, len(ips))
+	var unchecked []net.IP
+	var index []int
+	p.mu.Lock()
+	for i, ip := range ips {
+		if st, ok := p.state[ip.String()]; ok {
+			up[i], errs[i] = st.up, st.err
+			continue
+		}
+		unchecked = append(unchecked, ip)
+		index = append(index, i)
+	}
+	p.mu.Unlock()
+	newUp, newErrs := p.checkAll(ctx, unchecked)
+	for j, i := range index {
+		up[i], errs[i] = newUp[j], newErrs[j]
+	}
+	return up, errs
+}
+
+// Recheck probes the addresses again, between updates, and returns true if any of them should
+// now be published or withheld differently than before the check, or hasn't been checked before;
+// the caller then updates the records that contain them, which filters them again.
+func (p *Prober) Recheck(ctx context.Context, ips []net.IP) bool {
+	if p == nil || len(p.Probes) == 0 {
+		return false
+	}
+	ctx, span := tracing.Start(ctx, "reprobe")
+	defer span.End()
+
+	before := make([]bool, len(ips))
+	seen := make([]bool, len(ips))
+	p.mu.Lock()
+	for i, ip := range ips {
+		if st, ok := p.state[ip.String()]; ok {
+			before[i], seen[i] = st.up, true
+		}
+	}
+	p.mu.Unlock()
+	up, errs := p.checkAll(ctx, ips)
+	changed := false
+	for i, ip := range ips {
+		if seen[i] && up[i] == before[i] {
+			continue
+		}
+		changed = true
+		p.Logger.Info("address changed health between updates", zap.String("prober", p.Name), zap.Stringer("address", ip), zap.Bool("publish", up[i]), zap.Error(errs[i]))
+	}
+	return changed
+}
+
+// NewProber parses the provided probe specifications (see Parse) and returns a Prober with the
+// provided name.  A Prober with no probes passes every address.
+func NewProber(name string, specs []string, expectedStatus int, timeout time.Duration) (*Prober, error) {
+	p := &Prober{Name: name, Timeout: timeout, Logger: zap.L().Named("probe")}
+	for _, spec := range specs {
+		probe, err := Parse(spec, expectedStatus)
+		if err != nil {
+			return nil, err
+		}
+		p.Probes = append(p.Probes, probe)
+	}
+	return p, nil
+}
@@ -0,0 +1,221 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+)
+
+func TestParse(t *testing.T) {
+	testData := []struct {
+		spec    string
+		want    Probe
+		wantErr bool
+	}{
+		{spec: "tcp:443", want: &TCP{Port: 443}},
+		{spec: "http:80", want: &HTTP{Port: 80, Path: "/", ExpectedStatus: 200}},
+		{spec: "https:8443/healthz", want: &HTTP{TLS: true, Port: 8443, Path: "/healthz", ExpectedStatus: 200}},
+		{spec: "icmp", want: &ICMP{}},
+		{spec: "icmp:1", wantErr: true},
+		{spec: "tcp:443/foo", wantErr: true},
+		{spec: "tcp", wantErr: true},
+		{spec: "tcp:0", wantErr: true},
+		{spec: "tcp:http", wantErr: true},
+		{spec: "udp:53", wantErr: true},
+	}
+	for _, test := range testData {
+		got, err := Parse(test.spec, 200)
+		if err != nil {
+			if !test.wantErr {
+				t.Errorf("%s: unexpected error: %v", test.spec, err)
+			}
+			continue
+		}
+		if test.wantErr {
+			t.Errorf("%s: expected error", test.spec)
+		}
+		if diff := cmp.Diff(got, test.want); diff != "" {
+			t.Errorf("%s:\n%s", test.spec, diff)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/healthz" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	defer s.Close()
+	_, portStr, err := net.SplitHostPort(s.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 192.0.2.1 (TEST-NET-1) is not routable, so the connection times out.
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv4(192, 0, 2, 1)}
+
+	testData := []struct {
+		name  string
+		probe Probe
+		want  []net.IP
+	}{
+		{name: "tcp", probe: &TCP{Port: port}, want: []net.IP{net.IPv4(127, 0, 0, 1)}},
+		{name: "http ok", probe: &HTTP{Port: port, Path: "/healthz", ExpectedStatus: http.StatusOK}, want: []net.IP{net.IPv4(127, 0, 0, 1)}},
+		{name: "http wrong status", probe: &HTTP{Port: port, Path: "/", ExpectedStatus: http.StatusOK}, want: []net.IP{}},
+	}
+	for _, test := range testData {
+		p := &Prober{Probes: []Probe{test.probe}, Timeout: 100 * time.Millisecond, Logger: l}
+		got := p.Filter(context.Background(), ips)
+		if diff := cmp.Diff(got, test.want); diff != "" {
+			t.Errorf("%s:\n%s", test.name, diff)
+		}
+	}
+
+	var p *Prober
+	if diff := cmp.Diff(p.Filter(context.Background(), ips), ips); diff != "" {
+		t.Errorf("nil prober:\n%s", diff)
+	}
+}
+
+// scriptedProbe is a Probe that fails when the next entry of its script is false.
+type scriptedProbe struct {
+	script []bool
+}
+
+func (p *scriptedProbe) Check(ctx context.Context, ip net.IP) error {
+	ok := p.script[0]
+	p.script = p.script[1:]
+	if !ok {
+		return errors.New("injected failure")
+	}
+	return nil
+}
+
+func (p *scriptedProbe) String() string { return "scripted" }
+
+func TestScore(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ip := net.IPv4(127, 0, 0, 1)
+	p := &Prober{Name: "test", Probes: []Probe{&scriptedProbe{script: []bool{true, false, false, true}}}, Timeout: time.Second, Logger: l}
+	if _, ok := p.Score(ip); ok {
+		t.Error("unprobed address has a score")
+	}
+	for i, want := range []float64{1, 0.8, 0.64, 0.712} {
+		p.Check(context.Background(), ip) // nolint:errcheck
+		got, ok := p.Score(ip)
+		if !ok || math.Abs(got-want) > 1e-9 {
+			t.Errorf("check %d:\n  got: %v, %v\n want: %v, true", i, got, ok, want)
+		}
+	}
+}
+
+func TestHysteresis(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ip := net.IPv4(127, 0, 0, 1)
+	script := []bool{true, false, false, true, false, false, false, true, false, true, true}
+	want := []bool{true, true, true, true, true, true, false, false, false, false, true}
+	p := &Prober{Name: "test", Probes: []Probe{&scriptedProbe{script: script}}, Timeout: time.Second, Logger: l, FailureThreshold: 3, SuccessThreshold: 2}
+	for i := range script {
+		got := len(p.Filter(context.Background(), []net.IP{ip})) == 1
+		if got != want[i] {
+			t.Errorf("check %d:\n  got: %v\n want: %v", i, got, want[i])
+		}
+	}
+
+	// An address whose first check fails isn't published until it passes enough checks.
+	p = &Prober{Name: "test", Probes: []Probe{&scriptedProbe{script: []bool{false, true, true}}}, Timeout: time.Second, Logger: l, FailureThreshold: 3, SuccessThreshold: 2}
+	for i, want := range []bool{false, false, true} {
+		if got := len(p.Filter(context.Background(), []net.IP{ip})) == 1; got != want {
+			t.Errorf("new address, check %d:\n  got: %v\n want: %v", i, got, want)
+		}
+	}
+}
+
+func TestRecheck(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ip := net.IPv4(127, 0, 0, 1)
+	p := &Prober{Name: "test", Probes: []Probe{&scriptedProbe{script: []bool{true, true, false, false, true, true}}}, Timeout: time.Second, Logger: l, FailureThreshold: 2}
+	if got := p.Filter(context.Background(), []net.IP{ip}); len(got) != 1 {
+		t.Fatalf("first check:\n  got: %v\n want: [%v]", got, ip)
+	}
+	// Passing again, failing once (below the threshold), failing again, passing again.
+	for i, want := range []bool{false, false, true, true} {
+		if got := p.Recheck(context.Background(), []net.IP{ip}); got != want {
+			t.Errorf("recheck %d:\n  got: %v\n want: %v", i, got, want)
+		}
+	}
+	if got := p.Recheck(context.Background(), []net.IP{net.IPv4(127, 0, 0, 2)}); !got {
+		t.Error("recheck of unchecked address: expected a change")
+	}
+	if got := (&Prober{}).Recheck(context.Background(), []net.IP{ip}); got {
+		t.Error("recheck without probes: expected no change")
+	}
+}
+
+func TestPeriodic(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	ip := net.IPv4(127, 0, 0, 1)
+	// Updates only probe the address the first time; after that, only rechecks count.
+	p := &Prober{Name: "test", Probes: []Probe{&scriptedProbe{script: []bool{true, false, false}}}, Timeout: time.Second, Logger: l, FailureThreshold: 2, Periodic: true}
+	for i, want := range []int{1, 1, 1} {
+		if got := p.Filter(context.Background(), []net.IP{ip}); len(got) != want {
+			t.Errorf("filter %d:\n  got: %v\n want: %d addresses", i, got, want)
+		}
+	}
+	for i, want := range []bool{false, true} {
+		if got := p.Recheck(context.Background(), []net.IP{ip}); got != want {
+			t.Errorf("recheck %d:\n  got: %v\n want: %v", i, got, want)
+		}
+	}
+	if got := p.Filter(context.Background(), []net.IP{ip}); len(got) != 0 {
+		t.Errorf("filter after failing rechecks:\n  got: %v\n want: []", got)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	l := zaptest.NewLogger(t)
+	zap.ReplaceGlobals(l)
+	a, b := net.IPv4(127, 0, 0, 1), net.IPv4(127, 0, 0, 2)
+	p := &Prober{Name: "prune", Probes: []Probe{&scriptedProbe{script: []bool{true, true}}}, Timeout: time.Second, Logger: l}
+	// One at a time, because scriptedProbe isn't safe for concurrent checks.
+	p.Filter(context.Background(), []net.IP{a})
+	p.Filter(context.Background(), []net.IP{b})
+	p.Prune([]net.IP{a})
+	if _, ok := p.Score(a); !ok {
+		t.Error("kept address: expected a score")
+	}
+	if _, ok := p.Score(b); ok {
+		t.Error("pruned address: expected no score")
+	}
+	if probeHealthScore.DeleteLabelValues("prune", b.String()) || probeSuccess.DeleteLabelValues("prune", "scripted", b.String()) {
+		t.Error("pruned address: expected its metrics to be deleted")
+	}
+	if !probeHealthScore.DeleteLabelValues("prune", a.String()) {
+		t.Error("kept address: expected its metrics to be kept")
+	}
+	(*Prober)(nil).Prune(nil)
+}
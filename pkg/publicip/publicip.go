@@ -0,0 +1,215 @@
+// Package publicip discovers the public IP address that this program's traffic appears to come
+// from, for clusters behind NAT whose nodes don't know their own public address.
+package publicip
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/tracing"
+)
+
+// Discoverer returns the public IP address of the caller.
+type Discoverer interface {
+	Discover(ctx context.Context) (net.IP, error)
+}
+
+// New returns a Discoverer for the provided source.  Sources of the form "stun:<host>:<port>" use a
+// STUN binding request; http:// and https:// URLs are fetched with GET and are expected to return
+// the address (and optionally whitespace) as the body, like https://api.ipify.org does.
+func New(source string) (Discoverer, error) {
+	switch {
+	case strings.HasPrefix(source, "stun:"):
+		addr := strings.TrimPrefix(source, "stun:")
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return nil, fmt.Errorf("stun server %q: %w", addr, err)
+		}
+		return &STUN{Server: addr}, nil
+	case strings.HasPrefix(source, "http://"), strings.HasPrefix(source, "https://"):
+		return &HTTP{URL: source, Client: &http.Client{Transport: tracing.WrapRoundTripper(nil)}}, nil
+	}
+	return nil, fmt.Errorf("unknown public ip source %q; expected stun:<host>:<port> or a url", source)
+}
+
+// HTTP discovers the public IP address by fetching a "what's my IP" URL.
+type HTTP struct {
+	URL    string
+	Client *http.Client
+}
+
+// Discover implements Discoverer.
+func (h *HTTP) Discover(ctx context.Context) (net.IP, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	res, err := h.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", h.URL, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get %s: unexpected status %s", h.URL, res.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	if err != nil {
+		return nil, fmt.Errorf("read body: %w", err)
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil {
+		return nil, fmt.Errorf("get %s: response %q is not an ip address", h.URL, body)
+	}
+	return ip, nil
+}
+
+// STUN discovers the public IP address with a STUN (RFC 5389) binding request.
+type STUN struct {
+	Server string // host:port of the STUN server.
+}
+
+const (
+	stunBindingRequest   = 0x0001
+	stunBindingSuccess   = 0x0101
+	stunMagicCookie      = 0x2112A442
+	stunMappedAddress    = 0x0001
+	stunXORMappedAddress = 0x0020
+	stunHeaderLength     = 20
+	stunRetransmit       = 500 * time.Millisecond
+)
+
+// Discover implements Discoverer.
+func (s *STUN) Discover(ctx context.Context) (net.IP, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", s.Server)
+	if err != nil {
+		return nil, fmt.Errorf("dial stun server: %w", err)
+	}
+	defer conn.Close()
+
+	req := make([]byte, stunHeaderLength)
+	binary.BigEndian.PutUint16(req[0:2], stunBindingRequest)
+	binary.BigEndian.PutUint32(req[4:8], stunMagicCookie)
+	txid := req[8:20]
+	if _, err := rand.Read(txid); err != nil {
+		return nil, fmt.Errorf("generate transaction id: %w", err)
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("wait for stun response: %w", err)
+		}
+		if _, err := conn.Write(req); err != nil {
+			return nil, fmt.Errorf("send binding request: %w", err)
+		}
+		// UDP is lossy, so retransmit the request periodically until the context expires.
+		deadline := time.Now().Add(stunRetransmit)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return nil, fmt.Errorf("set deadline: %w", err)
+		}
+		n, err := conn.Read(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return nil, fmt.Errorf("read stun response: %w", err)
+		}
+		ip, err := parseBindingResponse(buf[:n], txid)
+		if err != nil {
+			return nil, err
+		}
+		return ip, nil
+	}
+}
+
+// parseBindingResponse extracts the mapped address from a STUN binding success response.
+func parseBindingResponse(msg, txid []byte) (net.IP, error) {
+	if len(msg) < stunHeaderLength {
+		return nil, errors.New("stun response too short")
+	}
+	if t := binary.BigEndian.Uint16(msg[0:2]); t != stunBindingSuccess {
+		return nil, fmt.Errorf("unexpected stun message type %#04x", t)
+	}
+	if !bytes.Equal(msg[8:20], txid) {
+		return nil, errors.New("stun response has wrong transaction id")
+	}
+	length := int(binary.BigEndian.Uint16(msg[2:4]))
+	if len(msg) < stunHeaderLength+length {
+		return nil, errors.New("stun response truncated")
+	}
+	attrs := msg[stunHeaderLength : stunHeaderLength+length]
+	var mapped net.IP
+	for len(attrs) >= 4 {
+		typ := binary.BigEndian.Uint16(attrs[0:2])
+		alen := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if len(attrs) < 4+alen {
+			return nil, errors.New("stun attribute truncated")
+		}
+		value := attrs[4 : 4+alen]
+		switch typ {
+		case stunXORMappedAddress:
+			ip, err := parseAddress(value, msg[4:20])
+			if err != nil {
+				return nil, fmt.Errorf("parse XOR-MAPPED-ADDRESS: %w", err)
+			}
+			return ip, nil
+		case stunMappedAddress:
+			ip, err := parseAddress(value, nil)
+			if err != nil {
+				return nil, fmt.Errorf("parse MAPPED-ADDRESS: %w", err)
+			}
+			mapped = ip
+		}
+		// Attributes are padded to a multiple of 4 bytes.
+		next := 4 + (alen+3)&^3
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+	if mapped == nil {
+		return nil, errors.New("stun response contains no mapped address")
+	}
+	return mapped, nil
+}
+
+// parseAddress parses a (XOR-)MAPPED-ADDRESS attribute value.  If xor is non-nil, the address is
+// XORed with it (the magic cookie followed by the transaction ID).
+func parseAddress(value, xor []byte) (net.IP, error) {
+	if len(value) < 4 {
+		return nil, errors.New("too short")
+	}
+	var ip net.IP
+	switch family := value[1]; family {
+	case 0x01:
+		ip = make(net.IP, net.IPv4len)
+	case 0x02:
+		ip = make(net.IP, net.IPv6len)
+	default:
+		return nil, fmt.Errorf("unknown address family %#02x", family)
+	}
+	if len(value) < 4+len(ip) {
+		return nil, errors.New("too short")
+	}
+	copy(ip, value[4:])
+	for i := range ip {
+		if xor != nil {
+			ip[i] ^= xor[i]
+		}
+	}
+	return ip, nil
+}
@@ -0,0 +1,109 @@
+package publicip
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// stunServer answers every binding request with a XOR-MAPPED-ADDRESS containing mapped.
+func stunServer(t *testing.T, mapped net.IP) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, peer, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < stunHeaderLength {
+				continue
+			}
+			ip := mapped.To4()
+			family := byte(0x01)
+			if ip == nil {
+				ip = mapped.To16()
+				family = 0x02
+			}
+			res := make([]byte, stunHeaderLength+4+4+len(ip))
+			binary.BigEndian.PutUint16(res[0:2], stunBindingSuccess)
+			binary.BigEndian.PutUint16(res[2:4], uint16(4+4+len(ip)))
+			copy(res[4:20], buf[4:20])
+			attr := res[stunHeaderLength:]
+			binary.BigEndian.PutUint16(attr[0:2], stunXORMappedAddress)
+			binary.BigEndian.PutUint16(attr[2:4], uint16(4+len(ip)))
+			attr[5] = family
+			for i := range ip {
+				attr[8+i] = ip[i] ^ buf[4+i]
+			}
+			conn.WriteTo(res, peer) // nolint:errcheck
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestSTUN(t *testing.T) {
+	for _, want := range []net.IP{net.ParseIP("203.0.113.7").To4(), net.ParseIP("2001:db8::7")} {
+		d, err := New("stun:" + stunServer(t, want))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, c := context.WithTimeout(context.Background(), time.Second)
+		got, err := d.Discover(ctx)
+		c()
+		if err != nil {
+			t.Fatalf("discover: %v", err)
+		}
+		if !got.Equal(want) {
+			t.Errorf("discover: got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/bad" {
+			w.Write([]byte("<html>hi</html>")) // nolint:errcheck
+			return
+		}
+		w.Write([]byte("203.0.113.7\n")) // nolint:errcheck
+	}))
+	defer s.Close()
+
+	d, err := New(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.Discover(context.Background())
+	if err != nil {
+		t.Fatalf("discover: %v", err)
+	}
+	if want := net.IPv4(203, 0, 113, 7); !got.Equal(want) {
+		t.Errorf("discover: got %v, want %v", got, want)
+	}
+
+	d, err = New(s.URL + "/bad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Discover(context.Background()); err == nil {
+		t.Error("discover with invalid response: expected error")
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, source := range []string{"", "stun:no-port", "ftp://example.com"} {
+		if _, err := New(source); err == nil {
+			t.Errorf("%q: expected error", source)
+		}
+	}
+}
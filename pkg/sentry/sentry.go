@@ -0,0 +1,206 @@
+// Package sentry reports errors that need a person's attention to a Sentry-compatible error
+// tracker (Sentry itself, GlitchTip, and the like), with the store, sink, record, and zone that
+// they concern attached.
+package sentry
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"github.com/jrockway/nodedns/pkg/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var (
+	eventsSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sentry_events",
+		Help: "The number of errors reported to the error tracker, by result (\"ok\", \"error\", or \"dropped\" because too many were queued).",
+	}, []string{"result"})
+)
+
+// Config configures error reporting.
+type Config struct {
+	DSN         string        `long:"sentry_dsn" env:"SENTRY_DSN" description:"report errors that need attention (authentication failures, drift in --audit mode, and sinks that fail --sink_unhealthy_after times in a row) to this sentry-compatible dsn"`
+	Environment string        `long:"sentry_environment" env:"SENTRY_ENVIRONMENT" description:"the environment that reported errors are tagged with, like production"`
+	Timeout     time.Duration `long:"sentry_timeout" env:"SENTRY_TIMEOUT" description:"how long sending each error may take" default:"10s"`
+}
+
+// Event is an error to report.
+type Event struct {
+	Message string            // What went wrong, like "dns authentication failed"; events are grouped by it.
+	Err     error             // The error, if any.
+	Tags    map[string]string // Context, like the store, sink, record, and zone.
+}
+
+// Reporter sends Events to an error tracker in the background.
+type Reporter struct {
+	Config Config
+	Logger *zap.Logger
+
+	store  string // The URL of the project's store endpoint.
+	auth   string // The X-Sentry-Auth header.
+	http   *http.Client
+	events chan *event
+	now    func() time.Time
+}
+
+// event is an Event in the form that the store endpoint accepts.
+type event struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	Message     string            `json:"message"`
+	Environment string            `json:"environment,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Fingerprint []string          `json:"fingerprint"`
+	Exception   *exceptions       `json:"exception,omitempty"`
+}
+
+type exceptions struct {
+	Values []exception `json:"values"`
+}
+
+type exception struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// New returns a Reporter for the DSN in cfg, like https://<key>@o0.ingest.sentry.io/<project>.
+func New(cfg Config) (*Reporter, error) {
+	u, err := url.Parse(cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("parse dsn: %w", err)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, errors.New("dsn has no public key")
+	}
+	project := path.Base(u.Path)
+	if project == "" || project == "/" || project == "." {
+		return nil, errors.New("dsn has no project id")
+	}
+	auth := "Sentry sentry_version=7, sentry_client=nodedns/1, sentry_key=" + u.User.Username()
+	if secret, ok := u.User.Password(); ok && secret != "" {
+		auth += ", sentry_secret=" + secret
+	}
+	store := url.URL{
+		Scheme: u.Scheme,
+		Host:   u.Host,
+		Path:   strings.TrimSuffix(path.Dir(u.Path), "/") + "/api/" + project + "/store/",
+	}
+	return &Reporter{
+		Config: cfg,
+		Logger: zap.L().Named("sentry"),
+		store:  store.String(),
+		auth:   auth,
+		http:   &http.Client{Transport: tracing.WrapRoundTripper(nil)},
+		events: make(chan *event, 100),
+		now:    time.Now,
+	}, nil
+}
+
+// Report queues an event to be sent.  It never blocks; if too many events are waiting, the event is
+// dropped.  The change's correlation ID, if ctx has one, is attached.
+func (r *Reporter) Report(ctx context.Context, e Event) {
+	id := make([]byte, 16)
+	rand.Read(id) // nolint:errcheck
+	tags := make(map[string]string, len(e.Tags)+1)
+	var keys []string
+	for k, v := range e.Tags {
+		tags[k] = v
+		keys = append(keys, k)
+	}
+	if cid := correlation.ID(ctx); cid != "" {
+		tags["correlation_id"] = cid
+	}
+	// Events with the same message and context are grouped together, regardless of the error's
+	// text, which often contains request IDs.
+	sort.Strings(keys)
+	fingerprint := []string{e.Message}
+	for _, k := range keys {
+		fingerprint = append(fingerprint, k+"="+e.Tags[k])
+	}
+	ev := &event{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   r.now().UTC().Format(time.RFC3339),
+		Level:       "error",
+		Platform:    "go",
+		Logger:      "nodedns",
+		Message:     e.Message,
+		Environment: r.Config.Environment,
+		Tags:        tags,
+		Fingerprint: fingerprint,
+	}
+	if e.Err != nil {
+		ev.Exception = &exceptions{Values: []exception{{Type: e.Message, Value: e.Err.Error()}}}
+	}
+	select {
+	case r.events <- ev:
+	default:
+		eventsSent.WithLabelValues("dropped").Inc()
+		r.Logger.Warn("too many errors queued; not reporting one", zap.String("message", e.Message))
+	}
+}
+
+// Run sends queued events until the context is done.
+func (r *Reporter) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-r.events:
+			if err := r.send(ctx, ev); err != nil {
+				eventsSent.WithLabelValues("error").Inc()
+				r.Logger.Warn("problem reporting error", zap.String("message", ev.Message), zap.Error(err))
+				continue
+			}
+			eventsSent.WithLabelValues("ok").Inc()
+		}
+	}
+}
+
+// send posts an event to the store endpoint.
+func (r *Reporter) send(ctx context.Context, ev *event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("marshal event: %w", err)
+	}
+	if r.Config.Timeout > 0 {
+		var c context.CancelFunc
+		ctx, c = context.WithTimeout(ctx, r.Config.Timeout)
+		defer c()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.store, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", r.auth)
+	res, err := r.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("post event: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("post event: unexpected status %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
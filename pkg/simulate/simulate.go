@@ -0,0 +1,199 @@
+// Package simulate measures how NodeStore and the DNS diff logic perform under node churn, by
+// synthesizing nodes and feeding them through a NodeStore to a fake provider.
+package simulate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/jrockway/nodedns/pkg/dns"
+	"github.com/jrockway/nodedns/pkg/fakedo"
+	"github.com/jrockway/nodedns/pkg/k8s"
+	"go.uber.org/zap"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Config configures a simulation.
+type Config struct {
+	Nodes      int     `long:"nodes" description:"how many nodes the cluster has" default:"100"`
+	Steps      int     `long:"steps" description:"how many rounds of churn to simulate" default:"100"`
+	Churn      float64 `long:"churn" description:"the fraction of nodes replaced (deleted, then re-added with a new address) each step" default:"0.05"`
+	UpdateRate float64 `long:"update_rate" description:"the fraction of nodes that receive an update that doesn't change their addresses each step, like a kubelet heartbeat" default:"0.5"`
+	Provider   string  `long:"provider" description:"where record changes go; nowhere, or a fake DigitalOcean api" choice:"none" choice:"fakedo" default:"fakedo"`
+	Seed       int64   `long:"seed" description:"the random seed; runs with the same seed make the same changes" default:"1"`
+}
+
+// Result is the outcome of a simulation.
+type Result struct {
+	Events        int           // The number of NodeStore events (adds, updates, deletes).
+	Notifications int           // The number of record changes sent to the provider.
+	Duration      time.Duration // The total time spent handling events.
+	Latencies     []time.Duration
+	Mallocs       uint64 // Heap allocations while handling events.
+	Bytes         uint64 // Bytes allocated while handling events.
+}
+
+// Percentile returns the p-th percentile (0-100) of event latency.
+func (r *Result) Percentile(p float64) time.Duration {
+	if len(r.Latencies) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration{}, r.Latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(p / 100 * float64(len(sorted)-1))
+	return sorted[i]
+}
+
+// Report writes a human-readable summary of the result.
+func (r *Result) Report(w io.Writer) error {
+	events := r.Events
+	if events == 0 {
+		events = 1
+	}
+	_, err := fmt.Fprintf(w, `events:          %d
+notifications:   %d
+total time:      %v
+latency p50:     %v
+latency p90:     %v
+latency p99:     %v
+latency max:     %v
+allocs/event:    %d
+bytes/event:     %d
+`, r.Events, r.Notifications, r.Duration, r.Percentile(50), r.Percentile(90), r.Percentile(99), r.Percentile(100), r.Mallocs/uint64(events), r.Bytes/uint64(events))
+	return err
+}
+
+// Validate returns an error if the configuration is invalid.
+func (c *Config) Validate() error {
+	if c.Nodes <= 0 || c.Nodes > 1<<16 {
+		return fmt.Errorf("nodes %d: must be between 1 and 65536", c.Nodes)
+	}
+	if c.Steps < 0 {
+		return fmt.Errorf("steps %d: must not be negative", c.Steps)
+	}
+	if c.Churn < 0 || c.Churn > 1 || c.UpdateRate < 0 || c.UpdateRate > 1 {
+		return fmt.Errorf("churn and update rate must be between 0 and 1")
+	}
+	return nil
+}
+
+type cluster struct {
+	rand  *rand.Rand
+	next  int               // The next address to hand out.
+	nodes map[string]string // Node name -> external address.
+}
+
+func (c *cluster) address() string {
+	c.next++
+	return fmt.Sprintf("42.%d.%d.%d", (c.next>>16)&0xff, (c.next>>8)&0xff, c.next&0xff)
+}
+
+func (c *cluster) node(name string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{{Type: v1.NodeExternalIP, Address: c.nodes[name]}},
+			Conditions: []v1.NodeCondition{
+				{Type: v1.NodeReady, Status: v1.ConditionTrue, LastHeartbeatTime: metav1.Now()},
+			},
+		},
+	}
+}
+
+// Run runs a simulation.
+func Run(ctx context.Context, cfg Config) (*Result, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	result := new(Result)
+	ns := k8s.NewNodeStore("simulate")
+	ns.Logger = zap.NewNop()
+	var onChangeErr error
+	switch cfg.Provider {
+	case "none":
+		ns.OnChange = func(req k8s.UpdateRequest) error {
+			result.Notifications++
+			return nil
+		}
+	case "fakedo":
+		s := fakedo.New("example.com")
+		defer s.Close()
+		client, err := dns.NewClientFromGodo(ctx, s.Client(), "example.com", time.Minute)
+		if err != nil {
+			return nil, fmt.Errorf("new dns client: %w", err)
+		}
+		ns.OnChange = func(req k8s.UpdateRequest) error {
+			result.Notifications++
+			err := client.UpdateDNS(req.Ctx, string(req.Record.Kind), req.Record.IPs)
+			if err != nil && onChangeErr == nil {
+				onChangeErr = err
+			}
+			return err
+		}
+	default:
+		return nil, fmt.Errorf("unknown provider %q", cfg.Provider)
+	}
+
+	c := &cluster{rand: rand.New(rand.NewSource(cfg.Seed)), nodes: make(map[string]string)} // nolint:gosec
+	var initial []interface{}
+	for i := 0; i < cfg.Nodes; i++ {
+		name := fmt.Sprintf("node-%d", i)
+		c.nodes[name] = c.address()
+		initial = append(initial, c.node(name))
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	observe := func(f func() error) error {
+		t := time.Now()
+		err := f()
+		result.Latencies = append(result.Latencies, time.Since(t))
+		result.Events++
+		return err
+	}
+	if err := observe(func() error { return ns.Replace(initial, "") }); err != nil {
+		return nil, fmt.Errorf("initial replace: %w", err)
+	}
+	names := make([]string, 0, cfg.Nodes)
+	for name := range c.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for step := 0; step < cfg.Steps; step++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			switch r := c.rand.Float64(); {
+			case r < cfg.Churn:
+				old := c.node(name)
+				if err := observe(func() error { return ns.Delete(old) }); err != nil {
+					return nil, fmt.Errorf("delete %s: %w", name, err)
+				}
+				c.nodes[name] = c.address()
+				if err := observe(func() error { return ns.Add(c.node(name)) }); err != nil {
+					return nil, fmt.Errorf("add %s: %w", name, err)
+				}
+			case r < cfg.Churn+cfg.UpdateRate:
+				if err := observe(func() error { return ns.Update(c.node(name)) }); err != nil {
+					return nil, fmt.Errorf("update %s: %w", name, err)
+				}
+			}
+		}
+	}
+	result.Duration = time.Since(start)
+	runtime.ReadMemStats(&after)
+	result.Mallocs = after.Mallocs - before.Mallocs
+	result.Bytes = after.TotalAlloc - before.TotalAlloc
+	if onChangeErr != nil {
+		return result, fmt.Errorf("update fake provider: %w", onChangeErr)
+	}
+	return result, nil
+}
@@ -0,0 +1,54 @@
+package simulate
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	cfg := Config{Nodes: 10, Steps: 5, Churn: 0.2, UpdateRate: 0.5, Provider: "fakedo", Seed: 1}
+	r, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if r.Events < 1 {
+		t.Errorf("expected events, got %d", r.Events)
+	}
+	if r.Notifications < 1 {
+		t.Errorf("expected notifications, got %d", r.Notifications)
+	}
+	if got, want := len(r.Latencies), r.Events; got != want {
+		t.Errorf("latencies:\n  got: %v\n want: %v", got, want)
+	}
+	buf := new(bytes.Buffer)
+	if err := r.Report(buf); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	t.Logf("report:\n%s", buf.String())
+
+	again, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if got, want := again.Notifications, r.Notifications; got != want {
+		t.Errorf("same seed, different notifications:\n  got: %v\n want: %v", got, want)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if _, err := Run(context.Background(), Config{Nodes: 0, Provider: "none"}); err == nil {
+		t.Error("expected error for zero nodes")
+	}
+	if _, err := Run(context.Background(), Config{Nodes: 1, Churn: 2, Provider: "none"}); err == nil {
+		t.Error("expected error for churn > 1")
+	}
+}
+
+func BenchmarkNodeStore(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := Run(context.Background(), Config{Nodes: 100, Steps: 10, Churn: 0.05, UpdateRate: 0.5, Provider: "none", Seed: 1}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
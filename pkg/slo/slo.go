@@ -0,0 +1,206 @@
+// Package slo measures how quickly node changes reach DNS, as an SLI that an SLO can be built on.
+//
+// A record is "stale" from the moment its desired addresses change until an update containing
+// them succeeds.  Time that a record spends stale for longer than the freshness threshold is bad;
+// all other time is good.  The SLI is the fraction of good record-seconds over a rolling window,
+// and the error budget is how much bad time the target allows in that window.
+package slo
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const numBuckets = 1000
+
+type bucket struct {
+	index int64 // Which interval of the window this bucket holds.
+	total float64
+	bad   float64
+}
+
+type record struct {
+	staleSince time.Time // Zero if the record is fresh.
+}
+
+// Tracker tracks record freshness.  It is a prometheus.Collector.
+type Tracker struct {
+	Threshold time.Duration // How long a record may be stale before it counts against the SLO.
+	Target    float64       // The fraction of time that records must be fresh, like 0.999.
+	Window    time.Duration // The window over which the SLI and error budget are computed.
+
+	now func() time.Time
+
+	mu        sync.Mutex
+	records   map[string]*record
+	last      time.Time
+	buckets   [numBuckets]bucket
+	totalSum  float64 // All time, for the counters.
+	badSum    float64
+	sli       *prometheus.Desc
+	budget    *prometheus.Desc
+	stale     *prometheus.Desc
+	totalDesc *prometheus.Desc
+	badDesc   *prometheus.Desc
+}
+
+// New returns a Tracker.
+func New(threshold time.Duration, target float64, window time.Duration) *Tracker {
+	t := &Tracker{
+		Threshold: threshold,
+		Target:    target,
+		Window:    window,
+		now:       time.Now,
+		records:   make(map[string]*record),
+		sli:       prometheus.NewDesc("sync_freshness_sli", "The fraction of record-time in the SLO window that records were fresh.", nil, nil),
+		budget:    prometheus.NewDesc("sync_freshness_error_budget_remaining", "The fraction of the SLO window's error budget that remains; negative when the SLO is violated.", nil, nil),
+		stale:     prometheus.NewDesc("sync_freshness_stale_records", "The number of records that have been stale for longer than the freshness threshold.", nil, nil),
+		totalDesc: prometheus.NewDesc("sync_freshness_record_seconds_total", "Total record-seconds observed.", nil, nil),
+		badDesc:   prometheus.NewDesc("sync_freshness_stale_record_seconds_total", "Total record-seconds that records spent stale for longer than the freshness threshold.", nil, nil),
+	}
+	t.last = t.now()
+	return t
+}
+
+// Changed notes that the record's desired addresses may have changed.  It stays stale until
+// Synced is called.
+func (t *Tracker) Changed(name string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tick()
+	r, ok := t.records[name]
+	if !ok {
+		r = new(record)
+		t.records[name] = r
+	}
+	if r.staleSince.IsZero() {
+		r.staleSince = t.now()
+	}
+}
+
+// Synced notes that the record's most recent desired addresses have been published.
+func (t *Tracker) Synced(name string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tick()
+	if r, ok := t.records[name]; ok {
+		r.staleSince = time.Time{}
+	}
+}
+
+func (t *Tracker) bucketWidth() int64 {
+	w := int64(t.Window) / numBuckets
+	if w < 1 {
+		w = 1
+	}
+	return w
+}
+
+// tick accounts for the time since the last tick.  The caller must hold the lock.
+func (t *Tracker) tick() {
+	now := t.now()
+	if !now.After(t.last) {
+		return
+	}
+	var total, bad float64
+	for _, r := range t.records {
+		total += now.Sub(t.last).Seconds()
+		if r.staleSince.IsZero() {
+			continue
+		}
+		// Bad time starts once the record has been stale for the threshold.
+		badStart := r.staleSince.Add(t.Threshold)
+		if badStart.Before(t.last) {
+			badStart = t.last
+		}
+		if now.After(badStart) {
+			bad += now.Sub(badStart).Seconds()
+		}
+	}
+	t.last = now
+	t.totalSum += total
+	t.badSum += bad
+
+	index := now.UnixNano() / t.bucketWidth()
+	b := &t.buckets[index%numBuckets]
+	if b.index != index {
+		*b = bucket{index: index}
+	}
+	b.total += total
+	b.bad += bad
+}
+
+// window returns the total and bad record-seconds in the window.  The caller must hold the lock.
+func (t *Tracker) window() (total, bad float64) {
+	current := t.now().UnixNano() / t.bucketWidth()
+	for _, b := range t.buckets {
+		if b.index > current-numBuckets && b.index <= current {
+			total += b.total
+			bad += b.bad
+		}
+	}
+	return total, bad
+}
+
+// SLI returns the fraction of good record-time in the window, and the fraction of the error
+// budget remaining.  With no data, both are 1.
+func (t *Tracker) SLI() (sli, budgetRemaining float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tick()
+	return t.compute()
+}
+
+// compute returns the SLI and error budget remaining.  The caller must hold the lock.
+func (t *Tracker) compute() (float64, float64) {
+	total, bad := t.window()
+	if total == 0 {
+		return 1, 1
+	}
+	sli := 1 - bad/total
+	allowed := (1 - t.Target) * total
+	if allowed <= 0 {
+		if bad > 0 {
+			return sli, -1
+		}
+		return sli, 1
+	}
+	return sli, 1 - bad/allowed
+}
+
+// Describe implements prometheus.Collector.
+func (t *Tracker) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{t.sli, t.budget, t.stale, t.totalDesc, t.badDesc} {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (t *Tracker) Collect(ch chan<- prometheus.Metric) {
+	t.mu.Lock()
+	t.tick()
+	sli, budget := t.compute()
+	var stale int
+	now := t.now()
+	for _, r := range t.records {
+		if !r.staleSince.IsZero() && now.Sub(r.staleSince) > t.Threshold {
+			stale++
+		}
+	}
+	total, bad := t.totalSum, t.badSum
+	t.mu.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(t.sli, prometheus.GaugeValue, sli)
+	ch <- prometheus.MustNewConstMetric(t.budget, prometheus.GaugeValue, budget)
+	ch <- prometheus.MustNewConstMetric(t.stale, prometheus.GaugeValue, float64(stale))
+	ch <- prometheus.MustNewConstMetric(t.totalDesc, prometheus.CounterValue, total)
+	ch <- prometheus.MustNewConstMetric(t.badDesc, prometheus.CounterValue, bad)
+}
@@ -0,0 +1,54 @@
+package slo
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTracker(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	tr := New(time.Minute, 0.9, time.Hour)
+	tr.now = func() time.Time { return now }
+	tr.last = now
+	check := func(step string, wantSLI, wantBudget float64) {
+		t.Helper()
+		sli, budget := tr.SLI()
+		if math.Abs(sli-wantSLI) > 1e-9 {
+			t.Errorf("%s: sli:\n  got: %v\n want: %v", step, sli, wantSLI)
+		}
+		if math.Abs(budget-wantBudget) > 1e-9 {
+			t.Errorf("%s: budget:\n  got: %v\n want: %v", step, budget, wantBudget)
+		}
+	}
+	check("no data", 1, 1)
+
+	// A change that syncs within the threshold is all good time.
+	tr.Changed("a")
+	now = now.Add(30 * time.Second)
+	tr.Synced("a")
+	now = now.Add(30 * time.Second)
+	check("fast sync", 1, 1)
+
+	// A change that takes 3 minutes to sync has 2 bad minutes.
+	tr.Changed("a")
+	now = now.Add(time.Minute)
+	tr.Changed("a") // A second change doesn't restart the clock.
+	now = now.Add(time.Minute)
+	want := `
+# HELP sync_freshness_stale_records The number of records that have been stale for longer than the freshness threshold.
+# TYPE sync_freshness_stale_records gauge
+sync_freshness_stale_records 1
+`
+	if err := testutil.CollectAndCompare(tr, strings.NewReader(want), "sync_freshness_stale_records"); err != nil {
+		t.Errorf("stale records: %v", err)
+	}
+	now = now.Add(time.Minute)
+	tr.Synced("a")
+	now = now.Add(6 * time.Minute)
+	// 10 minutes observed, 2 bad; 10% allowed is 1 minute, so the budget is overspent.
+	check("slow sync", 0.8, -1)
+}
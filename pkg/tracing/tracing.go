@@ -0,0 +1,186 @@
+// Package tracing sets up OpenTelemetry, configures how the traces of reconciles are sampled, and
+// links metrics to them with exemplars, so that a slow reconcile on a dashboard leads straight to
+// its trace.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"github.com/jrockway/opinionated-server/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/jaeger"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Config configures the tracer and the sampling of reconcile traces.  The exporters are configured
+// with the usual OTEL_EXPORTER_* environment variables, and the service name with
+// OTEL_SERVICE_NAME.
+type Config struct {
+	Exporter            string  `long:"trace_exporter" env:"TRACE_EXPORTER" description:"where to send traces: a jaeger agent (see OTEL_EXPORTER_JAEGER_AGENT_HOST and OTEL_EXPORTER_JAEGER_AGENT_PORT), an otlp collector over grpc (see OTEL_EXPORTER_OTLP_ENDPOINT), or nowhere" choice:"jaeger" choice:"otlp" choice:"none" default:"jaeger"`
+	SampleRate          float64 `long:"trace_sample_rate" env:"TRACE_SAMPLE_RATE" description:"the fraction of traces that are sampled when they start; the rest of a trace follows its root" default:"1"`
+	ReconcileSampleRate float64 `long:"trace_reconcile_sample_rate" env:"TRACE_RECONCILE_SAMPLE_RATE" description:"the fraction of node events, resyncs, and retries whose traces are sampled, overriding --trace_sample_rate for them; negative leaves them to --trace_sample_rate" default:"-1"`
+}
+
+// Validate returns an error if the configuration is invalid.
+func (c *Config) Validate() error {
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		return errors.New("trace_sample_rate must be between 0 and 1")
+	}
+	if c.ReconcileSampleRate > 1 {
+		return errors.New("trace_reconcile_sample_rate must be at most 1")
+	}
+	return nil
+}
+
+// Setup installs a global tracer provider that sends traces to the configured exporter, and the
+// W3C trace context propagator.  The returned function sends the traces that haven't been sent
+// yet, and stops the provider.
+func (c *Config) Setup(ctx context.Context, service string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch c.Exporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "jaeger":
+		exporter, err = jaeger.New(jaeger.WithAgentEndpoint())
+	case "otlp":
+		exporter, err = otlptracegrpc.New(ctx)
+	default:
+		return nil, fmt.Errorf("unknown exporter %q", c.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s exporter: %w", c.Exporter, err)
+	}
+	// Attributes from the environment, like OTEL_SERVICE_NAME, take precedence.
+	res, err := resource.New(ctx, resource.WithAttributes(semconv.ServiceNameKey.String(service)), resource.WithFromEnv(), resource.WithHost())
+	if err != nil {
+		return nil, fmt.Errorf("resource: %w", err)
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(reconcileSampler{Sampler: sdktrace.TraceIDRatioBased(c.SampleRate)})),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// Sampler returns a function that decides whether a reconcile is traced, or nil if
+// --trace_sample_rate decides.
+func (c *Config) Sampler() func() bool {
+	if c.ReconcileSampleRate < 0 {
+		return nil
+	}
+	rate := c.ReconcileSampleRate
+	return func() bool { return rand.Float64() < rate }
+}
+
+type sampledKey struct{}
+
+// WithSampled returns a context that makes a trace started with it sampled, or not, regardless of
+// the sample rate.
+func WithSampled(ctx context.Context, sampled bool) context.Context {
+	return context.WithValue(ctx, sampledKey{}, sampled)
+}
+
+// reconcileSampler is a Sampler that obeys WithSampled, and otherwise defers to another Sampler.
+type reconcileSampler struct {
+	sdktrace.Sampler
+}
+
+// ShouldSample implements sdktrace.Sampler.
+func (s reconcileSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	sampled, ok := p.ParentContext.Value(sampledKey{}).(bool)
+	if !ok {
+		return s.Sampler.ShouldSample(p)
+	}
+	result := sdktrace.SamplingResult{Decision: sdktrace.Drop, Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState()}
+	if sampled {
+		result.Decision = sdktrace.RecordAndSample
+	}
+	return result
+}
+
+// Description implements sdktrace.Sampler.
+func (s reconcileSampler) Description() string {
+	return "Reconcile{" + s.Sampler.Description() + "}"
+}
+
+// Start starts a span that's a child of the span in the context, if any.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer("github.com/jrockway/nodedns").Start(ctx, name, opts...)
+}
+
+// Fail marks the span as failed with err, if err is non-nil.
+func Fail(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// WrapRoundTripper returns a RoundTripper that traces each request as a child of the span in its
+// context, and records the X-Request-Id header of the response (which DigitalOcean sets, among
+// others) as the span's request_id attribute.  rt is wrapped with the opinionated-server's client
+// logging and metrics first.
+func WrapRoundTripper(rt http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(&requestIDTransport{underlying: client.WrapRoundTripper(rt)})
+}
+
+// requestIDTransport records the request ID of responses on the current span.
+type requestIDTransport struct {
+	underlying http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.underlying.RoundTrip(req)
+	if res != nil {
+		if id := res.Header.Get("X-Request-Id"); id != "" {
+			trace.SpanFromContext(req.Context()).SetAttributes(attribute.String("request_id", id))
+		}
+	}
+	return res, err
+}
+
+// Exemplar returns the labels of an exemplar that links an observation to the trace of the
+// context, if it was sampled, or else to its correlation ID, if any.  Exemplars are limited to 64
+// runes, which a 128-bit trace ID and a correlation ID together exceed; the root span of a
+// reconcile carries its correlation ID anyway.
+func Exemplar(ctx context.Context) prometheus.Labels {
+	labels := make(prometheus.Labels)
+	if sc := trace.SpanContextFromContext(ctx); sc.IsSampled() {
+		labels["trace_id"] = sc.TraceID().String()
+	} else if id := correlation.ID(ctx); id != "" {
+		labels["correlation_id"] = id
+	}
+	return labels
+}
+
+// Observe adds an observation to o, with an exemplar from the context if it has one and o
+// supports exemplars.
+func Observe(ctx context.Context, o prometheus.Observer, value float64) {
+	if eo, ok := o.(prometheus.ExemplarObserver); ok {
+		if labels := Exemplar(ctx); len(labels) > 0 {
+			eo.ObserveWithExemplar(value, labels)
+			return
+		}
+	}
+	o.Observe(value)
+}
@@ -0,0 +1,102 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jrockway/nodedns/pkg/correlation"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestSampler(t *testing.T) {
+	if s := (&Config{ReconcileSampleRate: -1}).Sampler(); s != nil {
+		t.Error("negative rate: got a sampler, want the tracer's")
+	}
+	never, always := (&Config{ReconcileSampleRate: 0}).Sampler(), (&Config{ReconcileSampleRate: 1}).Sampler()
+	for i := 0; i < 100; i++ {
+		if never() {
+			t.Fatal("rate 0 sampled a trace")
+		}
+		if !always() {
+			t.Fatal("rate 1 didn't sample a trace")
+		}
+	}
+	if err := (&Config{ReconcileSampleRate: 2}).Validate(); err == nil {
+		t.Error("rate 2 validated")
+	}
+}
+
+func TestWithSampled(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(sr),
+		sdktrace.WithSampler(sdktrace.ParentBased(reconcileSampler{Sampler: sdktrace.TraceIDRatioBased(0.5)})),
+	)
+	tracer := tp.Tracer("test")
+	for i := 0; i < 100; i++ {
+		ctx, span := tracer.Start(WithSampled(context.Background(), false), "dropped")
+		_, child := tracer.Start(ctx, "dropped-child")
+		child.End()
+		span.End()
+
+		ctx, span = tracer.Start(WithSampled(context.Background(), true), "sampled")
+		_, child = tracer.Start(WithSampled(ctx, false), "sampled-child")
+		child.End()
+		span.End()
+	}
+	got := make(map[string]int)
+	for _, span := range sr.Ended() {
+		got[span.Name()]++
+	}
+	want := map[string]int{"sampled": 100, "sampled-child": 100}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("ended spans:\n%s", diff)
+	}
+}
+
+func TestExemplar(t *testing.T) {
+	testData := []struct {
+		name    string
+		sampled bool
+		id      string
+		want    map[string]bool // Which labels are present.
+	}{
+		{name: "sampled", sampled: true, id: "0123456789abcdef", want: map[string]bool{"trace_id": true}},
+		{name: "unsampled", sampled: false, id: "0123456789abcdef", want: map[string]bool{"correlation_id": true}},
+		{name: "nothing", sampled: false, want: map[string]bool{}},
+	}
+	for _, test := range testData {
+		t.Run(test.name, func(t *testing.T) {
+			sampler := sdktrace.NeverSample()
+			if test.sampled {
+				sampler = sdktrace.AlwaysSample()
+			}
+			tp := sdktrace.NewTracerProvider(sdktrace.WithSampler(sampler))
+			ctx, span := tp.Tracer("test").Start(context.Background(), "test")
+			defer span.End()
+			if test.id != "" {
+				ctx = correlation.With(ctx, test.id)
+			}
+
+			h := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test", Buckets: []float64{1}})
+			Observe(ctx, h, 0.5)
+			m := new(dto.Metric)
+			if err := h.Write(m); err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]bool)
+			if e := m.GetHistogram().GetBucket()[0].GetExemplar(); e != nil {
+				for _, l := range e.GetLabel() {
+					got[l.GetName()] = true
+				}
+			}
+			if diff := cmp.Diff(got, test.want); diff != "" {
+				t.Errorf("exemplar labels:\n%s", diff)
+			}
+		})
+	}
+}
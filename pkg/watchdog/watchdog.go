@@ -0,0 +1,400 @@
+// Package watchdog notices when the addresses that DNS actually serves have diverged from the
+// addresses that nodedns wants to publish, and raises an alert.
+package watchdog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var (
+	diverged = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_diverged",
+			Help: "1 if a record's live answers have differed from the desired addresses for longer than the watchdog threshold, by record and cause.",
+		},
+		[]string{"record", "cause"},
+	)
+	drift = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_drift",
+			Help: "How many addresses a record's live answers have that it shouldn't, plus how many they're missing, at the last watchdog check.",
+		},
+		[]string{"record"},
+	)
+	divergenceAlerts = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_divergence_alerts",
+			Help: "The number of divergence alerts raised, by record and cause.",
+		},
+		[]string{"record", "cause"},
+	)
+)
+
+// Cause explains why a record diverged.
+type Cause string
+
+const (
+	// UpdateFailed means that the most recent attempt to update the record failed.
+	UpdateFailed Cause = "update_failed"
+	// Overwritten means that the most recent update succeeded, but the record no longer
+	// contains what was written; something else changed it.
+	Overwritten Cause = "overwritten"
+)
+
+var causes = []Cause{UpdateFailed, Overwritten}
+
+// Resolver looks up addresses, like *net.Resolver.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// Authoritative is the address that makes NewResolver query one of the authoritative
+// nameservers of each name's zone, so that answers aren't cached.
+const Authoritative = "authoritative"
+
+// NewResolver returns a Resolver that queries the DNS server at addr (host:port), the zone's
+// authoritative nameservers if addr is Authoritative, or the system resolver if addr is empty.
+func NewResolver(addr string) Resolver {
+	switch addr {
+	case "":
+		return net.DefaultResolver
+	case Authoritative:
+		return &authoritativeResolver{lookupNS: net.DefaultResolver.LookupNS, servers: make(map[string]string)}
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// authoritativeResolver resolves names with one of the authoritative nameservers of their zone.
+type authoritativeResolver struct {
+	lookupNS func(ctx context.Context, name string) ([]*net.NS, error)
+
+	mu      sync.Mutex
+	servers map[string]string // The nameserver (host:port) for each name that's been resolved.
+}
+
+// server returns a nameserver for the zone that host is in, which is found by looking up the NS
+// records of host and then of each of its parents, until some exist.
+func (r *authoritativeResolver) server(ctx context.Context, host string) (string, error) {
+	host = strings.TrimSuffix(host, ".")
+	r.mu.Lock()
+	s, ok := r.servers[host]
+	r.mu.Unlock()
+	if ok {
+		return s, nil
+	}
+	for name := host; name != ""; {
+		if nss, err := r.lookupNS(ctx, name); err == nil && len(nss) > 0 {
+			s = net.JoinHostPort(strings.TrimSuffix(nss[0].Host, "."), "53")
+			r.mu.Lock()
+			r.servers[host] = s
+			r.mu.Unlock()
+			return s, nil
+		}
+		i := strings.Index(name, ".")
+		if i < 0 {
+			break
+		}
+		name = name[i+1:]
+	}
+	return "", fmt.Errorf("no nameservers found for %s", host)
+}
+
+// LookupIPAddr implements Resolver.
+func (r *authoritativeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	s, err := r.server(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	return NewResolver(s).LookupIPAddr(ctx, host)
+}
+
+// Alert is the body of webhook requests.  An alert is sent when a record starts diverging, and
+// again (with Resolved set) when it stops.
+type Alert struct {
+	Record   string    `json:"record"`
+	Cause    Cause     `json:"cause"`
+	Resolved bool      `json:"resolved"`
+	Since    time.Time `json:"since"`
+	Desired  []string  `json:"desired"`
+	Actual   []string  `json:"actual"`
+	Error    string    `json:"error,omitempty"` // The error from the failed update, if any.
+}
+
+type state struct {
+	desired       []string
+	updateErr     error
+	divergedSince time.Time
+	firing        *Alert
+}
+
+// Watchdog periodically resolves records and compares them to the desired addresses.
+type Watchdog struct {
+	Resolver  Resolver
+	Threshold time.Duration // How long a record may diverge before an alert is raised.
+	Webhook   string        // If non-empty, alerts are POSTed here as JSON.
+	Client    *http.Client
+	Logger    *zap.Logger
+
+	now     func() time.Time
+	mu      sync.Mutex
+	records map[string]*state
+}
+
+// New returns a Watchdog.
+func New(resolver Resolver, threshold time.Duration, webhook string) *Watchdog {
+	return &Watchdog{
+		Resolver:  resolver,
+		Threshold: threshold,
+		Webhook:   webhook,
+		Client:    http.DefaultClient,
+		Logger:    zap.L().Named("watchdog"),
+		now:       time.Now,
+		records:   make(map[string]*state),
+	}
+}
+
+func normalize(ips []net.IP) []string {
+	result := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		result = append(result, ip.String())
+	}
+	sort.Strings(result)
+	return result
+}
+
+// difference returns how many of the strings in the sorted slices are only in one of them.
+func difference(a, b []string) int {
+	var n, i, j int
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			i++
+			j++
+		case a[i] < b[j]:
+			n++
+			i++
+		default:
+			n++
+			j++
+		}
+	}
+	return n + len(a) - i + len(b) - j
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Desired records the result of an attempt to set the record (a fully-qualified name) to ips.
+// updateErr is the error from that attempt, or nil if it succeeded.
+func (w *Watchdog) Desired(record string, ips []net.IP, updateErr error) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s, ok := w.records[record]
+	if !ok {
+		s = new(state)
+		w.records[record] = s
+	}
+	s.desired = normalize(ips)
+	s.updateErr = updateErr
+}
+
+// names returns the name of every record with desired addresses, in order.
+func (w *Watchdog) names() []string {
+	w.mu.Lock()
+	names := make([]string, 0, len(w.records))
+	for name := range w.records {
+		names = append(names, name)
+	}
+	w.mu.Unlock()
+	sort.Strings(names)
+	return names
+}
+
+// lookup returns the record's live addresses, sorted.  A record that doesn't exist has no
+// addresses.
+func (w *Watchdog) lookup(ctx context.Context, name string) ([]string, error) {
+	addrs, err := w.Resolver.LookupIPAddr(ctx, name)
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+			return nil, err
+		}
+		// A record with no addresses doesn't exist at all.
+	}
+	actual := []string{}
+	for _, a := range addrs {
+		actual = append(actual, a.IP.String())
+	}
+	sort.Strings(actual)
+	return actual, nil
+}
+
+// Check resolves every record once, and raises or resolves alerts as necessary.
+func (w *Watchdog) Check(ctx context.Context) {
+	for _, name := range w.names() {
+		actual, err := w.lookup(ctx, name)
+		if err != nil {
+			w.Logger.Warn("problem resolving record", zap.String("record", name), zap.Error(err))
+			continue
+		}
+		w.observe(ctx, name, actual)
+	}
+}
+
+// LogAnswers resolves every record once, and logs the live answers next to the desired
+// addresses.
+func (w *Watchdog) LogAnswers(ctx context.Context) {
+	for _, name := range w.names() {
+		actual, err := w.lookup(ctx, name)
+		w.mu.Lock()
+		desired := w.records[name].desired
+		w.mu.Unlock()
+		l := w.Logger.With(zap.String("record", name), zap.Strings("desired", desired))
+		if err != nil {
+			l.Warn("problem resolving record", zap.Error(err))
+			continue
+		}
+		l.Info("live dns answers", zap.Strings("actual", actual), zap.Bool("in_sync", equal(desired, actual)))
+	}
+}
+
+func (w *Watchdog) observe(ctx context.Context, name string, actual []string) {
+	w.mu.Lock()
+	s := w.records[name]
+	now := w.now()
+	var send *Alert
+	drift.WithLabelValues(name).Set(float64(difference(s.desired, actual)))
+	if equal(s.desired, actual) {
+		s.divergedSince = time.Time{}
+		if s.firing != nil {
+			send = s.firing
+			send.Resolved = true
+			send.Actual = actual
+			s.firing = nil
+		}
+	} else {
+		if s.divergedSince.IsZero() {
+			s.divergedSince = now
+			w.Logger.Info("record's live answers differ from the desired addresses", zap.String("record", name), zap.Strings("desired", s.desired), zap.Strings("actual", actual), zap.Error(s.updateErr))
+		}
+		cause := Overwritten
+		if s.updateErr != nil {
+			cause = UpdateFailed
+		}
+		if now.Sub(s.divergedSince) >= w.Threshold && (s.firing == nil || s.firing.Cause != cause) {
+			a := &Alert{Record: name, Cause: cause, Since: s.divergedSince, Desired: s.desired, Actual: actual}
+			if s.updateErr != nil {
+				a.Error = s.updateErr.Error()
+			}
+			s.firing = a
+			send = a
+			divergenceAlerts.WithLabelValues(name, string(cause)).Inc()
+		}
+	}
+	for _, c := range causes {
+		v := 0.0
+		if s.firing != nil && s.firing.Cause == c {
+			v = 1
+		}
+		diverged.WithLabelValues(name, string(c)).Set(v)
+	}
+	var alert Alert
+	if send != nil {
+		alert = *send
+	}
+	w.mu.Unlock()
+
+	if send == nil {
+		return
+	}
+	l := w.Logger.With(zap.String("record", name), zap.String("cause", string(alert.Cause)), zap.Strings("desired", alert.Desired), zap.Strings("actual", alert.Actual))
+	if alert.Resolved {
+		l.Info("record no longer diverged")
+	} else {
+		l.Error("record has diverged from desired state", zap.Time("since", alert.Since), zap.String("update_error", alert.Error))
+	}
+	if err := w.notify(ctx, &alert); err != nil {
+		l.Error("problem sending divergence webhook", zap.Error(err))
+	}
+}
+
+func (w *Watchdog) notify(ctx context.Context, a *Alert) error {
+	if w.Webhook == "" {
+		return nil
+	}
+	body, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("marshal alert: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.Webhook, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := w.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post webhook: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("post webhook: unexpected status %s", res.Status)
+	}
+	return nil
+}
+
+// Run checks every record at the provided interval, until the context is done.
+func (w *Watchdog) Run(ctx context.Context, interval time.Duration) {
+	every(ctx, interval, w.Check)
+}
+
+// RunLogger logs every record's live answers at the provided interval, until the context is done.
+func (w *Watchdog) RunLogger(ctx context.Context, interval time.Duration) {
+	every(ctx, interval, w.LogAnswers)
+}
+
+// every calls f at the provided interval, with a context that expires at the next tick, until
+// the context is done.
+func every(ctx context.Context, interval time.Duration, f func(ctx context.Context)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			tctx, c := context.WithTimeout(ctx, interval)
+			f(tctx)
+			c()
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package watchdog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type fakeResolver map[string][]string
+
+func (r fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	addrs, ok := r[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	var result []net.IPAddr
+	for _, a := range addrs {
+		result = append(result, net.IPAddr{IP: net.ParseIP(a)})
+	}
+	return result, nil
+}
+
+func TestWatchdog(t *testing.T) {
+	var mu sync.Mutex
+	var alerts []Alert
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var a Alert
+		if err := json.NewDecoder(req.Body).Decode(&a); err != nil {
+			t.Errorf("decode alert: %v", err)
+		}
+		mu.Lock()
+		alerts = append(alerts, a)
+		mu.Unlock()
+	}))
+	defer s.Close()
+
+	resolver := fakeResolver{"nodes.example.com": {"10.0.0.1"}}
+	w := New(resolver, time.Minute, s.URL)
+	w.Logger = zaptest.NewLogger(t)
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	w.now = func() time.Time { return now }
+	ctx := context.Background()
+	check := func(step string, want []Alert) {
+		t.Helper()
+		alerts = nil
+		w.Check(ctx)
+		if diff := cmp.Diff(alerts, want, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("%s: alerts:\n%s", step, diff)
+		}
+	}
+
+	w.Desired("nodes.example.com", []net.IP{net.IPv4(10, 0, 0, 1)}, nil)
+	check("in sync", nil)
+
+	// A successful update that hasn't propagated yet doesn't alert until the threshold.
+	w.Desired("nodes.example.com", []net.IP{net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 1)}, nil)
+	check("diverged briefly", nil)
+	if got, want := testutil.ToFloat64(drift.WithLabelValues("nodes.example.com")), 1.0; got != want {
+		t.Errorf("drift:\n  got: %v\n want: %v", got, want)
+	}
+	now = now.Add(2 * time.Minute)
+	since := now.Add(-2 * time.Minute)
+	check("overwritten", []Alert{{
+		Record:  "nodes.example.com",
+		Cause:   Overwritten,
+		Since:   since,
+		Desired: []string{"10.0.0.1", "10.0.0.2"},
+		Actual:  []string{"10.0.0.1"},
+	}})
+	check("still overwritten", nil)
+
+	// The next update fails; the cause changes.
+	w.Desired("nodes.example.com", []net.IP{net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 1)}, errors.New("boom"))
+	check("update failed", []Alert{{
+		Record:  "nodes.example.com",
+		Cause:   UpdateFailed,
+		Since:   since,
+		Desired: []string{"10.0.0.1", "10.0.0.2"},
+		Actual:  []string{"10.0.0.1"},
+		Error:   "boom",
+	}})
+
+	resolver["nodes.example.com"] = []string{"10.0.0.2", "10.0.0.1"}
+	check("resolved", []Alert{{
+		Record:   "nodes.example.com",
+		Cause:    UpdateFailed,
+		Resolved: true,
+		Since:    since,
+		Desired:  []string{"10.0.0.1", "10.0.0.2"},
+		Actual:   []string{"10.0.0.1", "10.0.0.2"},
+		Error:    "boom",
+	}})
+
+	if got, want := testutil.ToFloat64(drift.WithLabelValues("nodes.example.com")), 0.0; got != want {
+		t.Errorf("drift after resolving:\n  got: %v\n want: %v", got, want)
+	}
+
+	// A record that should be empty, and doesn't exist, is in sync.
+	w.Desired("empty.example.com", nil, nil)
+	check("empty", nil)
+}
+
+func TestLogAnswers(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	resolver := fakeResolver{"nodes.example.com": {"10.0.0.2", "10.0.0.1"}}
+	w := New(resolver, 0, "")
+	w.Logger = zap.New(core)
+	w.Desired("nodes.example.com", []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)}, nil)
+	w.Desired("empty.example.com", []net.IP{net.IPv4(10, 0, 0, 3)}, nil)
+	w.LogAnswers(context.Background())
+
+	var got []map[string]interface{}
+	for _, entry := range logs.All() {
+		got = append(got, entry.ContextMap())
+	}
+	want := []map[string]interface{}{
+		{"record": "empty.example.com", "desired": []interface{}{"10.0.0.3"}, "actual": []interface{}{}, "in_sync": false},
+		{"record": "nodes.example.com", "desired": []interface{}{"10.0.0.1", "10.0.0.2"}, "actual": []interface{}{"10.0.0.1", "10.0.0.2"}, "in_sync": true},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("logs:\n%s", diff)
+	}
+}
+
+func TestDifference(t *testing.T) {
+	testData := []struct {
+		a, b []string
+		want int
+	}{
+		{nil, nil, 0},
+		{[]string{"1"}, nil, 1},
+		{nil, []string{"1", "2"}, 2},
+		{[]string{"1", "2"}, []string{"1", "2"}, 0},
+		{[]string{"1", "3"}, []string{"2", "3", "4"}, 3},
+	}
+	for _, test := range testData {
+		if got := difference(test.a, test.b); got != test.want {
+			t.Errorf("difference(%v, %v):\n  got: %v\n want: %v", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestAuthoritativeServer(t *testing.T) {
+	var lookups []string
+	r := &authoritativeResolver{
+		lookupNS: func(ctx context.Context, name string) ([]*net.NS, error) {
+			lookups = append(lookups, name)
+			if name == "example.com" {
+				return []*net.NS{{Host: "ns1.example.net."}, {Host: "ns2.example.net."}}, nil
+			}
+			return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+		},
+		servers: make(map[string]string),
+	}
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		got, err := r.server(ctx, "nodes.k8s.example.com.")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "ns1.example.net:53"; got != want {
+			t.Errorf("server:\n  got: %v\n want: %v", got, want)
+		}
+	}
+	// The second lookup is cached.
+	if diff := cmp.Diff(lookups, []string{"nodes.k8s.example.com", "k8s.example.com", "example.com"}); diff != "" {
+		t.Errorf("lookups:\n%s", diff)
+	}
+	if _, err := r.server(ctx, "nodes.example.org"); err == nil {
+		t.Error("expected an error for a name with no nameservers")
+	}
+}